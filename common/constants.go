@@ -36,6 +36,9 @@ var ChannelQuotaCheckInterval = 2 // unit: second, 默认2秒检查一次
 var SessionSecret = uuid.New().String()
 var CryptoSecret = uuid.New().String()
 
+// CryptoSecretPrevious 轮换前使用过的 CRYPTO_SECRET，只用于解密旧数据
+var CryptoSecretPrevious []string
+
 var OptionMap map[string]string
 var OptionMapRWMutex sync.RWMutex
 
@@ -75,7 +78,10 @@ var MemoryCacheEnabled bool
 
 var LogConsumeEnabled = true
 var ConversationHistoryEnabled = false
+var ConversationHistoryDedup = false
+var ConversationHistorySearch = false
 var MESDailyPartition = true
+var MESNativePartition = false
 
 var SMTPServer = ""
 var SMTPPort = 587
@@ -7,6 +7,7 @@ import (
 	"os"
 	"path/filepath"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -52,6 +53,11 @@ func LoadEnv() {
 	} else {
 		CryptoSecret = SessionSecret
 	}
+	for _, secret := range strings.Split(os.Getenv("CRYPTO_SECRET_PREVIOUS"), ",") {
+		if secret = strings.TrimSpace(secret); secret != "" {
+			CryptoSecretPrevious = append(CryptoSecretPrevious, secret)
+		}
+	}
 	if os.Getenv("SQLITE_PATH") != "" {
 		SQLitePath = os.Getenv("SQLITE_PATH")
 	}
@@ -98,7 +104,13 @@ func LoadEnv() {
 
 	// Initialize MES daily partition setting
 	MESDailyPartition = GetEnvOrDefaultBool("MES_DAILY_PARTITION", true)
+	// 使用 MySQL / PostgreSQL 原生范围分区代替按天分表，需要在建表前设置
+	MESNativePartition = GetEnvOrDefaultBool("MES_NATIVE_PARTITION", false)
 
 	// Initialize conversation history setting
 	ConversationHistoryEnabled = GetEnvOrDefaultBool("CONVERSATION_HISTORY_ENABLED", false)
+	// 按对话去重存储消息，每条记录只保存新增的消息和前缀指针
+	ConversationHistoryDedup = GetEnvOrDefaultBool("CONVERSATION_HISTORY_DEDUP", false)
+	// 写入对话历史时同时维护全文检索索引，关键词搜索使用索引而不是 LIKE 扫描
+	ConversationHistorySearch = GetEnvOrDefaultBool("CONVERSATION_HISTORY_SEARCH", false)
 }
@@ -106,6 +106,24 @@ var (
 		},
 		[]string{"channel_id", "channel_name", "retry_reason"},
 	)
+
+	// 渠道熔断器状态：0 closed，1 half_open，2 open
+	ChannelBreakerState = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "channel_breaker_state",
+			Help: "Circuit breaker state per channel (0=closed, 1=half_open, 2=open)",
+		},
+		[]string{"channel_id", "channel_name"},
+	)
+
+	// 渠道熔断器状态变更次数
+	ChannelBreakerTransitions = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "channel_breaker_transitions_total",
+			Help: "Total number of circuit breaker state transitions per channel",
+		},
+		[]string{"channel_id", "channel_name", "state"},
+	)
 )
 
 func init() {
@@ -121,6 +139,8 @@ func init() {
 	prometheus.MustRegister(ChannelModelCalls)
 	prometheus.MustRegister(ChannelModelCallsLog)
 	prometheus.MustRegister(ChannelRetryCount)
+	prometheus.MustRegister(ChannelBreakerState)
+	prometheus.MustRegister(ChannelBreakerTransitions)
 }
 
 // PrometheusHandler 返回Prometheus指标处理函数
@@ -68,3 +68,23 @@ func (l *InMemoryRateLimiter) Request(key string, maxRequestNum int, duration in
 	}
 	return true
 }
+
+// Peek 返回时间窗口内已记录的请求数和其中最早一次请求的时间，不记录新的请求
+func (l *InMemoryRateLimiter) Peek(key string, duration int64) (count int, oldest int64) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	queue, ok := l.store[key]
+	if !ok {
+		return 0, 0
+	}
+	now := time.Now().Unix()
+	for _, t := range *queue {
+		if now-t < duration {
+			if count == 0 {
+				oldest = t
+			}
+			count++
+		}
+	}
+	return count, oldest
+}
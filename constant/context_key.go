@@ -7,4 +7,10 @@ const (
 	ContextKeyUserStatus       = "user_status"
 	ContextKeyUserEmail        = "user_email"
 	ContextKeyUserGroup        = "user_group"
+	ContextKeyFirstResponseAt  = "first_response_at"
+	ContextKeyHedgeGroup       = "hedge_group"
+	ContextKeyBatchId          = "batch_id"
+	ContextKeyLowPriority      = "low_priority" // 低优先级请求从最低优先级的渠道开始选择
+	ContextKeyResponseCacheHit = "response_cache_hit"
+	ContextKeyTPMReservations  = "tpm_reservations"
 )
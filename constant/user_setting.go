@@ -7,7 +7,10 @@ var (
 	UserSettingWebhookSecret         = "webhook_secret"                 // WebhookSecret webhook密钥
 	UserSettingNotificationEmail     = "notification_email"             // NotificationEmail 通知邮箱地址
 	UserAcceptUnsetRatioModel        = "accept_unset_model_ratio_model" // AcceptUnsetRatioModel 是否接受未设置价格的模型
-	UserSettingRecordIpLog          = "record_ip_log"                   // 是否记录请求和错误日志IP
+	UserSettingRecordIpLog           = "record_ip_log"                  // 是否记录请求和错误日志IP
+	UserSettingHistoryMode           = "history_mode"                   // 对话历史存储模式，为空时继承分组策略
+	UserSettingHistorySampleRate     = "history_sample_rate"            // 对话历史抽样比例
+	UserSettingHistoryRetentionDays  = "history_retention_days"         // 对话历史保留天数
 )
 
 var (
@@ -0,0 +1,666 @@
+package controller
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"one-api/common"
+	constant2 "one-api/constant"
+	"one-api/dto"
+	"one-api/middleware"
+	"one-api/model"
+	"one-api/service"
+	"one-api/setting/operation_setting"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+var supportedBatchEndpoints = []string{"/v1/chat/completions", "/v1/completions", "/v1/embeddings", "/v1/responses"}
+
+const batchCompletionWindow = 24 * 60 * 60
+
+var batchRelayEngine *gin.Engine
+var batchRelayEngineOnce sync.Once
+
+// getBatchRelayEngine 批量任务中的请求按在线请求相同的流程执行：令牌鉴权、限流、渠道选择、转发和计费
+func getBatchRelayEngine() *gin.Engine {
+	batchRelayEngineOnce.Do(func() {
+		engine := gin.New()
+		engine.Use(
+			func(c *gin.Context) {
+				// 请求 ID 和批量任务 ID 由 executeBatchLine 通过 context 传入
+				c.Set(common.RequestIdKey, c.Request.Context().Value(common.RequestIdKey))
+				c.Set(constant2.ContextKeyBatchId, c.Request.Context().Value(constant2.ContextKeyBatchId))
+				// 批量请求是低优先级请求，选择渠道时把高优先级渠道留给在线请求
+				c.Set(constant2.ContextKeyLowPriority, operation_setting.GetBatchSetting().LowPriorityChannels)
+				c.Next()
+			},
+			middleware.TokenAuth(),
+			func(c *gin.Context) {
+				// 请求由服务端发起，创建任务时已校验过客户端 IP
+				c.Set("allow_ips", map[string]any{})
+				c.Next()
+			},
+			middleware.ModelRequestRateLimit(),
+			middleware.ConcurrencyLimit(),
+			middleware.Distribute(),
+		)
+		for _, endpoint := range supportedBatchEndpoints {
+			engine.POST(endpoint, Relay)
+		}
+		batchRelayEngine = engine
+	})
+	return batchRelayEngine
+}
+
+func CreateBatch(c *gin.Context) {
+	if !operation_setting.GetBatchSetting().Enabled {
+		RelayNotImplemented(c)
+		return
+	}
+	if allowIps := c.GetStringMap("allow_ips"); len(allowIps) != 0 {
+		if _, ok := allowIps[c.ClientIP()]; !ok {
+			openAIErrorResponse(c, http.StatusForbidden, "ip_not_allowed", "您的 IP 不在令牌允许访问的列表中")
+			return
+		}
+	}
+	var request dto.BatchRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		openAIErrorResponse(c, http.StatusBadRequest, "invalid_request", err.Error())
+		return
+	}
+	if !slices.Contains(supportedBatchEndpoints, request.Endpoint) {
+		openAIErrorResponse(c, http.StatusBadRequest, "invalid_endpoint", fmt.Sprintf("Unsupported endpoint: %s", request.Endpoint))
+		return
+	}
+	if request.CompletionWindow != "24h" {
+		openAIErrorResponse(c, http.StatusBadRequest, "invalid_completion_window", "completion_window must be 24h")
+		return
+	}
+	if len(request.Metadata) > 16 {
+		openAIErrorResponse(c, http.StatusBadRequest, "invalid_metadata", "metadata can have at most 16 keys")
+		return
+	}
+	userId := c.GetInt("id")
+	inputFile, err := model.GetFileByIdAndUserId(request.InputFileId, userId)
+	if err != nil {
+		openAIErrorResponse(c, http.StatusBadRequest, "invalid_input_file", err.Error())
+		return
+	}
+	if inputFile.Purpose != model.FilePurposeBatch {
+		openAIErrorResponse(c, http.StatusBadRequest, "invalid_input_file", "input file purpose must be batch")
+		return
+	}
+	now := common.GetTimestamp()
+	batch := &model.Batch{
+		Id:               "batch_" + common.GetRandomString(24),
+		UserId:           userId,
+		TokenId:          c.GetInt("token_id"),
+		Endpoint:         request.Endpoint,
+		InputFileId:      inputFile.Id,
+		CompletionWindow: request.CompletionWindow,
+		Status:           model.BatchStatusValidating,
+		CreatedTime:      now,
+		ExpiresTime:      now + batchCompletionWindow,
+	}
+	if len(request.Metadata) > 0 {
+		metadata, _ := json.Marshal(request.Metadata)
+		batch.Metadata = string(metadata)
+	}
+	if err = batch.Insert(); err != nil {
+		openAIErrorResponse(c, http.StatusInternalServerError, "create_batch_failed", err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, batch.ToOpenAIBatch())
+}
+
+func RetrieveBatch(c *gin.Context) {
+	batch, err := model.GetBatchByIdAndUserId(c.Param("id"), c.GetInt("id"))
+	if err != nil {
+		openAIErrorResponse(c, http.StatusNotFound, "batch_not_found", err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, batch.ToOpenAIBatch())
+}
+
+func ListBatches(c *gin.Context) {
+	limit := getListLimit(c)
+	batches, err := model.GetUserBatches(c.GetInt("id"), c.Query("after"), limit+1)
+	if err != nil {
+		openAIErrorResponse(c, http.StatusInternalServerError, "list_batches_failed", err.Error())
+		return
+	}
+	hasMore := len(batches) > limit
+	if hasMore {
+		batches = batches[:limit]
+	}
+	data := make([]*dto.OpenAIBatch, 0, len(batches))
+	for _, batch := range batches {
+		data = append(data, batch.ToOpenAIBatch())
+	}
+	list := dto.OpenAIList{Object: "list", Data: data, HasMore: hasMore}
+	if len(batches) > 0 {
+		list.FirstId = batches[0].Id
+		list.LastId = batches[len(batches)-1].Id
+	}
+	c.JSON(http.StatusOK, list)
+}
+
+func CancelBatch(c *gin.Context) {
+	batch, err := model.GetBatchByIdAndUserId(c.Param("id"), c.GetInt("id"))
+	if err != nil {
+		openAIErrorResponse(c, http.StatusNotFound, "batch_not_found", err.Error())
+		return
+	}
+	if batch.Status != model.BatchStatusValidating && batch.Status != model.BatchStatusInProgress {
+		openAIErrorResponse(c, http.StatusConflict, "invalid_batch_status", fmt.Sprintf("Cannot cancel a batch with status %s", batch.Status))
+		return
+	}
+	// 由后台任务完成取消，已执行的请求结果仍会写入输出文件
+	ok, err := batch.UpdateStatus(batch.Status, map[string]any{
+		"status":          model.BatchStatusCancelling,
+		"cancelling_time": common.GetTimestamp(),
+	})
+	if err != nil {
+		openAIErrorResponse(c, http.StatusInternalServerError, "cancel_batch_failed", err.Error())
+		return
+	}
+	if !ok {
+		openAIErrorResponse(c, http.StatusConflict, "invalid_batch_status", "batch status has changed, please retry")
+		return
+	}
+	batch, _ = model.GetBatchById(batch.Id)
+	c.JSON(http.StatusOK, batch.ToOpenAIBatch())
+}
+
+// UpdateBatchTasks 后台依次处理批量任务，仅在主节点运行
+func UpdateBatchTasks() {
+	for {
+		setting := operation_setting.GetBatchSetting()
+		time.Sleep(time.Duration(max(setting.PollIntervalSeconds, 1)) * time.Second)
+		if !setting.Enabled {
+			continue
+		}
+		batches, err := model.GetUnfinishedBatches(10)
+		if err != nil {
+			common.SysError("failed to get unfinished batches: " + err.Error())
+			continue
+		}
+		for _, batch := range batches {
+			if err = processBatch(batch); err != nil {
+				common.SysError(fmt.Sprintf("failed to process batch %s: %s", batch.Id, err.Error()))
+			}
+		}
+	}
+}
+
+func processBatch(batch *model.Batch) error {
+	switch batch.Status {
+	case model.BatchStatusValidating:
+		return validateBatch(batch)
+	case model.BatchStatusInProgress:
+		return runBatch(batch)
+	case model.BatchStatusFinalizing:
+		return finalizeBatch(batch, model.BatchStatusCompleted)
+	case model.BatchStatusCancelling:
+		return finalizeBatch(batch, model.BatchStatusCancelled)
+	}
+	return nil
+}
+
+// batchInputReader 逐行读取输入文件，跳过空行
+type batchInputReader struct {
+	file   *os.File
+	reader *bufio.Reader
+	line   int
+}
+
+func openBatchInput(batch *model.Batch) (*batchInputReader, error) {
+	file, err := service.OpenStoredFile(batch.InputFileId)
+	if err != nil {
+		return nil, err
+	}
+	return &batchInputReader{file: file, reader: bufio.NewReader(file)}, nil
+}
+
+// next 返回下一行非空内容及其行号，读完时返回 io.EOF
+func (r *batchInputReader) next() ([]byte, int, error) {
+	for {
+		data, err := r.reader.ReadBytes('\n')
+		if len(data) > 0 {
+			r.line++
+			data = bytes.TrimSpace(data)
+			if len(data) > 0 {
+				return data, r.line, nil
+			}
+		}
+		if err != nil {
+			return nil, r.line, err
+		}
+	}
+}
+
+// skip 跳过已处理的请求，用于服务重启后继续执行
+func (r *batchInputReader) skip(count int) error {
+	for i := 0; i < count; i++ {
+		if _, _, err := r.next(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *batchInputReader) Close() {
+	_ = r.file.Close()
+}
+
+func parseBatchInputLine(batch *model.Batch, data []byte) (*dto.BatchInputLine, error) {
+	var line dto.BatchInputLine
+	if err := json.Unmarshal(data, &line); err != nil {
+		return nil, fmt.Errorf("invalid json: %s", err.Error())
+	}
+	if line.CustomId == "" {
+		return nil, errors.New("custom_id is required")
+	}
+	if line.Method != http.MethodPost {
+		return nil, fmt.Errorf("unsupported method: %s", line.Method)
+	}
+	if line.Url != batch.Endpoint {
+		return nil, fmt.Errorf("url %s does not match batch endpoint %s", line.Url, batch.Endpoint)
+	}
+	if len(line.Body) == 0 || line.Body[0] != '{' {
+		return nil, errors.New("body must be a json object")
+	}
+	return &line, nil
+}
+
+func validateBatch(batch *model.Batch) error {
+	input, err := openBatchInput(batch)
+	if err != nil {
+		return err
+	}
+	defer input.Close()
+
+	var batchErrors []dto.BatchError
+	customIds := make(map[string]bool)
+	total := 0
+	for len(batchErrors) < 100 {
+		data, lineNumber, err := input.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		total++
+		line, err := parseBatchInputLine(batch, data)
+		if err != nil {
+			batchErrors = append(batchErrors, dto.BatchError{Code: "invalid_request", Message: err.Error(), Line: lineNumber})
+			continue
+		}
+		if customIds[line.CustomId] {
+			batchErrors = append(batchErrors, dto.BatchError{Code: "duplicate_custom_id", Message: fmt.Sprintf("duplicate custom_id: %s", line.CustomId), Line: lineNumber})
+			continue
+		}
+		customIds[line.CustomId] = true
+	}
+	if len(batchErrors) == 0 {
+		if total == 0 {
+			batchErrors = append(batchErrors, dto.BatchError{Code: "empty_file", Message: "the input file is empty"})
+		} else if maxRequests := operation_setting.GetBatchSetting().MaxRequestsPerBatch; total > maxRequests {
+			batchErrors = append(batchErrors, dto.BatchError{Code: "too_many_requests", Message: fmt.Sprintf("a batch can contain at most %d requests", maxRequests)})
+		}
+	}
+	now := common.GetTimestamp()
+	if len(batchErrors) > 0 {
+		batch.SetErrors(batchErrors)
+		_, err = batch.UpdateStatus(model.BatchStatusValidating, map[string]any{
+			"status":      model.BatchStatusFailed,
+			"errors":      batch.Errors,
+			"failed_time": now,
+		})
+		return err
+	}
+	ok, err := batch.UpdateStatus(model.BatchStatusValidating, map[string]any{
+		"status":           model.BatchStatusInProgress,
+		"request_total":    total,
+		"in_progress_time": now,
+	})
+	if err != nil || !ok {
+		// 校验期间被取消，由下一轮处理
+		return err
+	}
+	batch.Status = model.BatchStatusInProgress
+	batch.RequestTotal = total
+	batch.InProgressTime = now
+	return runBatch(batch)
+}
+
+func batchOutputId(batch *model.Batch) string {
+	return batch.Id + "_output"
+}
+
+func batchErrorId(batch *model.Batch) string {
+	return batch.Id + "_error"
+}
+
+// batchWriter 追加写入输出文件和错误文件，并记录与进度对应的文件长度
+type batchWriter struct {
+	batch  *model.Batch
+	output *os.File
+	error  *os.File
+}
+
+func openBatchWriter(batch *model.Batch) (*batchWriter, error) {
+	// 丢弃上次中断时已写入但未记录进度的内容
+	if err := service.TruncateStoredFile(batchOutputId(batch), batch.OutputBytes); err != nil {
+		return nil, err
+	}
+	if err := service.TruncateStoredFile(batchErrorId(batch), batch.ErrorBytes); err != nil {
+		return nil, err
+	}
+	output, err := service.AppendStoredFile(batchOutputId(batch))
+	if err != nil {
+		return nil, err
+	}
+	errorFile, err := service.AppendStoredFile(batchErrorId(batch))
+	if err != nil {
+		_ = output.Close()
+		return nil, err
+	}
+	return &batchWriter{batch: batch, output: output, error: errorFile}, nil
+}
+
+func (w *batchWriter) write(line *dto.BatchOutputLine, success bool) error {
+	data, err := json.Marshal(line)
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+	if success {
+		n, err := w.output.Write(data)
+		w.batch.OutputBytes += int64(n)
+		w.batch.RequestCompleted++
+		return err
+	}
+	n, err := w.error.Write(data)
+	w.batch.ErrorBytes += int64(n)
+	w.batch.RequestFailed++
+	return err
+}
+
+func (w *batchWriter) saveProgress() error {
+	return model.UpdateBatchProgress(w.batch)
+}
+
+func (w *batchWriter) Close() {
+	_ = w.output.Close()
+	_ = w.error.Close()
+}
+
+func runBatch(batch *model.Batch) error {
+	token, err := model.GetTokenById(batch.TokenId)
+	if err != nil {
+		batch.SetErrors([]dto.BatchError{{Code: "token_not_found", Message: "the token used to create this batch no longer exists"}})
+		_, err = batch.UpdateStatus(model.BatchStatusInProgress, map[string]any{
+			"status":      model.BatchStatusFailed,
+			"errors":      batch.Errors,
+			"failed_time": common.GetTimestamp(),
+		})
+		return err
+	}
+	input, err := openBatchInput(batch)
+	if err != nil {
+		return err
+	}
+	defer input.Close()
+	if err = input.skip(batch.Progress); err != nil && err != io.EOF {
+		return err
+	}
+	writer, err := openBatchWriter(batch)
+	if err != nil {
+		return err
+	}
+	defer writer.Close()
+
+	for {
+		setting := operation_setting.GetBatchSetting()
+		if !setting.Enabled {
+			return nil
+		}
+		var lines [][]byte
+		for len(lines) < max(setting.Concurrency, 1) {
+			data, _, err := input.next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return err
+			}
+			lines = append(lines, data)
+		}
+		if len(lines) == 0 {
+			break
+		}
+		results := make([]*dto.BatchOutputLine, len(lines))
+		success := make([]bool, len(lines))
+		var wg sync.WaitGroup
+		for i, data := range lines {
+			wg.Add(1)
+			go func(i int, data []byte) {
+				defer wg.Done()
+				results[i], success[i] = executeBatchLine(batch, token, data)
+			}(i, data)
+		}
+		wg.Wait()
+		for i := range results {
+			if err = writer.write(results[i], success[i]); err != nil {
+				return err
+			}
+		}
+		batch.Progress += len(lines)
+		if err = writer.saveProgress(); err != nil {
+			return err
+		}
+
+		current, err := model.GetBatchById(batch.Id)
+		if err != nil {
+			return err
+		}
+		if current.Status == model.BatchStatusCancelling {
+			batch.Status = current.Status
+			writer.Close()
+			return finalizeBatch(batch, model.BatchStatusCancelled)
+		}
+		if common.GetTimestamp() > batch.ExpiresTime {
+			writer.Close()
+			return finalizeBatch(batch, model.BatchStatusExpired)
+		}
+		time.Sleep(time.Duration(setting.IntervalMilliseconds) * time.Millisecond)
+	}
+	ok, err := batch.UpdateStatus(model.BatchStatusInProgress, map[string]any{
+		"status":          model.BatchStatusFinalizing,
+		"finalizing_time": common.GetTimestamp(),
+	})
+	if err != nil || !ok {
+		return err
+	}
+	batch.Status = model.BatchStatusFinalizing
+	writer.Close()
+	return finalizeBatch(batch, model.BatchStatusCompleted)
+}
+
+func batchErrorLine(customId string, code string, message string) *dto.BatchOutputLine {
+	return &dto.BatchOutputLine{
+		Id:       "batch_req_" + common.GetRandomString(24),
+		CustomId: customId,
+		Error:    &dto.BatchError{Code: code, Message: message},
+	}
+}
+
+// executeBatchLine 执行一行请求，返回输出行以及请求是否成功
+func executeBatchLine(batch *model.Batch, token *model.Token, data []byte) (result *dto.BatchOutputLine, success bool) {
+	line, err := parseBatchInputLine(batch, data)
+	if err != nil {
+		return batchErrorLine("", "invalid_request", err.Error()), false
+	}
+	var streamRequest struct {
+		Stream bool `json:"stream"`
+	}
+	if err = json.Unmarshal(line.Body, &streamRequest); err == nil && streamRequest.Stream {
+		return batchErrorLine(line.CustomId, "invalid_request", "stream is not supported in batch requests"), false
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			common.SysError(fmt.Sprintf("batch request panic (batch %s, custom_id %s): %v", batch.Id, line.CustomId, r))
+			result, success = batchErrorLine(line.CustomId, "server_error", fmt.Sprintf("%v", r)), false
+		}
+	}()
+
+	requestId := common.GetTimeString() + common.GetRandomString(8)
+	ctx := context.WithValue(context.Background(), common.RequestIdKey, requestId)
+	ctx = context.WithValue(ctx, constant2.ContextKeyBatchId, batch.Id)
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, line.Url, bytes.NewReader(line.Body))
+	if err != nil {
+		return batchErrorLine(line.CustomId, "invalid_request", err.Error()), false
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("Authorization", "Bearer sk-"+token.Key)
+	request.RemoteAddr = "127.0.0.1:0"
+
+	recorder := httptest.NewRecorder()
+	getBatchRelayEngine().ServeHTTP(recorder, request)
+
+	body := recorder.Body.Bytes()
+	if !json.Valid(body) {
+		body, _ = json.Marshal(string(body))
+	}
+	return &dto.BatchOutputLine{
+		Id:       "batch_req_" + common.GetRandomString(24),
+		CustomId: line.CustomId,
+		Response: &dto.BatchOutputResponse{
+			StatusCode: recorder.Code,
+			RequestId:  requestId,
+			Body:       body,
+		},
+	}, recorder.Code >= 200 && recorder.Code < 300
+}
+
+// failRemainingLines 将未执行的请求写入错误文件
+func failRemainingLines(batch *model.Batch, code string, message string) error {
+	input, err := openBatchInput(batch)
+	if err != nil {
+		return err
+	}
+	defer input.Close()
+	if err = input.skip(batch.Progress); err != nil {
+		if err == io.EOF {
+			return nil
+		}
+		return err
+	}
+	writer, err := openBatchWriter(batch)
+	if err != nil {
+		return err
+	}
+	defer writer.Close()
+	for {
+		data, _, err := input.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		var line dto.BatchInputLine
+		_ = json.Unmarshal(data, &line)
+		if err = writer.write(batchErrorLine(line.CustomId, code, message), false); err != nil {
+			return err
+		}
+		batch.Progress++
+	}
+	return writer.saveProgress()
+}
+
+// saveBatchOutputFile 将输出内容登记为文件，内容为空时返回空字符串。
+// 文件 id 由批量任务 id 生成，中断后重新执行时结果不变。
+func saveBatchOutputFile(batch *model.Batch, storageId string, size int64, suffix string) (string, error) {
+	if size == 0 {
+		return "", service.DeleteStoredFile(storageId)
+	}
+	file := &model.File{
+		Id:          fmt.Sprintf("file-%s-%s", strings.TrimPrefix(batch.Id, "batch_"), suffix),
+		UserId:      batch.UserId,
+		TokenId:     batch.TokenId,
+		Filename:    fmt.Sprintf("%s_%s.jsonl", batch.Id, suffix),
+		Purpose:     model.FilePurposeBatchOutput,
+		Bytes:       size,
+		Status:      "processed",
+		CreatedTime: common.GetTimestamp(),
+	}
+	if _, err := service.StoredFileSize(file.Id); err != nil {
+		if err = service.TruncateStoredFile(storageId, size); err != nil {
+			return "", err
+		}
+		if err = service.RenameStoredFile(storageId, file.Id); err != nil {
+			return "", err
+		}
+	}
+	return file.Id, file.Save()
+}
+
+// finalizeBatch 生成输出文件和错误文件并结束批量任务
+func finalizeBatch(batch *model.Batch, finalStatus string) error {
+	fromStatus := batch.Status
+	// 校验完成前取消的任务没有需要写入的结果
+	if batch.RequestTotal > 0 {
+		switch finalStatus {
+		case model.BatchStatusCancelled:
+			if err := failRemainingLines(batch, "batch_cancelled", "This request was not executed because the batch was cancelled."); err != nil {
+				return err
+			}
+		case model.BatchStatusExpired:
+			if err := failRemainingLines(batch, "batch_expired", "This request could not be executed before the completion window expired."); err != nil {
+				return err
+			}
+		}
+	}
+	outputFileId, err := saveBatchOutputFile(batch, batchOutputId(batch), batch.OutputBytes, "output")
+	if err != nil {
+		return err
+	}
+	errorFileId, err := saveBatchOutputFile(batch, batchErrorId(batch), batch.ErrorBytes, "error")
+	if err != nil {
+		return err
+	}
+	now := common.GetTimestamp()
+	fields := map[string]any{
+		"status":            finalStatus,
+		"output_file_id":    outputFileId,
+		"error_file_id":     errorFileId,
+		"progress":          batch.Progress,
+		"request_completed": batch.RequestCompleted,
+		"request_failed":    batch.RequestFailed,
+	}
+	switch finalStatus {
+	case model.BatchStatusCompleted:
+		fields["completed_time"] = now
+	case model.BatchStatusCancelled:
+		fields["cancelled_time"] = now
+	case model.BatchStatusExpired:
+		fields["expired_time"] = now
+	}
+	_, err = batch.UpdateStatus(fromStatus, fields)
+	return err
+}
@@ -53,6 +53,14 @@ func GetSubscription(c *gin.Context) {
 		SystemHardLimitUSD: amount,
 		AccessUntil:        expiredTime,
 	}
+	// 设置了周期预算上限时，以本周期的预算作为额度上限
+	if budget := getBillingBudgetStatus(c); budget != nil && budget.Limit > 0 {
+		subscription.HardLimitUSD = quotaToBillingAmount(budget.Limit)
+		subscription.SoftLimitUSD = subscription.HardLimitUSD
+		if budget.AlertThreshold > 0 {
+			subscription.SoftLimitUSD = quotaToBillingAmount(budget.AlertThreshold)
+		}
+	}
 	c.JSON(200, subscription)
 	return
 }
@@ -79,14 +87,35 @@ func GetUsage(c *gin.Context) {
 		})
 		return
 	}
-	amount := float64(quota)
-	if common.DisplayInCurrencyEnabled {
-		amount /= common.QuotaPerUnit
+	// 启用周期预算时返回本周期的用量
+	if budget := getBillingBudgetStatus(c); budget != nil {
+		quota = budget.Used
 	}
 	usage := OpenAIUsageResponse{
 		Object:     "list",
-		TotalUsage: amount * 100,
+		TotalUsage: quotaToBillingAmount(quota) * 100,
 	}
 	c.JSON(200, usage)
 	return
 }
+
+func quotaToBillingAmount(quota int) float64 {
+	amount := float64(quota)
+	if common.DisplayInCurrencyEnabled {
+		amount /= common.QuotaPerUnit
+	}
+	return amount
+}
+
+// getBillingBudgetStatus 与额度统计口径一致：DisplayTokenStatEnabled 时取令牌的周期预算，否则取用户的，未启用时返回 nil
+func getBillingBudgetStatus(c *gin.Context) *model.QuotaBudgetStatus {
+	accountType, accountId := model.QuotaAccountUser, c.GetInt("id")
+	if common.DisplayTokenStatEnabled {
+		accountType, accountId = model.QuotaAccountToken, c.GetInt("token_id")
+	}
+	status, err := model.GetQuotaBudgetStatus(accountType, accountId)
+	if err != nil || !status.Enabled() {
+		return nil
+	}
+	return status
+}
@@ -82,14 +82,16 @@ func testChannel(channel *model.Channel, testModel string) (err error, openAIErr
 	}
 	cache.WriteContext(c)
 
-	c.Request.Header.Set("Authorization", "Bearer "+channel.Key)
 	c.Request.Header.Set("Content-Type", "application/json")
 	c.Set("channel", channel.Type)
 	c.Set("base_url", channel.GetBaseURL())
 	group, _ := model.GetUserGroup(1, false)
 	c.Set("group", group)
 
-	middleware.SetupContextForSelectedChannel(c, channel, testModel)
+	// 多密钥渠道按轮询策略选择密钥并设置 Authorization 请求头
+	if err = middleware.SetupContextForSelectedChannel(c, channel, testModel); err != nil {
+		return err, nil
+	}
 
 	info := relaycommon.GenRelayInfo(c)
 
@@ -98,6 +98,8 @@ func GetAllChannels(c *gin.Context) {
 		total, _ = model.CountAllChannels()
 	}
 
+	fillChannelRuntimeStatus(channelData)
+
 	// calculate type counts
 	typeCounts, _ := model.CountChannelsGroupByType()
 
@@ -152,7 +154,15 @@ func FetchUpstreamModels(c *gin.Context) {
 	case common.ChannelTypeAli:
 		url = fmt.Sprintf("%s/compatible-mode/v1/models", baseURL)
 	}
-	body, err := GetResponseBody("GET", url, channel, GetAuthHeader(channel.Key))
+	key, _, err := channel.GetNextEnabledKey()
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	body, err := GetResponseBody("GET", url, channel, GetAuthHeader(key))
 	if err != nil {
 		c.JSON(http.StatusOK, gin.H{
 			"success": false,
@@ -238,6 +248,8 @@ func SearchChannels(c *gin.Context) {
 		channelData = channels
 	}
 
+	fillChannelRuntimeStatus(channelData)
+
 	// calculate type counts for search results
 	typeCounts := make(map[int64]int64)
 	for _, channel := range channelData {
@@ -272,6 +284,7 @@ func GetChannel(c *gin.Context) {
 		})
 		return
 	}
+	fillChannelRuntimeStatus([]*model.Channel{channel})
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
 		"message": "",
@@ -314,6 +327,11 @@ func AddChannel(c *gin.Context) {
 		}
 		keys = []string{channel.Key}
 	}
+	// 多密钥渠道的所有密钥保存在同一个渠道中
+	if channel.GetMultiKeyEnabled() {
+		keys = []string{channel.Key}
+		channel.MultiKeyStatus = nil
+	}
 	channels := make([]model.Channel, 0, len(keys))
 	for _, key := range keys {
 		if key == "" {
@@ -538,6 +556,11 @@ func UpdateChannel(c *gin.Context) {
 			}
 		}
 	}
+	// 密钥状态只能通过密钥管理接口修改，密钥变更后下标失效，需要重置状态
+	channel.MultiKeyStatus = nil
+	if channel.Key != "" && channel.GetMultiKeyEnabled() {
+		channel.MultiKeyStatus = common.GetPointer[string]("{}")
+	}
 	err = channel.Update()
 	if err != nil {
 		c.JSON(http.StatusOK, gin.H{
@@ -701,3 +724,106 @@ func GetTagModels(c *gin.Context) {
 	})
 	return
 }
+
+func GetChannelKeys(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	channel, err := model.GetChannelById(id, true)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data": gin.H{
+			"multi_key_enabled": channel.GetMultiKeyEnabled(),
+			"multi_key_policy":  channel.GetMultiKeyPolicy(),
+			"keys":              model.GetChannelKeyInfos(channel),
+		},
+	})
+}
+
+type ChannelKeyStatusRequest struct {
+	Index  *int `json:"index"`
+	Status int  `json:"status"`
+}
+
+// UpdateChannelKeyStatus 启用或禁用多密钥渠道中的密钥，index 为空时重新启用全部密钥
+func UpdateChannelKeyStatus(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	req := ChannelKeyStatusRequest{}
+	err = c.ShouldBindJSON(&req)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "参数错误",
+		})
+		return
+	}
+	if req.Index == nil {
+		err = model.EnableAllChannelKeys(id)
+	} else {
+		if req.Status != common.ChannelStatusEnabled && req.Status != common.ChannelStatusManuallyDisabled {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": "无效的密钥状态",
+			})
+			return
+		}
+		_, err = model.UpdateChannelKeyStatus(id, *req.Index, req.Status, "手动禁用")
+	}
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+	})
+}
+
+func fillChannelRuntimeStatus(channels []*model.Channel) {
+	for _, channel := range channels {
+		status := model.GetChannelBreakerStatus(channel.Id)
+		channel.Breaker = &status
+		if stats, ok := model.GetChannelStats(channel.Id); ok {
+			channel.Stats = &stats
+		}
+	}
+}
+
+func ResetChannelBreaker(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	model.ResetChannelBreaker(id)
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+	})
+}
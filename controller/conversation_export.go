@@ -0,0 +1,74 @@
+package controller
+
+import (
+	"fmt"
+	"net/http"
+	"one-api/common"
+	"one-api/model"
+	"one-api/service"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// exportConversationHistories 流式导出对话历史，format 可选 openai（默认）、sharegpt、markdown，
+// status 可选 success（默认）、error、all
+func exportConversationHistories(c *gin.Context, filter model.ConversationHistoryFilter) {
+	options := service.ConversationExportOptions{
+		Filter: filter,
+		Format: c.DefaultQuery("format", service.ConversationExportFormatOpenAI),
+	}
+	contentType, extension, ok := service.ConversationExportContentType(options.Format)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "无效的导出格式，可选值为 openai、sharegpt、markdown",
+		})
+		return
+	}
+	switch c.DefaultQuery("status", "success") {
+	case "success":
+		options.IncludeSuccess = true
+	case "error":
+		options.IncludeError = true
+	case "all":
+		options.IncludeSuccess = true
+		options.IncludeError = true
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "无效的状态，可选值为 success、error、all",
+		})
+		return
+	}
+	applyConversationHistoryTimeRange(c, &options.Filter)
+
+	c.Header("Content-Type", contentType)
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=conversations_%s.%s", time.Now().Format("20060102150405"), extension))
+	c.Status(http.StatusOK)
+	// 响应头已经发出，导出中途出错只能记录日志并截断输出
+	err := service.ExportConversationHistories(c.Writer, options, c.Writer.Flush)
+	if err != nil {
+		common.LogError(c, "failed to export conversation histories: "+err.Error())
+	}
+}
+
+// ExportConversationHistories 导出当前用户的对话历史
+func ExportConversationHistories(c *gin.Context) {
+	exportConversationHistories(c, model.ConversationHistoryFilter{
+		UserId:         c.GetInt("id"),
+		ConversationId: c.Query("conversation_id"),
+		ModelName:      c.Query("model_name"),
+	})
+}
+
+// AdminExportConversationHistories 管理员导出对话历史，可按 user_id 过滤
+func AdminExportConversationHistories(c *gin.Context) {
+	userId, _ := strconv.Atoi(c.Query("user_id"))
+	exportConversationHistories(c, model.ConversationHistoryFilter{
+		UserId:         userId,
+		ConversationId: c.Query("conversation_id"),
+		ModelName:      c.Query("model_name"),
+	})
+}
@@ -2,12 +2,134 @@ package controller
 
 import (
 	"net/http"
+	"one-api/common"
 	"one-api/model"
 	"strconv"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
 
+// useConversationHistoryCursor 传入了 cursor（第一页可以为空）、start_timestamp 或 end_timestamp 时使用键集分页，
+// 否则保持原有的偏移分页，不传 page 的旧客户端仍然得到原有的响应格式
+func useConversationHistoryCursor(c *gin.Context) bool {
+	for _, key := range []string{"cursor", "start_timestamp", "end_timestamp"} {
+		if _, ok := c.GetQuery(key); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// applyConversationHistoryTimeRange 解析 start_timestamp、end_timestamp 查询参数
+func applyConversationHistoryTimeRange(c *gin.Context, filter *model.ConversationHistoryFilter) {
+	if startTimestamp, _ := strconv.ParseInt(c.Query("start_timestamp"), 10, 64); startTimestamp > 0 {
+		startTime := time.Unix(startTimestamp, 0)
+		filter.StartTime = &startTime
+	}
+	if endTimestamp, _ := strconv.ParseInt(c.Query("end_timestamp"), 10, 64); endTimestamp > 0 {
+		endTime := time.Unix(endTimestamp, 0)
+		filter.EndTime = &endTime
+	}
+}
+
+// useConversationSearch 启用全文检索且指定了关键词、令牌或角色时使用检索索引
+func useConversationSearch(c *gin.Context) bool {
+	return common.ConversationHistorySearch && (c.Query("keyword") != "" || c.Query("token_id") != "" || c.Query("role") != "")
+}
+
+// respondConversationSearch 使用全文检索索引按相关度分页返回对话历史，每条结果附带高亮摘要
+func respondConversationSearch(c *gin.Context, filter model.ConversationHistoryFilter, page int, pageSize int) {
+	applyConversationHistoryTimeRange(c, &filter)
+	searchFilter := model.ConversationSearchFilter{ConversationHistoryFilter: filter}
+	searchFilter.TokenId, _ = strconv.Atoi(c.Query("token_id"))
+	switch role := c.Query("role"); role {
+	case "", "system", "user", "assistant", "tool":
+		searchFilter.Role = role
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "无效的角色，可选值为 system、user、assistant、tool",
+		})
+		return
+	}
+	if page < 1 {
+		page = 1
+	}
+
+	results, total, err := model.SearchConversationHistoriesByIndex(searchFilter, pageSize, (page-1)*pageSize)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "搜索对话历史失败: " + err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data": gin.H{
+			"histories": results,
+			"total":     total,
+			"page":      page,
+			"page_size": pageSize,
+		},
+	})
+}
+
+// respondConversationHistoriesByCursor 按 (created_at, id) 游标分页返回对话历史，with_count=true 时才统计总数
+func respondConversationHistoriesByCursor(c *gin.Context, filter model.ConversationHistoryFilter, pageSize int) {
+	applyConversationHistoryTimeRange(c, &filter)
+	var cursor *model.ConversationHistoryCursor
+	if cursorStr := c.Query("cursor"); cursorStr != "" {
+		var err error
+		cursor, err = model.DecodeConversationHistoryCursor(cursorStr)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"message": "无效的分页游标",
+			})
+			return
+		}
+	}
+
+	histories, next, err := model.QueryConversationHistories(filter, cursor, pageSize)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "获取对话历史失败: " + err.Error(),
+		})
+		return
+	}
+	data := gin.H{
+		"histories":   histories,
+		"page_size":   pageSize,
+		"next_cursor": "",
+		"has_more":    next != nil,
+	}
+	if next != nil {
+		data["next_cursor"] = next.Encode()
+	}
+	// 跨分表计数代价较高，只在需要时统计
+	if c.Query("with_count") == "true" {
+		total, err := model.CountConversationHistories(filter)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"success": false,
+				"message": "统计对话历史失败: " + err.Error(),
+			})
+			return
+		}
+		data["total"] = total
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    data,
+	})
+}
+
 // GetConversationHistories 获取对话历史列表
 func GetConversationHistories(c *gin.Context) {
 	userId := c.GetInt("id")
@@ -23,6 +145,25 @@ func GetConversationHistories(c *gin.Context) {
 	if pageSize > 100 {
 		pageSize = 100
 	}
+	if pageSize <= 0 {
+		pageSize = 20
+	}
+
+	// 普通用户只能查询自己的对话历史
+	filter := model.ConversationHistoryFilter{
+		UserId:         userId,
+		ConversationId: conversationId,
+		ModelName:      modelName,
+		Keyword:        keyword,
+	}
+	if useConversationSearch(c) {
+		respondConversationSearch(c, filter, page, pageSize)
+		return
+	}
+	if useConversationHistoryCursor(c) {
+		respondConversationHistoriesByCursor(c, filter, pageSize)
+		return
+	}
 	offset := (page - 1) * pageSize
 
 	var histories []*model.ConversationHistory
@@ -206,11 +347,9 @@ func AdminGetConversationHistories(c *gin.Context) {
 	if pageSize > 100 {
 		pageSize = 100
 	}
-	offset := (page - 1) * pageSize
-
-	var histories []*model.ConversationHistory
-	var total int64
-	var err error
+	if pageSize <= 0 {
+		pageSize = 20
+	}
 
 	// 解析用户ID
 	var userId int
@@ -218,6 +357,26 @@ func AdminGetConversationHistories(c *gin.Context) {
 		userId, _ = strconv.Atoi(userIdStr)
 	}
 
+	filter := model.ConversationHistoryFilter{
+		UserId:         userId,
+		ConversationId: conversationId,
+		ModelName:      modelName,
+		Keyword:        keyword,
+	}
+	if useConversationSearch(c) {
+		respondConversationSearch(c, filter, page, pageSize)
+		return
+	}
+	if useConversationHistoryCursor(c) {
+		respondConversationHistoriesByCursor(c, filter, pageSize)
+		return
+	}
+	offset := (page - 1) * pageSize
+
+	var histories []*model.ConversationHistory
+	var total int64
+	var err error
+
 	// 根据不同条件查询
 	if conversationId != "" {
 		histories, total, err = model.GetConversationHistoriesByConversationId(conversationId, pageSize, offset)
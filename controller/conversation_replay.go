@@ -0,0 +1,323 @@
+package controller
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"one-api/common"
+	"one-api/dto"
+	"one-api/middleware"
+	"one-api/model"
+	"one-api/relay"
+	relaycommon "one-api/relay/common"
+	"one-api/relay/constant"
+	"one-api/relay/helper"
+	"one-api/service"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// errorReplayBatchLimit 批量重放单次最多处理的记录数
+	errorReplayBatchLimit = 50
+	// errorReplayConcurrency 批量重放的并发数
+	errorReplayConcurrency = 5
+	// errorReplayResponseMaxLen 结果中保留的回复长度（字符数）
+	errorReplayResponseMaxLen = 2000
+)
+
+// errorReplayResult 一次重放的结果，与原始错误并列返回
+type errorReplayResult struct {
+	HistoryId            int        `json:"history_id"`
+	ConversationId       string     `json:"conversation_id"`
+	ModelName            string     `json:"model_name"`
+	OriginalErrorCode    string     `json:"original_error_code"`
+	OriginalErrorMessage string     `json:"original_error_message"`
+	ChannelId            int        `json:"channel_id"`
+	ChannelName          string     `json:"channel_name"`
+	Success              bool       `json:"success"`
+	StatusCode           int        `json:"status_code"`
+	ErrorCode            string     `json:"error_code"`
+	ErrorMessage         string     `json:"error_message"`
+	Response             string     `json:"response"`
+	Usage                *dto.Usage `json:"usage"`
+	LatencyMs            int64      `json:"latency_ms"`
+}
+
+func (result *errorReplayResult) fail(err error, openaiErr *dto.OpenAIErrorWithStatusCode) *errorReplayResult {
+	result.Success = false
+	result.ErrorMessage = err.Error()
+	if openaiErr != nil {
+		result.StatusCode = openaiErr.StatusCode
+		result.ErrorCode = fmt.Sprintf("%v", openaiErr.Error.Code)
+		result.ErrorMessage = openaiErr.Error.Message
+	}
+	return result
+}
+
+// replayErrorConversation 以原用户的身份重新发送失败的请求，channelId 为 0 时按正常规则选择渠道。
+// 重放是演练，不预扣、不结算额度，也不记录消费日志。
+func replayErrorConversation(history *model.ErrorConversationHistory, channelId int) *errorReplayResult {
+	result := &errorReplayResult{
+		HistoryId:            history.Id,
+		ConversationId:       history.ConversationId,
+		ModelName:            history.ModelName,
+		OriginalErrorCode:    history.ErrorCode,
+		OriginalErrorMessage: history.ErrorMessage,
+	}
+	var data struct {
+		Messages []dto.Message `json:"messages"`
+		Model    string        `json:"model"`
+	}
+	if err := json.Unmarshal([]byte(history.RawJson), &data); err != nil {
+		return result.fail(fmt.Errorf("无法解析错误对话历史: %w", err), nil)
+	}
+	if len(data.Messages) == 0 {
+		return result.fail(errors.New("错误对话历史中没有消息"), nil)
+	}
+	modelName := history.ModelName
+	if data.Model != "" {
+		modelName = data.Model
+	}
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = &http.Request{
+		Method: "POST",
+		URL:    &url.URL{Path: "/v1/chat/completions"},
+		Body:   nil,
+		Header: make(http.Header),
+	}
+	c.Request.Header.Set("Content-Type", "application/json")
+
+	userCache, err := model.GetUserCache(history.UserId)
+	if err != nil {
+		return result.fail(err, nil)
+	}
+	userCache.WriteContext(c)
+	c.Set("id", history.UserId)
+	c.Set("group", userCache.Group)
+
+	var channel *model.Channel
+	acquired := false
+	if channelId > 0 {
+		channel, err = model.GetChannelById(channelId, true)
+	} else {
+		channel, _, err = model.CacheGetRandomSatisfiedChannel(c, userCache.Group, modelName, 0)
+		acquired = err == nil
+	}
+	if err != nil {
+		return result.fail(fmt.Errorf("选择渠道失败: %w", err), nil)
+	}
+	result.ChannelId = channel.Id
+	result.ChannelName = channel.Name
+	c.Set("channel", channel.Type)
+	if err = middleware.SetupContextForSelectedChannel(c, channel, modelName); err != nil {
+		return result.fail(err, nil)
+	}
+
+	tik := time.Now()
+	usage, err, openaiErr := doErrorReplayRequest(c, channel, modelName, data.Messages)
+	duration := time.Since(tik)
+	result.LatencyMs = duration.Milliseconds()
+	if acquired {
+		// 正常选择渠道时占用了熔断器的探测名额，重放结果同样可以反映上游是否恢复
+		model.RecordChannelBreakerResult(channel.Id, channel.Name, err == nil, duration)
+	}
+	if err != nil {
+		return result.fail(err, openaiErr)
+	}
+
+	result.Success = true
+	result.StatusCode = http.StatusOK
+	result.Usage = usage
+	var response dto.OpenAITextResponse
+	if err = json.Unmarshal(w.Body.Bytes(), &response); err == nil && len(response.Choices) > 0 {
+		result.Response = response.Choices[0].Message.StringContent()
+	} else {
+		result.Response = w.Body.String()
+	}
+	if runes := []rune(result.Response); len(runes) > errorReplayResponseMaxLen {
+		result.Response = string(runes[:errorReplayResponseMaxLen]) + "…"
+	}
+	common.SysLog(fmt.Sprintf("replayed error conversation history %d on channel #%d, success: %v", history.Id, channel.Id, result.Success))
+	return result
+}
+
+// doErrorReplayRequest 与渠道测试相同，直接通过适配器发送非流式请求，响应写入 c 的 ResponseRecorder
+func doErrorReplayRequest(c *gin.Context, channel *model.Channel, modelName string, messages []dto.Message) (*dto.Usage, error, *dto.OpenAIErrorWithStatusCode) {
+	info := relaycommon.GenRelayInfo(c)
+	if err := helper.ModelMappedHelper(c, info, nil); err != nil {
+		return nil, err, nil
+	}
+	apiType, _ := constant.ChannelType2APIType(channel.Type)
+	adaptor := relay.GetAdaptor(apiType)
+	if adaptor == nil {
+		return nil, fmt.Errorf("invalid api type: %d, adaptor is nil", apiType), nil
+	}
+	request := &dto.GeneralOpenAIRequest{
+		Model:    info.UpstreamModelName,
+		Messages: messages,
+		Stream:   false,
+	}
+	adaptor.Init(info)
+	convertedRequest, err := adaptor.ConvertOpenAIRequest(c, info, request)
+	if err != nil {
+		return nil, err, nil
+	}
+	jsonData, err := json.Marshal(convertedRequest)
+	if err != nil {
+		return nil, err, nil
+	}
+	requestBody := bytes.NewBuffer(jsonData)
+	c.Request.Body = io.NopCloser(requestBody)
+	resp, err := adaptor.DoRequest(c, info, requestBody)
+	if err != nil {
+		return nil, err, nil
+	}
+	var httpResp *http.Response
+	if resp != nil {
+		httpResp = resp.(*http.Response)
+		if httpResp.StatusCode != http.StatusOK {
+			openaiErr := service.RelayErrorHandler(httpResp, true)
+			return nil, fmt.Errorf("status code %d: %s", httpResp.StatusCode, openaiErr.Error.Message), openaiErr
+		}
+	}
+	usage, openaiErr := adaptor.DoResponse(c, httpResp, info)
+	if openaiErr != nil {
+		return nil, errors.New(openaiErr.Error.Message), openaiErr
+	}
+	if usage == nil {
+		return nil, errors.New("usage is nil"), nil
+	}
+	return usage.(*dto.Usage), nil, nil
+}
+
+type errorReplayRequest struct {
+	ChannelId int `json:"channel_id"` // 为 0 时按正常规则选择渠道
+}
+
+// ReplayErrorConversationHistory 重放一条错误对话历史，不计费
+func ReplayErrorConversationHistory(c *gin.Context) {
+	historyId, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "无效的ID",
+		})
+		return
+	}
+	var req errorReplayRequest
+	if c.Request.ContentLength > 0 {
+		if err = c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"message": "无效的参数",
+			})
+			return
+		}
+	}
+	history, err := model.GetErrorConversationHistoryById(historyId)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"success": false,
+			"message": "错误对话历史不存在",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    replayErrorConversation(history, req.ChannelId),
+	})
+}
+
+type batchErrorReplayRequest struct {
+	errorReplayRequest
+	UserId         int    `json:"user_id"`
+	ConversationId string `json:"conversation_id"`
+	ModelName      string `json:"model_name"`
+	StartTimestamp int64  `json:"start_timestamp"`
+	EndTimestamp   int64  `json:"end_timestamp"`
+	Limit          int    `json:"limit"` // 默认 10，最多 50
+}
+
+// BatchReplayErrorConversationHistories 按条件重放最近的错误对话历史，用于确认上游故障是否已经恢复，不计费
+func BatchReplayErrorConversationHistories(c *gin.Context) {
+	var req batchErrorReplayRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "无效的参数",
+		})
+		return
+	}
+	if req.Limit <= 0 {
+		req.Limit = 10
+	}
+	if req.Limit > errorReplayBatchLimit {
+		req.Limit = errorReplayBatchLimit
+	}
+	filter := model.ConversationHistoryFilter{
+		UserId:         req.UserId,
+		ConversationId: req.ConversationId,
+		ModelName:      req.ModelName,
+	}
+	if req.StartTimestamp > 0 {
+		startTime := time.Unix(req.StartTimestamp, 0)
+		filter.StartTime = &startTime
+	}
+	if req.EndTimestamp > 0 {
+		endTime := time.Unix(req.EndTimestamp, 0)
+		filter.EndTime = &endTime
+	}
+	histories, _, err := model.QueryErrorConversationHistories(filter, nil, req.Limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "获取错误对话历史失败: " + err.Error(),
+		})
+		return
+	}
+
+	results := make([]*errorReplayResult, len(histories))
+	semaphore := make(chan struct{}, errorReplayConcurrency)
+	var wg sync.WaitGroup
+	for i, history := range histories {
+		wg.Add(1)
+		semaphore <- struct{}{}
+		go func(i int, history *model.ErrorConversationHistory) {
+			defer func() {
+				<-semaphore
+				wg.Done()
+			}()
+			results[i] = replayErrorConversation(history, req.ChannelId)
+		}(i, history)
+	}
+	wg.Wait()
+
+	succeeded := 0
+	for _, result := range results {
+		if result.Success {
+			succeeded++
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data": gin.H{
+			"results":   results,
+			"total":     len(results),
+			"succeeded": succeeded,
+			"failed":    len(results) - succeeded,
+		},
+	})
+}
@@ -0,0 +1,152 @@
+package controller
+
+import (
+	"fmt"
+	"net/http"
+	"one-api/common"
+	"one-api/dto"
+	"one-api/model"
+	"one-api/service"
+	"one-api/setting/operation_setting"
+	"slices"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+var supportedFilePurposes = []string{"batch", "fine-tune", "assistants", "vision", "user_data", "evals"}
+
+func openAIErrorResponse(c *gin.Context, statusCode int, code string, message string) {
+	c.JSON(statusCode, gin.H{
+		"error": dto.OpenAIError{
+			Message: message,
+			Type:    "invalid_request_error",
+			Code:    code,
+		},
+	})
+}
+
+func getListLimit(c *gin.Context) int {
+	limit, _ := strconv.Atoi(c.Query("limit"))
+	if limit <= 0 {
+		limit = 20
+	}
+	if limit > 100 {
+		limit = 100
+	}
+	return limit
+}
+
+func UploadFile(c *gin.Context) {
+	setting := operation_setting.GetBatchSetting()
+	if !setting.Enabled {
+		RelayNotImplemented(c)
+		return
+	}
+	purpose := c.PostForm("purpose")
+	if !slices.Contains(supportedFilePurposes, purpose) {
+		openAIErrorResponse(c, http.StatusBadRequest, "invalid_purpose", fmt.Sprintf("Invalid purpose: %s", purpose))
+		return
+	}
+	fileHeader, err := c.FormFile("file")
+	if err != nil {
+		openAIErrorResponse(c, http.StatusBadRequest, "invalid_file", "file is required")
+		return
+	}
+	maxBytes := int64(setting.MaxFileSizeMB) * 1024 * 1024
+	if fileHeader.Size > maxBytes {
+		openAIErrorResponse(c, http.StatusBadRequest, "file_too_large", fmt.Sprintf("File size exceeds maximum allowed size: %dMB", setting.MaxFileSizeMB))
+		return
+	}
+	reader, err := fileHeader.Open()
+	if err != nil {
+		openAIErrorResponse(c, http.StatusBadRequest, "invalid_file", err.Error())
+		return
+	}
+	defer reader.Close()
+
+	file := &model.File{
+		Id:          "file-" + common.GetRandomString(24),
+		UserId:      c.GetInt("id"),
+		TokenId:     c.GetInt("token_id"),
+		Filename:    fileHeader.Filename,
+		Purpose:     purpose,
+		Status:      "processed",
+		CreatedTime: common.GetTimestamp(),
+	}
+	file.Bytes, err = service.SaveStoredFile(file.Id, reader, maxBytes)
+	if err != nil {
+		openAIErrorResponse(c, http.StatusBadRequest, "save_file_failed", err.Error())
+		return
+	}
+	if err = file.Insert(); err != nil {
+		_ = service.DeleteStoredFile(file.Id)
+		openAIErrorResponse(c, http.StatusInternalServerError, "save_file_failed", err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, file.ToOpenAIFile())
+}
+
+func ListFiles(c *gin.Context) {
+	limit := getListLimit(c)
+	files, err := model.GetUserFiles(c.GetInt("id"), c.Query("purpose"), c.Query("after"), limit+1)
+	if err != nil {
+		openAIErrorResponse(c, http.StatusInternalServerError, "list_files_failed", err.Error())
+		return
+	}
+	hasMore := len(files) > limit
+	if hasMore {
+		files = files[:limit]
+	}
+	data := make([]*dto.OpenAIFile, 0, len(files))
+	for _, file := range files {
+		data = append(data, file.ToOpenAIFile())
+	}
+	list := dto.OpenAIList{Object: "list", Data: data, HasMore: hasMore}
+	if len(files) > 0 {
+		list.FirstId = files[0].Id
+		list.LastId = files[len(files)-1].Id
+	}
+	c.JSON(http.StatusOK, list)
+}
+
+func RetrieveFile(c *gin.Context) {
+	file, err := model.GetFileByIdAndUserId(c.Param("id"), c.GetInt("id"))
+	if err != nil {
+		openAIErrorResponse(c, http.StatusNotFound, "file_not_found", err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, file.ToOpenAIFile())
+}
+
+func DeleteFile(c *gin.Context) {
+	file, err := model.GetFileByIdAndUserId(c.Param("id"), c.GetInt("id"))
+	if err != nil {
+		openAIErrorResponse(c, http.StatusNotFound, "file_not_found", err.Error())
+		return
+	}
+	if err = file.Delete(); err != nil {
+		openAIErrorResponse(c, http.StatusInternalServerError, "delete_file_failed", err.Error())
+		return
+	}
+	if err = service.DeleteStoredFile(file.Id); err != nil {
+		common.SysError(fmt.Sprintf("failed to delete stored file %s: %s", file.Id, err.Error()))
+	}
+	c.JSON(http.StatusOK, dto.OpenAIFileDeleted{Id: file.Id, Object: "file", Deleted: true})
+}
+
+func RetrieveFileContent(c *gin.Context) {
+	file, err := model.GetFileByIdAndUserId(c.Param("id"), c.GetInt("id"))
+	if err != nil {
+		openAIErrorResponse(c, http.StatusNotFound, "file_not_found", err.Error())
+		return
+	}
+	reader, err := service.OpenStoredFile(file.Id)
+	if err != nil {
+		openAIErrorResponse(c, http.StatusInternalServerError, "read_file_failed", err.Error())
+		return
+	}
+	defer reader.Close()
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", file.Filename))
+	c.DataFromReader(http.StatusOK, file.Bytes, "application/octet-stream", reader, nil)
+}
@@ -0,0 +1,250 @@
+package controller
+
+import (
+	"net/http"
+	"one-api/common"
+	"one-api/model"
+	"one-api/service"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetHistoryArchives 获取对话历史归档列表
+func GetHistoryArchives(c *gin.Context) {
+	p, _ := strconv.Atoi(c.Query("p"))
+	pageSize, _ := strconv.Atoi(c.Query("page_size"))
+	if p < 1 {
+		p = 1
+	}
+	if pageSize <= 0 {
+		pageSize = common.ItemsPerPage
+	}
+	archives, total, err := model.GetHistoryArchives(c.Query("base_table"), (p-1)*pageSize, pageSize)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data": gin.H{
+			"items":     archives,
+			"total":     total,
+			"page":      p,
+			"page_size": pageSize,
+		},
+	})
+}
+
+type archiveHistoryRequest struct {
+	Date string `json:"date"` // 2006-01-02
+}
+
+// ArchiveHistoryPartitions 立即归档指定日期的对话历史分区
+func ArchiveHistoryPartitions(c *gin.Context) {
+	var req archiveHistoryRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "无效的参数",
+		})
+		return
+	}
+	date, err := time.ParseInLocation("2006-01-02", req.Date, time.Local)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "无效的日期，格式应为 2006-01-02",
+		})
+		return
+	}
+	now := time.Now()
+	if !date.Before(time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.Local)) {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "只能归档今天之前的分区",
+		})
+		return
+	}
+	archives, err := service.ArchiveMESPartitionsByDate(date)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "归档失败: " + err.Error(),
+			"data":    archives,
+		})
+		return
+	}
+	if len(archives) == 0 {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "该日期没有可归档的分区",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    archives,
+	})
+}
+
+// RestoreHistoryArchive 将归档恢复到对话历史数据库
+func RestoreHistoryArchive(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "无效的归档ID",
+		})
+		return
+	}
+	archive, err := model.GetHistoryArchiveById(id)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "归档不存在",
+		})
+		return
+	}
+	rows, err := service.RestoreHistoryArchive(archive)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "恢复失败: " + err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data": gin.H{
+			"archive":       archive,
+			"restored_rows": rows,
+		},
+	})
+}
+
+// GetConversationDedupMigration 获取对话历史去重迁移进度
+func GetConversationDedupMigration(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    service.GetConversationDedupMigration(),
+	})
+}
+
+type historyPartitionDateRequest struct {
+	Date string `json:"date"` // 2006-01-02，为空时处理所有分区
+}
+
+// StartConversationDedupMigration 将已有分区中的对话历史转换为去重存储
+func StartConversationDedupMigration(c *gin.Context) {
+	var req historyPartitionDateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "无效的参数",
+		})
+		return
+	}
+	var date time.Time
+	if req.Date != "" {
+		var err error
+		date, err = time.ParseInLocation("2006-01-02", req.Date, time.Local)
+		if err != nil {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": "无效的日期，格式应为 2006-01-02",
+			})
+			return
+		}
+	}
+	if err := service.StartConversationDedupMigration(date); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    service.GetConversationDedupMigration(),
+	})
+}
+
+// GetConversationSearchIndexJob 获取重建对话历史检索索引的进度
+func GetConversationSearchIndexJob(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    service.GetConversationSearchIndexJob(),
+	})
+}
+
+// StartConversationSearchIndexJob 为已有分区中的对话历史重建检索索引
+func StartConversationSearchIndexJob(c *gin.Context) {
+	var req historyPartitionDateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "无效的参数",
+		})
+		return
+	}
+	var date time.Time
+	if req.Date != "" {
+		var err error
+		date, err = time.ParseInLocation("2006-01-02", req.Date, time.Local)
+		if err != nil {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": "无效的日期，格式应为 2006-01-02",
+			})
+			return
+		}
+	}
+	if err := service.StartConversationSearchIndexJob(date); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    service.GetConversationSearchIndexJob(),
+	})
+}
+
+// GetHistoryKeyRotation 获取对话历史密钥轮换进度
+func GetHistoryKeyRotation(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    service.GetHistoryKeyRotation(),
+	})
+}
+
+// StartHistoryKeyRotation 使用当前密钥重新加密对话历史，关闭加密时解密为明文
+func StartHistoryKeyRotation(c *gin.Context) {
+	if err := service.StartHistoryKeyRotation(); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    service.GetHistoryKeyRotation(),
+	})
+}
@@ -17,6 +17,12 @@ import (
 	"time"
 )
 
+// midjourneyTaskKey 轮询任务时的分组依据
+type midjourneyTaskKey struct {
+	channelId int
+	keyIndex  int
+}
+
 func UpdateMidjourneyTaskBulk() {
 	//imageModel := "midjourney"
 	ctx := context.TODO()
@@ -29,7 +35,8 @@ func UpdateMidjourneyTaskBulk() {
 		}
 
 		common.LogInfo(ctx, fmt.Sprintf("检测到未完成的任务数有: %v", len(tasks)))
-		taskChannelM := make(map[int][]string)
+		// 多密钥渠道的任务只能使用提交时的密钥查询，按渠道和密钥分组
+		taskChannelM := make(map[midjourneyTaskKey][]string)
 		taskM := make(map[string]*model.Midjourney)
 		nullTaskIds := make([]int, 0)
 		for _, task := range tasks {
@@ -39,7 +46,8 @@ func UpdateMidjourneyTaskBulk() {
 				continue
 			}
 			taskM[task.MjId] = task
-			taskChannelM[task.ChannelId] = append(taskChannelM[task.ChannelId], task.MjId)
+			taskKey := midjourneyTaskKey{channelId: task.ChannelId, keyIndex: task.KeyIndex}
+			taskChannelM[taskKey] = append(taskChannelM[taskKey], task.MjId)
 		}
 		if len(nullTaskIds) > 0 {
 			err := model.MjBulkUpdateByTaskIds(nullTaskIds, map[string]any{
@@ -56,7 +64,8 @@ func UpdateMidjourneyTaskBulk() {
 			continue
 		}
 
-		for channelId, taskIds := range taskChannelM {
+		for taskKey, taskIds := range taskChannelM {
+			channelId := taskKey.channelId
 			common.LogInfo(ctx, fmt.Sprintf("渠道 #%d 未完成的任务有: %d", channelId, len(taskIds)))
 			if len(taskIds) == 0 {
 				continue
@@ -74,6 +83,11 @@ func UpdateMidjourneyTaskBulk() {
 				}
 				continue
 			}
+			key, err := midjourneyChannel.GetKeyByIndex(taskKey.keyIndex)
+			if err != nil {
+				common.LogError(ctx, fmt.Sprintf("渠道 #%d 获取密钥失败: %v", channelId, err))
+				continue
+			}
 			requestUrl := fmt.Sprintf("%s/mj/task/list-by-condition", *midjourneyChannel.BaseURL)
 
 			body, _ := json.Marshal(map[string]any{
@@ -90,7 +104,7 @@ func UpdateMidjourneyTaskBulk() {
 			// 使用带有超时的 context 创建新的请求
 			req = req.WithContext(ctx)
 			req.Header.Set("Content-Type", "application/json")
-			req.Header.Set("mj-api-secret", midjourneyChannel.Key)
+			req.Header.Set("mj-api-secret", key)
 			resp, err := service.GetHttpClient().Do(req)
 			if err != nil {
 				common.LogError(ctx, fmt.Sprintf("Get Task Do req error: %v", err))
@@ -158,7 +172,10 @@ func UpdateMidjourneyTaskBulk() {
 					common.LogError(ctx, "UpdateMidjourneyTask task error: "+err.Error())
 				} else {
 					if shouldReturnQuota {
-						err = model.IncreaseUserQuota(task.UserId, task.Quota, false)
+						err = model.IncreaseWalletQuota(task.UserId, task.OrganizationId, task.Quota, model.QuotaMovement{
+							Reason:    model.QuotaReasonRefund,
+							RequestId: "mj:" + task.MjId,
+						})
 						if err != nil {
 							common.LogError(ctx, "fail to increase user quota: "+err.Error())
 						}
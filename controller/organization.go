@@ -0,0 +1,694 @@
+package controller
+
+import (
+	"fmt"
+	"net/http"
+	"one-api/common"
+	"one-api/model"
+	"sort"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// getOrganizationMember 解析路径中的组织 ID，并检查当前用户是否为组织成员，失败时已写入响应
+func getOrganizationMember(c *gin.Context) (*model.OrganizationMember, bool) {
+	organizationId, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "无效的组织ID",
+		})
+		return nil, false
+	}
+	member, err := model.GetOrganizationMember(organizationId, c.GetInt("id"))
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "组织不存在或不是该组织的成员",
+		})
+		return nil, false
+	}
+	return member, true
+}
+
+// getOrganizationOwner 同 getOrganizationMember，同时要求当前用户是组织所有者
+func getOrganizationOwner(c *gin.Context) (*model.OrganizationMember, bool) {
+	member, ok := getOrganizationMember(c)
+	if !ok {
+		return nil, false
+	}
+	if member.Role != model.OrganizationRoleOwner {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "只有组织所有者可以进行此操作",
+		})
+		return nil, false
+	}
+	return member, true
+}
+
+// getOrganizationBillingAdmin 同 getOrganizationMember，同时要求当前用户是组织所有者或财务管理员
+func getOrganizationBillingAdmin(c *gin.Context) (*model.OrganizationMember, bool) {
+	member, ok := getOrganizationMember(c)
+	if !ok {
+		return nil, false
+	}
+	if !member.CanManageBilling() {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "只有组织所有者或财务管理员可以进行此操作",
+		})
+		return nil, false
+	}
+	return member, true
+}
+
+// GetAllOrganizations 管理员查询所有组织
+func GetAllOrganizations(c *gin.Context) {
+	pageInfo, err := common.GetPageQuery(c)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "parse page query failed",
+		})
+		return
+	}
+	organizations, total, err := model.GetAllOrganizations(pageInfo.GetStartIdx(), pageInfo.GetPageSize())
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	pageInfo.SetTotal(int(total))
+	pageInfo.SetItems(organizations)
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    pageInfo,
+	})
+}
+
+type organizationQuotaRequest struct {
+	Quota  int    `json:"quota"`
+	Remark string `json:"remark"`
+}
+
+// UpdateOrganizationQuota 管理员直接设置组织钱包余额
+func UpdateOrganizationQuota(c *gin.Context) {
+	organizationId, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "无效的组织ID",
+		})
+		return
+	}
+	var req organizationQuotaRequest
+	if err = c.ShouldBindJSON(&req); err != nil || req.Quota < 0 {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "无效的参数",
+		})
+		return
+	}
+	organization, err := model.GetOrganizationById(organizationId)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "组织不存在",
+		})
+		return
+	}
+	if req.Remark == "" {
+		req.Remark = "管理员修改组织余额"
+	}
+	if err = model.SetOrganizationQuota(organization.Id, req.Quota, req.Remark); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	common.SysLog(fmt.Sprintf("admin %d changed quota of organization %d from %d to %d", c.GetInt("id"), organization.Id, organization.Quota, req.Quota))
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+	})
+}
+
+// GetSelfOrganizations 当前用户所在的组织
+func GetSelfOrganizations(c *gin.Context) {
+	organizations, err := model.GetUserOrganizations(c.GetInt("id"))
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    organizations,
+	})
+}
+
+type organizationRequest struct {
+	Name string `json:"name"`
+}
+
+func parseOrganizationName(c *gin.Context) (string, bool) {
+	var req organizationRequest
+	if err := c.ShouldBindJSON(&req); err != nil || req.Name == "" {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "组织名称不能为空",
+		})
+		return "", false
+	}
+	if len(req.Name) > 64 {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "组织名称过长",
+		})
+		return "", false
+	}
+	return req.Name, true
+}
+
+// CreateOrganization 创建组织，创建者成为所有者
+func CreateOrganization(c *gin.Context) {
+	name, ok := parseOrganizationName(c)
+	if !ok {
+		return
+	}
+	organization, err := model.CreateOrganization(name, c.GetInt("id"))
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    organization,
+	})
+}
+
+// GetOrganization 组织信息和当前用户的成员信息
+func GetOrganization(c *gin.Context) {
+	member, ok := getOrganizationMember(c)
+	if !ok {
+		return
+	}
+	organization, err := model.GetOrganizationById(member.OrganizationId)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	member.RefreshSpending()
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data": gin.H{
+			"organization": organization,
+			"member":       member,
+		},
+	})
+}
+
+// UpdateOrganization 修改组织名称
+func UpdateOrganization(c *gin.Context) {
+	member, ok := getOrganizationOwner(c)
+	if !ok {
+		return
+	}
+	name, ok := parseOrganizationName(c)
+	if !ok {
+		return
+	}
+	organization := &model.Organization{Id: member.OrganizationId, Name: name}
+	if err := organization.Update(); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+	})
+}
+
+// DeleteOrganization 删除组织，组织钱包中还有余额时不能删除
+func DeleteOrganization(c *gin.Context) {
+	member, ok := getOrganizationOwner(c)
+	if !ok {
+		return
+	}
+	quota, err := model.GetOrganizationQuota(member.OrganizationId)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	if quota != 0 {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "组织钱包中还有余额，无法删除",
+		})
+		return
+	}
+	if err = model.DeleteOrganization(member.OrganizationId); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+	})
+}
+
+// GetOrganizationMembers 组织成员列表，所有成员都可以查看
+func GetOrganizationMembers(c *gin.Context) {
+	member, ok := getOrganizationMember(c)
+	if !ok {
+		return
+	}
+	members, err := model.GetOrganizationMembers(member.OrganizationId)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	for _, m := range members {
+		m.RefreshSpending()
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    members,
+	})
+}
+
+type organizationMemberRequest struct {
+	UserId         int    `json:"user_id"`
+	Username       string `json:"username"` // 添加成员时可以使用用户名代替用户 ID
+	Role           string `json:"role"`
+	SpendingPeriod string `json:"spending_period"`
+	SpendingLimit  int    `json:"spending_limit"`
+}
+
+// AddOrganizationMember 添加成员，只有所有者可以操作
+func AddOrganizationMember(c *gin.Context) {
+	owner, ok := getOrganizationOwner(c)
+	if !ok {
+		return
+	}
+	var req organizationMemberRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "无效的参数",
+		})
+		return
+	}
+	if req.Role == "" {
+		req.Role = model.OrganizationRoleDeveloper
+	}
+	if !model.IsValidOrganizationRole(req.Role) {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "无效的成员角色",
+		})
+		return
+	}
+	if req.UserId == 0 && req.Username != "" {
+		req.UserId, _ = model.GetUserIdByUsername(req.Username)
+	}
+	if req.UserId == 0 {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "用户不存在",
+		})
+		return
+	}
+	if _, err := model.GetUserById(req.UserId, false); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "用户不存在",
+		})
+		return
+	}
+	if _, err := model.GetOrganizationMember(owner.OrganizationId, req.UserId); err == nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "该用户已经是组织成员",
+		})
+		return
+	}
+	member := &model.OrganizationMember{
+		OrganizationId: owner.OrganizationId,
+		UserId:         req.UserId,
+		Role:           req.Role,
+		SpendingPeriod: req.SpendingPeriod,
+		SpendingLimit:  req.SpendingLimit,
+	}
+	if err := member.ValidateSpending(); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	if err := member.Insert(); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    member,
+	})
+}
+
+// UpdateOrganizationMember 修改成员角色和消费上限。所有者可以修改角色，所有者和财务管理员可以修改消费上限。
+func UpdateOrganizationMember(c *gin.Context) {
+	operator, ok := getOrganizationBillingAdmin(c)
+	if !ok {
+		return
+	}
+	var req organizationMemberRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "无效的参数",
+		})
+		return
+	}
+	member, err := model.GetOrganizationMember(operator.OrganizationId, req.UserId)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "成员不存在",
+		})
+		return
+	}
+	if req.Role != "" && req.Role != member.Role {
+		if operator.Role != model.OrganizationRoleOwner {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": "只有组织所有者可以修改成员角色",
+			})
+			return
+		}
+		if !model.IsValidOrganizationRole(req.Role) {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": "无效的成员角色",
+			})
+			return
+		}
+		if member.Role == model.OrganizationRoleOwner {
+			if count, _ := model.CountOrganizationOwners(member.OrganizationId); count <= 1 {
+				c.JSON(http.StatusOK, gin.H{
+					"success": false,
+					"message": "组织至少需要一个所有者",
+				})
+				return
+			}
+		}
+		member.Role = req.Role
+	}
+	member.SpendingPeriod = req.SpendingPeriod
+	member.SpendingLimit = req.SpendingLimit
+	if err = member.ValidateSpending(); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	if err = member.Update(); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    member,
+	})
+}
+
+// RemoveOrganizationMember 移除成员，所有者可以移除任何成员，其他成员只能退出组织。
+// 成员被移除后，其创建的组织令牌无法继续使用。
+func RemoveOrganizationMember(c *gin.Context) {
+	operator, ok := getOrganizationMember(c)
+	if !ok {
+		return
+	}
+	userId, err := strconv.Atoi(c.Param("user_id"))
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "无效的用户ID",
+		})
+		return
+	}
+	if userId != operator.UserId && operator.Role != model.OrganizationRoleOwner {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "只有组织所有者可以移除其他成员",
+		})
+		return
+	}
+	member, err := model.GetOrganizationMember(operator.OrganizationId, userId)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "成员不存在",
+		})
+		return
+	}
+	if member.Role == model.OrganizationRoleOwner {
+		if count, _ := model.CountOrganizationOwners(member.OrganizationId); count <= 1 {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": "组织至少需要一个所有者",
+			})
+			return
+		}
+	}
+	if err = model.RemoveOrganizationMember(member.OrganizationId, userId); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+	})
+}
+
+// TransferQuotaToOrganization 将当前用户的余额转入组织钱包
+func TransferQuotaToOrganization(c *gin.Context) {
+	member, ok := getOrganizationBillingAdmin(c)
+	if !ok {
+		return
+	}
+	var req organizationQuotaRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "无效的参数",
+		})
+		return
+	}
+	if err := model.TransferUserQuotaToOrganization(member.UserId, member.OrganizationId, req.Quota); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	model.RecordLog(member.UserId, model.LogTypeManage, fmt.Sprintf("向组织 #%d 钱包转入 %s", member.OrganizationId, common.LogQuota(req.Quota)))
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+	})
+}
+
+// organizationUsageSummary 用量按某个维度汇总后的结果
+type organizationUsageSummary struct {
+	Key              string `json:"key"`
+	Quota            int    `json:"quota"`
+	RequestCount     int    `json:"request_count"`
+	PromptTokens     int    `json:"prompt_tokens"`
+	CompletionTokens int    `json:"completion_tokens"`
+}
+
+func summarizeOrganizationUsage(usages []*model.OrganizationUsage, key func(usage *model.OrganizationUsage) string) []*organizationUsageSummary {
+	summaryMap := make(map[string]*organizationUsageSummary)
+	var summaries []*organizationUsageSummary
+	for _, usage := range usages {
+		k := key(usage)
+		summary, ok := summaryMap[k]
+		if !ok {
+			summary = &organizationUsageSummary{Key: k}
+			summaryMap[k] = summary
+			summaries = append(summaries, summary)
+		}
+		summary.Quota += usage.Quota
+		summary.RequestCount += usage.RequestCount
+		summary.PromptTokens += usage.PromptTokens
+		summary.CompletionTokens += usage.CompletionTokens
+	}
+	sort.SliceStable(summaries, func(i, j int) bool {
+		return summaries[i].Quota > summaries[j].Quota
+	})
+	return summaries
+}
+
+// GetOrganizationUsage 组织令牌的用量报表，按成员和模型汇总
+func GetOrganizationUsage(c *gin.Context) {
+	member, ok := getOrganizationBillingAdmin(c)
+	if !ok {
+		return
+	}
+	startTimestamp, _ := strconv.ParseInt(c.Query("start_timestamp"), 10, 64)
+	endTimestamp, _ := strconv.ParseInt(c.Query("end_timestamp"), 10, 64)
+	usages, err := model.GetOrganizationUsage(member.OrganizationId, startTimestamp, endTimestamp)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	total := &organizationUsageSummary{Key: "total"}
+	for _, usage := range usages {
+		total.Quota += usage.Quota
+		total.RequestCount += usage.RequestCount
+		total.PromptTokens += usage.PromptTokens
+		total.CompletionTokens += usage.CompletionTokens
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data": gin.H{
+			"total": total,
+			"by_member": summarizeOrganizationUsage(usages, func(usage *model.OrganizationUsage) string {
+				return usage.Username
+			}),
+			"by_model": summarizeOrganizationUsage(usages, func(usage *model.OrganizationUsage) string {
+				return usage.ModelName
+			}),
+			"items": usages,
+		},
+	})
+}
+
+// GetOrganizationTokens 组织令牌列表，不包含令牌密钥
+func GetOrganizationTokens(c *gin.Context) {
+	member, ok := getOrganizationBillingAdmin(c)
+	if !ok {
+		return
+	}
+	pageInfo, err := common.GetPageQuery(c)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "parse page query failed",
+		})
+		return
+	}
+	tokens, total, err := model.GetOrganizationTokens(member.OrganizationId, pageInfo.GetStartIdx(), pageInfo.GetPageSize())
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	for _, token := range tokens {
+		token.RefreshBudgetUsage()
+	}
+	pageInfo.SetTotal(int(total))
+	pageInfo.SetItems(tokens)
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    pageInfo,
+	})
+}
+
+// DeleteOrganizationToken 删除任意成员创建的组织令牌
+func DeleteOrganizationToken(c *gin.Context) {
+	member, ok := getOrganizationBillingAdmin(c)
+	if !ok {
+		return
+	}
+	tokenId, err := strconv.Atoi(c.Param("token_id"))
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "无效的令牌ID",
+		})
+		return
+	}
+	if err = model.DeleteOrganizationToken(member.OrganizationId, tokenId); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+	})
+}
+
+// GetOrganizationLedgers 组织钱包的额度变动
+func GetOrganizationLedgers(c *gin.Context) {
+	member, ok := getOrganizationBillingAdmin(c)
+	if !ok {
+		return
+	}
+	pageInfo, err := common.GetPageQuery(c)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "parse page query failed",
+		})
+		return
+	}
+	filter := parseQuotaLedgerFilter(c, pageInfo)
+	filter.AccountType = model.QuotaAccountOrganization
+	filter.AccountId = member.OrganizationId
+	respondQuotaLedgers(c, pageInfo, filter)
+}
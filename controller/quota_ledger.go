@@ -0,0 +1,100 @@
+package controller
+
+import (
+	"net/http"
+	"one-api/common"
+	"one-api/model"
+	"one-api/service"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+func parseQuotaLedgerFilter(c *gin.Context, pageInfo *common.PageInfo) model.QuotaLedgerFilter {
+	userId, _ := strconv.Atoi(c.Query("user_id"))
+	tokenId, _ := strconv.Atoi(c.Query("token_id"))
+	accountId, _ := strconv.Atoi(c.Query("account_id"))
+	return model.QuotaLedgerFilter{
+		UserId:         userId,
+		TokenId:        tokenId,
+		AccountType:    c.Query("account_type"),
+		AccountId:      accountId,
+		Reason:         c.Query("reason"),
+		RequestId:      c.Query("request_id"),
+		StartTimestamp: pageInfo.StartTimestamp,
+		EndTimestamp:   pageInfo.EndTimestamp,
+	}
+}
+
+func respondQuotaLedgers(c *gin.Context, pageInfo *common.PageInfo, filter model.QuotaLedgerFilter) {
+	ledgers, total, err := model.GetQuotaLedgers(filter, pageInfo.GetStartIdx(), pageInfo.GetPageSize())
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	pageInfo.SetTotal(int(total))
+	pageInfo.SetItems(ledgers)
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    pageInfo,
+	})
+}
+
+// GetQuotaLedgers 管理员查询额度账本，可按用户、令牌、原因、请求 ID 和时间过滤
+func GetQuotaLedgers(c *gin.Context) {
+	pageInfo, err := common.GetPageQuery(c)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "parse page query failed",
+		})
+		return
+	}
+	respondQuotaLedgers(c, pageInfo, parseQuotaLedgerFilter(c, pageInfo))
+}
+
+// GetUserQuotaLedgers 用户查询自己的额度变动
+func GetUserQuotaLedgers(c *gin.Context) {
+	pageInfo, err := common.GetPageQuery(c)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "parse page query failed",
+		})
+		return
+	}
+	filter := parseQuotaLedgerFilter(c, pageInfo)
+	filter.UserId = c.GetInt("id")
+	respondQuotaLedgers(c, pageInfo, filter)
+}
+
+// GetQuotaReconciliation 获取最近一次对账结果
+func GetQuotaReconciliation(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    service.GetQuotaReconciliationReport(),
+	})
+}
+
+// ReconcileQuotaLedger 立即对账并返回结果
+func ReconcileQuotaLedger(c *gin.Context) {
+	report, err := service.ReconcileQuotaLedger()
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+			"data":    report,
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    report,
+	})
+}
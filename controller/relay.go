@@ -18,6 +18,7 @@ import (
 	"one-api/relay/helper"
 	"one-api/service"
 	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/gorilla/websocket"
@@ -50,6 +51,7 @@ func relayHandler(c *gin.Context, relayMode int) *dto.OpenAIErrorWithStatusCode
 }
 
 func Relay(c *gin.Context) {
+	middleware.SetRateLimitHeaders(c)
 	relayMode := constant.Path2RelayMode(c.Request.URL.Path)
 	requestId := c.GetString(common.RequestIdKey)
 	group := c.GetString("group")
@@ -61,6 +63,10 @@ func Relay(c *gin.Context) {
 		if err != nil {
 			common.LogError(c, err.Error())
 			openaiErr = service.OpenAIErrorWrapperLocal(err, "get_channel_failed", http.StatusInternalServerError)
+			// 渠道没有可用密钥时改选其他渠道
+			if errors.Is(err, model.ErrChannelNoEnabledKey) {
+				continue
+			}
 			break
 		}
 
@@ -74,10 +80,25 @@ func Relay(c *gin.Context) {
 			continue // 跳过此渠道，尝试下一个
 		}
 
+		// 检查渠道TPM和并发限制
+		releaseChannel, ok := acquireChannelLimits(c, channel)
+		if !ok {
+			openaiErr = service.OpenAIErrorWrapperLocal(errors.New("当前分组上游负载已饱和，请稍后再试"), "channel_rate_limited", http.StatusTooManyRequests)
+			continue
+		}
+
 		// 记录RPM使用次数
 		service.IncrementChannelRPMUsage(channel.Id)
 
-		openaiErr = relayRequest(c, relayMode, channel)
+		if i == 0 && shouldHedgeRequest(c, relayMode) {
+			// 对冲请求内部已记录各渠道的结果
+			openaiErr = hedgeRelayRequest(c, relayMode, channel, group, originalModel)
+		} else {
+			startTime := time.Now()
+			openaiErr = relayRequest(c, relayMode, channel)
+			recordChannelResult(c, channel, openaiErr, time.Since(startTime))
+		}
+		releaseChannel()
 
 		if openaiErr == nil {
 			return // 成功处理请求，直接返回
@@ -86,7 +107,7 @@ func Relay(c *gin.Context) {
 		// 检查是否需要立即禁用渠道并继续重试
 		if service.ShouldImmediatelyDisableAndRetry(openaiErr) && channel.GetAutoBan() {
 			// 立即禁用渠道
-			service.DisableChannel(channel.Id, channel.Name, fmt.Sprintf("立即禁用 - 状态码: %d, 错误: %s", openaiErr.StatusCode, openaiErr.Error.Message))
+			disableChannelOrKey(channel.Id, channel.Name, getChannelKeyIndex(c), fmt.Sprintf("立即禁用 - 状态码: %d, 错误: %s", openaiErr.StatusCode, openaiErr.Error.Message))
 			common.LogError(c, fmt.Sprintf("立即禁用渠道 #%d，状态码: %d，继续重试其他渠道", channel.Id, openaiErr.StatusCode))
 			// 记录立即禁用导致的重试
 			if i > 0 {
@@ -97,7 +118,7 @@ func Relay(c *gin.Context) {
 			continue
 		}
 
-		go processChannelError(c, channel.Id, channel.Type, channel.Name, channel.GetAutoBan(), openaiErr)
+		go processChannelError(c, channel.Id, channel.Type, channel.Name, getChannelKeyIndex(c), channel.GetAutoBan(), openaiErr)
 
 		if !shouldRetry(c, openaiErr, common.RetryTimes-i) {
 			break
@@ -122,7 +143,7 @@ func Relay(c *gin.Context) {
 	}
 
 	if openaiErr != nil {
-		if openaiErr.StatusCode == http.StatusTooManyRequests {
+		if openaiErr.StatusCode == http.StatusTooManyRequests && !openaiErr.LocalError {
 			common.LogError(c, fmt.Sprintf("origin 429 error: %s", openaiErr.Error.Message))
 			openaiErr.Error.Message = "当前分组上游负载已饱和，请稍后再试"
 		}
@@ -190,6 +211,10 @@ func WssRelay(c *gin.Context) {
 		if err != nil {
 			common.LogError(c, err.Error())
 			openaiErr = service.OpenAIErrorWrapperLocal(err, "get_channel_failed", http.StatusInternalServerError)
+			// 渠道没有可用密钥时改选其他渠道
+			if errors.Is(err, model.ErrChannelNoEnabledKey) {
+				continue
+			}
 			break
 		}
 
@@ -203,10 +228,20 @@ func WssRelay(c *gin.Context) {
 			continue // 跳过此渠道，尝试下一个
 		}
 
+		// 检查渠道TPM和并发限制
+		releaseChannel, ok := acquireChannelLimits(c, channel)
+		if !ok {
+			openaiErr = service.OpenAIErrorWrapperLocal(errors.New("当前分组上游负载已饱和，请稍后再试"), "channel_rate_limited", http.StatusTooManyRequests)
+			continue
+		}
+
 		// 记录RPM使用次数
 		service.IncrementChannelRPMUsage(channel.Id)
 
 		openaiErr = wssRequest(c, ws, relayMode, channel)
+		releaseChannel()
+		// 实时会话的耗时不代表上游延迟，只统计成功与否
+		recordChannelResult(c, channel, openaiErr, 0)
 
 		if openaiErr == nil {
 			return // 成功处理请求，直接返回
@@ -215,7 +250,7 @@ func WssRelay(c *gin.Context) {
 		// 检查是否需要立即禁用渠道并继续重试
 		if service.ShouldImmediatelyDisableAndRetry(openaiErr) && channel.GetAutoBan() {
 			// 立即禁用渠道
-			service.DisableChannel(channel.Id, channel.Name, fmt.Sprintf("立即禁用 - 状态码: %d, 错误: %s", openaiErr.StatusCode, openaiErr.Error.Message))
+			disableChannelOrKey(channel.Id, channel.Name, getChannelKeyIndex(c), fmt.Sprintf("立即禁用 - 状态码: %d, 错误: %s", openaiErr.StatusCode, openaiErr.Error.Message))
 			common.LogError(c, fmt.Sprintf("立即禁用渠道 #%d，状态码: %d，继续重试其他渠道", channel.Id, openaiErr.StatusCode))
 			// 记录立即禁用导致的重试
 			if i > 0 {
@@ -226,7 +261,7 @@ func WssRelay(c *gin.Context) {
 			continue
 		}
 
-		go processChannelError(c, channel.Id, channel.Type, channel.Name, channel.GetAutoBan(), openaiErr)
+		go processChannelError(c, channel.Id, channel.Type, channel.Name, getChannelKeyIndex(c), channel.GetAutoBan(), openaiErr)
 
 		if !shouldRetry(c, openaiErr, common.RetryTimes-i) {
 			break
@@ -251,7 +286,7 @@ func WssRelay(c *gin.Context) {
 	}
 
 	if openaiErr != nil {
-		if openaiErr.StatusCode == http.StatusTooManyRequests {
+		if openaiErr.StatusCode == http.StatusTooManyRequests && !openaiErr.LocalError {
 			openaiErr.Error.Message = "当前分组上游负载已饱和，请稍后再试"
 		}
 
@@ -286,6 +321,7 @@ func WssRelay(c *gin.Context) {
 }
 
 func RelayClaude(c *gin.Context) {
+	middleware.SetRateLimitHeaders(c)
 	//relayMode := constant.Path2RelayMode(c.Request.URL.Path)
 	requestId := c.GetString(common.RequestIdKey)
 	group := c.GetString("group")
@@ -297,6 +333,10 @@ func RelayClaude(c *gin.Context) {
 		if err != nil {
 			common.LogError(c, err.Error())
 			claudeErr = service.ClaudeErrorWrapperLocal(err, "get_channel_failed", http.StatusInternalServerError)
+			// 渠道没有可用密钥时改选其他渠道
+			if errors.Is(err, model.ErrChannelNoEnabledKey) {
+				continue
+			}
 			break
 		}
 
@@ -310,21 +350,32 @@ func RelayClaude(c *gin.Context) {
 			continue // 跳过此渠道，尝试下一个
 		}
 
+		// 检查渠道TPM和并发限制
+		releaseChannel, ok := acquireChannelLimits(c, channel)
+		if !ok {
+			claudeErr = service.ClaudeErrorWrapperLocal(errors.New("当前分组上游负载已饱和，请稍后再试"), "channel_rate_limited", http.StatusTooManyRequests)
+			continue
+		}
+
 		// 记录RPM使用次数
 		service.IncrementChannelRPMUsage(channel.Id)
 
+		startTime := time.Now()
 		claudeErr = claudeRequest(c, channel)
+		releaseChannel()
 
 		if claudeErr == nil {
+			recordChannelResult(c, channel, nil, time.Since(startTime))
 			return // 成功处理请求，直接返回
 		}
 
 		openaiErr := service.ClaudeErrorToOpenAIError(claudeErr)
+		recordChannelResult(c, channel, openaiErr, time.Since(startTime))
 
 		// 检查是否需要立即禁用渠道并继续重试
 		if service.ShouldImmediatelyDisableAndRetry(openaiErr) && channel.GetAutoBan() {
 			// 立即禁用渠道
-			service.DisableChannel(channel.Id, channel.Name, fmt.Sprintf("立即禁用 - 状态码: %d, 错误: %s", openaiErr.StatusCode, openaiErr.Error.Message))
+			disableChannelOrKey(channel.Id, channel.Name, getChannelKeyIndex(c), fmt.Sprintf("立即禁用 - 状态码: %d, 错误: %s", openaiErr.StatusCode, openaiErr.Error.Message))
 			common.LogError(c, fmt.Sprintf("立即禁用渠道 #%d，状态码: %d，继续重试其他渠道", channel.Id, openaiErr.StatusCode))
 			// 记录立即禁用导致的重试
 			if i > 0 {
@@ -335,7 +386,7 @@ func RelayClaude(c *gin.Context) {
 			continue
 		}
 
-		go processChannelError(c, channel.Id, channel.Type, channel.Name, channel.GetAutoBan(), openaiErr)
+		go processChannelError(c, channel.Id, channel.Type, channel.Name, getChannelKeyIndex(c), channel.GetAutoBan(), openaiErr)
 
 		if !shouldRetry(c, openaiErr, common.RetryTimes-i) {
 			break
@@ -398,6 +449,8 @@ func RelayClaude(c *gin.Context) {
 }
 
 func relayRequest(c *gin.Context, relayMode int, channel *model.Channel) *dto.OpenAIErrorWithStatusCode {
+	// 失败的请求没有实际用量，释放预占的 TPM
+	defer service.SettleTPM(c, 0)
 	addUsedChannel(c, channel.Id)
 	requestBody, _ := common.GetRequestBody(c)
 	c.Request.Body = io.NopCloser(bytes.NewBuffer(requestBody))
@@ -405,6 +458,8 @@ func relayRequest(c *gin.Context, relayMode int, channel *model.Channel) *dto.Op
 }
 
 func wssRequest(c *gin.Context, ws *websocket.Conn, relayMode int, channel *model.Channel) *dto.OpenAIErrorWithStatusCode {
+	// 失败的请求没有实际用量，释放预占的 TPM
+	defer service.SettleTPM(c, 0)
 	addUsedChannel(c, channel.Id)
 	requestBody, _ := common.GetRequestBody(c)
 	c.Request.Body = io.NopCloser(bytes.NewBuffer(requestBody))
@@ -412,6 +467,8 @@ func wssRequest(c *gin.Context, ws *websocket.Conn, relayMode int, channel *mode
 }
 
 func claudeRequest(c *gin.Context, channel *model.Channel) *dto.ClaudeErrorWithStatusCode {
+	// 失败的请求没有实际用量，释放预占的 TPM
+	defer service.SettleTPM(c, 0)
 	addUsedChannel(c, channel.Id)
 	requestBody, _ := common.GetRequestBody(c)
 	c.Request.Body = io.NopCloser(bytes.NewBuffer(requestBody))
@@ -442,7 +499,9 @@ func getChannel(c *gin.Context, group, originalModel string, retryCount int) (*m
 	if err != nil {
 		return nil, errors.New(fmt.Sprintf("获取重试渠道失败: %s", err.Error()))
 	}
-	middleware.SetupContextForSelectedChannel(c, channel, originalModel)
+	if err = middleware.SetupContextForSelectedChannel(c, channel, originalModel); err != nil {
+		return nil, err
+	}
 	return channel, nil
 }
 
@@ -478,7 +537,7 @@ func shouldRetry(c *gin.Context, openaiErr *dto.OpenAIErrorWithStatusCode, retry
 	return true
 }
 
-func processChannelError(c *gin.Context, channelId int, channelType int, channelName string, autoBan bool, err *dto.OpenAIErrorWithStatusCode) {
+func processChannelError(c *gin.Context, channelId int, channelType int, channelName string, keyIndex int, autoBan bool, err *dto.OpenAIErrorWithStatusCode) {
 	// 不要使用context获取渠道信息，异步处理时可能会出现渠道信息不一致的情况
 	// do not use context to get channel info, there may be inconsistent channel info when processing asynchronously
 
@@ -487,13 +546,85 @@ func processChannelError(c *gin.Context, channelId int, channelType int, channel
 		return
 	}
 
+	model.RecordChannelKeyError(channelId, keyIndex)
 	common.LogError(c, fmt.Sprintf("relay error (channel #%d, status code: %d): %s", channelId, err.StatusCode, err.Error.Message))
 	if service.ShouldDisableChannel(channelType, err) && autoBan {
-		service.DisableChannel(channelId, channelName, err.Error.Message)
+		disableChannelOrKey(channelId, channelName, keyIndex, err.Error.Message)
+	}
+}
+
+// acquireChannelLimits 检查渠道的TPM和并发限制，渠道已满时返回 false，成功时需要调用返回的函数释放并发名额
+func acquireChannelLimits(c *gin.Context, channel *model.Channel) (func(), bool) {
+	if service.IsChannelTPMExceeded(channel) {
+		common.LogInfo(c, fmt.Sprintf("渠道 #%d TPM限制超限，切换到其他渠道", channel.Id))
+		return nil, false
+	}
+	release, ok := service.AcquireChannelConcurrency(channel)
+	if !ok {
+		common.LogInfo(c, fmt.Sprintf("渠道 #%d 并发限制超限，切换到其他渠道", channel.Id))
+		return nil, false
+	}
+	return release, true
+}
+
+// recordChannelResult 将请求结果计入渠道熔断器和自适应权重统计，只有上游故障类错误才算失败
+func recordChannelResult(c *gin.Context, channel *model.Channel, openaiErr *dto.OpenAIErrorWithStatusCode, duration time.Duration) {
+	if openaiErr != nil && openaiErr.LocalError {
+		return
+	}
+	// 响应缓存命中时没有请求渠道
+	if c.GetBool(constant2.ContextKeyResponseCacheHit) {
+		return
+	}
+	success := openaiErr == nil || !isUpstreamFailure(openaiErr)
+	// 首字时间按本次尝试的开始时间计算
+	var firstToken time.Duration
+	value, _ := c.Get(constant2.ContextKeyFirstResponseAt)
+	if firstResponseAt, ok := value.(time.Time); ok && !firstResponseAt.IsZero() {
+		firstToken = firstResponseAt.Sub(time.Now().Add(-duration))
+		if firstToken < 0 {
+			firstToken = 0
+		}
+		c.Set(constant2.ContextKeyFirstResponseAt, time.Time{})
 	}
+	// 熔断器按首字时间判断慢请求，耗时较长但正常输出的流式响应不算失败；没有收到响应时使用整个请求的耗时
+	latency := duration
+	if firstToken > 0 {
+		latency = firstToken
+	}
+	model.RecordChannelBreakerResult(channel.Id, channel.Name, success, latency)
+	if duration <= 0 {
+		return
+	}
+	model.RecordChannelStats(channel.Id, success, firstToken, duration)
+}
+
+func isUpstreamFailure(openaiErr *dto.OpenAIErrorWithStatusCode) bool {
+	if openaiErr.Error.Type == "empty_response" {
+		return true
+	}
+	return openaiErr.StatusCode >= 500 || openaiErr.StatusCode == http.StatusTooManyRequests || openaiErr.StatusCode == http.StatusRequestTimeout
+}
+
+// getChannelKeyIndex 返回本次请求使用的密钥下标，非多密钥渠道返回 -1
+func getChannelKeyIndex(c *gin.Context) int {
+	if !c.GetBool("channel_multi_key") {
+		return -1
+	}
+	return c.GetInt("channel_key_index")
+}
+
+// disableChannelOrKey 多密钥渠道只禁用出错的密钥，否则禁用整个渠道
+func disableChannelOrKey(channelId int, channelName string, keyIndex int, reason string) {
+	if keyIndex >= 0 {
+		service.DisableChannelKey(channelId, channelName, keyIndex, reason)
+		return
+	}
+	service.DisableChannel(channelId, channelName, reason)
 }
 
 func RelayMidjourney(c *gin.Context) {
+	middleware.SetRateLimitHeaders(c)
 	relayMode := c.GetInt("relay_mode")
 	var err *dto.MidjourneyResponse
 	switch relayMode {
@@ -504,9 +635,13 @@ func RelayMidjourney(c *gin.Context) {
 	case relayconstant.RelayModeMidjourneyTaskImageSeed:
 		err = relay.RelayMidjourneyTaskImageSeed(c)
 	case relayconstant.RelayModeSwapFace:
-		err = relay.RelaySwapFace(c)
+		err = relayMidjourneyWithChannelLimits(c, func() *dto.MidjourneyResponse {
+			return relay.RelaySwapFace(c)
+		})
 	default:
-		err = relay.RelayMidjourneySubmit(c, relayMode)
+		err = relayMidjourneyWithChannelLimits(c, func() *dto.MidjourneyResponse {
+			return relay.RelayMidjourneySubmit(c, relayMode)
+		})
 	}
 	//err = relayMidjourneySubmit(c, relayMode)
 	log.Println(err)
@@ -526,6 +661,20 @@ func RelayMidjourney(c *gin.Context) {
 	}
 }
 
+// relayMidjourneyWithChannelLimits 提交任务前检查所选渠道的TPM和并发限制，渠道已满时按负载饱和返回
+func relayMidjourneyWithChannelLimits(c *gin.Context, submit func() *dto.MidjourneyResponse) *dto.MidjourneyResponse {
+	channel, err := model.CacheGetChannel(c.GetInt("channel_id"))
+	if err != nil {
+		return service.MidjourneyErrorWrapper(constant2.MjRequestError, "get_channel_info_failed")
+	}
+	releaseChannel, ok := acquireChannelLimits(c, channel)
+	if !ok {
+		return &dto.MidjourneyResponse{Code: 30, Description: "channel_rate_limited"}
+	}
+	defer releaseChannel()
+	return submit()
+}
+
 func RelayNotImplemented(c *gin.Context) {
 	err := dto.OpenAIError{
 		Message: "API not implemented",
@@ -551,6 +700,7 @@ func RelayNotFound(c *gin.Context) {
 }
 
 func RelayTask(c *gin.Context) {
+	middleware.SetRateLimitHeaders(c)
 	relayMode := c.GetInt("relay_mode")
 	group := c.GetString("group")
 	originalModel := c.GetString("original_model")
@@ -561,6 +711,10 @@ func RelayTask(c *gin.Context) {
 		if err != nil {
 			common.LogError(c, err.Error())
 			taskErr = service.TaskErrorWrapperLocal(err, "get_channel_failed", http.StatusInternalServerError)
+			// 渠道没有可用密钥时改选其他渠道
+			if errors.Is(err, model.ErrChannelNoEnabledKey) {
+				continue
+			}
 			break
 		}
 
@@ -569,7 +723,15 @@ func RelayTask(c *gin.Context) {
 		useChannel = append(useChannel, fmt.Sprintf("%d", channel.Id))
 		c.Set("use_channel", useChannel)
 
+		// 检查渠道TPM和并发限制
+		releaseChannel, ok := acquireChannelLimits(c, channel)
+		if !ok {
+			taskErr = service.TaskErrorWrapperLocal(errors.New("当前分组上游负载已饱和，请稍后再试"), "channel_rate_limited", http.StatusTooManyRequests)
+			continue
+		}
+
 		taskErr = taskRelayHandler(c, relayMode)
+		releaseChannel()
 
 		if taskErr == nil {
 			return // 成功处理请求，直接返回
@@ -578,7 +740,7 @@ func RelayTask(c *gin.Context) {
 		// 检查是否需要立即禁用渠道并继续重试
 		if service.ShouldImmediatelyDisableAndRetryTask(taskErr) && channel.GetAutoBan() {
 			// 立即禁用渠道
-			service.DisableChannel(channel.Id, channel.Name, fmt.Sprintf("立即禁用 - 状态码: %d, 错误: %s", taskErr.StatusCode, taskErr.Message))
+			disableChannelOrKey(channel.Id, channel.Name, getChannelKeyIndex(c), fmt.Sprintf("立即禁用 - 状态码: %d, 错误: %s", taskErr.StatusCode, taskErr.Message))
 			common.LogError(c, fmt.Sprintf("立即禁用渠道 #%d，状态码: %d，继续重试其他渠道", channel.Id, taskErr.StatusCode))
 			// 继续重试其他渠道
 			continue
@@ -0,0 +1,213 @@
+package controller
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"one-api/common"
+	constant2 "one-api/constant"
+	"one-api/dto"
+	"one-api/middleware"
+	"one-api/model"
+	relayconstant "one-api/relay/constant"
+	"one-api/service"
+	"one-api/setting/operation_setting"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type hedgeResult struct {
+	channel   *model.Channel
+	ctx       *gin.Context
+	recorder  *httptest.ResponseRecorder
+	err       *dto.OpenAIErrorWithStatusCode
+	cancelled bool
+}
+
+// shouldHedgeRequest 判断是否对本次请求使用对冲，仅支持启用了对冲的令牌或分组的非流式文本请求
+func shouldHedgeRequest(c *gin.Context, relayMode int) bool {
+	if !operation_setting.GetHedgeSetting().Enabled {
+		return false
+	}
+	if relayMode != relayconstant.RelayModeChatCompletions && relayMode != relayconstant.RelayModeCompletions {
+		return false
+	}
+	if _, ok := c.Get("specific_channel_id"); ok {
+		return false
+	}
+	// 批量任务是低优先级请求，不使用对冲
+	if c.GetString(constant2.ContextKeyBatchId) != "" {
+		return false
+	}
+	if !c.GetBool("token_hedge_enabled") && !operation_setting.IsHedgeEnabledForGroup(c.GetString("group")) {
+		return false
+	}
+	// 音频模型使用单独的计费流程，不参与对冲
+	if strings.HasPrefix(c.GetString("original_model"), "gpt-4o-audio") {
+		return false
+	}
+	var request struct {
+		Stream bool `json:"stream"`
+	}
+	if err := common.UnmarshalBodyReusable(c, &request); err != nil {
+		return false
+	}
+	return !request.Stream
+}
+
+// newHedgeContext 为对冲请求中的一个渠道创建独立的 gin.Context，响应先写入 recorder，胜出后再写回客户端
+func newHedgeContext(c *gin.Context, hedge *service.HedgeGroup, channelId int) (*gin.Context, *httptest.ResponseRecorder) {
+	recorder := httptest.NewRecorder()
+	ctx, _ := gin.CreateTestContext(recorder)
+	ctx.Request = c.Request.Clone(hedge.Add(c.Request.Context(), channelId))
+	requestBody, _ := common.GetRequestBody(c)
+	ctx.Request.Body = io.NopCloser(bytes.NewBuffer(requestBody))
+	ctx.Params = c.Params
+	for key, value := range c.Keys {
+		ctx.Set(key, value)
+	}
+	ctx.Set("use_channel", append([]string{}, c.GetStringSlice("use_channel")...))
+	ctx.Set(constant2.ContextKeyHedgeGroup, hedge)
+	return ctx, recorder
+}
+
+// getHedgeChannel 选择与主渠道同优先级的另一个渠道，与普通请求一样检查渠道的 RPM、TPM 和并发限制。
+// 成功时需要在对冲请求结束后调用返回的函数释放并发名额
+func getHedgeChannel(c *gin.Context, group string, originalModel string, primaryId int) (*model.Channel, func()) {
+	for i := 0; i < 3; i++ {
+		channel, _, err := model.CacheGetRandomSatisfiedChannel(c, group, originalModel, 0)
+		if err != nil || channel == nil {
+			return nil, nil
+		}
+		if channel.Id == primaryId || service.CheckChannelRPMLimit(channel.Id) {
+			continue
+		}
+		if release, ok := acquireChannelLimits(c, channel); ok {
+			return channel, release
+		}
+	}
+	return nil, nil
+}
+
+// hedgeRelayRequest 先向主渠道发起请求，超过延迟仍未完成时向同优先级的另一个渠道发起相同请求，
+// 使用最先成功的响应并取消其余请求。只有主渠道预扣费，被取消的请求及其估算的上游消耗记录在胜出渠道日志的 Other 中。
+// 全部失败时返回主渠道的错误，交给外层重试。
+func hedgeRelayRequest(c *gin.Context, relayMode int, channel *model.Channel, group string, originalModel string) *dto.OpenAIErrorWithStatusCode {
+	hedge := service.NewHedgeGroup()
+	defer hedge.CancelAll()
+	// 全部失败时释放主渠道预占的 TPM
+	defer hedge.ReleaseTPM()
+	usedChannel := c.GetStringSlice("use_channel")
+	results := make(chan *hedgeResult, 2)
+
+	// release 释放渠道的并发名额，主渠道的名额由外层释放
+	start := func(channel *model.Channel, ctx *gin.Context, recorder *httptest.ResponseRecorder, release func()) {
+		go func() {
+			result := &hedgeResult{channel: channel, ctx: ctx, recorder: recorder}
+			defer func() {
+				if r := recover(); r != nil {
+					common.SysError(fmt.Sprintf("hedge request panic (channel #%d): %v", channel.Id, r))
+					result.err = service.OpenAIErrorWrapperLocal(fmt.Errorf("%v", r), "hedge_request_panic", http.StatusInternalServerError)
+				}
+				// 被取消的请求在上游连接关闭后结束，同样释放名额
+				if release != nil {
+					release()
+				}
+				hedge.Finish(channel.Id)
+				results <- result
+			}()
+			startTime := time.Now()
+			result.err = relayRequest(ctx, relayMode, channel)
+			if result.err == nil {
+				result.cancelled = !hedge.Claim(channel.Id)
+			} else {
+				result.cancelled = hedge.Cancelled(channel.Id)
+			}
+			// 被取消的请求不代表渠道质量，不计入统计
+			if !result.cancelled {
+				recordChannelResult(ctx, channel, result.err, time.Since(startTime))
+			}
+		}()
+	}
+
+	primaryCtx, primaryRecorder := newHedgeContext(c, hedge, channel.Id)
+	start(channel, primaryCtx, primaryRecorder, nil)
+	usedChannel = append(usedChannel, fmt.Sprintf("%d", channel.Id))
+	pending := 1
+
+	timer := time.NewTimer(time.Duration(operation_setting.GetHedgeSetting().DelayMilliseconds) * time.Millisecond)
+	defer timer.Stop()
+
+	var primary *hedgeResult
+	for pending > 0 {
+		select {
+		case <-timer.C:
+			hedgeChannel, releaseHedgeChannel := getHedgeChannel(c, group, originalModel, channel.Id)
+			if hedgeChannel == nil {
+				common.LogInfo(c, "没有可用于对冲请求的其他渠道")
+				continue
+			}
+			hedgeCtx, hedgeRecorder := newHedgeContext(c, hedge, hedgeChannel.Id)
+			if err := middleware.SetupContextForSelectedChannel(hedgeCtx, hedgeChannel, originalModel); err != nil {
+				releaseHedgeChannel()
+				common.LogInfo(c, fmt.Sprintf("对冲渠道 #%d 不可用: %s", hedgeChannel.Id, err.Error()))
+				continue
+			}
+			service.IncrementChannelRPMUsage(hedgeChannel.Id)
+			start(hedgeChannel, hedgeCtx, hedgeRecorder, releaseHedgeChannel)
+			usedChannel = append(usedChannel, fmt.Sprintf("%d", hedgeChannel.Id))
+			pending++
+			common.LogInfo(c, fmt.Sprintf("渠道 #%d 超过 %dms 未完成，向渠道 #%d 发起对冲请求", channel.Id, operation_setting.GetHedgeSetting().DelayMilliseconds, hedgeChannel.Id))
+		case result := <-results:
+			pending--
+			if result.err == nil && !result.cancelled {
+				writeHedgeResult(c, result, usedChannel)
+				return nil
+			}
+			if result.channel.Id == channel.Id {
+				primary = result
+				continue
+			}
+			if result.err != nil && !result.cancelled {
+				processHedgeChannelError(result)
+			}
+		}
+	}
+
+	// 全部失败，使用主渠道的上下文交给外层处理错误和重试
+	mergeHedgeContext(c, primary.ctx, usedChannel)
+	return primary.err
+}
+
+func processHedgeChannelError(result *hedgeResult) {
+	channel := result.channel
+	if service.ShouldImmediatelyDisableAndRetry(result.err) && channel.GetAutoBan() {
+		disableChannelOrKey(channel.Id, channel.Name, getChannelKeyIndex(result.ctx), fmt.Sprintf("立即禁用 - 状态码: %d, 错误: %s", result.err.StatusCode, result.err.Error.Message))
+		return
+	}
+	go processChannelError(result.ctx, channel.Id, channel.Type, channel.Name, getChannelKeyIndex(result.ctx), channel.GetAutoBan(), result.err)
+}
+
+func mergeHedgeContext(c *gin.Context, ctx *gin.Context, usedChannel []string) {
+	for key, value := range ctx.Keys {
+		if key == constant2.ContextKeyHedgeGroup {
+			continue
+		}
+		c.Set(key, value)
+	}
+	c.Set("use_channel", usedChannel)
+}
+
+// writeHedgeResult 将胜出渠道的响应写回客户端
+func writeHedgeResult(c *gin.Context, result *hedgeResult, usedChannel []string) {
+	mergeHedgeContext(c, result.ctx, usedChannel)
+	for key, values := range result.recorder.Header() {
+		c.Writer.Header()[key] = values
+	}
+	c.Writer.WriteHeader(result.recorder.Code)
+	_, _ = c.Writer.Write(result.recorder.Body.Bytes())
+}
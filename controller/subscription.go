@@ -0,0 +1,233 @@
+package controller
+
+import (
+	"net/http"
+	"one-api/common"
+	"one-api/model"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetSubscriptionPlans 用户可以订阅的套餐
+func GetSubscriptionPlans(c *gin.Context) {
+	plans, err := model.GetSubscriptionPlans(true)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    plans,
+	})
+}
+
+// GetAllSubscriptionPlans 管理员查询所有套餐，包括已停用的套餐
+func GetAllSubscriptionPlans(c *gin.Context) {
+	plans, err := model.GetSubscriptionPlans(false)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    plans,
+	})
+}
+
+func AddSubscriptionPlan(c *gin.Context) {
+	plan := model.SubscriptionPlan{}
+	if err := c.ShouldBindJSON(&plan); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	if plan.OverageRatio == 0 {
+		plan.OverageRatio = 1
+	}
+	if plan.Status == 0 {
+		plan.Status = model.SubscriptionPlanStatusEnabled
+	}
+	if err := plan.Validate(); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	cleanPlan := model.SubscriptionPlan{
+		Name:          plan.Name,
+		Description:   plan.Description,
+		Price:         plan.Price,
+		DurationDays:  plan.DurationDays,
+		IncludedQuota: plan.IncludedQuota,
+		Group:         plan.Group,
+		OverageRatio:  plan.OverageRatio,
+		Status:        plan.Status,
+	}
+	if err := cleanPlan.Insert(); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    cleanPlan,
+	})
+}
+
+// UpdateSubscriptionPlan 修改套餐，已开通的订阅在下次续订时使用新的配置
+func UpdateSubscriptionPlan(c *gin.Context) {
+	plan := model.SubscriptionPlan{}
+	if err := c.ShouldBindJSON(&plan); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	if err := plan.Validate(); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	if _, err := model.GetSubscriptionPlanById(plan.Id); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "套餐不存在",
+		})
+		return
+	}
+	if err := plan.Update(); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    plan,
+	})
+}
+
+// DeleteSubscriptionPlan 删除套餐，已开通的订阅到期后不再续订
+func DeleteSubscriptionPlan(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	if err := model.DeleteSubscriptionPlanById(id); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+	})
+}
+
+func respondSubscriptions(c *gin.Context, userId int) {
+	pageInfo, err := common.GetPageQuery(c)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "parse page query failed",
+		})
+		return
+	}
+	subscriptions, total, err := model.GetSubscriptions(userId, pageInfo.GetStartIdx(), pageInfo.GetPageSize())
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	pageInfo.SetTotal(int(total))
+	pageInfo.SetItems(subscriptions)
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    pageInfo,
+	})
+}
+
+// GetAllSubscriptions 管理员查询订阅记录，可按用户过滤
+func GetAllSubscriptions(c *gin.Context) {
+	userId, _ := strconv.Atoi(c.Query("user_id"))
+	respondSubscriptions(c, userId)
+}
+
+// GetSelfSubscriptions 用户查询自己的订阅记录
+func GetSelfSubscriptions(c *gin.Context) {
+	respondSubscriptions(c, c.GetInt("id"))
+}
+
+type subscriptionRenewalRequest struct {
+	AutoRenew  bool `json:"auto_renew"`
+	NextPlanId int  `json:"next_plan_id"` // 下个周期切换到的套餐，0 表示续订当前套餐
+}
+
+// UpdateSelfSubscription 修改当前订阅的续订设置，关闭自动续订即为取消订阅，更换套餐在本周期结束时生效
+func UpdateSelfSubscription(c *gin.Context) {
+	var req subscriptionRenewalRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "无效的参数",
+		})
+		return
+	}
+	subscription, err := model.GetActiveSubscription(c.GetInt("id"))
+	if err != nil || subscription == nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "当前没有生效的订阅",
+		})
+		return
+	}
+	if req.NextPlanId == subscription.PlanId {
+		req.NextPlanId = 0
+	}
+	if req.NextPlanId != 0 {
+		plan, err := model.GetSubscriptionPlanById(req.NextPlanId)
+		if err != nil || plan.Status != model.SubscriptionPlanStatusEnabled {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": "套餐不存在",
+			})
+			return
+		}
+	}
+	subscription.AutoRenew = req.AutoRenew
+	subscription.NextPlanId = req.NextPlanId
+	if err = subscription.UpdateRenewal(); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    subscription,
+	})
+}
@@ -14,6 +14,7 @@ import (
 	"one-api/dto"
 	"one-api/model"
 	"one-api/relay"
+	relaychannel "one-api/relay/channel"
 	"sort"
 	"strconv"
 	"time"
@@ -113,7 +114,30 @@ func updateSunoTaskAll(ctx context.Context, channelId int, taskIds []string, tas
 	if adaptor == nil {
 		return errors.New("adaptor not found")
 	}
-	resp, err := adaptor.FetchTask(*channel.BaseURL, channel.Key, map[string]any{
+	// 多密钥渠道的任务只能使用提交时的密钥查询，按密钥分组查询
+	keyTaskIds := make(map[int][]string)
+	for _, taskId := range taskIds {
+		keyIndex := 0
+		if task, ok := taskM[taskId]; ok {
+			keyIndex = task.KeyIndex
+		}
+		keyTaskIds[keyIndex] = append(keyTaskIds[keyIndex], taskId)
+	}
+	for keyIndex, ids := range keyTaskIds {
+		key, err := channel.GetKeyByIndex(keyIndex)
+		if err != nil {
+			common.LogError(ctx, fmt.Sprintf("渠道 #%d 获取密钥 %d 失败: %s", channelId, keyIndex, err.Error()))
+			continue
+		}
+		if err = fetchSunoTaskAll(ctx, adaptor, *channel.BaseURL, key, channelId, ids, taskM); err != nil {
+			common.LogError(ctx, fmt.Sprintf("渠道 #%d 查询异步任务失败: %s", channelId, err.Error()))
+		}
+	}
+	return nil
+}
+
+func fetchSunoTaskAll(ctx context.Context, adaptor relaychannel.TaskAdaptor, baseURL string, key string, channelId int, taskIds []string, taskM map[string]*model.Task) error {
+	resp, err := adaptor.FetchTask(baseURL, key, map[string]any{
 		"ids": taskIds,
 	})
 	if err != nil {
@@ -161,7 +185,10 @@ func updateSunoTaskAll(ctx context.Context, channelId int, taskIds []string, tas
 			} else {
 				quota := task.Quota
 				if quota != 0 {
-					err = model.IncreaseUserQuota(task.UserId, quota, false)
+					err = model.IncreaseWalletQuota(task.UserId, task.OrganizationId, quota, model.QuotaMovement{
+						Reason:    model.QuotaReasonRefund,
+						RequestId: "task:" + task.TaskID,
+					})
 					if err != nil {
 						common.LogError(ctx, "fail to increase user quota: "+err.Error())
 					}
@@ -56,7 +56,16 @@ func updateVideoSingleTask(ctx context.Context, adaptor channel.TaskAdaptor, cha
 	if channel.GetBaseURL() != "" {
 		baseURL = channel.GetBaseURL()
 	}
-	resp, err := adaptor.FetchTask(baseURL, channel.Key, map[string]any{
+	// 多密钥渠道需要使用提交任务时的密钥查询
+	keyIndex := 0
+	if task, ok := taskM[taskId]; ok {
+		keyIndex = task.KeyIndex
+	}
+	key, err := channel.GetKeyByIndex(keyIndex)
+	if err != nil {
+		return fmt.Errorf("GetKeyByIndex failed for task %s: %w", taskId, err)
+	}
+	resp, err := adaptor.FetchTask(baseURL, key, map[string]any{
 		"task_id": taskId,
 	})
 	if err != nil {
@@ -123,7 +132,10 @@ func updateVideoSingleTask(ctx context.Context, adaptor channel.TaskAdaptor, cha
 		common.LogInfo(ctx, fmt.Sprintf("Task %s failed: %s", task.TaskID, task.FailReason))
 		quota := task.Quota
 		if quota != 0 {
-			if err := model.IncreaseUserQuota(task.UserId, quota, false); err != nil {
+			if err := model.IncreaseWalletQuota(task.UserId, task.OrganizationId, quota, model.QuotaMovement{
+				Reason:    model.QuotaReasonRefund,
+				RequestId: "task:" + task.TaskID,
+			}); err != nil {
 				common.LogError(ctx, "Failed to increase user quota: "+err.Error())
 			}
 			logContent := fmt.Sprintf("Video async task failed %s, refund %s", task.TaskID, common.LogQuota(quota))
@@ -28,6 +28,9 @@ func GetAllTokens(c *gin.Context) {
 		})
 		return
 	}
+	for _, token := range tokens {
+		token.RefreshBudgetUsage()
+	}
 	// Get total count for pagination
 	total, _ := model.CountUserTokens(userId)
 
@@ -56,6 +59,9 @@ func SearchTokens(c *gin.Context) {
 		})
 		return
 	}
+	for _, token := range tokens {
+		token.RefreshBudgetUsage()
+	}
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
 		"message": "",
@@ -82,6 +88,7 @@ func GetToken(c *gin.Context) {
 		})
 		return
 	}
+	token.RefreshBudgetUsage()
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
 		"message": "",
@@ -131,6 +138,30 @@ func AddToken(c *gin.Context) {
 		})
 		return
 	}
+	if err = token.GetHistoryPolicy().Validate(); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	if err = token.GetBudget().Validate(); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	if token.OrganizationId != 0 {
+		// 组织的任何成员都可以创建组织令牌
+		if _, err = model.GetOrganizationMember(token.OrganizationId, c.GetInt("id")); err != nil {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": "不是该组织的成员",
+			})
+			return
+		}
+	}
 	key, err := common.GenerateKey()
 	if err != nil {
 		c.JSON(http.StatusOK, gin.H{
@@ -141,18 +172,29 @@ func AddToken(c *gin.Context) {
 		return
 	}
 	cleanToken := model.Token{
-		UserId:             c.GetInt("id"),
-		Name:               token.Name,
-		Key:                key,
-		CreatedTime:        common.GetTimestamp(),
-		AccessedTime:       common.GetTimestamp(),
-		ExpiredTime:        token.ExpiredTime,
-		RemainQuota:        token.RemainQuota,
-		UnlimitedQuota:     token.UnlimitedQuota,
-		ModelLimitsEnabled: token.ModelLimitsEnabled,
-		ModelLimits:        token.ModelLimits,
-		AllowIps:           token.AllowIps,
-		Group:              token.Group,
+		UserId:               c.GetInt("id"),
+		Name:                 token.Name,
+		Key:                  key,
+		CreatedTime:          common.GetTimestamp(),
+		AccessedTime:         common.GetTimestamp(),
+		ExpiredTime:          token.ExpiredTime,
+		RemainQuota:          token.RemainQuota,
+		UnlimitedQuota:       token.UnlimitedQuota,
+		ModelLimitsEnabled:   token.ModelLimitsEnabled,
+		ModelLimits:          token.ModelLimits,
+		AllowIps:             token.AllowIps,
+		Group:                token.Group,
+		HedgeEnabled:         token.HedgeEnabled,
+		ResponseCacheTTL:     token.ResponseCacheTTL,
+		TPMLimit:             token.TPMLimit,
+		ConcurrencyLimit:     token.ConcurrencyLimit,
+		HistoryMode:          token.HistoryMode,
+		HistorySampleRate:    token.HistorySampleRate,
+		HistoryRetentionDays: token.HistoryRetentionDays,
+		BudgetPeriod:         token.BudgetPeriod,
+		BudgetLimit:          token.BudgetLimit,
+		BudgetAlertThreshold: token.BudgetAlertThreshold,
+		OrganizationId:       token.OrganizationId,
 	}
 	err = cleanToken.Insert()
 	if err != nil {
@@ -206,6 +248,20 @@ func UpdateToken(c *gin.Context) {
 		})
 		return
 	}
+	if err = token.GetHistoryPolicy().Validate(); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	if err = token.GetBudget().Validate(); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
 	cleanToken, err := model.GetTokenByIds(token.Id, userId)
 	if err != nil {
 		c.JSON(http.StatusOK, gin.H{
@@ -242,6 +298,16 @@ func UpdateToken(c *gin.Context) {
 		cleanToken.ModelLimits = token.ModelLimits
 		cleanToken.AllowIps = token.AllowIps
 		cleanToken.Group = token.Group
+		cleanToken.HedgeEnabled = token.HedgeEnabled
+		cleanToken.ResponseCacheTTL = token.ResponseCacheTTL
+		cleanToken.TPMLimit = token.TPMLimit
+		cleanToken.ConcurrencyLimit = token.ConcurrencyLimit
+		cleanToken.HistoryMode = token.HistoryMode
+		cleanToken.HistorySampleRate = token.HistorySampleRate
+		cleanToken.HistoryRetentionDays = token.HistoryRetentionDays
+		cleanToken.BudgetPeriod = token.BudgetPeriod
+		cleanToken.BudgetLimit = token.BudgetLimit
+		cleanToken.BudgetAlertThreshold = token.BudgetAlertThreshold
 	}
 	err = cleanToken.Update()
 	if err != nil {
@@ -251,6 +317,7 @@ func UpdateToken(c *gin.Context) {
 		})
 		return
 	}
+	cleanToken.RefreshBudgetUsage()
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
 		"message": "",
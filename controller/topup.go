@@ -1,48 +1,37 @@
 package controller
 
 import (
+	"errors"
 	"fmt"
 	"log"
-	"net/url"
+	"net/http"
 	"one-api/common"
 	"one-api/model"
 	"one-api/service"
 	"one-api/setting"
 	"strconv"
-	"sync"
 	"time"
 
-	"github.com/Calcium-Ion/go-epay/epay"
 	"github.com/gin-gonic/gin"
-	"github.com/samber/lo"
 	"github.com/shopspring/decimal"
 )
 
-type EpayRequest struct {
+type PaymentRequest struct {
 	Amount        int64  `json:"amount"`
 	PaymentMethod string `json:"payment_method"`
 	TopUpCode     string `json:"top_up_code"`
 }
 
+type SubscriptionPaymentRequest struct {
+	PlanId        int    `json:"plan_id"`
+	PaymentMethod string `json:"payment_method"`
+}
+
 type AmountRequest struct {
 	Amount    int64  `json:"amount"`
 	TopUpCode string `json:"top_up_code"`
 }
 
-func GetEpayClient() *epay.Client {
-	if setting.PayAddress == "" || setting.EpayId == "" || setting.EpayKey == "" {
-		return nil
-	}
-	withUrl, err := epay.NewClient(&epay.Config{
-		PartnerID: setting.EpayId,
-		Key:       setting.EpayKey,
-	}, setting.PayAddress)
-	if err != nil {
-		return nil
-	}
-	return withUrl
-}
-
 func getPayMoney(amount int64, group string) float64 {
 	dAmount := decimal.NewFromInt(amount)
 
@@ -74,8 +63,53 @@ func getMinTopup() int64 {
 	return int64(minTopup)
 }
 
-func RequestEpay(c *gin.Context) {
-	var req EpayRequest
+// createPaymentOrder 在支付方式对应的渠道创建订单并保存，返回前端跳转支付页面所需的信息
+func createPaymentOrder(c *gin.Context, topUp *model.TopUp, name string) {
+	providerName := setting.GetPayMethodProvider(topUp.PaymentMethod)
+	provider, err := service.GetPaymentProvider(providerName)
+	if err != nil {
+		c.JSON(200, gin.H{"message": "error", "data": err.Error()})
+		return
+	}
+	// 先保存订单再到渠道创建，渠道的回调总能找到对应的订单
+	topUp.PaymentProvider = provider.GetName()
+	topUp.CreateTime = time.Now().Unix()
+	topUp.Status = model.TopUpStatusPending
+	err = topUp.Insert()
+	if err != nil {
+		c.JSON(200, gin.H{"message": "error", "data": "创建订单失败"})
+		return
+	}
+	checkout, err := provider.CreateOrder(&service.PaymentOrder{
+		TradeNo:       topUp.TradeNo,
+		Name:          name,
+		Money:         topUp.Money,
+		PaymentMethod: topUp.PaymentMethod,
+		NotifyUrl:     service.GetPaymentNotifyUrl(providerName),
+		ReturnUrl:     setting.ServerAddress + "/console/log",
+	})
+	if err != nil {
+		common.SysError(fmt.Sprintf("failed to create %s payment order: %s", providerName, err.Error()))
+		if _, failErr := model.FailTopUp(topUp.TradeNo); failErr != nil {
+			common.SysError(fmt.Sprintf("failed to mark payment order %s as failed: %s", topUp.TradeNo, failErr.Error()))
+		}
+		c.JSON(200, gin.H{"message": "error", "data": "拉起支付失败"})
+		return
+	}
+	if checkout.ProviderTradeNo != "" {
+		if err = topUp.UpdateProviderTradeNo(checkout.ProviderTradeNo); err != nil {
+			common.SysError(fmt.Sprintf("failed to save provider trade no of payment order %s: %s", topUp.TradeNo, err.Error()))
+		}
+	}
+	method := checkout.Method
+	if method == "" {
+		method = "POST"
+	}
+	c.JSON(200, gin.H{"message": "success", "data": checkout.Params, "url": checkout.Url, "method": method})
+}
+
+func RequestPayment(c *gin.Context) {
+	var req PaymentRequest
 	err := c.ShouldBindJSON(&req)
 	if err != nil {
 		c.JSON(200, gin.H{"message": "error", "data": "参数错误"})
@@ -103,29 +137,8 @@ func RequestEpay(c *gin.Context) {
 		return
 	}
 
-	callBackAddress := service.GetCallbackAddress()
-	returnUrl, _ := url.Parse(setting.ServerAddress + "/console/log")
-	notifyUrl, _ := url.Parse(callBackAddress + "/api/user/epay/notify")
 	tradeNo := fmt.Sprintf("%s%d", common.GetRandomString(6), time.Now().Unix())
 	tradeNo = fmt.Sprintf("USR%dNO%s", id, tradeNo)
-	client := GetEpayClient()
-	if client == nil {
-		c.JSON(200, gin.H{"message": "error", "data": "当前管理员未配置支付信息"})
-		return
-	}
-	uri, params, err := client.Purchase(&epay.PurchaseArgs{
-		Type:           req.PaymentMethod,
-		ServiceTradeNo: tradeNo,
-		Name:           fmt.Sprintf("TUC%d", req.Amount),
-		Money:          strconv.FormatFloat(payMoney, 'f', 2, 64),
-		Device:         epay.PC,
-		NotifyUrl:      notifyUrl,
-		ReturnUrl:      returnUrl,
-	})
-	if err != nil {
-		c.JSON(200, gin.H{"message": "error", "data": "拉起支付失败"})
-		return
-	}
 	amount := req.Amount
 	if !common.DisplayInCurrencyEnabled {
 		dAmount := decimal.NewFromInt(int64(amount))
@@ -133,109 +146,76 @@ func RequestEpay(c *gin.Context) {
 		amount = dAmount.Div(dQuotaPerUnit).IntPart()
 	}
 	topUp := &model.TopUp{
-		UserId:     id,
-		Amount:     amount,
-		Money:      payMoney,
-		TradeNo:    tradeNo,
-		CreateTime: time.Now().Unix(),
-		Status:     "pending",
+		UserId:        id,
+		Amount:        amount,
+		Money:         payMoney,
+		TradeNo:       tradeNo,
+		PaymentMethod: req.PaymentMethod,
 	}
-	err = topUp.Insert()
+	topUp.Quota = topUp.GetQuota()
+	createPaymentOrder(c, topUp, fmt.Sprintf("TUC%d", req.Amount))
+}
+
+// RequestSubscriptionPayment 在线支付购买订阅套餐，支付成功后在回调中开通订阅
+func RequestSubscriptionPayment(c *gin.Context) {
+	var req SubscriptionPaymentRequest
+	err := c.ShouldBindJSON(&req)
 	if err != nil {
-		c.JSON(200, gin.H{"message": "error", "data": "创建订单失败"})
+		c.JSON(200, gin.H{"message": "error", "data": "参数错误"})
+		return
+	}
+	plan, err := model.GetSubscriptionPlanById(req.PlanId)
+	if err != nil || plan.Status != model.SubscriptionPlanStatusEnabled {
+		c.JSON(200, gin.H{"message": "error", "data": "套餐不存在"})
+		return
+	}
+	if plan.Price < 0.01 {
+		c.JSON(200, gin.H{"message": "error", "data": "套餐价格过低"})
+		return
+	}
+	if !setting.ContainsPayMethod(req.PaymentMethod) {
+		c.JSON(200, gin.H{"message": "error", "data": "支付方式不存在"})
 		return
 	}
-	c.JSON(200, gin.H{"message": "success", "data": params, "url": uri})
-}
-
-// tradeNo lock
-var orderLocks sync.Map
-var createLock sync.Mutex
 
-// LockOrder 尝试对给定订单号加锁
-func LockOrder(tradeNo string) {
-	lock, ok := orderLocks.Load(tradeNo)
-	if !ok {
-		createLock.Lock()
-		defer createLock.Unlock()
-		lock, ok = orderLocks.Load(tradeNo)
-		if !ok {
-			lock = new(sync.Mutex)
-			orderLocks.Store(tradeNo, lock)
-		}
+	id := c.GetInt("id")
+	tradeNo := fmt.Sprintf("%s%d", common.GetRandomString(6), time.Now().Unix())
+	tradeNo = fmt.Sprintf("SUB%dNO%s", id, tradeNo)
+	topUp := &model.TopUp{
+		UserId:        id,
+		Money:         plan.Price,
+		TradeNo:       tradeNo,
+		PlanId:        plan.Id,
+		PaymentMethod: req.PaymentMethod,
 	}
-	lock.(*sync.Mutex).Lock()
+	createPaymentOrder(c, topUp, fmt.Sprintf("SUB%d", plan.Id))
 }
 
-// UnlockOrder 释放给定订单号的锁
-func UnlockOrder(tradeNo string) {
-	lock, ok := orderLocks.Load(tradeNo)
-	if ok {
-		lock.(*sync.Mutex).Unlock()
-	}
+// PaymentNotify 支付渠道的异步通知。订单状态的更新是幂等的，处理失败时返回失败让渠道重试。
+func PaymentNotify(c *gin.Context) {
+	paymentNotify(c, c.Param("provider"))
 }
 
+// EpayNotify 易支付的异步通知，保留原有地址
 func EpayNotify(c *gin.Context) {
-	params := lo.Reduce(lo.Keys(c.Request.URL.Query()), func(r map[string]string, t string, i int) map[string]string {
-		r[t] = c.Request.URL.Query().Get(t)
-		return r
-	}, map[string]string{})
-	client := GetEpayClient()
-	if client == nil {
-		log.Println("易支付回调失败 未找到配置信息")
-		_, err := c.Writer.Write([]byte("fail"))
-		if err != nil {
-			log.Println("易支付回调写入失败")
-			return
-		}
-	}
-	verifyInfo, err := client.Verify(params)
-	if err == nil && verifyInfo.VerifyStatus {
-		_, err := c.Writer.Write([]byte("success"))
-		if err != nil {
-			log.Println("易支付回调写入失败")
-		}
-	} else {
-		_, err := c.Writer.Write([]byte("fail"))
-		if err != nil {
-			log.Println("易支付回调写入失败")
-		}
-		log.Println("易支付回调签名验证失败")
+	paymentNotify(c, service.PaymentProviderEpay)
+}
+
+func paymentNotify(c *gin.Context, providerName string) {
+	provider, err := service.GetPaymentProvider(providerName)
+	if err != nil {
+		log.Printf("支付回调失败 %s: %s", providerName, err.Error())
+		c.String(http.StatusBadRequest, "fail")
 		return
 	}
-
-	if verifyInfo.TradeStatus == epay.StatusTradeSuccess {
-		log.Println(verifyInfo)
-		LockOrder(verifyInfo.ServiceTradeNo)
-		defer UnlockOrder(verifyInfo.ServiceTradeNo)
-		topUp := model.GetTopUpByTradeNo(verifyInfo.ServiceTradeNo)
-		if topUp == nil {
-			log.Printf("易支付回调未找到订单: %v", verifyInfo)
-			return
-		}
-		if topUp.Status == "pending" {
-			topUp.Status = "success"
-			err := topUp.Update()
-			if err != nil {
-				log.Printf("易支付回调更新订单失败: %v", topUp)
-				return
-			}
-			//user, _ := model.GetUserById(topUp.UserId, false)
-			//user.Quota += topUp.Amount * 500000
-			dAmount := decimal.NewFromInt(int64(topUp.Amount))
-			dQuotaPerUnit := decimal.NewFromFloat(common.QuotaPerUnit)
-			quotaToAdd := int(dAmount.Mul(dQuotaPerUnit).IntPart())
-			err = model.IncreaseUserQuota(topUp.UserId, quotaToAdd, true)
-			if err != nil {
-				log.Printf("易支付回调更新用户失败: %v", topUp)
-				return
-			}
-			log.Printf("易支付回调更新用户成功 %v", topUp)
-			model.RecordLog(topUp.UserId, model.LogTypeTopup, fmt.Sprintf("使用在线充值成功，充值金额: %v，支付金额：%f", common.LogQuota(quotaToAdd), topUp.Money))
-		}
-	} else {
-		log.Printf("易支付异常回调: %v", verifyInfo)
+	result, err := provider.VerifyCallback(c.Request)
+	if err == nil {
+		err = service.HandlePaymentResult(provider, result)
+	}
+	if err != nil {
+		log.Printf("%s 支付回调处理失败: %s", providerName, err.Error())
 	}
+	c.String(provider.CallbackResponse(err == nil))
 }
 
 func RequestAmount(c *gin.Context) {
@@ -263,3 +243,97 @@ func RequestAmount(c *gin.Context) {
 	}
 	c.JSON(200, gin.H{"message": "success", "data": strconv.FormatFloat(payMoney, 'f', 2, 64)})
 }
+
+// GetAllTopUps 管理员查询在线支付订单，可按用户和状态过滤
+func GetAllTopUps(c *gin.Context) {
+	pageInfo, err := common.GetPageQuery(c)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "parse page query failed",
+		})
+		return
+	}
+	userId, _ := strconv.Atoi(c.Query("user_id"))
+	topUps, total, err := model.GetTopUps(userId, c.Query("status"), pageInfo.GetStartIdx(), pageInfo.GetPageSize())
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	pageInfo.SetTotal(int(total))
+	pageInfo.SetItems(topUps)
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    pageInfo,
+	})
+}
+
+// SyncTopUp 向支付渠道查询订单状态并同步到本地，用于补单
+func SyncTopUp(c *gin.Context) {
+	topUp := model.GetTopUpByTradeNo(c.Param("trade_no"))
+	if topUp == nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "订单不存在",
+		})
+		return
+	}
+	if _, err := service.SyncPayment(topUp); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    model.GetTopUpByTradeNo(topUp.TradeNo),
+	})
+}
+
+type refundTopUpRequest struct {
+	Money float64 `json:"money"` // 退款金额，为 0 时全额退款
+}
+
+// RefundTopUp 通过原支付渠道退款，并收回订单计入的额度
+func RefundTopUp(c *gin.Context) {
+	var req refundTopUpRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "无效的参数",
+		})
+		return
+	}
+	topUp := model.GetTopUpByTradeNo(c.Param("trade_no"))
+	if topUp == nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "订单不存在",
+		})
+		return
+	}
+	reclaimed, err := service.RefundPayment(topUp, req.Money)
+	if err != nil {
+		message := err.Error()
+		if errors.Is(err, model.ErrTopUpStatusConflict) {
+			message = "只能退款已支付的订单"
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": message,
+		})
+		return
+	}
+	model.RecordLog(topUp.UserId, model.LogTypeManage, fmt.Sprintf("管理员为订单 %s 退款，收回额度: %v", topUp.TradeNo, common.LogQuota(reclaimed)))
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    model.GetTopUpByTradeNo(topUp.TradeNo),
+	})
+}
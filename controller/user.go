@@ -7,6 +7,7 @@ import (
 	"net/url"
 	"one-api/common"
 	"one-api/model"
+	"one-api/service"
 	"one-api/setting"
 	"strconv"
 	"strings"
@@ -331,6 +332,7 @@ func GetUser(c *gin.Context) {
 		})
 		return
 	}
+	user.RefreshBudgetUsage()
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
 		"message": "",
@@ -462,6 +464,8 @@ func GetSelf(c *gin.Context) {
 	}
 	// Hide admin remarks: set to empty to trigger omitempty tag, ensuring the remark field is not included in JSON returned to regular users
 	user.Remark = ""
+	user.RefreshBudgetUsage()
+	user.Subscription, _ = model.GetActiveSubscription(id)
 
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
@@ -521,6 +525,13 @@ func UpdateUser(c *gin.Context) {
 		})
 		return
 	}
+	if err := updatedUser.GetBudget().Validate(); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
 	originUser, err := model.GetUserById(updatedUser.Id, false)
 	if err != nil {
 		c.JSON(http.StatusOK, gin.H{
@@ -948,6 +959,10 @@ type UpdateUserSettingRequest struct {
 	NotificationEmail          string  `json:"notification_email,omitempty"`
 	AcceptUnsetModelRatioModel bool    `json:"accept_unset_model_ratio_model"`
 	RecordIpLog                bool    `json:"record_ip_log"`
+	// 对话历史存储策略，未提供时保留原有设置
+	HistoryMode          *string `json:"history_mode,omitempty"`
+	HistorySampleRate    *int    `json:"history_sample_rate,omitempty"`
+	HistoryRetentionDays *int    `json:"history_retention_days,omitempty"`
 }
 
 func UpdateUserSetting(c *gin.Context) {
@@ -1040,6 +1055,30 @@ func UpdateUserSetting(c *gin.Context) {
 		settings[constant.UserSettingNotificationEmail] = req.NotificationEmail
 	}
 
+	// 对话历史存储策略
+	historyPolicy := service.GetUserHistoryPolicy(user.GetSetting())
+	if req.HistoryMode != nil {
+		historyPolicy.Mode = *req.HistoryMode
+	}
+	if req.HistorySampleRate != nil {
+		historyPolicy.SampleRate = *req.HistorySampleRate
+	}
+	if req.HistoryRetentionDays != nil {
+		historyPolicy.RetentionDays = *req.HistoryRetentionDays
+	}
+	if err := historyPolicy.Validate(); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	if historyPolicy.Mode != "" {
+		settings[constant.UserSettingHistoryMode] = historyPolicy.Mode
+		settings[constant.UserSettingHistorySampleRate] = historyPolicy.SampleRate
+		settings[constant.UserSettingHistoryRetentionDays] = historyPolicy.RetentionDays
+	}
+
 	// 更新用户设置
 	user.SetSetting(settings)
 	if err := user.Update(false); err != nil {
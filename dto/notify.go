@@ -13,6 +13,8 @@ const (
 	NotifyTypeQuotaExceed   = "quota_exceed"
 	NotifyTypeChannelUpdate = "channel_update"
 	NotifyTypeChannelTest   = "channel_test"
+	NotifyTypeBudgetAlert   = "budget_alert"
+	NotifyTypeSubscription  = "subscription"
 )
 
 func NewNotify(t string, title string, content string, values []interface{}) Notify {
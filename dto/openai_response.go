@@ -229,6 +229,10 @@ type ResponsesOutput struct {
 	Status  string                   `json:"status"`
 	Role    string                   `json:"role"`
 	Content []ResponsesOutputContent `json:"content"`
+	// function_call
+	CallId    string `json:"call_id,omitempty"`
+	Name      string `json:"name,omitempty"`
+	Arguments string `json:"arguments,omitempty"`
 }
 
 type ResponsesOutputContent struct {
@@ -253,8 +257,14 @@ const (
 
 // ResponsesStreamResponse 用于处理 /v1/responses 流式响应
 type ResponsesStreamResponse struct {
-	Type     string                   `json:"type"`
-	Response *OpenAIResponsesResponse `json:"response,omitempty"`
-	Delta    string                   `json:"delta,omitempty"`
-	Item     *ResponsesOutput         `json:"item,omitempty"`
+	Type         string                   `json:"type"`
+	Response     *OpenAIResponsesResponse `json:"response,omitempty"`
+	Delta        string                   `json:"delta,omitempty"`
+	Item         *ResponsesOutput         `json:"item,omitempty"`
+	ItemId       string                   `json:"item_id,omitempty"`
+	OutputIndex  *int                     `json:"output_index,omitempty"`
+	ContentIndex *int                     `json:"content_index,omitempty"`
+	Part         *ResponsesOutputContent  `json:"part,omitempty"`
+	Text         string                   `json:"text,omitempty"`
+	Arguments    string                   `json:"arguments,omitempty"`
 }
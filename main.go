@@ -88,7 +88,10 @@ func main() {
 
 	// Log the final settings
 	common.SysLog(fmt.Sprintf("Final ConversationHistoryEnabled setting: %v", common.ConversationHistoryEnabled))
+	common.SysLog(fmt.Sprintf("Final ConversationHistoryDedup setting: %v", common.ConversationHistoryDedup))
+	common.SysLog(fmt.Sprintf("Final ConversationHistorySearch setting: %v", common.ConversationHistorySearch))
 	common.SysLog(fmt.Sprintf("Final MESDailyPartition setting: %v", common.MESDailyPartition))
+	common.SysLog(fmt.Sprintf("Final MESNativePartition setting: %v", model.IsMESNativePartition()))
 
 	// Ensure today's partition tables exist
 	err = model.EnsureTodayTablesExist()
@@ -136,6 +139,9 @@ func main() {
 	// 渠道限额检查定时任务
 	go model.ChannelQuotaCheckTask()
 
+	// 自适应渠道权重统计同步
+	go model.SyncChannelStats()
+
 	if os.Getenv("CHANNEL_UPDATE_FREQUENCY") != "" {
 		frequency, err := strconv.Atoi(os.Getenv("CHANNEL_UPDATE_FREQUENCY"))
 		if err != nil {
@@ -158,6 +164,20 @@ func main() {
 			controller.UpdateTaskBulk()
 		})
 	}
+	if common.IsMasterNode {
+		gopool.Go(func() {
+			controller.UpdateBatchTasks()
+		})
+		gopool.Go(func() {
+			service.AutoArchiveHistoryPartitions()
+		})
+		gopool.Go(func() {
+			service.AutoReconcileQuotaLedger()
+		})
+		gopool.Go(func() {
+			service.AutoProcessSubscriptions()
+		})
+	}
 	if os.Getenv("BATCH_UPDATE_ENABLED") == "true" {
 		common.BatchUpdateEnabled = true
 		common.SysLog("batch update enabled with interval " + strconv.Itoa(common.BatchUpdateInterval) + "s")
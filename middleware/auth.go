@@ -249,6 +249,13 @@ func TokenAuth() func(c *gin.Context) {
 		}
 		c.Set("allow_ips", token.GetIpLimitsMap())
 		c.Set("token_group", token.Group)
+		c.Set("token_hedge_enabled", token.HedgeEnabled)
+		c.Set("token_response_cache_ttl", token.ResponseCacheTTL)
+		c.Set("token_tpm_limit", token.TPMLimit)
+		c.Set("token_concurrency_limit", token.ConcurrencyLimit)
+		c.Set("token_history_policy", token.GetHistoryPolicy())
+		c.Set("token_budget", token.GetBudget())
+		c.Set("token_organization_id", token.OrganizationId)
 		if len(parts) > 1 {
 			if model.IsAdmin(token.UserId) {
 				c.Set("specific_channel_id", parts[1])
@@ -0,0 +1,21 @@
+package middleware
+
+import (
+	"net/http"
+	"one-api/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ConcurrencyLimit 令牌和用户的并发请求数限制，请求处理结束后释放
+func ConcurrencyLimit() func(c *gin.Context) {
+	return func(c *gin.Context) {
+		release, err := service.AcquireRequestConcurrency(c)
+		if err != nil {
+			abortWithOpenAiMessage(c, http.StatusTooManyRequests, err.Error())
+			return
+		}
+		defer release()
+		c.Next()
+	}
+}
@@ -122,7 +122,25 @@ func Distribute() func(c *gin.Context) {
 			}
 		}
 		c.Set(constant.ContextKeyRequestStartTime, time.Now())
-		SetupContextForSelectedChannel(c, channel, modelRequest.Model)
+		if err := SetupContextForSelectedChannel(c, channel, modelRequest.Model); err != nil {
+			// 指定渠道时无法改选，否则尝试其他渠道
+			if ok {
+				abortWithOpenAiMessage(c, http.StatusServiceUnavailable, err.Error())
+				return
+			}
+			for retryCount := 1; err != nil; retryCount++ {
+				if retryCount > common.RetryTimes {
+					abortWithOpenAiMessage(c, http.StatusServiceUnavailable, fmt.Sprintf("当前分组 %s 下对于模型 %s 无可用渠道", userGroup, modelRequest.Model))
+					return
+				}
+				channel, _, err = model.CacheGetRandomSatisfiedChannel(c, userGroup, modelRequest.Model, retryCount)
+				if err != nil || channel == nil {
+					abortWithOpenAiMessage(c, http.StatusServiceUnavailable, fmt.Sprintf("当前分组 %s 下对于模型 %s 无可用渠道", userGroup, modelRequest.Model))
+					return
+				}
+				err = SetupContextForSelectedChannel(c, channel, modelRequest.Model)
+			}
+		}
 		c.Next()
 	}
 }
@@ -230,14 +248,21 @@ func getModelRequest(c *gin.Context) (*ModelRequest, bool, error) {
 	return &modelRequest, shouldSelectChannel, nil
 }
 
-func SetupContextForSelectedChannel(c *gin.Context, channel *model.Channel, modelName string) {
+// SetupContextForSelectedChannel 将选中渠道的信息写入上下文，渠道没有可用密钥时返回 model.ErrChannelNoEnabledKey
+func SetupContextForSelectedChannel(c *gin.Context, channel *model.Channel, modelName string) error {
 	c.Set("original_model", modelName) // for retry
 	if channel == nil {
-		return
+		return nil
+	}
+	key, keyIndex, err := channel.GetNextEnabledKey()
+	if err != nil {
+		common.SysError(fmt.Sprintf("渠道 #%d 选择密钥失败: %s", channel.Id, err.Error()))
+		return fmt.Errorf("渠道 #%d 选择密钥失败: %w", channel.Id, err)
 	}
 	c.Set("channel_id", channel.Id)
 	c.Set("channel_name", channel.Name)
 	c.Set("channel_type", channel.Type)
+	c.Set("channel_tpm_limit", channel.GetTPMLimit())
 	c.Set("channel_create_time", channel.CreatedTime)
 	c.Set("channel_setting", channel.GetSetting())
 	c.Set("param_override", channel.GetParamOverride())
@@ -247,7 +272,9 @@ func SetupContextForSelectedChannel(c *gin.Context, channel *model.Channel, mode
 	c.Set("auto_ban", channel.GetAutoBan())
 	c.Set("model_mapping", channel.GetModelMapping())
 	c.Set("status_code_mapping", channel.GetStatusCodeMapping())
-	c.Request.Header.Set("Authorization", fmt.Sprintf("Bearer %s", channel.Key))
+	c.Set("channel_multi_key", channel.GetMultiKeyEnabled())
+	c.Set("channel_key_index", keyIndex)
+	c.Request.Header.Set("Authorization", fmt.Sprintf("Bearer %s", key))
 	c.Set("base_url", channel.GetBaseURL())
 	// TODO: api_version统一
 	switch channel.Type {
@@ -268,6 +295,7 @@ func SetupContextForSelectedChannel(c *gin.Context, channel *model.Channel, mode
 	case common.ChannelTypeCoze:
 		c.Set("bot_id", channel.Other)
 	}
+	return nil
 }
 
 // extractModelNameFromGeminiPath 从 Gemini API URL 路径中提取模型名
@@ -89,6 +89,7 @@ func redisRateLimitHandler(duration int64, totalMaxCount, successMaxCount int) g
 			return
 		}
 		if !allowed {
+			setRequestRateLimitExceededHeaders(c)
 			abortWithOpenAiMessage(c, http.StatusTooManyRequests, fmt.Sprintf("您已达到请求数限制：%d分钟内最多请求%d次", setting.ModelRequestRateLimitDurationMinutes, successMaxCount))
 			return
 		}
@@ -113,6 +114,7 @@ func redisRateLimitHandler(duration int64, totalMaxCount, successMaxCount int) g
 			}
 
 			if !allowed {
+				setRequestRateLimitExceededHeaders(c)
 				abortWithOpenAiMessage(c, http.StatusTooManyRequests, fmt.Sprintf("您已达到总请求数限制：%d分钟内最多请求%d次，包括失败次数，请检查您的请求是否正确", setting.ModelRequestRateLimitDurationMinutes, totalMaxCount))
 			}
 		}
@@ -138,6 +140,7 @@ func memoryRateLimitHandler(duration int64, totalMaxCount, successMaxCount int)
 
 		// 1. 检查总请求数限制（当totalMaxCount为0时跳过）
 		if totalMaxCount > 0 && !inMemoryRateLimiter.Request(totalKey, totalMaxCount, duration) {
+			setRequestRateLimitExceededHeaders(c)
 			c.Status(http.StatusTooManyRequests)
 			c.Abort()
 			return
@@ -147,6 +150,7 @@ func memoryRateLimitHandler(duration int64, totalMaxCount, successMaxCount int)
 		// 使用一个临时key来检查限制，这样可以避免实际记录
 		checkKey := successKey + "_check"
 		if !inMemoryRateLimiter.Request(checkKey, successMaxCount, duration) {
+			setRequestRateLimitExceededHeaders(c)
 			c.Status(http.StatusTooManyRequests)
 			c.Abort()
 			return
@@ -162,6 +166,27 @@ func memoryRateLimitHandler(duration int64, totalMaxCount, successMaxCount int)
 	}
 }
 
+// getModelRequestRateLimit 计算限流参数，分组设置了限流配置时使用分组的配置
+func getModelRequestRateLimit(c *gin.Context) (duration int64, totalMaxCount int, successMaxCount int) {
+	duration = int64(setting.ModelRequestRateLimitDurationMinutes * 60)
+	totalMaxCount = setting.ModelRequestRateLimitCount
+	successMaxCount = setting.ModelRequestRateLimitSuccessCount
+
+	// 获取分组
+	group := c.GetString("token_group")
+	if group == "" {
+		group = c.GetString(constant.ContextKeyUserGroup)
+	}
+
+	//获取分组的限流配置
+	groupTotalCount, groupSuccessCount, found := setting.GetGroupRateLimit(group)
+	if found {
+		totalMaxCount = groupTotalCount
+		successMaxCount = groupSuccessCount
+	}
+	return duration, totalMaxCount, successMaxCount
+}
+
 // ModelRequestRateLimit 模型请求限流中间件
 func ModelRequestRateLimit() func(c *gin.Context) {
 	return func(c *gin.Context) {
@@ -171,23 +196,7 @@ func ModelRequestRateLimit() func(c *gin.Context) {
 			return
 		}
 
-		// 计算限流参数
-		duration := int64(setting.ModelRequestRateLimitDurationMinutes * 60)
-		totalMaxCount := setting.ModelRequestRateLimitCount
-		successMaxCount := setting.ModelRequestRateLimitSuccessCount
-
-		// 获取分组
-		group := c.GetString("token_group")
-		if group == "" {
-			group = c.GetString(constant.ContextKeyUserGroup)
-		}
-
-		//获取分组的限流配置
-		groupTotalCount, groupSuccessCount, found := setting.GetGroupRateLimit(group)
-		if found {
-			totalMaxCount = groupTotalCount
-			successMaxCount = groupSuccessCount
-		}
+		duration, totalMaxCount, successMaxCount := getModelRequestRateLimit(c)
 
 		// 根据存储类型选择并执行限流处理器
 		if common.RedisEnabled {
@@ -0,0 +1,117 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"one-api/common"
+	"one-api/constant"
+	"one-api/service"
+	"one-api/setting"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// getModelRequestLimitStatus 从模型请求限流器的计数中获取请求数限制、剩余次数和恢复到满额所需的秒数。
+// 优先使用成功请求数限制，未设置时使用总请求数限制。
+func getModelRequestLimitStatus(c *gin.Context) (limit int, remaining int, reset int64, ok bool) {
+	if !setting.ModelRequestRateLimitEnabled {
+		return 0, 0, 0, false
+	}
+	duration, totalMaxCount, successMaxCount := getModelRequestRateLimit(c)
+	userId := strconv.Itoa(c.GetInt("id"))
+	if successMaxCount > 0 {
+		var count int
+		var oldest int64
+		if common.RedisEnabled {
+			count, oldest = redisRequestWindow(fmt.Sprintf("rateLimit:%s:%s", ModelRequestRateLimitSuccessCountMark, userId), duration)
+		} else {
+			count, oldest = inMemoryRateLimiter.Peek(ModelRequestRateLimitSuccessCountMark+userId, duration)
+		}
+		// 成功请求在响应结束后才记录，这里把本次请求也计算在内
+		remaining = successMaxCount - count - 1
+		if count > 0 {
+			reset = oldest + duration - time.Now().Unix()
+		}
+		return successMaxCount, max(remaining, 0), max(reset, 0), true
+	}
+	if totalMaxCount > 0 {
+		if common.RedisEnabled {
+			remaining, reset = redisTokenBucketStatus(fmt.Sprintf("rateLimit:%s", userId), duration, totalMaxCount)
+		} else {
+			count, oldest := inMemoryRateLimiter.Peek(ModelRequestRateLimitCountMark+userId, duration)
+			remaining = totalMaxCount - count
+			if count > 0 {
+				reset = oldest + duration - time.Now().Unix()
+			}
+		}
+		return totalMaxCount, max(remaining, 0), max(reset, 0), true
+	}
+	return 0, 0, 0, false
+}
+
+// redisRequestWindow 统计 Redis 列表中时间窗口内的请求数和最早一次请求的时间，时间格式与 recordRedisRequest 一致
+func redisRequestWindow(key string, duration int64) (count int, oldest int64) {
+	values, err := common.RDB.LRange(context.Background(), key, 0, -1).Result()
+	if err != nil {
+		return 0, 0
+	}
+	now, _ := time.Parse(timeFormat, time.Now().Format(timeFormat))
+	for _, value := range values {
+		requestTime, err := time.Parse(timeFormat, value)
+		if err != nil || int64(now.Sub(requestTime).Seconds()) >= duration {
+			continue
+		}
+		count++
+		// 列表头部是最新的请求
+		oldest = time.Now().Unix() - int64(now.Sub(requestTime).Seconds())
+	}
+	return count, oldest
+}
+
+// redisTokenBucketStatus 根据令牌桶的状态计算剩余请求数和恢复到满额所需的秒数，参数与 redisRateLimitHandler 一致
+func redisTokenBucketStatus(key string, duration int64, totalMaxCount int) (remaining int, reset int64) {
+	capacity := float64(int64(totalMaxCount) * duration)
+	values, err := common.RDB.HMGet(context.Background(), key, "tokens", "last_time").Result()
+	if err != nil || values[0] == nil || values[1] == nil {
+		return totalMaxCount, 0
+	}
+	tokens, _ := strconv.ParseFloat(fmt.Sprint(values[0]), 64)
+	lastTime, _ := strconv.ParseInt(fmt.Sprint(values[1]), 10, 64)
+	tokens = math.Min(capacity, tokens+float64(time.Now().Unix()-lastTime)*float64(totalMaxCount))
+	remaining = int(tokens / float64(duration))
+	reset = int64(math.Ceil((capacity - tokens) / float64(totalMaxCount)))
+	return remaining, reset
+}
+
+// SetRateLimitHeaders 根据限流器的计数设置 OpenAI 风格的 x-ratelimit-* 响应头和额度响应头，需要在写入响应前调用
+func SetRateLimitHeaders(c *gin.Context) {
+	if limit, remaining, reset, ok := getModelRequestLimitStatus(c); ok {
+		c.Header("x-ratelimit-limit-requests", strconv.Itoa(limit))
+		c.Header("x-ratelimit-remaining-requests", strconv.Itoa(remaining))
+		c.Header("x-ratelimit-reset-requests", fmt.Sprintf("%ds", reset))
+	}
+	if limit, remaining, reset, ok := service.GetTPMLimitStatus(c); ok {
+		c.Header("x-ratelimit-limit-tokens", strconv.FormatInt(limit, 10))
+		c.Header("x-ratelimit-remaining-tokens", strconv.FormatInt(remaining, 10))
+		c.Header("x-ratelimit-reset-tokens", fmt.Sprintf("%ds", reset))
+	}
+	if c.GetBool("token_unlimited_quota") {
+		c.Header("x-token-quota-remaining", "unlimited")
+	} else {
+		c.Header("x-token-quota-remaining", strconv.Itoa(c.GetInt("token_quota")))
+	}
+	if userQuota, ok := c.Get(constant.ContextKeyUserQuota); ok {
+		c.Header("x-user-quota-remaining", fmt.Sprint(userQuota))
+	}
+}
+
+// setRequestRateLimitExceededHeaders 请求数超限时设置限流响应头和 Retry-After
+func setRequestRateLimitExceededHeaders(c *gin.Context) {
+	SetRateLimitHeaders(c)
+	if _, _, reset, ok := getModelRequestLimitStatus(c); ok {
+		c.Header("Retry-After", strconv.FormatInt(max(reset, 1), 10))
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"errors"
 	"fmt"
 	"one-api/common"
+	"one-api/setting/operation_setting"
 	"strings"
 
 	"github.com/samber/lo"
@@ -41,13 +42,18 @@ func GetAllEnableAbilities() []Ability {
 	return abilities
 }
 
-func getPriority(group string, model string, retry int) (int, error) {
+func getPriority(group string, model string, retry int, lowPriority bool) (int, error) {
 
+	// 低优先级请求按优先级升序选择
+	order := "priority DESC"
+	if lowPriority {
+		order = "priority ASC"
+	}
 	var priorities []int
 	err := DB.Model(&Ability{}).
 		Select("DISTINCT(priority)").
 		Where(commonGroupCol+" = ? and model = ? and enabled = ?", group, model, commonTrueVal).
-		Order("priority DESC").              // 按优先级降序排序
+		Order(order).                        // 按优先级排序
 		Pluck("priority", &priorities).Error // Pluck用于将查询的结果直接扫描到一个切片中
 
 	if err != nil {
@@ -63,7 +69,7 @@ func getPriority(group string, model string, retry int) (int, error) {
 	// 确定要使用的优先级
 	var priorityToUse int
 	if retry >= len(priorities) {
-		// 如果重试次数大于优先级数，则使用最小的优先级
+		// 如果重试次数大于优先级数，则使用最后一个优先级
 		priorityToUse = priorities[len(priorities)-1]
 	} else {
 		priorityToUse = priorities[retry]
@@ -71,11 +77,11 @@ func getPriority(group string, model string, retry int) (int, error) {
 	return priorityToUse, nil
 }
 
-func getChannelQuery(group string, model string, retry int) *gorm.DB {
+func getChannelQuery(group string, model string, retry int, lowPriority bool) *gorm.DB {
 	maxPrioritySubQuery := DB.Model(&Ability{}).Select("MAX(priority)").Where(commonGroupCol+" = ? and model = ? and enabled = ?", group, model, commonTrueVal)
 	channelQuery := DB.Where(commonGroupCol+" = ? and model = ? and enabled = ? and priority = (?)", group, model, commonTrueVal, maxPrioritySubQuery)
-	if retry != 0 {
-		priority, err := getPriority(group, model, retry)
+	if retry != 0 || lowPriority {
+		priority, err := getPriority(group, model, retry, lowPriority)
 		if err != nil {
 			common.SysError(fmt.Sprintf("Get priority failed: %s", err.Error()))
 		} else {
@@ -86,11 +92,11 @@ func getChannelQuery(group string, model string, retry int) *gorm.DB {
 	return channelQuery
 }
 
-func GetRandomSatisfiedChannel(group string, model string, retry int) (*Channel, error) {
+func GetRandomSatisfiedChannel(group string, model string, retry int, lowPriority bool) (*Channel, error) {
 	var abilities []Ability
 
 	var err error = nil
-	channelQuery := getChannelQuery(group, model, retry)
+	channelQuery := getChannelQuery(group, model, retry, lowPriority)
 	if common.UsingSQLite || common.UsingPostgreSQL {
 		err = channelQuery.Order("weight DESC").Find(&abilities).Error
 	} else {
@@ -118,7 +124,30 @@ func GetRandomSatisfiedChannel(group string, model string, retry int) (*Channel,
 		return nil, errors.New("no available channel (all channels exceeded quota limit)")
 	}
 
+	// 过滤掉熔断中的渠道，全部熔断时不过滤
+	var breakerAbilities []Ability
+	for _, ability := range validAbilities {
+		if IsChannelBreakerAvailable(ability.ChannelId) {
+			breakerAbilities = append(breakerAbilities, ability)
+		}
+	}
+	if len(breakerAbilities) > 0 {
+		validAbilities = breakerAbilities
+	}
+
 	channel := Channel{}
+	// 自适应模式下根据渠道的延迟和成功率调整权重
+	if operation_setting.GetChannelAdaptiveSetting().Enabled {
+		channelIds := make([]int, len(validAbilities))
+		staticWeights := make([]int, len(validAbilities))
+		for i, ability_ := range validAbilities {
+			channelIds[i] = ability_.ChannelId
+			staticWeights[i] = int(ability_.Weight) + 10
+		}
+		channel.Id = channelIds[pickWeightedIndex(getAdaptiveWeights(channelIds, staticWeights))]
+		err = DB.First(&channel, "id = ?", channel.Id).Error
+		return &channel, err
+	}
 	// Randomly choose one from valid channels
 	weightSum := uint(0)
 	for _, ability_ := range validAbilities {
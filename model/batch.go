@@ -0,0 +1,169 @@
+package model
+
+import (
+	"encoding/json"
+	"errors"
+	"one-api/dto"
+
+	"gorm.io/gorm"
+)
+
+const (
+	BatchStatusValidating = "validating"
+	BatchStatusFailed     = "failed"
+	BatchStatusInProgress = "in_progress"
+	BatchStatusFinalizing = "finalizing"
+	BatchStatusCompleted  = "completed"
+	BatchStatusExpired    = "expired"
+	BatchStatusCancelling = "cancelling"
+	BatchStatusCancelled  = "cancelled"
+)
+
+// Batch OpenAI Batch API 批量任务，由后台任务逐行经过正常的转发流程执行
+type Batch struct {
+	Id               string `json:"id" gorm:"type:varchar(64);primaryKey"`
+	UserId           int    `json:"user_id" gorm:"index"`
+	TokenId          int    `json:"token_id" gorm:"index"`
+	Endpoint         string `json:"endpoint" gorm:"type:varchar(64)"`
+	InputFileId      string `json:"input_file_id" gorm:"type:varchar(64)"`
+	CompletionWindow string `json:"completion_window" gorm:"type:varchar(16)"`
+	Status           string `json:"status" gorm:"type:varchar(20);index"`
+	OutputFileId     string `json:"output_file_id" gorm:"type:varchar(64)"`
+	ErrorFileId      string `json:"error_file_id" gorm:"type:varchar(64)"`
+	Errors           string `json:"errors" gorm:"type:text"`
+	Metadata         string `json:"metadata" gorm:"type:text"`
+	RequestTotal     int    `json:"request_total"`
+	RequestCompleted int    `json:"request_completed"`
+	RequestFailed    int    `json:"request_failed"`
+	Progress         int    `json:"progress"`     // 已处理的输入行数，服务重启后从此处继续
+	OutputBytes      int64  `json:"output_bytes"` // 与 Progress 对应的输出文件长度
+	ErrorBytes       int64  `json:"error_bytes"`  // 与 Progress 对应的错误文件长度
+	CreatedTime      int64  `json:"created_time" gorm:"bigint;index"`
+	InProgressTime   int64  `json:"in_progress_time" gorm:"bigint"`
+	ExpiresTime      int64  `json:"expires_time" gorm:"bigint"`
+	FinalizingTime   int64  `json:"finalizing_time" gorm:"bigint"`
+	CompletedTime    int64  `json:"completed_time" gorm:"bigint"`
+	FailedTime       int64  `json:"failed_time" gorm:"bigint"`
+	ExpiredTime      int64  `json:"expired_time" gorm:"bigint"`
+	CancellingTime   int64  `json:"cancelling_time" gorm:"bigint"`
+	CancelledTime    int64  `json:"cancelled_time" gorm:"bigint"`
+}
+
+func optionalTime(t int64) *int64 {
+	if t == 0 {
+		return nil
+	}
+	return &t
+}
+
+func optionalString(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
+
+func (batch *Batch) ToOpenAIBatch() *dto.OpenAIBatch {
+	openAIBatch := &dto.OpenAIBatch{
+		Id:               batch.Id,
+		Object:           "batch",
+		Endpoint:         batch.Endpoint,
+		InputFileId:      batch.InputFileId,
+		CompletionWindow: batch.CompletionWindow,
+		Status:           batch.Status,
+		OutputFileId:     optionalString(batch.OutputFileId),
+		ErrorFileId:      optionalString(batch.ErrorFileId),
+		CreatedAt:        batch.CreatedTime,
+		InProgressAt:     optionalTime(batch.InProgressTime),
+		ExpiresAt:        optionalTime(batch.ExpiresTime),
+		FinalizingAt:     optionalTime(batch.FinalizingTime),
+		CompletedAt:      optionalTime(batch.CompletedTime),
+		FailedAt:         optionalTime(batch.FailedTime),
+		ExpiredAt:        optionalTime(batch.ExpiredTime),
+		CancellingAt:     optionalTime(batch.CancellingTime),
+		CancelledAt:      optionalTime(batch.CancelledTime),
+		RequestCounts: dto.BatchRequestCounts{
+			Total:     batch.RequestTotal,
+			Completed: batch.RequestCompleted,
+			Failed:    batch.RequestFailed,
+		},
+	}
+	if batch.Errors != "" {
+		var batchErrors dto.BatchErrors
+		if err := json.Unmarshal([]byte(batch.Errors), &batchErrors); err == nil {
+			openAIBatch.Errors = &batchErrors
+		}
+	}
+	if batch.Metadata != "" {
+		_ = json.Unmarshal([]byte(batch.Metadata), &openAIBatch.Metadata)
+	}
+	return openAIBatch
+}
+
+func (batch *Batch) SetErrors(errs []dto.BatchError) {
+	data, _ := json.Marshal(dto.BatchErrors{Object: "list", Data: errs})
+	batch.Errors = string(data)
+}
+
+func (batch *Batch) Insert() error {
+	return DB.Create(batch).Error
+}
+
+func (batch *Batch) Update() error {
+	return DB.Save(batch).Error
+}
+
+// UpdateStatus 仅在状态未被其他请求修改时更新，返回是否更新成功
+func (batch *Batch) UpdateStatus(fromStatus string, fields map[string]any) (bool, error) {
+	result := DB.Model(&Batch{}).Where("id = ? and status = ?", batch.Id, fromStatus).Updates(fields)
+	return result.RowsAffected > 0, result.Error
+}
+
+// UpdateBatchProgress 保存执行进度，不修改状态，避免覆盖执行期间的取消操作
+func UpdateBatchProgress(batch *Batch) error {
+	return DB.Model(&Batch{}).Where("id = ?", batch.Id).Updates(map[string]any{
+		"progress":          batch.Progress,
+		"output_bytes":      batch.OutputBytes,
+		"error_bytes":       batch.ErrorBytes,
+		"request_completed": batch.RequestCompleted,
+		"request_failed":    batch.RequestFailed,
+	}).Error
+}
+
+func GetBatchById(id string) (*Batch, error) {
+	var batch Batch
+	err := DB.First(&batch, "id = ?", id).Error
+	return &batch, err
+}
+
+func GetBatchByIdAndUserId(id string, userId int) (*Batch, error) {
+	if id == "" || userId == 0 {
+		return nil, errors.New("id 或 userId 为空！")
+	}
+	var batch Batch
+	err := DB.First(&batch, "id = ? and user_id = ?", id, userId).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, errors.New("批量任务不存在")
+	}
+	return &batch, err
+}
+
+// GetUserBatches 按创建时间倒序分页查询用户批量任务，after 为上一页最后一个任务的 id
+func GetUserBatches(userId int, after string, limit int) (batches []*Batch, err error) {
+	query := DB.Where("user_id = ?", userId)
+	if after != "" {
+		var afterBatch Batch
+		if err = DB.First(&afterBatch, "id = ? and user_id = ?", after, userId).Error; err == nil {
+			query = query.Where("created_time < ? or (created_time = ? and id < ?)", afterBatch.CreatedTime, afterBatch.CreatedTime, afterBatch.Id)
+		}
+	}
+	err = query.Order("created_time desc, id desc").Limit(limit).Find(&batches).Error
+	return batches, err
+}
+
+// GetUnfinishedBatches 获取需要后台任务处理的批量任务，按创建时间先后处理
+func GetUnfinishedBatches(limit int) (batches []*Batch, err error) {
+	err = DB.Where("status in ?", []string{BatchStatusValidating, BatchStatusInProgress, BatchStatusFinalizing, BatchStatusCancelling}).
+		Order("created_time asc").Limit(limit).Find(&batches).Error
+	return batches, err
+}
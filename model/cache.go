@@ -5,7 +5,9 @@ import (
 	"fmt"
 	"math/rand"
 	"one-api/common"
+	"one-api/constant"
 	"one-api/setting"
+	"one-api/setting/operation_setting"
 	"sort"
 	"strings"
 	"sync"
@@ -82,6 +84,7 @@ func CacheGetRandomSatisfiedChannel(c *gin.Context, group string, model string,
 	var channel *Channel
 	var err error
 	selectGroup := group
+	lowPriority := c.GetBool(constant.ContextKeyLowPriority)
 	if group == "auto" {
 		if len(setting.AutoGroups) == 0 {
 			return nil, selectGroup, errors.New("auto groups is not enabled")
@@ -90,7 +93,7 @@ func CacheGetRandomSatisfiedChannel(c *gin.Context, group string, model string,
 			if common.DebugEnabled {
 				println("autoGroup:", autoGroup)
 			}
-			channel, _ = getRandomSatisfiedChannel(autoGroup, model, retry)
+			channel, _ = getRandomSatisfiedChannel(autoGroup, model, retry, lowPriority)
 			if channel == nil {
 				continue
 			} else {
@@ -103,7 +106,7 @@ func CacheGetRandomSatisfiedChannel(c *gin.Context, group string, model string,
 			}
 		}
 	} else {
-		channel, err = getRandomSatisfiedChannel(group, model, retry)
+		channel, err = getRandomSatisfiedChannel(group, model, retry, lowPriority)
 		if err != nil {
 			return nil, group, err
 		}
@@ -111,10 +114,13 @@ func CacheGetRandomSatisfiedChannel(c *gin.Context, group string, model string,
 	if channel == nil {
 		return nil, group, errors.New("channel not found")
 	}
+	AcquireChannelBreaker(channel.Id)
 	return channel, selectGroup, nil
 }
 
-func getRandomSatisfiedChannel(group string, model string, retry int) (*Channel, error) {
+// getRandomSatisfiedChannel 按 retry 选择优先级层级，retry 为 0 时使用最高优先级；
+// lowPriority 为 true 时从最低优先级开始，重试时逐级提高
+func getRandomSatisfiedChannel(group string, model string, retry int, lowPriority bool) (*Channel, error) {
 	if strings.HasPrefix(model, "gpt-4-gizmo") {
 		model = "gpt-4-gizmo-*"
 	}
@@ -124,7 +130,7 @@ func getRandomSatisfiedChannel(group string, model string, retry int) (*Channel,
 
 	// if memory cache is disabled, get channel directly from database
 	if !common.MemoryCacheEnabled {
-		return GetRandomSatisfiedChannel(group, model, retry)
+		return GetRandomSatisfiedChannel(group, model, retry, lowPriority)
 	}
 
 	channelSyncLock.RLock()
@@ -147,6 +153,9 @@ func getRandomSatisfiedChannel(group string, model string, retry int) (*Channel,
 		return nil, errors.New("no available channel (all channels exceeded quota limit)")
 	}
 
+	// 过滤掉熔断中的渠道
+	validChannels = filterChannelsByBreaker(validChannels)
+
 	uniquePriorities := make(map[int]bool)
 	for _, channel := range validChannels {
 		uniquePriorities[int(channel.GetPriority())] = true
@@ -155,7 +164,11 @@ func getRandomSatisfiedChannel(group string, model string, retry int) (*Channel,
 	for priority := range uniquePriorities {
 		sortedUniquePriorities = append(sortedUniquePriorities, priority)
 	}
-	sort.Sort(sort.Reverse(sort.IntSlice(sortedUniquePriorities)))
+	if lowPriority {
+		sort.Ints(sortedUniquePriorities)
+	} else {
+		sort.Sort(sort.Reverse(sort.IntSlice(sortedUniquePriorities)))
+	}
 
 	if retry >= len(uniquePriorities) {
 		retry = len(uniquePriorities) - 1
@@ -172,6 +185,18 @@ func getRandomSatisfiedChannel(group string, model string, retry int) (*Channel,
 
 	// 平滑系数
 	smoothingFactor := 10
+
+	// 自适应模式下根据渠道的延迟和成功率调整权重
+	if operation_setting.GetChannelAdaptiveSetting().Enabled {
+		channelIds := make([]int, len(targetChannels))
+		staticWeights := make([]int, len(targetChannels))
+		for i, channel := range targetChannels {
+			channelIds[i] = channel.Id
+			staticWeights[i] = channel.GetWeight() + smoothingFactor
+		}
+		return targetChannels[pickWeightedIndex(getAdaptiveWeights(channelIds, staticWeights))], nil
+	}
+
 	// Calculate the total weight of all channels up to endIdx
 	totalWeight := 0
 	for _, channel := range targetChannels {
@@ -215,3 +240,14 @@ func CacheUpdateChannelStatus(id int, status int) {
 		channel.Status = status
 	}
 }
+
+func CacheUpdateChannelMultiKeyStatus(id int, multiKeyStatus *string) {
+	if !common.MemoryCacheEnabled {
+		return
+	}
+	channelSyncLock.Lock()
+	defer channelSyncLock.Unlock()
+	if channel, ok := channelsIDM[id]; ok {
+		channel.MultiKeyStatus = multiKeyStatus
+	}
+}
@@ -56,6 +56,18 @@ type Channel struct {
 	RPMLimit          *int64 `json:"rpm_limit" gorm:"bigint;default:0"`           // RPM限制值（每分钟请求次数）
 	LastMinuteTime    int64  `json:"last_minute_time" gorm:"bigint;default:0"`    // 上一分钟的时间戳
 	CurrentMinuteUsed int64  `json:"current_minute_used" gorm:"bigint;default:0"` // 当前分钟已使用次数
+
+	// 渠道TPM和并发限制，0 表示不限制
+	TPMLimit         *int64 `json:"tpm_limit" gorm:"bigint;default:0"`         // 每分钟 token 数限制
+	ConcurrencyLimit *int64 `json:"concurrency_limit" gorm:"bigint;default:0"` // 最大同时处理的请求数
+
+	// 多密钥相关字段
+	MultiKeyEnabled *bool   `json:"multi_key_enabled" gorm:"default:false"`              // 是否启用多密钥（Key 按行分隔）
+	MultiKeyPolicy  *string `json:"multi_key_policy" gorm:"type:varchar(32);default:''"` // 密钥轮询策略
+	MultiKeyStatus  *string `json:"multi_key_status" gorm:"type:text"`                   // 各密钥状态（JSON）
+
+	Breaker *ChannelBreakerStatus `json:"breaker,omitempty" gorm:"-"` // 熔断器状态，仅用于接口展示
+	Stats   *ChannelStats         `json:"stats,omitempty" gorm:"-"`   // 自适应权重统计，仅用于接口展示
 }
 
 func (channel *Channel) GetModels() []string {
@@ -965,6 +977,20 @@ func (channel *Channel) SetRPMLimit(limit int64) {
 	channel.RPMLimit = &limit
 }
 
+func (channel *Channel) GetTPMLimit() int64 {
+	if channel.TPMLimit == nil {
+		return 0
+	}
+	return *channel.TPMLimit
+}
+
+func (channel *Channel) GetConcurrencyLimit() int64 {
+	if channel.ConcurrencyLimit == nil {
+		return 0
+	}
+	return *channel.ConcurrencyLimit
+}
+
 // Get channels of specified type with pagination
 func GetChannelsByType(startIdx int, num int, idSort bool, channelType int) ([]*Channel, error) {
 	var channels []*Channel
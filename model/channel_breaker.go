@@ -0,0 +1,253 @@
+package model
+
+import (
+	"fmt"
+	"one-api/common"
+	"one-api/setting/operation_setting"
+	"sync"
+	"time"
+)
+
+// 熔断器状态
+const (
+	BreakerStateClosed   = "closed"
+	BreakerStateOpen     = "open"
+	BreakerStateHalfOpen = "half_open"
+)
+
+const breakerBucketCount = 10
+
+type breakerBucket struct {
+	index    int64
+	total    int
+	failures int
+}
+
+type channelBreaker struct {
+	state    string
+	openedAt time.Time
+	probing  int
+	probeAt  time.Time
+	buckets  [breakerBucketCount]breakerBucket
+}
+
+// ChannelBreakerStatus 渠道熔断器状态快照
+type ChannelBreakerStatus struct {
+	State     string  `json:"state"`
+	Total     int     `json:"total"`
+	Failures  int     `json:"failures"`
+	ErrorRate float64 `json:"error_rate"`
+	OpenedAt  int64   `json:"opened_at,omitempty"`
+}
+
+var channelBreakers = make(map[int]*channelBreaker)
+var channelBreakerLock sync.Mutex
+
+func getChannelBreaker(channelId int) *channelBreaker {
+	breaker, ok := channelBreakers[channelId]
+	if !ok {
+		breaker = &channelBreaker{state: BreakerStateClosed}
+		channelBreakers[channelId] = breaker
+	}
+	return breaker
+}
+
+// bucketSeconds 每个统计桶覆盖的秒数
+func bucketSeconds(setting *operation_setting.ChannelBreakerSetting) int64 {
+	seconds := int64(setting.WindowSeconds) / breakerBucketCount
+	if seconds < 1 {
+		seconds = 1
+	}
+	return seconds
+}
+
+func (b *channelBreaker) counts(now time.Time, setting *operation_setting.ChannelBreakerSetting) (int, int) {
+	current := now.Unix() / bucketSeconds(setting)
+	total, failures := 0, 0
+	for _, bucket := range b.buckets {
+		if current-bucket.index < breakerBucketCount {
+			total += bucket.total
+			failures += bucket.failures
+		}
+	}
+	return total, failures
+}
+
+func (b *channelBreaker) reset() {
+	b.buckets = [breakerBucketCount]breakerBucket{}
+	b.probing = 0
+}
+
+// refresh 熔断时间结束后由 open 转入 half_open，长时间没有结果的探测名额会被回收
+func (b *channelBreaker) refresh(now time.Time, setting *operation_setting.ChannelBreakerSetting) {
+	openDuration := time.Duration(setting.OpenSeconds) * time.Second
+	if b.state == BreakerStateOpen && now.Sub(b.openedAt) >= openDuration {
+		b.state = BreakerStateHalfOpen
+		b.probing = 0
+	}
+	if b.state == BreakerStateHalfOpen && b.probing > 0 && now.Sub(b.probeAt) >= openDuration {
+		b.probing = 0
+	}
+}
+
+func (b *channelBreaker) available(setting *operation_setting.ChannelBreakerSetting) bool {
+	switch b.state {
+	case BreakerStateOpen:
+		return false
+	case BreakerStateHalfOpen:
+		return b.probing < setting.HalfOpenProbes
+	}
+	return true
+}
+
+// IsChannelBreakerAvailable 渠道选择时判断熔断器是否放行
+func IsChannelBreakerAvailable(channelId int) bool {
+	setting := operation_setting.GetChannelBreakerSetting()
+	if !setting.Enabled {
+		return true
+	}
+	channelBreakerLock.Lock()
+	defer channelBreakerLock.Unlock()
+	breaker, ok := channelBreakers[channelId]
+	if !ok {
+		return true
+	}
+	breaker.refresh(time.Now(), setting)
+	return breaker.available(setting)
+}
+
+// AcquireChannelBreaker 渠道被选中后调用，半开状态下占用一个探测名额
+func AcquireChannelBreaker(channelId int) {
+	setting := operation_setting.GetChannelBreakerSetting()
+	if !setting.Enabled {
+		return
+	}
+	channelBreakerLock.Lock()
+	defer channelBreakerLock.Unlock()
+	breaker, ok := channelBreakers[channelId]
+	if !ok {
+		return
+	}
+	now := time.Now()
+	breaker.refresh(now, setting)
+	if breaker.state == BreakerStateHalfOpen {
+		breaker.probing++
+		breaker.probeAt = now
+	}
+}
+
+// RecordChannelBreakerResult 记录一次渠道请求结果并更新熔断器状态，latency 为收到上游首个响应的耗时
+func RecordChannelBreakerResult(channelId int, channelName string, success bool, latency time.Duration) {
+	setting := operation_setting.GetChannelBreakerSetting()
+	if !setting.Enabled {
+		return
+	}
+	if success && setting.SlowRequestSeconds > 0 && latency >= time.Duration(setting.SlowRequestSeconds)*time.Second {
+		success = false
+	}
+
+	now := time.Now()
+	channelBreakerLock.Lock()
+	breaker := getChannelBreaker(channelId)
+	breaker.refresh(now, setting)
+	previous := breaker.state
+
+	switch breaker.state {
+	case BreakerStateHalfOpen:
+		if breaker.probing > 0 {
+			breaker.probing--
+		}
+		if success {
+			breaker.state = BreakerStateClosed
+			breaker.reset()
+		} else {
+			breaker.state = BreakerStateOpen
+			breaker.openedAt = now
+		}
+	case BreakerStateClosed:
+		index := now.Unix() / bucketSeconds(setting)
+		bucket := &breaker.buckets[index%breakerBucketCount]
+		if bucket.index != index {
+			*bucket = breakerBucket{index: index}
+		}
+		bucket.total++
+		if !success {
+			bucket.failures++
+		}
+		total, failures := breaker.counts(now, setting)
+		if total >= setting.MinRequests && total > 0 && float64(failures)/float64(total) >= setting.ErrorRateThreshold {
+			breaker.state = BreakerStateOpen
+			breaker.openedAt = now
+			breaker.reset()
+		}
+	}
+	current := breaker.state
+	channelBreakerLock.Unlock()
+
+	if previous != current {
+		common.SysLog(fmt.Sprintf("渠道 %s (ID: %d) 熔断器状态变更: %s -> %s", channelName, channelId, previous, current))
+		common.ChannelBreakerTransitions.WithLabelValues(fmt.Sprintf("%d", channelId), channelName, current).Inc()
+	}
+	common.ChannelBreakerState.WithLabelValues(fmt.Sprintf("%d", channelId), channelName).Set(breakerStateValue(current))
+}
+
+func breakerStateValue(state string) float64 {
+	switch state {
+	case BreakerStateOpen:
+		return 2
+	case BreakerStateHalfOpen:
+		return 1
+	}
+	return 0
+}
+
+// GetChannelBreakerStatus 返回渠道熔断器状态，未启用或没有记录时为 closed
+func GetChannelBreakerStatus(channelId int) ChannelBreakerStatus {
+	setting := operation_setting.GetChannelBreakerSetting()
+	status := ChannelBreakerStatus{State: BreakerStateClosed}
+	if !setting.Enabled {
+		return status
+	}
+	now := time.Now()
+	channelBreakerLock.Lock()
+	defer channelBreakerLock.Unlock()
+	breaker, ok := channelBreakers[channelId]
+	if !ok {
+		return status
+	}
+	breaker.refresh(now, setting)
+	status.State = breaker.state
+	status.Total, status.Failures = breaker.counts(now, setting)
+	if status.Total > 0 {
+		status.ErrorRate = float64(status.Failures) / float64(status.Total)
+	}
+	if breaker.state != BreakerStateClosed {
+		status.OpenedAt = breaker.openedAt.Unix()
+	}
+	return status
+}
+
+// ResetChannelBreaker 手动将渠道熔断器恢复为 closed
+func ResetChannelBreaker(channelId int) {
+	channelBreakerLock.Lock()
+	delete(channelBreakers, channelId)
+	channelBreakerLock.Unlock()
+	common.ChannelBreakerState.DeletePartialMatch(map[string]string{"channel_id": fmt.Sprintf("%d", channelId)})
+}
+
+// filterChannelsByBreaker 过滤掉熔断中的渠道，全部熔断时返回原列表，避免整体不可用
+func filterChannelsByBreaker(channels []*Channel) []*Channel {
+	if !operation_setting.GetChannelBreakerSetting().Enabled {
+		return channels
+	}
+	available := make([]*Channel, 0, len(channels))
+	for _, channel := range channels {
+		if IsChannelBreakerAvailable(channel.Id) {
+			available = append(available, channel)
+		}
+	}
+	if len(available) == 0 {
+		return channels
+	}
+	return available
+}
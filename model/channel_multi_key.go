@@ -0,0 +1,316 @@
+package model
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"one-api/common"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 多密钥轮询策略
+const (
+	MultiKeyPolicyRoundRobin  = "round_robin"
+	MultiKeyPolicyRandom      = "random"
+	MultiKeyPolicyLeastFailed = "least_failed"
+)
+
+// ChannelKeyStatus 多密钥渠道中单个密钥的状态，持久化在 Channel.MultiKeyStatus 中
+type ChannelKeyStatus struct {
+	Status        int    `json:"status"`
+	Reason        string `json:"reason,omitempty"`
+	StatusTime    int64  `json:"status_time,omitempty"`
+	LastError     string `json:"last_error,omitempty"`
+	LastErrorTime int64  `json:"last_error_time,omitempty"`
+	FailCount     int64  `json:"fail_count"`
+}
+
+// ChannelKeyInfo 管理接口返回的单个密钥信息（密钥已脱敏）
+type ChannelKeyInfo struct {
+	Index     int    `json:"index"`
+	MaskedKey string `json:"masked_key"`
+	ChannelKeyStatus
+	LastUsedTime int64 `json:"last_used_time"`
+}
+
+// channelKeyRuntime 密钥的运行时统计，仅保存在内存中
+type channelKeyRuntime struct {
+	cursor        uint64
+	lastUsedTime  map[int]int64
+	lastErrorTime map[int]int64
+}
+
+const allKeysDisabledReason = "所有密钥均已被禁用"
+
+// ErrChannelNoEnabledKey 渠道没有可用密钥，调用方应改选其他渠道
+var ErrChannelNoEnabledKey = errors.New("渠道没有可用密钥")
+
+var channelKeyRuntimes = make(map[int]*channelKeyRuntime)
+var channelKeyRuntimeLock sync.Mutex
+var channelKeyStatusLock sync.Mutex
+
+func (channel *Channel) GetMultiKeyEnabled() bool {
+	if channel.MultiKeyEnabled == nil {
+		return false
+	}
+	return *channel.MultiKeyEnabled
+}
+
+func (channel *Channel) GetMultiKeyPolicy() string {
+	if channel.MultiKeyPolicy == nil || *channel.MultiKeyPolicy == "" {
+		return MultiKeyPolicyRoundRobin
+	}
+	return *channel.MultiKeyPolicy
+}
+
+// GetKeys 返回渠道的全部密钥，未启用多密钥时只有一个
+func (channel *Channel) GetKeys() []string {
+	if !channel.GetMultiKeyEnabled() {
+		return []string{channel.Key}
+	}
+	keys := make([]string, 0)
+	for _, key := range strings.Split(channel.Key, "\n") {
+		key = strings.TrimSpace(key)
+		if key != "" {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+func (channel *Channel) GetMultiKeyStatus() map[int]*ChannelKeyStatus {
+	statusMap := make(map[int]*ChannelKeyStatus)
+	if channel.MultiKeyStatus != nil && *channel.MultiKeyStatus != "" {
+		err := json.Unmarshal([]byte(*channel.MultiKeyStatus), &statusMap)
+		if err != nil {
+			common.SysError("failed to unmarshal multi key status: " + err.Error())
+		}
+	}
+	return statusMap
+}
+
+func (channel *Channel) SetMultiKeyStatus(statusMap map[int]*ChannelKeyStatus) {
+	statusBytes, err := json.Marshal(statusMap)
+	if err != nil {
+		common.SysError("failed to marshal multi key status: " + err.Error())
+		return
+	}
+	channel.MultiKeyStatus = common.GetPointer[string](string(statusBytes))
+}
+
+// getEnabledKeyIndexes 返回当前可用的密钥下标
+func (channel *Channel) getEnabledKeyIndexes(keys []string) []int {
+	statusMap := channel.GetMultiKeyStatus()
+	indexes := make([]int, 0, len(keys))
+	for i := range keys {
+		if status, ok := statusMap[i]; ok && status.Status != common.ChannelStatusEnabled {
+			continue
+		}
+		indexes = append(indexes, i)
+	}
+	return indexes
+}
+
+func getChannelKeyRuntime(channelId int) *channelKeyRuntime {
+	runtime, ok := channelKeyRuntimes[channelId]
+	if !ok {
+		runtime = &channelKeyRuntime{
+			lastUsedTime:  make(map[int]int64),
+			lastErrorTime: make(map[int]int64),
+		}
+		channelKeyRuntimes[channelId] = runtime
+	}
+	return runtime
+}
+
+// GetNextEnabledKey 按照渠道的轮询策略选择一个可用密钥，返回密钥及其下标
+func (channel *Channel) GetNextEnabledKey() (string, int, error) {
+	if !channel.GetMultiKeyEnabled() {
+		return channel.Key, 0, nil
+	}
+	keys := channel.GetKeys()
+	if len(keys) == 0 {
+		return "", -1, ErrChannelNoEnabledKey
+	}
+	indexes := channel.getEnabledKeyIndexes(keys)
+	if len(indexes) == 0 {
+		return "", -1, fmt.Errorf("%w：%s", ErrChannelNoEnabledKey, allKeysDisabledReason)
+	}
+
+	channelKeyRuntimeLock.Lock()
+	defer channelKeyRuntimeLock.Unlock()
+	runtime := getChannelKeyRuntime(channel.Id)
+
+	selected := indexes[0]
+	switch channel.GetMultiKeyPolicy() {
+	case MultiKeyPolicyRandom:
+		selected = indexes[rand.Intn(len(indexes))]
+	case MultiKeyPolicyLeastFailed:
+		// 优先选择最久未失败的密钥，相同时选择最久未使用的
+		for _, index := range indexes[1:] {
+			if runtime.lastErrorTime[index] < runtime.lastErrorTime[selected] ||
+				(runtime.lastErrorTime[index] == runtime.lastErrorTime[selected] && runtime.lastUsedTime[index] < runtime.lastUsedTime[selected]) {
+				selected = index
+			}
+		}
+	default:
+		selected = indexes[runtime.cursor%uint64(len(indexes))]
+		runtime.cursor++
+	}
+	runtime.lastUsedTime[selected] = time.Now().UnixMilli()
+	return keys[selected], selected, nil
+}
+
+// GetKeyByIndex 返回指定下标的密钥，用于异步任务查询等必须使用提交时密钥的场景；
+// 下标无效（如旧数据或渠道密钥已调整）时按轮询策略重新选择
+func (channel *Channel) GetKeyByIndex(index int) (string, error) {
+	keys := channel.GetKeys()
+	if index >= 0 && index < len(keys) {
+		return keys[index], nil
+	}
+	key, _, err := channel.GetNextEnabledKey()
+	return key, err
+}
+
+// RecordChannelKeyError 记录密钥最近一次失败时间，供 least_failed 策略使用
+func RecordChannelKeyError(channelId int, keyIndex int) {
+	if keyIndex < 0 {
+		return
+	}
+	channelKeyRuntimeLock.Lock()
+	defer channelKeyRuntimeLock.Unlock()
+	getChannelKeyRuntime(channelId).lastErrorTime[keyIndex] = time.Now().UnixMilli()
+}
+
+// GetChannelKeyInfos 返回渠道每个密钥的状态信息
+func GetChannelKeyInfos(channel *Channel) []ChannelKeyInfo {
+	keys := channel.GetKeys()
+	statusMap := channel.GetMultiKeyStatus()
+
+	channelKeyRuntimeLock.Lock()
+	runtime := getChannelKeyRuntime(channel.Id)
+	infos := make([]ChannelKeyInfo, 0, len(keys))
+	for i, key := range keys {
+		info := ChannelKeyInfo{
+			Index:     i,
+			MaskedKey: maskChannelKey(key),
+			ChannelKeyStatus: ChannelKeyStatus{
+				Status: common.ChannelStatusEnabled,
+			},
+			LastUsedTime: runtime.lastUsedTime[i] / 1000,
+		}
+		if status, ok := statusMap[i]; ok {
+			info.ChannelKeyStatus = *status
+		}
+		infos = append(infos, info)
+	}
+	channelKeyRuntimeLock.Unlock()
+	return infos
+}
+
+func maskChannelKey(key string) string {
+	if len(key) <= 8 {
+		return strings.Repeat("*", len(key))
+	}
+	return key[:4] + strings.Repeat("*", 4) + key[len(key)-4:]
+}
+
+// UpdateChannelKeyStatus 更新多密钥渠道中指定密钥的状态。
+// 所有密钥都被禁用时自动禁用整个渠道，重新启用密钥时恢复被自动禁用的渠道。
+func UpdateChannelKeyStatus(channelId int, keyIndex int, status int, reason string) (bool, error) {
+	channelKeyStatusLock.Lock()
+	defer channelKeyStatusLock.Unlock()
+
+	channel, err := GetChannelById(channelId, true)
+	if err != nil {
+		return false, err
+	}
+	if !channel.GetMultiKeyEnabled() {
+		return false, errors.New("该渠道未启用多密钥模式")
+	}
+	keys := channel.GetKeys()
+	if keyIndex < 0 || keyIndex >= len(keys) {
+		return false, fmt.Errorf("密钥下标 %d 超出范围", keyIndex)
+	}
+
+	statusMap := channel.GetMultiKeyStatus()
+	keyStatus, ok := statusMap[keyIndex]
+	if !ok {
+		keyStatus = &ChannelKeyStatus{Status: common.ChannelStatusEnabled}
+		statusMap[keyIndex] = keyStatus
+	}
+	if keyStatus.Status == status {
+		return false, nil
+	}
+	keyStatus.Status = status
+	keyStatus.StatusTime = common.GetTimestamp()
+	if status == common.ChannelStatusEnabled {
+		keyStatus.Reason = ""
+	} else {
+		keyStatus.Reason = reason
+	}
+	if status == common.ChannelStatusAutoDisabled {
+		keyStatus.LastError = reason
+		keyStatus.LastErrorTime = keyStatus.StatusTime
+		keyStatus.FailCount++
+	}
+	if err = saveChannelMultiKeyStatus(channel, statusMap); err != nil {
+		return false, err
+	}
+
+	enabledCount := len(channel.getEnabledKeyIndexes(keys))
+	if enabledCount == 0 && channel.Status == common.ChannelStatusEnabled {
+		UpdateChannelStatusById(channelId, common.ChannelStatusAutoDisabled, allKeysDisabledReason)
+	} else if enabledCount > 0 && isDisabledByAllKeys(channel) {
+		UpdateChannelStatusById(channelId, common.ChannelStatusEnabled, "")
+	}
+	return true, nil
+}
+
+// EnableAllChannelKeys 重新启用多密钥渠道的全部密钥
+func EnableAllChannelKeys(channelId int) error {
+	channelKeyStatusLock.Lock()
+	channel, err := GetChannelById(channelId, true)
+	if err != nil {
+		channelKeyStatusLock.Unlock()
+		return err
+	}
+	statusMap := channel.GetMultiKeyStatus()
+	for _, keyStatus := range statusMap {
+		if keyStatus.Status != common.ChannelStatusEnabled {
+			keyStatus.Status = common.ChannelStatusEnabled
+			keyStatus.Reason = ""
+			keyStatus.StatusTime = common.GetTimestamp()
+		}
+	}
+	err = saveChannelMultiKeyStatus(channel, statusMap)
+	channelKeyStatusLock.Unlock()
+	if err != nil {
+		return err
+	}
+	if isDisabledByAllKeys(channel) {
+		UpdateChannelStatusById(channelId, common.ChannelStatusEnabled, "")
+	}
+	return nil
+}
+
+// isDisabledByAllKeys 渠道是否因为全部密钥被禁用而被自动禁用
+func isDisabledByAllKeys(channel *Channel) bool {
+	if channel.Status != common.ChannelStatusAutoDisabled {
+		return false
+	}
+	return channel.GetOtherInfo()["status_reason"] == allKeysDisabledReason
+}
+
+func saveChannelMultiKeyStatus(channel *Channel, statusMap map[int]*ChannelKeyStatus) error {
+	channel.SetMultiKeyStatus(statusMap)
+	err := DB.Model(&Channel{}).Where("id = ?", channel.Id).Update("multi_key_status", channel.MultiKeyStatus).Error
+	if err != nil {
+		return err
+	}
+	CacheUpdateChannelMultiKeyStatus(channel.Id, channel.MultiKeyStatus)
+	return nil
+}
@@ -0,0 +1,229 @@
+package model
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"one-api/common"
+	"one-api/setting/operation_setting"
+	"sync"
+	"time"
+)
+
+// ChannelStats 渠道运行统计，使用 EWMA 平滑
+type ChannelStats struct {
+	FirstTokenMs float64 `json:"first_token_ms"` // 首字时间
+	LatencyMs    float64 `json:"latency_ms"`     // 总耗时
+	SuccessRate  float64 `json:"success_rate"`   // 成功率
+	Samples      int64   `json:"samples"`
+	UpdatedAt    int64   `json:"updated_at"`
+}
+
+var channelStats = make(map[int]*ChannelStats)
+var channelStatsLock sync.RWMutex
+
+func ewma(alpha float64, current float64, sample float64) float64 {
+	return alpha*sample + (1-alpha)*current
+}
+
+// RecordChannelStats 记录一次渠道请求的首字时间、总耗时和结果。
+// firstToken 为 0 表示没有首字时间（例如请求失败）。
+func RecordChannelStats(channelId int, success bool, firstToken time.Duration, latency time.Duration) {
+	setting := operation_setting.GetChannelAdaptiveSetting()
+	if !setting.Enabled {
+		return
+	}
+	successSample := 0.0
+	if success {
+		successSample = 1
+	}
+	channelStatsLock.Lock()
+	defer channelStatsLock.Unlock()
+	stats, ok := channelStats[channelId]
+	if !ok {
+		stats = &ChannelStats{SuccessRate: successSample}
+		if success {
+			stats.LatencyMs = float64(latency.Milliseconds())
+			stats.FirstTokenMs = float64(firstToken.Milliseconds())
+		}
+		channelStats[channelId] = stats
+	} else {
+		stats.SuccessRate = ewma(setting.Alpha, stats.SuccessRate, successSample)
+		// 失败请求的耗时不代表上游速度，只计入成功率
+		if success {
+			stats.LatencyMs = ewma(setting.Alpha, stats.LatencyMs, float64(latency.Milliseconds()))
+			if firstToken > 0 {
+				stats.FirstTokenMs = ewma(setting.Alpha, stats.FirstTokenMs, float64(firstToken.Milliseconds()))
+			}
+		}
+	}
+	stats.Samples++
+	stats.UpdatedAt = common.GetTimestamp()
+}
+
+// GetChannelStats 返回渠道统计快照
+func GetChannelStats(channelId int) (ChannelStats, bool) {
+	channelStatsLock.RLock()
+	defer channelStatsLock.RUnlock()
+	stats, ok := channelStats[channelId]
+	if !ok {
+		return ChannelStats{}, false
+	}
+	return *stats, true
+}
+
+func (stats *ChannelStats) latencyScore(setting *operation_setting.ChannelAdaptiveSetting) float64 {
+	if stats.FirstTokenMs <= 0 {
+		return stats.LatencyMs
+	}
+	return setting.FirstTokenWeight*stats.FirstTokenMs + (1-setting.FirstTokenWeight)*stats.LatencyMs
+}
+
+// getAdaptiveWeights 计算同一优先级内各渠道的有效权重。
+// 有效权重 = (静态权重 + 平滑系数) × 成功率² × 延迟系数，延迟系数为平均延迟与渠道延迟之比。
+func getAdaptiveWeights(channelIds []int, staticWeights []int) []float64 {
+	setting := operation_setting.GetChannelAdaptiveSetting()
+	weights := make([]float64, len(channelIds))
+	for i := range channelIds {
+		weights[i] = float64(staticWeights[i])
+	}
+	if !setting.Enabled {
+		return weights
+	}
+
+	channelStatsLock.RLock()
+	scores := make([]float64, len(channelIds))
+	successRates := make([]float64, len(channelIds))
+	totalScore, scoredCount := 0.0, 0
+	for i, id := range channelIds {
+		successRates[i] = 1
+		stats, ok := channelStats[id]
+		if !ok || stats.Samples < int64(setting.MinSamples) {
+			continue
+		}
+		successRates[i] = stats.SuccessRate
+		scores[i] = stats.latencyScore(setting)
+		if scores[i] > 0 {
+			totalScore += scores[i]
+			scoredCount++
+		}
+	}
+	channelStatsLock.RUnlock()
+
+	avgScore := 0.0
+	if scoredCount > 0 {
+		avgScore = totalScore / float64(scoredCount)
+	}
+	for i := range channelIds {
+		factor := 1.0
+		if scores[i] > 0 && avgScore > 0 {
+			factor = avgScore / scores[i]
+			if factor < setting.MinFactor {
+				factor = setting.MinFactor
+			}
+			if factor > setting.MaxFactor {
+				factor = setting.MaxFactor
+			}
+		}
+		weights[i] = weights[i] * successRates[i] * successRates[i] * factor
+	}
+	return weights
+}
+
+// pickWeightedIndex 按权重随机选择一个下标，所有权重都为 0 时等概率选择
+func pickWeightedIndex(weights []float64) int {
+	total := 0.0
+	for _, weight := range weights {
+		total += weight
+	}
+	if total <= 0 {
+		return common.GetRandomInt(len(weights))
+	}
+	random := rand.Float64() * total
+	for i, weight := range weights {
+		random -= weight
+		if random < 0 {
+			return i
+		}
+	}
+	return len(weights) - 1
+}
+
+func channelStatsRedisKey(channelId int) string {
+	return fmt.Sprintf("channel_stats:%d", channelId)
+}
+
+// syncChannelStatsWithRedis 将本节点的统计与 Redis 中的统计取平均，实现多节点共享
+func syncChannelStatsWithRedis() {
+	channelStatsLock.RLock()
+	snapshot := make(map[int]ChannelStats, len(channelStats))
+	for id, stats := range channelStats {
+		snapshot[id] = *stats
+	}
+	channelStatsLock.RUnlock()
+	// 本节点还没有请求过的渠道直接使用其他节点的统计
+	channelSyncLock.RLock()
+	for id := range channelsIDM {
+		if _, ok := snapshot[id]; !ok {
+			snapshot[id] = ChannelStats{}
+		}
+	}
+	channelSyncLock.RUnlock()
+
+	expiration := time.Duration(operation_setting.GetChannelAdaptiveSetting().SyncIntervalSeconds) * time.Second * 30
+	for id, local := range snapshot {
+		merged := local
+		value, err := common.RedisGet(channelStatsRedisKey(id))
+		if err == nil && value != "" {
+			var remote ChannelStats
+			if json.Unmarshal([]byte(value), &remote) == nil && remote.Samples > 0 {
+				if local.Samples == 0 {
+					merged = remote
+				} else {
+					merged.FirstTokenMs = (local.FirstTokenMs + remote.FirstTokenMs) / 2
+					merged.LatencyMs = (local.LatencyMs + remote.LatencyMs) / 2
+					merged.SuccessRate = (local.SuccessRate + remote.SuccessRate) / 2
+					if remote.Samples > merged.Samples {
+						merged.Samples = remote.Samples
+					}
+				}
+			}
+		}
+		if merged.Samples == 0 {
+			continue
+		}
+		data, err := json.Marshal(merged)
+		if err != nil {
+			continue
+		}
+		if err = common.RedisSet(channelStatsRedisKey(id), string(data), expiration); err != nil {
+			common.SysError("failed to sync channel stats: " + err.Error())
+			continue
+		}
+		channelStatsLock.Lock()
+		if stats, ok := channelStats[id]; ok {
+			stats.FirstTokenMs = merged.FirstTokenMs
+			stats.LatencyMs = merged.LatencyMs
+			stats.SuccessRate = merged.SuccessRate
+			stats.Samples = merged.Samples
+		} else {
+			channelStats[id] = &merged
+		}
+		channelStatsLock.Unlock()
+	}
+}
+
+// SyncChannelStats 定时通过 Redis 同步渠道统计
+func SyncChannelStats() {
+	for {
+		setting := operation_setting.GetChannelAdaptiveSetting()
+		interval := setting.SyncIntervalSeconds
+		if interval <= 0 {
+			interval = 10
+		}
+		time.Sleep(time.Duration(interval) * time.Second)
+		if setting.Enabled && common.RedisEnabled {
+			syncChannelStatsWithRedis()
+		}
+	}
+}
@@ -14,7 +14,7 @@ import (
 
 // GetConversationHistoryTableName 获取对话历史表名（支持日期分表）
 func GetConversationHistoryTableName(date ...time.Time) string {
-	if !common.MESDailyPartition {
+	if !useMESDailyTables() {
 		return "conversation_histories"
 	}
 
@@ -28,8 +28,11 @@ func GetConversationHistoryTableName(date ...time.Time) string {
 	return fmt.Sprintf("conversation_histories_%s", targetDate.Format("2006_01_02"))
 }
 
-// ensureConversationHistoryTableExists 确保对话历史表存在
-func ensureConversationHistoryTableExists(tableName string) error {
+// ensureConversationHistoryTableExists 确保对话历史表存在，原生分区模式下按 date 创建记录所在的分区
+func ensureConversationHistoryTableExists(tableName string, date time.Time) error {
+	if IsMESNativePartition() {
+		return ensureNativePartition(tableName, false, date)
+	}
 	if MES_DB.Migrator().HasTable(tableName) {
 		return nil
 	}
@@ -37,38 +40,12 @@ func ensureConversationHistoryTableExists(tableName string) error {
 	return MES_DB.Table(tableName).AutoMigrate(&ConversationHistory{})
 }
 
-// getConversationHistoryAllTables 获取所有存在的对话历史分表
+// getConversationHistoryAllTables 获取所有存在的对话历史分表，按日期升序排列
 func getConversationHistoryAllTables() []string {
-	var tables []string
-
-	if !common.MESDailyPartition {
-		return []string{"conversation_histories"}
+	if !useMESDailyTables() {
+		return []string{ConversationHistoryBaseTable}
 	}
-
-	// 获取数据库中所有以 conversation_histories_ 开头的表
-	var tableNames []string
-	err := MES_DB.Raw("SHOW TABLES LIKE 'conversation_histories_%'").Scan(&tableNames).Error
-	if err != nil {
-		// 如果查询失败，回退到检查最近一年的表
-		now := time.Now()
-		for i := 0; i < 365; i++ {
-			pastDate := now.AddDate(0, 0, -i)
-			tableName := GetConversationHistoryTableName(pastDate)
-			if MES_DB.Migrator().HasTable(tableName) {
-				tables = append(tables, tableName)
-			}
-		}
-		return tables
-	}
-
-	tables = append(tables, tableNames...)
-
-	// 添加基础表（如果存在的话）
-	if MES_DB.Migrator().HasTable("conversation_histories") {
-		tables = append(tables, "conversation_histories")
-	}
-
-	return tables
+	return getMESPartitionTables(ConversationHistoryBaseTable)
 }
 
 // getDateRange 获取日期范围内的所有表名
@@ -99,20 +76,41 @@ func (ConversationHistory) TableName() string {
 	return "conversation_histories"
 }
 
-// CreateConversationHistory 创建对话历史记录
-func CreateConversationHistory(c *gin.Context, conversationId string, modelName string, rawJson string, userId int) error {
+// CreateConversationHistory 创建对话历史记录，tokenId 用于全文检索按令牌过滤，
+// retentionDays 为存储策略中的保留天数，0 表示按全局清理天数清理
+func CreateConversationHistory(c *gin.Context, conversationId string, modelName string, rawJson string, userId int, tokenId int, retentionDays int) error {
+	plainJson := rawJson
+	if common.ConversationHistoryDedup {
+		compact, err := dedupConversationRawJson(userId, conversationId, rawJson)
+		if err != nil {
+			// 去重失败时仍然保存完整的对话
+			common.LogError(c, "failed to dedup conversation history: "+err.Error())
+		} else {
+			rawJson = compact
+		}
+	}
+
+	rawJson, err := encryptHistoryField(rawJson)
+	if err != nil {
+		common.LogError(c, "failed to encrypt conversation history: "+err.Error())
+		return err
+	}
+
+	// 分表和分区都按记录写入的 created_at 计算，避免跨零点时写入前一天的分区
+	now := time.Now()
 	history := &ConversationHistory{
 		ConversationId: conversationId,
 		ModelName:      modelName,
 		RawJson:        rawJson,
 		UserId:         userId,
+		CreatedAt:      now,
 	}
 
 	// 获取当天的表名
-	tableName := GetConversationHistoryTableName()
+	tableName := GetConversationHistoryTableName(now)
 
 	// 确保表存在（双重保障）
-	err := ensureConversationHistoryTableExists(tableName)
+	err = ensureConversationHistoryTableExists(tableName, now)
 	if err != nil {
 		common.LogError(c, "failed to ensure conversation history table exists: "+err.Error())
 		return err
@@ -124,6 +122,17 @@ func CreateConversationHistory(c *gin.Context, conversationId string, modelName
 		return err
 	}
 
+	if err = createConversationHistoryRetention(tableName, history.Id, history.CreatedAt, retentionDays); err != nil {
+		common.LogError(c, "failed to save conversation history retention: "+err.Error())
+	}
+
+	if common.ConversationHistorySearch {
+		// 索引写入失败不影响对话历史本身，可以通过重建索引补齐
+		if err = indexConversationHistory(tableName, history, plainJson, tokenId); err != nil {
+			common.LogError(c, "failed to index conversation history: "+err.Error())
+		}
+	}
+
 	return nil
 }
 
@@ -131,13 +140,13 @@ func CreateConversationHistory(c *gin.Context, conversationId string, modelName
 func GetConversationHistoryById(id int) (*ConversationHistory, error) {
 	var history ConversationHistory
 
-	if !common.MESDailyPartition {
+	if !useMESDailyTables() {
 		// 不分表，直接查询
 		err := MES_DB.Table("conversation_histories").First(&history, id).Error
 		if err != nil {
 			return nil, err
 		}
-		return &history, nil
+		return &history, decodeConversationHistories([]*ConversationHistory{&history})
 	}
 
 	// 分表模式：查询所有存在的表
@@ -150,7 +159,7 @@ func GetConversationHistoryById(id int) (*ConversationHistory, error) {
 
 		err := MES_DB.Table(tableName).First(&history, id).Error
 		if err == nil {
-			return &history, nil
+			return &history, decodeConversationHistories([]*ConversationHistory{&history})
 		}
 	}
 
@@ -162,7 +171,7 @@ func GetConversationHistoriesByConversationId(conversationId string, limit int,
 	var histories []*ConversationHistory
 	var total int64
 
-	if !common.MESDailyPartition {
+	if !useMESDailyTables() {
 		// 不分表，直接查询
 		err := MES_DB.Table("conversation_histories").Model(&ConversationHistory{}).Where("conversation_id = ?", conversationId).Count(&total).Error
 		if err != nil {
@@ -179,11 +188,11 @@ func GetConversationHistoriesByConversationId(conversationId string, limit int,
 			return nil, 0, err
 		}
 
-		return histories, total, nil
+		return histories, total, decodeConversationHistories(histories)
 	}
 
 	// 分表模式：查询所有存在的表
-	tables := getConversationHistoryAllTables()
+	tables := getConversationHistoryTablesNewestFirst()
 
 	// 先获取总数
 	total = 0
@@ -236,7 +245,7 @@ func GetConversationHistoriesByConversationId(conversationId string, limit int,
 		collected += len(tableHistories)
 	}
 
-	return histories, total, nil
+	return histories, total, decodeConversationHistories(histories)
 }
 
 // GetConversationHistoriesByUserId 根据用户ID获取对话历史列表
@@ -244,7 +253,7 @@ func GetConversationHistoriesByUserId(userId int, limit int, offset int) ([]*Con
 	var histories []*ConversationHistory
 	var total int64
 
-	if !common.MESDailyPartition {
+	if !useMESDailyTables() {
 		// 不分表，直接查询
 		err := MES_DB.Table("conversation_histories").Model(&ConversationHistory{}).Where("user_id = ?", userId).Count(&total).Error
 		if err != nil {
@@ -261,11 +270,11 @@ func GetConversationHistoriesByUserId(userId int, limit int, offset int) ([]*Con
 			return nil, 0, err
 		}
 
-		return histories, total, nil
+		return histories, total, decodeConversationHistories(histories)
 	}
 
 	// 分表模式：查询所有存在的表
-	tables := getConversationHistoryAllTables()
+	tables := getConversationHistoryTablesNewestFirst()
 
 	// 先获取总数
 	total = 0
@@ -318,12 +327,16 @@ func GetConversationHistoriesByUserId(userId int, limit int, offset int) ([]*Con
 		collected += len(tableHistories)
 	}
 
-	return histories, total, nil
+	return histories, total, decodeConversationHistories(histories)
 }
 
 // UpdateConversationHistory 更新对话历史
 func UpdateConversationHistory(id int, rawJson string) error {
-	if !common.MESDailyPartition {
+	rawJson, err := encryptHistoryField(rawJson)
+	if err != nil {
+		return err
+	}
+	if !useMESDailyTables() {
 		return MES_DB.Table("conversation_histories").Model(&ConversationHistory{}).Where("id = ?", id).Update("raw_json", rawJson).Error
 	}
 
@@ -346,24 +359,43 @@ func UpdateConversationHistory(id int, rawJson string) error {
 	return gorm.ErrRecordNotFound
 }
 
-// DeleteConversationHistory 软删除对话历史
-func DeleteConversationHistory(id int) error {
-	if !common.MESDailyPartition {
-		return MES_DB.Table("conversation_histories").Delete(&ConversationHistory{}, id).Error
+// deleteConversationHistoryFromTable 从表中删除一条对话历史，hard 为 true 时硬删除，返回是否找到了记录。
+// 删除后清理该对话不再被引用的去重消息。
+func deleteConversationHistoryFromTable(tableName string, id int, hard bool) (bool, error) {
+	query := func() *gorm.DB {
+		if hard {
+			return MES_DB.Table(tableName).Unscoped()
+		}
+		return MES_DB.Table(tableName)
 	}
 
-	// 分表模式：需要在所有表中查找记录
-	tables := getConversationHistoryAllTables()
-	for _, tableName := range tables {
+	var histories []*ConversationHistory
+	err := query().Select("id", "user_id", "conversation_id").Where("id = ?", id).Limit(1).Find(&histories).Error
+	if err != nil || len(histories) == 0 {
+		return false, err
+	}
+	if err = query().Delete(&ConversationHistory{}, id).Error; err != nil {
+		return true, err
+	}
+	_, err = deleteOrphanConversationMessages([]conversationMessageKey{{
+		UserId:         histories[0].UserId,
+		ConversationId: histories[0].ConversationId,
+	}})
+	return true, err
+}
+
+// deleteConversationHistory 在所有表中查找并删除对话历史，不分表时只有一张表
+func deleteConversationHistory(id int, hard bool) error {
+	for _, tableName := range getConversationHistoryAllTables() {
 		if !MES_DB.Migrator().HasTable(tableName) {
 			continue
 		}
 
-		result := MES_DB.Table(tableName).Delete(&ConversationHistory{}, id)
-		if result.Error != nil {
-			return result.Error
+		found, err := deleteConversationHistoryFromTable(tableName, id, hard)
+		if err != nil {
+			return err
 		}
-		if result.RowsAffected > 0 {
+		if found {
 			return nil // 找到并删除了记录
 		}
 	}
@@ -371,10 +403,19 @@ func DeleteConversationHistory(id int) error {
 	return gorm.ErrRecordNotFound
 }
 
+// DeleteConversationHistory 软删除对话历史
+func DeleteConversationHistory(id int) error {
+	return deleteConversationHistory(id, false)
+}
+
 // DeleteConversationHistoriesByConversationId 根据对话ID软删除对话历史
 func DeleteConversationHistoriesByConversationId(conversationId string) error {
-	if !common.MESDailyPartition {
-		return MES_DB.Table("conversation_histories").Where("conversation_id = ?", conversationId).Delete(&ConversationHistory{}).Error
+	if !useMESDailyTables() {
+		err := MES_DB.Table("conversation_histories").Where("conversation_id = ?", conversationId).Delete(&ConversationHistory{}).Error
+		if err != nil {
+			return err
+		}
+		return deleteOrphanConversationMessagesByConversationId(conversationId)
 	}
 
 	// 分表模式：需要在所有表中删除记录
@@ -390,33 +431,16 @@ func DeleteConversationHistoriesByConversationId(conversationId string) error {
 			hasError = err
 		}
 	}
+	if hasError != nil {
+		return hasError
+	}
 
-	return hasError
+	return deleteOrphanConversationMessagesByConversationId(conversationId)
 }
 
 // HardDeleteConversationHistory 硬删除对话历史
 func HardDeleteConversationHistory(id int) error {
-	if !common.MESDailyPartition {
-		return MES_DB.Table("conversation_histories").Unscoped().Delete(&ConversationHistory{}, id).Error
-	}
-
-	// 分表模式：需要在所有表中查找记录
-	tables := getConversationHistoryAllTables()
-	for _, tableName := range tables {
-		if !MES_DB.Migrator().HasTable(tableName) {
-			continue
-		}
-
-		result := MES_DB.Table(tableName).Unscoped().Delete(&ConversationHistory{}, id)
-		if result.Error != nil {
-			return result.Error
-		}
-		if result.RowsAffected > 0 {
-			return nil // 找到并删除了记录
-		}
-	}
-
-	return gorm.ErrRecordNotFound
+	return deleteConversationHistory(id, true)
 }
 
 // SearchConversationHistories 搜索对话历史
@@ -424,13 +448,13 @@ func SearchConversationHistories(keyword string, userId int, modelName string, l
 	var histories []*ConversationHistory
 	var total int64
 
-	if !common.MESDailyPartition {
+	if !useMESDailyTables() {
 		// 不分表，直接查询
 		query := MES_DB.Table("conversation_histories").Model(&ConversationHistory{})
 
 		// 构建搜索条件
 		if keyword != "" {
-			query = query.Where("conversation_id LIKE ? OR raw_json LIKE ?", "%"+keyword+"%", "%"+keyword+"%")
+			query = whereConversationKeyword(query, keyword)
 		}
 
 		if userId > 0 {
@@ -457,11 +481,11 @@ func SearchConversationHistories(keyword string, userId int, modelName string, l
 			return nil, 0, err
 		}
 
-		return histories, total, nil
+		return histories, total, decodeConversationHistories(histories)
 	}
 
 	// 分表模式：查询所有存在的表
-	tables := getConversationHistoryAllTables()
+	tables := getConversationHistoryTablesNewestFirst()
 
 	// 先获取总数
 	total = 0
@@ -470,7 +494,7 @@ func SearchConversationHistories(keyword string, userId int, modelName string, l
 
 		// 构建搜索条件
 		if keyword != "" {
-			query = query.Where("conversation_id LIKE ? OR raw_json LIKE ?", "%"+keyword+"%", "%"+keyword+"%")
+			query = whereConversationKeyword(query, keyword)
 		}
 
 		if userId > 0 {
@@ -503,7 +527,7 @@ func SearchConversationHistories(keyword string, userId int, modelName string, l
 
 		// 构建搜索条件
 		if keyword != "" {
-			tableQuery = tableQuery.Where("conversation_id LIKE ? OR raw_json LIKE ?", "%"+keyword+"%", "%"+keyword+"%")
+			tableQuery = whereConversationKeyword(tableQuery, keyword)
 		}
 
 		if userId > 0 {
@@ -520,7 +544,7 @@ func SearchConversationHistories(keyword string, userId int, modelName string, l
 		var tableTotal int64
 		countQuery := MES_DB.Table(tableName).Model(&ConversationHistory{})
 		if keyword != "" {
-			countQuery = countQuery.Where("conversation_id LIKE ? OR raw_json LIKE ?", "%"+keyword+"%", "%"+keyword+"%")
+			countQuery = whereConversationKeyword(countQuery, keyword)
 		}
 		if userId > 0 {
 			countQuery = countQuery.Where("user_id = ?", userId)
@@ -554,7 +578,20 @@ func SearchConversationHistories(keyword string, userId int, modelName string, l
 		collected += len(tableHistories)
 	}
 
-	return histories, total, nil
+	return histories, total, decodeConversationHistories(histories)
+}
+
+// decodeConversationHistories 解密查询到的对话历史并还原去重存储的消息，无法解密的记录保留密文
+func decodeConversationHistories(histories []*ConversationHistory) error {
+	for _, history := range histories {
+		rawJson, err := decryptHistoryField(history.RawJson)
+		if err != nil {
+			common.SysError(fmt.Sprintf("failed to decrypt conversation history %d: %v", history.Id, err))
+			continue
+		}
+		history.RawJson = rawJson
+	}
+	return expandConversationHistories(histories)
 }
 
 // GetRawJsonAsMap 将RawJson字段解析为map
@@ -578,17 +615,32 @@ func (h *ConversationHistory) SetRawJsonFromMap(data map[string]interface{}) err
 }
 
 // CleanupOldConversationHistories 清理旧的对话历史记录
+// 按存储策略设置了保留天数的记录：已到期的先行删除（包括错误对话历史），未到期的跳过
 func CleanupOldConversationHistories(days int) (int64, error) {
-	cutoffTime := time.Now().AddDate(0, 0, -days)
+	now := time.Now()
+	cutoffTime := now.AddDate(0, 0, -days)
 
-	if !common.MESDailyPartition {
-		result := MES_DB.Table("conversation_histories").Where("created_at < ?", cutoffTime).Delete(&ConversationHistory{})
-		return result.RowsAffected, result.Error
+	totalDeleted, err := deleteExpiredConversationHistories(now)
+	if err != nil {
+		return totalDeleted, err
+	}
+
+	if err := deleteConversationSearchDocumentsBefore(cutoffTime, now); err != nil {
+		return totalDeleted, err
+	}
+
+	if !useMESDailyTables() {
+		result := MES_DB.Table("conversation_histories").Where("created_at < ?", cutoffTime).
+			Where("id NOT IN (?)", retainedConversationHistoryIds("conversation_histories", now)).Delete(&ConversationHistory{})
+		totalDeleted += result.RowsAffected
+		if result.Error != nil {
+			return totalDeleted, result.Error
+		}
+		_, err = CleanupOrphanConversationMessages()
+		return totalDeleted, err
 	}
 
 	// 分表模式：清理旧表或旧记录
-	var totalDeleted int64 = 0
-	now := time.Now()
 
 	// 检查过去180天的表（比较保守的范围）
 	for i := days; i < 180; i++ {
@@ -602,14 +654,15 @@ func CleanupOldConversationHistories(days int) (int64, error) {
 		// 如果整个表都过期了，可以选择删除整个表
 		if pastDate.Before(cutoffTime.AddDate(0, 0, -1)) {
 			// 删除表中的所有记录
-			result := MES_DB.Table(tableName).Where("1 = 1").Delete(&ConversationHistory{})
+			result := MES_DB.Table(tableName).Where("id NOT IN (?)", retainedConversationHistoryIds(tableName, now)).Delete(&ConversationHistory{})
 			if result.Error != nil {
 				return totalDeleted, result.Error
 			}
 			totalDeleted += result.RowsAffected
 		} else {
 			// 部分记录过期，只删除过期记录
-			result := MES_DB.Table(tableName).Where("created_at < ?", cutoffTime).Delete(&ConversationHistory{})
+			result := MES_DB.Table(tableName).Where("created_at < ?", cutoffTime).
+				Where("id NOT IN (?)", retainedConversationHistoryIds(tableName, now)).Delete(&ConversationHistory{})
 			if result.Error != nil {
 				return totalDeleted, result.Error
 			}
@@ -617,29 +670,44 @@ func CleanupOldConversationHistories(days int) (int64, error) {
 		}
 	}
 
-	return totalDeleted, nil
+	// 去重存储的消息在对话历史全部删除后才能清理，包括按保留期删除的对话历史
+	_, err = CleanupOrphanConversationMessages()
+	return totalDeleted, err
 }
 
 // EnsureTodayTablesExist 确保今天的分表存在（系统启动时调用）
 func EnsureTodayTablesExist() error {
-	if !common.MESDailyPartition {
+	if IsMESNativePartition() {
+		// 原生分区同时预创建明天的分区，避免跨日时写入失败
+		now := time.Now()
+		common.SysLog(fmt.Sprintf("Ensuring native partitions exist for %s", now.Format("2006_01_02")))
+		for _, date := range []time.Time{now, now.AddDate(0, 0, 1)} {
+			if err := createPartitionTablesForDate(date); err != nil {
+				return err
+			}
+		}
+		common.SysLog("Native partitions created successfully")
+		return nil
+	}
+	if !useMESDailyTables() {
 		return nil
 	}
 
 	// 获取今天的表名
-	todayTableName := GetConversationHistoryTableName()
-	todayErrorTableName := GetErrorConversationHistoryTableName()
+	now := time.Now()
+	todayTableName := GetConversationHistoryTableName(now)
+	todayErrorTableName := GetErrorConversationHistoryTableName(now)
 
 	common.SysLog(fmt.Sprintf("Ensuring today's partition tables exist: %s, %s", todayTableName, todayErrorTableName))
 
 	// 创建今天的对话历史表
-	err := ensureConversationHistoryTableExists(todayTableName)
+	err := ensureConversationHistoryTableExists(todayTableName, now)
 	if err != nil {
 		return fmt.Errorf("failed to ensure conversation history table %s: %v", todayTableName, err)
 	}
 
 	// 创建今天的错误对话历史表
-	err = ensureErrorConversationHistoryTableExists(todayErrorTableName)
+	err = ensureErrorConversationHistoryTableExists(todayErrorTableName, now)
 	if err != nil {
 		return fmt.Errorf("failed to ensure error conversation history table %s: %v", todayErrorTableName, err)
 	}
@@ -656,7 +724,7 @@ var (
 
 // StartPartitionTableMonitor 启动分表监控服务
 func StartPartitionTableMonitor() {
-	if !common.MESDailyPartition {
+	if !isMESPartitionEnabled() {
 		return
 	}
 
@@ -726,17 +794,27 @@ func checkAndCreateNewPartition() {
 
 // createPartitionTablesForDate 为指定日期创建分区表
 func createPartitionTablesForDate(date time.Time) error {
+	if IsMESNativePartition() {
+		if err := ensureNativePartition(ConversationHistoryBaseTable, false, date); err != nil {
+			return fmt.Errorf("failed to create conversation history partition for %s: %v", date.Format("2006_01_02"), err)
+		}
+		if err := ensureNativePartition(ErrorConversationHistoryBaseTable, true, date); err != nil {
+			return fmt.Errorf("failed to create error conversation history partition for %s: %v", date.Format("2006_01_02"), err)
+		}
+		return nil
+	}
+
 	tableName := GetConversationHistoryTableName(date)
 	errorTableName := GetErrorConversationHistoryTableName(date)
 
 	// 创建对话历史表
-	err := ensureConversationHistoryTableExists(tableName)
+	err := ensureConversationHistoryTableExists(tableName, date)
 	if err != nil {
 		return fmt.Errorf("failed to create conversation history table %s: %v", tableName, err)
 	}
 
 	// 创建错误对话历史表
-	err = ensureErrorConversationHistoryTableExists(errorTableName)
+	err = ensureErrorConversationHistoryTableExists(errorTableName, date)
 	if err != nil {
 		return fmt.Errorf("failed to create error conversation history table %s: %v", errorTableName, err)
 	}
@@ -746,7 +824,7 @@ func createPartitionTablesForDate(date time.Time) error {
 
 // PreCreateTomorrowTables 预创建明天的分区表（可以手动调用）
 func PreCreateTomorrowTables() error {
-	if !common.MESDailyPartition {
+	if !isMESPartitionEnabled() {
 		return nil
 	}
 
@@ -762,7 +840,7 @@ func PreCreateTomorrowTables() error {
 
 // SimulateDateChange 模拟日期变化（仅用于测试）
 func SimulateDateChange(targetDate time.Time) error {
-	if !common.MESDailyPartition {
+	if !isMESPartitionEnabled() {
 		return fmt.Errorf("daily partition is not enabled")
 	}
 
@@ -785,7 +863,7 @@ func SimulateDateChange(targetDate time.Time) error {
 
 // GetPartitionTableStats 获取分区表统计信息
 func GetPartitionTableStats() map[string]interface{} {
-	if !common.MESDailyPartition {
+	if !isMESPartitionEnabled() {
 		return map[string]interface{}{
 			"partition_enabled": false,
 		}
@@ -795,12 +873,21 @@ func GetPartitionTableStats() map[string]interface{} {
 		"partition_enabled": true,
 		"current_date":      getCurrentPartitionDate(),
 		"monitor_running":   true,
+		"native_partition":  IsMESNativePartition(),
 	}
 
-	// 获取所有存在的分区表
-	tables := getConversationHistoryAllTables()
-	stats["existing_tables"] = tables
-	stats["total_partitions"] = len(tables)
+	// 获取所有存在的分区
+	partitions, err := GetMESPartitions(ConversationHistoryBaseTable)
+	if err != nil {
+		stats["error"] = err.Error()
+		return stats
+	}
+	names := make([]string, 0, len(partitions))
+	for _, partition := range partitions {
+		names = append(names, partition.Name)
+	}
+	stats["existing_tables"] = names
+	stats["total_partitions"] = len(partitions)
 
 	return stats
 }
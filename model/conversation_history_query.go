@@ -0,0 +1,232 @@
+package model
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// ConversationHistoryFilter 对话历史查询条件，零值字段不参与过滤
+type ConversationHistoryFilter struct {
+	UserId         int
+	ConversationId string
+	ModelName      string
+	Keyword        string
+	StartTime      *time.Time
+	EndTime        *time.Time
+}
+
+// apply 将查询条件应用到单个分表的查询上
+func (f *ConversationHistoryFilter) apply(tx *gorm.DB) *gorm.DB {
+	if f.UserId > 0 {
+		tx = tx.Where("user_id = ?", f.UserId)
+	}
+	if f.ConversationId != "" {
+		tx = tx.Where("conversation_id = ?", f.ConversationId)
+	}
+	if f.ModelName != "" {
+		tx = tx.Where("model_name = ?", f.ModelName)
+	}
+	if f.Keyword != "" {
+		tx = whereConversationKeyword(tx, f.Keyword)
+	}
+	if f.StartTime != nil {
+		tx = tx.Where("created_at >= ?", *f.StartTime)
+	}
+	if f.EndTime != nil {
+		tx = tx.Where("created_at <= ?", *f.EndTime)
+	}
+	return tx
+}
+
+// ConversationHistoryCursor 键集分页游标，指向上一页的最后一条记录
+type ConversationHistoryCursor struct {
+	CreatedAt time.Time
+	Id        int
+}
+
+// Encode 将游标编码为不透明的字符串
+func (c *ConversationHistoryCursor) Encode() string {
+	raw := fmt.Sprintf("%d_%d", c.CreatedAt.UnixNano(), c.Id)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+// DecodeConversationHistoryCursor 解析 Encode 生成的游标
+func DecodeConversationHistoryCursor(cursor string) (*ConversationHistoryCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, errors.New("invalid cursor")
+	}
+	parts := strings.SplitN(string(raw), "_", 2)
+	if len(parts) != 2 {
+		return nil, errors.New("invalid cursor")
+	}
+	nanos, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return nil, errors.New("invalid cursor")
+	}
+	id, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return nil, errors.New("invalid cursor")
+	}
+	return &ConversationHistoryCursor{CreatedAt: time.Unix(0, nanos), Id: id}, nil
+}
+
+// getConversationHistoryTablesNewestFirst 获取所有存在的对话历史分表，按日期从新到旧排列
+func getConversationHistoryTablesNewestFirst() []string {
+	tables := getConversationHistoryAllTables()
+	for i, j := 0, len(tables)-1; i < j; i, j = i+1, j-1 {
+		tables[i], tables[j] = tables[j], tables[i]
+	}
+	return tables
+}
+
+// getConversationHistoryTablesInRange 获取 [startTime, endTime] 范围内存在的对话历史分表，按日期从新到旧排列。
+func getConversationHistoryTablesInRange(startTime, endTime *time.Time) []string {
+	return getMESTablesInRange(ConversationHistoryBaseTable, startTime, endTime)
+}
+
+// getMESTablesInRange 获取基础表 baseTable 在 [startTime, endTime] 范围内存在的分表，按日期从新到旧排列。
+// 未分表的基础表没有日期，存在时总是排在最后。
+func getMESTablesInRange(baseTable string, startTime, endTime *time.Time) []string {
+	if !useMESDailyTables() {
+		return []string{baseTable}
+	}
+	tables := getMESPartitionTables(baseTable)
+	for i, j := 0, len(tables)-1; i < j; i, j = i+1, j-1 {
+		tables[i], tables[j] = tables[j], tables[i]
+	}
+	if (startTime == nil && endTime == nil) || len(tables) == 0 {
+		return tables
+	}
+	existing := make(map[string]bool, len(tables))
+	for _, tableName := range tables {
+		existing[tableName] = true
+	}
+	// 未指定的边界使用最早的分表和当前时间
+	end := time.Now()
+	if endTime != nil {
+		end = *endTime
+	}
+	var start time.Time
+	if startTime != nil {
+		start = *startTime
+	} else {
+		partitions, err := GetMESPartitions(baseTable)
+		if err != nil {
+			return tables
+		}
+		for _, partition := range partitions {
+			if !partition.Date.IsZero() {
+				start = partition.Date
+				break
+			}
+		}
+		if start.IsZero() {
+			start = end
+		}
+	}
+	// 从开始日期的零点开始逐日生成表名，避免结束日期的时刻早于开始时刻时漏掉最后一天
+	start = time.Date(start.Year(), start.Month(), start.Day(), 0, 0, 0, 0, start.Location())
+	var dateTables []string
+	for current := start; !current.After(end); current = current.AddDate(0, 0, 1) {
+		dateTables = append(dateTables, baseTable+"_"+current.Format(mesPartitionTableDateFormat))
+	}
+	result := make([]string, 0, len(dateTables)+1)
+	for i := len(dateTables) - 1; i >= 0; i-- {
+		if existing[dateTables[i]] {
+			result = append(result, dateTables[i])
+		}
+	}
+	if existing[baseTable] {
+		result = append(result, baseTable)
+	}
+	return result
+}
+
+// QueryConversationHistories 使用 (created_at, id) 键集分页查询对话历史，按时间从新到旧返回。
+// cursor 为空时从最新的记录开始，返回的游标为空表示没有更多数据。
+func QueryConversationHistories(filter ConversationHistoryFilter, cursor *ConversationHistoryCursor, limit int) ([]*ConversationHistory, *ConversationHistoryCursor, error) {
+	endTime := filter.EndTime
+	if cursor != nil && (endTime == nil || cursor.CreatedAt.Before(*endTime)) {
+		// 游标之后的分表已经在之前的页面中读完
+		endTime = &cursor.CreatedAt
+	}
+	tables := getConversationHistoryTablesInRange(filter.StartTime, endTime)
+
+	histories := make([]*ConversationHistory, 0, limit)
+	for _, tableName := range tables {
+		var tableHistories []*ConversationHistory
+		tx := filter.apply(MES_DB.Table(tableName))
+		if cursor != nil {
+			tx = tx.Where("(created_at < ? OR (created_at = ? AND id < ?))", cursor.CreatedAt, cursor.CreatedAt, cursor.Id)
+		}
+		err := tx.Order("created_at desc, id desc").Limit(limit - len(histories)).Find(&tableHistories).Error
+		if err != nil {
+			return nil, nil, err
+		}
+		histories = append(histories, tableHistories...)
+		if len(histories) >= limit {
+			break
+		}
+	}
+
+	var next *ConversationHistoryCursor
+	if len(histories) > 0 && len(histories) >= limit {
+		last := histories[len(histories)-1]
+		next = &ConversationHistoryCursor{CreatedAt: last.CreatedAt, Id: last.Id}
+	}
+	return histories, next, decodeConversationHistories(histories)
+}
+
+// CountConversationHistories 统计符合条件的对话历史数量，只统计时间范围内的分表
+func CountConversationHistories(filter ConversationHistoryFilter) (int64, error) {
+	var total int64
+	for _, tableName := range getConversationHistoryTablesInRange(filter.StartTime, filter.EndTime) {
+		var count int64
+		err := filter.apply(MES_DB.Table(tableName).Model(&ConversationHistory{})).Count(&count).Error
+		if err != nil {
+			return 0, err
+		}
+		total += count
+	}
+	return total, nil
+}
+
+// QueryErrorConversationHistories 与 QueryConversationHistories 相同，查询错误对话历史
+func QueryErrorConversationHistories(filter ConversationHistoryFilter, cursor *ConversationHistoryCursor, limit int) ([]*ErrorConversationHistory, *ConversationHistoryCursor, error) {
+	endTime := filter.EndTime
+	if cursor != nil && (endTime == nil || cursor.CreatedAt.Before(*endTime)) {
+		endTime = &cursor.CreatedAt
+	}
+	tables := getMESTablesInRange(ErrorConversationHistoryBaseTable, filter.StartTime, endTime)
+
+	histories := make([]*ErrorConversationHistory, 0, limit)
+	for _, tableName := range tables {
+		var tableHistories []*ErrorConversationHistory
+		tx := filter.apply(MES_DB.Table(tableName))
+		if cursor != nil {
+			tx = tx.Where("(created_at < ? OR (created_at = ? AND id < ?))", cursor.CreatedAt, cursor.CreatedAt, cursor.Id)
+		}
+		err := tx.Order("created_at desc, id desc").Limit(limit - len(histories)).Find(&tableHistories).Error
+		if err != nil {
+			return nil, nil, err
+		}
+		histories = append(histories, tableHistories...)
+		if len(histories) >= limit {
+			break
+		}
+	}
+
+	var next *ConversationHistoryCursor
+	if len(histories) > 0 && len(histories) >= limit {
+		last := histories[len(histories)-1]
+		next = &ConversationHistoryCursor{CreatedAt: last.CreatedAt, Id: last.Id}
+	}
+	return histories, next, decodeErrorConversationHistories(histories)
+}
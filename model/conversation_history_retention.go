@@ -0,0 +1,68 @@
+package model
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// ConversationHistoryRetention 按存储策略单独设置了保留天数的对话历史的过期时间。
+// 对话历史按天分表（或使用原生分区），新增字段需要修改所有分表，因此过期时间单独存放。
+type ConversationHistoryRetention struct {
+	Id           int       `json:"id" gorm:"primaryKey;autoIncrement"`
+	HistoryTable string    `json:"history_table" gorm:"type:varchar(64);uniqueIndex:idx_history_retention_record,priority:1"`
+	HistoryId    int       `json:"history_id" gorm:"uniqueIndex:idx_history_retention_record,priority:2"`
+	ExpireAt     time.Time `json:"expire_at" gorm:"index"`
+}
+
+// createConversationHistoryRetention 记录对话历史的过期时间，retentionDays 为 0 时按全局清理天数清理，不需要记录
+func createConversationHistoryRetention(tableName string, historyId int, createdAt time.Time, retentionDays int) error {
+	if retentionDays <= 0 {
+		return nil
+	}
+	return MES_DB.Create(&ConversationHistoryRetention{
+		HistoryTable: tableName,
+		HistoryId:    historyId,
+		ExpireAt:     createdAt.AddDate(0, 0, retentionDays),
+	}).Error
+}
+
+// retainedConversationHistoryIds 表中尚未到期的记录 ID 子查询，全局清理时跳过这些记录
+func retainedConversationHistoryIds(tableName string, now time.Time) *gorm.DB {
+	return MES_DB.Model(&ConversationHistoryRetention{}).Select("history_id").
+		Where("history_table = ? AND expire_at >= ?", tableName, now)
+}
+
+// deleteExpiredConversationHistories 删除已过保留期的对话历史和错误对话历史，不论是否达到全局清理天数
+func deleteExpiredConversationHistories(now time.Time) (int64, error) {
+	var tables []string
+	err := MES_DB.Model(&ConversationHistoryRetention{}).Where("expire_at < ?", now).
+		Distinct().Pluck("history_table", &tables).Error
+	if err != nil {
+		return 0, err
+	}
+	var totalDeleted int64
+	for _, tableName := range tables {
+		expired := MES_DB.Model(&ConversationHistoryRetention{}).Select("history_id").
+			Where("history_table = ? AND expire_at < ?", tableName, now)
+		if MES_DB.Migrator().HasTable(tableName) {
+			// 两种表的软删除字段相同
+			result := MES_DB.Table(tableName).Where("id IN (?)", expired).Delete(&ConversationHistory{})
+			if result.Error != nil {
+				return totalDeleted, result.Error
+			}
+			totalDeleted += result.RowsAffected
+			err = MES_DB.Where("history_table = ? AND history_id IN (?)", tableName, expired).
+				Delete(&ConversationSearchDocument{}).Error
+			if err != nil {
+				return totalDeleted, err
+			}
+		}
+		err = MES_DB.Where("history_table = ? AND expire_at < ?", tableName, now).
+			Delete(&ConversationHistoryRetention{}).Error
+		if err != nil {
+			return totalDeleted, err
+		}
+	}
+	return totalDeleted, nil
+}
@@ -0,0 +1,419 @@
+package model
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"one-api/common"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// conversationMessageChainKey 去重存储模式下 RawJson 中代替 messages 的字段
+const conversationMessageChainKey = "message_chain"
+
+// ConversationMessage 去重存储模式下对话中的一条消息。
+// Hash 由父消息的 Hash 和消息内容计算，唯一确定了到这条消息为止的完整对话，因此同一对话中相同前缀只保存一次。
+type ConversationMessage struct {
+	Id             int       `json:"id" gorm:"primaryKey;autoIncrement"`
+	UserId         int       `json:"user_id" gorm:"uniqueIndex:idx_conversation_message_hash,priority:1"`
+	ConversationId string    `json:"conversation_id" gorm:"type:varchar(255);uniqueIndex:idx_conversation_message_hash,priority:2"`
+	Hash           string    `json:"hash" gorm:"type:char(64);uniqueIndex:idx_conversation_message_hash,priority:3"`
+	ParentHash     string    `json:"parent_hash" gorm:"type:char(64)"`
+	Seq            int       `json:"seq"` // 消息在对话中的位置，从 0 开始
+	Message        string    `json:"message" gorm:"type:longtext;not null"`
+	CreatedAt      time.Time `json:"created_at" gorm:"autoCreateTime;index"`
+}
+
+// TableName 指定表名
+func (ConversationMessage) TableName() string {
+	return "conversation_messages"
+}
+
+// ConversationMessageChain 去重存储的对话历史只记录前缀中最后一条已有消息和本次新增的消息
+type ConversationMessageChain struct {
+	Prefix string   `json:"prefix"`
+	Turns  []string `json:"turns"`
+}
+
+// head 返回本次对话最后一条消息的 Hash
+func (chain *ConversationMessageChain) head() string {
+	if len(chain.Turns) > 0 {
+		return chain.Turns[len(chain.Turns)-1]
+	}
+	return chain.Prefix
+}
+
+// canonicalConversationMessage 重新序列化消息，使字段顺序一致，相同内容得到相同的 Hash
+func canonicalConversationMessage(raw json.RawMessage) ([]byte, error) {
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return nil, err
+	}
+	return json.Marshal(value)
+}
+
+func conversationMessageHash(parentHash string, message []byte) string {
+	hash := sha256.New()
+	hash.Write([]byte(parentHash))
+	hash.Write([]byte{'\n'})
+	hash.Write(message)
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// dedupConversationRawJson 将完整的 messages 写入消息表，返回只包含消息链的 RawJson。
+// 没有 messages 或已经是去重格式时原样返回。
+func dedupConversationRawJson(userId int, conversationId string, rawJson string) (string, error) {
+	var data map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(rawJson), &data); err != nil {
+		return "", err
+	}
+	if _, ok := data[conversationMessageChainKey]; ok {
+		return rawJson, nil
+	}
+	rawMessages, ok := data["messages"]
+	if !ok {
+		return rawJson, nil
+	}
+	var messages []json.RawMessage
+	if err := json.Unmarshal(rawMessages, &messages); err != nil {
+		return "", err
+	}
+
+	nodes := make([]*ConversationMessage, 0, len(messages))
+	hashes := make([]string, 0, len(messages))
+	parentHash := ""
+	for i, message := range messages {
+		canonical, err := canonicalConversationMessage(message)
+		if err != nil {
+			return "", err
+		}
+		hash := conversationMessageHash(parentHash, canonical)
+		// Hash 使用明文计算，加密后相同的消息仍然可以去重
+		message, err := encryptHistoryField(string(canonical))
+		if err != nil {
+			return "", err
+		}
+		nodes = append(nodes, &ConversationMessage{
+			UserId:         userId,
+			ConversationId: conversationId,
+			Hash:           hash,
+			ParentHash:     parentHash,
+			Seq:            i,
+			Message:        message,
+		})
+		hashes = append(hashes, hash)
+		parentHash = hash
+	}
+
+	chain := ConversationMessageChain{Turns: []string{}}
+	if len(hashes) > 0 {
+		var existing []string
+		err := MES_DB.Model(&ConversationMessage{}).
+			Where("user_id = ? AND conversation_id = ? AND hash IN ?", userId, conversationId, hashes).
+			Pluck("hash", &existing).Error
+		if err != nil {
+			return "", err
+		}
+		existingSet := make(map[string]bool, len(existing))
+		for _, hash := range existing {
+			existingSet[hash] = true
+		}
+		// Hash 包含了前缀，已存在的消息一定是连续的前缀
+		prefixLen := 0
+		for prefixLen < len(hashes) && existingSet[hashes[prefixLen]] {
+			prefixLen++
+		}
+		if prefixLen > 0 {
+			chain.Prefix = hashes[prefixLen-1]
+		}
+		chain.Turns = hashes[prefixLen:]
+		if newNodes := nodes[prefixLen:]; len(newNodes) > 0 {
+			// 同一对话的并发请求可能写入相同的消息
+			err = MES_DB.Clauses(clause.OnConflict{DoNothing: true}).Create(newNodes).Error
+			if err != nil {
+				return "", err
+			}
+		}
+	}
+
+	chainJson, err := json.Marshal(chain)
+	if err != nil {
+		return "", err
+	}
+	delete(data, "messages")
+	data[conversationMessageChainKey] = chainJson
+	compact, err := json.Marshal(data)
+	if err != nil {
+		return "", err
+	}
+	return string(compact), nil
+}
+
+// expandConversationHistories 将去重存储的对话历史还原为包含完整 messages 的 RawJson，每个对话只查询一次消息表
+func expandConversationHistories(histories []*ConversationHistory) error {
+	type conversationKey struct {
+		userId         int
+		conversationId string
+	}
+	nodesByConversation := make(map[conversationKey]map[string]*ConversationMessage)
+	for _, history := range histories {
+		if history == nil || !strings.Contains(history.RawJson, conversationMessageChainKey) {
+			continue
+		}
+		var data map[string]json.RawMessage
+		if err := json.Unmarshal([]byte(history.RawJson), &data); err != nil {
+			continue
+		}
+		rawChain, ok := data[conversationMessageChainKey]
+		if !ok {
+			continue
+		}
+		var chain ConversationMessageChain
+		if err := json.Unmarshal(rawChain, &chain); err != nil {
+			return err
+		}
+
+		key := conversationKey{history.UserId, history.ConversationId}
+		nodes, ok := nodesByConversation[key]
+		if !ok {
+			var items []*ConversationMessage
+			err := MES_DB.Where("user_id = ? AND conversation_id = ?", key.userId, key.conversationId).Find(&items).Error
+			if err != nil {
+				return err
+			}
+			nodes = make(map[string]*ConversationMessage, len(items))
+			for _, item := range items {
+				nodes[item.Hash] = item
+			}
+			nodesByConversation[key] = nodes
+		}
+
+		// 从最后一条消息沿父消息回溯到对话开头
+		var messages []json.RawMessage
+		for hash := chain.head(); hash != ""; {
+			node, ok := nodes[hash]
+			if !ok {
+				common.SysError("conversation message not found: " + hash)
+				break
+			}
+			message, err := decryptHistoryField(node.Message)
+			if err != nil {
+				return err
+			}
+			messages = append(messages, json.RawMessage(message))
+			hash = node.ParentHash
+		}
+		for i, j := 0, len(messages)-1; i < j; i, j = i+1, j-1 {
+			messages[i], messages[j] = messages[j], messages[i]
+		}
+		if messages == nil {
+			messages = []json.RawMessage{}
+		}
+		messagesJson, err := json.Marshal(messages)
+		if err != nil {
+			return err
+		}
+		delete(data, conversationMessageChainKey)
+		data["messages"] = messagesJson
+		expanded, err := json.Marshal(data)
+		if err != nil {
+			return err
+		}
+		history.RawJson = string(expanded)
+	}
+	return nil
+}
+
+// expandArchivedConversationHistories 归档前还原去重存储的对话历史，使归档文件不依赖消息表，删除分区后仍然包含完整的消息。
+// 还原后的 RawJson 按当前配置重新加密，其余记录原样归档。
+func expandArchivedConversationHistories(histories []*ConversationHistory) error {
+	var deduped []*ConversationHistory
+	for _, history := range histories {
+		rawJson, err := decryptHistoryField(history.RawJson)
+		if err != nil {
+			return fmt.Errorf("conversation history %d: %w", history.Id, err)
+		}
+		if !strings.Contains(rawJson, conversationMessageChainKey) {
+			continue
+		}
+		history.RawJson = rawJson
+		deduped = append(deduped, history)
+	}
+	if err := expandConversationHistories(deduped); err != nil {
+		return err
+	}
+	for _, history := range deduped {
+		rawJson, err := encryptHistoryField(history.RawJson)
+		if err != nil {
+			return err
+		}
+		history.RawJson = rawJson
+	}
+	return nil
+}
+
+// whereConversationKeyword 关键词搜索对话ID和对话内容，去重存储的消息在消息表中，按对话匹配
+func whereConversationKeyword(tx *gorm.DB, keyword string) *gorm.DB {
+	pattern := "%" + keyword + "%"
+	if !common.ConversationHistoryDedup {
+		return tx.Where("(conversation_id LIKE ? OR raw_json LIKE ?)", pattern, pattern)
+	}
+	return tx.Where("(conversation_id LIKE ? OR raw_json LIKE ? OR conversation_id IN (?))", pattern, pattern,
+		MES_DB.Model(&ConversationMessage{}).Select("conversation_id").Where("message LIKE ?", pattern))
+}
+
+// DedupConversationHistoryPartition 将分区中完整存储的对话历史转换为去重存储，返回转换的记录数
+func DedupConversationHistoryPartition(partition MESPartition, batchSize int) (int64, error) {
+	var converted int64
+	lastId := 0
+	for {
+		var histories []*ConversationHistory
+		err := mesPartitionQuery(partition).Where("id > ?", lastId).Order("id").Limit(batchSize).Find(&histories).Error
+		if err != nil {
+			return converted, err
+		}
+		for _, history := range histories {
+			lastId = history.Id
+			rawJson, err := decryptHistoryField(history.RawJson)
+			if err != nil {
+				return converted, err
+			}
+			compact, err := dedupConversationRawJson(history.UserId, history.ConversationId, rawJson)
+			if err != nil {
+				common.SysError("failed to dedup conversation history " + partition.Table + ": " + err.Error())
+				continue
+			}
+			if compact == rawJson {
+				continue
+			}
+			if compact, err = encryptHistoryField(compact); err != nil {
+				return converted, err
+			}
+			err = MES_DB.Table(partition.Table).Where("id = ?", history.Id).UpdateColumn("raw_json", compact).Error
+			if err != nil {
+				return converted, err
+			}
+			converted++
+		}
+		if len(histories) < batchSize {
+			return converted, nil
+		}
+	}
+}
+
+// conversationMessageGracePeriod 批量清理时跳过最近写入过消息的对话：去重消息先于对话历史写入，新对话在这段时间内可能还没有对话历史
+const conversationMessageGracePeriod = time.Hour
+
+// conversationMessageKey 消息表中的一个对话，不同用户可能使用相同的对话ID
+type conversationMessageKey struct {
+	UserId         int
+	ConversationId string
+}
+
+// survivingConversationMessageKeys 返回 keys 中仍有未删除的对话历史引用的对话
+func survivingConversationMessageKeys(keys []conversationMessageKey) (map[conversationMessageKey]bool, error) {
+	conversationIds := make([]string, 0, len(keys))
+	seen := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		if !seen[key.ConversationId] {
+			seen[key.ConversationId] = true
+			conversationIds = append(conversationIds, key.ConversationId)
+		}
+	}
+	surviving := make(map[conversationMessageKey]bool)
+	for _, tableName := range getConversationHistoryAllTables() {
+		if !MES_DB.Migrator().HasTable(tableName) {
+			continue
+		}
+		var rows []conversationMessageKey
+		err := MES_DB.Table(tableName).Distinct("user_id", "conversation_id").
+			Where("deleted_at IS NULL AND conversation_id IN ?", conversationIds).Scan(&rows).Error
+		if err != nil {
+			return nil, err
+		}
+		for _, row := range rows {
+			surviving[row] = true
+		}
+	}
+	return surviving, nil
+}
+
+// deleteOrphanConversationMessages 删除 keys 中已经没有未删除的对话历史引用的对话的去重消息，返回删除的消息数
+func deleteOrphanConversationMessages(keys []conversationMessageKey) (int64, error) {
+	if len(keys) == 0 {
+		return 0, nil
+	}
+	surviving, err := survivingConversationMessageKeys(keys)
+	if err != nil {
+		return 0, err
+	}
+	var deleted int64
+	for _, key := range keys {
+		if surviving[key] {
+			continue
+		}
+		result := MES_DB.Where("user_id = ? AND conversation_id = ?", key.UserId, key.ConversationId).Delete(&ConversationMessage{})
+		if result.Error != nil {
+			return deleted, result.Error
+		}
+		deleted += result.RowsAffected
+	}
+	return deleted, nil
+}
+
+// deleteOrphanConversationMessagesByConversationId 删除对话ID下已经没有对话历史引用的去重消息
+func deleteOrphanConversationMessagesByConversationId(conversationId string) error {
+	var userIds []int
+	err := MES_DB.Model(&ConversationMessage{}).Where("conversation_id = ?", conversationId).
+		Distinct().Pluck("user_id", &userIds).Error
+	if err != nil {
+		return err
+	}
+	keys := make([]conversationMessageKey, 0, len(userIds))
+	for _, userId := range userIds {
+		keys = append(keys, conversationMessageKey{UserId: userId, ConversationId: conversationId})
+	}
+	_, err = deleteOrphanConversationMessages(keys)
+	return err
+}
+
+// CleanupOrphanConversationMessages 分批检查消息表中的所有对话，删除已经没有未删除的对话历史引用的去重消息，返回删除的消息数。
+// 在清理、按保留期删除对话历史和删除分区后调用，最近写入过消息的对话留到下次清理。
+func CleanupOrphanConversationMessages() (int64, error) {
+	const batchSize = 500
+	before := time.Now().Add(-conversationMessageGracePeriod)
+	var deleted int64
+	var last *conversationMessageKey
+	for {
+		query := MES_DB.Model(&ConversationMessage{}).Select("user_id, conversation_id")
+		if last != nil {
+			query = query.Where("user_id > ? OR (user_id = ? AND conversation_id > ?)", last.UserId, last.UserId, last.ConversationId)
+		}
+		var keys []conversationMessageKey
+		err := query.Group("user_id, conversation_id").Having("MAX(created_at) < ?", before).
+			Order("user_id, conversation_id").Limit(batchSize).Scan(&keys).Error
+		if err != nil {
+			return deleted, err
+		}
+		if len(keys) == 0 {
+			return deleted, nil
+		}
+		count, err := deleteOrphanConversationMessages(keys)
+		deleted += count
+		if err != nil {
+			return deleted, err
+		}
+		if len(keys) < batchSize {
+			return deleted, nil
+		}
+		last = &keys[len(keys)-1]
+	}
+}
@@ -0,0 +1,565 @@
+package model
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"html"
+	"io"
+	"one-api/common"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"golang.org/x/crypto/hkdf"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	// conversationSearchMaxTerms 单条对话历史最多索引的词元数
+	conversationSearchMaxTerms = 10000
+	// conversationSearchMaxWordLen 超过该长度的词（通常是 base64、哈希等）不索引
+	conversationSearchMaxWordLen = 64
+	// conversationSearchSnippetLen 摘要长度（字符数）
+	conversationSearchSnippetLen = 160
+	// conversationSearchMaxSnippets 每条结果最多返回的摘要数
+	conversationSearchMaxSnippets = 3
+	// conversationSearchTermsIndex MySQL FULLTEXT / PostgreSQL GIN 索引名
+	conversationSearchTermsIndex = "idx_conversation_search_terms"
+)
+
+// conversationSearchRoles 索引的消息角色，其他角色归入最接近的一类
+var conversationSearchRoles = []string{"system", "user", "assistant", "tool"}
+
+// ConversationSearchDocument 对话历史的全文检索文档，每条对话历史对应一条，由写入对话历史时同步维护。
+// Terms 中保存的是 "角色+词元" 的 HMAC 摘要而不是原文，启用加密时索引也不会泄露对话内容；
+// 只索引本轮新增的消息（最后一条助手回复之后的消息和本次回复），与去重存储的新增消息一致。
+type ConversationSearchDocument struct {
+	Id             int       `json:"id" gorm:"primaryKey;autoIncrement"`
+	HistoryTable   string    `json:"history_table" gorm:"type:varchar(64);uniqueIndex:idx_conversation_search_history,priority:1"`
+	HistoryId      int       `json:"history_id" gorm:"uniqueIndex:idx_conversation_search_history,priority:2"`
+	ConversationId string    `json:"conversation_id" gorm:"type:varchar(255);index"`
+	UserId         int       `json:"user_id" gorm:"index"`
+	TokenId        int       `json:"token_id" gorm:"index"`
+	ModelName      string    `json:"model_name" gorm:"type:varchar(255);index"`
+	Roles          string    `json:"roles" gorm:"type:varchar(64)"` // 包含的角色，形如 ,user,assistant,
+	Terms          string    `json:"-" gorm:"type:longtext;not null"`
+	CreatedAt      time.Time `json:"created_at" gorm:"index"`
+}
+
+// TableName 指定表名
+func (ConversationSearchDocument) TableName() string {
+	return "conversation_search_documents"
+}
+
+// ensureConversationSearchIndex 为 Terms 创建数据库原生的全文索引，SQLite 使用 LIKE 匹配，不需要额外索引
+func ensureConversationSearchIndex() error {
+	tableName := ConversationSearchDocument{}.TableName()
+	switch mesDialect() {
+	case common.DatabaseTypeMySQL:
+		var count int64
+		err := MES_DB.Raw("SELECT COUNT(*) FROM information_schema.statistics WHERE table_schema = DATABASE() AND table_name = ? AND index_name = ?",
+			tableName, conversationSearchTermsIndex).Scan(&count).Error
+		if err != nil || count > 0 {
+			return err
+		}
+		return MES_DB.Exec("CREATE FULLTEXT INDEX " + conversationSearchTermsIndex + " ON " + tableName + " (terms)").Error
+	case common.DatabaseTypePostgreSQL:
+		return MES_DB.Exec("CREATE INDEX IF NOT EXISTS " + conversationSearchTermsIndex + " ON " + tableName +
+			" USING GIN (to_tsvector('simple', terms))").Error
+	}
+	return nil
+}
+
+var (
+	conversationSearchKey       []byte
+	conversationSearchKeySecret string
+	conversationSearchKeyLock   sync.Mutex
+)
+
+// getConversationSearchKey 使用 HKDF-SHA256 从 CRYPTO_SECRET 派生索引专用的 HMAC 密钥，更换 CRYPTO_SECRET 后需要重建索引
+func getConversationSearchKey() []byte {
+	conversationSearchKeyLock.Lock()
+	defer conversationSearchKeyLock.Unlock()
+	if conversationSearchKey != nil && conversationSearchKeySecret == common.CryptoSecret {
+		return conversationSearchKey
+	}
+	key := make([]byte, 32)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, []byte(common.CryptoSecret), nil, []byte("one-api conversation search")), key); err != nil {
+		panic(err)
+	}
+	conversationSearchKey = key
+	conversationSearchKeySecret = common.CryptoSecret
+	return key
+}
+
+// conversationSearchTerm 计算角色中词元的索引词，以字母开头，避免被全文解析器当作数字拆开
+func conversationSearchTerm(role string, token string) string {
+	mac := hmac.New(sha256.New, getConversationSearchKey())
+	mac.Write([]byte(role))
+	mac.Write([]byte{0})
+	mac.Write([]byte(token))
+	return "w" + hex.EncodeToString(mac.Sum(nil)[:6])
+}
+
+func isConversationSearchCJK(r rune) bool {
+	return unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul)
+}
+
+// splitConversationSearchWords 将文本转为小写并拆分为词：连续的字母数字为一个词，连续的中日韩字符为一个词
+func splitConversationSearchWords(text string) []string {
+	var words []string
+	var word []rune
+	cjk := false
+	flush := func() {
+		if len(word) > 0 && len(word) <= conversationSearchMaxWordLen {
+			words = append(words, string(word))
+		}
+		word = word[:0]
+	}
+	for _, r := range text {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			flush()
+			continue
+		}
+		isCJK := isConversationSearchCJK(r)
+		if len(word) > 0 && isCJK != cjk {
+			flush()
+		}
+		cjk = isCJK
+		word = append(word, unicode.ToLower(r))
+	}
+	flush()
+	return words
+}
+
+// conversationSearchTokens 将词切分为词元，中日韩文本没有分隔符，按相邻两个字切分
+func conversationSearchTokens(word string) []string {
+	runes := []rune(word)
+	if len(runes) < 2 || !isConversationSearchCJK(runes[0]) {
+		return []string{word}
+	}
+	tokens := make([]string, 0, len(runes)-1)
+	for i := 0; i+1 < len(runes); i++ {
+		tokens = append(tokens, string(runes[i:i+2]))
+	}
+	return tokens
+}
+
+// normalizeConversationSearchRole 将消息角色归入 conversationSearchRoles 中的一类
+func normalizeConversationSearchRole(role string) string {
+	switch role {
+	case "system", "developer":
+		return "system"
+	case "assistant", "model":
+		return "assistant"
+	case "tool", "function":
+		return "tool"
+	}
+	return "user"
+}
+
+// conversationSearchMessage 对话历史中一条 OpenAI 格式的消息，只解析检索需要的字段
+type conversationSearchMessage struct {
+	Role             string          `json:"role"`
+	Content          json.RawMessage `json:"content"`
+	ReasoningContent string          `json:"reasoning_content"`
+	ToolCalls        []struct {
+		Function struct {
+			Name      string `json:"name"`
+			Arguments string `json:"arguments"`
+		} `json:"function"`
+	} `json:"tool_calls"`
+}
+
+// text 返回消息中可检索的文本：文本内容、推理内容和工具调用
+func (m *conversationSearchMessage) text() string {
+	var parts []string
+	var content string
+	if err := json.Unmarshal(m.Content, &content); err == nil {
+		parts = append(parts, content)
+	} else {
+		var contentParts []struct {
+			Text string `json:"text"`
+		}
+		if err := json.Unmarshal(m.Content, &contentParts); err == nil {
+			for _, part := range contentParts {
+				if part.Text != "" {
+					parts = append(parts, part.Text)
+				}
+			}
+		}
+	}
+	if m.ReasoningContent != "" {
+		parts = append(parts, m.ReasoningContent)
+	}
+	for _, toolCall := range m.ToolCalls {
+		parts = append(parts, toolCall.Function.Name, toolCall.Function.Arguments)
+	}
+	return strings.Join(parts, "\n")
+}
+
+// latestConversationTurn 解析 RawJson 中的消息，返回本轮新增的消息：上一条助手回复之后的消息和本次回复
+func latestConversationTurn(rawJson string) []*conversationSearchMessage {
+	var data struct {
+		Messages []*conversationSearchMessage `json:"messages"`
+	}
+	if err := json.Unmarshal([]byte(rawJson), &data); err != nil {
+		return nil
+	}
+	messages := data.Messages
+	for i := len(messages) - 2; i >= 0; i-- {
+		if normalizeConversationSearchRole(messages[i].Role) == "assistant" {
+			return messages[i+1:]
+		}
+	}
+	return messages
+}
+
+// buildConversationSearchDocument 由明文 RawJson 生成检索文档，没有可索引的内容时返回 nil
+func buildConversationSearchDocument(history *ConversationHistory, tableName string, rawJson string, tokenId int) *ConversationSearchDocument {
+	var terms strings.Builder
+	roles := make(map[string]bool)
+	count := 0
+	terms.WriteString(" ")
+	for _, message := range latestConversationTurn(rawJson) {
+		role := normalizeConversationSearchRole(message.Role)
+		for _, word := range splitConversationSearchWords(message.text()) {
+			for _, token := range conversationSearchTokens(word) {
+				if count >= conversationSearchMaxTerms {
+					break
+				}
+				terms.WriteString(conversationSearchTerm(role, token))
+				terms.WriteString(" ")
+				roles[role] = true
+				count++
+			}
+		}
+	}
+	if count == 0 {
+		return nil
+	}
+	roleList := ","
+	for _, role := range conversationSearchRoles {
+		if roles[role] {
+			roleList += role + ","
+		}
+	}
+	return &ConversationSearchDocument{
+		HistoryTable:   tableName,
+		HistoryId:      history.Id,
+		ConversationId: history.ConversationId,
+		UserId:         history.UserId,
+		TokenId:        tokenId,
+		ModelName:      history.ModelName,
+		Roles:          roleList,
+		Terms:          terms.String(),
+		CreatedAt:      history.CreatedAt,
+	}
+}
+
+// indexConversationHistory 写入或更新对话历史的检索文档，rawJson 为去重和加密之前的明文。
+// tokenId 为 0 时（重建索引）保留已有文档中的令牌ID。
+func indexConversationHistory(tableName string, history *ConversationHistory, rawJson string, tokenId int) error {
+	doc := buildConversationSearchDocument(history, tableName, rawJson, tokenId)
+	if doc == nil {
+		return nil
+	}
+	columns := []string{"conversation_id", "user_id", "model_name", "roles", "terms", "created_at"}
+	if tokenId > 0 {
+		columns = append(columns, "token_id")
+	}
+	return MES_DB.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "history_table"}, {Name: "history_id"}},
+		DoUpdates: clause.AssignmentColumns(columns),
+	}).Create(doc).Error
+}
+
+// IndexConversationHistoryPartition 为分区中已有的对话历史重建检索文档，用于开启检索前的历史数据和更换 CRYPTO_SECRET 后重建索引
+func IndexConversationHistoryPartition(partition MESPartition, batchSize int) (int64, error) {
+	var indexed int64
+	lastId := 0
+	for {
+		var histories []*ConversationHistory
+		err := mesPartitionQuery(partition).Where("id > ? AND deleted_at IS NULL", lastId).Order("id").Limit(batchSize).Find(&histories).Error
+		if err != nil {
+			return indexed, err
+		}
+		if len(histories) == 0 {
+			return indexed, nil
+		}
+		lastId = histories[len(histories)-1].Id
+		if err = decodeConversationHistories(histories); err != nil {
+			return indexed, err
+		}
+		for _, history := range histories {
+			if err = indexConversationHistory(partition.Table, history, history.RawJson, 0); err != nil {
+				return indexed, err
+			}
+			indexed++
+		}
+		if len(histories) < batchSize {
+			return indexed, nil
+		}
+	}
+}
+
+// deleteConversationSearchDocumentsBefore 删除 cutoff 之前的检索文档，与对话历史的清理保持一致
+func deleteConversationSearchDocumentsBefore(cutoff time.Time, now time.Time) error {
+	// 保留期更长、尚未到期的记录保留索引
+	retained := MES_DB.Model(&ConversationHistoryRetention{}).Select("1").
+		Where("conversation_history_retentions.history_table = conversation_search_documents.history_table").
+		Where("conversation_history_retentions.history_id = conversation_search_documents.history_id").
+		Where("conversation_history_retentions.expire_at >= ?", now)
+	return MES_DB.Where("created_at < ?", cutoff).Where("NOT EXISTS (?)", retained).
+		Delete(&ConversationSearchDocument{}).Error
+}
+
+// ConversationSearchFilter 全文检索条件，Keyword 中的每个词都必须出现；Role 限定关键词出现在该角色的消息中
+type ConversationSearchFilter struct {
+	ConversationHistoryFilter
+	TokenId int
+	Role    string
+}
+
+// ConversationSearchResult 检索结果，Snippets 为 HTML 转义后的摘要，匹配的词用 <mark> 标出
+type ConversationSearchResult struct {
+	*ConversationHistory
+	Score    float64  `json:"score"`
+	Snippets []string `json:"snippets"`
+}
+
+type conversationSearchHit struct {
+	HistoryTable string
+	HistoryId    int
+	Score        float64
+}
+
+// searchRoles 关键词匹配的角色
+func (f *ConversationSearchFilter) searchRoles() []string {
+	if f.Role != "" {
+		return []string{normalizeConversationSearchRole(f.Role)}
+	}
+	return conversationSearchRoles
+}
+
+// apply 应用过滤条件和关键词匹配，返回查询和排序使用的相关度表达式
+func (f *ConversationSearchFilter) apply(tx *gorm.DB, words []string) (*gorm.DB, clause.Expr) {
+	if f.UserId > 0 {
+		tx = tx.Where("user_id = ?", f.UserId)
+	}
+	if f.TokenId > 0 {
+		tx = tx.Where("token_id = ?", f.TokenId)
+	}
+	if f.ConversationId != "" {
+		tx = tx.Where("conversation_id = ?", f.ConversationId)
+	}
+	if f.ModelName != "" {
+		tx = tx.Where("model_name = ?", f.ModelName)
+	}
+	if f.Role != "" {
+		tx = tx.Where("roles LIKE ?", "%,"+normalizeConversationSearchRole(f.Role)+",%")
+	}
+	if f.StartTime != nil {
+		tx = tx.Where("created_at >= ?", *f.StartTime)
+	}
+	if f.EndTime != nil {
+		tx = tx.Where("created_at <= ?", *f.EndTime)
+	}
+
+	// 每个词元必须出现在任意一个允许的角色中
+	var groups [][]string
+	for _, word := range words {
+		for _, token := range conversationSearchTokens(word) {
+			var group []string
+			for _, role := range f.searchRoles() {
+				group = append(group, conversationSearchTerm(role, token))
+			}
+			groups = append(groups, group)
+		}
+	}
+	score := clause.Expr{SQL: "0"}
+	if len(groups) == 0 {
+		return tx, score
+	}
+	switch mesDialect() {
+	case common.DatabaseTypeMySQL:
+		parts := make([]string, len(groups))
+		for i, group := range groups {
+			parts[i] = "+(" + strings.Join(group, " ") + ")"
+		}
+		query := strings.Join(parts, " ")
+		tx = tx.Where("MATCH(terms) AGAINST (? IN BOOLEAN MODE)", query)
+		score = clause.Expr{SQL: "MATCH(terms) AGAINST (? IN BOOLEAN MODE)", Vars: []interface{}{query}}
+	case common.DatabaseTypePostgreSQL:
+		parts := make([]string, len(groups))
+		for i, group := range groups {
+			parts[i] = "(" + strings.Join(group, " | ") + ")"
+		}
+		query := strings.Join(parts, " & ")
+		tx = tx.Where("to_tsvector('simple', terms) @@ to_tsquery('simple', ?)", query)
+		score = clause.Expr{SQL: "ts_rank(to_tsvector('simple', terms), to_tsquery('simple', ?))", Vars: []interface{}{query}}
+	default:
+		// SQLite 没有可用的全文索引，逐个匹配词元，结果按时间排序
+		for _, group := range groups {
+			conditions := make([]string, len(group))
+			args := make([]interface{}, len(group))
+			for i, term := range group {
+				conditions[i] = "terms LIKE ?"
+				args[i] = "% " + term + " %"
+			}
+			tx = tx.Where("("+strings.Join(conditions, " OR ")+")", args...)
+		}
+	}
+	return tx, score
+}
+
+// SearchConversationHistoriesByIndex 使用全文检索索引搜索对话历史，按相关度和时间排序，返回带高亮摘要的结果和总数
+func SearchConversationHistoriesByIndex(filter ConversationSearchFilter, limit int, offset int) ([]*ConversationSearchResult, int64, error) {
+	words := splitConversationSearchWords(filter.Keyword)
+	query, score := filter.apply(MES_DB.Model(&ConversationSearchDocument{}), words)
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	var hits []conversationSearchHit
+	err := query.Select("history_table, history_id, ? AS score", score).
+		Order("score desc, created_at desc, id desc").
+		Limit(limit).Offset(offset).
+		Scan(&hits).Error
+	if err != nil {
+		return nil, 0, err
+	}
+
+	// 按表批量读取对话历史，已删除或已归档的记录不返回
+	idsByTable := make(map[string][]int)
+	for _, hit := range hits {
+		idsByTable[hit.HistoryTable] = append(idsByTable[hit.HistoryTable], hit.HistoryId)
+	}
+	type historyKey struct {
+		table string
+		id    int
+	}
+	historyMap := make(map[historyKey]*ConversationHistory, len(hits))
+	var histories []*ConversationHistory
+	for tableName, ids := range idsByTable {
+		if !MES_DB.Migrator().HasTable(tableName) {
+			continue
+		}
+		var tableHistories []*ConversationHistory
+		if err = MES_DB.Table(tableName).Where("id IN ?", ids).Find(&tableHistories).Error; err != nil {
+			return nil, 0, err
+		}
+		for _, history := range tableHistories {
+			historyMap[historyKey{tableName, history.Id}] = history
+		}
+		histories = append(histories, tableHistories...)
+	}
+	if err = decodeConversationHistories(histories); err != nil {
+		return nil, 0, err
+	}
+
+	results := make([]*ConversationSearchResult, 0, len(hits))
+	for _, hit := range hits {
+		history, ok := historyMap[historyKey{hit.HistoryTable, hit.HistoryId}]
+		if !ok {
+			continue
+		}
+		results = append(results, &ConversationSearchResult{
+			ConversationHistory: history,
+			Score:               hit.Score,
+			Snippets:            conversationSearchSnippets(history.RawJson, words, filter.Role),
+		})
+	}
+	return results, total, nil
+}
+
+// conversationSearchSnippets 从本轮新增的消息中截取包含关键词的摘要，没有关键词时返回每条消息的开头
+func conversationSearchSnippets(rawJson string, words []string, role string) []string {
+	snippets := make([]string, 0, conversationSearchMaxSnippets)
+	for _, message := range latestConversationTurn(rawJson) {
+		if len(snippets) >= conversationSearchMaxSnippets {
+			break
+		}
+		if role != "" && normalizeConversationSearchRole(message.Role) != normalizeConversationSearchRole(role) {
+			continue
+		}
+		if snippet, ok := highlightConversationSnippet(message.text(), words); ok {
+			snippets = append(snippets, snippet)
+		}
+	}
+	return snippets
+}
+
+// highlightConversationSnippet 截取第一个匹配位置附近的文本，用 <mark> 标出所有匹配的词，文本没有匹配时返回 false
+func highlightConversationSnippet(text string, words []string) (string, bool) {
+	runes := []rune(text)
+	if len(runes) == 0 {
+		return "", false
+	}
+	lower := make([]rune, len(runes))
+	for i, r := range runes {
+		lower[i] = unicode.ToLower(r)
+	}
+	marked := make([]bool, len(runes))
+	first := -1
+	for _, word := range words {
+		target := []rune(word)
+		for i := 0; i+len(target) <= len(lower); i++ {
+			if !hasRunePrefix(lower[i:], target) {
+				continue
+			}
+			for j := i; j < i+len(target); j++ {
+				marked[j] = true
+			}
+			if first < 0 || i < first {
+				first = i
+			}
+		}
+	}
+	if len(words) > 0 && first < 0 {
+		return "", false
+	}
+
+	start := 0
+	if first > conversationSearchSnippetLen/4 {
+		start = first - conversationSearchSnippetLen/4
+	}
+	end := start + conversationSearchSnippetLen
+	if end > len(runes) {
+		end = len(runes)
+	}
+	var builder strings.Builder
+	if start > 0 {
+		builder.WriteString("…")
+	}
+	for i := start; i < end; {
+		j := i
+		for j < end && marked[j] == marked[i] {
+			j++
+		}
+		segment := html.EscapeString(string(runes[i:j]))
+		if marked[i] {
+			segment = "<mark>" + segment + "</mark>"
+		}
+		builder.WriteString(segment)
+		i = j
+	}
+	if end < len(runes) {
+		builder.WriteString("…")
+	}
+	return builder.String(), true
+}
+
+func hasRunePrefix(runes []rune, prefix []rune) bool {
+	for i, r := range prefix {
+		if runes[i] != r {
+			return false
+		}
+	}
+	return true
+}
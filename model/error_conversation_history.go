@@ -12,7 +12,7 @@ import (
 
 // GetErrorConversationHistoryTableName 获取错误对话历史表名（支持日期分表）
 func GetErrorConversationHistoryTableName(date ...time.Time) string {
-	if !common.MESDailyPartition {
+	if !useMESDailyTables() {
 		return "error_conversation_histories"
 	}
 
@@ -26,8 +26,11 @@ func GetErrorConversationHistoryTableName(date ...time.Time) string {
 	return fmt.Sprintf("error_conversation_histories_%s", targetDate.Format("2006_01_02"))
 }
 
-// ensureErrorConversationHistoryTableExists 确保错误对话历史表存在
-func ensureErrorConversationHistoryTableExists(tableName string) error {
+// ensureErrorConversationHistoryTableExists 确保错误对话历史表存在，原生分区模式下按 date 创建记录所在的分区
+func ensureErrorConversationHistoryTableExists(tableName string, date time.Time) error {
+	if IsMESNativePartition() {
+		return ensureNativePartition(tableName, true, date)
+	}
 	if MES_DB.Migrator().HasTable(tableName) {
 		return nil
 	}
@@ -35,38 +38,12 @@ func ensureErrorConversationHistoryTableExists(tableName string) error {
 	return MES_DB.Table(tableName).AutoMigrate(&ErrorConversationHistory{})
 }
 
-// getErrorConversationHistoryAllTables 获取所有存在的错误对话历史分表
+// getErrorConversationHistoryAllTables 获取所有存在的错误对话历史分表，按日期升序排列
 func getErrorConversationHistoryAllTables() []string {
-	var tables []string
-
-	if !common.MESDailyPartition {
-		return []string{"error_conversation_histories"}
-	}
-
-	// 获取数据库中所有以 error_conversation_histories_ 开头的表
-	var tableNames []string
-	err := MES_DB.Raw("SHOW TABLES LIKE 'error_conversation_histories_%'").Scan(&tableNames).Error
-	if err != nil {
-		// 如果查询失败，回退到检查最近一年的表
-		now := time.Now()
-		for i := 0; i < 365; i++ {
-			pastDate := now.AddDate(0, 0, -i)
-			tableName := GetErrorConversationHistoryTableName(pastDate)
-			if MES_DB.Migrator().HasTable(tableName) {
-				tables = append(tables, tableName)
-			}
-		}
-		return tables
+	if !useMESDailyTables() {
+		return []string{ErrorConversationHistoryBaseTable}
 	}
-
-	tables = append(tables, tableNames...)
-
-	// 添加基础表（如果存在的话）
-	if MES_DB.Migrator().HasTable("error_conversation_histories") {
-		tables = append(tables, "error_conversation_histories")
-	}
-
-	return tables
+	return getMESPartitionTables(ErrorConversationHistoryBaseTable)
 }
 
 type ErrorConversationHistory struct {
@@ -87,8 +64,16 @@ func (ErrorConversationHistory) TableName() string {
 	return "error_conversation_histories"
 }
 
-// CreateErrorConversationHistory 创建错误对话历史记录
-func CreateErrorConversationHistory(c *gin.Context, conversationId string, modelName string, rawJson string, errorMessage string, errorCode string, userId int) error {
+// CreateErrorConversationHistory 创建错误对话历史记录，retentionDays 为存储策略中的保留天数，0 表示按全局清理天数清理
+func CreateErrorConversationHistory(c *gin.Context, conversationId string, modelName string, rawJson string, errorMessage string, errorCode string, userId int, retentionDays int) error {
+	rawJson, err := encryptHistoryField(rawJson)
+	if err != nil {
+		common.LogError(c, "failed to encrypt error conversation history: "+err.Error())
+		return err
+	}
+
+	// 分表和分区都按记录写入的 created_at 计算
+	now := time.Now()
 	history := &ErrorConversationHistory{
 		ConversationId: conversationId,
 		ModelName:      modelName,
@@ -96,13 +81,14 @@ func CreateErrorConversationHistory(c *gin.Context, conversationId string, model
 		ErrorMessage:   errorMessage,
 		ErrorCode:      errorCode,
 		UserId:         userId,
+		CreatedAt:      now,
 	}
 
 	// 获取当天的表名
-	tableName := GetErrorConversationHistoryTableName()
+	tableName := GetErrorConversationHistoryTableName(now)
 
 	// 确保表存在
-	err := ensureErrorConversationHistoryTableExists(tableName)
+	err = ensureErrorConversationHistoryTableExists(tableName, now)
 	if err != nil {
 		common.LogError(c, "failed to ensure error conversation history table exists: "+err.Error())
 		return err
@@ -114,6 +100,10 @@ func CreateErrorConversationHistory(c *gin.Context, conversationId string, model
 		return err
 	}
 
+	if err = createConversationHistoryRetention(tableName, history.Id, history.CreatedAt, retentionDays); err != nil {
+		common.LogError(c, "failed to save error conversation history retention: "+err.Error())
+	}
+
 	return nil
 }
 
@@ -121,13 +111,13 @@ func CreateErrorConversationHistory(c *gin.Context, conversationId string, model
 func GetErrorConversationHistoryById(id int) (*ErrorConversationHistory, error) {
 	var history ErrorConversationHistory
 
-	if !common.MESDailyPartition {
+	if !useMESDailyTables() {
 		// 不分表，直接查询
 		err := MES_DB.Table("error_conversation_histories").First(&history, id).Error
 		if err != nil {
 			return nil, err
 		}
-		return &history, nil
+		return &history, decodeErrorConversationHistories([]*ErrorConversationHistory{&history})
 	}
 
 	// 分表模式：查询所有存在的表
@@ -140,7 +130,7 @@ func GetErrorConversationHistoryById(id int) (*ErrorConversationHistory, error)
 
 		err := MES_DB.Table(tableName).First(&history, id).Error
 		if err == nil {
-			return &history, nil
+			return &history, decodeErrorConversationHistories([]*ErrorConversationHistory{&history})
 		}
 	}
 
@@ -152,7 +142,7 @@ func GetErrorConversationHistoriesByConversationId(conversationId string, limit
 	var histories []*ErrorConversationHistory
 	var total int64
 
-	if !common.MESDailyPartition {
+	if !useMESDailyTables() {
 		// 不分表，直接查询
 		err := MES_DB.Table("error_conversation_histories").Model(&ErrorConversationHistory{}).Where("conversation_id = ?", conversationId).Count(&total).Error
 		if err != nil {
@@ -169,7 +159,7 @@ func GetErrorConversationHistoriesByConversationId(conversationId string, limit
 			return nil, 0, err
 		}
 
-		return histories, total, nil
+		return histories, total, decodeErrorConversationHistories(histories)
 	}
 
 	// 分表模式：查询所有存在的表
@@ -226,7 +216,7 @@ func GetErrorConversationHistoriesByConversationId(conversationId string, limit
 		collected += len(tableHistories)
 	}
 
-	return histories, total, nil
+	return histories, total, decodeErrorConversationHistories(histories)
 }
 
 // GetErrorConversationHistoriesByUserId 根据用户ID获取错误对话历史列表
@@ -234,7 +224,7 @@ func GetErrorConversationHistoriesByUserId(userId int, limit int, offset int) ([
 	var histories []*ErrorConversationHistory
 	var total int64
 
-	if !common.MESDailyPartition {
+	if !useMESDailyTables() {
 		// 不分表，直接查询
 		err := MES_DB.Table("error_conversation_histories").Model(&ErrorConversationHistory{}).Where("user_id = ?", userId).Count(&total).Error
 		if err != nil {
@@ -251,7 +241,7 @@ func GetErrorConversationHistoriesByUserId(userId int, limit int, offset int) ([
 			return nil, 0, err
 		}
 
-		return histories, total, nil
+		return histories, total, decodeErrorConversationHistories(histories)
 	}
 
 	// 分表模式：查询所有存在的表
@@ -308,12 +298,12 @@ func GetErrorConversationHistoriesByUserId(userId int, limit int, offset int) ([
 		collected += len(tableHistories)
 	}
 
-	return histories, total, nil
+	return histories, total, decodeErrorConversationHistories(histories)
 }
 
 // DeleteErrorConversationHistory 软删除错误对话历史
 func DeleteErrorConversationHistory(id int) error {
-	if !common.MESDailyPartition {
+	if !useMESDailyTables() {
 		return MES_DB.Table("error_conversation_histories").Delete(&ErrorConversationHistory{}, id).Error
 	}
 
@@ -338,7 +328,7 @@ func DeleteErrorConversationHistory(id int) error {
 
 // DeleteErrorConversationHistoriesByConversationId 根据对话ID软删除错误对话历史
 func DeleteErrorConversationHistoriesByConversationId(conversationId string) error {
-	if !common.MESDailyPartition {
+	if !useMESDailyTables() {
 		return MES_DB.Table("error_conversation_histories").Where("conversation_id = ?", conversationId).Delete(&ErrorConversationHistory{}).Error
 	}
 
@@ -361,7 +351,7 @@ func DeleteErrorConversationHistoriesByConversationId(conversationId string) err
 
 // HardDeleteErrorConversationHistory 硬删除错误对话历史
 func HardDeleteErrorConversationHistory(id int) error {
-	if !common.MESDailyPartition {
+	if !useMESDailyTables() {
 		return MES_DB.Table("error_conversation_histories").Unscoped().Delete(&ErrorConversationHistory{}, id).Error
 	}
 
@@ -389,7 +379,7 @@ func SearchErrorConversationHistories(keyword string, userId int, modelName stri
 	var histories []*ErrorConversationHistory
 	var total int64
 
-	if !common.MESDailyPartition {
+	if !useMESDailyTables() {
 		// 不分表，直接查询
 		query := MES_DB.Table("error_conversation_histories").Model(&ErrorConversationHistory{})
 
@@ -422,7 +412,7 @@ func SearchErrorConversationHistories(keyword string, userId int, modelName stri
 			return nil, 0, err
 		}
 
-		return histories, total, nil
+		return histories, total, decodeErrorConversationHistories(histories)
 	}
 
 	// 分表模式：查询所有存在的表
@@ -519,7 +509,20 @@ func SearchErrorConversationHistories(keyword string, userId int, modelName stri
 		collected += len(tableHistories)
 	}
 
-	return histories, total, nil
+	return histories, total, decodeErrorConversationHistories(histories)
+}
+
+// decodeErrorConversationHistories 解密查询到的错误对话历史，无法解密的记录保留密文
+func decodeErrorConversationHistories(histories []*ErrorConversationHistory) error {
+	for _, history := range histories {
+		rawJson, err := decryptHistoryField(history.RawJson)
+		if err != nil {
+			common.SysError(fmt.Sprintf("failed to decrypt error conversation history %d: %v", history.Id, err))
+			continue
+		}
+		history.RawJson = rawJson
+	}
+	return nil
 }
 
 // GetRawJsonAsMap 将RawJson字段解析为map
@@ -543,17 +546,19 @@ func (h *ErrorConversationHistory) SetRawJsonFromMap(data map[string]interface{}
 }
 
 // CleanupOldErrorConversationHistories 清理旧的错误对话历史记录
+// 按存储策略设置了保留天数且未到期的记录会被跳过
 func CleanupOldErrorConversationHistories(days int) (int64, error) {
-	cutoffTime := time.Now().AddDate(0, 0, -days)
+	now := time.Now()
+	cutoffTime := now.AddDate(0, 0, -days)
 
-	if !common.MESDailyPartition {
-		result := MES_DB.Table("error_conversation_histories").Where("created_at < ?", cutoffTime).Delete(&ErrorConversationHistory{})
+	if !useMESDailyTables() {
+		result := MES_DB.Table("error_conversation_histories").Where("created_at < ?", cutoffTime).
+			Where("id NOT IN (?)", retainedConversationHistoryIds("error_conversation_histories", now)).Delete(&ErrorConversationHistory{})
 		return result.RowsAffected, result.Error
 	}
 
 	// 分表模式：清理旧表或旧记录
 	var totalDeleted int64 = 0
-	now := time.Now()
 
 	// 检查过去180天的表（比较保守的范围）
 	for i := days; i < 180; i++ {
@@ -567,14 +572,15 @@ func CleanupOldErrorConversationHistories(days int) (int64, error) {
 		// 如果整个表都过期了，可以选择删除整个表
 		if pastDate.Before(cutoffTime.AddDate(0, 0, -1)) {
 			// 删除表中的所有记录
-			result := MES_DB.Table(tableName).Where("1 = 1").Delete(&ErrorConversationHistory{})
+			result := MES_DB.Table(tableName).Where("id NOT IN (?)", retainedConversationHistoryIds(tableName, now)).Delete(&ErrorConversationHistory{})
 			if result.Error != nil {
 				return totalDeleted, result.Error
 			}
 			totalDeleted += result.RowsAffected
 		} else {
 			// 部分记录过期，只删除过期记录
-			result := MES_DB.Table(tableName).Where("created_at < ?", cutoffTime).Delete(&ErrorConversationHistory{})
+			result := MES_DB.Table(tableName).Where("created_at < ?", cutoffTime).
+				Where("id NOT IN (?)", retainedConversationHistoryIds(tableName, now)).Delete(&ErrorConversationHistory{})
 			if result.Error != nil {
 				return totalDeleted, result.Error
 			}
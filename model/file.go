@@ -0,0 +1,78 @@
+package model
+
+import (
+	"errors"
+	"one-api/dto"
+
+	"gorm.io/gorm"
+)
+
+const (
+	FilePurposeBatch       = "batch"
+	FilePurposeBatchOutput = "batch_output"
+)
+
+// File 通过 Files API 上传或由批量任务生成的文件，内容保存在本地存储中
+type File struct {
+	Id          string `json:"id" gorm:"type:varchar(64);primaryKey"`
+	UserId      int    `json:"user_id" gorm:"index"`
+	TokenId     int    `json:"token_id" gorm:"index"`
+	Filename    string `json:"filename" gorm:"type:varchar(255)"`
+	Purpose     string `json:"purpose" gorm:"type:varchar(32);index"`
+	Bytes       int64  `json:"bytes"`
+	Status      string `json:"status" gorm:"type:varchar(20)"`
+	CreatedTime int64  `json:"created_time" gorm:"bigint;index"`
+}
+
+func (file *File) ToOpenAIFile() *dto.OpenAIFile {
+	return &dto.OpenAIFile{
+		Id:        file.Id,
+		Object:    "file",
+		Bytes:     file.Bytes,
+		CreatedAt: file.CreatedTime,
+		Filename:  file.Filename,
+		Purpose:   file.Purpose,
+		Status:    file.Status,
+	}
+}
+
+func (file *File) Insert() error {
+	return DB.Create(file).Error
+}
+
+// Save 写入或覆盖文件记录，用于可能重复执行的后台任务
+func (file *File) Save() error {
+	return DB.Save(file).Error
+}
+
+func (file *File) Delete() error {
+	return DB.Delete(file).Error
+}
+
+func GetFileByIdAndUserId(id string, userId int) (*File, error) {
+	if id == "" || userId == 0 {
+		return nil, errors.New("id 或 userId 为空！")
+	}
+	var file File
+	err := DB.First(&file, "id = ? and user_id = ?", id, userId).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, errors.New("文件不存在")
+	}
+	return &file, err
+}
+
+// GetUserFiles 按创建时间倒序分页查询用户文件，after 为上一页最后一个文件的 id
+func GetUserFiles(userId int, purpose string, after string, limit int) (files []*File, err error) {
+	query := DB.Where("user_id = ?", userId)
+	if purpose != "" {
+		query = query.Where("purpose = ?", purpose)
+	}
+	if after != "" {
+		var afterFile File
+		if err = DB.First(&afterFile, "id = ? and user_id = ?", after, userId).Error; err == nil {
+			query = query.Where("created_time < ? or (created_time = ? and id < ?)", afterFile.CreatedTime, afterFile.CreatedTime, afterFile.Id)
+		}
+	}
+	err = query.Order("created_time desc, id desc").Limit(limit).Find(&files).Error
+	return files, err
+}
@@ -0,0 +1,251 @@
+package model
+
+import (
+	"encoding/json"
+	"fmt"
+	"one-api/common"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	HistoryArchiveStatusArchived = "archived" // 已导出并校验，分表可能已删除
+	HistoryArchiveStatusRestored = "restored" // 已恢复到 MES 数据库
+)
+
+// HistoryArchive 对话历史分表的归档记录，归档文件为 gzip 压缩的 JSONL，同目录下有记录校验和的 manifest
+type HistoryArchive struct {
+	Id           int    `json:"id"`
+	BaseTable    string `json:"base_table" gorm:"type:varchar(64);uniqueIndex:idx_history_archive_table_date"`
+	Partition    string `json:"partition" gorm:"type:varchar(128)"`
+	Date         string `json:"date" gorm:"type:varchar(10);uniqueIndex:idx_history_archive_table_date"` // 2006-01-02
+	Storage      string `json:"storage" gorm:"type:varchar(16)"`
+	Path         string `json:"path" gorm:"type:varchar(512)"`
+	ManifestPath string `json:"manifest_path" gorm:"type:varchar(512)"`
+	Rows         int64  `json:"rows"`
+	Size         int64  `json:"size"`
+	Sha256       string `json:"sha256" gorm:"type:char(64)"`
+	Status       string `json:"status" gorm:"type:varchar(16)"`
+	Dropped      bool   `json:"dropped"`
+	CreatedTime  int64  `json:"created_time" gorm:"bigint"`
+	RestoredTime int64  `json:"restored_time" gorm:"bigint"`
+}
+
+func (archive *HistoryArchive) Update() error {
+	return DB.Save(archive).Error
+}
+
+func GetHistoryArchiveById(id int) (*HistoryArchive, error) {
+	var archive HistoryArchive
+	err := DB.First(&archive, "id = ?", id).Error
+	return &archive, err
+}
+
+// GetHistoryArchive 获取某个基础表某一天的归档记录，不存在时返回 nil
+func GetHistoryArchive(baseTable string, date string) (*HistoryArchive, error) {
+	var archives []*HistoryArchive
+	err := DB.Where("base_table = ? AND date = ?", baseTable, date).Limit(1).Find(&archives).Error
+	if err != nil || len(archives) == 0 {
+		return nil, err
+	}
+	return archives[0], nil
+}
+
+func GetHistoryArchives(baseTable string, startIdx int, num int) (archives []*HistoryArchive, total int64, err error) {
+	tx := DB.Model(&HistoryArchive{})
+	if baseTable != "" {
+		tx = tx.Where("base_table = ?", baseTable)
+	}
+	if err = tx.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	err = tx.Order("date desc, id desc").Limit(num).Offset(startIdx).Find(&archives).Error
+	return archives, total, err
+}
+
+// newMESPartitionRows 根据基础表返回对应记录类型的切片
+func newMESPartitionRows(baseTable string) (interface{}, error) {
+	switch baseTable {
+	case ConversationHistoryBaseTable:
+		return &[]*ConversationHistory{}, nil
+	case ErrorConversationHistoryBaseTable:
+		return &[]*ErrorConversationHistory{}, nil
+	}
+	return nil, fmt.Errorf("unknown conversation history table: %s", baseTable)
+}
+
+// mesPartitionQuery 获取某一天分区的查询，原生分区按 created_at 范围查询父表，按天分表直接查询当天的表
+func mesPartitionQuery(partition MESPartition) *gorm.DB {
+	if partition.Native {
+		nextDay := partition.Date.AddDate(0, 0, 1)
+		return MES_DB.Table(partition.Table).Unscoped().Where("created_at >= ? AND created_at < ?", partition.Date, nextDay)
+	}
+	return MES_DB.Table(partition.Table).Unscoped()
+}
+
+// ExportMESPartitionRows 按 id 顺序分批读取分区中的所有记录（包括软删除的记录），每条记录序列化为 JSON 后交给 fn。
+// 去重存储的对话历史导出为包含完整 messages 的 RawJson。
+func ExportMESPartitionRows(partition MESPartition, baseTable string, batchSize int, fn func(row []byte) error) (int64, error) {
+	var total int64
+	lastId := 0
+	for {
+		rows, err := newMESPartitionRows(baseTable)
+		if err != nil {
+			return total, err
+		}
+		err = mesPartitionQuery(partition).Where("id > ?", lastId).Order("id").Limit(batchSize).Find(rows).Error
+		if err != nil {
+			return total, err
+		}
+		count := 0
+		switch items := rows.(type) {
+		case *[]*ConversationHistory:
+			if err = expandArchivedConversationHistories(*items); err != nil {
+				return total, err
+			}
+			for _, item := range *items {
+				if err = exportMESRow(item, fn); err != nil {
+					return total, err
+				}
+				lastId = item.Id
+			}
+			count = len(*items)
+		case *[]*ErrorConversationHistory:
+			for _, item := range *items {
+				if err = exportMESRow(item, fn); err != nil {
+					return total, err
+				}
+				lastId = item.Id
+			}
+			count = len(*items)
+		}
+		total += int64(count)
+		if count < batchSize {
+			return total, nil
+		}
+	}
+}
+
+func exportMESRow(item interface{}, fn func(row []byte) error) error {
+	data, err := json.Marshal(item)
+	if err != nil {
+		return err
+	}
+	return fn(data)
+}
+
+// CountMESPartitionRows 统计分区中的记录数（包括软删除的记录），用于归档后校验
+func CountMESPartitionRows(partition MESPartition) (int64, error) {
+	var count int64
+	err := mesPartitionQuery(partition).Count(&count).Error
+	return count, err
+}
+
+// DropMESPartition 删除已归档的分区，按天分表删除整张表，原生分区删除对应的分区。
+// 删除对话历史分区后清理不再被引用的去重消息。
+func DropMESPartition(partition MESPartition) error {
+	if !partition.Native {
+		if err := MES_DB.Migrator().DropTable(partition.Table); err != nil {
+			return err
+		}
+	} else {
+		var statement string
+		if mesDialect() == common.DatabaseTypeMySQL {
+			statement = fmt.Sprintf("ALTER TABLE `%s` DROP PARTITION %s", partition.Table, partition.Name)
+		} else {
+			statement = fmt.Sprintf(`DROP TABLE "%s"`, partition.Name)
+		}
+		if err := MES_DB.Exec(statement).Error; err != nil {
+			return err
+		}
+		nativePartitionCache.Delete(partition.Table + ":" + partition.Name)
+	}
+	if strings.HasPrefix(partition.Table, ConversationHistoryBaseTable) {
+		_, err := CleanupOrphanConversationMessages()
+		return err
+	}
+	return nil
+}
+
+// RestoreMESPartitionRows 将归档的记录写回某一天的分区，已存在的 id 会被跳过以便重复恢复
+func RestoreMESPartitionRows(baseTable string, date time.Time, rows [][]byte) error {
+	if len(rows) == 0 {
+		return nil
+	}
+	tableName := baseTable
+	var err error
+	if IsMESNativePartition() {
+		err = ensureNativePartition(baseTable, baseTable == ErrorConversationHistoryBaseTable, date)
+	} else {
+		if useMESDailyTables() {
+			tableName = baseTable + "_" + date.Format(mesPartitionTableDateFormat)
+		}
+		if baseTable == ErrorConversationHistoryBaseTable {
+			err = ensureErrorConversationHistoryTableExists(tableName, date)
+		} else {
+			err = ensureConversationHistoryTableExists(tableName, date)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	records, err := decodeMESPartitionRows(baseTable, rows, func(rawJson *string) error {
+		// 恢复的记录按当前加密配置重新处理，不再依赖归档时使用的密钥
+		value, _, err := reencryptHistoryField(*rawJson)
+		if err != nil {
+			return err
+		}
+		*rawJson = value
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return MES_DB.Table(tableName).Clauses(clause.OnConflict{DoNothing: true}).Create(records).Error
+}
+
+// CheckMESPartitionRowsDecryptable 检查归档记录中的 RawJson 都可以用当前密钥解密。
+// 归档保存的是归档时的密文，密钥轮换后需要将旧密钥保留在 CRYPTO_SECRET_PREVIOUS 中才能恢复。
+func CheckMESPartitionRowsDecryptable(baseTable string, rows [][]byte) error {
+	_, err := decodeMESPartitionRows(baseTable, rows, func(rawJson *string) error {
+		_, err := decryptHistoryField(*rawJson)
+		return err
+	})
+	return err
+}
+
+// decodeMESPartitionRows 解析归档的记录，fn 用于检查或修改每条记录的 RawJson
+func decodeMESPartitionRows(baseTable string, rows [][]byte, fn func(rawJson *string) error) (interface{}, error) {
+	records, err := newMESPartitionRows(baseTable)
+	if err != nil {
+		return nil, err
+	}
+	switch items := records.(type) {
+	case *[]*ConversationHistory:
+		for _, row := range rows {
+			var item ConversationHistory
+			if err = json.Unmarshal(row, &item); err != nil {
+				return nil, err
+			}
+			if err = fn(&item.RawJson); err != nil {
+				return nil, fmt.Errorf("%s id %d: %w", baseTable, item.Id, err)
+			}
+			*items = append(*items, &item)
+		}
+	case *[]*ErrorConversationHistory:
+		for _, row := range rows {
+			var item ErrorConversationHistory
+			if err = json.Unmarshal(row, &item); err != nil {
+				return nil, err
+			}
+			if err = fn(&item.RawJson); err != nil {
+				return nil, fmt.Errorf("%s id %d: %w", baseTable, item.Id, err)
+			}
+			*items = append(*items, &item)
+		}
+	}
+	return records, nil
+}
@@ -0,0 +1,228 @@
+package model
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"one-api/common"
+	"one-api/setting/operation_setting"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/hkdf"
+	"gorm.io/gorm"
+)
+
+// 加密后的字段格式为 enc:v1:<密钥ID>:<base64(nonce+密文)>，没有该前缀的字段视为明文
+const historyCipherPrefix = "enc:v1:"
+
+type historyCipherKey struct {
+	id   string
+	aead cipher.AEAD
+}
+
+// historyKeyRing 当前密钥用于加密，当前密钥和 CRYPTO_SECRET_PREVIOUS 中的旧密钥都可以解密
+type historyKeyRing struct {
+	current *historyCipherKey
+	keys    map[string]*historyCipherKey
+}
+
+var (
+	historyKeyRingCache     *historyKeyRing
+	historyKeyRingSecrets   string
+	historyKeyRingCacheLock sync.Mutex
+)
+
+// deriveHistoryCipherKey 使用 HKDF-SHA256 从 secret 派生对话历史专用的 AES-256 密钥，密钥ID为密钥摘要的前 8 位
+func deriveHistoryCipherKey(secret string) (*historyCipherKey, error) {
+	key := make([]byte, 32)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, []byte(secret), nil, []byte("one-api conversation history")), key); err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	digest := sha256.Sum256(key)
+	return &historyCipherKey{id: hex.EncodeToString(digest[:4]), aead: aead}, nil
+}
+
+func getHistoryKeyRing() (*historyKeyRing, error) {
+	secrets := append([]string{common.CryptoSecret}, common.CryptoSecretPrevious...)
+	cacheKey := strings.Join(secrets, "\x00")
+
+	historyKeyRingCacheLock.Lock()
+	defer historyKeyRingCacheLock.Unlock()
+	if historyKeyRingCache != nil && historyKeyRingSecrets == cacheKey {
+		return historyKeyRingCache, nil
+	}
+	ring := &historyKeyRing{keys: make(map[string]*historyCipherKey)}
+	for i, secret := range secrets {
+		key, err := deriveHistoryCipherKey(secret)
+		if err != nil {
+			return nil, err
+		}
+		if i == 0 {
+			ring.current = key
+		}
+		if _, ok := ring.keys[key.id]; !ok {
+			ring.keys[key.id] = key
+		}
+	}
+	historyKeyRingCache = ring
+	historyKeyRingSecrets = cacheKey
+	return ring, nil
+}
+
+func isHistoryEncryptionEnabled() bool {
+	return operation_setting.GetHistoryPrivacySetting().EncryptionEnabled
+}
+
+// historyCipherKeyId 返回加密字段使用的密钥ID，明文返回空字符串
+func historyCipherKeyId(value string) string {
+	if !strings.HasPrefix(value, historyCipherPrefix) {
+		return ""
+	}
+	keyId, _, _ := strings.Cut(strings.TrimPrefix(value, historyCipherPrefix), ":")
+	return keyId
+}
+
+func encryptHistoryValue(plaintext string) (string, error) {
+	ring, err := getHistoryKeyRing()
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, ring.current.aead.NonceSize())
+	if _, err = rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := ring.current.aead.Seal(nonce, nonce, []byte(plaintext), []byte(ring.current.id))
+	return historyCipherPrefix + ring.current.id + ":" + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// encryptHistoryField 启用加密时加密对话历史字段，否则原样返回
+func encryptHistoryField(value string) (string, error) {
+	if !isHistoryEncryptionEnabled() || strings.HasPrefix(value, historyCipherPrefix) {
+		return value, nil
+	}
+	return encryptHistoryValue(value)
+}
+
+// decryptHistoryField 解密对话历史字段，明文原样返回。无论当前是否启用加密，已加密的数据都可以读取。
+func decryptHistoryField(value string) (string, error) {
+	if !strings.HasPrefix(value, historyCipherPrefix) {
+		return value, nil
+	}
+	keyId, encoded, ok := strings.Cut(strings.TrimPrefix(value, historyCipherPrefix), ":")
+	if !ok {
+		return "", errors.New("invalid encrypted conversation history")
+	}
+	ring, err := getHistoryKeyRing()
+	if err != nil {
+		return "", err
+	}
+	key, ok := ring.keys[keyId]
+	if !ok {
+		return "", fmt.Errorf("conversation history key %s not found, check CRYPTO_SECRET_PREVIOUS", keyId)
+	}
+	sealed, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", err
+	}
+	nonceSize := key.aead.NonceSize()
+	if len(sealed) < nonceSize {
+		return "", errors.New("invalid encrypted conversation history")
+	}
+	plaintext, err := key.aead.Open(nil, sealed[:nonceSize], sealed[nonceSize:], []byte(keyId))
+	if err != nil {
+		return "", err
+	}
+	return string(plaintext), nil
+}
+
+// reencryptHistoryField 按当前配置重新处理字段：启用加密时使用当前密钥加密，未启用时解密为明文。
+// 字段已经符合当前配置时返回 false。
+func reencryptHistoryField(value string) (string, bool, error) {
+	keyId := historyCipherKeyId(value)
+	if isHistoryEncryptionEnabled() {
+		ring, err := getHistoryKeyRing()
+		if err != nil {
+			return "", false, err
+		}
+		if keyId == ring.current.id {
+			return value, false, nil
+		}
+	} else if keyId == "" {
+		return value, false, nil
+	}
+	plaintext, err := decryptHistoryField(value)
+	if err != nil {
+		return "", false, err
+	}
+	if !isHistoryEncryptionEnabled() {
+		return plaintext, true, nil
+	}
+	encrypted, err := encryptHistoryValue(plaintext)
+	if err != nil {
+		return "", false, err
+	}
+	return encrypted, true, nil
+}
+
+// reencryptMESRows 分批读取 id 之后的记录，将 column 按当前加密配置重新写入，返回更新的记录数
+func reencryptMESRows(query func() *gorm.DB, table string, column string, batchSize int) (int64, error) {
+	var updated int64
+	lastId := 0
+	for {
+		var rows []struct {
+			Id    int
+			Value string
+		}
+		err := query().Select("id, "+column+" AS value").Where("id > ?", lastId).Order("id").Limit(batchSize).Scan(&rows).Error
+		if err != nil {
+			return updated, err
+		}
+		for _, row := range rows {
+			lastId = row.Id
+			value, changed, err := reencryptHistoryField(row.Value)
+			if err != nil {
+				return updated, fmt.Errorf("%s id %d: %w", table, row.Id, err)
+			}
+			if !changed {
+				continue
+			}
+			if err = MES_DB.Table(table).Where("id = ?", row.Id).UpdateColumn(column, value).Error; err != nil {
+				return updated, err
+			}
+			updated++
+		}
+		if len(rows) < batchSize {
+			return updated, nil
+		}
+	}
+}
+
+// ReencryptMESPartition 按当前加密配置重新加密（或解密）分区中的 RawJson，用于密钥轮换和开启、关闭加密
+func ReencryptMESPartition(partition MESPartition, batchSize int) (int64, error) {
+	return reencryptMESRows(func() *gorm.DB {
+		return mesPartitionQuery(partition)
+	}, partition.Table, "raw_json", batchSize)
+}
+
+// ReencryptConversationMessages 按当前加密配置重新加密去重存储的消息
+func ReencryptConversationMessages(batchSize int) (int64, error) {
+	tableName := ConversationMessage{}.TableName()
+	return reencryptMESRows(func() *gorm.DB {
+		return MES_DB.Table(tableName)
+	}, tableName, "message", batchSize)
+}
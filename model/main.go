@@ -356,7 +356,24 @@ func InitMESDB() (err error) {
 	if os.Getenv("MES_SQL_DSN") == "" {
 		MES_DB = DB
 		common.SysLog("MES_SQL_DSN not set, using main database for conversation history")
-		return
+		// 与主库共用连接时数据库类型也与主库一致
+		common.UsingMESPostgreSQL = common.UsingPostgreSQL
+		common.UsingMESMySQL = common.UsingMySQL
+		common.UsingMESSQLite = common.UsingSQLite
+		switch {
+		case common.UsingPostgreSQL:
+			common.MESSqlType = common.DatabaseTypePostgreSQL
+		case common.UsingMySQL:
+			common.MESSqlType = common.DatabaseTypeMySQL
+		default:
+			common.MESSqlType = common.DatabaseTypeSQLite
+		}
+		if !common.IsMasterNode {
+			return nil
+		}
+		// 消息去重、全文检索和保留期等表同样需要在主库中创建
+		common.SysLog("MES database migration started")
+		return migrateMESDB()
 	}
 	db, err := chooseDB("MES_SQL_DSN", "mes")
 	if err == nil {
@@ -401,6 +418,15 @@ func migrateDB() error {
 		&QuotaData{},
 		&Task{},
 		&Setup{},
+		&File{},
+		&Batch{},
+		&ResponseCache{},
+		&HistoryArchive{},
+		&QuotaLedger{},
+		&Organization{},
+		&OrganizationMember{},
+		&SubscriptionPlan{},
+		&Subscription{},
 	)
 	if err != nil {
 		return err
@@ -410,7 +436,7 @@ func migrateDB() error {
 
 func migrateDBFast() error {
 	var wg sync.WaitGroup
-	errChan := make(chan error, 12) // Buffer size matches number of migrations
+	errChan := make(chan error, 21) // Buffer size matches number of migrations
 
 	migrations := []struct {
 		model interface{}
@@ -428,6 +454,15 @@ func migrateDBFast() error {
 		{&QuotaData{}, "QuotaData"},
 		{&Task{}, "Task"},
 		{&Setup{}, "Setup"},
+		{&File{}, "File"},
+		{&Batch{}, "Batch"},
+		{&ResponseCache{}, "ResponseCache"},
+		{&HistoryArchive{}, "HistoryArchive"},
+		{&QuotaLedger{}, "QuotaLedger"},
+		{&Organization{}, "Organization"},
+		{&OrganizationMember{}, "OrganizationMember"},
+		{&SubscriptionPlan{}, "SubscriptionPlan"},
+		{&Subscription{}, "Subscription"},
 	}
 
 	for _, m := range migrations {
@@ -464,6 +499,26 @@ func migrateLOGDB() error {
 
 func migrateMESDB() error {
 	var err error
+	// 去重存储的消息表不分区
+	if err = MES_DB.AutoMigrate(&ConversationMessage{}); err != nil {
+		return err
+	}
+	// 全文检索文档表同样不分区
+	if err = MES_DB.AutoMigrate(&ConversationSearchDocument{}); err != nil {
+		return err
+	}
+	if err = ensureConversationSearchIndex(); err != nil {
+		return err
+	}
+	// 保留期记录同样不分区
+	if err = MES_DB.AutoMigrate(&ConversationHistoryRetention{}); err != nil {
+		return err
+	}
+	if IsMESNativePartition() {
+		// 原生分区表的主键包含分区键，由 EnsureTodayTablesExist 建表，不能使用 AutoMigrate
+		common.SysLog("MES database uses native partition, skip auto migration")
+		return nil
+	}
 	if err = MES_DB.AutoMigrate(
 		&ConversationHistory{},
 		&ErrorConversationHistory{},
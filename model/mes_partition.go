@@ -0,0 +1,289 @@
+package model
+
+import (
+	"fmt"
+	"one-api/common"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	ConversationHistoryBaseTable      = "conversation_histories"
+	ErrorConversationHistoryBaseTable = "error_conversation_histories"
+
+	// 按天分表的日期后缀格式
+	mesPartitionTableDateFormat = "2006_01_02"
+	// 原生分区的分区名格式，例如 p20250115
+	mesNativePartitionNameFormat = "p20060102"
+)
+
+// MESPartition MES 数据库中的一个对话历史分区
+type MESPartition struct {
+	Table  string    `json:"table"`  // 数据所在的表名，原生分区时为父表
+	Name   string    `json:"name"`   // 分区名，按天分表时与表名相同
+	Date   time.Time `json:"date"`   // 分区对应的日期，未分表的基础表为零值
+	Native bool      `json:"native"` // 是否为数据库原生分区
+}
+
+// nativePartitionCache 记录已确认存在的原生分区，避免每次写入都查询数据库
+var nativePartitionCache sync.Map
+
+// mesDialect 获取 MES 数据库类型，未单独配置 MES_SQL_DSN 时与主数据库相同
+func mesDialect() string {
+	return MES_DB.Dialector.Name()
+}
+
+// IsMESNativePartition 是否使用数据库原生分区，仅 MySQL 和 PostgreSQL 支持，SQLite 下继续按天分表
+func IsMESNativePartition() bool {
+	if !common.MESNativePartition || MES_DB == nil {
+		return false
+	}
+	dialect := mesDialect()
+	return dialect == common.DatabaseTypeMySQL || dialect == common.DatabaseTypePostgreSQL
+}
+
+// useMESDailyTables 是否使用 conversation_histories_YYYY_MM_DD 形式的按天分表
+func useMESDailyTables() bool {
+	return common.MESDailyPartition && !IsMESNativePartition()
+}
+
+// isMESPartitionEnabled 是否启用了任意一种分区方式
+func isMESPartitionEnabled() bool {
+	return useMESDailyTables() || IsMESNativePartition()
+}
+
+// listMESTables 列出 MES 数据库中以 prefix 开头的表
+func listMESTables(prefix string) ([]string, error) {
+	var query string
+	switch mesDialect() {
+	case common.DatabaseTypeMySQL:
+		query = "SELECT table_name FROM information_schema.tables WHERE table_schema = DATABASE() AND table_name LIKE ?"
+	case common.DatabaseTypePostgreSQL:
+		query = "SELECT tablename FROM pg_tables WHERE schemaname = current_schema() AND tablename LIKE ?"
+	default:
+		query = "SELECT name FROM sqlite_master WHERE type = 'table' AND name LIKE ?"
+	}
+	var tableNames []string
+	err := MES_DB.Raw(query, prefix+"%").Scan(&tableNames).Error
+	return tableNames, err
+}
+
+// listNativePartitions 列出原生分区表的所有分区名
+func listNativePartitions(table string) ([]string, error) {
+	var query string
+	switch mesDialect() {
+	case common.DatabaseTypeMySQL:
+		query = "SELECT partition_name FROM information_schema.partitions WHERE table_schema = DATABASE() AND table_name = ? AND partition_name IS NOT NULL"
+	case common.DatabaseTypePostgreSQL:
+		query = "SELECT c.relname FROM pg_inherits i JOIN pg_class c ON c.oid = i.inhrelid JOIN pg_class p ON p.oid = i.inhparent " +
+			"WHERE p.relname = ? AND p.relnamespace = current_schema()::regnamespace"
+	default:
+		return nil, fmt.Errorf("native partition is not supported on %s", mesDialect())
+	}
+	var names []string
+	err := MES_DB.Raw(query, table).Scan(&names).Error
+	return names, err
+}
+
+// GetMESPartitions 获取基础表 baseTable 的所有分区，按日期升序排列，未分表的基础表（如果存在）排在最前
+func GetMESPartitions(baseTable string) ([]MESPartition, error) {
+	var partitions []MESPartition
+	if IsMESNativePartition() {
+		names, err := listNativePartitions(baseTable)
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range names {
+			// PostgreSQL 的分区是独立的子表，名称为 基础表_pYYYYMMDD
+			suffix := name[strings.LastIndex(name, "_")+1:]
+			date, err := time.ParseInLocation(mesNativePartitionNameFormat, suffix, time.Local)
+			if err != nil {
+				continue
+			}
+			partitions = append(partitions, MESPartition{Table: baseTable, Name: name, Date: date, Native: true})
+		}
+	} else {
+		prefix := baseTable + "_"
+		tableNames, err := listMESTables(prefix)
+		if err != nil {
+			return nil, err
+		}
+		for _, tableName := range tableNames {
+			// LIKE 中的下划线是通配符，这里严格校验日期后缀
+			date, err := time.ParseInLocation(mesPartitionTableDateFormat, strings.TrimPrefix(tableName, prefix), time.Local)
+			if err != nil {
+				continue
+			}
+			partitions = append(partitions, MESPartition{Table: tableName, Name: tableName, Date: date})
+		}
+		if MES_DB.Migrator().HasTable(baseTable) {
+			partitions = append(partitions, MESPartition{Table: baseTable, Name: baseTable})
+		}
+	}
+	sort.SliceStable(partitions, func(i, j int) bool {
+		return partitions[i].Date.Before(partitions[j].Date)
+	})
+	return partitions, nil
+}
+
+// getMESPartitionTables 获取按天分表模式下所有存在的表名，按日期升序排列
+func getMESPartitionTables(baseTable string) []string {
+	partitions, err := GetMESPartitions(baseTable)
+	if err != nil {
+		common.SysError(fmt.Sprintf("failed to list partitions of %s: %v", baseTable, err))
+		return nil
+	}
+	tables := make([]string, 0, len(partitions))
+	for _, partition := range partitions {
+		tables = append(tables, partition.Table)
+	}
+	return tables
+}
+
+// nativePartitionName 获取原生分区的分区名，PostgreSQL 的分区是独立的表，需要加上基础表名作为前缀
+func nativePartitionName(table string, date time.Time) string {
+	name := date.Format(mesNativePartitionNameFormat)
+	if mesDialect() == common.DatabaseTypePostgreSQL {
+		return table + "_" + name
+	}
+	return name
+}
+
+// isNativePartitionedTable 检查表是否已经是原生分区表
+func isNativePartitionedTable(table string) (bool, error) {
+	var count int64
+	var err error
+	if mesDialect() == common.DatabaseTypeMySQL {
+		err = MES_DB.Raw("SELECT COUNT(*) FROM information_schema.partitions WHERE table_schema = DATABASE() AND table_name = ? AND partition_name IS NOT NULL", table).Scan(&count).Error
+	} else {
+		err = MES_DB.Raw("SELECT COUNT(*) FROM pg_partitioned_table pt JOIN pg_class c ON c.oid = pt.partrelid "+
+			"WHERE c.relname = ? AND c.relnamespace = current_schema()::regnamespace", table).Scan(&count).Error
+	}
+	return count > 0, err
+}
+
+// nativePartitionTableDDL 生成原生分区父表的建表语句，分区键必须包含在主键中。
+// MySQL 的分区需要在建表时至少定义一个，initialDate 为第一个分区的日期。
+func nativePartitionTableDDL(table string, withError bool, initialDate time.Time) []string {
+	if mesDialect() == common.DatabaseTypeMySQL {
+		errorColumns := ""
+		if withError {
+			errorColumns = "`error_message` text, `error_code` varchar(100), "
+		}
+		nextDay := initialDate.AddDate(0, 0, 1).Format("2006-01-02")
+		return []string{fmt.Sprintf("CREATE TABLE IF NOT EXISTS `%[1]s` ("+
+			"`id` bigint NOT NULL AUTO_INCREMENT, "+
+			"`conversation_id` varchar(255) NOT NULL, "+
+			"`model_name` varchar(255) NOT NULL, "+
+			"`raw_json` longtext NOT NULL, "+
+			"%[2]s"+
+			"`user_id` bigint, "+
+			"`created_at` datetime(3) NOT NULL, "+
+			"`updated_at` datetime(3) NULL, "+
+			"`deleted_at` datetime(3) NULL, "+
+			"PRIMARY KEY (`id`, `created_at`), "+
+			"KEY `idx_%[1]s_conversation_id` (`conversation_id`), "+
+			"KEY `idx_%[1]s_model_name` (`model_name`), "+
+			"KEY `idx_%[1]s_user_id` (`user_id`), "+
+			"KEY `idx_%[1]s_deleted_at` (`deleted_at`)"+
+			") PARTITION BY RANGE (TO_DAYS(`created_at`)) (PARTITION %[3]s VALUES LESS THAN (TO_DAYS('%[4]s')))",
+			table, errorColumns, nativePartitionName(table, initialDate), nextDay)}
+	}
+	errorColumns := ""
+	if withError {
+		errorColumns = `"error_message" text, "error_code" varchar(100), `
+	}
+	statements := []string{fmt.Sprintf(`CREATE TABLE IF NOT EXISTS "%s" (`+
+		`"id" bigserial, `+
+		`"conversation_id" varchar(255) NOT NULL, `+
+		`"model_name" varchar(255) NOT NULL, `+
+		`"raw_json" text NOT NULL, `+
+		`%s`+
+		`"user_id" bigint, `+
+		`"created_at" timestamptz NOT NULL, `+
+		`"updated_at" timestamptz, `+
+		`"deleted_at" timestamptz, `+
+		`PRIMARY KEY ("id", "created_at")`+
+		`) PARTITION BY RANGE ("created_at")`, table, errorColumns)}
+	// PostgreSQL 11 以上在父表上创建的索引会自动应用到所有分区
+	for _, column := range []string{"conversation_id", "model_name", "user_id", "deleted_at"} {
+		statements = append(statements, fmt.Sprintf(`CREATE INDEX IF NOT EXISTS "idx_%[1]s_%[2]s" ON "%[1]s" ("%[2]s")`, table, column))
+	}
+	return statements
+}
+
+// ensureNativePartitionedTable 确保原生分区父表存在，已存在的普通表无法自动转换为分区表
+func ensureNativePartitionedTable(table string, withError bool, date time.Time) error {
+	if MES_DB.Migrator().HasTable(table) {
+		partitioned, err := isNativePartitionedTable(table)
+		if err != nil {
+			return err
+		}
+		if !partitioned {
+			return fmt.Errorf("table %s already exists and is not partitioned, please migrate it manually or disable MES_NATIVE_PARTITION", table)
+		}
+		return nil
+	}
+	for _, statement := range nativePartitionTableDDL(table, withError, date) {
+		if err := MES_DB.Exec(statement).Error; err != nil {
+			return fmt.Errorf("failed to create partitioned table %s: %v", table, err)
+		}
+	}
+	common.SysLog(fmt.Sprintf("Created native partitioned table %s", table))
+	return nil
+}
+
+// hasNativePartition 检查指定的分区是否存在
+func hasNativePartition(table string, name string) (bool, error) {
+	names, err := listNativePartitions(table)
+	if err != nil {
+		return false, err
+	}
+	for _, n := range names {
+		if n == name {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// ensureNativePartition 确保原生分区表中指定日期的分区存在
+func ensureNativePartition(table string, withError bool, date time.Time) error {
+	day := time.Date(date.Year(), date.Month(), date.Day(), 0, 0, 0, 0, time.Local)
+	name := nativePartitionName(table, day)
+	cacheKey := table + ":" + name
+	if _, ok := nativePartitionCache.Load(cacheKey); ok {
+		return nil
+	}
+	if err := ensureNativePartitionedTable(table, withError, day); err != nil {
+		return err
+	}
+	exists, err := hasNativePartition(table, name)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		nextDay := day.AddDate(0, 0, 1)
+		var statement string
+		if mesDialect() == common.DatabaseTypeMySQL {
+			// MySQL 的范围分区只能在末尾追加，日期早于已有分区时会失败
+			statement = fmt.Sprintf("ALTER TABLE `%s` ADD PARTITION (PARTITION %s VALUES LESS THAN (TO_DAYS('%s')))",
+				table, name, nextDay.Format("2006-01-02"))
+		} else {
+			statement = fmt.Sprintf(`CREATE TABLE IF NOT EXISTS "%s" PARTITION OF "%s" FOR VALUES FROM ('%s') TO ('%s')`,
+				name, table, day.Format("2006-01-02 15:04:05-07:00"), nextDay.Format("2006-01-02 15:04:05-07:00"))
+		}
+		if err = MES_DB.Exec(statement).Error; err != nil {
+			// 多个节点可能同时创建同一个分区
+			if exists, _ = hasNativePartition(table, name); !exists {
+				return fmt.Errorf("failed to create partition %s of %s: %v", name, table, err)
+			}
+		} else {
+			common.SysLog(fmt.Sprintf("Created native partition %s of %s", name, table))
+		}
+	}
+	nativePartitionCache.Store(cacheKey, true)
+	return nil
+}
@@ -78,6 +78,11 @@ func InitOptionMap() {
 	common.OptionMap["EpayKey"] = ""
 	common.OptionMap["Price"] = strconv.FormatFloat(setting.Price, 'f', -1, 64)
 	common.OptionMap["MinTopUp"] = strconv.Itoa(setting.MinTopUp)
+	common.OptionMap["StripeSecretKey"] = ""
+	common.OptionMap["StripeWebhookSecret"] = ""
+	common.OptionMap["StripeCurrency"] = setting.StripeCurrency
+	common.OptionMap["PaymentWebhookAddress"] = ""
+	common.OptionMap["PaymentWebhookSecret"] = ""
 	common.OptionMap["TopupGroupRatio"] = common.TopupGroupRatio2JSONString()
 	common.OptionMap["Chats"] = setting.Chats2JsonString()
 	common.OptionMap["AutoGroups"] = setting.AutoGroups2JsonString()
@@ -328,6 +333,16 @@ func updateOptionMap(key string, value string) (err error) {
 		setting.Price, _ = strconv.ParseFloat(value, 64)
 	case "MinTopUp":
 		setting.MinTopUp, _ = strconv.Atoi(value)
+	case "StripeSecretKey":
+		setting.StripeSecretKey = value
+	case "StripeWebhookSecret":
+		setting.StripeWebhookSecret = value
+	case "StripeCurrency":
+		setting.StripeCurrency = value
+	case "PaymentWebhookAddress":
+		setting.PaymentWebhookAddress = value
+	case "PaymentWebhookSecret":
+		setting.PaymentWebhookSecret = value
 	case "TopupGroupRatio":
 		err = common.UpdateTopupGroupRatioByJSONString(value)
 	case "GitHubClientId":
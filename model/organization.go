@@ -0,0 +1,427 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"one-api/common"
+	"time"
+
+	"github.com/bytedance/gopkg/util/gopool"
+	"gorm.io/gorm"
+)
+
+// 组织成员角色
+const (
+	OrganizationRoleOwner        = "owner"         // 管理组织、成员和角色，同时拥有财务管理员的权限
+	OrganizationRoleBillingAdmin = "billing_admin" // 为组织钱包转入额度、设置成员消费上限、查看用量和账本
+	OrganizationRoleDeveloper    = "developer"     // 创建组织令牌，使用组织钱包
+)
+
+// Organization 组织，成员通过组织令牌共享同一个额度钱包。
+// 分组、倍率和可用分组仍然按发起请求的成员计算，组织只替代用户余额作为扣费账户。
+type Organization struct {
+	Id          int            `json:"id"`
+	Name        string         `json:"name" gorm:"type:varchar(64);index"`
+	Quota       int            `json:"quota" gorm:"type:int;default:0"`      // 组织钱包余额
+	UsedQuota   int            `json:"used_quota" gorm:"type:int;default:0"` // 组织钱包累计消费
+	CreatedTime int64          `json:"created_time" gorm:"bigint"`
+	DeletedAt   gorm.DeletedAt `json:"-" gorm:"index"`
+}
+
+// OrganizationMember 组织成员，SpendingLimit 限制成员通过组织令牌消费的额度
+type OrganizationMember struct {
+	Id                  int    `json:"id"`
+	OrganizationId      int    `json:"organization_id" gorm:"uniqueIndex:idx_organization_member,priority:1"`
+	UserId              int    `json:"user_id" gorm:"uniqueIndex:idx_organization_member,priority:2;index"`
+	Role                string `json:"role" gorm:"type:varchar(16)"`
+	SpendingPeriod      string `json:"spending_period" gorm:"type:varchar(16);default:''"` // 消费上限的周期，取值同预算周期，为空表示累计
+	SpendingLimit       int    `json:"spending_limit" gorm:"type:int;default:0"`           // 每个周期的消费上限，0 表示不限制
+	SpendingUsed        int    `json:"spending_used" gorm:"type:int;default:0"`            // SpendingPeriodStart 所属周期内的消费
+	SpendingPeriodStart int64  `json:"spending_period_start" gorm:"bigint;default:0"`
+	UsedQuota           int    `json:"used_quota" gorm:"type:int;default:0"` // 累计使用组织钱包的额度
+	CreatedTime         int64  `json:"created_time" gorm:"bigint"`
+	Username            string `json:"username" gorm:"->;-:migration"`
+}
+
+// UserOrganization 用户所在的组织及其角色
+type UserOrganization struct {
+	Organization
+	Role string `json:"role"`
+}
+
+// OrganizationUsage 组织令牌产生的消费，按成员和模型汇总
+type OrganizationUsage struct {
+	UserId           int    `json:"user_id"`
+	Username         string `json:"username"`
+	ModelName        string `json:"model_name"`
+	Quota            int    `json:"quota"`
+	RequestCount     int    `json:"request_count"`
+	PromptTokens     int    `json:"prompt_tokens"`
+	CompletionTokens int    `json:"completion_tokens"`
+}
+
+func IsValidOrganizationRole(role string) bool {
+	switch role {
+	case OrganizationRoleOwner, OrganizationRoleBillingAdmin, OrganizationRoleDeveloper:
+		return true
+	}
+	return false
+}
+
+// CanManageBilling 是否可以管理组织钱包和查看用量
+func (member *OrganizationMember) CanManageBilling() bool {
+	return member.Role == OrganizationRoleOwner || member.Role == OrganizationRoleBillingAdmin
+}
+
+// ValidateSpending 检查消费上限配置是否合法，与预算不同，周期为空表示累计上限
+func (member *OrganizationMember) ValidateSpending() error {
+	if member.SpendingLimit < 0 {
+		return errors.New("消费上限不能为负数")
+	}
+	return QuotaBudget{Period: member.SpendingPeriod}.Validate()
+}
+
+// spendingPeriodStart 当前消费周期的开始时间，累计上限为 0
+func (member *OrganizationMember) spendingPeriodStart(now time.Time) int64 {
+	if member.SpendingPeriod == "" {
+		return 0
+	}
+	return GetBudgetPeriodStart(member.SpendingPeriod, now).Unix()
+}
+
+// RefreshSpending 按当前时间修正本周期的消费，周期已经结束时为 0
+func (member *OrganizationMember) RefreshSpending() {
+	periodStart := member.spendingPeriodStart(time.Now())
+	if member.SpendingPeriodStart != periodStart {
+		member.SpendingUsed = 0
+		member.SpendingPeriodStart = periodStart
+	}
+}
+
+// SpendingRemaining 本周期还可以消费的额度，未设置上限时返回 -1
+func (member *OrganizationMember) SpendingRemaining() int {
+	if member.SpendingLimit <= 0 {
+		return -1
+	}
+	member.RefreshSpending()
+	return max(member.SpendingLimit-member.SpendingUsed, 0)
+}
+
+// CreateOrganization 创建组织，创建者成为所有者
+func CreateOrganization(name string, ownerId int) (*Organization, error) {
+	organization := &Organization{
+		Name:        name,
+		CreatedTime: common.GetTimestamp(),
+	}
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(organization).Error; err != nil {
+			return err
+		}
+		return tx.Create(&OrganizationMember{
+			OrganizationId: organization.Id,
+			UserId:         ownerId,
+			Role:           OrganizationRoleOwner,
+			CreatedTime:    common.GetTimestamp(),
+		}).Error
+	})
+	return organization, err
+}
+
+func GetOrganizationById(id int) (*Organization, error) {
+	if id == 0 {
+		return nil, errors.New("id 为空！")
+	}
+	organization := &Organization{}
+	err := DB.First(organization, "id = ?", id).Error
+	return organization, err
+}
+
+func GetAllOrganizations(startIdx int, num int) (organizations []*Organization, total int64, err error) {
+	err = DB.Model(&Organization{}).Count(&total).Error
+	if err != nil {
+		return nil, 0, err
+	}
+	err = DB.Order("id desc").Limit(num).Offset(startIdx).Find(&organizations).Error
+	return organizations, total, err
+}
+
+func GetUserOrganizations(userId int) ([]*UserOrganization, error) {
+	var organizations []*UserOrganization
+	err := DB.Table("organizations").Select("organizations.*, organization_members.role").
+		Joins("JOIN organization_members ON organization_members.organization_id = organizations.id").
+		Where("organization_members.user_id = ? AND organizations.deleted_at IS NULL", userId).
+		Order("organizations.id").Scan(&organizations).Error
+	return organizations, err
+}
+
+func (organization *Organization) Update() error {
+	return DB.Model(organization).Select("name").Updates(organization).Error
+}
+
+// DeleteOrganization 删除组织及其成员关系，组织令牌随之失效
+func DeleteOrganization(id int) error {
+	return DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("organization_id = ?", id).Delete(&OrganizationMember{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&Organization{}, "id = ?", id).Error
+	})
+}
+
+func GetOrganizationMember(organizationId int, userId int) (*OrganizationMember, error) {
+	member := &OrganizationMember{}
+	err := DB.Where("organization_id = ? AND user_id = ?", organizationId, userId).First(member).Error
+	return member, err
+}
+
+func GetOrganizationMembers(organizationId int) ([]*OrganizationMember, error) {
+	var members []*OrganizationMember
+	err := DB.Table("organization_members").Select("organization_members.*, users.username").
+		Joins("LEFT JOIN users ON users.id = organization_members.user_id").
+		Where("organization_members.organization_id = ?", organizationId).
+		Order("organization_members.id").Scan(&members).Error
+	return members, err
+}
+
+func CountOrganizationOwners(organizationId int) (count int64, err error) {
+	err = DB.Model(&OrganizationMember{}).Where("organization_id = ? AND role = ?", organizationId, OrganizationRoleOwner).Count(&count).Error
+	return count, err
+}
+
+func (member *OrganizationMember) Insert() error {
+	member.CreatedTime = common.GetTimestamp()
+	return DB.Create(member).Error
+}
+
+// Update 更新角色和消费上限，修改周期后本周期的消费从 0 开始统计
+func (member *OrganizationMember) Update() error {
+	return DB.Model(member).Select("role", "spending_period", "spending_limit").Updates(member).Error
+}
+
+func RemoveOrganizationMember(organizationId int, userId int) error {
+	return DB.Where("organization_id = ? AND user_id = ?", organizationId, userId).Delete(&OrganizationMember{}).Error
+}
+
+// GetOrganizationTokens 查询组织令牌，不返回令牌密钥
+func GetOrganizationTokens(organizationId int, startIdx int, num int) (tokens []*Token, total int64, err error) {
+	query := DB.Model(&Token{}).Where("organization_id = ?", organizationId)
+	if err = query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	err = query.Order("id desc").Limit(num).Offset(startIdx).Omit("key").Find(&tokens).Error
+	return tokens, total, err
+}
+
+func DeleteOrganizationToken(organizationId int, tokenId int) error {
+	token := &Token{}
+	err := DB.Where("id = ? AND organization_id = ?", tokenId, organizationId).First(token).Error
+	if err != nil {
+		return err
+	}
+	return token.Delete()
+}
+
+// GetOrganizationUsage 从消费日志汇总组织令牌（包括已删除的令牌）的用量
+func GetOrganizationUsage(organizationId int, startTimestamp int64, endTimestamp int64) ([]*OrganizationUsage, error) {
+	var tokenIds []int
+	err := DB.Unscoped().Model(&Token{}).Where("organization_id = ?", organizationId).Pluck("id", &tokenIds).Error
+	if err != nil || len(tokenIds) == 0 {
+		return []*OrganizationUsage{}, err
+	}
+	query := LOG_DB.Table("logs").
+		Select("user_id, username, model_name, sum(quota) quota, count(*) request_count, sum(prompt_tokens) prompt_tokens, sum(completion_tokens) completion_tokens").
+		Where("type = ? AND token_id IN ?", LogTypeConsume, tokenIds)
+	if startTimestamp != 0 {
+		query = query.Where("created_at >= ?", startTimestamp)
+	}
+	if endTimestamp != 0 {
+		query = query.Where("created_at <= ?", endTimestamp)
+	}
+	var usages []*OrganizationUsage
+	err = query.Group("user_id, username, model_name").Order("quota desc").Scan(&usages).Error
+	return usages, err
+}
+
+func GetOrganizationQuota(id int) (quota int, err error) {
+	err = DB.Model(&Organization{}).Where("id = ?", id).Select("quota").Find(&quota).Error
+	return quota, err
+}
+
+// SetOrganizationQuota 管理员直接设置组织钱包余额，差额记入账本
+func SetOrganizationQuota(id int, quota int, remark string) error {
+	return DB.Transaction(func(tx *gorm.DB) error {
+		before, err := lockQuotaBalance(tx, QuotaAccountOrganization, id)
+		if err != nil {
+			return err
+		}
+		if err = tx.Model(&Organization{}).Where("id = ?", id).Update("quota", quota).Error; err != nil {
+			return err
+		}
+		return recordQuotaAdjustment(tx, QuotaAccountOrganization, id, before, QuotaMovement{
+			Reason: QuotaReasonAdjust,
+			Remark: remark,
+		})
+	})
+}
+
+// TransferUserQuotaToOrganization 将用户余额转入组织钱包，两笔账本记录互为对方科目
+func TransferUserQuotaToOrganization(userId int, organizationId int, quota int) error {
+	if quota <= 0 {
+		return errors.New("转入额度必须大于 0")
+	}
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&User{}).Where("id = ? AND quota >= ?", userId, quota).Update("quota", gorm.Expr("quota - ?", quota))
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return errors.New("用户余额不足")
+		}
+		userLedger := QuotaMovement{
+			Reason:         QuotaReasonTransfer,
+			CounterAccount: fmt.Sprintf("%s:%d", QuotaAccountOrganization, organizationId),
+			Remark:         "转入组织钱包",
+		}.newLedger(QuotaAccountUser, userId, -quota)
+		if err := recordQuotaLedger(tx, QuotaAccountUser, userId, -quota, []*QuotaLedger{userLedger}); err != nil {
+			return err
+		}
+		if err := tx.Model(&Organization{}).Where("id = ?", organizationId).Update("quota", gorm.Expr("quota + ?", quota)).Error; err != nil {
+			return err
+		}
+		organizationLedger := QuotaMovement{
+			Reason:         QuotaReasonTransfer,
+			UserId:         userId,
+			CounterAccount: fmt.Sprintf("%s:%d", QuotaAccountUser, userId),
+			Remark:         "成员转入",
+		}.newLedger(QuotaAccountOrganization, organizationId, quota)
+		return recordQuotaLedger(tx, QuotaAccountOrganization, organizationId, quota, []*QuotaLedger{organizationLedger})
+	})
+	if err != nil {
+		return err
+	}
+	gopool.Go(func() {
+		if err := cacheDecrUserQuota(userId, int64(quota)); err != nil {
+			common.SysError("failed to decrease user quota: " + err.Error())
+		}
+	})
+	return nil
+}
+
+// chargeOrganizationQuota 从组织钱包扣除成员的消费，quota 为负数时退还。
+// 组织钱包不走批量更新，余额、成员消费和账本在同一事务中写入。
+// 受预算上限约束的扣费通过带条件的更新检查钱包余额和成员的消费上限，并发请求不会同时通过检查，
+// 余额不足或超出上限时整笔扣费回滚并返回 ErrQuotaBudgetExceeded。
+func chargeOrganizationQuota(organizationId int, userId int, quota int, movement QuotaMovement) error {
+	if movement.UserId == 0 {
+		movement.UserId = userId
+	}
+	ledger := movement.newLedger(QuotaAccountOrganization, organizationId, -quota)
+	usage := budgetUsage([]*QuotaLedger{ledger})
+	enforce := quota > 0 && movement.EnforceBudget
+	return DB.Transaction(func(tx *gorm.DB) error {
+		// 组织删除后异步任务仍可能退款，余额变动同样需要记录
+		query := tx.Unscoped().Model(&Organization{}).Where("id = ?", organizationId)
+		if enforce {
+			query = query.Where("quota >= ?", quota)
+		}
+		result := query.UpdateColumns(map[string]interface{}{
+			"quota":      gorm.Expr("quota - ?", quota),
+			"used_quota": gorm.Expr("used_quota + ?", usage),
+		})
+		if result.Error != nil {
+			return result.Error
+		}
+		if enforce && result.RowsAffected == 0 {
+			return ErrQuotaBudgetExceeded
+		}
+		if err := recordQuotaLedger(tx, QuotaAccountOrganization, organizationId, -quota, []*QuotaLedger{ledger}); err != nil {
+			return err
+		}
+		return addOrganizationMemberSpending(tx, organizationId, userId, usage, enforce)
+	})
+}
+
+// addOrganizationMemberSpending 累加成员的消费，进入新周期时从 0 开始。成员已被移除时不统计。
+// enforce 为 true 时检查成员的消费上限，超出时返回 ErrQuotaBudgetExceeded。
+func addOrganizationMemberSpending(tx *gorm.DB, organizationId int, userId int, usage int, enforce bool) error {
+	if usage == 0 {
+		return nil
+	}
+	member := &OrganizationMember{}
+	err := tx.Where("organization_id = ? AND user_id = ?", organizationId, userId).Limit(1).Find(member).Error
+	if err != nil || member.Id == 0 {
+		return err
+	}
+	enforce = enforce && usage > 0
+	query := tx.Model(&OrganizationMember{}).Where("id = ?", member.Id)
+	updates := map[string]interface{}{
+		"used_quota": gorm.Expr("used_quota + ?", usage),
+	}
+	periodStart := member.spendingPeriodStart(time.Now())
+	if member.SpendingPeriodStart == periodStart {
+		if enforce {
+			query = query.Where("(spending_limit <= 0 OR spending_used + ? <= spending_limit)", usage)
+		}
+		updates["spending_used"] = gorm.Expr("spending_used + ?", usage)
+	} else {
+		if enforce {
+			query = query.Where("(spending_limit <= 0 OR spending_limit >= ?)", usage)
+		}
+		// 上一周期预扣、本周期退还的额度不计为负数
+		updates["spending_used"] = max(usage, 0)
+		updates["spending_period_start"] = periodStart
+	}
+	result := query.UpdateColumns(updates)
+	if result.Error != nil {
+		return result.Error
+	}
+	if enforce && result.RowsAffected == 0 {
+		return ErrQuotaBudgetExceeded
+	}
+	return nil
+}
+
+// GetWalletQuota 返回请求可用的额度。organizationId 为 0 时是用户余额；
+// 否则是组织钱包余额，成员设置了消费上限时取两者中较小的值。
+func GetWalletQuota(userId int, organizationId int) (int, error) {
+	if organizationId == 0 {
+		return GetUserQuota(userId, false)
+	}
+	member, err := GetOrganizationMember(organizationId, userId)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return 0, errors.New("the organization of this token does not exist or the user is no longer a member")
+		}
+		return 0, err
+	}
+	quota, err := GetOrganizationQuota(organizationId)
+	if err != nil {
+		return 0, err
+	}
+	if remaining := member.SpendingRemaining(); remaining >= 0 {
+		quota = min(quota, remaining)
+	}
+	return quota, nil
+}
+
+// DecreaseWalletQuota 从用户余额或组织钱包扣除额度
+func DecreaseWalletQuota(userId int, organizationId int, quota int, movement QuotaMovement) error {
+	if organizationId == 0 {
+		return DecreaseUserQuota(userId, quota, movement)
+	}
+	if quota < 0 {
+		return errors.New("quota 不能为负数！")
+	}
+	return chargeOrganizationQuota(organizationId, userId, quota, movement)
+}
+
+// IncreaseWalletQuota 向用户余额或组织钱包退还额度
+func IncreaseWalletQuota(userId int, organizationId int, quota int, movement QuotaMovement) error {
+	if organizationId == 0 {
+		return IncreaseUserQuota(userId, quota, false, movement)
+	}
+	if quota < 0 {
+		return errors.New("quota 不能为负数！")
+	}
+	return chargeOrganizationQuota(organizationId, userId, -quota, movement)
+}
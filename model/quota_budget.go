@@ -0,0 +1,209 @@
+package model
+
+import (
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// ErrQuotaBudgetExceeded 受预算上限约束的扣费使本周期用量超过上限
+var ErrQuotaBudgetExceeded = errors.New("quota budget exceeded")
+
+// 周期预算的周期，为空表示不启用周期预算
+const (
+	BudgetPeriodDaily   = "daily"
+	BudgetPeriodWeekly  = "weekly"
+	BudgetPeriodMonthly = "monthly"
+)
+
+// QuotaBudget 令牌或用户的周期预算配置
+type QuotaBudget struct {
+	Period         string `json:"budget_period"`
+	Limit          int    `json:"budget_limit"`           // 每个周期的额度上限，达到后拒绝请求，0 表示不限制
+	AlertThreshold int    `json:"budget_alert_threshold"` // 本周期用量达到该额度时通知用户，每个周期只通知一次，0 表示不提醒
+}
+
+// Enabled 是否需要统计周期用量
+func (budget QuotaBudget) Enabled() bool {
+	return budget.Period != "" && (budget.Limit > 0 || budget.AlertThreshold > 0)
+}
+
+// Limited 是否设置了周期额度上限
+func (budget QuotaBudget) Limited() bool {
+	return budget.Period != "" && budget.Limit > 0
+}
+
+// Validate 检查预算配置是否合法
+func (budget QuotaBudget) Validate() error {
+	switch budget.Period {
+	case "", BudgetPeriodDaily, BudgetPeriodWeekly, BudgetPeriodMonthly:
+	default:
+		return errors.New("无效的预算周期")
+	}
+	if budget.Limit < 0 || budget.AlertThreshold < 0 {
+		return errors.New("预算额度不能为负数")
+	}
+	if budget.Period == "" && (budget.Limit > 0 || budget.AlertThreshold > 0) {
+		return errors.New("设置预算额度时必须指定预算周期")
+	}
+	return nil
+}
+
+// GetBudgetPeriodStart 返回 t 所在周期的开始时间（服务器本地时间），周为周一开始
+func GetBudgetPeriodStart(period string, t time.Time) time.Time {
+	year, month, day := t.Date()
+	switch period {
+	case BudgetPeriodWeekly:
+		weekday := (int(t.Weekday()) + 6) % 7
+		return time.Date(year, month, day-weekday, 0, 0, 0, 0, t.Location())
+	case BudgetPeriodMonthly:
+		return time.Date(year, month, 1, 0, 0, 0, 0, t.Location())
+	}
+	return time.Date(year, month, day, 0, 0, 0, 0, t.Location())
+}
+
+// GetBudgetPeriodEnd 返回 t 所在周期的结束时间，即下一周期的开始时间
+func GetBudgetPeriodEnd(period string, t time.Time) time.Time {
+	start := GetBudgetPeriodStart(period, t)
+	switch period {
+	case BudgetPeriodWeekly:
+		return start.AddDate(0, 0, 7)
+	case BudgetPeriodMonthly:
+		return start.AddDate(0, 1, 0)
+	}
+	return start.AddDate(0, 0, 1)
+}
+
+// QuotaBudgetStatus 账户当前周期的预算用量
+type QuotaBudgetStatus struct {
+	QuotaBudget
+	Used        int   `json:"budget_used"`
+	PeriodStart int64 `json:"budget_period_start"`
+	ResetTime   int64 `json:"budget_reset_time"` // 下次重置的时间
+}
+
+// Remaining 本周期剩余的额度，未设置上限时返回 -1
+func (status *QuotaBudgetStatus) Remaining() int {
+	if status.Limit <= 0 {
+		return -1
+	}
+	return max(status.Limit-status.Used, 0)
+}
+
+// quotaBudgetState 余额表中的预算字段
+type quotaBudgetState struct {
+	BudgetPeriod         string
+	BudgetLimit          int
+	BudgetAlertThreshold int
+	BudgetUsed           int
+	BudgetPeriodStart    int64
+}
+
+// status 计算当前周期的用量，周期已经结束时用量为 0
+func (state *quotaBudgetState) status(now time.Time) *QuotaBudgetStatus {
+	status := &QuotaBudgetStatus{
+		QuotaBudget: QuotaBudget{
+			Period:         state.BudgetPeriod,
+			Limit:          state.BudgetLimit,
+			AlertThreshold: state.BudgetAlertThreshold,
+		},
+	}
+	if state.BudgetPeriod == "" {
+		return status
+	}
+	status.PeriodStart = GetBudgetPeriodStart(state.BudgetPeriod, now).Unix()
+	status.ResetTime = GetBudgetPeriodEnd(state.BudgetPeriod, now).Unix()
+	if state.BudgetPeriodStart == status.PeriodStart {
+		status.Used = state.BudgetUsed
+	}
+	return status
+}
+
+func getQuotaBudgetState(tx *gorm.DB, accountType string, accountId int) (*quotaBudgetState, error) {
+	model, _ := quotaBalanceColumn(accountType)
+	state := &quotaBudgetState{}
+	err := tx.Model(model).Where("id = ?", accountId).
+		Select("budget_period, budget_limit, budget_alert_threshold, budget_used, budget_period_start").
+		Scan(state).Error
+	return state, err
+}
+
+// GetQuotaBudgetStatus 从数据库读取令牌或用户当前周期的预算用量，开启批量更新时不包括尚未写入的用量
+func GetQuotaBudgetStatus(accountType string, accountId int) (*QuotaBudgetStatus, error) {
+	state, err := getQuotaBudgetState(DB, accountType, accountId)
+	if err != nil {
+		return nil, err
+	}
+	return state.status(time.Now()), nil
+}
+
+// budgetUsage 账本记录中计入预算的用量：消费和退款，充值等其他变动不计入
+func budgetUsage(ledgers []*QuotaLedger) int {
+	usage := 0
+	for _, ledger := range ledgers {
+		if ledger.Reason == QuotaReasonConsume || ledger.Reason == QuotaReasonRefund {
+			usage -= ledger.Delta
+		}
+	}
+	return usage
+}
+
+// budgetEnforced 账本记录中是否有受预算上限约束的扣费
+func budgetEnforced(ledgers []*QuotaLedger) bool {
+	for _, ledger := range ledgers {
+		if ledger.enforceBudget {
+			return true
+		}
+	}
+	return false
+}
+
+// addQuotaBudgetUsage 在修改余额的同一事务中累加本周期用量，进入新周期时从 0 开始。未启用周期预算的账户不统计。
+// 受预算上限约束的扣费通过带条件的更新检查上限，并发请求不会同时通过检查；超出上限时返回 ErrQuotaBudgetExceeded，
+// 调用方的事务随之回滚，余额不会被扣除。
+func addQuotaBudgetUsage(tx *gorm.DB, accountType string, accountId int, ledgers []*QuotaLedger) error {
+	usage := budgetUsage(ledgers)
+	if usage == 0 {
+		return nil
+	}
+	state, err := getQuotaBudgetState(tx, accountType, accountId)
+	if err != nil || state.BudgetPeriod == "" {
+		return err
+	}
+	enforce := usage > 0 && budgetEnforced(ledgers)
+	model, _ := quotaBalanceColumn(accountType)
+	query := tx.Model(model).Where("id = ?", accountId)
+	periodStart := GetBudgetPeriodStart(state.BudgetPeriod, time.Now()).Unix()
+	var result *gorm.DB
+	if state.BudgetPeriodStart == periodStart {
+		if enforce {
+			query = query.Where("(budget_limit <= 0 OR budget_used + ? <= budget_limit)", usage)
+		}
+		result = query.UpdateColumn("budget_used", gorm.Expr("budget_used + ?", usage))
+	} else {
+		if enforce {
+			query = query.Where("(budget_limit <= 0 OR budget_limit >= ?)", usage)
+		}
+		// 上一周期预扣、本周期退还的额度不计为负数
+		result = query.UpdateColumns(map[string]interface{}{
+			"budget_used":         max(usage, 0),
+			"budget_period_start": periodStart,
+		})
+	}
+	if result.Error != nil {
+		return result.Error
+	}
+	if enforce && result.RowsAffected == 0 {
+		return ErrQuotaBudgetExceeded
+	}
+	return nil
+}
+
+// MarkQuotaBudgetAlerted 标记本周期已发送预算提醒，返回 false 表示本周期已经提醒过（可能由其他节点发送）
+func MarkQuotaBudgetAlerted(accountType string, accountId int, periodStart int64) (bool, error) {
+	model, _ := quotaBalanceColumn(accountType)
+	result := DB.Model(model).Where("id = ? AND budget_alerted_start <> ?", accountId, periodStart).
+		UpdateColumn("budget_alerted_start", periodStart)
+	return result.RowsAffected > 0, result.Error
+}
@@ -0,0 +1,329 @@
+package model
+
+import (
+	"fmt"
+	"one-api/common"
+
+	"gorm.io/gorm"
+)
+
+// 账本中的余额账户类型
+const (
+	QuotaAccountUser         = "user"         // 用户余额 users.quota
+	QuotaAccountToken        = "token"        // 令牌余额 tokens.remain_quota
+	QuotaAccountOrganization = "organization" // 组织钱包 organizations.quota
+)
+
+// 额度变动原因，未指定对方科目时对方科目为 system:<原因>
+const (
+	QuotaReasonConsume      = "consume"      // 请求预扣与结算
+	QuotaReasonRefund       = "refund"       // 退还预扣额度、异步任务失败退款
+	QuotaReasonTopUp        = "topup"        // 在线充值
+	QuotaReasonTopUpRefund  = "topup_refund" // 在线充值退款，收回充值的额度
+	QuotaReasonRedemption   = "redemption"   // 兑换码
+	QuotaReasonAffiliate    = "affiliate"    // 邀请奖励
+	QuotaReasonAdjust       = "adjust"       // 管理员或用户直接修改余额
+	QuotaReasonTransfer     = "transfer"     // 用户余额转入组织钱包
+	QuotaReasonSubscription = "subscription" // 订阅包含额度的发放与收回、自动续订扣费
+)
+
+// QuotaLedger 额度账本，只追加不修改。每条记录是一笔完整的复式分录：
+// Account 一方增加 Delta，CounterAccount 一方减少 Delta，两者之和为零。
+// BalanceBefore / BalanceAfter 是 Account 在同一事务中变动前后的余额。
+type QuotaLedger struct {
+	Id             int    `json:"id"`
+	AccountType    string `json:"account_type" gorm:"type:varchar(16);index:idx_quota_ledger_account,priority:1"`
+	AccountId      int    `json:"account_id" gorm:"index:idx_quota_ledger_account,priority:2"`
+	CounterAccount string `json:"counter_account" gorm:"type:varchar(64)"`
+	UserId         int    `json:"user_id" gorm:"index"`
+	TokenId        int    `json:"token_id" gorm:"index"`
+	Delta          int    `json:"delta"`
+	BalanceBefore  int    `json:"balance_before"`
+	BalanceAfter   int    `json:"balance_after"`
+	Reason         string `json:"reason" gorm:"type:varchar(32);index"`
+	RequestId      string `json:"request_id" gorm:"type:varchar(64);index"`
+	Remark         string `json:"remark" gorm:"type:varchar(255)"`
+	CreatedAt      int64  `json:"created_at" gorm:"bigint;index"`
+
+	enforceBudget bool // 不写入数据库，见 QuotaMovement.EnforceBudget
+}
+
+// QuotaMovement 额度变动的来源，修改余额的函数据此写入账本
+type QuotaMovement struct {
+	Reason         string
+	RequestId      string
+	UserId         int    // 令牌余额变动时为令牌所属用户
+	TokenId        int    // 用户余额变动时为关联的令牌
+	CounterAccount string // 为空时为 system:<Reason>
+	Remark         string
+	// EnforceBudget 扣费受周期预算上限约束：在扣费的同一事务中检查上限，超出时整笔扣费失败并返回 ErrQuotaBudgetExceeded。
+	// 这类扣费不走批量更新。
+	EnforceBudget bool
+}
+
+func (movement QuotaMovement) newLedger(accountType string, accountId int, delta int) *QuotaLedger {
+	ledger := &QuotaLedger{
+		AccountType:    accountType,
+		AccountId:      accountId,
+		CounterAccount: movement.CounterAccount,
+		UserId:         movement.UserId,
+		TokenId:        movement.TokenId,
+		Delta:          delta,
+		Reason:         movement.Reason,
+		RequestId:      movement.RequestId,
+		Remark:         movement.Remark,
+		CreatedAt:      common.GetTimestamp(),
+		enforceBudget:  movement.EnforceBudget,
+	}
+	if ledger.CounterAccount == "" {
+		ledger.CounterAccount = "system:" + movement.Reason
+	}
+	switch accountType {
+	case QuotaAccountUser:
+		ledger.UserId = accountId
+	case QuotaAccountToken:
+		ledger.TokenId = accountId
+	}
+	return ledger
+}
+
+// quotaBalanceColumn 账户类型对应的余额表和字段
+func quotaBalanceColumn(accountType string) (interface{}, string) {
+	switch accountType {
+	case QuotaAccountToken:
+		return &Token{}, "remain_quota"
+	case QuotaAccountOrganization:
+		return &Organization{}, "quota"
+	}
+	return &User{}, "quota"
+}
+
+// getQuotaBalance 读取账户余额，包括已删除的用户和令牌
+func getQuotaBalance(tx *gorm.DB, accountType string, accountId int) (int, error) {
+	model, column := quotaBalanceColumn(accountType)
+	var balance int
+	err := tx.Unscoped().Model(model).Where("id = ?", accountId).Select(column).Scan(&balance).Error
+	return balance, err
+}
+
+// lockQuotaBalance 在事务中锁定账户行并返回当前余额，用于余额被直接覆盖的场景。
+// 先执行一次不改变数据的更新来获取行锁，SQLite 不支持 FOR UPDATE，这种写法在各数据库上都可用。
+func lockQuotaBalance(tx *gorm.DB, accountType string, accountId int) (int, error) {
+	model, column := quotaBalanceColumn(accountType)
+	err := tx.Unscoped().Model(model).Where("id = ?", accountId).UpdateColumn(column, gorm.Expr(column)).Error
+	if err != nil {
+		return 0, err
+	}
+	return getQuotaBalance(tx, accountType, accountId)
+}
+
+// recordQuotaLedger 在修改余额的同一事务中写入账本。delta 为本次对余额的总修改，
+// entries 为其中的每笔变动（批量更新时会有多笔），按顺序根据变动后的余额推算每笔的前后余额。
+func recordQuotaLedger(tx *gorm.DB, accountType string, accountId int, delta int, entries []*QuotaLedger) error {
+	if len(entries) == 0 {
+		return nil
+	}
+	after, err := getQuotaBalance(tx, accountType, accountId)
+	if err != nil {
+		return err
+	}
+	balance := after - delta
+	for _, entry := range entries {
+		entry.BalanceBefore = balance
+		balance += entry.Delta
+		entry.BalanceAfter = balance
+	}
+	if balance != after {
+		common.SysError(fmt.Sprintf("quota ledger of %s %d does not add up: %d != %d", accountType, accountId, balance, after))
+	}
+	return tx.CreateInBatches(entries, 100).Error
+}
+
+// recordQuotaAdjustment 余额被直接设置为新值时记录差额，需要在 lockQuotaBalance 之后、同一事务中调用
+func recordQuotaAdjustment(tx *gorm.DB, accountType string, accountId int, before int, movement QuotaMovement) error {
+	after, err := getQuotaBalance(tx, accountType, accountId)
+	if err != nil || after == before {
+		return err
+	}
+	entry := movement.newLedger(accountType, accountId, after-before)
+	entry.BalanceBefore = before
+	entry.BalanceAfter = after
+	return tx.Create(entry).Error
+}
+
+// QuotaLedgerFilter 账本查询条件，零值表示不限制
+type QuotaLedgerFilter struct {
+	UserId         int
+	TokenId        int
+	AccountType    string
+	AccountId      int // 需要与 AccountType 一起使用
+	Reason         string
+	RequestId      string
+	StartTimestamp int64
+	EndTimestamp   int64
+}
+
+// GetQuotaLedgers 按条件分页查询账本，按时间倒序
+func GetQuotaLedgers(filter QuotaLedgerFilter, startIdx int, num int) ([]*QuotaLedger, int64, error) {
+	query := DB.Model(&QuotaLedger{})
+	if filter.UserId != 0 {
+		query = query.Where("user_id = ?", filter.UserId)
+	}
+	if filter.TokenId != 0 {
+		query = query.Where("token_id = ?", filter.TokenId)
+	}
+	if filter.AccountType != "" {
+		query = query.Where("account_type = ?", filter.AccountType)
+	}
+	if filter.AccountId != 0 {
+		query = query.Where("account_id = ?", filter.AccountId)
+	}
+	if filter.Reason != "" {
+		query = query.Where("reason = ?", filter.Reason)
+	}
+	if filter.RequestId != "" {
+		query = query.Where("request_id = ?", filter.RequestId)
+	}
+	if filter.StartTimestamp != 0 {
+		query = query.Where("created_at >= ?", filter.StartTimestamp)
+	}
+	if filter.EndTimestamp != 0 {
+		query = query.Where("created_at <= ?", filter.EndTimestamp)
+	}
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	var ledgers []*QuotaLedger
+	err := query.Order("id desc").Limit(num).Offset(startIdx).Find(&ledgers).Error
+	return ledgers, total, err
+}
+
+// QuotaLedgerDrift 对账发现的不一致。账户第一笔记录的 BalanceBefore 视为期初余额，
+// 账本余额 = 期初余额 + 所有变动之和，应与最后一笔记录的 BalanceAfter 和当前实际余额一致。
+type QuotaLedgerDrift struct {
+	AccountType      string `json:"account_type"`
+	AccountId        int    `json:"account_id"`
+	OpeningBalance   int    `json:"opening_balance"`
+	LedgerBalance    int    `json:"ledger_balance"`
+	LastBalanceAfter int    `json:"last_balance_after"`
+	ActualBalance    int    `json:"actual_balance"`
+	Drift            int    `json:"drift"` // 实际余额 - 账本余额
+}
+
+type quotaLedgerSummary struct {
+	AccountType   string
+	AccountId     int
+	TotalDelta    int
+	BalanceBefore int
+	BalanceAfter  int
+}
+
+func (summary *quotaLedgerSummary) drift(actual int) *QuotaLedgerDrift {
+	ledgerBalance := summary.BalanceBefore + summary.TotalDelta
+	if actual == ledgerBalance && summary.BalanceAfter == ledgerBalance {
+		return nil
+	}
+	return &QuotaLedgerDrift{
+		AccountType:      summary.AccountType,
+		AccountId:        summary.AccountId,
+		OpeningBalance:   summary.BalanceBefore,
+		LedgerBalance:    ledgerBalance,
+		LastBalanceAfter: summary.BalanceAfter,
+		ActualBalance:    actual,
+		Drift:            actual - ledgerBalance,
+	}
+}
+
+// quotaLedgerSummaryQuery 按账户汇总账本：变动之和、第一笔的变动前余额、最后一笔的变动后余额。
+// accountType 为空时汇总所有账户，否则只汇总指定账户
+func quotaLedgerSummaryQuery(tx *gorm.DB, accountType string, accountId int) *gorm.DB {
+	totals := tx.Model(&QuotaLedger{}).
+		Select("account_type, account_id, SUM(delta) AS total_delta, MIN(id) AS first_id, MAX(id) AS last_id").
+		Group("account_type, account_id")
+	if accountType != "" {
+		totals = totals.Where("account_type = ? AND account_id = ?", accountType, accountId)
+	}
+	return tx.Table("(?) AS t", totals).
+		Select("t.account_type, t.account_id, t.total_delta, f.balance_before, l.balance_after").
+		Joins("JOIN quota_ledgers f ON f.id = t.first_id").
+		Joins("JOIN quota_ledgers l ON l.id = t.last_id")
+}
+
+// ReconcileQuotaLedger 对比账本与 User.Quota / Token.RemainQuota，返回检查的账户数和不一致的账户。
+// 对账期间仍有额度变动，初步发现不一致的账户会在锁定余额后重新核对一次，排除并发造成的误报。
+// 开启批量更新时，尚未写入数据库的变动同样不在账本中，不影响对账。
+func ReconcileQuotaLedger() (int, []*QuotaLedgerDrift, error) {
+	var summaries []*quotaLedgerSummary
+	if err := quotaLedgerSummaryQuery(DB, "", 0).Scan(&summaries).Error; err != nil {
+		return 0, nil, err
+	}
+	var candidates []*quotaLedgerSummary
+	for _, accountType := range []string{QuotaAccountUser, QuotaAccountToken, QuotaAccountOrganization} {
+		summaryMap := make(map[int]*quotaLedgerSummary)
+		var ids []int
+		for _, summary := range summaries {
+			if summary.AccountType == accountType {
+				summaryMap[summary.AccountId] = summary
+				ids = append(ids, summary.AccountId)
+			}
+		}
+		model, column := quotaBalanceColumn(accountType)
+		for start := 0; start < len(ids); start += 1000 {
+			end := min(start+1000, len(ids))
+			var balances []struct {
+				Id      int
+				Balance int
+			}
+			err := DB.Unscoped().Model(model).Select("id, "+column+" AS balance").
+				Where("id IN ?", ids[start:end]).Scan(&balances).Error
+			if err != nil {
+				return 0, nil, err
+			}
+			found := make(map[int]bool, len(balances))
+			for _, balance := range balances {
+				found[balance.Id] = true
+				if summaryMap[balance.Id].drift(balance.Balance) != nil {
+					candidates = append(candidates, summaryMap[balance.Id])
+				}
+			}
+			// 账户已被物理删除
+			for _, id := range ids[start:end] {
+				if !found[id] {
+					candidates = append(candidates, summaryMap[id])
+				}
+			}
+		}
+	}
+
+	var drifts []*QuotaLedgerDrift
+	for _, candidate := range candidates {
+		drift, err := reconcileQuotaAccount(candidate.AccountType, candidate.AccountId)
+		if err != nil {
+			return 0, nil, err
+		}
+		if drift != nil {
+			drifts = append(drifts, drift)
+		}
+	}
+	return len(summaries), drifts, nil
+}
+
+// reconcileQuotaAccount 锁定账户余额后重新核对单个账户
+func reconcileQuotaAccount(accountType string, accountId int) (*QuotaLedgerDrift, error) {
+	var drift *QuotaLedgerDrift
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		actual, err := lockQuotaBalance(tx, accountType, accountId)
+		if err != nil {
+			return err
+		}
+		var summary quotaLedgerSummary
+		err = quotaLedgerSummaryQuery(tx, accountType, accountId).Scan(&summary).Error
+		if err != nil {
+			return err
+		}
+		drift = summary.drift(actual)
+		return nil
+	})
+	return drift, err
+}
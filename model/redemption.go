@@ -139,6 +139,14 @@ func Redeem(key string, userId int) (quota int, err error) {
 		if err != nil {
 			return err
 		}
+		ledger := QuotaMovement{
+			Reason:    QuotaReasonRedemption,
+			RequestId: fmt.Sprintf("redemption:%d", redemption.Id),
+		}.newLedger(QuotaAccountUser, userId, redemption.Quota)
+		err = recordQuotaLedger(tx, QuotaAccountUser, userId, redemption.Quota, []*QuotaLedger{ledger})
+		if err != nil {
+			return err
+		}
 		redemption.RedeemedTime = common.GetTimestamp()
 		redemption.Status = common.RedemptionCodeStatusUsed
 		redemption.UsedUserId = userId
@@ -0,0 +1,39 @@
+package model
+
+import (
+	"one-api/common"
+)
+
+// ResponseCache 未启用 Redis 时用于保存文本补全响应缓存
+type ResponseCache struct {
+	CacheKey    string `json:"cache_key" gorm:"type:varchar(64);primaryKey"`
+	Value       string `json:"value" gorm:"type:text"`
+	CreatedTime int64  `json:"created_time" gorm:"bigint"`
+	ExpiredTime int64  `json:"expired_time" gorm:"bigint;index"`
+}
+
+// GetResponseCache 获取未过期的缓存内容，不存在时返回空字符串
+func GetResponseCache(key string) (string, error) {
+	var caches []ResponseCache
+	err := DB.Where("cache_key = ? and expired_time > ?", key, common.GetTimestamp()).Limit(1).Find(&caches).Error
+	if err != nil || len(caches) == 0 {
+		return "", err
+	}
+	return caches[0].Value, nil
+}
+
+func SaveResponseCache(key string, value string, ttlSeconds int) error {
+	now := common.GetTimestamp()
+	return DB.Save(&ResponseCache{
+		CacheKey:    key,
+		Value:       value,
+		CreatedTime: now,
+		ExpiredTime: now + int64(ttlSeconds),
+	}).Error
+}
+
+// DeleteExpiredResponseCaches 清理已过期的缓存
+func DeleteExpiredResponseCaches() (int64, error) {
+	result := DB.Where("expired_time <= ?", common.GetTimestamp()).Delete(&ResponseCache{})
+	return result.RowsAffected, result.Error
+}
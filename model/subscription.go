@@ -0,0 +1,416 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"one-api/common"
+	"one-api/setting"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	SubscriptionPlanStatusEnabled  = 1
+	SubscriptionPlanStatusDisabled = 2
+)
+
+const (
+	SubscriptionStatusActive   = "active"
+	SubscriptionStatusExpired  = "expired"
+	SubscriptionStatusReplaced = "replaced" // 订阅期间购买了新套餐
+)
+
+var ErrSubscriptionInsufficientQuota = errors.New("余额不足，无法续订")
+
+// ErrSubscriptionNotActive 续订或结束订阅时订阅已被替换、结束或续订，不做任何修改
+var ErrSubscriptionNotActive = errors.New("订阅已不再生效")
+
+// SubscriptionPlan 订阅套餐。每个周期开始时将包含额度计入用户余额，周期结束时收回未用完的部分；
+// 本周期消费超过包含额度后，按超额倍率（叠加在分组倍率上）从用户余额扣费。
+type SubscriptionPlan struct {
+	Id            int            `json:"id"`
+	Name          string         `json:"name" gorm:"type:varchar(64)"`
+	Description   string         `json:"description" gorm:"type:varchar(255)"`
+	Price         float64        `json:"price"`                                    // 每个周期的价格，与在线充值使用相同的货币
+	DurationDays  int            `json:"duration_days"`                            // 周期天数
+	IncludedQuota int            `json:"included_quota"`                           // 每个周期包含的额度
+	Group         string         `json:"group" gorm:"type:varchar(64);default:''"` // 订阅期间用户所在的分组，为空表示不修改
+	OverageRatio  float64        `json:"overage_ratio" gorm:"default:1"`           // 包含额度用完后的计费倍率
+	Status        int            `json:"status" gorm:"default:1"`
+	CreatedTime   int64          `json:"created_time" gorm:"bigint"`
+	DeletedAt     gorm.DeletedAt `json:"-" gorm:"index"`
+}
+
+// Subscription 用户的订阅记录，创建时复制套餐的配置，管理员修改套餐不影响当前周期
+type Subscription struct {
+	Id            int     `json:"id"`
+	UserId        int     `json:"user_id" gorm:"index"`
+	PlanId        int     `json:"plan_id"`
+	PlanName      string  `json:"plan_name" gorm:"type:varchar(64)"`
+	Status        string  `json:"status" gorm:"type:varchar(16);index"`
+	Group         string  `json:"group" gorm:"type:varchar(64);default:''"`
+	PreviousGroup string  `json:"previous_group" gorm:"type:varchar(64);default:''"` // 订阅前的分组，订阅结束后恢复
+	IncludedQuota int     `json:"included_quota"`
+	UsedQuota     int     `json:"used_quota" gorm:"default:0"` // 本周期的消费
+	OverageRatio  float64 `json:"overage_ratio" gorm:"default:1"`
+	StartTime     int64   `json:"start_time" gorm:"bigint"`
+	EndTime       int64   `json:"end_time" gorm:"bigint;index"`
+	AutoRenew     bool    `json:"auto_renew"`                    // 到期时从用户余额扣费续订
+	NextPlanId    int     `json:"next_plan_id" gorm:"default:0"` // 续订时切换到的套餐，0 表示续订当前套餐
+	TradeNo       string  `json:"trade_no" gorm:"type:varchar(64);default:''"`
+	CreatedTime   int64   `json:"created_time" gorm:"bigint"`
+}
+
+// Validate 检查套餐配置是否合法
+func (plan *SubscriptionPlan) Validate() error {
+	if plan.Name == "" {
+		return errors.New("套餐名称不能为空")
+	}
+	if plan.Price < 0 || plan.IncludedQuota < 0 {
+		return errors.New("价格和包含额度不能为负数")
+	}
+	if plan.DurationDays <= 0 {
+		return errors.New("周期天数必须大于 0")
+	}
+	if plan.OverageRatio < 0 {
+		return errors.New("超额倍率不能为负数")
+	}
+	return nil
+}
+
+// PriceQuota 套餐价格按充值价格换算成的额度，用于从余额扣费续订
+func (plan *SubscriptionPlan) PriceQuota() int {
+	if setting.Price <= 0 {
+		return 0
+	}
+	return int(plan.Price / setting.Price * common.QuotaPerUnit)
+}
+
+func (plan *SubscriptionPlan) periodEnd(start time.Time) int64 {
+	return start.AddDate(0, 0, plan.DurationDays).Unix()
+}
+
+func (plan *SubscriptionPlan) Insert() error {
+	plan.CreatedTime = common.GetTimestamp()
+	return DB.Create(plan).Error
+}
+
+func (plan *SubscriptionPlan) Update() error {
+	return DB.Model(plan).Select("name", "description", "price", "duration_days", "included_quota", "group", "overage_ratio", "status").Updates(plan).Error
+}
+
+func DeleteSubscriptionPlanById(id int) error {
+	return DB.Delete(&SubscriptionPlan{}, "id = ?", id).Error
+}
+
+func GetSubscriptionPlanById(id int) (*SubscriptionPlan, error) {
+	plan := &SubscriptionPlan{}
+	err := DB.First(plan, "id = ?", id).Error
+	return plan, err
+}
+
+// GetSubscriptionPlans 查询套餐，enabledOnly 为 true 时只返回可以订阅的套餐
+func GetSubscriptionPlans(enabledOnly bool) ([]*SubscriptionPlan, error) {
+	var plans []*SubscriptionPlan
+	query := DB.Order("price asc, id asc")
+	if enabledOnly {
+		query = query.Where("status = ?", SubscriptionPlanStatusEnabled)
+	}
+	err := query.Find(&plans).Error
+	return plans, err
+}
+
+// GetActiveSubscription 用户当前生效的订阅，没有时返回 nil
+func GetActiveSubscription(userId int) (*Subscription, error) {
+	var subscription Subscription
+	err := DB.Where("user_id = ? AND status = ?", userId, SubscriptionStatusActive).Limit(1).Find(&subscription).Error
+	if err != nil || subscription.Id == 0 {
+		return nil, err
+	}
+	return &subscription, nil
+}
+
+func GetSubscriptions(userId int, startIdx int, num int) (subscriptions []*Subscription, total int64, err error) {
+	query := DB.Model(&Subscription{})
+	if userId != 0 {
+		query = query.Where("user_id = ?", userId)
+	}
+	if err = query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	err = query.Order("id desc").Limit(num).Offset(startIdx).Find(&subscriptions).Error
+	return subscriptions, total, err
+}
+
+// GetDueSubscriptions 按 ID 顺序返回 afterId 之后已到期、需要续订或结束的订阅
+func GetDueSubscriptions(now int64, afterId int, limit int) ([]*Subscription, error) {
+	var subscriptions []*Subscription
+	err := DB.Where("status = ? AND end_time <= ? AND id > ?", SubscriptionStatusActive, now, afterId).Order("id asc").Limit(limit).Find(&subscriptions).Error
+	return subscriptions, err
+}
+
+// subscriptionOverageCacheDuration 超额倍率的缓存时间。每个请求计费时都需要超额倍率，缓存后不必每次查询订阅；
+// 本周期消费超过包含额度后，最多延迟这么久开始按超额倍率计费。订阅开始新周期或结束时清除缓存。
+const subscriptionOverageCacheDuration = 10 * time.Second
+
+type subscriptionOverage struct {
+	ratio    float64
+	exceeded bool
+	expireAt time.Time
+}
+
+// subscriptionOverageCache 用户ID -> subscriptionOverage
+var subscriptionOverageCache sync.Map
+
+// GetSubscriptionOverageRatio 用户本周期消费超过包含额度时返回超额倍率
+func GetSubscriptionOverageRatio(userId int) (float64, bool) {
+	if value, ok := subscriptionOverageCache.Load(userId); ok {
+		if overage := value.(subscriptionOverage); time.Now().Before(overage.expireAt) {
+			return overage.ratio, overage.exceeded
+		}
+	}
+	subscription, err := GetActiveSubscription(userId)
+	if err != nil {
+		return 0, false
+	}
+	overage := subscriptionOverage{expireAt: time.Now().Add(subscriptionOverageCacheDuration)}
+	if subscription != nil && subscription.OverageRatio > 0 && subscription.OverageRatio != 1 {
+		overage.ratio = subscription.OverageRatio
+		overage.exceeded = subscription.UsedQuota >= subscription.IncludedQuota
+	}
+	subscriptionOverageCache.Store(userId, overage)
+	return overage.ratio, overage.exceeded
+}
+
+// invalidateSubscriptionCache 订阅变化后清除用户缓存和超额倍率缓存
+func invalidateSubscriptionCache(userId int) {
+	_ = invalidateUserCache(userId)
+	subscriptionOverageCache.Delete(userId)
+}
+
+// UpdateRenewal 修改自动续订设置，降级或更换套餐在本周期结束时生效
+func (subscription *Subscription) UpdateRenewal() error {
+	return DB.Model(subscription).Select("auto_renew", "next_plan_id").Updates(subscription).Error
+}
+
+// addSubscriptionUsage 在修改用户余额的同一事务中累加当前订阅周期的消费
+func addSubscriptionUsage(tx *gorm.DB, userId int, ledgers []*QuotaLedger) error {
+	usage := budgetUsage(ledgers)
+	if usage == 0 {
+		return nil
+	}
+	return tx.Model(&Subscription{}).Where("user_id = ? AND status = ?", userId, SubscriptionStatusActive).
+		UpdateColumn("used_quota", gorm.Expr("used_quota + ?", usage)).Error
+}
+
+// changeUserQuota 在事务中修改用户余额并写入账本
+func changeUserQuota(tx *gorm.DB, userId int, delta int, movement QuotaMovement) error {
+	if delta == 0 {
+		return nil
+	}
+	err := tx.Model(&User{}).Where("id = ?", userId).Update("quota", gorm.Expr("quota + ?", delta)).Error
+	if err != nil {
+		return err
+	}
+	return recordQuotaLedger(tx, QuotaAccountUser, userId, delta, []*QuotaLedger{movement.newLedger(QuotaAccountUser, userId, delta)})
+}
+
+// reclaimSubscriptionQuota 收回本周期未用完的包含额度，最多收回到余额为 0
+func reclaimSubscriptionQuota(tx *gorm.DB, subscription *Subscription) error {
+	// 先锁定用户余额，消费会在同一行锁下累加订阅用量，此时读取的用量是最新的
+	balance, err := lockQuotaBalance(tx, QuotaAccountUser, subscription.UserId)
+	if err != nil {
+		return err
+	}
+	err = tx.Model(&Subscription{}).Where("id = ?", subscription.Id).Select("used_quota").Scan(&subscription.UsedQuota).Error
+	if err != nil {
+		return err
+	}
+	unused := subscription.IncludedQuota - subscription.UsedQuota
+	reclaim := min(unused, balance)
+	if reclaim <= 0 {
+		return nil
+	}
+	return changeUserQuota(tx, subscription.UserId, -reclaim, QuotaMovement{
+		Reason:         QuotaReasonSubscription,
+		RequestId:      subscription.TradeNo,
+		CounterAccount: fmt.Sprintf("subscription:%d", subscription.Id),
+		Remark:         "收回未用完的订阅额度",
+	})
+}
+
+// claimDueSubscription 在事务开始时认领到期的订阅：只有仍然生效且尚未续订的订阅才会被更新，
+// 并发的替换（标记为 replaced）、结束或其他节点的续订会使认领失败，返回 ErrSubscriptionNotActive
+func claimDueSubscription(tx *gorm.DB, subscription *Subscription, updates map[string]interface{}) error {
+	result := tx.Model(&Subscription{}).
+		Where("id = ? AND status = ? AND end_time = ?", subscription.Id, SubscriptionStatusActive, subscription.EndTime).
+		UpdateColumns(updates)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrSubscriptionNotActive
+	}
+	return nil
+}
+
+// startSubscriptionPeriod 开始新周期：计入包含额度并切换分组
+func startSubscriptionPeriod(tx *gorm.DB, subscription *Subscription, plan *SubscriptionPlan, now time.Time) error {
+	subscription.PlanId = plan.Id
+	subscription.PlanName = plan.Name
+	subscription.Group = plan.Group
+	subscription.IncludedQuota = plan.IncludedQuota
+	subscription.UsedQuota = 0
+	subscription.OverageRatio = plan.OverageRatio
+	subscription.StartTime = now.Unix()
+	subscription.EndTime = plan.periodEnd(now)
+	subscription.NextPlanId = 0
+	if err := tx.Save(subscription).Error; err != nil {
+		return err
+	}
+	if plan.Group != "" {
+		if err := tx.Model(&User{}).Where("id = ?", subscription.UserId).Update("group", plan.Group).Error; err != nil {
+			return err
+		}
+	}
+	return changeUserQuota(tx, subscription.UserId, plan.IncludedQuota, QuotaMovement{
+		Reason:         QuotaReasonSubscription,
+		RequestId:      subscription.TradeNo,
+		CounterAccount: fmt.Sprintf("subscription:%d", subscription.Id),
+		Remark:         "订阅包含额度：" + plan.Name,
+	})
+}
+
+// ActivateSubscription 支付成功后开通订阅。已有订阅时立即替换，旧订阅未用完的包含额度会被收回。
+func ActivateSubscription(userId int, plan *SubscriptionPlan, tradeNo string) (*Subscription, error) {
+	var subscription *Subscription
+	err := DB.Transaction(func(tx *gorm.DB) (err error) {
+		subscription, err = activateSubscription(tx, userId, plan, tradeNo)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	invalidateSubscriptionCache(userId)
+	return subscription, nil
+}
+
+func activateSubscription(tx *gorm.DB, userId int, plan *SubscriptionPlan, tradeNo string) (*Subscription, error) {
+	subscription := &Subscription{
+		UserId:      userId,
+		Status:      SubscriptionStatusActive,
+		AutoRenew:   true,
+		TradeNo:     tradeNo,
+		CreatedTime: common.GetTimestamp(),
+	}
+	current := &Subscription{}
+	err := tx.Where("user_id = ? AND status = ?", userId, SubscriptionStatusActive).Limit(1).Find(current).Error
+	if err != nil {
+		return nil, err
+	}
+	if current.Id != 0 {
+		if err = reclaimSubscriptionQuota(tx, current); err != nil {
+			return nil, err
+		}
+		if err = tx.Model(current).Update("status", SubscriptionStatusReplaced).Error; err != nil {
+			return nil, err
+		}
+		if current.Group != "" && plan.Group == "" {
+			// 新套餐不指定分组时恢复订阅前的分组
+			err = tx.Model(&User{}).Where("id = ? AND "+commonGroupCol+" = ?", userId, current.Group).
+				Update("group", current.PreviousGroup).Error
+			if err != nil {
+				return nil, err
+			}
+		}
+		subscription.PreviousGroup = current.PreviousGroup
+	} else {
+		err = tx.Model(&User{}).Where("id = ?", userId).Select(commonGroupCol).Find(&subscription.PreviousGroup).Error
+		if err != nil {
+			return nil, err
+		}
+	}
+	return subscription, startSubscriptionPeriod(tx, subscription, plan, time.Now())
+}
+
+// RenewSubscription 到期续订：收回上个周期未用完的额度，从余额扣除套餐价格后开始新周期。
+// 余额不足时返回 ErrSubscriptionInsufficientQuota，订阅已不再生效时返回 ErrSubscriptionNotActive，均不做任何修改。
+func RenewSubscription(subscription *Subscription, plan *SubscriptionPlan) error {
+	cost := plan.PriceQuota()
+	now := time.Now()
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		// 新周期的开始时间必然与上个周期不同，认领成功时一定有行被更新
+		if err := claimDueSubscription(tx, subscription, map[string]interface{}{"start_time": now.Unix()}); err != nil {
+			return err
+		}
+		if err := reclaimSubscriptionQuota(tx, subscription); err != nil {
+			return err
+		}
+		if cost > 0 {
+			result := tx.Model(&User{}).Where("id = ? AND quota >= ?", subscription.UserId, cost).Update("quota", gorm.Expr("quota - ?", cost))
+			if result.Error != nil {
+				return result.Error
+			}
+			if result.RowsAffected == 0 {
+				return ErrSubscriptionInsufficientQuota
+			}
+			ledger := QuotaMovement{
+				Reason:         QuotaReasonSubscription,
+				CounterAccount: fmt.Sprintf("subscription:%d", subscription.Id),
+				Remark:         "自动续订：" + plan.Name,
+			}.newLedger(QuotaAccountUser, subscription.UserId, -cost)
+			if err := recordQuotaLedger(tx, QuotaAccountUser, subscription.UserId, -cost, []*QuotaLedger{ledger}); err != nil {
+				return err
+			}
+		}
+		if plan.Group == "" && subscription.Group != "" {
+			// 新套餐不指定分组时恢复订阅前的分组
+			err := tx.Model(&User{}).Where("id = ? AND "+commonGroupCol+" = ?", subscription.UserId, subscription.Group).
+				Update("group", subscription.PreviousGroup).Error
+			if err != nil {
+				return err
+			}
+		}
+		subscription.TradeNo = ""
+		return startSubscriptionPeriod(tx, subscription, plan, now)
+	})
+	if err != nil {
+		return err
+	}
+	invalidateSubscriptionCache(subscription.UserId)
+	return nil
+}
+
+// ExpireSubscription 结束订阅：收回未用完的包含额度，用户分组仍为订阅分组时恢复为订阅前的分组。
+// 订阅已不再生效时返回 ErrSubscriptionNotActive，不做任何修改。
+func ExpireSubscription(subscription *Subscription) error {
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		return expireSubscription(tx, subscription)
+	})
+	if err != nil {
+		return err
+	}
+	subscription.Status = SubscriptionStatusExpired
+	invalidateSubscriptionCache(subscription.UserId)
+	return nil
+}
+
+func expireSubscription(tx *gorm.DB, subscription *Subscription) error {
+	err := claimDueSubscription(tx, subscription, map[string]interface{}{"status": SubscriptionStatusExpired})
+	if err != nil {
+		return err
+	}
+	if err = reclaimSubscriptionQuota(tx, subscription); err != nil {
+		return err
+	}
+	if subscription.Group != "" {
+		err := tx.Model(&User{}).Where("id = ? AND "+commonGroupCol+" = ?", subscription.UserId, subscription.Group).
+			Update("group", subscription.PreviousGroup).Error
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -4,29 +4,46 @@ import (
 	"errors"
 	"fmt"
 	"one-api/common"
+	"one-api/setting/operation_setting"
 	"strings"
+	"time"
 
 	"github.com/bytedance/gopkg/util/gopool"
 	"gorm.io/gorm"
 )
 
 type Token struct {
-	Id                 int            `json:"id"`
-	UserId             int            `json:"user_id" gorm:"index"`
-	Key                string         `json:"key" gorm:"type:char(48);uniqueIndex"`
-	Status             int            `json:"status" gorm:"default:1"`
-	Name               string         `json:"name" gorm:"index" `
-	CreatedTime        int64          `json:"created_time" gorm:"bigint"`
-	AccessedTime       int64          `json:"accessed_time" gorm:"bigint"`
-	ExpiredTime        int64          `json:"expired_time" gorm:"bigint;default:-1"` // -1 means never expired
-	RemainQuota        int            `json:"remain_quota" gorm:"default:0"`
-	UnlimitedQuota     bool           `json:"unlimited_quota" gorm:"default:false"`
-	ModelLimitsEnabled bool           `json:"model_limits_enabled" gorm:"default:false"`
-	ModelLimits        string         `json:"model_limits" gorm:"type:varchar(1024);default:''"`
-	AllowIps           *string        `json:"allow_ips" gorm:"default:''"`
-	UsedQuota          int            `json:"used_quota" gorm:"default:0"` // used quota
-	Group              string         `json:"group" gorm:"default:''"`
-	DeletedAt          gorm.DeletedAt `gorm:"index"`
+	Id                   int            `json:"id"`
+	UserId               int            `json:"user_id" gorm:"index"`
+	Key                  string         `json:"key" gorm:"type:char(48);uniqueIndex"`
+	Status               int            `json:"status" gorm:"default:1"`
+	Name                 string         `json:"name" gorm:"index" `
+	CreatedTime          int64          `json:"created_time" gorm:"bigint"`
+	AccessedTime         int64          `json:"accessed_time" gorm:"bigint"`
+	ExpiredTime          int64          `json:"expired_time" gorm:"bigint;default:-1"` // -1 means never expired
+	RemainQuota          int            `json:"remain_quota" gorm:"default:0"`
+	UnlimitedQuota       bool           `json:"unlimited_quota" gorm:"default:false"`
+	ModelLimitsEnabled   bool           `json:"model_limits_enabled" gorm:"default:false"`
+	ModelLimits          string         `json:"model_limits" gorm:"type:varchar(1024);default:''"`
+	AllowIps             *string        `json:"allow_ips" gorm:"default:''"`
+	UsedQuota            int            `json:"used_quota" gorm:"default:0"` // used quota
+	Group                string         `json:"group" gorm:"default:''"`
+	HedgeEnabled         bool           `json:"hedge_enabled" gorm:"default:false"`               // 是否启用对冲请求
+	ResponseCacheTTL     int            `json:"response_cache_ttl" gorm:"default:0"`              // 响应缓存时间（秒），0 表示不启用，-1 表示使用默认缓存时间
+	TPMLimit             int            `json:"tpm_limit" gorm:"default:0"`                       // 每分钟 token 数限制，0 表示不限制
+	ConcurrencyLimit     int            `json:"concurrency_limit" gorm:"default:0"`               // 最大同时处理的请求数，0 表示不限制
+	HistoryMode          string         `json:"history_mode" gorm:"type:varchar(16);default:''"`  // 对话历史存储模式，为空时继承用户设置
+	HistorySampleRate    int            `json:"history_sample_rate" gorm:"default:0"`             // 对话历史抽样比例
+	HistoryRetentionDays int            `json:"history_retention_days" gorm:"default:0"`          // 对话历史保留天数，0 表示按全局清理天数清理
+	BudgetPeriod         string         `json:"budget_period" gorm:"type:varchar(16);default:''"` // 周期预算：daily、weekly、monthly，为空表示不启用
+	BudgetLimit          int            `json:"budget_limit" gorm:"default:0"`                    // 每个周期的额度上限，0 表示不限制
+	BudgetAlertThreshold int            `json:"budget_alert_threshold" gorm:"default:0"`          // 本周期用量达到该额度时提醒，0 表示不提醒
+	BudgetUsed           int            `json:"budget_used" gorm:"default:0"`                     // 本周期已用额度
+	BudgetPeriodStart    int64          `json:"budget_period_start" gorm:"bigint;default:0"`      // BudgetUsed 所属周期的开始时间
+	BudgetAlertedStart   int64          `json:"-" gorm:"bigint;default:0"`                        // 已发送预算提醒的周期开始时间
+	BudgetResetTime      int64          `json:"budget_reset_time" gorm:"-"`                       // 下次重置的时间，查询时计算
+	OrganizationId       int            `json:"organization_id" gorm:"index;default:0"`           // 组织令牌从组织钱包扣费，0 表示个人令牌，创建后不能修改
+	DeletedAt            gorm.DeletedAt `gorm:"index"`
 }
 
 func (token *Token) Clean() {
@@ -183,8 +200,25 @@ func (token *Token) Update() (err error) {
 			})
 		}
 	}()
-	err = DB.Model(token).Select("name", "status", "expired_time", "remain_quota", "unlimited_quota",
-		"model_limits_enabled", "model_limits", "allow_ips", "group").Updates(token).Error
+	err = DB.Transaction(func(tx *gorm.DB) error {
+		before, err := lockQuotaBalance(tx, QuotaAccountToken, token.Id)
+		if err != nil {
+			return err
+		}
+		err = tx.Model(token).Select("name", "status", "expired_time", "remain_quota", "unlimited_quota",
+			"model_limits_enabled", "model_limits", "allow_ips", "group", "hedge_enabled", "response_cache_ttl", "tpm_limit", "concurrency_limit",
+			"history_mode", "history_sample_rate", "history_retention_days",
+			"budget_period", "budget_limit", "budget_alert_threshold").Updates(token).Error
+		if err != nil {
+			return err
+		}
+		// 令牌额度被直接设置为新值，记录差额
+		return recordQuotaAdjustment(tx, QuotaAccountToken, token.Id, before, QuotaMovement{
+			Reason: QuotaReasonAdjust,
+			UserId: token.UserId,
+			Remark: "修改令牌额度",
+		})
+	})
 	return err
 }
 
@@ -261,7 +295,7 @@ func DeleteTokenById(id int, userId int) (err error) {
 	return token.Delete()
 }
 
-func IncreaseTokenQuota(id int, key string, quota int) (err error) {
+func IncreaseTokenQuota(id int, key string, quota int, movement QuotaMovement) (err error) {
 	if quota < 0 {
 		return errors.New("quota 不能为负数！")
 	}
@@ -273,28 +307,45 @@ func IncreaseTokenQuota(id int, key string, quota int) (err error) {
 			}
 		})
 	}
+	ledger := movement.newLedger(QuotaAccountToken, id, quota)
 	if common.BatchUpdateEnabled {
-		addNewRecord(BatchUpdateTypeTokenQuota, id, quota)
+		addNewLedgerRecord(BatchUpdateTypeTokenQuota, id, quota, ledger)
 		return nil
 	}
-	return increaseTokenQuota(id, quota)
+	return increaseTokenQuota(id, quota, ledger)
 }
 
-func increaseTokenQuota(id int, quota int) (err error) {
-	err = DB.Model(&Token{}).Where("id = ?", id).Updates(
-		map[string]interface{}{
-			"remain_quota":  gorm.Expr("remain_quota + ?", quota),
-			"used_quota":    gorm.Expr("used_quota - ?", quota),
-			"accessed_time": common.GetTimestamp(),
-		},
-	).Error
-	return err
+// increaseTokenQuota 修改令牌余额并在同一事务中写入账本，quota 为负数时减少余额
+func increaseTokenQuota(id int, quota int, ledgers ...*QuotaLedger) (err error) {
+	return DB.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&Token{}).Where("id = ?", id).Updates(
+			map[string]interface{}{
+				"remain_quota":  gorm.Expr("remain_quota + ?", quota),
+				"used_quota":    gorm.Expr("used_quota - ?", quota),
+				"accessed_time": common.GetTimestamp(),
+			},
+		).Error
+		if err != nil {
+			return err
+		}
+		if err = recordQuotaLedger(tx, QuotaAccountToken, id, quota, ledgers); err != nil {
+			return err
+		}
+		return addQuotaBudgetUsage(tx, QuotaAccountToken, id, ledgers)
+	})
 }
 
-func DecreaseTokenQuota(id int, key string, quota int) (err error) {
+func DecreaseTokenQuota(id int, key string, quota int, movement QuotaMovement) (err error) {
 	if quota < 0 {
 		return errors.New("quota 不能为负数！")
 	}
+	ledger := movement.newLedger(QuotaAccountToken, id, -quota)
+	// 受预算上限约束的扣费可能失败，需要立即写入数据库，成功后再更新缓存
+	if common.BatchUpdateEnabled && !movement.EnforceBudget {
+		addNewLedgerRecord(BatchUpdateTypeTokenQuota, id, -quota, ledger)
+	} else if err = increaseTokenQuota(id, -quota, ledger); err != nil {
+		return err
+	}
 	if common.RedisEnabled {
 		gopool.Go(func() {
 			err := cacheDecrTokenQuota(key, int64(quota))
@@ -303,22 +354,7 @@ func DecreaseTokenQuota(id int, key string, quota int) (err error) {
 			}
 		})
 	}
-	if common.BatchUpdateEnabled {
-		addNewRecord(BatchUpdateTypeTokenQuota, id, -quota)
-		return nil
-	}
-	return decreaseTokenQuota(id, quota)
-}
-
-func decreaseTokenQuota(id int, quota int) (err error) {
-	err = DB.Model(&Token{}).Where("id = ?", id).Updates(
-		map[string]interface{}{
-			"remain_quota":  gorm.Expr("remain_quota - ?", quota),
-			"used_quota":    gorm.Expr("used_quota + ?", quota),
-			"accessed_time": common.GetTimestamp(),
-		},
-	).Error
-	return err
+	return nil
 }
 
 // CountUserTokens returns total number of tokens for the given user, used for pagination
@@ -327,3 +363,33 @@ func CountUserTokens(userId int) (int64, error) {
 	err := DB.Model(&Token{}).Where("user_id = ?", userId).Count(&total).Error
 	return total, err
 }
+
+// GetBudget 令牌的周期预算配置
+func (token *Token) GetBudget() QuotaBudget {
+	return QuotaBudget{
+		Period:         token.BudgetPeriod,
+		Limit:          token.BudgetLimit,
+		AlertThreshold: token.BudgetAlertThreshold,
+	}
+}
+
+// RefreshBudgetUsage 按当前时间修正周期用量，周期已经结束时用量为 0，用于返回给前端
+func (token *Token) RefreshBudgetUsage() {
+	status := (&quotaBudgetState{
+		BudgetPeriod:      token.BudgetPeriod,
+		BudgetUsed:        token.BudgetUsed,
+		BudgetPeriodStart: token.BudgetPeriodStart,
+	}).status(time.Now())
+	token.BudgetUsed = status.Used
+	token.BudgetPeriodStart = status.PeriodStart
+	token.BudgetResetTime = status.ResetTime
+}
+
+// GetHistoryPolicy 令牌上的对话历史存储策略，Mode 为空表示继承用户设置
+func (token *Token) GetHistoryPolicy() operation_setting.HistoryPolicy {
+	return operation_setting.HistoryPolicy{
+		Mode:          token.HistoryMode,
+		SampleRate:    token.HistorySampleRate,
+		RetentionDays: token.HistoryRetentionDays,
+	}
+}
@@ -1,13 +1,41 @@
 package model
 
+import (
+	"errors"
+	"fmt"
+	"one-api/common"
+
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+)
+
+// 订单状态只能按 pending -> success -> refunding -> refunded 或 pending -> failed 变化，渠道退款失败时 refunding 恢复为 success。
+// 支付渠道的回调可能重复或乱序到达，状态更新都带有原状态条件，重复的回调不会重复入账
+const (
+	TopUpStatusPending   = "pending"
+	TopUpStatusSuccess   = "success"
+	TopUpStatusFailed    = "failed"
+	TopUpStatusRefunding = "refunding" // 已向渠道发起退款，尚未收回额度
+	TopUpStatusRefunded  = "refunded"
+)
+
+var ErrTopUpStatusConflict = errors.New("订单状态已变化")
+
 type TopUp struct {
-	Id         int     `json:"id"`
-	UserId     int     `json:"user_id" gorm:"index"`
-	Amount     int64   `json:"amount"`
-	Money      float64 `json:"money"`
-	TradeNo    string  `json:"trade_no"`
-	CreateTime int64   `json:"create_time"`
-	Status     string  `json:"status"`
+	Id              int     `json:"id"`
+	UserId          int     `json:"user_id" gorm:"index"`
+	Amount          int64   `json:"amount"`
+	Money           float64 `json:"money"`
+	TradeNo         string  `json:"trade_no"`
+	CreateTime      int64   `json:"create_time"`
+	Status          string  `json:"status"`
+	PlanId          int     `json:"plan_id" gorm:"default:0"`                            // 订阅订单对应的套餐，0 表示充值订单
+	Quota           int     `json:"quota" gorm:"default:0"`                              // 支付成功后计入的额度，为 0 时按 Amount 计算
+	PaymentProvider string  `json:"payment_provider" gorm:"type:varchar(32);default:''"` // 为空表示易支付
+	PaymentMethod   string  `json:"payment_method" gorm:"type:varchar(32);default:''"`
+	ProviderTradeNo string  `json:"provider_trade_no" gorm:"type:varchar(128);default:''"` // 支付渠道的订单号
+	CompleteTime    int64   `json:"complete_time" gorm:"bigint;default:0"`
+	RefundMoney     float64 `json:"refund_money" gorm:"default:0"`
 }
 
 func (topUp *TopUp) Insert() error {
@@ -16,12 +44,28 @@ func (topUp *TopUp) Insert() error {
 	return err
 }
 
+// UpdateProviderTradeNo 渠道创建订单后保存渠道的订单号
+func (topUp *TopUp) UpdateProviderTradeNo(providerTradeNo string) error {
+	topUp.ProviderTradeNo = providerTradeNo
+	return DB.Model(&TopUp{}).Where("id = ?", topUp.Id).Update("provider_trade_no", providerTradeNo).Error
+}
+
 func (topUp *TopUp) Update() error {
 	var err error
 	err = DB.Save(topUp).Error
 	return err
 }
 
+// GetQuota 充值订单支付成功后计入的额度
+func (topUp *TopUp) GetQuota() int {
+	if topUp.Quota > 0 || topUp.PlanId != 0 {
+		return topUp.Quota
+	}
+	dAmount := decimal.NewFromInt(topUp.Amount)
+	dQuotaPerUnit := decimal.NewFromFloat(common.QuotaPerUnit)
+	return int(dAmount.Mul(dQuotaPerUnit).IntPart())
+}
+
 func GetTopUpById(id int) *TopUp {
 	var topUp *TopUp
 	var err error
@@ -41,3 +85,160 @@ func GetTopUpByTradeNo(tradeNo string) *TopUp {
 	}
 	return topUp
 }
+
+func GetTopUps(userId int, status string, startIdx int, num int) (topUps []*TopUp, total int64, err error) {
+	query := DB.Model(&TopUp{})
+	if userId != 0 {
+		query = query.Where("user_id = ?", userId)
+	}
+	if status != "" {
+		query = query.Where("status = ?", status)
+	}
+	if err = query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	err = query.Order("id desc").Limit(num).Offset(startIdx).Find(&topUps).Error
+	return topUps, total, err
+}
+
+// updateTopUpStatus 仅当订单仍处于 from 中的某个状态时更新，返回 false 表示订单已被其他回调处理
+func updateTopUpStatus(tx *gorm.DB, topUp *TopUp, from []string, updates map[string]interface{}) (bool, error) {
+	result := tx.Model(&TopUp{}).Where("id = ? AND status IN ?", topUp.Id, from).Updates(updates)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return false, nil
+	}
+	return true, tx.Where("id = ?", topUp.Id).First(topUp).Error
+}
+
+// CompleteTopUp 支付成功后入账：充值订单增加用户余额，订阅订单开通订阅。订单状态与余额在同一事务中修改，
+// 已经入账的订单返回 false。已标记为失败的订单再次收到成功回调时仍会入账。
+func CompleteTopUp(tradeNo string, providerTradeNo string) (*TopUp, bool, error) {
+	topUp := GetTopUpByTradeNo(tradeNo)
+	if topUp == nil {
+		return nil, false, fmt.Errorf("订单 %s 不存在", tradeNo)
+	}
+	updates := map[string]interface{}{
+		"status":        TopUpStatusSuccess,
+		"complete_time": common.GetTimestamp(),
+	}
+	if providerTradeNo != "" {
+		updates["provider_trade_no"] = providerTradeNo
+	}
+	completed := false
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		ok, err := updateTopUpStatus(tx, topUp, []string{TopUpStatusPending, TopUpStatusFailed}, updates)
+		if err != nil || !ok {
+			return err
+		}
+		completed = true
+		if topUp.PlanId != 0 {
+			// 套餐在支付期间下架不影响已支付的订单
+			plan := &SubscriptionPlan{}
+			if err = tx.Unscoped().First(plan, "id = ?", topUp.PlanId).Error; err != nil {
+				return err
+			}
+			_, err = activateSubscription(tx, topUp.UserId, plan, topUp.TradeNo)
+			return err
+		}
+		return changeUserQuota(tx, topUp.UserId, topUp.GetQuota(), QuotaMovement{
+			Reason:    QuotaReasonTopUp,
+			RequestId: topUp.TradeNo,
+		})
+	})
+	if err != nil || !completed {
+		return topUp, false, err
+	}
+	invalidateSubscriptionCache(topUp.UserId)
+	return topUp, true, nil
+}
+
+// FailTopUp 将未支付的订单标记为失败，已入账的订单不受影响
+func FailTopUp(tradeNo string) (bool, error) {
+	topUp := GetTopUpByTradeNo(tradeNo)
+	if topUp == nil {
+		return false, fmt.Errorf("订单 %s 不存在", tradeNo)
+	}
+	return updateTopUpStatus(DB, topUp, []string{TopUpStatusPending}, map[string]interface{}{
+		"status": TopUpStatusFailed,
+	})
+}
+
+// StartTopUpRefund 向渠道发起退款前将已支付的订单标记为退款中，同一订单只能有一个退款请求。
+// 订单不是已支付状态时返回 ErrTopUpStatusConflict。
+func StartTopUpRefund(topUp *TopUp) error {
+	ok, err := updateTopUpStatus(DB, topUp, []string{TopUpStatusSuccess}, map[string]interface{}{
+		"status": TopUpStatusRefunding,
+	})
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrTopUpStatusConflict
+	}
+	return nil
+}
+
+// CancelTopUpRefund 渠道退款失败时将退款中的订单恢复为已支付
+func CancelTopUpRefund(topUp *TopUp) error {
+	_, err := updateTopUpStatus(DB, topUp, []string{TopUpStatusRefunding}, map[string]interface{}{
+		"status": TopUpStatusSuccess,
+	})
+	return err
+}
+
+// RefundTopUp 订单退款后按退款金额收回入账的额度，最多收回到余额为 0；订阅订单结束仍在生效的订阅。返回收回的额度。
+// 订单不是已支付或退款中状态时返回 ErrTopUpStatusConflict。
+func RefundTopUp(tradeNo string, money float64) (*TopUp, int, error) {
+	topUp := GetTopUpByTradeNo(tradeNo)
+	if topUp == nil {
+		return nil, 0, fmt.Errorf("订单 %s 不存在", tradeNo)
+	}
+	reclaimed := 0
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		ok, err := updateTopUpStatus(tx, topUp, []string{TopUpStatusSuccess, TopUpStatusRefunding}, map[string]interface{}{
+			"status":       TopUpStatusRefunded,
+			"refund_money": money,
+		})
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return ErrTopUpStatusConflict
+		}
+		balance, err := lockQuotaBalance(tx, QuotaAccountUser, topUp.UserId)
+		if err != nil {
+			return err
+		}
+		if topUp.PlanId != 0 {
+			subscription := &Subscription{}
+			err = tx.Where("trade_no = ? AND status = ?", topUp.TradeNo, SubscriptionStatusActive).Limit(1).Find(subscription).Error
+			if err != nil || subscription.Id == 0 {
+				return err
+			}
+			if err = expireSubscription(tx, subscription); err != nil {
+				return err
+			}
+			after, err := getQuotaBalance(tx, QuotaAccountUser, topUp.UserId)
+			reclaimed = balance - after
+			return err
+		}
+		quota := topUp.GetQuota()
+		if money < topUp.Money {
+			// 部分退款按退款金额的比例收回
+			quota = int(float64(quota) * money / topUp.Money)
+		}
+		reclaimed = min(quota, balance)
+		return changeUserQuota(tx, topUp.UserId, -reclaimed, QuotaMovement{
+			Reason:    QuotaReasonTopUpRefund,
+			RequestId: topUp.TradeNo,
+		})
+	})
+	if err != nil {
+		return topUp, 0, err
+	}
+	invalidateSubscriptionCache(topUp.UserId)
+	return topUp, reclaimed, nil
+}
@@ -7,6 +7,7 @@ import (
 	"one-api/common"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/bytedance/gopkg/util/gopool"
 	"gorm.io/gorm"
@@ -42,6 +43,39 @@ type User struct {
 	LinuxDOId        string         `json:"linux_do_id" gorm:"column:linux_do_id;index"`
 	Setting          string         `json:"setting" gorm:"type:text;column:setting"`
 	Remark           string         `json:"remark,omitempty" gorm:"type:varchar(255)" validate:"max=255"`
+	TPMLimit         int            `json:"tpm_limit" gorm:"type:int;default:0"`         // 每分钟 token 数限制，0 表示不限制
+	ConcurrencyLimit int            `json:"concurrency_limit" gorm:"type:int;default:0"` // 最大同时处理的请求数，0 表示不限制
+
+	BudgetPeriod         string `json:"budget_period" gorm:"type:varchar(16);default:''"` // 周期预算：daily、weekly、monthly，为空表示不启用
+	BudgetLimit          int    `json:"budget_limit" gorm:"type:int;default:0"`           // 每个周期的额度上限，0 表示不限制
+	BudgetAlertThreshold int    `json:"budget_alert_threshold" gorm:"type:int;default:0"` // 本周期用量达到该额度时提醒，0 表示不提醒
+	BudgetUsed           int    `json:"budget_used" gorm:"type:int;default:0"`            // 本周期已用额度
+	BudgetPeriodStart    int64  `json:"budget_period_start" gorm:"bigint;default:0"`      // BudgetUsed 所属周期的开始时间
+	BudgetAlertedStart   int64  `json:"-" gorm:"bigint;default:0"`                        // 已发送预算提醒的周期开始时间
+	BudgetResetTime      int64  `json:"budget_reset_time" gorm:"-"`                       // 下次重置的时间，查询时计算
+
+	Subscription *Subscription `json:"subscription,omitempty" gorm:"-"` // 当前生效的订阅，仅在查询自己的信息时返回
+}
+
+// GetBudget 用户的周期预算配置
+func (user *User) GetBudget() QuotaBudget {
+	return QuotaBudget{
+		Period:         user.BudgetPeriod,
+		Limit:          user.BudgetLimit,
+		AlertThreshold: user.BudgetAlertThreshold,
+	}
+}
+
+// RefreshBudgetUsage 按当前时间修正周期用量，周期已经结束时用量为 0，用于返回给前端
+func (user *User) RefreshBudgetUsage() {
+	status := (&quotaBudgetState{
+		BudgetPeriod:      user.BudgetPeriod,
+		BudgetUsed:        user.BudgetUsed,
+		BudgetPeriodStart: user.BudgetPeriodStart,
+	}).status(time.Now())
+	user.BudgetUsed = status.Used
+	user.BudgetPeriodStart = status.PeriodStart
+	user.BudgetResetTime = status.ResetTime
 }
 
 func (user *User) ToBaseUser() *UserBase {
@@ -53,6 +87,13 @@ func (user *User) ToBaseUser() *UserBase {
 		Username: user.Username,
 		Setting:  user.Setting,
 		Email:    user.Email,
+
+		TPMLimit:         user.TPMLimit,
+		ConcurrencyLimit: user.ConcurrencyLimit,
+
+		BudgetPeriod:         user.BudgetPeriod,
+		BudgetLimit:          user.BudgetLimit,
+		BudgetAlertThreshold: user.BudgetAlertThreshold,
 	}
 	return cache
 }
@@ -297,6 +338,15 @@ func (user *User) TransferAffQuotaToQuota(quota int) error {
 	if err := tx.Save(user).Error; err != nil {
 		return err
 	}
+	// 从邀请额度账户划转到余额
+	ledger := QuotaMovement{
+		Reason:         QuotaReasonAffiliate,
+		CounterAccount: fmt.Sprintf("user_aff:%d", user.Id),
+		Remark:         "邀请额度划转",
+	}.newLedger(QuotaAccountUser, user.Id, quota)
+	if err := recordQuotaLedger(tx, QuotaAccountUser, user.Id, quota, []*QuotaLedger{ledger}); err != nil {
+		return err
+	}
 
 	// 提交事务
 	return tx.Commit().Error
@@ -322,7 +372,11 @@ func (user *User) Insert(inviterId int) error {
 	}
 	if inviterId != 0 {
 		if common.QuotaForInvitee > 0 {
-			_ = IncreaseUserQuota(user.Id, common.QuotaForInvitee, true)
+			_ = IncreaseUserQuota(user.Id, common.QuotaForInvitee, true, QuotaMovement{
+				Reason:         QuotaReasonAffiliate,
+				CounterAccount: fmt.Sprintf("user_aff:%d", inviterId),
+				Remark:         "使用邀请码赠送",
+			})
 			RecordLog(user.Id, LogTypeSystem, fmt.Sprintf("使用邀请码赠送 %s", common.LogQuota(common.QuotaForInvitee)))
 		}
 		if common.QuotaForInviter > 0 {
@@ -368,13 +422,33 @@ func (user *User) Edit(updatePassword bool) error {
 		"group":        newUser.Group,
 		"quota":        newUser.Quota,
 		"remark":       newUser.Remark,
+
+		"tpm_limit":         newUser.TPMLimit,
+		"concurrency_limit": newUser.ConcurrencyLimit,
+
+		"budget_period":          newUser.BudgetPeriod,
+		"budget_limit":           newUser.BudgetLimit,
+		"budget_alert_threshold": newUser.BudgetAlertThreshold,
 	}
 	if updatePassword {
 		updates["password"] = newUser.Password
 	}
 
 	DB.First(&user, user.Id)
-	if err = DB.Model(user).Updates(updates).Error; err != nil {
+	err = DB.Transaction(func(tx *gorm.DB) error {
+		before, err := lockQuotaBalance(tx, QuotaAccountUser, user.Id)
+		if err != nil {
+			return err
+		}
+		if err = tx.Model(user).Updates(updates).Error; err != nil {
+			return err
+		}
+		return recordQuotaAdjustment(tx, QuotaAccountUser, user.Id, before, QuotaMovement{
+			Reason: QuotaReasonAdjust,
+			Remark: "管理员修改用户额度",
+		})
+	})
+	if err != nil {
 		return err
 	}
 
@@ -654,7 +728,7 @@ func GetUserSetting(id int, fromDB bool) (settingMap map[string]interface{}, err
 	return common.StrToMap(setting), nil
 }
 
-func IncreaseUserQuota(id int, quota int, db bool) (err error) {
+func IncreaseUserQuota(id int, quota int, db bool, movement QuotaMovement) (err error) {
 	if quota < 0 {
 		return errors.New("quota 不能为负数！")
 	}
@@ -664,54 +738,59 @@ func IncreaseUserQuota(id int, quota int, db bool) (err error) {
 			common.SysError("failed to increase user quota: " + err.Error())
 		}
 	})
+	ledger := movement.newLedger(QuotaAccountUser, id, quota)
 	if !db && common.BatchUpdateEnabled {
-		addNewRecord(BatchUpdateTypeUserQuota, id, quota)
+		addNewLedgerRecord(BatchUpdateTypeUserQuota, id, quota, ledger)
 		return nil
 	}
-	return increaseUserQuota(id, quota)
+	return increaseUserQuota(id, quota, ledger)
 }
 
-func increaseUserQuota(id int, quota int) (err error) {
-	err = DB.Model(&User{}).Where("id = ?", id).Update("quota", gorm.Expr("quota + ?", quota)).Error
-	if err != nil {
-		return err
-	}
-	return err
+// increaseUserQuota 修改用户余额并在同一事务中写入账本，quota 为负数时减少余额
+func increaseUserQuota(id int, quota int, ledgers ...*QuotaLedger) (err error) {
+	return DB.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&User{}).Where("id = ?", id).Update("quota", gorm.Expr("quota + ?", quota)).Error
+		if err != nil {
+			return err
+		}
+		if err = recordQuotaLedger(tx, QuotaAccountUser, id, quota, ledgers); err != nil {
+			return err
+		}
+		if err = addSubscriptionUsage(tx, id, ledgers); err != nil {
+			return err
+		}
+		return addQuotaBudgetUsage(tx, QuotaAccountUser, id, ledgers)
+	})
 }
 
-func DecreaseUserQuota(id int, quota int) (err error) {
+func DecreaseUserQuota(id int, quota int, movement QuotaMovement) (err error) {
 	if quota < 0 {
 		return errors.New("quota 不能为负数！")
 	}
+	ledger := movement.newLedger(QuotaAccountUser, id, -quota)
+	// 受预算上限约束的扣费可能失败，需要立即写入数据库，成功后再更新缓存
+	if common.BatchUpdateEnabled && !movement.EnforceBudget {
+		addNewLedgerRecord(BatchUpdateTypeUserQuota, id, -quota, ledger)
+	} else if err = increaseUserQuota(id, -quota, ledger); err != nil {
+		return err
+	}
 	gopool.Go(func() {
 		err := cacheDecrUserQuota(id, int64(quota))
 		if err != nil {
 			common.SysError("failed to decrease user quota: " + err.Error())
 		}
 	})
-	if common.BatchUpdateEnabled {
-		addNewRecord(BatchUpdateTypeUserQuota, id, -quota)
-		return nil
-	}
-	return decreaseUserQuota(id, quota)
-}
-
-func decreaseUserQuota(id int, quota int) (err error) {
-	err = DB.Model(&User{}).Where("id = ?", id).Update("quota", gorm.Expr("quota - ?", quota)).Error
-	if err != nil {
-		return err
-	}
-	return err
+	return nil
 }
 
-func DeltaUpdateUserQuota(id int, delta int) (err error) {
+func DeltaUpdateUserQuota(id int, delta int, movement QuotaMovement) (err error) {
 	if delta == 0 {
 		return nil
 	}
 	if delta > 0 {
-		return IncreaseUserQuota(id, delta, false)
+		return IncreaseUserQuota(id, delta, false, movement)
 	} else {
-		return DecreaseUserQuota(id, -delta)
+		return DecreaseUserQuota(id, -delta, movement)
 	}
 }
 
@@ -798,6 +877,11 @@ func GetUsernameById(id int, fromDB bool) (username string, err error) {
 	return username, nil
 }
 
+func GetUserIdByUsername(username string) (id int, err error) {
+	err = DB.Model(&User{}).Where("username = ?", username).Select("id").First(&id).Error
+	return id, err
+}
+
 func IsLinuxDOIdAlreadyTaken(linuxDOId string) bool {
 	var user User
 	err := DB.Unscoped().Where("linux_do_id = ?", linuxDOId).First(&user).Error
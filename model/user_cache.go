@@ -21,6 +21,13 @@ type UserBase struct {
 	Status   int    `json:"status"`
 	Username string `json:"username"`
 	Setting  string `json:"setting"`
+
+	TPMLimit         int `json:"tpm_limit"`
+	ConcurrencyLimit int `json:"concurrency_limit"`
+
+	BudgetPeriod         string `json:"budget_period"`
+	BudgetLimit          int    `json:"budget_limit"`
+	BudgetAlertThreshold int    `json:"budget_alert_threshold"`
 }
 
 func (user *UserBase) WriteContext(c *gin.Context) {
@@ -30,6 +37,18 @@ func (user *UserBase) WriteContext(c *gin.Context) {
 	c.Set(constant.ContextKeyUserEmail, user.Email)
 	c.Set("username", user.Username)
 	c.Set(constant.ContextKeyUserSetting, user.GetSetting())
+	c.Set("user_tpm_limit", user.TPMLimit)
+	c.Set("user_concurrency_limit", user.ConcurrencyLimit)
+	c.Set("user_budget", user.GetBudget())
+}
+
+// GetBudget 用户的周期预算配置
+func (user *UserBase) GetBudget() QuotaBudget {
+	return QuotaBudget{
+		Period:         user.BudgetPeriod,
+		Limit:          user.BudgetLimit,
+		AlertThreshold: user.BudgetAlertThreshold,
+	}
 }
 
 func (user *UserBase) GetSetting() map[string]interface{} {
@@ -103,15 +122,7 @@ func GetUserCache(userId int) (userCache *UserBase, err error) {
 	}
 
 	// Create cache object from user data
-	userCache = &UserBase{
-		Id:       user.Id,
-		Group:    user.Group,
-		Quota:    user.Quota,
-		Status:   user.Status,
-		Username: user.Username,
-		Setting:  user.Setting,
-		Email:    user.Email,
-	}
+	userCache = user.ToBaseUser()
 
 	return userCache, nil
 }
@@ -23,10 +23,14 @@ const (
 var batchUpdateStores []map[int]int
 var batchUpdateLocks []sync.Mutex
 
+// batchLedgerStores 余额批量更新时暂存的每笔账本记录，与 batchUpdateStores 共用锁，随合并后的余额更新一起写入
+var batchLedgerStores []map[int][]*QuotaLedger
+
 func init() {
 	for i := 0; i < BatchUpdateTypeCount; i++ {
 		batchUpdateStores = append(batchUpdateStores, make(map[int]int))
 		batchUpdateLocks = append(batchUpdateLocks, sync.Mutex{})
+		batchLedgerStores = append(batchLedgerStores, make(map[int][]*QuotaLedger))
 	}
 }
 
@@ -49,6 +53,14 @@ func addNewRecord(type_ int, id int, value int) {
 	}
 }
 
+// addNewLedgerRecord 暂存余额变动及其账本记录
+func addNewLedgerRecord(type_ int, id int, value int, ledger *QuotaLedger) {
+	batchUpdateLocks[type_].Lock()
+	defer batchUpdateLocks[type_].Unlock()
+	batchUpdateStores[type_][id] += value
+	batchLedgerStores[type_][id] = append(batchLedgerStores[type_][id], ledger)
+}
+
 func batchUpdate() {
 	// check if there's any data to update
 	hasData := false
@@ -71,17 +83,19 @@ func batchUpdate() {
 		batchUpdateLocks[i].Lock()
 		store := batchUpdateStores[i]
 		batchUpdateStores[i] = make(map[int]int)
+		ledgerStore := batchLedgerStores[i]
+		batchLedgerStores[i] = make(map[int][]*QuotaLedger)
 		batchUpdateLocks[i].Unlock()
 		// TODO: maybe we can combine updates with same key?
 		for key, value := range store {
 			switch i {
 			case BatchUpdateTypeUserQuota:
-				err := increaseUserQuota(key, value)
+				err := increaseUserQuota(key, value, ledgerStore[key]...)
 				if err != nil {
 					common.SysError("failed to batch update user quota: " + err.Error())
 				}
 			case BatchUpdateTypeTokenQuota:
-				err := increaseTokenQuota(key, value)
+				err := increaseTokenQuota(key, value, ledgerStore[key]...)
 				if err != nil {
 					common.SysError("failed to batch update token quota: " + err.Error())
 				}
@@ -7,6 +7,7 @@ import (
 	"io"
 	"net/http"
 	common2 "one-api/common"
+	constant2 "one-api/constant"
 	"one-api/relay/common"
 	"one-api/relay/constant"
 	"one-api/relay/helper"
@@ -235,6 +236,10 @@ func doRequest(c *gin.Context, req *http.Request, info *common.RelayInfo) (*http
 		}
 	}
 
+	// 对冲请求使用可取消的 context，其他渠道胜出后立即中断上游请求
+	if _, ok := c.Get(constant2.ContextKeyHedgeGroup); ok {
+		req = req.WithContext(c.Request.Context())
+	}
 	resp, err := client.Do(req)
 
 	if err != nil {
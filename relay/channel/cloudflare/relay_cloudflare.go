@@ -11,7 +11,6 @@ import (
 	"one-api/relay/helper"
 	"one-api/service"
 	"strings"
-	"time"
 
 	"github.com/gin-gonic/gin"
 )
@@ -62,7 +61,7 @@ func cfStreamHandler(c *gin.Context, resp *http.Response, info *relaycommon.Rela
 		err = helper.ObjectData(c, response)
 		if isFirst {
 			isFirst = false
-			info.FirstResponseTime = time.Now()
+			info.SetFirstResponseTime(c)
 		}
 		if err != nil {
 			common.LogError(c, "error_rendering_stream_response: "+err.Error())
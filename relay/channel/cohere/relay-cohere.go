@@ -12,7 +12,6 @@ import (
 	"one-api/relay/helper"
 	"one-api/service"
 	"strings"
-	"time"
 )
 
 func requestOpenAI2Cohere(textRequest dto.GeneralOpenAIRequest) *CohereRequest {
@@ -110,7 +109,7 @@ func cohereStreamHandler(c *gin.Context, resp *http.Response, info *relaycommon.
 		case data := <-dataChan:
 			if isFirst {
 				isFirst = false
-				info.FirstResponseTime = time.Now()
+				info.SetFirstResponseTime(c)
 			}
 			data = strings.TrimSuffix(data, "\r")
 			var cohereResp CohereResponse
@@ -0,0 +1,400 @@
+package gemini
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"one-api/common"
+	"one-api/constant"
+	"one-api/dto"
+	relaycommon "one-api/relay/common"
+	"one-api/relay/helper"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// 匹配 OpenAI 格式响应中以 markdown 形式返回的 base64 图片
+var markdownDataImageRegex = regexp.MustCompile(`!\[[^\]]*\]\(data:([\w/+.-]+);base64,([A-Za-z0-9+/=]+)\)`)
+
+// GeminiRequest2OpenAI 将 Gemini generateContent 请求转换为 Chat Completions 请求，用于不支持 Gemini 协议的渠道
+func GeminiRequest2OpenAI(request *GeminiChatRequest, info *relaycommon.RelayInfo) (*dto.GeneralOpenAIRequest, error) {
+	openAIRequest := dto.GeneralOpenAIRequest{
+		Model:       info.UpstreamModelName,
+		Stream:      info.IsStream,
+		MaxTokens:   request.GenerationConfig.MaxOutputTokens,
+		Temperature: request.GenerationConfig.Temperature,
+		TopP:        request.GenerationConfig.TopP,
+		TopK:        int(request.GenerationConfig.TopK),
+		N:           request.GenerationConfig.CandidateCount,
+		Seed:        float64(request.GenerationConfig.Seed),
+	}
+	if len(request.GenerationConfig.StopSequences) > 0 {
+		openAIRequest.Stop = request.GenerationConfig.StopSequences
+	}
+	if request.GenerationConfig.ResponseMimeType == "application/json" {
+		if request.GenerationConfig.ResponseSchema != nil {
+			openAIRequest.ResponseFormat = &dto.ResponseFormat{
+				Type: "json_schema",
+				JsonSchema: &dto.FormatJsonSchema{
+					Name:   "response",
+					Schema: request.GenerationConfig.ResponseSchema,
+				},
+			}
+		} else {
+			openAIRequest.ResponseFormat = &dto.ResponseFormat{Type: "json_object"}
+		}
+	}
+
+	messages := make([]dto.Message, 0, len(request.Contents)+1)
+	if request.SystemInstructions != nil {
+		var texts []string
+		for _, part := range request.SystemInstructions.Parts {
+			if part.Text != "" {
+				texts = append(texts, part.Text)
+			}
+		}
+		if len(texts) > 0 {
+			messages = append(messages, dto.Message{Role: "system", Content: strings.Join(texts, "\n")})
+		}
+	}
+
+	// Gemini 的函数调用没有 ID，按函数名依次匹配调用与结果
+	pendingCalls := make(map[string][]string)
+	for _, content := range request.Contents {
+		var mediaContents []dto.MediaContent
+		var toolCalls []dto.ToolCallRequest
+		for _, part := range content.Parts {
+			switch {
+			case part.FunctionCall != nil:
+				args, err := json.Marshal(part.FunctionCall.Arguments)
+				if err != nil {
+					return nil, err
+				}
+				id := fmt.Sprintf("call_%s", common.GetUUID())
+				pendingCalls[part.FunctionCall.FunctionName] = append(pendingCalls[part.FunctionCall.FunctionName], id)
+				toolCalls = append(toolCalls, dto.ToolCallRequest{
+					ID:   id,
+					Type: "function",
+					Function: dto.FunctionRequest{
+						Name:      part.FunctionCall.FunctionName,
+						Arguments: string(args),
+					},
+				})
+			case part.FunctionResponse != nil:
+				name := part.FunctionResponse.Name
+				id := fmt.Sprintf("call_%s", common.GetUUID())
+				if ids := pendingCalls[name]; len(ids) > 0 {
+					id = ids[0]
+					pendingCalls[name] = ids[1:]
+				}
+				output, err := json.Marshal(part.FunctionResponse.Response)
+				if err != nil {
+					return nil, err
+				}
+				messages = append(messages, dto.Message{Role: "tool", ToolCallId: id, Content: string(output)})
+			case part.Thought:
+				// 思考内容不回传给上游
+			case part.InlineData != nil:
+				mediaContents = append(mediaContents, geminiInlineData2Media(part.InlineData))
+			case part.FileData != nil:
+				mediaContents = append(mediaContents, geminiFileData2Media(part.FileData))
+			case part.ExecutableCode != nil:
+				mediaContents = append(mediaContents, dto.MediaContent{Type: dto.ContentTypeText,
+					Text: "```" + part.ExecutableCode.Language + "\n" + part.ExecutableCode.Code + "\n```"})
+			case part.CodeExecutionResult != nil:
+				mediaContents = append(mediaContents, dto.MediaContent{Type: dto.ContentTypeText,
+					Text: "```output\n" + part.CodeExecutionResult.Output + "\n```"})
+			case part.Text != "":
+				mediaContents = append(mediaContents, dto.MediaContent{Type: dto.ContentTypeText, Text: part.Text})
+			}
+		}
+		if len(mediaContents) == 0 && len(toolCalls) == 0 {
+			continue
+		}
+		role := "user"
+		if content.Role == "model" {
+			role = "assistant"
+		}
+		message := dto.Message{Role: role}
+		if len(mediaContents) == 0 {
+			message.SetNullContent()
+		} else if len(mediaContents) == 1 && mediaContents[0].Type == dto.ContentTypeText {
+			message.SetStringContent(mediaContents[0].Text)
+		} else {
+			message.SetMediaContent(mediaContents)
+		}
+		if len(toolCalls) > 0 {
+			message.SetToolCalls(toolCalls)
+		}
+		messages = append(messages, message)
+	}
+	openAIRequest.Messages = messages
+
+	for _, tool := range request.Tools {
+		if tool.GoogleSearch != nil || tool.GoogleSearchRetrieval != nil || tool.CodeExecution != nil {
+			// 内置工具只有 Gemini 渠道支持
+			return nil, errors.New("gemini built-in tools are not supported by this channel")
+		}
+		if tool.FunctionDeclarations == nil {
+			continue
+		}
+		data, err := json.Marshal(tool.FunctionDeclarations)
+		if err != nil {
+			return nil, err
+		}
+		var functions []dto.FunctionRequest
+		if err := json.Unmarshal(data, &functions); err != nil {
+			return nil, fmt.Errorf("invalid function declarations: %w", err)
+		}
+		for _, function := range functions {
+			openAIRequest.Tools = append(openAIRequest.Tools, dto.ToolCallRequest{
+				Type:     "function",
+				Function: function,
+			})
+		}
+	}
+	return &openAIRequest, nil
+}
+
+func geminiInlineData2Media(data *GeminiInlineData) dto.MediaContent {
+	dataUrl := fmt.Sprintf("data:%s;base64,%s", data.MimeType, data.Data)
+	switch {
+	case strings.HasPrefix(data.MimeType, "image/"):
+		return dto.MediaContent{Type: dto.ContentTypeImageURL, ImageUrl: &dto.MessageImageUrl{Url: dataUrl, Detail: "auto"}}
+	case strings.HasPrefix(data.MimeType, "audio/"):
+		return dto.MediaContent{Type: dto.ContentTypeInputAudio, InputAudio: &dto.MessageInputAudio{
+			Data:   data.Data,
+			Format: strings.TrimPrefix(strings.TrimPrefix(data.MimeType, "audio/"), "x-"),
+		}}
+	}
+	return dto.MediaContent{Type: dto.ContentTypeFile, File: map[string]any{"file_data": dataUrl}}
+}
+
+func geminiFileData2Media(data *GeminiFileData) dto.MediaContent {
+	if strings.HasPrefix(data.MimeType, "image/") || data.MimeType == "" {
+		return dto.MediaContent{Type: dto.ContentTypeImageURL, ImageUrl: &dto.MessageImageUrl{Url: data.FileUri, Detail: "auto"}}
+	}
+	return dto.MediaContent{Type: dto.ContentTypeFile, File: map[string]any{"file_id": data.FileUri}}
+}
+
+// openAIText2GeminiParts 将文本拆分为 Gemini parts，markdown 形式的 base64 图片还原为 inlineData
+func openAIText2GeminiParts(text string) []GeminiPart {
+	if text == "" {
+		return nil
+	}
+	var parts []GeminiPart
+	last := 0
+	for _, match := range markdownDataImageRegex.FindAllStringSubmatchIndex(text, -1) {
+		if prefix := strings.TrimSpace(text[last:match[0]]); prefix != "" {
+			parts = append(parts, GeminiPart{Text: text[last:match[0]]})
+		}
+		parts = append(parts, GeminiPart{InlineData: &GeminiInlineData{
+			MimeType: text[match[2]:match[3]],
+			Data:     text[match[4]:match[5]],
+		}})
+		last = match[1]
+	}
+	if last == 0 {
+		return []GeminiPart{{Text: text}}
+	}
+	if suffix := strings.TrimSpace(text[last:]); suffix != "" {
+		parts = append(parts, GeminiPart{Text: text[last:]})
+	}
+	return parts
+}
+
+// openAIMessage2GeminiParts 转换消息内容，兼容字符串内容和包含 image_url 的数组内容
+func openAIMessage2GeminiParts(message *dto.Message) []GeminiPart {
+	if message.IsStringContent() {
+		return openAIText2GeminiParts(message.StringContent())
+	}
+	var parts []GeminiPart
+	for _, media := range message.ParseContent() {
+		switch media.Type {
+		case dto.ContentTypeText:
+			parts = append(parts, openAIText2GeminiParts(media.Text)...)
+		case dto.ContentTypeImageURL:
+			image := media.GetImageMedia()
+			if image == nil {
+				continue
+			}
+			if mimeType, data, ok := parseDataUrl(image.Url); ok {
+				parts = append(parts, GeminiPart{InlineData: &GeminiInlineData{MimeType: mimeType, Data: data}})
+			} else {
+				parts = append(parts, GeminiPart{FileData: &GeminiFileData{FileUri: image.Url}})
+			}
+		}
+	}
+	return parts
+}
+
+func parseDataUrl(url string) (string, string, bool) {
+	if !strings.HasPrefix(url, "data:") {
+		return "", "", false
+	}
+	header, data, found := strings.Cut(strings.TrimPrefix(url, "data:"), ";base64,")
+	if !found {
+		return "", "", false
+	}
+	return header, data, true
+}
+
+func openAIToolCall2GeminiPart(toolCall dto.ToolCallRequest) GeminiPart {
+	var args any = map[string]any{}
+	if toolCall.Function.Arguments != "" {
+		if err := json.Unmarshal([]byte(toolCall.Function.Arguments), &args); err != nil {
+			args = map[string]any{}
+		}
+	}
+	return GeminiPart{FunctionCall: &FunctionCall{
+		FunctionName: toolCall.Function.Name,
+		Arguments:    args,
+	}}
+}
+
+func openAIFinishReason2Gemini(finishReason string) string {
+	switch finishReason {
+	case constant.FinishReasonLength:
+		return "MAX_TOKENS"
+	case constant.FinishReasonContentFilter:
+		return "SAFETY"
+	}
+	return "STOP"
+}
+
+func usageOpenAI2Gemini(usage *dto.Usage) GeminiUsageMetadata {
+	if usage == nil {
+		return GeminiUsageMetadata{}
+	}
+	reasoningTokens := usage.CompletionTokenDetails.ReasoningTokens
+	return GeminiUsageMetadata{
+		PromptTokenCount:     usage.PromptTokens,
+		CandidatesTokenCount: usage.CompletionTokens - reasoningTokens,
+		ThoughtsTokenCount:   reasoningTokens,
+		TotalTokenCount:      usage.TotalTokens,
+	}
+}
+
+// ResponseOpenAI2Gemini 将 Chat Completions 非流式响应转换为 Gemini generateContent 响应
+func ResponseOpenAI2Gemini(openAIResponse *dto.OpenAITextResponse) *GeminiChatResponse {
+	response := &GeminiChatResponse{
+		Candidates:    make([]GeminiChatCandidate, 0, len(openAIResponse.Choices)),
+		UsageMetadata: usageOpenAI2Gemini(&openAIResponse.Usage),
+	}
+	for _, choice := range openAIResponse.Choices {
+		parts := make([]GeminiPart, 0)
+		if reasoning := choice.Message.ReasoningContent; reasoning != "" {
+			parts = append(parts, GeminiPart{Text: reasoning, Thought: true})
+		}
+		parts = append(parts, openAIMessage2GeminiParts(&choice.Message)...)
+		for _, toolCall := range choice.Message.ParseToolCalls() {
+			parts = append(parts, openAIToolCall2GeminiPart(toolCall))
+		}
+		finishReason := openAIFinishReason2Gemini(choice.FinishReason)
+		response.Candidates = append(response.Candidates, GeminiChatCandidate{
+			Content:      GeminiChatContent{Role: "model", Parts: parts},
+			FinishReason: &finishReason,
+			Index:        int64(choice.Index),
+		})
+	}
+	return response
+}
+
+// geminiTranslator 将 OpenAI 格式响应转换为 Gemini 格式，流式的函数调用参数在结束前累积，最后一次性输出
+type geminiTranslator struct {
+	toolCalls    map[int]*dto.ToolCallRequest
+	finishReason string
+}
+
+// NewGeminiTranslator 创建 Gemini 协议转换器
+func NewGeminiTranslator(info *relaycommon.RelayInfo) helper.ProtocolTranslator {
+	return &geminiTranslator{
+		toolCalls: make(map[int]*dto.ToolCallRequest),
+	}
+}
+
+func geminiSSEData(builder *strings.Builder, response *GeminiChatResponse) {
+	jsonData, err := json.Marshal(response)
+	if err != nil {
+		common.SysError("error marshalling stream response: " + err.Error())
+		return
+	}
+	builder.WriteString(fmt.Sprintf("data: %s\n\n", jsonData))
+}
+
+func (t *geminiTranslator) TranslateChunk(data string) ([]byte, error) {
+	var chunk dto.ChatCompletionsStreamResponse
+	if err := json.Unmarshal(common.StringToByteSlice(data), &chunk); err != nil {
+		return nil, err
+	}
+	var builder strings.Builder
+	for _, choice := range chunk.Choices {
+		if choice.FinishReason != nil && *choice.FinishReason != "" {
+			t.finishReason = *choice.FinishReason
+		}
+		for _, toolCall := range choice.Delta.ToolCalls {
+			index := 0
+			if toolCall.Index != nil {
+				index = *toolCall.Index
+			}
+			call, ok := t.toolCalls[index]
+			if !ok {
+				call = &dto.ToolCallRequest{ID: toolCall.ID, Type: "function"}
+				t.toolCalls[index] = call
+			}
+			if toolCall.Function.Name != "" {
+				call.Function.Name = toolCall.Function.Name
+			}
+			call.Function.Arguments += toolCall.Function.Arguments
+		}
+		var parts []GeminiPart
+		if reasoning := choice.Delta.GetReasoningContent(); reasoning != "" {
+			parts = append(parts, GeminiPart{Text: reasoning, Thought: true})
+		}
+		parts = append(parts, openAIText2GeminiParts(choice.Delta.GetContentString())...)
+		if len(parts) == 0 {
+			continue
+		}
+		geminiSSEData(&builder, &GeminiChatResponse{
+			Candidates: []GeminiChatCandidate{{
+				Content: GeminiChatContent{Role: "model", Parts: parts},
+				Index:   int64(choice.Index),
+			}},
+		})
+	}
+	return []byte(builder.String()), nil
+}
+
+func (t *geminiTranslator) TranslateStreamEnd(usage *dto.Usage) []byte {
+	parts := make([]GeminiPart, 0, len(t.toolCalls))
+	indexes := make([]int, 0, len(t.toolCalls))
+	for index := range t.toolCalls {
+		indexes = append(indexes, index)
+	}
+	sort.Ints(indexes)
+	for _, index := range indexes {
+		parts = append(parts, openAIToolCall2GeminiPart(*t.toolCalls[index]))
+	}
+	finishReason := openAIFinishReason2Gemini(t.finishReason)
+	var builder strings.Builder
+	geminiSSEData(&builder, &GeminiChatResponse{
+		Candidates: []GeminiChatCandidate{{
+			Content:      GeminiChatContent{Role: "model", Parts: parts},
+			FinishReason: &finishReason,
+		}},
+		UsageMetadata: usageOpenAI2Gemini(usage),
+	})
+	return []byte(builder.String())
+}
+
+func (t *geminiTranslator) TranslateResponse(body []byte, usage *dto.Usage) ([]byte, error) {
+	var openAIResponse dto.OpenAITextResponse
+	if err := json.Unmarshal(body, &openAIResponse); err != nil {
+		return nil, err
+	}
+	if usage != nil {
+		openAIResponse.Usage = *usage
+	}
+	return json.Marshal(ResponseOpenAI2Gemini(&openAIResponse))
+}
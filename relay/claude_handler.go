@@ -78,6 +78,11 @@ func ClaudeHelper(c *gin.Context) (claudeError *dto.ClaudeErrorWithStatusCode) {
 	if adaptor == nil {
 		return service.ClaudeErrorWrapperLocal(fmt.Errorf("invalid api type: %d", relayInfo.ApiType), "invalid_api_type", http.StatusBadRequest)
 	}
+	// 渠道不支持 Claude 协议时经由 OpenAI 格式转换
+	translated := !helper.IsNativeRelayFormat(relayInfo, relaycommon.RelayFormatClaude)
+	if translated {
+		useOpenAIFormat(relayInfo)
+	}
 	adaptor.Init(relayInfo)
 	var requestBody io.Reader
 
@@ -107,7 +112,12 @@ func ClaudeHelper(c *gin.Context) (claudeError *dto.ClaudeErrorWithStatusCode) {
 		relayInfo.UpstreamModelName = textRequest.Model
 	}
 
-	convertedRequest, err := adaptor.ConvertClaudeRequest(c, relayInfo, textRequest)
+	var convertedRequest any
+	if translated {
+		convertedRequest, err = convertClaudeViaOpenAI(c, adaptor, relayInfo, textRequest)
+	} else {
+		convertedRequest, err = adaptor.ConvertClaudeRequest(c, relayInfo, textRequest)
+	}
 	if err != nil {
 		return service.ClaudeErrorWrapperLocal(err, "convert_request_failed", http.StatusInternalServerError)
 	}
@@ -138,6 +148,14 @@ func ClaudeHelper(c *gin.Context) (claudeError *dto.ClaudeErrorWithStatusCode) {
 		}
 	}
 
+	// 记录最终回复用于保存对话历史，需要在协议转换之前安装
+	capture := startConversationCapture(c, relaycommon.RelayFormatClaude)
+	defer capture.Restore(c)
+	var translateWriter *helper.TranslateWriter
+	if translated {
+		translateWriter = helper.NewTranslateWriter(c, service.NewClaudeTranslator(relayInfo))
+		defer translateWriter.Restore(c)
+	}
 	usage, openaiErr := adaptor.DoResponse(c, httpResp, relayInfo)
 	//log.Printf("usage: %v", usage)
 	if openaiErr != nil {
@@ -145,7 +163,13 @@ func ClaudeHelper(c *gin.Context) (claudeError *dto.ClaudeErrorWithStatusCode) {
 		service.ResetStatusCode(openaiErr, statusCodeMappingStr)
 		return service.OpenAIErrorToClaudeError(openaiErr)
 	}
+	if translateWriter != nil {
+		if err := translateWriter.Finish(c, usage.(*dto.Usage)); err != nil {
+			common.LogError(c, "translate response failed: "+err.Error())
+		}
+	}
 	service.PostClaudeConsumeQuota(c, relayInfo, usage.(*dto.Usage), preConsumedQuota, userQuota, priceData, "")
+	capture.Save(c, relayInfo, textRequest, usage.(*dto.Usage))
 	return nil
 }
 
@@ -62,9 +62,12 @@ type ResponsesUsageInfo struct {
 type RelayInfo struct {
 	ChannelType       int
 	ChannelId         int
+	ChannelKeyIndex   int // 多密钥渠道本次使用的密钥下标
 	TokenId           int
 	TokenKey          string
 	UserId            int
+	RequestId         string
+	OrganizationId    int // 组织令牌所属的组织，扣费使用组织钱包
 	Group             string
 	UserGroup         string
 	TokenUnlimited    bool
@@ -236,9 +239,12 @@ func GenRelayInfo(c *gin.Context) *RelayInfo {
 		RequestURLPath:    c.Request.URL.String(),
 		ChannelType:       channelType,
 		ChannelId:         channelId,
+		ChannelKeyIndex:   c.GetInt("channel_key_index"),
 		TokenId:           tokenId,
 		TokenKey:          tokenKey,
 		UserId:            userId,
+		RequestId:         c.GetString(common.RequestIdKey),
+		OrganizationId:    c.GetInt("token_organization_id"),
 		Group:             group,
 		UserGroup:         c.GetString(constant.ContextKeyUserGroup),
 		TokenUnlimited:    tokenUnlimited,
@@ -289,10 +295,12 @@ func (info *RelayInfo) SetIsStream(isStream bool) {
 	info.IsStream = isStream
 }
 
-func (info *RelayInfo) SetFirstResponseTime() {
+// SetFirstResponseTime 记录首个响应的时间，同时写入上下文，渠道统计据此计算首字时间
+func (info *RelayInfo) SetFirstResponseTime(c *gin.Context) {
 	if info.isFirstResponse {
 		info.FirstResponseTime = time.Now()
 		info.isFirstResponse = false
+		c.Set(constant.ContextKeyFirstResponseAt, info.FirstResponseTime)
 	}
 }
 
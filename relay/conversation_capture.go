@@ -0,0 +1,464 @@
+package relay
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"one-api/common"
+	"one-api/dto"
+	"one-api/model"
+	"one-api/relay/channel/gemini"
+	relaycommon "one-api/relay/common"
+	"one-api/relay/helper"
+	"one-api/service"
+	"strings"
+	"time"
+
+	"github.com/bytedance/gopkg/util/gopool"
+	"github.com/gin-gonic/gin"
+)
+
+// conversationCaptureMaxBytes 单次响应记录的上限，超过后不保存对话历史
+const conversationCaptureMaxBytes = 16 << 20
+
+// conversationCapture 记录写回客户端的响应，请求结束后按入站协议组装出最终的助手回复并保存为对话历史。
+// 需要在 TranslateWriter 之前安装，才能记录到协议转换后的内容。
+type conversationCapture struct {
+	writer        *helper.CaptureWriter
+	format        string
+	retentionDays int
+}
+
+// startConversationCapture 存储策略决定不保存本次请求时返回 nil，nil 上的方法都是空操作
+func startConversationCapture(c *gin.Context, format string) *conversationCapture {
+	policy := service.GetConversationHistoryPolicy(c)
+	if !service.ShouldSaveConversationHistory(policy, false) {
+		return nil
+	}
+	return &conversationCapture{
+		writer:        helper.NewCaptureWriter(c, conversationCaptureMaxBytes),
+		format:        format,
+		retentionDays: policy.RetentionDays,
+	}
+}
+
+func (capture *conversationCapture) Restore(c *gin.Context) {
+	if capture == nil {
+		return
+	}
+	capture.writer.Restore(c)
+}
+
+// Save 将请求消息与助手回复保存为对话历史，request 为各入站协议的请求结构体
+func (capture *conversationCapture) Save(c *gin.Context, relayInfo *relaycommon.RelayInfo, request any, usage *dto.Usage) {
+	if capture == nil {
+		return
+	}
+	// 对冲请求中落选的渠道不保存对话历史
+	if hedge := service.GetHedgeGroup(c); hedge != nil && hedge.Cancelled(relayInfo.ChannelId) {
+		return
+	}
+	body, ok := capture.writer.Body()
+	if !ok {
+		common.LogWarn(c, "response too large, conversation history not saved")
+		return
+	}
+	messages, err := conversationRequestMessages(request, relayInfo)
+	if err != nil {
+		common.LogError(c, "failed to convert conversation request: "+err.Error())
+		return
+	}
+	conversationId := getConversationId(c, "conv", relayInfo.UserId)
+	modelName := relayInfo.OriginModelName
+	userId := relayInfo.UserId
+	tokenId := relayInfo.TokenId
+	format := capture.format
+	retentionDays := capture.retentionDays
+
+	// 异步解析并保存对话历史，避免影响主流程性能
+	gopool.Go(func() {
+		message, finishReason, ok := AssembleAssistantMessage(format, body)
+		if !ok {
+			return
+		}
+		conversationData := map[string]interface{}{
+			"messages":      append(messages, message),
+			"model":         modelName,
+			"format":        format,
+			"finish_reason": finishReason,
+		}
+		if usage != nil {
+			conversationData["usage"] = usage
+		}
+		jsonData, err := json.Marshal(conversationData)
+		if err != nil {
+			common.LogError(c, "failed to marshal conversation data: "+err.Error())
+			return
+		}
+		// 保存前脱敏
+		rawJson, err := service.RedactHistoryJson(string(jsonData))
+		if err != nil {
+			common.LogError(c, "failed to redact conversation data: "+err.Error())
+			return
+		}
+		err = model.CreateConversationHistory(c, conversationId, modelName, rawJson, userId, tokenId, retentionDays)
+		if err != nil {
+			common.LogError(c, "failed to save conversation history: "+err.Error())
+		}
+	})
+}
+
+// getConversationId 优先从请求头获取对话ID，没有则使用前缀+用户ID+时间戳生成
+func getConversationId(c *gin.Context, prefix string, userId int) string {
+	conversationId := c.GetHeader("X-Conversation-ID")
+	if conversationId == "" {
+		conversationId = c.GetString("conversation_id")
+	}
+	if conversationId == "" {
+		conversationId = fmt.Sprintf("%s_%d_%d", prefix, userId, time.Now().Unix())
+	}
+	return conversationId
+}
+
+// conversationRequestMessages 将各协议的请求统一转换为 OpenAI 格式的消息列表
+func conversationRequestMessages(request any, relayInfo *relaycommon.RelayInfo) ([]dto.Message, error) {
+	var openAIRequest *dto.GeneralOpenAIRequest
+	var err error
+	switch req := request.(type) {
+	case *dto.GeneralOpenAIRequest:
+		openAIRequest = req
+	case *dto.ClaudeRequest:
+		openAIRequest, err = service.ClaudeToOpenAIRequest(*req, relayInfo)
+	case *dto.OpenAIResponsesRequest:
+		openAIRequest, err = service.ResponsesToOpenAIRequest(*req, relayInfo)
+	case *gemini.GeminiChatRequest:
+		openAIRequest, err = gemini.GeminiRequest2OpenAI(req, relayInfo)
+	default:
+		return nil, fmt.Errorf("unsupported request type %T", request)
+	}
+	if err != nil {
+		return nil, err
+	}
+	messages := make([]dto.Message, len(openAIRequest.Messages))
+	copy(messages, openAIRequest.Messages)
+	return messages, nil
+}
+
+// assistantTurn 组装后的助手回复，工具调用按下标合并流式分片
+type assistantTurn struct {
+	content      strings.Builder
+	reasoning    strings.Builder
+	toolCalls    []*dto.ToolCallResponse
+	toolIndex    map[int]*dto.ToolCallResponse
+	finishReason string
+}
+
+func (turn *assistantTurn) toolCall(index int) *dto.ToolCallResponse {
+	if turn.toolIndex == nil {
+		turn.toolIndex = make(map[int]*dto.ToolCallResponse)
+	}
+	if toolCall, ok := turn.toolIndex[index]; ok {
+		return toolCall
+	}
+	toolCall := &dto.ToolCallResponse{Type: "function"}
+	turn.toolIndex[index] = toolCall
+	turn.toolCalls = append(turn.toolCalls, toolCall)
+	return toolCall
+}
+
+// addToolCall 添加一个完整的工具调用，不参与按下标合并
+func (turn *assistantTurn) addToolCall(id string, name string, arguments string) {
+	turn.toolCalls = append(turn.toolCalls, &dto.ToolCallResponse{
+		ID:   id,
+		Type: "function",
+		Function: dto.FunctionResponse{
+			Name:      name,
+			Arguments: arguments,
+		},
+	})
+}
+
+func (turn *assistantTurn) empty() bool {
+	return turn.content.Len() == 0 && turn.reasoning.Len() == 0 && len(turn.toolCalls) == 0
+}
+
+func (turn *assistantTurn) message() dto.Message {
+	message := dto.Message{Role: "assistant"}
+	message.SetStringContent(turn.content.String())
+	message.ReasoningContent = turn.reasoning.String()
+	if len(turn.toolCalls) > 0 {
+		toolCalls := make([]dto.ToolCallResponse, 0, len(turn.toolCalls))
+		for _, toolCall := range turn.toolCalls {
+			toolCalls = append(toolCalls, *toolCall)
+		}
+		message.SetToolCalls(toolCalls)
+	}
+	return message
+}
+
+// AssembleAssistantMessage 按入站协议（relaycommon.RelayFormat*）将记录的响应组装为 OpenAI 格式的助手消息，
+// 返回消息、结束原因以及是否有回复内容
+func AssembleAssistantMessage(format string, body []byte) (dto.Message, string, bool) {
+	turn := assembleAssistantTurn(format, body)
+	if turn == nil {
+		return dto.Message{}, "", false
+	}
+	return turn.message(), turn.finishReason, true
+}
+
+// assembleAssistantTurn 按入站协议解析记录的响应（流式或非流式），没有任何回复内容时返回 nil
+func assembleAssistantTurn(format string, body []byte) *assistantTurn {
+	turn := &assistantTurn{}
+	trimmed := bytes.TrimSpace(body)
+	if len(trimmed) == 0 {
+		return nil
+	}
+	var events [][]byte
+	if trimmed[0] == '{' {
+		events = [][]byte{trimmed}
+	} else if trimmed[0] == '[' {
+		// Gemini 非 SSE 流式响应为 JSON 数组
+		var items []json.RawMessage
+		if err := json.Unmarshal(trimmed, &items); err != nil {
+			return nil
+		}
+		for _, item := range items {
+			events = append(events, item)
+		}
+	} else {
+		events = sseDataEvents(trimmed)
+	}
+	for _, event := range events {
+		switch format {
+		case relaycommon.RelayFormatClaude:
+			assembleClaudeEvent(turn, event)
+		case relaycommon.RelayFormatGemini:
+			assembleGeminiEvent(turn, event)
+		case relaycommon.RelayFormatOpenAIResponses:
+			assembleResponsesEvent(turn, event)
+		default:
+			assembleOpenAIEvent(turn, event)
+		}
+	}
+	if turn.empty() {
+		return nil
+	}
+	return turn
+}
+
+// sseDataEvents 提取 SSE 中的 data 行，忽略 event 行、保活注释和 [DONE]
+func sseDataEvents(body []byte) [][]byte {
+	var events [][]byte
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+	scanner.Buffer(make([]byte, 64*1024), conversationCaptureMaxBytes)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if !bytes.HasPrefix(line, []byte("data:")) {
+			continue
+		}
+		data := bytes.TrimSpace(line[len("data:"):])
+		if len(data) == 0 || string(data) == "[DONE]" {
+			continue
+		}
+		events = append(events, bytes.Clone(data))
+	}
+	return events
+}
+
+func assembleOpenAIEvent(turn *assistantTurn, data []byte) {
+	var probe struct {
+		Choices []struct {
+			Message json.RawMessage `json:"message"`
+		} `json:"choices"`
+	}
+	if err := json.Unmarshal(data, &probe); err != nil {
+		return
+	}
+	// 非流式响应的 choices 中为完整的 message，流式响应为 delta
+	if len(probe.Choices) > 0 && len(probe.Choices[0].Message) > 0 {
+		var response dto.OpenAITextResponse
+		if err := json.Unmarshal(data, &response); err != nil || len(response.Choices) == 0 {
+			return
+		}
+		choice := response.Choices[0]
+		turn.content.WriteString(choice.Message.StringContent())
+		if choice.Message.ReasoningContent != "" {
+			turn.reasoning.WriteString(choice.Message.ReasoningContent)
+		} else {
+			turn.reasoning.WriteString(choice.Message.Reasoning)
+		}
+		var toolCalls []dto.ToolCallResponse
+		if len(choice.Message.ToolCalls) > 0 && json.Unmarshal(choice.Message.ToolCalls, &toolCalls) == nil {
+			for _, toolCall := range toolCalls {
+				turn.addToolCall(toolCall.ID, toolCall.Function.Name, toolCall.Function.Arguments)
+			}
+		}
+		turn.finishReason = choice.FinishReason
+		return
+	}
+	var chunk dto.ChatCompletionsStreamResponse
+	if err := json.Unmarshal(data, &chunk); err != nil {
+		return
+	}
+	for _, choice := range chunk.Choices {
+		// 多个候选时只记录第一个
+		if choice.Index != 0 {
+			continue
+		}
+		turn.content.WriteString(choice.Delta.GetContentString())
+		turn.reasoning.WriteString(choice.Delta.GetReasoningContent())
+		for i, delta := range choice.Delta.ToolCalls {
+			index := i
+			if delta.Index != nil {
+				index = *delta.Index
+			}
+			toolCall := turn.toolCall(index)
+			if delta.ID != "" {
+				toolCall.ID = delta.ID
+			}
+			if delta.Function.Name != "" {
+				toolCall.Function.Name = delta.Function.Name
+			}
+			toolCall.Function.Arguments += delta.Function.Arguments
+		}
+		if choice.FinishReason != nil {
+			turn.finishReason = *choice.FinishReason
+		}
+	}
+}
+
+func assembleClaudeEvent(turn *assistantTurn, data []byte) {
+	var response dto.ClaudeResponse
+	if err := json.Unmarshal(data, &response); err != nil {
+		return
+	}
+	switch response.Type {
+	case "message":
+		for _, block := range response.Content {
+			switch block.Type {
+			case "text":
+				turn.content.WriteString(block.GetText())
+			case "thinking":
+				turn.reasoning.WriteString(block.Thinking)
+			case "tool_use":
+				arguments, _ := json.Marshal(block.Input)
+				turn.addToolCall(block.Id, block.Name, string(arguments))
+			}
+		}
+		turn.finishReason = response.StopReason
+	case "content_block_start":
+		block := response.ContentBlock
+		if block == nil {
+			return
+		}
+		switch block.Type {
+		case "text":
+			turn.content.WriteString(block.GetText())
+		case "thinking":
+			turn.reasoning.WriteString(block.Thinking)
+		case "tool_use":
+			toolCall := turn.toolCall(response.GetIndex())
+			toolCall.ID = block.Id
+			toolCall.Function.Name = block.Name
+		}
+	case "content_block_delta":
+		delta := response.Delta
+		if delta == nil {
+			return
+		}
+		switch delta.Type {
+		case "text_delta":
+			turn.content.WriteString(delta.GetText())
+		case "thinking_delta":
+			turn.reasoning.WriteString(delta.Thinking)
+		case "input_json_delta":
+			if delta.PartialJson != nil {
+				toolCall := turn.toolCall(response.GetIndex())
+				toolCall.Function.Arguments += *delta.PartialJson
+			}
+		}
+	case "message_delta":
+		if response.Delta != nil && response.Delta.StopReason != nil {
+			turn.finishReason = *response.Delta.StopReason
+		}
+	}
+}
+
+func assembleGeminiEvent(turn *assistantTurn, data []byte) {
+	var response gemini.GeminiChatResponse
+	if err := json.Unmarshal(data, &response); err != nil || len(response.Candidates) == 0 {
+		return
+	}
+	candidate := response.Candidates[0]
+	for _, part := range candidate.Content.Parts {
+		if part.FunctionCall != nil {
+			arguments, _ := json.Marshal(part.FunctionCall.Arguments)
+			turn.addToolCall("", part.FunctionCall.FunctionName, string(arguments))
+		} else if part.Thought {
+			turn.reasoning.WriteString(part.Text)
+		} else {
+			turn.content.WriteString(part.Text)
+		}
+	}
+	if candidate.FinishReason != nil {
+		turn.finishReason = *candidate.FinishReason
+	}
+}
+
+// responsesOutputItem 在 ResponsesOutput 的基础上补充 reasoning 条目的摘要
+type responsesOutputItem struct {
+	dto.ResponsesOutput
+	Summary []dto.ResponsesOutputContent `json:"summary,omitempty"`
+}
+
+func assembleResponsesEvent(turn *assistantTurn, data []byte) {
+	var event struct {
+		Type     string          `json:"type"`
+		Status   string          `json:"status"`
+		Output   json.RawMessage `json:"output"`
+		Response *struct {
+			Status string          `json:"status"`
+			Output json.RawMessage `json:"output"`
+		} `json:"response"`
+	}
+	if err := json.Unmarshal(data, &event); err != nil {
+		return
+	}
+	var status string
+	var output json.RawMessage
+	switch event.Type {
+	case "":
+		// 非流式响应
+		status, output = event.Status, event.Output
+	case "response.completed", "response.incomplete", "response.failed":
+		// 结束事件包含完整的输出，覆盖之前的内容
+		if event.Response == nil {
+			return
+		}
+		status, output = event.Response.Status, event.Response.Output
+	default:
+		return
+	}
+	var items []responsesOutputItem
+	if err := json.Unmarshal(output, &items); err != nil {
+		return
+	}
+	*turn = assistantTurn{finishReason: status}
+	for _, item := range items {
+		switch item.Type {
+		case "message":
+			for _, content := range item.Content {
+				if content.Type == "output_text" {
+					turn.content.WriteString(content.Text)
+				}
+			}
+		case "reasoning":
+			for _, summary := range item.Summary {
+				turn.reasoning.WriteString(summary.Text)
+			}
+		case "function_call":
+			turn.addToolCall(item.CallId, item.Name, item.Arguments)
+		}
+	}
+}
@@ -134,6 +134,11 @@ func GeminiHelper(c *gin.Context) (openaiErr *dto.OpenAIErrorWithStatusCode) {
 		return service.OpenAIErrorWrapperLocal(fmt.Errorf("invalid api type: %d", relayInfo.ApiType), "invalid_api_type", http.StatusBadRequest)
 	}
 
+	// 渠道不支持 Gemini 协议时经由 OpenAI 格式转换
+	translated := !helper.IsNativeRelayFormat(relayInfo, relaycommon.RelayFormatGemini)
+	if translated {
+		useOpenAIFormat(relayInfo)
+	}
 	adaptor.Init(relayInfo)
 
 	// Clean up empty system instruction
@@ -150,7 +155,15 @@ func GeminiHelper(c *gin.Context) (openaiErr *dto.OpenAIErrorWithStatusCode) {
 		}
 	}
 
-	requestBody, err := json.Marshal(req)
+	var convertedRequest any = req
+	if translated {
+		convertedRequest, err = convertGeminiViaOpenAI(c, adaptor, relayInfo, req)
+		if err != nil {
+			return service.OpenAIErrorWrapperLocal(err, "convert_request_failed", http.StatusBadRequest)
+		}
+	}
+
+	requestBody, err := json.Marshal(convertedRequest)
 	if err != nil {
 		return service.OpenAIErrorWrapperLocal(err, "marshal_text_request_failed", http.StatusInternalServerError)
 	}
@@ -179,12 +192,26 @@ func GeminiHelper(c *gin.Context) (openaiErr *dto.OpenAIErrorWithStatusCode) {
 		}
 	}
 
+	// 记录最终回复用于保存对话历史，需要在协议转换之前安装
+	capture := startConversationCapture(c, relaycommon.RelayFormatGemini)
+	defer capture.Restore(c)
+	var translateWriter *helper.TranslateWriter
+	if translated {
+		translateWriter = helper.NewTranslateWriter(c, gemini.NewGeminiTranslator(relayInfo))
+		defer translateWriter.Restore(c)
+	}
 	usage, openaiErr := adaptor.DoResponse(c, resp.(*http.Response), relayInfo)
 	if openaiErr != nil {
 		service.ResetStatusCode(openaiErr, statusCodeMappingStr)
 		return openaiErr
 	}
+	if translateWriter != nil {
+		if err := translateWriter.Finish(c, usage.(*dto.Usage)); err != nil {
+			common.LogError(c, "translate response failed: "+err.Error())
+		}
+	}
 
 	postConsumeQuota(c, relayInfo, usage.(*dto.Usage), preConsumedQuota, userQuota, priceData, "")
+	capture.Save(c, relayInfo, req, usage.(*dto.Usage))
 	return nil
 }
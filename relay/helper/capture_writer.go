@@ -0,0 +1,68 @@
+package helper
+
+import (
+	"bytes"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+// CaptureWriter 包装 gin.ResponseWriter，在写回客户端的同时记录响应内容，用于响应缓存。
+// 响应超过 maxBytes 时停止记录并标记为溢出。
+type CaptureWriter struct {
+	gin.ResponseWriter
+	mu         sync.Mutex
+	buffer     bytes.Buffer
+	maxBytes   int
+	overflowed bool
+}
+
+// NewCaptureWriter 替换 c.Writer，需要在请求结束时调用 Restore
+func NewCaptureWriter(c *gin.Context, maxBytes int) *CaptureWriter {
+	writer := &CaptureWriter{
+		ResponseWriter: c.Writer,
+		maxBytes:       maxBytes,
+	}
+	c.Writer = writer
+	return writer
+}
+
+func (w *CaptureWriter) capture(data []byte) {
+	// 保活 goroutine 可能并发写入
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.overflowed {
+		return
+	}
+	if w.buffer.Len()+len(data) > w.maxBytes {
+		w.overflowed = true
+		w.buffer.Reset()
+		return
+	}
+	w.buffer.Write(data)
+}
+
+func (w *CaptureWriter) Write(data []byte) (int, error) {
+	w.capture(data)
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *CaptureWriter) WriteString(s string) (int, error) {
+	w.capture([]byte(s))
+	return w.ResponseWriter.WriteString(s)
+}
+
+// Body 返回记录的响应内容，响应过大未完整记录时返回 false
+func (w *CaptureWriter) Body() ([]byte, bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.overflowed {
+		return nil, false
+	}
+	return bytes.Clone(w.buffer.Bytes()), true
+}
+
+// Restore 恢复原始 ResponseWriter
+func (w *CaptureWriter) Restore(c *gin.Context) {
+	c.Writer = w.ResponseWriter
+}
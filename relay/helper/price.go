@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"one-api/common"
 	constant2 "one-api/constant"
+	"one-api/model"
 	relaycommon "one-api/relay/common"
 	"one-api/setting/ratio_setting"
 
@@ -13,6 +14,7 @@ import (
 type GroupRatioInfo struct {
 	GroupRatio        float64
 	GroupSpecialRatio float64
+	OverageRatio      float64 // 订阅包含额度用完后的超额倍率，已计入 GroupRatio，0 表示未超额
 }
 
 type PriceData struct {
@@ -58,6 +60,14 @@ func HandleGroupRatio(ctx *gin.Context, relayInfo *relaycommon.RelayInfo) GroupR
 		groupRatioInfo.GroupRatio = ratio_setting.GetGroupRatio(relayInfo.Group)
 	}
 
+	// 订阅本周期的包含额度用完后按超额倍率计费，组织令牌使用组织钱包，不计入个人订阅
+	if relayInfo.OrganizationId == 0 {
+		if overageRatio, exceeded := model.GetSubscriptionOverageRatio(relayInfo.UserId); exceeded {
+			groupRatioInfo.OverageRatio = overageRatio
+			groupRatioInfo.GroupRatio *= overageRatio
+		}
+	}
+
 	return groupRatioInfo
 }
 
@@ -0,0 +1,173 @@
+package helper
+
+import (
+	"bytes"
+	"net/http"
+	"one-api/common"
+	"one-api/dto"
+	relaycommon "one-api/relay/common"
+	"one-api/relay/constant"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ProtocolTranslator 将渠道输出的 OpenAI Chat Completions 格式响应转换为入站请求的协议格式
+type ProtocolTranslator interface {
+	// TranslateChunk 转换一个流式数据块（SSE data 内容），返回需要写回客户端的数据
+	TranslateChunk(data string) ([]byte, error)
+	// TranslateStreamEnd 流结束时返回收尾事件
+	TranslateStreamEnd(usage *dto.Usage) []byte
+	// TranslateResponse 转换非流式响应体
+	TranslateResponse(body []byte, usage *dto.Usage) ([]byte, error)
+}
+
+// IsNativeRelayFormat 判断渠道能否直接处理入站协议，不能处理时需要先转换为 OpenAI Chat Completions 格式
+func IsNativeRelayFormat(info *relaycommon.RelayInfo, format string) bool {
+	switch format {
+	case relaycommon.RelayFormatClaude:
+		switch info.ApiType {
+		case constant.APITypeAnthropic, constant.APITypeAws:
+			return true
+		case constant.APITypeVertexAi:
+			return strings.HasPrefix(info.UpstreamModelName, "claude")
+		case constant.APITypeOpenAI:
+			// OpenAI 渠道自身支持 claude 模型的 /v1/messages 转换
+			return strings.Contains(info.UpstreamModelName, "claude")
+		}
+		return false
+	case relaycommon.RelayFormatOpenAIResponses:
+		return info.ApiType == constant.APITypeOpenAI
+	case relaycommon.RelayFormatGemini:
+		switch info.ApiType {
+		case constant.APITypeGemini:
+			return true
+		case constant.APITypeVertexAi:
+			return strings.HasPrefix(info.UpstreamModelName, "gemini")
+		}
+		return false
+	}
+	return true
+}
+
+// TranslateWriter 包装 gin.ResponseWriter，把适配器写出的 OpenAI 格式响应转换为入站协议格式后再写回客户端。
+// 流式响应按 SSE 行实时转换，非流式响应缓存到 Finish 时一次性转换。
+type TranslateWriter struct {
+	gin.ResponseWriter
+	translator ProtocolTranslator
+	mu         sync.Mutex
+	buffer     bytes.Buffer
+	status     int
+	restored   bool
+}
+
+// NewTranslateWriter 替换 c.Writer，需要在请求结束时调用 Finish 或 Restore
+func NewTranslateWriter(c *gin.Context, translator ProtocolTranslator) *TranslateWriter {
+	writer := &TranslateWriter{
+		ResponseWriter: c.Writer,
+		translator:     translator,
+		status:         http.StatusOK,
+	}
+	c.Writer = writer
+	return writer
+}
+
+func (w *TranslateWriter) isStream() bool {
+	return strings.HasPrefix(w.Header().Get("Content-Type"), "text/event-stream")
+}
+
+func (w *TranslateWriter) WriteHeader(code int) {
+	w.status = code
+	if w.isStream() {
+		w.ResponseWriter.WriteHeader(code)
+	}
+}
+
+func (w *TranslateWriter) Write(data []byte) (int, error) {
+	// 保活 goroutine 可能并发写入
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.buffer.Write(data)
+	if w.isStream() {
+		w.translateLines()
+	}
+	return len(data), nil
+}
+
+func (w *TranslateWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+// translateLines 转换缓冲区中所有完整的 SSE 行
+func (w *TranslateWriter) translateLines() {
+	written := false
+	for {
+		index := bytes.IndexByte(w.buffer.Bytes(), '\n')
+		if index < 0 {
+			break
+		}
+		line := strings.TrimRight(string(w.buffer.Next(index+1)), "\r\n")
+		switch {
+		case strings.HasPrefix(line, "data:"):
+			data := strings.TrimSpace(strings.TrimPrefix(line, "data:"))
+			if data == "" || data == "[DONE]" {
+				continue
+			}
+			out, err := w.translator.TranslateChunk(data)
+			if err != nil {
+				common.SysError("error translating stream response: " + err.Error())
+				continue
+			}
+			if len(out) > 0 {
+				_, _ = w.ResponseWriter.Write(out)
+				written = true
+			}
+		case strings.HasPrefix(line, ":"):
+			// 保活注释原样输出
+			_, _ = w.ResponseWriter.Write([]byte(line + "\n\n"))
+			written = true
+		}
+	}
+	if written {
+		w.ResponseWriter.Flush()
+	}
+}
+
+// Restore 恢复原始 ResponseWriter，未转换的内容被丢弃，用于出错时返回错误信息
+func (w *TranslateWriter) Restore(c *gin.Context) {
+	if w.restored {
+		return
+	}
+	w.restored = true
+	c.Writer = w.ResponseWriter
+}
+
+// Finish 输出剩余的转换结果并恢复原始 ResponseWriter
+func (w *TranslateWriter) Finish(c *gin.Context, usage *dto.Usage) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.restored {
+		return nil
+	}
+	w.Restore(c)
+	if w.isStream() {
+		w.buffer.WriteString("\n")
+		w.translateLines()
+		_, _ = w.ResponseWriter.Write(w.translator.TranslateStreamEnd(usage))
+		w.ResponseWriter.Flush()
+		return nil
+	}
+	if w.buffer.Len() == 0 {
+		return nil
+	}
+	body, err := w.translator.TranslateResponse(w.buffer.Bytes(), usage)
+	if err != nil {
+		return err
+	}
+	w.Header().Del("Content-Length")
+	w.Header().Set("Content-Type", "application/json")
+	w.ResponseWriter.WriteHeader(w.status)
+	_, err = w.ResponseWriter.Write(body)
+	return err
+}
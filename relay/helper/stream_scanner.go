@@ -204,7 +204,7 @@ func StreamScannerHandler(c *gin.Context, resp *http.Response, info *relaycommon
 			data = strings.TrimLeft(data, " ")
 			data = strings.TrimSuffix(data, "\r")
 			if !strings.HasPrefix(data, "[DONE]") {
-				info.SetFirstResponseTime()
+				info.SetFirstResponseTime(c)
 
 				// 使用超时机制防止写操作阻塞
 				done := make(chan bool, 1)
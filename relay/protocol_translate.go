@@ -0,0 +1,53 @@
+package relay
+
+import (
+	"one-api/dto"
+	"one-api/relay/channel"
+	"one-api/relay/channel/gemini"
+	relaycommon "one-api/relay/common"
+	relayconstant "one-api/relay/constant"
+	"one-api/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// useOpenAIFormat 渠道不支持入站协议时，改用 OpenAI Chat Completions 格式请求渠道，响应再由 TranslateWriter 转换回入站协议
+func useOpenAIFormat(info *relaycommon.RelayInfo) {
+	info.RelayFormat = relaycommon.RelayFormatOpenAI
+	info.RelayMode = relayconstant.RelayModeChatCompletions
+	info.RequestURLPath = "/v1/chat/completions"
+	info.ShouldIncludeUsage = true
+}
+
+func convertViaOpenAIRequest(c *gin.Context, adaptor channel.Adaptor, info *relaycommon.RelayInfo, request *dto.GeneralOpenAIRequest) (any, error) {
+	if request.Stream && info.SupportStreamOptions {
+		request.StreamOptions = &dto.StreamOptions{
+			IncludeUsage: true,
+		}
+	}
+	return adaptor.ConvertOpenAIRequest(c, info, request)
+}
+
+func convertClaudeViaOpenAI(c *gin.Context, adaptor channel.Adaptor, info *relaycommon.RelayInfo, request *dto.ClaudeRequest) (any, error) {
+	openAIRequest, err := service.ClaudeToOpenAIRequest(*request, info)
+	if err != nil {
+		return nil, err
+	}
+	return convertViaOpenAIRequest(c, adaptor, info, openAIRequest)
+}
+
+func convertResponsesViaOpenAI(c *gin.Context, adaptor channel.Adaptor, info *relaycommon.RelayInfo, request *dto.OpenAIResponsesRequest) (any, error) {
+	openAIRequest, err := service.ResponsesToOpenAIRequest(*request, info)
+	if err != nil {
+		return nil, err
+	}
+	return convertViaOpenAIRequest(c, adaptor, info, openAIRequest)
+}
+
+func convertGeminiViaOpenAI(c *gin.Context, adaptor channel.Adaptor, info *relaycommon.RelayInfo, request *gemini.GeminiChatRequest) (any, error) {
+	openAIRequest, err := gemini.GeminiRequest2OpenAI(request, info)
+	if err != nil {
+		return nil, err
+	}
+	return convertViaOpenAIRequest(c, adaptor, info, openAIRequest)
+}
@@ -12,7 +12,6 @@ import (
 	"one-api/constant"
 	"one-api/dto"
 	"one-api/model"
-	"one-api/relay/channel/openai"
 	relaycommon "one-api/relay/common"
 	relayconstant "one-api/relay/constant"
 	"one-api/relay/helper"
@@ -151,15 +150,42 @@ func TextHelper(c *gin.Context) (openaiErr *dto.OpenAIErrorWithStatusCode) {
 	}
 
 	// pre-consume quota 预消耗配额
-	preConsumedQuota, userQuota, openaiErr := preConsumeQuota(c, priceData.ShouldPreConsumedQuota, relayInfo)
-	if openaiErr != nil {
-		return openaiErr
+	var preConsumedQuota, userQuota int
+	hedge := service.GetHedgeGroup(c)
+	if hedge != nil {
+		hedge.SetUpstreamEstimate(relayInfo.ChannelId, promptTokens, getPromptQuota(priceData, promptTokens))
+	}
+	if hedge != nil && !hedge.IsPrimary(relayInfo.ChannelId) {
+		// 对冲请求中后发起的渠道与主渠道共用预扣费和 TPM 预占，胜出时由 postConsumeQuota 按实际用量扣费。
+		// 这里仍然检查预算和余额，但不再预扣：主渠道的预扣已计入预算用量
+		userQuota, openaiErr = checkHedgeQuota(c, relayInfo)
+		if openaiErr != nil {
+			return openaiErr
+		}
+	} else {
+		preConsumedQuota, userQuota, openaiErr = preConsumeQuota(c, priceData.ShouldPreConsumedQuota, relayInfo)
+		if openaiErr != nil {
+			return openaiErr
+		}
 	}
 	defer func() {
 		if openaiErr != nil {
 			returnPreConsumedQuota(c, relayInfo, userQuota, preConsumedQuota)
 		}
 	}()
+
+	// 响应缓存命中时直接回放缓存内容，不请求上游
+	cache := &responseCache{}
+	if entry := lookupResponseCache(c, cache, relayInfo.OriginModelName, relayInfo.UserId, textRequest, relayInfo.RelayMode); entry != nil {
+		relayInfo.IsStream = entry.Stream
+		replayResponseCache(c, entry)
+		postConsumeQuota(c, relayInfo, &entry.Usage, preConsumedQuota, userQuota, priceData, "")
+		return nil
+	}
+	if cache.writer != nil {
+		defer cache.writer.Restore(c)
+	}
+
 	includeUsage := false
 	// 判断用户是否需要返回使用情况
 	if textRequest.StreamOptions != nil && textRequest.StreamOptions.IncludeUsage {
@@ -258,6 +284,12 @@ func TextHelper(c *gin.Context) (openaiErr *dto.OpenAIErrorWithStatusCode) {
 		}
 	}
 
+	// 记录最终回复用于保存对话历史
+	var capture *conversationCapture
+	if relayInfo.RelayMode == relayconstant.RelayModeChatCompletions {
+		capture = startConversationCapture(c, relaycommon.RelayFormatOpenAI)
+		defer capture.Restore(c)
+	}
 	usage, openaiErr := adaptor.DoResponse(c, httpResp, relayInfo)
 	if openaiErr != nil {
 		// reset status code 重置状态码
@@ -280,10 +312,13 @@ func TextHelper(c *gin.Context) (openaiErr *dto.OpenAIErrorWithStatusCode) {
 		postConsumeQuota(c, relayInfo, usage.(*dto.Usage), preConsumedQuota, userQuota, priceData, "")
 	}
 
-	// 保存对话历史 (仅对聊天完成模式且AI成功回复)
-	if relayInfo.RelayMode == relayconstant.RelayModeChatCompletions && textRequest != nil && openaiErr == nil {
-		saveConversationHistoryWithResponse(c, textRequest, relayInfo)
+	// 对冲请求中落选的渠道不保存对话历史
+	if hedge := service.GetHedgeGroup(c); hedge != nil && hedge.Cancelled(relayInfo.ChannelId) {
+		return nil
 	}
+	saveResponseCache(c, cache, relayInfo.IsStream, usage.(*dto.Usage))
+
+	capture.Save(c, relayInfo, textRequest, usage.(*dto.Usage))
 
 	return nil
 }
@@ -324,9 +359,41 @@ func checkRequestSensitive(textRequest *dto.GeneralOpenAIRequest, info *relaycom
 	return words, err
 }
 
+// getPromptQuota 按 prompt tokens 估算输入部分的额度，按次计费的模型返回单次价格
+func getPromptQuota(priceData helper.PriceData, promptTokens int) int {
+	if priceData.UsePrice {
+		return int(priceData.ModelPrice * common.QuotaPerUnit * priceData.GroupRatioInfo.GroupRatio)
+	}
+	return int(float64(promptTokens) * priceData.ModelRatio * priceData.GroupRatioInfo.GroupRatio)
+}
+
+// checkHedgeQuota 检查对冲请求中后发起的渠道能否继续请求，与预扣费使用相同的预算和余额检查，返回用户剩余配额
+func checkHedgeQuota(c *gin.Context, relayInfo *relaycommon.RelayInfo) (int, *dto.OpenAIErrorWithStatusCode) {
+	if openaiErr := service.CheckQuotaBudget(c, relayInfo, 0); openaiErr != nil {
+		return 0, openaiErr
+	}
+	userQuota, err := model.GetWalletQuota(relayInfo.UserId, relayInfo.OrganizationId)
+	if err != nil {
+		return 0, service.OpenAIErrorWrapperLocal(err, "get_user_quota_failed", http.StatusInternalServerError)
+	}
+	if userQuota <= 0 {
+		return 0, service.OpenAIErrorWrapperLocal(errors.New("user quota is not enough"), "insufficient_user_quota", http.StatusForbidden)
+	}
+	relayInfo.UserQuota = userQuota
+	return userQuota, nil
+}
+
 // 预扣费并返回用户剩余配额
 func preConsumeQuota(c *gin.Context, preConsumedQuota int, relayInfo *relaycommon.RelayInfo) (int, int, *dto.OpenAIErrorWithStatusCode) {
-	userQuota, err := model.GetUserQuota(relayInfo.UserId, false)
+	// 检查令牌和用户的周期预算
+	if openaiErr := service.CheckQuotaBudget(c, relayInfo, preConsumedQuota); openaiErr != nil {
+		return 0, 0, openaiErr
+	}
+	// 按 prompt tokens 检查令牌和用户的 TPM 限制
+	if openaiErr := service.ReserveTPM(c, relayInfo); openaiErr != nil {
+		return 0, 0, openaiErr
+	}
+	userQuota, err := model.GetWalletQuota(relayInfo.UserId, relayInfo.OrganizationId)
 	if err != nil {
 		return 0, 0, service.OpenAIErrorWrapperLocal(err, "get_user_quota_failed", http.StatusInternalServerError)
 	}
@@ -337,7 +404,8 @@ func preConsumeQuota(c *gin.Context, preConsumedQuota int, relayInfo *relaycommo
 		return 0, 0, service.OpenAIErrorWrapperLocal(fmt.Errorf("chat pre-consumed quota failed, user quota: %s, need quota: %s", common.FormatQuota(userQuota), common.FormatQuota(preConsumedQuota)), "insufficient_user_quota", http.StatusForbidden)
 	}
 	relayInfo.UserQuota = userQuota
-	if userQuota > 100*preConsumedQuota {
+	// 设置了周期预算上限时总是预扣，由扣费事务检查上限
+	if userQuota > 100*preConsumedQuota && !service.QuotaBudgetLimited(c, relayInfo) {
 		// 用户额度充足，判断令牌额度是否充足
 		if !relayInfo.TokenUnlimited {
 			// 非无限令牌，判断令牌额度是否充足
@@ -357,11 +425,33 @@ func preConsumeQuota(c *gin.Context, preConsumedQuota int, relayInfo *relaycommo
 
 	if preConsumedQuota > 0 {
 		err := service.PreConsumeTokenQuota(relayInfo, preConsumedQuota)
+		if errors.Is(err, model.ErrQuotaBudgetExceeded) {
+			return 0, 0, service.OpenAIErrorWrapperLocal(err, "token_budget_exceeded", http.StatusForbidden)
+		}
 		if err != nil {
 			return 0, 0, service.OpenAIErrorWrapperLocal(err, "pre_consume_token_quota_failed", http.StatusForbidden)
 		}
-		err = model.DecreaseUserQuota(relayInfo.UserId, preConsumedQuota)
+		err = model.DecreaseWalletQuota(relayInfo.UserId, relayInfo.OrganizationId, preConsumedQuota, model.QuotaMovement{
+			Reason:        model.QuotaReasonConsume,
+			RequestId:     relayInfo.RequestId,
+			TokenId:       relayInfo.TokenId,
+			Remark:        "预扣",
+			EnforceBudget: true,
+		})
 		if err != nil {
+			// 退还已从令牌扣除的额度
+			if !relayInfo.IsPlayground {
+				if returnErr := model.IncreaseTokenQuota(relayInfo.TokenId, relayInfo.TokenKey, preConsumedQuota, model.QuotaMovement{
+					Reason:    model.QuotaReasonRefund,
+					RequestId: relayInfo.RequestId,
+					UserId:    relayInfo.UserId,
+				}); returnErr != nil {
+					common.SysError("error return pre-consumed token quota: " + returnErr.Error())
+				}
+			}
+			if errors.Is(err, model.ErrQuotaBudgetExceeded) {
+				return 0, 0, service.OpenAIErrorWrapperLocal(err, "user_budget_exceeded", http.StatusForbidden)
+			}
 			return 0, 0, service.OpenAIErrorWrapperLocal(err, "decrease_user_quota_failed", http.StatusInternalServerError)
 		}
 	}
@@ -373,7 +463,7 @@ func returnPreConsumedQuota(c *gin.Context, relayInfo *relaycommon.RelayInfo, us
 		gopool.Go(func() {
 			relayInfoCopy := *relayInfo
 
-			err := service.PostConsumeQuota(&relayInfoCopy, -preConsumedQuota, 0, false)
+			err := service.ReturnPreConsumedQuota(&relayInfoCopy, preConsumedQuota)
 			if err != nil {
 				common.SysError("error return pre-consumed quota: " + err.Error())
 			}
@@ -512,6 +602,7 @@ func postConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo,
 
 	quota := int(quotaCalculateDecimal.Round(0).IntPart())
 	totalTokens := promptTokens + completionTokens
+	service.SettleTPM(ctx, totalTokens)
 
 	var logContent string
 	if !priceData.UsePrice {
@@ -520,6 +611,25 @@ func postConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo,
 		logContent = fmt.Sprintf("模型价格 %.2f，分组倍率 %.2f", modelPrice, groupRatio)
 	}
 
+	// 对冲请求中落选的渠道不计费，只在日志中记录上游消耗
+	hedgeLoser := false
+	upstreamQuota := quota
+	if hedge := service.GetHedgeGroup(ctx); hedge != nil && !hedge.Claim(relayInfo.ChannelId) {
+		hedgeLoser = true
+		quota = 0
+		logContent += "，对冲请求落选，不计费"
+	}
+
+	// 响应缓存命中时按分组的缓存计费比例计费
+	responseCacheHit := ctx.GetBool(constant.ContextKeyResponseCacheHit)
+	var responseCacheRatio float64
+	if responseCacheHit {
+		responseCacheRatio = operation_setting.GetResponseCacheHitQuotaRatio(relayInfo.Group)
+		upstreamQuota = quota
+		quota = int(decimal.NewFromInt(int64(quota)).Mul(decimal.NewFromFloat(responseCacheRatio)).Round(0).IntPart())
+		logContent += fmt.Sprintf("，响应缓存命中，缓存计费比例 %.2f", responseCacheRatio)
+	}
+
 	// record all the consume log even if quota is 0
 	if totalTokens == 0 {
 		// in this case, must be some error happened
@@ -528,7 +638,10 @@ func postConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo,
 		logContent += fmt.Sprintf("（可能是上游超时）")
 		common.LogError(ctx, fmt.Sprintf("total tokens is 0, cannot consume quota, userId %d, channelId %d, "+
 			"tokenId %d, model %s， pre-consumed quota %d", relayInfo.UserId, relayInfo.ChannelId, relayInfo.TokenId, modelName, preConsumedQuota))
-	} else {
+	} else if responseCacheHit {
+		// 缓存命中没有使用渠道
+		model.UpdateUserUsedQuotaAndRequestCount(relayInfo.UserId, quota)
+	} else if !hedgeLoser {
 		model.UpdateUserUsedQuotaAndRequestCount(relayInfo.UserId, quota)
 		model.UpdateChannelUsedQuota(relayInfo.ChannelId, quota)
 		model.UpdateChannelUsedCount(relayInfo.ChannelId, 1)
@@ -555,6 +668,9 @@ func postConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo,
 		logContent += ", " + extraContent
 	}
 	other := service.GenerateTextOtherInfo(ctx, relayInfo, modelRatio, groupRatio, completionRatio, cacheTokens, cacheRatio, modelPrice, priceData.GroupRatioInfo.GroupSpecialRatio)
+	if priceData.GroupRatioInfo.OverageRatio != 0 {
+		other["subscription_overage_ratio"] = priceData.GroupRatioInfo.OverageRatio
+	}
 	if imageTokens != 0 {
 		other["image"] = true
 		other["image_ratio"] = imageRatio
@@ -580,93 +696,42 @@ func postConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo,
 			other["file_search_price"] = fileSearchPrice
 		}
 	}
+	if hedgeLoser {
+		other["hedge_loser"] = true
+		other["upstream_quota"] = upstreamQuota
+	}
+	if responseCacheHit {
+		other["response_cache_hit"] = true
+		other["response_cache_ratio"] = responseCacheRatio
+		other["upstream_quota"] = upstreamQuota
+	}
 	if !audioInputQuota.IsZero() {
 		other["audio_input_seperate_price"] = true
 		other["audio_input_token_count"] = audioTokens
 		other["audio_input_price"] = audioInputPrice
 	}
-	model.RecordConsumeLog(ctx, relayInfo.UserId, relayInfo.ChannelId, promptTokens, completionTokens, logModel,
-		tokenName, quota, logContent, relayInfo.TokenId, userQuota, int(useTimeSeconds), relayInfo.IsStream, relayInfo.Group, other)
-}
-
-// saveConversationHistoryWithResponse 保存包含AI回复的对话历史
-func saveConversationHistoryWithResponse(c *gin.Context, textRequest *dto.GeneralOpenAIRequest, relayInfo *relaycommon.RelayInfo) {
-	// 检查是否启用对话历史存储
-	if !common.ConversationHistoryEnabled {
-		return
-	}
-
-	// 生成对话ID，优先从请求头获取，如果没有则使用用户ID+时间戳生成
-	conversationId := c.GetHeader("X-Conversation-ID")
-	if conversationId == "" {
-		conversationId = c.GetString("conversation_id")
-	}
-	if conversationId == "" {
-		conversationId = fmt.Sprintf("conv_%d_%d", relayInfo.UserId, time.Now().Unix())
-	}
-
-	// 从响应中获取AI回复内容
-	aiResponse := extractAIResponseFromContext(c)
-	if aiResponse == nil || aiResponse.Content == "" {
-		// 如果没有AI回复内容或内容为空，则不保存
-		return
+	logChannelId := relayInfo.ChannelId
+	if responseCacheHit {
+		logChannelId = 0
 	}
-
-	// 构建完整的对话消息列表
-	messages := make([]dto.Message, len(textRequest.Messages))
-	copy(messages, textRequest.Messages)
-
-	// 添加AI的回复消息
-	aiMessage := dto.Message{
-		Role: "assistant",
-	}
-	aiMessage.SetStringContent(aiResponse.Content)
-
-	// 如果有思考内容，添加到消息中
-	if aiResponse.ReasoningContent != "" {
-		aiMessage.ReasoningContent = aiResponse.ReasoningContent
-	}
-
-	messages = append(messages, aiMessage)
-
-	// 构建要保存的JSON数据
-	conversationData := map[string]interface{}{
-		"messages": messages,
-		"model":    relayInfo.OriginModelName,
-	}
-
-	// 将数据转换为JSON字符串
-	jsonData, err := json.Marshal(conversationData)
-	if err != nil {
-		common.LogError(c, "failed to marshal conversation data: "+err.Error())
-		return
-	}
-
-	// 异步保存对话历史，避免影响主流程性能
-	gopool.Go(func() {
-		err := model.CreateConversationHistory(c, conversationId, relayInfo.OriginModelName, string(jsonData), relayInfo.UserId)
-		if err != nil {
-			common.LogError(c, "failed to save conversation history: "+err.Error())
-		}
-	})
+	model.RecordConsumeLog(ctx, relayInfo.UserId, logChannelId, promptTokens, completionTokens, logModel,
+		tokenName, quota, logContent, relayInfo.TokenId, userQuota, int(useTimeSeconds), relayInfo.IsStream, relayInfo.Group, other)
 }
 
 // saveErrorConversationHistory 保存错误对话历史
 func saveErrorConversationHistory(c *gin.Context, textRequest *dto.GeneralOpenAIRequest, relayInfo *relaycommon.RelayInfo, errorMessage string, errorCode string) {
-	// 检查是否启用对话历史存储
-	if !common.ConversationHistoryEnabled {
+	// 按令牌、用户、分组的存储策略决定是否保存
+	policy := service.GetConversationHistoryPolicy(c)
+	if !service.ShouldSaveConversationHistory(policy, true) {
 		return
 	}
-
-	// 生成对话ID，优先从请求头获取，如果没有则使用用户ID+时间戳生成
-	conversationId := c.GetHeader("X-Conversation-ID")
-	if conversationId == "" {
-		conversationId = c.GetString("conversation_id")
-	}
-	if conversationId == "" {
-		conversationId = fmt.Sprintf("conv_error_%d_%d", relayInfo.UserId, time.Now().Unix())
+	// 对冲请求中被取消的渠道不保存错误历史
+	if hedge := service.GetHedgeGroup(c); hedge != nil && hedge.Cancelled(relayInfo.ChannelId) {
+		return
 	}
 
+	conversationId := getConversationId(c, "conv_error", relayInfo.UserId)
+
 	// 构建要保存的JSON数据，只包含原始对话内容，不包含错误信息
 	conversationData := map[string]interface{}{
 		"messages": textRequest.Messages,
@@ -682,61 +747,15 @@ func saveErrorConversationHistory(c *gin.Context, textRequest *dto.GeneralOpenAI
 
 	// 异步保存错误对话历史，避免影响主流程性能
 	gopool.Go(func() {
-		err := model.CreateErrorConversationHistory(c, conversationId, relayInfo.OriginModelName, string(jsonData), errorMessage, errorCode, relayInfo.UserId)
+		// 保存前脱敏
+		rawJson, err := service.RedactHistoryJson(string(jsonData))
+		if err != nil {
+			common.LogError(c, "failed to redact error conversation data: "+err.Error())
+			return
+		}
+		err = model.CreateErrorConversationHistory(c, conversationId, relayInfo.OriginModelName, rawJson, service.RedactHistoryText(errorMessage), errorCode, relayInfo.UserId, policy.RetentionDays)
 		if err != nil {
 			common.LogError(c, "failed to save error conversation history: "+err.Error())
 		}
 	})
 }
-
-// extractAIResponseFromContext 从上下文中提取AI回复内容
-func extractAIResponseFromContext(c *gin.Context) *openai.AIResponseContent {
-	// 尝试从上下文中获取响应数据
-	if responseData, exists := c.Get("ai_response_content"); exists {
-		if content, ok := responseData.(*openai.AIResponseContent); ok {
-			return content
-		}
-	}
-
-	// 如果上下文中没有，尝试从响应体中解析
-	if responseBody, exists := c.Get("response_body"); exists {
-		if bodyBytes, ok := responseBody.([]byte); ok {
-			return parseAIResponseFromBody(bodyBytes)
-		}
-	}
-
-	return nil
-}
-
-// parseAIResponseFromBody 从响应体中解析AI回复内容
-func parseAIResponseFromBody(responseBody []byte) *openai.AIResponseContent {
-	var openaiResponse dto.OpenAITextResponse
-	if err := json.Unmarshal(responseBody, &openaiResponse); err != nil {
-		return nil
-	}
-
-	if len(openaiResponse.Choices) == 0 {
-		return nil
-	}
-
-	choice := openaiResponse.Choices[0]
-	content := choice.Message.StringContent()
-
-	// 检查是否有内容
-	if content == "" {
-		return nil
-	}
-
-	result := &openai.AIResponseContent{
-		Content: content,
-	}
-
-	// 检查思考内容，支持两种字段名
-	if choice.Message.ReasoningContent != "" {
-		result.ReasoningContent = choice.Message.ReasoningContent
-	} else if choice.Message.Reasoning != "" {
-		result.ReasoningContent = choice.Message.Reasoning
-	}
-
-	return result
-}
@@ -54,7 +54,7 @@ func RelayTaskSubmit(c *gin.Context, relayMode int) (taskErr *dto.TaskError) {
 	// 预扣
 	groupRatio := ratio_setting.GetGroupRatio(relayInfo.Group)
 	ratio := modelPrice * groupRatio
-	userQuota, err := model.GetUserQuota(relayInfo.UserId, false)
+	userQuota, err := model.GetWalletQuota(relayInfo.UserId, relayInfo.OrganizationId)
 	if err != nil {
 		taskErr = service.TaskErrorWrapper(err, "get_user_quota_failed", http.StatusInternalServerError)
 		return
@@ -75,24 +75,57 @@ func RelayTaskSubmit(c *gin.Context, relayMode int) (taskErr *dto.TaskError) {
 			taskErr = service.TaskErrorWrapperLocal(errors.New("task_origin_not_exist"), "task_not_exist", http.StatusBadRequest)
 			return
 		}
+		channel, err := model.GetChannelById(originTask.ChannelId, true)
+		if err != nil {
+			taskErr = service.TaskErrorWrapperLocal(err, "channel_not_found", http.StatusBadRequest)
+			return
+		}
 		if originTask.ChannelId != relayInfo.ChannelId {
-			channel, err := model.GetChannelById(originTask.ChannelId, true)
-			if err != nil {
-				taskErr = service.TaskErrorWrapperLocal(err, "channel_not_found", http.StatusBadRequest)
-				return
-			}
 			if channel.Status != common.ChannelStatusEnabled {
 				return service.TaskErrorWrapperLocal(errors.New("该任务所属渠道已被禁用"), "task_channel_disable", http.StatusBadRequest)
 			}
 			c.Set("base_url", channel.GetBaseURL())
 			c.Set("channel_id", originTask.ChannelId)
-			c.Request.Header.Set("Authorization", fmt.Sprintf("Bearer %s", channel.Key))
 
 			relayInfo.BaseUrl = channel.GetBaseURL()
 			relayInfo.ChannelId = originTask.ChannelId
 		}
+		// 基于原任务的操作需要使用提交原任务时的密钥
+		key, err := channel.GetKeyByIndex(originTask.KeyIndex)
+		if err != nil {
+			taskErr = service.TaskErrorWrapperLocal(err, "channel_key_not_found", http.StatusServiceUnavailable)
+			return
+		}
+		c.Set("channel_key_index", originTask.KeyIndex)
+		c.Request.Header.Set("Authorization", fmt.Sprintf("Bearer %s", key))
+		relayInfo.ApiKey = key
+		relayInfo.ChannelKeyIndex = originTask.KeyIndex
 	}
 
+	// 提交前扣费，扣费受周期预算上限约束，提交失败时退还
+	if err = service.PreConsumeTaskQuota(c, relayInfo.RelayInfo, quota); err != nil {
+		taskErr = service.TaskErrorWrapperLocal(err, "pre_consume_quota_failed", http.StatusForbidden)
+		return
+	}
+	defer func() {
+		if !relayInfo.ConsumeQuota || taskErr != nil {
+			returnTaskQuota(relayInfo.RelayInfo, quota)
+			return
+		}
+		if quota != 0 {
+			tokenName := c.GetString("token_name")
+			logContent := fmt.Sprintf("模型固定价格 %.2f，分组倍率 %.2f，操作 %s", modelPrice, groupRatio, relayInfo.Action)
+			other := make(map[string]interface{})
+			other["model_price"] = modelPrice
+			other["group_ratio"] = groupRatio
+			model.RecordConsumeLog(c, relayInfo.UserId, relayInfo.ChannelId, 0, 0,
+				modelName, tokenName, quota, logContent, relayInfo.TokenId, userQuota, 0, false, relayInfo.Group, other)
+			model.UpdateUserUsedQuotaAndRequestCount(relayInfo.UserId, quota)
+			model.UpdateChannelUsedQuota(relayInfo.ChannelId, quota)
+			model.UpdateChannelUsedCount(relayInfo.ChannelId, 1)
+		}
+	}()
+
 	// build body
 	requestBody, err := adaptor.BuildRequestBody(c, relayInfo)
 	if err != nil {
@@ -112,29 +145,6 @@ func RelayTaskSubmit(c *gin.Context, relayMode int) (taskErr *dto.TaskError) {
 		return
 	}
 
-	defer func() {
-		// release quota
-		if relayInfo.ConsumeQuota && taskErr == nil {
-
-			err := service.PostConsumeQuota(relayInfo.RelayInfo, quota, 0, true)
-			if err != nil {
-				common.SysError("error consuming token remain quota: " + err.Error())
-			}
-			if quota != 0 {
-				tokenName := c.GetString("token_name")
-				logContent := fmt.Sprintf("模型固定价格 %.2f，分组倍率 %.2f，操作 %s", modelPrice, groupRatio, relayInfo.Action)
-				other := make(map[string]interface{})
-				other["model_price"] = modelPrice
-				other["group_ratio"] = groupRatio
-				model.RecordConsumeLog(c, relayInfo.UserId, relayInfo.ChannelId, 0, 0,
-					modelName, tokenName, quota, logContent, relayInfo.TokenId, userQuota, 0, false, relayInfo.Group, other)
-				model.UpdateUserUsedQuotaAndRequestCount(relayInfo.UserId, quota)
-				model.UpdateChannelUsedQuota(relayInfo.ChannelId, quota)
-				model.UpdateChannelUsedCount(relayInfo.ChannelId, 1)
-			}
-		}
-	}()
-
 	taskID, taskData, taskErr := adaptor.DoResponse(c, resp, relayInfo)
 	if taskErr != nil {
 		return
@@ -266,3 +276,13 @@ func TaskModel2Dto(task *model.Task) *dto.TaskDto {
 		Data:       task.Data,
 	}
 }
+
+// returnTaskQuota 按次计费的任务提交失败时退还提交前扣除的额度
+func returnTaskQuota(relayInfo *relaycommon.RelayInfo, quota int) {
+	if quota <= 0 {
+		return
+	}
+	if err := service.ReturnPreConsumedQuota(relayInfo, quota); err != nil {
+		common.SysError("error return task quota: " + err.Error())
+	}
+}
@@ -0,0 +1,102 @@
+package relay
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"one-api/common"
+	"one-api/constant"
+	"one-api/dto"
+	"one-api/relay/helper"
+	"one-api/service"
+	"one-api/setting/operation_setting"
+	"strings"
+
+	"github.com/bytedance/gopkg/util/gopool"
+	"github.com/gin-gonic/gin"
+)
+
+// responseCache 一次文本请求的响应缓存状态，key 为空表示本次请求不使用缓存
+type responseCache struct {
+	key    string
+	ttl    int
+	writer *helper.CaptureWriter
+}
+
+// lookupResponseCache 查询响应缓存，命中时返回缓存内容；未命中时记录响应以便请求成功后写入缓存
+func lookupResponseCache(c *gin.Context, cache *responseCache, modelName string, userId int, textRequest *dto.GeneralOpenAIRequest, relayMode int) *service.ResponseCacheEntry {
+	// 音频模型使用单独的计费流程，不参与缓存
+	if strings.HasPrefix(modelName, "gpt-4o-audio") {
+		return nil
+	}
+	cache.ttl = service.GetResponseCacheTTL(c, relayMode, textRequest)
+	if cache.ttl <= 0 {
+		return nil
+	}
+	key, err := service.GetResponseCacheKey(userId, modelName, textRequest)
+	if err != nil {
+		common.LogError(c, "failed to generate response cache key: "+err.Error())
+		return nil
+	}
+	cache.key = key
+	// Cache-Control: no-cache 跳过缓存读取，但仍然刷新缓存
+	if !strings.Contains(c.GetHeader("Cache-Control"), "no-cache") {
+		entry, err := service.GetResponseCache(key)
+		if err != nil {
+			common.LogError(c, "failed to get response cache: "+err.Error())
+		}
+		if entry != nil && entry.Stream == textRequest.Stream {
+			return entry
+		}
+	}
+	c.Header("X-Response-Cache", "MISS")
+	cache.writer = helper.NewCaptureWriter(c, operation_setting.GetResponseCacheSetting().MaxBodyKB*1024)
+	return nil
+}
+
+// replayResponseCache 将缓存的响应写回客户端，流式响应按原始 SSE 数据回放
+func replayResponseCache(c *gin.Context, entry *service.ResponseCacheEntry) {
+	c.Set(constant.ContextKeyResponseCacheHit, true)
+	c.Header("X-Response-Cache", "HIT")
+	c.Header("X-Response-Cache-Age", fmt.Sprintf("%d", common.GetTimestamp()-entry.CreatedTime))
+	if entry.Stream {
+		helper.SetEventStreamHeaders(c)
+		c.Status(http.StatusOK)
+		_, _ = c.Writer.WriteString(entry.Body)
+		c.Writer.Flush()
+		return
+	}
+	c.Data(http.StatusOK, "application/json", []byte(entry.Body))
+}
+
+// saveResponseCache 请求成功后异步写入缓存，只缓存完整的响应
+func saveResponseCache(c *gin.Context, cache *responseCache, stream bool, usage *dto.Usage) {
+	if cache.writer == nil || usage == nil || usage.TotalTokens == 0 {
+		return
+	}
+	body, ok := cache.writer.Body()
+	if !ok || c.Writer.Status() != http.StatusOK {
+		return
+	}
+	if stream {
+		// 客户端中途断开时流可能不完整
+		if !bytes.Contains(body, []byte("data: [DONE]")) {
+			return
+		}
+	} else if !json.Valid(body) {
+		return
+	}
+	entry := &service.ResponseCacheEntry{
+		Stream:      stream,
+		Body:        string(body),
+		Usage:       *usage,
+		CreatedTime: common.GetTimestamp(),
+	}
+	key, ttl := cache.key, cache.ttl
+	gopool.Go(func() {
+		if err := service.SaveResponseCache(key, entry, ttl); err != nil {
+			common.SysError("failed to save response cache: " + err.Error())
+		}
+	})
+}
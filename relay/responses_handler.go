@@ -94,16 +94,26 @@ func ResponsesHelper(c *gin.Context) (openaiErr *dto.OpenAIErrorWithStatusCode)
 	if adaptor == nil {
 		return service.OpenAIErrorWrapperLocal(fmt.Errorf("invalid api type: %d", relayInfo.ApiType), "invalid_api_type", http.StatusBadRequest)
 	}
+	// 渠道不支持 Responses API 时经由 Chat Completions 格式转换
+	translated := !helper.IsNativeRelayFormat(relayInfo, relaycommon.RelayFormatOpenAIResponses)
+	if translated {
+		useOpenAIFormat(relayInfo)
+	}
 	adaptor.Init(relayInfo)
 	var requestBody io.Reader
-	if model_setting.GetGlobalSettings().PassThroughRequestEnabled {
+	if model_setting.GetGlobalSettings().PassThroughRequestEnabled && !translated {
 		body, err := common.GetRequestBody(c)
 		if err != nil {
 			return service.OpenAIErrorWrapperLocal(err, "get_request_body_error", http.StatusInternalServerError)
 		}
 		requestBody = bytes.NewBuffer(body)
 	} else {
-		convertedRequest, err := adaptor.ConvertOpenAIResponsesRequest(c, relayInfo, *req)
+		var convertedRequest any
+		if translated {
+			convertedRequest, err = convertResponsesViaOpenAI(c, adaptor, relayInfo, req)
+		} else {
+			convertedRequest, err = adaptor.ConvertOpenAIResponsesRequest(c, relayInfo, *req)
+		}
 		if err != nil {
 			return service.OpenAIErrorWrapperLocal(err, "convert_request_error", http.StatusBadRequest)
 		}
@@ -152,17 +162,31 @@ func ResponsesHelper(c *gin.Context) (openaiErr *dto.OpenAIErrorWithStatusCode)
 		}
 	}
 
+	// 记录最终回复用于保存对话历史，需要在协议转换之前安装
+	capture := startConversationCapture(c, relaycommon.RelayFormatOpenAIResponses)
+	defer capture.Restore(c)
+	var translateWriter *helper.TranslateWriter
+	if translated {
+		translateWriter = helper.NewTranslateWriter(c, service.NewResponsesTranslator(relayInfo))
+		defer translateWriter.Restore(c)
+	}
 	usage, openaiErr := adaptor.DoResponse(c, httpResp, relayInfo)
 	if openaiErr != nil {
 		// reset status code 重置状态码
 		service.ResetStatusCode(openaiErr, statusCodeMappingStr)
 		return openaiErr
 	}
+	if translateWriter != nil {
+		if err := translateWriter.Finish(c, usage.(*dto.Usage)); err != nil {
+			common.LogError(c, "translate response failed: "+err.Error())
+		}
+	}
 
 	if strings.HasPrefix(relayInfo.OriginModelName, "gpt-4o-audio") {
 		service.PostAudioConsumeQuota(c, relayInfo, usage.(*dto.Usage), preConsumedQuota, userQuota, priceData, "")
 	} else {
 		postConsumeQuota(c, relayInfo, usage.(*dto.Usage), preConsumedQuota, userQuota, priceData, "")
 	}
+	capture.Save(c, relayInfo, req, usage.(*dto.Usage))
 	return nil
 }
@@ -45,6 +45,8 @@ func SetApiRouter(router *gin.Engine) {
 			//userRoute.POST("/tokenlog", middleware.CriticalRateLimit(), controller.TokenLog)
 			userRoute.GET("/logout", controller.Logout)
 			userRoute.GET("/epay/notify", controller.EpayNotify)
+			userRoute.GET("/payment/:provider/notify", controller.PaymentNotify)
+			userRoute.POST("/payment/:provider/notify", controller.PaymentNotify)
 			userRoute.GET("/groups", controller.GetUserGroups)
 
 			selfRoute := userRoute.Group("/")
@@ -58,8 +60,9 @@ func SetApiRouter(router *gin.Engine) {
 				selfRoute.GET("/token", controller.GenerateAccessToken)
 				selfRoute.GET("/aff", controller.GetAffCode)
 				selfRoute.POST("/topup", controller.TopUp)
-				selfRoute.POST("/pay", controller.RequestEpay)
+				selfRoute.POST("/pay", controller.RequestPayment)
 				selfRoute.POST("/amount", controller.RequestAmount)
+				selfRoute.POST("/subscribe", controller.RequestSubscriptionPayment)
 				selfRoute.POST("/aff_transfer", controller.TransferAffQuota)
 				selfRoute.PUT("/setting", controller.UpdateUserSetting)
 			}
@@ -115,6 +118,9 @@ func SetApiRouter(router *gin.Engine) {
 			channelRoute.POST("/fetch_models", controller.FetchModels)
 			channelRoute.POST("/batch/tag", controller.BatchSetChannelTag)
 			channelRoute.GET("/tag/models", controller.GetTagModels)
+			channelRoute.GET("/:id/keys", controller.GetChannelKeys)
+			channelRoute.PUT("/:id/keys", controller.UpdateChannelKeyStatus)
+			channelRoute.POST("/:id/breaker/reset", controller.ResetChannelBreaker)
 		}
 		tokenRoute := apiRouter.Group("/token")
 		tokenRoute.Use(middleware.UserAuth())
@@ -146,6 +152,48 @@ func SetApiRouter(router *gin.Engine) {
 		logRoute.GET("/self", middleware.UserAuth(), controller.GetUserLogs)
 		logRoute.GET("/self/search", middleware.UserAuth(), controller.SearchUserLogs)
 
+		ledgerRoute := apiRouter.Group("/ledger")
+		ledgerRoute.GET("/", middleware.AdminAuth(), controller.GetQuotaLedgers)
+		ledgerRoute.GET("/self", middleware.UserAuth(), controller.GetUserQuotaLedgers)
+		ledgerRoute.GET("/reconcile", middleware.RootAuth(), controller.GetQuotaReconciliation)
+		ledgerRoute.POST("/reconcile", middleware.RootAuth(), controller.ReconcileQuotaLedger)
+
+		organizationRoute := apiRouter.Group("/organization")
+		organizationRoute.GET("/", middleware.AdminAuth(), controller.GetAllOrganizations)
+		organizationRoute.PUT("/:id/quota", middleware.AdminAuth(), controller.UpdateOrganizationQuota)
+		organizationRoute.GET("/self", middleware.UserAuth(), controller.GetSelfOrganizations)
+		organizationRoute.POST("/", middleware.UserAuth(), controller.CreateOrganization)
+		organizationRoute.GET("/:id", middleware.UserAuth(), controller.GetOrganization)
+		organizationRoute.PUT("/:id", middleware.UserAuth(), controller.UpdateOrganization)
+		organizationRoute.DELETE("/:id", middleware.UserAuth(), controller.DeleteOrganization)
+		organizationRoute.GET("/:id/members", middleware.UserAuth(), controller.GetOrganizationMembers)
+		organizationRoute.POST("/:id/members", middleware.UserAuth(), controller.AddOrganizationMember)
+		organizationRoute.PUT("/:id/members", middleware.UserAuth(), controller.UpdateOrganizationMember)
+		organizationRoute.DELETE("/:id/members/:user_id", middleware.UserAuth(), controller.RemoveOrganizationMember)
+		organizationRoute.POST("/:id/transfer", middleware.UserAuth(), controller.TransferQuotaToOrganization)
+		organizationRoute.GET("/:id/usage", middleware.UserAuth(), controller.GetOrganizationUsage)
+		organizationRoute.GET("/:id/tokens", middleware.UserAuth(), controller.GetOrganizationTokens)
+		organizationRoute.DELETE("/:id/tokens/:token_id", middleware.UserAuth(), controller.DeleteOrganizationToken)
+		organizationRoute.GET("/:id/ledger", middleware.UserAuth(), controller.GetOrganizationLedgers)
+
+		topUpRoute := apiRouter.Group("/topup")
+		topUpRoute.Use(middleware.AdminAuth())
+		{
+			topUpRoute.GET("/", controller.GetAllTopUps)
+			topUpRoute.POST("/:trade_no/sync", controller.SyncTopUp)
+			topUpRoute.POST("/:trade_no/refund", controller.RefundTopUp)
+		}
+
+		subscriptionRoute := apiRouter.Group("/subscription")
+		subscriptionRoute.GET("/plans", middleware.UserAuth(), controller.GetSubscriptionPlans)
+		subscriptionRoute.GET("/self", middleware.UserAuth(), controller.GetSelfSubscriptions)
+		subscriptionRoute.PUT("/self", middleware.UserAuth(), controller.UpdateSelfSubscription)
+		subscriptionRoute.GET("/", middleware.AdminAuth(), controller.GetAllSubscriptions)
+		subscriptionRoute.GET("/plan/", middleware.AdminAuth(), controller.GetAllSubscriptionPlans)
+		subscriptionRoute.POST("/plan/", middleware.AdminAuth(), controller.AddSubscriptionPlan)
+		subscriptionRoute.PUT("/plan/", middleware.AdminAuth(), controller.UpdateSubscriptionPlan)
+		subscriptionRoute.DELETE("/plan/:id", middleware.AdminAuth(), controller.DeleteSubscriptionPlan)
+
 		dataRoute := apiRouter.Group("/data")
 		dataRoute.GET("/", middleware.AdminAuth(), controller.GetAllQuotaDates)
 		dataRoute.GET("/self", middleware.UserAuth(), controller.GetUserQuotaDates)
@@ -174,14 +222,27 @@ func SetApiRouter(router *gin.Engine) {
 		{
 			// 用户路由
 			conversationRoute.GET("/history", middleware.UserAuth(), controller.GetConversationHistories)
+			conversationRoute.GET("/history/export", middleware.UserAuth(), controller.ExportConversationHistories)
 			conversationRoute.GET("/history/:id", middleware.UserAuth(), controller.GetConversationHistory)
 			conversationRoute.DELETE("/history/:id", middleware.UserAuth(), controller.DeleteConversationHistory)
 			conversationRoute.DELETE("/history/conversation/:conversation_id", middleware.UserAuth(), controller.DeleteConversationHistoriesByConversationId)
 			
 			// 管理员路由
 			conversationRoute.GET("/admin/history", middleware.AdminAuth(), controller.AdminGetConversationHistories)
+			conversationRoute.GET("/admin/history/export", middleware.AdminAuth(), controller.AdminExportConversationHistories)
 			conversationRoute.DELETE("/admin/history/:id", middleware.AdminAuth(), controller.AdminDeleteConversationHistory)
 			conversationRoute.POST("/admin/cleanup", middleware.AdminAuth(), controller.CleanupOldConversationHistories)
+			conversationRoute.POST("/admin/error-history/replay", middleware.AdminAuth(), controller.BatchReplayErrorConversationHistories)
+			conversationRoute.POST("/admin/error-history/:id/replay", middleware.AdminAuth(), controller.ReplayErrorConversationHistory)
+			conversationRoute.GET("/admin/archives", middleware.RootAuth(), controller.GetHistoryArchives)
+			conversationRoute.POST("/admin/archives", middleware.RootAuth(), controller.ArchiveHistoryPartitions)
+			conversationRoute.POST("/admin/archives/:id/restore", middleware.RootAuth(), controller.RestoreHistoryArchive)
+			conversationRoute.GET("/admin/dedup", middleware.RootAuth(), controller.GetConversationDedupMigration)
+			conversationRoute.POST("/admin/dedup", middleware.RootAuth(), controller.StartConversationDedupMigration)
+			conversationRoute.GET("/admin/search-index", middleware.RootAuth(), controller.GetConversationSearchIndexJob)
+			conversationRoute.POST("/admin/search-index", middleware.RootAuth(), controller.StartConversationSearchIndexJob)
+			conversationRoute.GET("/admin/key-rotation", middleware.RootAuth(), controller.GetHistoryKeyRotation)
+			conversationRoute.POST("/admin/key-rotation", middleware.RootAuth(), controller.StartHistoryKeyRotation)
 		}
 	}
 }
@@ -24,6 +24,20 @@ func DisableChannel(channelId int, channelName string, reason string) {
 	}
 }
 
+// DisableChannelKey 禁用多密钥渠道中的单个密钥并通知
+func DisableChannelKey(channelId int, channelName string, keyIndex int, reason string) {
+	success, err := model.UpdateChannelKeyStatus(channelId, keyIndex, common.ChannelStatusAutoDisabled, reason)
+	if err != nil {
+		common.SysError(fmt.Sprintf("failed to disable key #%d of channel #%d: %s", keyIndex, channelId, err.Error()))
+		return
+	}
+	if success {
+		subject := fmt.Sprintf("通道「%s」（#%d）的密钥 #%d 已被禁用", channelName, channelId, keyIndex)
+		content := fmt.Sprintf("通道「%s」（#%d）的密钥 #%d 已被禁用，原因：%s", channelName, channelId, keyIndex, reason)
+		NotifyRootUser(fmt.Sprintf("%s_key_%d", formatNotifyType(channelId, common.ChannelStatusAutoDisabled), keyIndex), subject, content)
+	}
+}
+
 func EnableChannel(channelId int, channelName string) {
 	success := model.UpdateChannelStatusById(channelId, common.ChannelStatusEnabled, "")
 	if success {
@@ -0,0 +1,55 @@
+package service
+
+import (
+	"one-api/model"
+	"time"
+)
+
+var conversationDedupJob = &historyJob{name: "dedup migration"}
+
+// ConversationDedupMigration 对话历史去重迁移任务的进度，保留 converted 字段兼容已有的接口调用方
+type ConversationDedupMigration struct {
+	Running   bool     `json:"running"`
+	Total     int      `json:"total"`     // 需要迁移的分区数
+	Finished  []string `json:"finished"`  // 已完成的分区
+	Converted int64    `json:"converted"` // 已转换的记录数
+	Error     string   `json:"error"`
+	StartTime int64    `json:"start_time"`
+	EndTime   int64    `json:"end_time"`
+}
+
+// GetConversationDedupMigration 获取最近一次去重迁移任务的进度
+func GetConversationDedupMigration() ConversationDedupMigration {
+	status := conversationDedupJob.Status()
+	return ConversationDedupMigration{
+		Running:   status.Running,
+		Total:     status.Total,
+		Finished:  status.Finished,
+		Converted: status.Rows,
+		Error:     status.Error,
+		StartTime: status.StartTime,
+		EndTime:   status.EndTime,
+	}
+}
+
+// StartConversationDedupMigration 在后台将已有分区中完整存储的对话历史转换为去重存储，date 为零值时迁移所有分区
+func StartConversationDedupMigration(date time.Time) error {
+	partitions, err := model.GetMESPartitions(model.ConversationHistoryBaseTable)
+	if err != nil {
+		return err
+	}
+	var steps []historyJobStep
+	for _, partition := range partitions {
+		if !date.IsZero() && !partition.Date.Equal(date) {
+			continue
+		}
+		partition := partition
+		steps = append(steps, historyJobStep{
+			name: historyPartitionStepName(partition),
+			run: func() (int64, error) {
+				return model.DedupConversationHistoryPartition(partition, 500)
+			},
+		})
+	}
+	return conversationDedupJob.start(steps)
+}
@@ -0,0 +1,330 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"one-api/dto"
+	"one-api/model"
+	"strings"
+	"time"
+)
+
+const (
+	ConversationExportFormatOpenAI   = "openai"
+	ConversationExportFormatShareGPT = "sharegpt"
+	ConversationExportFormatMarkdown = "markdown"
+
+	// conversationExportBatchSize 每次从分表读取的记录数
+	conversationExportBatchSize = 200
+)
+
+// ConversationExportOptions 导出条件，IncludeSuccess / IncludeError 分别控制是否导出成功和失败的对话
+type ConversationExportOptions struct {
+	Filter         model.ConversationHistoryFilter
+	Format         string
+	IncludeSuccess bool
+	IncludeError   bool
+}
+
+// conversationExportRecord 导出的一条对话，同一对话只保留最新的记录，它包含了到此为止的完整消息
+type conversationExportRecord struct {
+	ConversationId string
+	ModelName      string
+	UserId         int
+	CreatedAt      time.Time
+	Messages       []dto.Message
+	ErrorCode      string
+	ErrorMessage   string
+}
+
+// conversationExportKey 对话ID由客户端传入，不同用户可能使用相同的ID，去重时需要同时区分用户
+type conversationExportKey struct {
+	UserId         int
+	ConversationId string
+}
+
+// conversationExporter 将对话逐条写入 writer，不同格式实现各自的开头、记录和结尾
+type conversationExporter interface {
+	begin(w io.Writer) error
+	write(w io.Writer, record *conversationExportRecord) error
+	end(w io.Writer) error
+}
+
+// ConversationExportContentType 返回导出格式的 Content-Type 和文件扩展名，格式不支持时返回 false
+func ConversationExportContentType(format string) (string, string, bool) {
+	switch format {
+	case ConversationExportFormatOpenAI:
+		return "application/x-ndjson; charset=utf-8", "jsonl", true
+	case ConversationExportFormatShareGPT:
+		return "application/json; charset=utf-8", "json", true
+	case ConversationExportFormatMarkdown:
+		return "text/markdown; charset=utf-8", "md", true
+	}
+	return "", "", false
+}
+
+func newConversationExporter(format string) (conversationExporter, error) {
+	switch format {
+	case ConversationExportFormatOpenAI:
+		return &openAIConversationExporter{}, nil
+	case ConversationExportFormatShareGPT:
+		return &shareGPTConversationExporter{}, nil
+	case ConversationExportFormatMarkdown:
+		return &markdownConversationExporter{}, nil
+	}
+	return nil, fmt.Errorf("unsupported export format: %s", format)
+}
+
+// ExportConversationHistories 按条件流式导出对话历史，从新到旧分批读取，同一对话只导出最新的一条记录。
+// flush 在每批记录写入后调用，用于将已生成的内容尽早发送给客户端。
+func ExportConversationHistories(w io.Writer, options ConversationExportOptions, flush func()) error {
+	exporter, err := newConversationExporter(options.Format)
+	if err != nil {
+		return err
+	}
+	if err = exporter.begin(w); err != nil {
+		return err
+	}
+	if options.IncludeSuccess {
+		if err = exportSuccessConversations(w, exporter, options.Filter, flush); err != nil {
+			return err
+		}
+	}
+	if options.IncludeError {
+		if err = exportErrorConversations(w, exporter, options.Filter, flush); err != nil {
+			return err
+		}
+	}
+	return exporter.end(w)
+}
+
+func exportSuccessConversations(w io.Writer, exporter conversationExporter, filter model.ConversationHistoryFilter, flush func()) error {
+	seen := make(map[conversationExportKey]bool)
+	var cursor *model.ConversationHistoryCursor
+	for {
+		histories, next, err := model.QueryConversationHistories(filter, cursor, conversationExportBatchSize)
+		if err != nil {
+			return err
+		}
+		for _, history := range histories {
+			key := conversationExportKey{UserId: history.UserId, ConversationId: history.ConversationId}
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			record, err := newConversationExportRecord(history.ConversationId, history.ModelName, history.UserId, history.CreatedAt, history.RawJson)
+			if err != nil {
+				continue
+			}
+			if err = exporter.write(w, record); err != nil {
+				return err
+			}
+		}
+		flush()
+		if next == nil {
+			return nil
+		}
+		cursor = next
+	}
+}
+
+func exportErrorConversations(w io.Writer, exporter conversationExporter, filter model.ConversationHistoryFilter, flush func()) error {
+	seen := make(map[conversationExportKey]bool)
+	var cursor *model.ConversationHistoryCursor
+	for {
+		histories, next, err := model.QueryErrorConversationHistories(filter, cursor, conversationExportBatchSize)
+		if err != nil {
+			return err
+		}
+		for _, history := range histories {
+			key := conversationExportKey{UserId: history.UserId, ConversationId: history.ConversationId}
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			record, err := newConversationExportRecord(history.ConversationId, history.ModelName, history.UserId, history.CreatedAt, history.RawJson)
+			if err != nil {
+				continue
+			}
+			record.ErrorCode = history.ErrorCode
+			record.ErrorMessage = history.ErrorMessage
+			if err = exporter.write(w, record); err != nil {
+				return err
+			}
+		}
+		flush()
+		if next == nil {
+			return nil
+		}
+		cursor = next
+	}
+}
+
+// newConversationExportRecord 从 RawJson 中解析消息，无法解析的记录（例如无法解密的密文）会被跳过
+func newConversationExportRecord(conversationId string, modelName string, userId int, createdAt time.Time, rawJson string) (*conversationExportRecord, error) {
+	var data struct {
+		Messages []dto.Message `json:"messages"`
+	}
+	if err := json.Unmarshal([]byte(rawJson), &data); err != nil {
+		return nil, err
+	}
+	return &conversationExportRecord{
+		ConversationId: conversationId,
+		ModelName:      modelName,
+		UserId:         userId,
+		CreatedAt:      createdAt,
+		Messages:       data.Messages,
+	}, nil
+}
+
+// openAIConversationExporter OpenAI 对话微调格式，每行一个 {"messages": [...]}。
+// 只保留微调支持的字段，不包含助手回复的对话（例如失败的请求）不会导出。
+type openAIConversationExporter struct{}
+
+func (e *openAIConversationExporter) begin(w io.Writer) error {
+	return nil
+}
+
+func (e *openAIConversationExporter) write(w io.Writer, record *conversationExportRecord) error {
+	hasAssistant := false
+	messages := make([]dto.Message, 0, len(record.Messages))
+	for _, message := range record.Messages {
+		if message.Role == "assistant" {
+			hasAssistant = true
+		}
+		messages = append(messages, dto.Message{
+			Role:       message.Role,
+			Content:    message.Content,
+			Name:       message.Name,
+			ToolCalls:  message.ToolCalls,
+			ToolCallId: message.ToolCallId,
+		})
+	}
+	if !hasAssistant {
+		return nil
+	}
+	line, err := json.Marshal(map[string]interface{}{"messages": messages})
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(line, '\n'))
+	return err
+}
+
+func (e *openAIConversationExporter) end(w io.Writer) error {
+	return nil
+}
+
+// shareGPTConversationExporter ShareGPT 格式的 JSON 数组，角色映射为 human、gpt、function_call、observation，系统提示放在 system 字段
+type shareGPTConversationExporter struct {
+	count int
+}
+
+type shareGPTTurn struct {
+	From  string `json:"from"`
+	Value string `json:"value"`
+}
+
+type shareGPTConversation struct {
+	Id            string         `json:"id"`
+	Model         string         `json:"model"`
+	System        string         `json:"system,omitempty"`
+	Conversations []shareGPTTurn `json:"conversations"`
+	Error         string         `json:"error,omitempty"`
+}
+
+func (e *shareGPTConversationExporter) begin(w io.Writer) error {
+	_, err := io.WriteString(w, "[\n")
+	return err
+}
+
+func (e *shareGPTConversationExporter) write(w io.Writer, record *conversationExportRecord) error {
+	conversation := shareGPTConversation{
+		Id:            record.ConversationId,
+		Model:         record.ModelName,
+		Conversations: make([]shareGPTTurn, 0, len(record.Messages)),
+		Error:         record.ErrorMessage,
+	}
+	var systemPrompts []string
+	for i := range record.Messages {
+		message := &record.Messages[i]
+		switch message.Role {
+		case "system", "developer":
+			systemPrompts = append(systemPrompts, message.StringContent())
+		case "assistant":
+			if content := message.StringContent(); content != "" {
+				conversation.Conversations = append(conversation.Conversations, shareGPTTurn{From: "gpt", Value: content})
+			}
+			for _, toolCall := range message.ParseToolCalls() {
+				call, err := json.Marshal(map[string]string{"name": toolCall.Function.Name, "arguments": toolCall.Function.Arguments})
+				if err != nil {
+					return err
+				}
+				conversation.Conversations = append(conversation.Conversations, shareGPTTurn{From: "function_call", Value: string(call)})
+			}
+		case "tool", "function":
+			conversation.Conversations = append(conversation.Conversations, shareGPTTurn{From: "observation", Value: message.StringContent()})
+		default:
+			conversation.Conversations = append(conversation.Conversations, shareGPTTurn{From: "human", Value: message.StringContent()})
+		}
+	}
+	conversation.System = strings.Join(systemPrompts, "\n\n")
+
+	item, err := json.Marshal(conversation)
+	if err != nil {
+		return err
+	}
+	if e.count > 0 {
+		if _, err = io.WriteString(w, ",\n"); err != nil {
+			return err
+		}
+	}
+	e.count++
+	_, err = w.Write(item)
+	return err
+}
+
+func (e *shareGPTConversationExporter) end(w io.Writer) error {
+	_, err := io.WriteString(w, "\n]\n")
+	return err
+}
+
+// markdownConversationExporter 可读的 Markdown 对话记录，每个对话一节
+type markdownConversationExporter struct{}
+
+func (e *markdownConversationExporter) begin(w io.Writer) error {
+	return nil
+}
+
+func (e *markdownConversationExporter) write(w io.Writer, record *conversationExportRecord) error {
+	var builder strings.Builder
+	builder.WriteString("## " + record.ConversationId + "\n\n")
+	builder.WriteString("- 模型: " + record.ModelName + "\n")
+	builder.WriteString(fmt.Sprintf("- 用户: %d\n", record.UserId))
+	builder.WriteString("- 时间: " + record.CreatedAt.Format("2006-01-02 15:04:05") + "\n")
+	if record.ErrorCode != "" || record.ErrorMessage != "" {
+		builder.WriteString("- 错误: " + strings.TrimSpace(record.ErrorCode+" "+record.ErrorMessage) + "\n")
+	}
+	builder.WriteString("\n")
+	for i := range record.Messages {
+		message := &record.Messages[i]
+		builder.WriteString("### " + message.Role + "\n\n")
+		if message.ReasoningContent != "" {
+			builder.WriteString("> " + strings.ReplaceAll(message.ReasoningContent, "\n", "\n> ") + "\n\n")
+		}
+		if content := message.StringContent(); content != "" {
+			builder.WriteString(content + "\n\n")
+		}
+		for _, toolCall := range message.ParseToolCalls() {
+			builder.WriteString("调用工具 `" + toolCall.Function.Name + "`：\n\n```json\n" + toolCall.Function.Arguments + "\n```\n\n")
+		}
+	}
+	builder.WriteString("---\n\n")
+	_, err := io.WriteString(w, builder.String())
+	return err
+}
+
+func (e *markdownConversationExporter) end(w io.Writer) error {
+	return nil
+}
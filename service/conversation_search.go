@@ -0,0 +1,41 @@
+package service
+
+import (
+	"errors"
+	"one-api/common"
+	"one-api/model"
+	"time"
+)
+
+var conversationSearchIndexJob = &historyJob{name: "search index"}
+
+// GetConversationSearchIndexJob 获取最近一次重建检索索引任务的进度
+func GetConversationSearchIndexJob() HistoryJobStatus {
+	return conversationSearchIndexJob.Status()
+}
+
+// StartConversationSearchIndexJob 在后台为已有分区中的对话历史重建检索文档，date 为零值时处理所有分区。
+// 开启 CONVERSATION_HISTORY_SEARCH 之前写入的数据，以及更换 CRYPTO_SECRET 之后的数据都需要重建。
+func StartConversationSearchIndexJob(date time.Time) error {
+	if !common.ConversationHistorySearch {
+		return errors.New("未启用对话历史全文检索")
+	}
+	partitions, err := model.GetMESPartitions(model.ConversationHistoryBaseTable)
+	if err != nil {
+		return err
+	}
+	var steps []historyJobStep
+	for _, partition := range partitions {
+		if !date.IsZero() && !partition.Date.Equal(date) {
+			continue
+		}
+		partition := partition
+		steps = append(steps, historyJobStep{
+			name: historyPartitionStepName(partition),
+			run: func() (int64, error) {
+				return model.IndexConversationHistoryPartition(partition, 500)
+			},
+		})
+	}
+	return conversationSearchIndexJob.start(steps)
+}
@@ -249,6 +249,7 @@ func StreamResponseOpenAI2Claude(openAIResponse *dto.ChatCompletionsStreamRespon
 			}
 			resp.SetIndex(0)
 			claudeResponses = append(claudeResponses, resp)
+			info.ClaudeConvertInfo.LastMessagesType = relaycommon.LastMessageTypeTools
 		} else {
 			//resp := &dto.ClaudeResponse{
 			//	Type: "content_block_start",
@@ -297,8 +298,11 @@ func StreamResponseOpenAI2Claude(openAIResponse *dto.ChatCompletionsStreamRespon
 			claudeResponse.Type = "content_block_delta"
 			if len(chosenChoice.Delta.ToolCalls) > 0 {
 				if info.ClaudeConvertInfo.LastMessagesType != relaycommon.LastMessageTypeTools {
-					claudeResponses = append(claudeResponses, generateStopBlock(info.ClaudeConvertInfo.Index))
-					info.ClaudeConvertInfo.Index++
+					// 之前没有内容块时直接从当前下标开始
+					if info.ClaudeConvertInfo.LastMessagesType != relaycommon.LastMessageTypeNone {
+						claudeResponses = append(claudeResponses, generateStopBlock(info.ClaudeConvertInfo.Index))
+						info.ClaudeConvertInfo.Index++
+					}
 					claudeResponses = append(claudeResponses, &dto.ClaudeResponse{
 						Index: &info.ClaudeConvertInfo.Index,
 						Type:  "content_block_start",
@@ -388,7 +392,7 @@ func ResponseOpenAI2Claude(openAIResponse *dto.OpenAITextResponse, info *relayco
 		claudeContent := dto.ClaudeMediaMessage{}
 		if choice.FinishReason == "tool_calls" {
 			claudeContent.Type = "tool_use"
-			claudeContent.Id = choice.Message.ToolCallId
+			claudeContent.Id = choice.Message.ParseToolCalls()[0].ID
 			claudeContent.Name = choice.Message.ParseToolCalls()[0].Function.Name
 			var mapParams map[string]interface{}
 			if err := json.Unmarshal([]byte(choice.Message.ParseToolCalls()[0].Function.Arguments), &mapParams); err == nil {
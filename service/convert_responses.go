@@ -0,0 +1,415 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"one-api/common"
+	"one-api/dto"
+	relaycommon "one-api/relay/common"
+	"strings"
+)
+
+type responsesInputContent struct {
+	Type     string `json:"type"`
+	Text     string `json:"text,omitempty"`
+	ImageUrl any    `json:"image_url,omitempty"`
+	FileData string `json:"file_data,omitempty"`
+	Filename string `json:"filename,omitempty"`
+}
+
+type responsesInputItem struct {
+	Type      string          `json:"type,omitempty"`
+	Role      string          `json:"role,omitempty"`
+	Content   json.RawMessage `json:"content,omitempty"`
+	CallId    string          `json:"call_id,omitempty"`
+	Name      string          `json:"name,omitempty"`
+	Arguments string          `json:"arguments,omitempty"`
+	Output    any             `json:"output,omitempty"`
+}
+
+// ResponsesToOpenAIRequest 将 Responses API 请求转换为 Chat Completions 请求，用于不支持 Responses API 的渠道
+func ResponsesToOpenAIRequest(request dto.OpenAIResponsesRequest, info *relaycommon.RelayInfo) (*dto.GeneralOpenAIRequest, error) {
+	if request.PreviousResponseID != "" {
+		return nil, errors.New("previous_response_id is not supported by this channel")
+	}
+	openAIRequest := dto.GeneralOpenAIRequest{
+		Model:     request.Model,
+		Stream:    request.Stream,
+		MaxTokens: request.MaxOutputTokens,
+		TopP:      request.TopP,
+		User:      request.User,
+	}
+	if request.Temperature != 0 {
+		openAIRequest.Temperature = common.GetPointer[float64](request.Temperature)
+	}
+	if request.Reasoning != nil {
+		openAIRequest.ReasoningEffort = request.Reasoning.Effort
+	}
+	if request.ParallelToolCalls {
+		openAIRequest.ParallelTooCalls = common.GetPointer[bool](true)
+	}
+
+	messages := make([]dto.Message, 0)
+	if len(request.Instructions) > 0 {
+		var instructions string
+		if err := json.Unmarshal(request.Instructions, &instructions); err == nil && instructions != "" {
+			messages = append(messages, dto.Message{Role: "system", Content: instructions})
+		}
+	}
+	inputMessages, err := responsesInputToMessages(request.Input)
+	if err != nil {
+		return nil, err
+	}
+	openAIRequest.Messages = append(messages, inputMessages...)
+
+	for _, tool := range request.Tools {
+		if tool.Type != "function" {
+			// 内置工具（web_search、file_search 等）只有 OpenAI 渠道支持
+			return nil, fmt.Errorf("tool type %s is not supported by this channel", tool.Type)
+		}
+		var parameters any
+		if len(tool.Parameters) > 0 {
+			parameters = tool.Parameters
+		}
+		openAIRequest.Tools = append(openAIRequest.Tools, dto.ToolCallRequest{
+			Type: "function",
+			Function: dto.FunctionRequest{
+				Name:        tool.Name,
+				Description: tool.Description,
+				Parameters:  parameters,
+			},
+		})
+	}
+	if len(request.ToolChoice) > 0 {
+		var toolChoice any
+		if err := json.Unmarshal(request.ToolChoice, &toolChoice); err == nil {
+			if choice, ok := toolChoice.(map[string]any); ok && choice["type"] == "function" {
+				toolChoice = map[string]any{
+					"type":     "function",
+					"function": map[string]any{"name": choice["name"]},
+				}
+			}
+			openAIRequest.ToolChoice = toolChoice
+		}
+	}
+	if len(request.Text) > 0 {
+		var text struct {
+			Format *struct {
+				Type        string `json:"type"`
+				Name        string `json:"name"`
+				Description string `json:"description"`
+				Schema      any    `json:"schema"`
+				Strict      any    `json:"strict"`
+			} `json:"format"`
+		}
+		if err := json.Unmarshal(request.Text, &text); err == nil && text.Format != nil && text.Format.Type != "text" {
+			responseFormat := &dto.ResponseFormat{Type: text.Format.Type}
+			if text.Format.Type == "json_schema" {
+				responseFormat.JsonSchema = &dto.FormatJsonSchema{
+					Name:        text.Format.Name,
+					Description: text.Format.Description,
+					Schema:      text.Format.Schema,
+					Strict:      text.Format.Strict,
+				}
+			}
+			openAIRequest.ResponseFormat = responseFormat
+		}
+	}
+	return &openAIRequest, nil
+}
+
+func responsesInputToMessages(input json.RawMessage) ([]dto.Message, error) {
+	var text string
+	if err := json.Unmarshal(input, &text); err == nil {
+		return []dto.Message{{Role: "user", Content: text}}, nil
+	}
+	var items []responsesInputItem
+	if err := json.Unmarshal(input, &items); err != nil {
+		return nil, fmt.Errorf("invalid input: %w", err)
+	}
+	messages := make([]dto.Message, 0, len(items))
+	for _, item := range items {
+		switch item.Type {
+		case "function_call":
+			toolCall := dto.ToolCallRequest{
+				ID:   item.CallId,
+				Type: "function",
+				Function: dto.FunctionRequest{
+					Name:      item.Name,
+					Arguments: item.Arguments,
+				},
+			}
+			// 连续的函数调用合并到同一条 assistant 消息中
+			if len(messages) > 0 && messages[len(messages)-1].Role == "assistant" && messages[len(messages)-1].ToolCalls != nil {
+				last := &messages[len(messages)-1]
+				last.SetToolCalls(append(last.ParseToolCalls(), toolCall))
+				continue
+			}
+			message := dto.Message{Role: "assistant"}
+			message.SetNullContent()
+			message.SetToolCalls([]dto.ToolCallRequest{toolCall})
+			messages = append(messages, message)
+		case "function_call_output":
+			output, ok := item.Output.(string)
+			if !ok {
+				output = toJSONString(item.Output)
+			}
+			messages = append(messages, dto.Message{Role: "tool", ToolCallId: item.CallId, Content: output})
+		case "", "message":
+			role := item.Role
+			if role == "developer" {
+				role = "system"
+			}
+			message := dto.Message{Role: role}
+			var content string
+			if err := json.Unmarshal(item.Content, &content); err == nil {
+				message.SetStringContent(content)
+				messages = append(messages, message)
+				continue
+			}
+			var parts []responsesInputContent
+			if err := json.Unmarshal(item.Content, &parts); err != nil {
+				return nil, fmt.Errorf("invalid input content: %w", err)
+			}
+			mediaContents := make([]dto.MediaContent, 0, len(parts))
+			for _, part := range parts {
+				switch part.Type {
+				case "input_text", "output_text":
+					mediaContents = append(mediaContents, dto.MediaContent{Type: dto.ContentTypeText, Text: part.Text})
+				case "input_image":
+					mediaContents = append(mediaContents, dto.MediaContent{Type: dto.ContentTypeImageURL, ImageUrl: part.ImageUrl})
+				case "input_file":
+					mediaContents = append(mediaContents, dto.MediaContent{Type: dto.ContentTypeFile, File: map[string]any{
+						"filename":  part.Filename,
+						"file_data": part.FileData,
+					}})
+				}
+			}
+			message.SetMediaContent(mediaContents)
+			messages = append(messages, message)
+		default:
+			return nil, fmt.Errorf("input item type %s is not supported by this channel", item.Type)
+		}
+	}
+	return messages, nil
+}
+
+func responsesStatus(finishReason string) (string, *dto.IncompleteDetails) {
+	if finishReason == "length" {
+		return "incomplete", &dto.IncompleteDetails{Reasoning: "max_output_tokens"}
+	}
+	return "completed", nil
+}
+
+func usageOpenAI2Responses(usage *dto.Usage) *dto.Usage {
+	if usage == nil {
+		return nil
+	}
+	return &dto.Usage{
+		InputTokens:  usage.PromptTokens,
+		OutputTokens: usage.CompletionTokens,
+		TotalTokens:  usage.TotalTokens,
+		InputTokensDetails: &dto.InputTokenDetails{
+			CachedTokens: usage.PromptTokensDetails.CachedTokens,
+		},
+	}
+}
+
+// ResponseOpenAI2Responses 将 Chat Completions 非流式响应转换为 Responses API 响应
+func ResponseOpenAI2Responses(openAIResponse *dto.OpenAITextResponse, info *relaycommon.RelayInfo) *dto.OpenAIResponsesResponse {
+	response := &dto.OpenAIResponsesResponse{
+		ID:        fmt.Sprintf("resp_%s", openAIResponse.Id),
+		Object:    "response",
+		CreatedAt: int(openAIResponse.Created),
+		Model:     openAIResponse.Model,
+		Output:    make([]dto.ResponsesOutput, 0),
+		Usage:     usageOpenAI2Responses(&openAIResponse.Usage),
+	}
+	finishReason := ""
+	for _, choice := range openAIResponse.Choices {
+		finishReason = choice.FinishReason
+		if text := choice.Message.StringContent(); text != "" {
+			response.Output = append(response.Output, dto.ResponsesOutput{
+				Type:   "message",
+				ID:     fmt.Sprintf("msg_%s_%d", openAIResponse.Id, choice.Index),
+				Status: "completed",
+				Role:   "assistant",
+				Content: []dto.ResponsesOutputContent{
+					{Type: "output_text", Text: text, Annotations: []interface{}{}},
+				},
+			})
+		}
+		for _, toolCall := range choice.Message.ParseToolCalls() {
+			response.Output = append(response.Output, dto.ResponsesOutput{
+				Type:      "function_call",
+				ID:        fmt.Sprintf("fc_%s", toolCall.ID),
+				Status:    "completed",
+				CallId:    toolCall.ID,
+				Name:      toolCall.Function.Name,
+				Arguments: toolCall.Function.Arguments,
+			})
+		}
+	}
+	response.Status, response.IncompleteDetails = responsesStatus(finishReason)
+	return response
+}
+
+type responsesToolCallState struct {
+	item        dto.ResponsesOutput
+	outputIndex int
+}
+
+// ResponsesStreamConverter 将 Chat Completions 流式响应逐块转换为 Responses API 流式事件
+type ResponsesStreamConverter struct {
+	response     *dto.OpenAIResponsesResponse
+	started      bool
+	text         strings.Builder
+	textItem     *dto.ResponsesOutput
+	textIndex    int
+	toolCalls    map[int]*responsesToolCallState
+	nextIndex    int
+	finishReason string
+}
+
+func NewResponsesStreamConverter() *ResponsesStreamConverter {
+	return &ResponsesStreamConverter{
+		toolCalls: make(map[int]*responsesToolCallState),
+	}
+}
+
+func (r *ResponsesStreamConverter) ensureStarted(chunk *dto.ChatCompletionsStreamResponse) []dto.ResponsesStreamResponse {
+	if r.started {
+		return nil
+	}
+	r.started = true
+	r.response = &dto.OpenAIResponsesResponse{
+		ID:        fmt.Sprintf("resp_%s", chunk.Id),
+		Object:    "response",
+		CreatedAt: int(chunk.Created),
+		Status:    "in_progress",
+		Model:     chunk.Model,
+		Output:    make([]dto.ResponsesOutput, 0),
+	}
+	created := *r.response
+	return []dto.ResponsesStreamResponse{
+		{Type: "response.created", Response: &created},
+		{Type: "response.in_progress", Response: &created},
+	}
+}
+
+// Convert 转换一个 Chat Completions 流式数据块
+func (r *ResponsesStreamConverter) Convert(chunk *dto.ChatCompletionsStreamResponse) []dto.ResponsesStreamResponse {
+	events := r.ensureStarted(chunk)
+	for _, choice := range chunk.Choices {
+		if choice.FinishReason != nil && *choice.FinishReason != "" {
+			r.finishReason = *choice.FinishReason
+		}
+		if content := choice.Delta.GetContentString(); content != "" {
+			if r.textItem == nil {
+				r.textIndex = r.nextIndex
+				r.nextIndex++
+				r.textItem = &dto.ResponsesOutput{
+					Type:    "message",
+					ID:      fmt.Sprintf("msg_%s", chunk.Id),
+					Status:  "in_progress",
+					Role:    "assistant",
+					Content: []dto.ResponsesOutputContent{},
+				}
+				item := *r.textItem
+				events = append(events,
+					dto.ResponsesStreamResponse{Type: "response.output_item.added", OutputIndex: common.GetPointer[int](r.textIndex), Item: &item},
+					dto.ResponsesStreamResponse{Type: "response.content_part.added", ItemId: r.textItem.ID, OutputIndex: common.GetPointer[int](r.textIndex), ContentIndex: common.GetPointer[int](0),
+						Part: &dto.ResponsesOutputContent{Type: "output_text", Annotations: []interface{}{}}},
+				)
+			}
+			r.text.WriteString(content)
+			events = append(events, dto.ResponsesStreamResponse{
+				Type:         "response.output_text.delta",
+				ItemId:       r.textItem.ID,
+				OutputIndex:  common.GetPointer[int](r.textIndex),
+				ContentIndex: common.GetPointer[int](0),
+				Delta:        content,
+			})
+		}
+		for i, toolCall := range choice.Delta.ToolCalls {
+			index := i
+			if toolCall.Index != nil {
+				index = *toolCall.Index
+			}
+			state, ok := r.toolCalls[index]
+			if !ok {
+				state = &responsesToolCallState{
+					item: dto.ResponsesOutput{
+						Type:   "function_call",
+						ID:     fmt.Sprintf("fc_%s", toolCall.ID),
+						Status: "in_progress",
+						CallId: toolCall.ID,
+						Name:   toolCall.Function.Name,
+					},
+					outputIndex: r.nextIndex,
+				}
+				r.nextIndex++
+				r.toolCalls[index] = state
+				item := state.item
+				events = append(events, dto.ResponsesStreamResponse{Type: "response.output_item.added", OutputIndex: common.GetPointer[int](state.outputIndex), Item: &item})
+			}
+			if toolCall.Function.Arguments != "" {
+				state.item.Arguments += toolCall.Function.Arguments
+				events = append(events, dto.ResponsesStreamResponse{
+					Type:        "response.function_call_arguments.delta",
+					ItemId:      state.item.ID,
+					OutputIndex: common.GetPointer[int](state.outputIndex),
+					Delta:       toolCall.Function.Arguments,
+				})
+			}
+		}
+	}
+	return events
+}
+
+// Finish 流结束时生成收尾事件和 response.completed
+func (r *ResponsesStreamConverter) Finish(usage *dto.Usage) []dto.ResponsesStreamResponse {
+	events := r.ensureStarted(&dto.ChatCompletionsStreamResponse{})
+	outputs := make([]dto.ResponsesOutput, r.nextIndex)
+	if r.textItem != nil {
+		text := r.text.String()
+		part := dto.ResponsesOutputContent{Type: "output_text", Text: text, Annotations: []interface{}{}}
+		r.textItem.Status = "completed"
+		r.textItem.Content = []dto.ResponsesOutputContent{part}
+		item := *r.textItem
+		outputs[r.textIndex] = item
+		events = append(events,
+			dto.ResponsesStreamResponse{Type: "response.output_text.done", ItemId: item.ID, OutputIndex: common.GetPointer[int](r.textIndex), ContentIndex: common.GetPointer[int](0), Text: text},
+			dto.ResponsesStreamResponse{Type: "response.content_part.done", ItemId: item.ID, OutputIndex: common.GetPointer[int](r.textIndex), ContentIndex: common.GetPointer[int](0), Part: &part},
+			dto.ResponsesStreamResponse{Type: "response.output_item.done", OutputIndex: common.GetPointer[int](r.textIndex), Item: &item},
+		)
+	}
+	for i := 0; i < len(r.toolCalls); i++ {
+		state, ok := r.toolCalls[i]
+		if !ok {
+			continue
+		}
+		state.item.Status = "completed"
+		item := state.item
+		outputs[state.outputIndex] = item
+		events = append(events,
+			dto.ResponsesStreamResponse{Type: "response.function_call_arguments.done", ItemId: item.ID, OutputIndex: common.GetPointer[int](state.outputIndex), Arguments: item.Arguments},
+			dto.ResponsesStreamResponse{Type: "response.output_item.done", OutputIndex: common.GetPointer[int](state.outputIndex), Item: &item},
+		)
+	}
+	response := *r.response
+	response.Output = make([]dto.ResponsesOutput, 0, len(outputs))
+	for _, output := range outputs {
+		if output.Type != "" {
+			response.Output = append(response.Output, output)
+		}
+	}
+	response.Usage = usageOpenAI2Responses(usage)
+	response.Status, response.IncompleteDetails = responsesStatus(r.finishReason)
+	eventType := "response.completed"
+	if response.Status == "incomplete" {
+		eventType = "response.incomplete"
+	}
+	return append(events, dto.ResponsesStreamResponse{Type: eventType, Response: &response})
+}
@@ -0,0 +1,115 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"one-api/constant"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// 文件 id 由网关生成，这里仍然校验以防路径穿越
+func fileStoragePath(id string) (string, error) {
+	if id == "" || strings.ContainsAny(id, `/\.`) {
+		return "", fmt.Errorf("invalid file id: %s", id)
+	}
+	return filepath.Join(constant.FileStoragePath, id), nil
+}
+
+// SaveStoredFile 将内容写入本地存储，超过 maxBytes 时返回错误并删除已写入的内容
+func SaveStoredFile(id string, reader io.Reader, maxBytes int64) (int64, error) {
+	path, err := fileStoragePath(id)
+	if err != nil {
+		return 0, err
+	}
+	if err = os.MkdirAll(constant.FileStoragePath, 0755); err != nil {
+		return 0, err
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		return 0, err
+	}
+	size, err := io.Copy(file, io.LimitReader(reader, maxBytes+1))
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil && size > maxBytes {
+		err = fmt.Errorf("file size exceeds maximum allowed size: %d bytes", maxBytes)
+	}
+	if err != nil {
+		_ = os.Remove(path)
+		return 0, err
+	}
+	return size, nil
+}
+
+func OpenStoredFile(id string) (*os.File, error) {
+	path, err := fileStoragePath(id)
+	if err != nil {
+		return nil, err
+	}
+	return os.Open(path)
+}
+
+// AppendStoredFile 以追加方式打开文件，用于批量任务逐步写入输出
+func AppendStoredFile(id string) (*os.File, error) {
+	path, err := fileStoragePath(id)
+	if err != nil {
+		return nil, err
+	}
+	if err = os.MkdirAll(constant.FileStoragePath, 0755); err != nil {
+		return nil, err
+	}
+	return os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+}
+
+func StoredFileSize(id string) (int64, error) {
+	path, err := fileStoragePath(id)
+	if err != nil {
+		return 0, err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
+// TruncateStoredFile 将文件截断到指定长度，用于丢弃服务中断时写入了一半的输出
+func TruncateStoredFile(id string, size int64) error {
+	path, err := fileStoragePath(id)
+	if err != nil {
+		return err
+	}
+	err = os.Truncate(path, size)
+	if errors.Is(err, os.ErrNotExist) && size == 0 {
+		return nil
+	}
+	return err
+}
+
+func RenameStoredFile(from string, to string) error {
+	fromPath, err := fileStoragePath(from)
+	if err != nil {
+		return err
+	}
+	toPath, err := fileStoragePath(to)
+	if err != nil {
+		return err
+	}
+	return os.Rename(fromPath, toPath)
+}
+
+func DeleteStoredFile(id string) error {
+	path, err := fileStoragePath(id)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,189 @@
+package service
+
+import (
+	"context"
+	"one-api/constant"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// HedgeGroup 对冲请求组：同一请求并发发往多个渠道，最先完成的渠道胜出并计费，其余请求被取消。
+// 只有最先发起的主渠道预扣费和预占 TPM，预占的 TPM 由胜出渠道按实际用量结算。
+type HedgeGroup struct {
+	mu        sync.Mutex
+	channels  []int
+	cancels   map[int]context.CancelFunc
+	finished  map[int]bool
+	winner    int
+	cancelled []map[string]interface{}
+	branches  map[int]*hedgeBranch
+
+	tpmReservations []tpmReservation
+	tpmSettled      bool
+}
+
+// hedgeBranch 对冲请求中单个渠道的请求信息，用于记录被取消请求的上游消耗
+type hedgeBranch struct {
+	startTime     time.Time
+	promptTokens  int
+	upstreamQuota int
+}
+
+func NewHedgeGroup() *HedgeGroup {
+	return &HedgeGroup{
+		cancels:  make(map[int]context.CancelFunc),
+		finished: make(map[int]bool),
+		branches: make(map[int]*hedgeBranch),
+	}
+}
+
+// GetHedgeGroup 获取当前请求所属的对冲请求组，非对冲请求返回 nil
+func GetHedgeGroup(c *gin.Context) *HedgeGroup {
+	value, ok := c.Get(constant.ContextKeyHedgeGroup)
+	if !ok {
+		return nil
+	}
+	group, _ := value.(*HedgeGroup)
+	return group
+}
+
+// Add 将渠道加入对冲请求组，返回该渠道请求使用的可取消 context
+func (g *HedgeGroup) Add(parent context.Context, channelId int) context.Context {
+	ctx, cancel := context.WithCancel(parent)
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.channels = append(g.channels, channelId)
+	g.cancels[channelId] = cancel
+	g.branches[channelId] = &hedgeBranch{startTime: time.Now()}
+	return ctx
+}
+
+// IsPrimary 判断渠道是否为最先发起请求的主渠道
+func (g *HedgeGroup) IsPrimary(channelId int) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return len(g.channels) > 0 && g.channels[0] == channelId
+}
+
+// SetUpstreamEstimate 记录渠道请求的 prompt tokens 和按 prompt 估算的上游消耗，
+// 请求被取消时上游通常已按输入计费，用于在胜出渠道的日志中记录
+func (g *HedgeGroup) SetUpstreamEstimate(channelId int, promptTokens int, upstreamQuota int) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if branch, ok := g.branches[channelId]; ok {
+		branch.promptTokens = promptTokens
+		branch.upstreamQuota = upstreamQuota
+	}
+}
+
+// Claim 尝试成为胜出渠道，成功时取消其余仍在进行中的请求。
+// 同一渠道重复调用返回相同结果。
+func (g *HedgeGroup) Claim(channelId int) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.winner != 0 {
+		return g.winner == channelId
+	}
+	g.winner = channelId
+	for id, cancel := range g.cancels {
+		if id == channelId {
+			continue
+		}
+		if !g.finished[id] {
+			cancelled := map[string]interface{}{"channel_id": id}
+			if branch, ok := g.branches[id]; ok {
+				cancelled["duration_ms"] = time.Since(branch.startTime).Milliseconds()
+				cancelled["prompt_tokens"] = branch.promptTokens
+				cancelled["estimated_upstream_quota"] = branch.upstreamQuota
+			}
+			g.cancelled = append(g.cancelled, cancelled)
+		}
+		cancel()
+	}
+	return true
+}
+
+// Finish 标记渠道请求已结束
+func (g *HedgeGroup) Finish(channelId int) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.finished[channelId] = true
+}
+
+// Cancelled 判断渠道请求是否因其他渠道胜出而被取消
+func (g *HedgeGroup) Cancelled(channelId int) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.winner != 0 && g.winner != channelId
+}
+
+// CancelAll 取消所有请求，用于对冲结束后释放资源
+func (g *HedgeGroup) CancelAll() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for _, cancel := range g.cancels {
+		cancel()
+	}
+}
+
+// Info 返回用于日志记录的对冲信息
+func (g *HedgeGroup) Info() map[string]interface{} {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	info := make(map[string]interface{})
+	info["channels"] = append([]int{}, g.channels...)
+	info["winner"] = g.winner
+	if len(g.cancelled) > 0 {
+		info["cancelled"] = append([]map[string]interface{}{}, g.cancelled...)
+	}
+	return info
+}
+
+func (g *HedgeGroup) setTPMReservations(reservations []tpmReservation) {
+	g.mu.Lock()
+	settled := g.tpmSettled
+	if !settled {
+		g.tpmReservations = append(g.tpmReservations, reservations...)
+	}
+	g.mu.Unlock()
+	// 已经结算过的对冲请求不再占用 TPM
+	if settled {
+		for _, reservation := range reservations {
+			adjustTPM(reservation.key, reservation.minute, -reservation.amount)
+		}
+	}
+}
+
+// settleTPM 按胜出渠道的实际用量结算主渠道预占的 TPM，渠道维度的用量转移到胜出渠道。
+// 失败或被取消的请求（totalTokens 为 0）不结算，全部失败时由 ReleaseTPM 释放。
+func (g *HedgeGroup) settleTPM(channelId int, totalTokens int) {
+	g.mu.Lock()
+	if g.tpmSettled || (channelId != 0 && (totalTokens == 0 || (g.winner != 0 && g.winner != channelId))) {
+		g.mu.Unlock()
+		return
+	}
+	g.tpmSettled = true
+	reservations := g.tpmReservations
+	primaryKey := ""
+	if len(g.channels) > 0 && g.channels[0] != channelId {
+		primaryKey = tpmKey(RateLimitScopeChannel, g.channels[0])
+	}
+	g.mu.Unlock()
+	for _, reservation := range reservations {
+		if channelId != 0 && reservation.key == primaryKey {
+			adjustTPM(reservation.key, reservation.minute, -reservation.amount)
+			adjustTPM(tpmKey(RateLimitScopeChannel, channelId), reservation.minute, int64(totalTokens))
+			continue
+		}
+		if delta := int64(totalTokens) - reservation.amount; delta != 0 {
+			adjustTPM(reservation.key, reservation.minute, delta)
+		}
+	}
+}
+
+// ReleaseTPM 释放尚未结算的 TPM 预占，用于对冲请求全部失败时
+func (g *HedgeGroup) ReleaseTPM() {
+	g.settleTPM(0, 0)
+}
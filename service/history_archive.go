@@ -0,0 +1,459 @@
+package service
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"one-api/common"
+	"one-api/model"
+	"one-api/setting/operation_setting"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+)
+
+const (
+	historyArchiveBatchSize   = 500
+	historyArchiveCheckPeriod = time.Hour
+)
+
+// 手动归档、恢复和定时归档互斥执行
+var historyArchiveLock sync.Mutex
+
+// HistoryArchiveManifest 与归档文件一起保存的清单，用于恢复前校验文件完整性
+type HistoryArchiveManifest struct {
+	BaseTable   string `json:"base_table"`
+	Partition   string `json:"partition"`
+	Date        string `json:"date"`
+	File        string `json:"file"`
+	Format      string `json:"format"`
+	Rows        int64  `json:"rows"`
+	Size        int64  `json:"size"`
+	Sha256      string `json:"sha256"`
+	CreatedTime int64  `json:"created_time"`
+}
+
+// historyArchiveStorage 归档文件的存储位置
+type historyArchiveStorage interface {
+	// Put 保存文件并返回之后用于读取的路径，sha256 为内容的十六进制摘要
+	Put(name string, body io.ReadSeeker, size int64, sha256 string) (string, error)
+	Get(path string) (io.ReadCloser, error)
+}
+
+func getHistoryArchiveStorage() (historyArchiveStorage, string, error) {
+	setting := operation_setting.GetHistoryArchiveSetting()
+	switch setting.Storage {
+	case operation_setting.HistoryArchiveStorageLocal, "":
+		return &localArchiveStorage{dir: setting.LocalDir}, operation_setting.HistoryArchiveStorageLocal, nil
+	case operation_setting.HistoryArchiveStorageS3:
+		if setting.S3Endpoint == "" || setting.S3Bucket == "" {
+			return nil, "", errors.New("s3 endpoint and bucket are required")
+		}
+		return &s3ArchiveStorage{setting: *setting}, operation_setting.HistoryArchiveStorageS3, nil
+	}
+	return nil, "", fmt.Errorf("unknown archive storage: %s", setting.Storage)
+}
+
+type localArchiveStorage struct {
+	dir string
+}
+
+func (s *localArchiveStorage) Put(name string, body io.ReadSeeker, size int64, sha256 string) (string, error) {
+	if err := os.MkdirAll(s.dir, 0755); err != nil {
+		return "", err
+	}
+	path := filepath.Join(s.dir, name)
+	// 先写入临时文件再重命名，避免留下不完整的归档
+	file, err := os.CreateTemp(s.dir, name+".*.tmp")
+	if err != nil {
+		return "", err
+	}
+	_, err = io.Copy(file, body)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(file.Name(), path)
+	}
+	if err != nil {
+		_ = os.Remove(file.Name())
+		return "", err
+	}
+	return path, nil
+}
+
+func (s *localArchiveStorage) Get(path string) (io.ReadCloser, error) {
+	return os.Open(path)
+}
+
+// s3ArchiveStorage S3 兼容的对象存储，使用路径风格的地址和 SigV4 签名
+type s3ArchiveStorage struct {
+	setting operation_setting.HistoryArchiveSetting
+}
+
+func (s *s3ArchiveStorage) objectURL(key string) string {
+	return fmt.Sprintf("%s/%s/%s", strings.TrimSuffix(s.setting.S3Endpoint, "/"), s.setting.S3Bucket, key)
+}
+
+func (s *s3ArchiveStorage) do(req *http.Request, payloadHash string) (*http.Response, error) {
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	credentials := aws.Credentials{AccessKeyID: s.setting.S3AccessKey, SecretAccessKey: s.setting.S3SecretKey}
+	err := v4.NewSigner().SignHTTP(context.Background(), credentials, req, payloadHash, "s3", s.setting.S3Region, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	resp, err := GetHttpClient().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		_ = resp.Body.Close()
+		return nil, fmt.Errorf("s3 request failed: status %d, %s", resp.StatusCode, string(body))
+	}
+	return resp, nil
+}
+
+func (s *s3ArchiveStorage) Put(name string, body io.ReadSeeker, size int64, sha256 string) (string, error) {
+	key := s.setting.S3Prefix + name
+	req, err := http.NewRequest(http.MethodPut, s.objectURL(key), body)
+	if err != nil {
+		return "", err
+	}
+	req.ContentLength = size
+	resp, err := s.do(req, sha256)
+	if err != nil {
+		return "", err
+	}
+	_ = resp.Body.Close()
+	return key, nil
+}
+
+func (s *s3ArchiveStorage) Get(path string) (io.ReadCloser, error) {
+	req, err := http.NewRequest(http.MethodGet, s.objectURL(path), nil)
+	if err != nil {
+		return nil, err
+	}
+	emptyHash := sha256.Sum256(nil)
+	resp, err := s.do(req, hex.EncodeToString(emptyHash[:]))
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// downloadHistoryArchive 将归档文件下载到临时文件并校验摘要，调用方负责关闭并删除临时文件
+func downloadHistoryArchive(storage historyArchiveStorage, path string, expectedSha256 string) (*os.File, error) {
+	reader, err := storage.Get(path)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	file, err := os.CreateTemp("", "history-archive-*.jsonl.gz")
+	if err != nil {
+		return nil, err
+	}
+	hasher := sha256.New()
+	_, err = io.Copy(io.MultiWriter(file, hasher), reader)
+	if err == nil && hex.EncodeToString(hasher.Sum(nil)) != expectedSha256 {
+		err = fmt.Errorf("checksum mismatch for archive %s", path)
+	}
+	if err == nil {
+		_, err = file.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		_ = file.Close()
+		_ = os.Remove(file.Name())
+		return nil, err
+	}
+	return file, nil
+}
+
+// readHistoryArchiveRows 逐行读取 gzip 压缩的 JSONL，每 batchSize 行调用一次 fn
+func readHistoryArchiveRows(reader io.Reader, batchSize int, fn func(rows [][]byte) error) (int64, error) {
+	gz, err := gzip.NewReader(reader)
+	if err != nil {
+		return 0, err
+	}
+	defer gz.Close()
+	scanner := bufio.NewScanner(gz)
+	// 单条对话历史可能很大
+	scanner.Buffer(make([]byte, 0, 64*1024), 256*1024*1024)
+	var total int64
+	rows := make([][]byte, 0, batchSize)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+		rows = append(rows, bytes.Clone(line))
+		total++
+		if len(rows) >= batchSize {
+			if err = fn(rows); err != nil {
+				return total, err
+			}
+			rows = rows[:0]
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		return total, err
+	}
+	if len(rows) > 0 {
+		err = fn(rows)
+	}
+	return total, err
+}
+
+// ArchiveMESPartition 将一天的分区导出为 gzip 压缩的 JSONL 并写入清单，校验归档内容后按配置删除分区
+func ArchiveMESPartition(baseTable string, partition model.MESPartition) (*model.HistoryArchive, error) {
+	if partition.Date.IsZero() {
+		return nil, fmt.Errorf("partition %s has no date", partition.Name)
+	}
+	setting := operation_setting.GetHistoryArchiveSetting()
+	storage, storageName, err := getHistoryArchiveStorage()
+	if err != nil {
+		return nil, err
+	}
+	date := partition.Date.Format("2006-01-02")
+	name := fmt.Sprintf("%s_%s", baseTable, partition.Date.Format("2006_01_02"))
+
+	// 导出到临时文件，同时计算压缩后的摘要
+	tmp, err := os.CreateTemp("", name+"-*.jsonl.gz")
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = tmp.Close()
+		_ = os.Remove(tmp.Name())
+	}()
+	hasher := sha256.New()
+	gz := gzip.NewWriter(io.MultiWriter(tmp, hasher))
+	rows, err := model.ExportMESPartitionRows(partition, baseTable, historyArchiveBatchSize, func(row []byte) error {
+		if _, err := gz.Write(row); err != nil {
+			return err
+		}
+		_, err := gz.Write([]byte("\n"))
+		return err
+	})
+	if err == nil {
+		err = gz.Close()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to export %s: %v", partition.Name, err)
+	}
+	size, err := tmp.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, err
+	}
+	checksum := hex.EncodeToString(hasher.Sum(nil))
+	if _, err = tmp.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	path, err := storage.Put(name+".jsonl.gz", tmp, size, checksum)
+	if err != nil {
+		return nil, fmt.Errorf("failed to upload archive %s: %v", name, err)
+	}
+
+	manifest := HistoryArchiveManifest{
+		BaseTable:   baseTable,
+		Partition:   partition.Name,
+		Date:        date,
+		File:        name + ".jsonl.gz",
+		Format:      "jsonl.gz",
+		Rows:        rows,
+		Size:        size,
+		Sha256:      checksum,
+		CreatedTime: common.GetTimestamp(),
+	}
+	manifestData, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	manifestHash := sha256.Sum256(manifestData)
+	manifestPath, err := storage.Put(name+".manifest.json", bytes.NewReader(manifestData), int64(len(manifestData)), hex.EncodeToString(manifestHash[:]))
+	if err != nil {
+		return nil, fmt.Errorf("failed to upload manifest %s: %v", name, err)
+	}
+
+	// 重新读取已保存的归档，确认摘要和行数都与数据库一致
+	file, err := downloadHistoryArchive(storage, path, checksum)
+	if err != nil {
+		return nil, fmt.Errorf("failed to verify archive %s: %v", name, err)
+	}
+	archivedRows, err := readHistoryArchiveRows(file, historyArchiveBatchSize, func(rows [][]byte) error { return nil })
+	_ = file.Close()
+	_ = os.Remove(file.Name())
+	if err != nil {
+		return nil, fmt.Errorf("failed to verify archive %s: %v", name, err)
+	}
+	currentRows, err := model.CountMESPartitionRows(partition)
+	if err != nil {
+		return nil, err
+	}
+	if archivedRows != rows || currentRows != rows {
+		return nil, fmt.Errorf("archive %s row count mismatch: exported %d, archived %d, current %d", name, rows, archivedRows, currentRows)
+	}
+
+	archive, err := model.GetHistoryArchive(baseTable, date)
+	if err != nil {
+		return nil, err
+	}
+	if archive == nil {
+		archive = &model.HistoryArchive{BaseTable: baseTable, Date: date}
+	}
+	archive.Partition = partition.Name
+	archive.Storage = storageName
+	archive.Path = path
+	archive.ManifestPath = manifestPath
+	archive.Rows = rows
+	archive.Size = size
+	archive.Sha256 = checksum
+	archive.Status = model.HistoryArchiveStatusArchived
+	archive.Dropped = false
+	archive.CreatedTime = manifest.CreatedTime
+	if err = archive.Update(); err != nil {
+		return nil, err
+	}
+
+	if setting.DropAfterArchive {
+		if err = model.DropMESPartition(partition); err != nil {
+			return archive, fmt.Errorf("archive %s saved but failed to drop partition: %v", name, err)
+		}
+		archive.Dropped = true
+		if err = archive.Update(); err != nil {
+			return archive, err
+		}
+	}
+	common.SysLog(fmt.Sprintf("archived %s: %d rows, %d bytes, dropped: %v", partition.Name, rows, size, archive.Dropped))
+	return archive, nil
+}
+
+// ArchiveMESPartitionsByDate 归档指定日期的对话历史和错误对话历史分区，返回生成的归档记录
+func ArchiveMESPartitionsByDate(date time.Time) ([]*model.HistoryArchive, error) {
+	historyArchiveLock.Lock()
+	defer historyArchiveLock.Unlock()
+	day := date.Format("2006-01-02")
+	archives := make([]*model.HistoryArchive, 0, 2)
+	for _, baseTable := range []string{model.ConversationHistoryBaseTable, model.ErrorConversationHistoryBaseTable} {
+		partitions, err := model.GetMESPartitions(baseTable)
+		if err != nil {
+			return archives, err
+		}
+		for _, partition := range partitions {
+			if partition.Date.IsZero() || partition.Date.Format("2006-01-02") != day {
+				continue
+			}
+			archive, err := ArchiveMESPartition(baseTable, partition)
+			if err != nil {
+				return archives, err
+			}
+			archives = append(archives, archive)
+		}
+	}
+	return archives, nil
+}
+
+// ArchiveExpiredMESPartitions 归档早于保留天数的所有分区
+func ArchiveExpiredMESPartitions() error {
+	historyArchiveLock.Lock()
+	defer historyArchiveLock.Unlock()
+	setting := operation_setting.GetHistoryArchiveSetting()
+	now := time.Now()
+	cutoff := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.Local).AddDate(0, 0, -max(setting.RetentionDays, 1))
+	for _, baseTable := range []string{model.ConversationHistoryBaseTable, model.ErrorConversationHistoryBaseTable} {
+		partitions, err := model.GetMESPartitions(baseTable)
+		if err != nil {
+			return err
+		}
+		for _, partition := range partitions {
+			if partition.Date.IsZero() || !partition.Date.Before(cutoff) {
+				continue
+			}
+			// 已归档但保留了分区，或者由管理员恢复的分区不再自动归档
+			archive, err := model.GetHistoryArchive(baseTable, partition.Date.Format("2006-01-02"))
+			if err != nil {
+				return err
+			}
+			if archive != nil {
+				continue
+			}
+			if _, err = ArchiveMESPartition(baseTable, partition); err != nil {
+				common.SysError(fmt.Sprintf("failed to archive %s: %s", partition.Name, err.Error()))
+			}
+		}
+	}
+	return nil
+}
+
+// RestoreHistoryArchive 校验归档文件和密钥后将其中的记录恢复到 MES 数据库，已存在的记录会被跳过
+func RestoreHistoryArchive(archive *model.HistoryArchive) (int64, error) {
+	historyArchiveLock.Lock()
+	defer historyArchiveLock.Unlock()
+	date, err := time.ParseInLocation("2006-01-02", archive.Date, time.Local)
+	if err != nil {
+		return 0, err
+	}
+	// 本地归档记录的是完整路径，不受当前存储配置影响
+	var storage historyArchiveStorage = &localArchiveStorage{}
+	if archive.Storage != operation_setting.HistoryArchiveStorageLocal {
+		if storage, _, err = getHistoryArchiveStorage(); err != nil {
+			return 0, err
+		}
+	}
+	file, err := downloadHistoryArchive(storage, archive.Path, archive.Sha256)
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		_ = file.Close()
+		_ = os.Remove(file.Name())
+	}()
+	// 先确认归档中的密文都可以解密，避免缺少旧密钥时只恢复了一部分记录
+	if _, err = readHistoryArchiveRows(file, historyArchiveBatchSize, func(rows [][]byte) error {
+		return model.CheckMESPartitionRowsDecryptable(archive.BaseTable, rows)
+	}); err != nil {
+		return 0, fmt.Errorf("archive cannot be decrypted with the current keys, add the CRYPTO_SECRET used when archiving to CRYPTO_SECRET_PREVIOUS: %v", err)
+	}
+	if _, err = file.Seek(0, io.SeekStart); err != nil {
+		return 0, err
+	}
+	rows, err := readHistoryArchiveRows(file, historyArchiveBatchSize, func(rows [][]byte) error {
+		return model.RestoreMESPartitionRows(archive.BaseTable, date, rows)
+	})
+	if err != nil {
+		return rows, err
+	}
+	if rows != archive.Rows {
+		return rows, fmt.Errorf("archive row count mismatch: expected %d, restored %d", archive.Rows, rows)
+	}
+	archive.Status = model.HistoryArchiveStatusRestored
+	archive.Dropped = false
+	archive.RestoredTime = common.GetTimestamp()
+	return rows, archive.Update()
+}
+
+// AutoArchiveHistoryPartitions 定时归档过期的对话历史分区，仅在主节点运行
+func AutoArchiveHistoryPartitions() {
+	for {
+		time.Sleep(historyArchiveCheckPeriod)
+		if !operation_setting.GetHistoryArchiveSetting().Enabled {
+			continue
+		}
+		if err := ArchiveExpiredMESPartitions(); err != nil {
+			common.SysError("failed to archive conversation history partitions: " + err.Error())
+		}
+	}
+}
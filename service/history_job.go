@@ -0,0 +1,103 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"one-api/common"
+	"one-api/model"
+	"strings"
+	"sync"
+
+	"github.com/bytedance/gopkg/util/gopool"
+)
+
+// HistoryJobStatus 对话历史后台任务（去重迁移、密钥轮换）的进度
+type HistoryJobStatus struct {
+	Running   bool     `json:"running"`
+	Total     int      `json:"total"`    // 需要处理的分区（表）数
+	Finished  []string `json:"finished"` // 已完成的分区（表）
+	Rows      int64    `json:"rows"`     // 已处理的记录数
+	Error     string   `json:"error"`
+	StartTime int64    `json:"start_time"`
+	EndTime   int64    `json:"end_time"`
+}
+
+// historyPartitionStepName MySQL 原生分区的名称不包含表名，需要加上表名区分
+func historyPartitionStepName(partition model.MESPartition) string {
+	if strings.HasPrefix(partition.Name, partition.Table) {
+		return partition.Name
+	}
+	return partition.Table + "." + partition.Name
+}
+
+type historyJobStep struct {
+	name string
+	run  func() (int64, error)
+}
+
+// historyJob 同一时间只运行一个实例，逐个执行步骤，遇到错误时停止
+type historyJob struct {
+	name   string
+	mu     sync.Mutex
+	status HistoryJobStatus
+}
+
+func (job *historyJob) Status() HistoryJobStatus {
+	job.mu.Lock()
+	defer job.mu.Unlock()
+	status := job.status
+	status.Finished = append([]string{}, job.status.Finished...)
+	return status
+}
+
+func (job *historyJob) start(steps []historyJobStep) error {
+	if len(steps) == 0 {
+		return errors.New("没有需要处理的分区")
+	}
+	job.mu.Lock()
+	defer job.mu.Unlock()
+	if job.status.Running {
+		return errors.New("已有任务正在运行")
+	}
+	job.status = HistoryJobStatus{
+		Running:   true,
+		Total:     len(steps),
+		Finished:  []string{},
+		StartTime: common.GetTimestamp(),
+	}
+	gopool.Go(func() {
+		job.run(steps)
+	})
+	return nil
+}
+
+func (job *historyJob) run(steps []historyJobStep) {
+	var jobErr error
+	for _, step := range steps {
+		// 避免与归档同时操作同一个分区
+		historyArchiveLock.Lock()
+		rows, err := step.run()
+		historyArchiveLock.Unlock()
+
+		job.mu.Lock()
+		job.status.Rows += rows
+		if err == nil {
+			job.status.Finished = append(job.status.Finished, step.name)
+		}
+		job.mu.Unlock()
+		if err != nil {
+			jobErr = fmt.Errorf("%s: %w", step.name, err)
+			break
+		}
+		common.SysLog(fmt.Sprintf("conversation history %s: %s done, %d rows updated", job.name, step.name, rows))
+	}
+
+	job.mu.Lock()
+	defer job.mu.Unlock()
+	job.status.Running = false
+	job.status.EndTime = common.GetTimestamp()
+	if jobErr != nil {
+		job.status.Error = jobErr.Error()
+		common.SysError(fmt.Sprintf("conversation history %s failed: %s", job.name, jobErr.Error()))
+	}
+}
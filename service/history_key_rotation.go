@@ -0,0 +1,40 @@
+package service
+
+import (
+	"one-api/model"
+)
+
+var historyKeyRotationJob = &historyJob{name: "key rotation"}
+
+// GetHistoryKeyRotation 获取最近一次密钥轮换任务的进度
+func GetHistoryKeyRotation() HistoryJobStatus {
+	return historyKeyRotationJob.Status()
+}
+
+// StartHistoryKeyRotation 在后台按当前加密配置重新处理所有对话历史：启用加密时使用当前 CRYPTO_SECRET 派生的密钥重新加密，
+// 未启用时解密为明文。轮换前需要将旧的 CRYPTO_SECRET 加入 CRYPTO_SECRET_PREVIOUS。
+func StartHistoryKeyRotation() error {
+	var steps []historyJobStep
+	for _, baseTable := range []string{model.ConversationHistoryBaseTable, model.ErrorConversationHistoryBaseTable} {
+		partitions, err := model.GetMESPartitions(baseTable)
+		if err != nil {
+			return err
+		}
+		for _, partition := range partitions {
+			partition := partition
+			steps = append(steps, historyJobStep{
+				name: historyPartitionStepName(partition),
+				run: func() (int64, error) {
+					return model.ReencryptMESPartition(partition, 500)
+				},
+			})
+		}
+	}
+	steps = append(steps, historyJobStep{
+		name: model.ConversationMessage{}.TableName(),
+		run: func() (int64, error) {
+			return model.ReencryptConversationMessages(500)
+		},
+	})
+	return historyKeyRotationJob.start(steps)
+}
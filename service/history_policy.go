@@ -0,0 +1,72 @@
+package service
+
+import (
+	"math/rand"
+	"one-api/common"
+	"one-api/constant"
+	"one-api/setting/operation_setting"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetUserHistoryPolicy 从用户设置中读取对话历史存储策略，未设置时 Mode 为空
+func GetUserHistoryPolicy(setting map[string]interface{}) operation_setting.HistoryPolicy {
+	mode, _ := setting[constant.UserSettingHistoryMode].(string)
+	return operation_setting.HistoryPolicy{
+		Mode:          mode,
+		SampleRate:    historySettingInt(setting[constant.UserSettingHistorySampleRate]),
+		RetentionDays: historySettingInt(setting[constant.UserSettingHistoryRetentionDays]),
+	}
+}
+
+// historySettingInt 用户设置反序列化后数字为 float64，也兼容字符串
+func historySettingInt(value interface{}) int {
+	switch v := value.(type) {
+	case float64:
+		return int(v)
+	case int:
+		return v
+	case string:
+		n, _ := strconv.Atoi(v)
+		return n
+	}
+	return 0
+}
+
+// GetConversationHistoryPolicy 获取当前请求的对话历史存储策略，按令牌、用户、分组的顺序取第一个设置了 Mode 的策略，
+// 都未设置时按全局开关 ConversationHistoryEnabled 决定是否保存
+func GetConversationHistoryPolicy(c *gin.Context) operation_setting.HistoryPolicy {
+	if value, ok := c.Get("token_history_policy"); ok {
+		if policy, ok := value.(operation_setting.HistoryPolicy); ok && policy.Mode != "" {
+			return policy
+		}
+	}
+	if policy := GetUserHistoryPolicy(c.GetStringMap(constant.ContextKeyUserSetting)); policy.Mode != "" {
+		return policy
+	}
+	group := c.GetString("group")
+	if group == "" {
+		group = c.GetString(constant.ContextKeyUserGroup)
+	}
+	if policy := operation_setting.GetHistoryGroupPolicy(group); policy.Mode != "" {
+		return policy
+	}
+	if common.ConversationHistoryEnabled {
+		return operation_setting.HistoryPolicy{Mode: operation_setting.HistoryModeOn}
+	}
+	return operation_setting.HistoryPolicy{Mode: operation_setting.HistoryModeOff}
+}
+
+// ShouldSaveConversationHistory 按策略决定是否保存本次请求，isError 表示保存的是失败请求的错误对话历史
+func ShouldSaveConversationHistory(policy operation_setting.HistoryPolicy, isError bool) bool {
+	switch policy.Mode {
+	case operation_setting.HistoryModeOn:
+		return true
+	case operation_setting.HistoryModeErrorsOnly:
+		return isError
+	case operation_setting.HistoryModeSampled:
+		return rand.Intn(100) < policy.SampleRate
+	}
+	return false
+}
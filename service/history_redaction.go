@@ -0,0 +1,191 @@
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"one-api/setting/operation_setting"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// PIIDetector 对话历史脱敏检测器，Redact 返回替换掉敏感信息后的文本
+type PIIDetector interface {
+	Name() string
+	Redact(text string) string
+}
+
+var (
+	piiDetectors     = make(map[string]PIIDetector)
+	piiDetectorsLock sync.RWMutex
+)
+
+// RegisterPIIDetector 注册脱敏检测器，同名检测器会被替换。检测器在 history_privacy_setting.detectors 中按名称启用。
+func RegisterPIIDetector(detector PIIDetector) {
+	piiDetectorsLock.Lock()
+	defer piiDetectorsLock.Unlock()
+	piiDetectors[detector.Name()] = detector
+}
+
+func getPIIDetector(name string) PIIDetector {
+	piiDetectorsLock.RLock()
+	defer piiDetectorsLock.RUnlock()
+	return piiDetectors[name]
+}
+
+func piiPlaceholder(name string) string {
+	return "[REDACTED_" + strings.ToUpper(name) + "]"
+}
+
+// regexPIIDetector 按正则替换，digitBoundary 要求匹配前后不是数字，避免误伤更长的数字串
+type regexPIIDetector struct {
+	name          string
+	pattern       *regexp.Regexp
+	digitBoundary bool
+}
+
+func (d *regexPIIDetector) Name() string {
+	return d.name
+}
+
+func (d *regexPIIDetector) Redact(text string) string {
+	matches := d.pattern.FindAllStringIndex(text, -1)
+	if len(matches) == 0 {
+		return text
+	}
+	var builder strings.Builder
+	last := 0
+	for _, match := range matches {
+		start, end := match[0], match[1]
+		if d.digitBoundary && (start > 0 && isASCIIDigit(text[start-1]) || end < len(text) && isASCIIDigit(text[end])) {
+			continue
+		}
+		builder.WriteString(text[last:start])
+		builder.WriteString(piiPlaceholder(d.name))
+		last = end
+	}
+	builder.WriteString(text[last:])
+	return builder.String()
+}
+
+func isASCIIDigit(b byte) bool {
+	return b >= '0' && b <= '9'
+}
+
+// dictionaryPIIDetector 替换 history_privacy_setting.dictionary_words 中的词，不区分大小写
+type dictionaryPIIDetector struct {
+	mu      sync.Mutex
+	words   string
+	pattern *regexp.Regexp
+}
+
+func (d *dictionaryPIIDetector) Name() string {
+	return "dictionary"
+}
+
+func (d *dictionaryPIIDetector) getPattern() *regexp.Regexp {
+	words := operation_setting.GetHistoryPrivacySetting().DictionaryWords
+	key := strings.Join(words, "\x00")
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.words == key && (d.pattern != nil || key == "") {
+		return d.pattern
+	}
+	quoted := make([]string, 0, len(words))
+	for _, word := range words {
+		if word = strings.TrimSpace(word); word != "" {
+			quoted = append(quoted, regexp.QuoteMeta(word))
+		}
+	}
+	d.words = key
+	d.pattern = nil
+	if len(quoted) > 0 {
+		d.pattern = regexp.MustCompile("(?i)" + strings.Join(quoted, "|"))
+	}
+	return d.pattern
+}
+
+func (d *dictionaryPIIDetector) Redact(text string) string {
+	pattern := d.getPattern()
+	if pattern == nil {
+		return text
+	}
+	return pattern.ReplaceAllLiteralString(text, piiPlaceholder(d.Name()))
+}
+
+func init() {
+	RegisterPIIDetector(&regexPIIDetector{
+		name:    "email",
+		pattern: regexp.MustCompile(`[A-Za-z0-9._%+\-]+@[A-Za-z0-9.\-]+\.[A-Za-z]{2,}`),
+	})
+	RegisterPIIDetector(&regexPIIDetector{
+		name: "phone",
+		// 中国大陆手机号，以及带国际区号的号码
+		pattern:       regexp.MustCompile(`(?:\+?86[ \-]?)?1[3-9]\d{9}|\+\d{1,3}(?:[ \-]?\d{2,4}){3,5}`),
+		digitBoundary: true,
+	})
+	RegisterPIIDetector(&regexPIIDetector{
+		name: "id_number",
+		// 18 位居民身份证号，以及美国 SSN
+		pattern:       regexp.MustCompile(`[1-9]\d{5}(?:18|19|20)\d{2}(?:0[1-9]|1[0-2])(?:0[1-9]|[12]\d|3[01])\d{3}[\dXx]|\d{3}-\d{2}-\d{4}`),
+		digitBoundary: true,
+	})
+	RegisterPIIDetector(&regexPIIDetector{
+		name: "api_key",
+		// OpenAI / Anthropic、AWS、Google、GitHub、Slack 的密钥格式
+		pattern: regexp.MustCompile(`sk-[A-Za-z0-9_\-]{20,}|AKIA[0-9A-Z]{16}|AIza[0-9A-Za-z_\-]{35}|gh[pousr]_[A-Za-z0-9]{36,}|xox[abposr]-[A-Za-z0-9\-]{10,}`),
+	})
+	RegisterPIIDetector(&dictionaryPIIDetector{})
+}
+
+// RedactHistoryText 使用启用的检测器依次脱敏文本，未启用脱敏时原样返回
+func RedactHistoryText(text string) string {
+	setting := operation_setting.GetHistoryPrivacySetting()
+	if !setting.RedactionEnabled || text == "" {
+		return text
+	}
+	for _, name := range setting.Detectors {
+		if detector := getPIIDetector(name); detector != nil {
+			text = detector.Redact(text)
+		}
+	}
+	return text
+}
+
+// RedactHistoryJson 脱敏 JSON 中的所有字符串值（不包括键名），在保存对话历史之前调用
+func RedactHistoryJson(rawJson string) (string, error) {
+	if !operation_setting.GetHistoryPrivacySetting().RedactionEnabled {
+		return rawJson, nil
+	}
+	decoder := json.NewDecoder(strings.NewReader(rawJson))
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return "", err
+	}
+	value = redactHistoryValue(value)
+
+	var buffer bytes.Buffer
+	encoder := json.NewEncoder(&buffer)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(value); err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(buffer.String(), "\n"), nil
+}
+
+func redactHistoryValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case string:
+		return RedactHistoryText(v)
+	case map[string]interface{}:
+		for key, item := range v {
+			v[key] = redactHistoryValue(item)
+		}
+	case []interface{}:
+		for i, item := range v {
+			v[i] = redactHistoryValue(item)
+		}
+	}
+	return value
+}
@@ -1,6 +1,7 @@
 package service
 
 import (
+	"one-api/constant"
 	"one-api/dto"
 	relaycommon "one-api/relay/common"
 
@@ -28,6 +29,12 @@ func GenerateTextOtherInfo(ctx *gin.Context, relayInfo *relaycommon.RelayInfo, m
 	adminInfo := make(map[string]interface{})
 	adminInfo["use_channel"] = ctx.GetStringSlice("use_channel")
 	other["admin_info"] = adminInfo
+	if batchId := ctx.GetString(constant.ContextKeyBatchId); batchId != "" {
+		other["batch_id"] = batchId
+	}
+	if hedge := GetHedgeGroup(ctx); hedge != nil {
+		other["hedge"] = hedge.Info()
+	}
 	return other
 }
 
@@ -0,0 +1,195 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"one-api/common"
+	"one-api/model"
+	"time"
+)
+
+const (
+	PaymentProviderEpay    = "epay"
+	PaymentProviderStripe  = "stripe"
+	PaymentProviderWebhook = "webhook"
+	PaymentProviderMock    = "mock"
+)
+
+var ErrPaymentNotConfigured = errors.New("当前管理员未配置支付信息")
+
+// PaymentOrder 发往支付渠道的订单
+type PaymentOrder struct {
+	TradeNo       string
+	Name          string
+	Money         float64
+	PaymentMethod string
+	NotifyUrl     string
+	ReturnUrl     string
+}
+
+// PaymentCheckout 创建订单的结果，前端向 Url 提交 Params 跳转到支付页面
+type PaymentCheckout struct {
+	Url             string
+	Method          string // 提交方式，为空时为 POST
+	Params          map[string]string
+	ProviderTradeNo string
+}
+
+// PaymentResult 回调或查询得到的订单状态，Status 为 model.TopUpStatus*，为空表示无需处理的通知
+type PaymentResult struct {
+	TradeNo         string
+	ProviderTradeNo string
+	Status          string
+	Money           float64 // 渠道返回的支付金额，为 0 表示未返回
+}
+
+// PaymentProvider 支付渠道
+type PaymentProvider interface {
+	GetName() string
+	// CreateOrder 在渠道创建订单，返回跳转到支付页面的信息
+	CreateOrder(order *PaymentOrder) (*PaymentCheckout, error)
+	// VerifyCallback 校验渠道的异步通知并解析订单状态
+	VerifyCallback(req *http.Request) (*PaymentResult, error)
+	// CallbackResponse 返回给渠道的应答，success 为 false 时渠道会重试
+	CallbackResponse(success bool) (int, string)
+	// QueryOrder 主动查询订单在渠道的状态
+	QueryOrder(topUp *model.TopUp) (*PaymentResult, error)
+	// Refund 原路退款 money，不修改本地订单状态
+	Refund(topUp *model.TopUp, money float64) error
+}
+
+// GetPaymentProvider 返回已配置的支付渠道，未配置时返回 ErrPaymentNotConfigured
+func GetPaymentProvider(name string) (PaymentProvider, error) {
+	var provider PaymentProvider
+	switch name {
+	case "", PaymentProviderEpay:
+		provider = newEpayProvider()
+	case PaymentProviderStripe:
+		provider = newStripeProvider()
+	case PaymentProviderWebhook:
+		provider = newWebhookPaymentProvider()
+	case PaymentProviderMock:
+		// 模拟渠道直接确认支付，仅在调试模式下可用
+		if common.DebugEnabled {
+			provider = mockPaymentProvider
+		}
+	default:
+		return nil, fmt.Errorf("未知的支付渠道: %s", name)
+	}
+	if provider == nil {
+		return nil, ErrPaymentNotConfigured
+	}
+	return provider, nil
+}
+
+// GetPaymentNotifyUrl 支付渠道的异步通知地址，易支付沿用原有地址
+func GetPaymentNotifyUrl(provider string) string {
+	if provider == "" || provider == PaymentProviderEpay {
+		return GetCallbackAddress() + "/api/user/epay/notify"
+	}
+	return GetCallbackAddress() + "/api/user/payment/" + provider + "/notify"
+}
+
+// HandlePaymentResult 按渠道返回的状态更新订单，重复的通知不会重复入账。返回 error 时渠道应当重试。
+func HandlePaymentResult(provider PaymentProvider, result *PaymentResult) error {
+	if result.Status == "" {
+		return nil
+	}
+	topUp := model.GetTopUpByTradeNo(result.TradeNo)
+	if topUp == nil {
+		return fmt.Errorf("订单 %s 不存在", result.TradeNo)
+	}
+	if topUp.PaymentProvider != provider.GetName() && !(topUp.PaymentProvider == "" && provider.GetName() == PaymentProviderEpay) {
+		return fmt.Errorf("订单 %s 不属于支付渠道 %s", result.TradeNo, provider.GetName())
+	}
+	switch result.Status {
+	case model.TopUpStatusSuccess:
+		if result.Money > 0 && math.Abs(result.Money-topUp.Money) >= 0.01 {
+			return fmt.Errorf("订单 %s 支付金额 %.2f 与订单金额 %.2f 不一致", result.TradeNo, result.Money, topUp.Money)
+		}
+		topUp, completed, err := model.CompleteTopUp(result.TradeNo, result.ProviderTradeNo)
+		if err != nil || !completed {
+			return err
+		}
+		common.SysLog(fmt.Sprintf("payment %s of user %d completed via %s", topUp.TradeNo, topUp.UserId, provider.GetName()))
+		recordTopUpLog(topUp)
+	case model.TopUpStatusFailed:
+		if _, err := model.FailTopUp(result.TradeNo); err != nil {
+			return err
+		}
+	case model.TopUpStatusRefunded:
+		// 在渠道后台直接退款时同步收回额度，按渠道返回的退款金额部分收回
+		money := result.Money
+		if money <= 0 || money > topUp.Money {
+			money = topUp.Money
+		}
+		topUp, reclaimed, err := model.RefundTopUp(result.TradeNo, money)
+		if errors.Is(err, model.ErrTopUpStatusConflict) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		model.RecordLog(topUp.UserId, model.LogTypeSystem, fmt.Sprintf("订单 %s 已退款，收回额度: %v", topUp.TradeNo, common.LogQuota(reclaimed)))
+	}
+	return nil
+}
+
+func recordTopUpLog(topUp *model.TopUp) {
+	if topUp.PlanId == 0 {
+		model.RecordLog(topUp.UserId, model.LogTypeTopup, fmt.Sprintf("使用在线充值成功，充值金额: %v，支付金额：%f", common.LogQuota(topUp.GetQuota()), topUp.Money))
+		return
+	}
+	subscription, _ := model.GetActiveSubscription(topUp.UserId)
+	if subscription == nil {
+		return
+	}
+	model.RecordLog(topUp.UserId, model.LogTypeTopup, fmt.Sprintf("订阅套餐 %s 支付成功，包含额度: %v，有效期至 %s，支付金额：%f",
+		subscription.PlanName, common.LogQuota(subscription.IncludedQuota), time.Unix(subscription.EndTime, 0).Format("2006-01-02 15:04:05"), topUp.Money))
+}
+
+// RefundPayment 通过原支付渠道退款并收回额度，money 为 0 时全额退款
+func RefundPayment(topUp *model.TopUp, money float64) (int, error) {
+	if topUp.Status != model.TopUpStatusSuccess {
+		return 0, model.ErrTopUpStatusConflict
+	}
+	if money <= 0 || money > topUp.Money {
+		money = topUp.Money
+	}
+	provider, err := GetPaymentProvider(topUp.PaymentProvider)
+	if err != nil {
+		return 0, err
+	}
+	// 先标记为退款中，并发的退款请求不会重复向渠道退款
+	if err = model.StartTopUpRefund(topUp); err != nil {
+		return 0, err
+	}
+	if err = provider.Refund(topUp, money); err != nil {
+		if cancelErr := model.CancelTopUpRefund(topUp); cancelErr != nil {
+			common.SysError(fmt.Sprintf("failed to cancel refund of payment %s: %s", topUp.TradeNo, cancelErr.Error()))
+		}
+		return 0, err
+	}
+	_, reclaimed, err := model.RefundTopUp(topUp.TradeNo, money)
+	if errors.Is(err, model.ErrTopUpStatusConflict) {
+		// 渠道的退款通知先到达，额度已经收回
+		return 0, nil
+	}
+	return reclaimed, err
+}
+
+// SyncPayment 主动查询订单在渠道的状态并更新本地订单，用于回调丢失的情况
+func SyncPayment(topUp *model.TopUp) (*PaymentResult, error) {
+	provider, err := GetPaymentProvider(topUp.PaymentProvider)
+	if err != nil {
+		return nil, err
+	}
+	result, err := provider.QueryOrder(topUp)
+	if err != nil {
+		return nil, err
+	}
+	result.TradeNo = topUp.TradeNo
+	return result, HandlePaymentResult(provider, result)
+}
@@ -0,0 +1,156 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"one-api/common"
+	"one-api/model"
+	"one-api/setting"
+	"strconv"
+	"strings"
+
+	"github.com/Calcium-Ion/go-epay/epay"
+	"github.com/samber/lo"
+)
+
+type epayProvider struct {
+	client *epay.Client
+}
+
+func newEpayProvider() PaymentProvider {
+	if setting.PayAddress == "" || setting.EpayId == "" || setting.EpayKey == "" {
+		return nil
+	}
+	client, err := epay.NewClient(&epay.Config{
+		PartnerID: setting.EpayId,
+		Key:       setting.EpayKey,
+	}, setting.PayAddress)
+	if err != nil {
+		return nil
+	}
+	return &epayProvider{client: client}
+}
+
+func (p *epayProvider) GetName() string {
+	return PaymentProviderEpay
+}
+
+func (p *epayProvider) CreateOrder(order *PaymentOrder) (*PaymentCheckout, error) {
+	notifyUrl, err := url.Parse(order.NotifyUrl)
+	if err != nil {
+		return nil, err
+	}
+	returnUrl, err := url.Parse(order.ReturnUrl)
+	if err != nil {
+		return nil, err
+	}
+	uri, params, err := p.client.Purchase(&epay.PurchaseArgs{
+		Type:           order.PaymentMethod,
+		ServiceTradeNo: order.TradeNo,
+		Name:           order.Name,
+		Money:          strconv.FormatFloat(order.Money, 'f', 2, 64),
+		Device:         epay.PC,
+		NotifyUrl:      notifyUrl,
+		ReturnUrl:      returnUrl,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &PaymentCheckout{Url: uri, Params: params}, nil
+}
+
+func (p *epayProvider) VerifyCallback(req *http.Request) (*PaymentResult, error) {
+	query := req.URL.Query()
+	params := lo.Reduce(lo.Keys(query), func(r map[string]string, t string, i int) map[string]string {
+		r[t] = query.Get(t)
+		return r
+	}, map[string]string{})
+	verifyInfo, err := p.client.Verify(params)
+	if err != nil {
+		return nil, err
+	}
+	if !verifyInfo.VerifyStatus {
+		return nil, errors.New("易支付回调签名验证失败")
+	}
+	result := &PaymentResult{
+		TradeNo:         verifyInfo.ServiceTradeNo,
+		ProviderTradeNo: verifyInfo.TradeNo,
+	}
+	if verifyInfo.TradeStatus == epay.StatusTradeSuccess {
+		result.Status = model.TopUpStatusSuccess
+		result.Money, _ = strconv.ParseFloat(verifyInfo.Money, 64)
+	} else {
+		common.SysLog(fmt.Sprintf("易支付异常回调: %v", verifyInfo))
+	}
+	return result, nil
+}
+
+func (p *epayProvider) CallbackResponse(success bool) (int, string) {
+	if success {
+		return http.StatusOK, "success"
+	}
+	return http.StatusOK, "fail"
+}
+
+// epayApiResponse 易支付 api.php 接口的返回
+type epayApiResponse struct {
+	Code    int    `json:"code"`
+	Msg     string `json:"msg"`
+	TradeNo string `json:"trade_no"`
+	Money   string `json:"money"`
+	Status  int    `json:"status"` // 1 为已支付
+}
+
+// callApi 调用易支付 api.php 接口，查询接口使用 GET 传参，退款接口使用 POST 传参
+func (p *epayProvider) callApi(act string, params url.Values) (*epayApiResponse, error) {
+	apiUrl := *p.client.BaseUrl
+	apiUrl.Path = strings.TrimSuffix(apiUrl.Path, "/") + "/api.php"
+	params.Set("pid", setting.EpayId)
+	params.Set("key", setting.EpayKey)
+	var resp *http.Response
+	var err error
+	if act == "refund" {
+		apiUrl.RawQuery = url.Values{"act": {act}}.Encode()
+		resp, err = GetImpatientHttpClient().PostForm(apiUrl.String(), params)
+	} else {
+		params.Set("act", act)
+		apiUrl.RawQuery = params.Encode()
+		resp, err = GetImpatientHttpClient().Get(apiUrl.String())
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var response epayApiResponse
+	if err = json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return nil, err
+	}
+	if response.Code != 1 {
+		return nil, fmt.Errorf("易支付接口返回错误: %s", response.Msg)
+	}
+	return &response, nil
+}
+
+func (p *epayProvider) QueryOrder(topUp *model.TopUp) (*PaymentResult, error) {
+	response, err := p.callApi("order", url.Values{"out_trade_no": {topUp.TradeNo}})
+	if err != nil {
+		return nil, err
+	}
+	result := &PaymentResult{TradeNo: topUp.TradeNo, ProviderTradeNo: response.TradeNo}
+	if response.Status == 1 {
+		result.Status = model.TopUpStatusSuccess
+		result.Money, _ = strconv.ParseFloat(response.Money, 64)
+	}
+	return result, nil
+}
+
+func (p *epayProvider) Refund(topUp *model.TopUp, money float64) error {
+	_, err := p.callApi("refund", url.Values{
+		"out_trade_no": {topUp.TradeNo},
+		"money":        {strconv.FormatFloat(money, 'f', 2, 64)},
+	})
+	return err
+}
@@ -0,0 +1,110 @@
+package service
+
+import (
+	"crypto/hmac"
+	"errors"
+	"net/http"
+	"one-api/common"
+	"one-api/model"
+	"strconv"
+	"sync"
+)
+
+// MockPaymentProvider 本地模拟支付渠道，用于调试和测试支付流程，不会产生真实交易。
+// 创建订单时返回指向回调地址的表单，提交后即视为支付成功；订单状态只保存在内存中。
+type MockPaymentProvider struct {
+	secret string
+	orders sync.Map // trade_no -> *PaymentResult
+}
+
+var mockPaymentProvider = &MockPaymentProvider{secret: common.GetRandomString(32)}
+
+func (p *MockPaymentProvider) GetName() string {
+	return PaymentProviderMock
+}
+
+func (p *MockPaymentProvider) sign(tradeNo string, status string) string {
+	return generateSignature(p.secret, []byte(tradeNo+"|"+status))
+}
+
+// CallbackParams 生成模拟渠道的回调参数，status 为 model.TopUpStatusSuccess 或 model.TopUpStatusFailed
+func (p *MockPaymentProvider) CallbackParams(tradeNo string, status string) map[string]string {
+	return map[string]string{
+		"trade_no": tradeNo,
+		"status":   status,
+		"sign":     p.sign(tradeNo, status),
+	}
+}
+
+// SetStatus 修改订单在模拟渠道中的状态，用于模拟回调丢失后主动查询
+func (p *MockPaymentProvider) SetStatus(tradeNo string, status string) {
+	if value, ok := p.orders.Load(tradeNo); ok {
+		result := *value.(*PaymentResult)
+		result.Status = status
+		p.orders.Store(tradeNo, &result)
+	}
+}
+
+func (p *MockPaymentProvider) CreateOrder(order *PaymentOrder) (*PaymentCheckout, error) {
+	providerTradeNo := "MOCK" + order.TradeNo
+	p.orders.Store(order.TradeNo, &PaymentResult{
+		TradeNo:         order.TradeNo,
+		ProviderTradeNo: providerTradeNo,
+		Money:           order.Money,
+	})
+	return &PaymentCheckout{
+		Url:             order.NotifyUrl,
+		Params:          p.CallbackParams(order.TradeNo, model.TopUpStatusSuccess),
+		ProviderTradeNo: providerTradeNo,
+	}, nil
+}
+
+func (p *MockPaymentProvider) VerifyCallback(req *http.Request) (*PaymentResult, error) {
+	if err := req.ParseForm(); err != nil {
+		return nil, err
+	}
+	tradeNo := req.Form.Get("trade_no")
+	status := req.Form.Get("status")
+	if !hmac.Equal([]byte(req.Form.Get("sign")), []byte(p.sign(tradeNo, status))) {
+		return nil, errors.New("mock payment signature mismatch")
+	}
+	value, ok := p.orders.Load(tradeNo)
+	if !ok {
+		return nil, errors.New("mock payment order not found")
+	}
+	p.SetStatus(tradeNo, status)
+	result := *value.(*PaymentResult)
+	result.Status = status
+	return &result, nil
+}
+
+func (p *MockPaymentProvider) CallbackResponse(success bool) (int, string) {
+	if success {
+		return http.StatusOK, "success"
+	}
+	return http.StatusBadRequest, "fail"
+}
+
+func (p *MockPaymentProvider) QueryOrder(topUp *model.TopUp) (*PaymentResult, error) {
+	value, ok := p.orders.Load(topUp.TradeNo)
+	if !ok {
+		return nil, errors.New("mock payment order not found")
+	}
+	result := *value.(*PaymentResult)
+	return &result, nil
+}
+
+func (p *MockPaymentProvider) Refund(topUp *model.TopUp, money float64) error {
+	value, ok := p.orders.Load(topUp.TradeNo)
+	if !ok {
+		return errors.New("mock payment order not found")
+	}
+	if value.(*PaymentResult).Status != model.TopUpStatusSuccess {
+		return errors.New("mock payment order is not paid")
+	}
+	if money > topUp.Money {
+		return errors.New("refund money exceeds paid money: " + strconv.FormatFloat(topUp.Money, 'f', 2, 64))
+	}
+	p.SetStatus(topUp.TradeNo, model.TopUpStatusRefunded)
+	return nil
+}
@@ -0,0 +1,247 @@
+package service
+
+import (
+	"crypto/hmac"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"one-api/model"
+	"one-api/setting"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	stripeApiBase = "https://api.stripe.com/v1"
+	// stripeSignatureTolerance 回调签名时间戳允许的偏差，防止重放
+	stripeSignatureTolerance = 5 * time.Minute
+)
+
+// stripeZeroDecimalCurrencies 没有小数单位的货币，金额不乘以 100
+var stripeZeroDecimalCurrencies = map[string]bool{
+	"bif": true, "clp": true, "djf": true, "gnf": true, "jpy": true, "kmf": true, "krw": true, "mga": true,
+	"pyg": true, "rwf": true, "ugx": true, "vnd": true, "vuv": true, "xaf": true, "xof": true, "xpf": true,
+}
+
+type stripeProvider struct {
+	secretKey     string
+	webhookSecret string
+	currency      string
+}
+
+func newStripeProvider() PaymentProvider {
+	if setting.StripeSecretKey == "" || setting.StripeWebhookSecret == "" {
+		return nil
+	}
+	currency := strings.ToLower(setting.StripeCurrency)
+	if currency == "" {
+		currency = "usd"
+	}
+	return &stripeProvider{
+		secretKey:     setting.StripeSecretKey,
+		webhookSecret: setting.StripeWebhookSecret,
+		currency:      currency,
+	}
+}
+
+func (p *stripeProvider) GetName() string {
+	return PaymentProviderStripe
+}
+
+func (p *stripeProvider) toMinorUnit(money float64) int64 {
+	if stripeZeroDecimalCurrencies[p.currency] {
+		return int64(math.Round(money))
+	}
+	return int64(math.Round(money * 100))
+}
+
+func (p *stripeProvider) fromMinorUnit(amount int64) float64 {
+	if stripeZeroDecimalCurrencies[p.currency] {
+		return float64(amount)
+	}
+	return float64(amount) / 100
+}
+
+type stripeCheckoutSession struct {
+	Id                string            `json:"id"`
+	Url               string            `json:"url"`
+	Status            string            `json:"status"`         // open, complete, expired
+	PaymentStatus     string            `json:"payment_status"` // paid, unpaid, no_payment_required
+	ClientReferenceId string            `json:"client_reference_id"`
+	AmountTotal       int64             `json:"amount_total"`
+	PaymentIntent     string            `json:"payment_intent"`
+	Metadata          map[string]string `json:"metadata"`
+}
+
+// result 将 Checkout Session 的状态转换为订单状态，尚未支付完成时 Status 为空
+func (p *stripeProvider) result(session *stripeCheckoutSession) *PaymentResult {
+	result := &PaymentResult{
+		TradeNo:         session.ClientReferenceId,
+		ProviderTradeNo: session.Id,
+	}
+	if result.TradeNo == "" {
+		result.TradeNo = session.Metadata["trade_no"]
+	}
+	switch {
+	case session.PaymentStatus == "paid":
+		result.Status = model.TopUpStatusSuccess
+		result.Money = p.fromMinorUnit(session.AmountTotal)
+	case session.Status == "expired":
+		result.Status = model.TopUpStatusFailed
+	}
+	return result
+}
+
+func (p *stripeProvider) doRequest(method string, path string, form url.Values, v any) error {
+	var body io.Reader
+	if form != nil {
+		body = strings.NewReader(form.Encode())
+	}
+	req, err := http.NewRequest(method, stripeApiBase+path, body)
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth(p.secretKey, "")
+	if form != nil {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	resp, err := GetImpatientHttpClient().Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		var errResp struct {
+			Error struct {
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		_ = json.NewDecoder(resp.Body).Decode(&errResp)
+		return fmt.Errorf("stripe request failed with status code %d: %s", resp.StatusCode, errResp.Error.Message)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+func (p *stripeProvider) CreateOrder(order *PaymentOrder) (*PaymentCheckout, error) {
+	form := url.Values{}
+	form.Set("mode", "payment")
+	form.Set("success_url", order.ReturnUrl)
+	form.Set("cancel_url", order.ReturnUrl)
+	form.Set("client_reference_id", order.TradeNo)
+	form.Set("metadata[trade_no]", order.TradeNo)
+	form.Set("line_items[0][quantity]", "1")
+	form.Set("line_items[0][price_data][currency]", p.currency)
+	form.Set("line_items[0][price_data][unit_amount]", strconv.FormatInt(p.toMinorUnit(order.Money), 10))
+	form.Set("line_items[0][price_data][product_data][name]", order.Name)
+	var session stripeCheckoutSession
+	if err := p.doRequest(http.MethodPost, "/checkout/sessions", form, &session); err != nil {
+		return nil, err
+	}
+	// Checkout 页面只能通过 GET 打开
+	return &PaymentCheckout{Url: session.Url, Method: http.MethodGet, ProviderTradeNo: session.Id}, nil
+}
+
+// verifySignature 校验 Stripe-Signature 请求头：t=<时间戳>,v1=<签名>，签名为 HMAC-SHA256(t + "." + body)
+func (p *stripeProvider) verifySignature(header string, payload []byte) error {
+	var timestamp string
+	var signatures []string
+	for _, part := range strings.Split(header, ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+		switch key {
+		case "t":
+			timestamp = value
+		case "v1":
+			signatures = append(signatures, value)
+		}
+	}
+	t, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil || len(signatures) == 0 {
+		return errors.New("invalid stripe signature header")
+	}
+	if time.Since(time.Unix(t, 0)).Abs() > stripeSignatureTolerance {
+		return errors.New("stripe signature timestamp out of tolerance")
+	}
+	expected := generateSignature(p.webhookSecret, []byte(timestamp+"."+string(payload)))
+	for _, signature := range signatures {
+		if hmac.Equal([]byte(signature), []byte(expected)) {
+			return nil
+		}
+	}
+	return errors.New("stripe signature mismatch")
+}
+
+func (p *stripeProvider) VerifyCallback(req *http.Request) (*PaymentResult, error) {
+	payload, err := io.ReadAll(io.LimitReader(req.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	if err = p.verifySignature(req.Header.Get("Stripe-Signature"), payload); err != nil {
+		return nil, err
+	}
+	var event struct {
+		Type string `json:"type"`
+		Data struct {
+			Object stripeCheckoutSession `json:"object"`
+		} `json:"data"`
+	}
+	if err = json.Unmarshal(payload, &event); err != nil {
+		return nil, err
+	}
+	switch event.Type {
+	case "checkout.session.completed", "checkout.session.async_payment_succeeded", "checkout.session.expired":
+		return p.result(&event.Data.Object), nil
+	case "checkout.session.async_payment_failed":
+		result := p.result(&event.Data.Object)
+		result.Status = model.TopUpStatusFailed
+		return result, nil
+	}
+	// 其他事件无需处理
+	return &PaymentResult{}, nil
+}
+
+func (p *stripeProvider) CallbackResponse(success bool) (int, string) {
+	if success {
+		return http.StatusOK, "ok"
+	}
+	return http.StatusBadRequest, "fail"
+}
+
+func (p *stripeProvider) getSession(topUp *model.TopUp) (*stripeCheckoutSession, error) {
+	if topUp.ProviderTradeNo == "" {
+		return nil, errors.New("订单没有对应的 Stripe Checkout Session")
+	}
+	var session stripeCheckoutSession
+	err := p.doRequest(http.MethodGet, "/checkout/sessions/"+url.PathEscape(topUp.ProviderTradeNo), nil, &session)
+	return &session, err
+}
+
+func (p *stripeProvider) QueryOrder(topUp *model.TopUp) (*PaymentResult, error) {
+	session, err := p.getSession(topUp)
+	if err != nil {
+		return nil, err
+	}
+	return p.result(session), nil
+}
+
+func (p *stripeProvider) Refund(topUp *model.TopUp, money float64) error {
+	session, err := p.getSession(topUp)
+	if err != nil {
+		return err
+	}
+	if session.PaymentIntent == "" {
+		return errors.New("订单尚未支付")
+	}
+	form := url.Values{
+		"payment_intent": {session.PaymentIntent},
+		"amount":         {strconv.FormatInt(p.toMinorUnit(money), 10)},
+	}
+	var refund struct {
+		Id string `json:"id"`
+	}
+	return p.doRequest(http.MethodPost, "/refunds", form, &refund)
+}
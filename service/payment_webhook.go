@@ -0,0 +1,175 @@
+package service
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"one-api/model"
+	"one-api/setting"
+)
+
+// 通用支付网关协议：
+// 本站向 PaymentWebhookAddress POST JSON 请求（action 为 create、query 或 refund），
+// 网关异步通知 POST JSON 到回调地址。双方都在 X-Payment-Signature 请求头中携带 HMAC-SHA256(secret, body) 的十六进制签名。
+const paymentWebhookSignatureHeader = "X-Payment-Signature"
+
+type webhookPaymentRequest struct {
+	Action          string  `json:"action"`
+	TradeNo         string  `json:"trade_no"`
+	ProviderTradeNo string  `json:"provider_trade_no,omitempty"`
+	Name            string  `json:"name,omitempty"`
+	Money           float64 `json:"money,omitempty"`
+	PaymentMethod   string  `json:"payment_method,omitempty"`
+	NotifyUrl       string  `json:"notify_url,omitempty"`
+	ReturnUrl       string  `json:"return_url,omitempty"`
+}
+
+// webhookPaymentResponse 网关的响应和异步通知，status 为 pending、success、failed 或 refunded
+type webhookPaymentResponse struct {
+	Url             string            `json:"url"`
+	Method          string            `json:"method"`
+	Params          map[string]string `json:"params"`
+	TradeNo         string            `json:"trade_no"`
+	ProviderTradeNo string            `json:"provider_trade_no"`
+	Status          string            `json:"status"`
+	Money           float64           `json:"money"`
+	Message         string            `json:"message"`
+}
+
+func (response *webhookPaymentResponse) result() *PaymentResult {
+	result := &PaymentResult{
+		TradeNo:         response.TradeNo,
+		ProviderTradeNo: response.ProviderTradeNo,
+		Money:           response.Money,
+	}
+	switch response.Status {
+	case model.TopUpStatusSuccess, model.TopUpStatusFailed, model.TopUpStatusRefunded:
+		result.Status = response.Status
+	}
+	return result
+}
+
+type webhookPaymentProvider struct {
+	address string
+	secret  string
+}
+
+func newWebhookPaymentProvider() PaymentProvider {
+	if setting.PaymentWebhookAddress == "" || setting.PaymentWebhookSecret == "" {
+		return nil
+	}
+	return &webhookPaymentProvider{
+		address: setting.PaymentWebhookAddress,
+		secret:  setting.PaymentWebhookSecret,
+	}
+}
+
+func (p *webhookPaymentProvider) GetName() string {
+	return PaymentProviderWebhook
+}
+
+func (p *webhookPaymentProvider) call(request *webhookPaymentRequest) (*webhookPaymentResponse, error) {
+	payload, err := json.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(http.MethodPost, p.address, bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(paymentWebhookSignatureHeader, generateSignature(p.secret, payload))
+	resp, err := GetImpatientHttpClient().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	// 网关的响应同样需要签名，防止地址被劫持后伪造支付结果
+	if !hmac.Equal([]byte(resp.Header.Get(paymentWebhookSignatureHeader)), []byte(generateSignature(p.secret, body))) {
+		return nil, errors.New("payment gateway response signature mismatch")
+	}
+	var response webhookPaymentResponse
+	if err = json.Unmarshal(body, &response); err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("payment gateway request failed with status code %d: %s", resp.StatusCode, response.Message)
+	}
+	return &response, nil
+}
+
+func (p *webhookPaymentProvider) CreateOrder(order *PaymentOrder) (*PaymentCheckout, error) {
+	response, err := p.call(&webhookPaymentRequest{
+		Action:        "create",
+		TradeNo:       order.TradeNo,
+		Name:          order.Name,
+		Money:         order.Money,
+		PaymentMethod: order.PaymentMethod,
+		NotifyUrl:     order.NotifyUrl,
+		ReturnUrl:     order.ReturnUrl,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if response.Url == "" {
+		return nil, errors.New("payment gateway returned empty url")
+	}
+	return &PaymentCheckout{
+		Url:             response.Url,
+		Method:          response.Method,
+		Params:          response.Params,
+		ProviderTradeNo: response.ProviderTradeNo,
+	}, nil
+}
+
+func (p *webhookPaymentProvider) VerifyCallback(req *http.Request) (*PaymentResult, error) {
+	payload, err := io.ReadAll(io.LimitReader(req.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	if !hmac.Equal([]byte(req.Header.Get(paymentWebhookSignatureHeader)), []byte(generateSignature(p.secret, payload))) {
+		return nil, errors.New("payment callback signature mismatch")
+	}
+	var response webhookPaymentResponse
+	if err = json.Unmarshal(payload, &response); err != nil {
+		return nil, err
+	}
+	return response.result(), nil
+}
+
+func (p *webhookPaymentProvider) CallbackResponse(success bool) (int, string) {
+	if success {
+		return http.StatusOK, "success"
+	}
+	return http.StatusBadRequest, "fail"
+}
+
+func (p *webhookPaymentProvider) QueryOrder(topUp *model.TopUp) (*PaymentResult, error) {
+	response, err := p.call(&webhookPaymentRequest{
+		Action:          "query",
+		TradeNo:         topUp.TradeNo,
+		ProviderTradeNo: topUp.ProviderTradeNo,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return response.result(), nil
+}
+
+func (p *webhookPaymentProvider) Refund(topUp *model.TopUp, money float64) error {
+	_, err := p.call(&webhookPaymentRequest{
+		Action:          "refund",
+		TradeNo:         topUp.TradeNo,
+		ProviderTradeNo: topUp.ProviderTradeNo,
+		Money:           money,
+	})
+	return err
+}
@@ -0,0 +1,145 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"one-api/common"
+	"one-api/dto"
+	relaycommon "one-api/relay/common"
+	"one-api/relay/helper"
+	"strings"
+)
+
+func sseEvent(builder *strings.Builder, eventType string, data any) {
+	jsonData, err := json.Marshal(data)
+	if err != nil {
+		common.SysError("error marshalling stream response: " + err.Error())
+		return
+	}
+	builder.WriteString(fmt.Sprintf("event: %s\ndata: %s\n\n", eventType, jsonData))
+}
+
+// claudeTranslator 将 OpenAI 格式响应转换为 Claude Messages 格式
+type claudeTranslator struct {
+	info *relaycommon.RelayInfo
+}
+
+// NewClaudeTranslator 创建 Claude 协议转换器，转换状态与渠道适配器使用的 RelayInfo 相互独立
+func NewClaudeTranslator(info *relaycommon.RelayInfo) helper.ProtocolTranslator {
+	return &claudeTranslator{
+		info: &relaycommon.RelayInfo{
+			PromptTokens: info.PromptTokens,
+			ClaudeConvertInfo: &relaycommon.ClaudeConvertInfo{
+				LastMessagesType: relaycommon.LastMessageTypeNone,
+			},
+		},
+	}
+}
+
+func (t *claudeTranslator) convert(chunk *dto.ChatCompletionsStreamResponse) string {
+	var builder strings.Builder
+	t.info.SendResponseCount++
+	for _, resp := range StreamResponseOpenAI2Claude(chunk, t.info) {
+		sseEvent(&builder, resp.Type, resp)
+	}
+	return builder.String()
+}
+
+func (t *claudeTranslator) start(chunk *dto.ChatCompletionsStreamResponse) string {
+	if t.info.SendResponseCount > 0 {
+		return ""
+	}
+	// 第一个数据块只生成 message_start，内容在之后单独转换
+	return t.convert(&dto.ChatCompletionsStreamResponse{Id: chunk.Id, Model: chunk.Model})
+}
+
+func (t *claudeTranslator) TranslateChunk(data string) ([]byte, error) {
+	var chunk dto.ChatCompletionsStreamResponse
+	if err := json.Unmarshal(common.StringToByteSlice(data), &chunk); err != nil {
+		return nil, err
+	}
+	if chunk.Usage != nil {
+		t.info.ClaudeConvertInfo.Usage = chunk.Usage
+	}
+	out := t.start(&chunk)
+	if len(chunk.Choices) == 0 {
+		return []byte(out), nil
+	}
+	// 结束原因单独记录，避免与内容在同一个数据块时内容被丢弃
+	choice := chunk.Choices[0]
+	if choice.FinishReason != nil && *choice.FinishReason != "" {
+		t.info.FinishReason = *choice.FinishReason
+		choice.FinishReason = nil
+	}
+	chunk.Choices = []dto.ChatCompletionsStreamResponseChoice{choice}
+	out += t.convert(&chunk)
+	return []byte(out), nil
+}
+
+func (t *claudeTranslator) TranslateStreamEnd(usage *dto.Usage) []byte {
+	out := t.start(&dto.ChatCompletionsStreamResponse{})
+	if usage != nil {
+		t.info.ClaudeConvertInfo.Usage = usage
+	}
+	t.info.ClaudeConvertInfo.Done = true
+	out += t.convert(&dto.ChatCompletionsStreamResponse{
+		Choices: []dto.ChatCompletionsStreamResponseChoice{{}},
+	})
+	return []byte(out)
+}
+
+func (t *claudeTranslator) TranslateResponse(body []byte, usage *dto.Usage) ([]byte, error) {
+	var openAIResponse dto.OpenAITextResponse
+	if err := json.Unmarshal(body, &openAIResponse); err != nil {
+		return nil, err
+	}
+	if usage != nil {
+		openAIResponse.Usage = *usage
+	}
+	return json.Marshal(ResponseOpenAI2Claude(&openAIResponse, t.info))
+}
+
+// responsesTranslator 将 OpenAI Chat Completions 格式响应转换为 Responses API 格式
+type responsesTranslator struct {
+	info      *relaycommon.RelayInfo
+	converter *ResponsesStreamConverter
+}
+
+// NewResponsesTranslator 创建 Responses API 协议转换器
+func NewResponsesTranslator(info *relaycommon.RelayInfo) helper.ProtocolTranslator {
+	return &responsesTranslator{
+		info:      info,
+		converter: NewResponsesStreamConverter(),
+	}
+}
+
+func (t *responsesTranslator) TranslateChunk(data string) ([]byte, error) {
+	var chunk dto.ChatCompletionsStreamResponse
+	if err := json.Unmarshal(common.StringToByteSlice(data), &chunk); err != nil {
+		return nil, err
+	}
+	var builder strings.Builder
+	for _, event := range t.converter.Convert(&chunk) {
+		sseEvent(&builder, event.Type, event)
+	}
+	return []byte(builder.String()), nil
+}
+
+func (t *responsesTranslator) TranslateStreamEnd(usage *dto.Usage) []byte {
+	var builder strings.Builder
+	for _, event := range t.converter.Finish(usage) {
+		sseEvent(&builder, event.Type, event)
+	}
+	return []byte(builder.String())
+}
+
+func (t *responsesTranslator) TranslateResponse(body []byte, usage *dto.Usage) ([]byte, error) {
+	var openAIResponse dto.OpenAITextResponse
+	if err := json.Unmarshal(body, &openAIResponse); err != nil {
+		return nil, err
+	}
+	if usage != nil {
+		openAIResponse.Usage = *usage
+	}
+	return json.Marshal(ResponseOpenAI2Responses(&openAIResponse, t.info))
+}
@@ -79,7 +79,7 @@ func PreWssConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo, usag
 	if relayInfo.UsePrice {
 		return nil
 	}
-	userQuota, err := model.GetUserQuota(relayInfo.UserId, false)
+	userQuota, err := model.GetWalletQuota(relayInfo.UserId, relayInfo.OrganizationId)
 	if err != nil {
 		return err
 	}
@@ -181,6 +181,7 @@ func PostWssConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo, mod
 	quota := calculateAudioQuota(quotaInfo)
 
 	totalTokens := usage.TotalTokens
+	SettleTPM(ctx, totalTokens)
 	var logContent string
 	if !usePrice {
 		logContent = fmt.Sprintf("模型倍率 %.2f，补全倍率 %.2f，音频倍率 %.2f，音频补全倍率 %.2f，分组倍率 %.2f",
@@ -250,6 +251,7 @@ func PostClaudeConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo,
 	quota := int(calculateQuota)
 
 	totalTokens := promptTokens + completionTokens
+	SettleTPM(ctx, totalTokens)
 
 	var logContent string
 	// record all the consume log even if quota is 0
@@ -318,6 +320,7 @@ func PostAudioConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo,
 	quota := calculateAudioQuota(quotaInfo)
 
 	totalTokens := usage.TotalTokens
+	SettleTPM(ctx, totalTokens)
 	var logContent string
 	if !usePrice {
 		logContent = fmt.Sprintf("模型倍率 %.2f，补全倍率 %.2f，音频倍率 %.2f，音频补全倍率 %.2f，分组倍率 %.2f",
@@ -375,19 +378,71 @@ func PreConsumeTokenQuota(relayInfo *relaycommon.RelayInfo, quota int) error {
 	if !relayInfo.TokenUnlimited && token.RemainQuota < quota {
 		return fmt.Errorf("token quota is not enough, token remain quota: %s, need quota: %s", common.FormatQuota(token.RemainQuota), common.FormatQuota(quota))
 	}
-	err = model.DecreaseTokenQuota(relayInfo.TokenId, relayInfo.TokenKey, quota)
+	movement := quotaMovement(relayInfo, model.QuotaReasonConsume)
+	movement.Remark = "预扣"
+	movement.EnforceBudget = true
+	err = model.DecreaseTokenQuota(relayInfo.TokenId, relayInfo.TokenKey, quota, movement)
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
+// quotaMovement 请求产生的额度变动，写入账本时关联请求 ID、用户和令牌
+func quotaMovement(relayInfo *relaycommon.RelayInfo, reason string) model.QuotaMovement {
+	return model.QuotaMovement{
+		Reason:    reason,
+		RequestId: relayInfo.RequestId,
+		UserId:    relayInfo.UserId,
+		TokenId:   relayInfo.TokenId,
+	}
+}
+
 func PostConsumeQuota(relayInfo *relaycommon.RelayInfo, quota int, preConsumedQuota int, sendEmail bool) (err error) {
+	return consumeQuota(relayInfo, quota, preConsumedQuota, sendEmail, model.QuotaReasonConsume)
+}
+
+// PreConsumeTaskQuota 按次计费的 Midjourney 和异步任务在提交上游前扣除全部额度，提交失败时使用 ReturnPreConsumedQuota 退还。
+// 扣费受令牌和用户周期预算上限的约束，超出上限时不扣费并返回错误。
+func PreConsumeTaskQuota(c *gin.Context, relayInfo *relaycommon.RelayInfo, quota int) error {
+	if quota <= 0 {
+		return nil
+	}
+	if openaiErr := CheckQuotaBudget(c, relayInfo, quota); openaiErr != nil {
+		return errors.New(openaiErr.Error.Message)
+	}
+	movement := quotaMovement(relayInfo, model.QuotaReasonConsume)
+	movement.EnforceBudget = true
+	if err := model.DecreaseWalletQuota(relayInfo.UserId, relayInfo.OrganizationId, quota, movement); err != nil {
+		return err
+	}
+	if !relayInfo.IsPlayground {
+		if err := model.DecreaseTokenQuota(relayInfo.TokenId, relayInfo.TokenKey, quota, movement); err != nil {
+			// 令牌超出预算时退还已从用户余额扣除的额度
+			refundErr := model.IncreaseWalletQuota(relayInfo.UserId, relayInfo.OrganizationId, quota, quotaMovement(relayInfo, model.QuotaReasonRefund))
+			if refundErr != nil {
+				common.SysError("error return task quota: " + refundErr.Error())
+			}
+			return err
+		}
+	}
+	if relayInfo.OrganizationId == 0 {
+		checkAndSendQuotaNotify(relayInfo, quota, 0)
+	}
+	return nil
+}
+
+// ReturnPreConsumedQuota 请求失败时退还预扣的额度，账本中记为退款
+func ReturnPreConsumedQuota(relayInfo *relaycommon.RelayInfo, preConsumedQuota int) error {
+	return consumeQuota(relayInfo, -preConsumedQuota, 0, false, model.QuotaReasonRefund)
+}
 
+func consumeQuota(relayInfo *relaycommon.RelayInfo, quota int, preConsumedQuota int, sendEmail bool, reason string) (err error) {
+	movement := quotaMovement(relayInfo, reason)
 	if quota > 0 {
-		err = model.DecreaseUserQuota(relayInfo.UserId, quota)
+		err = model.DecreaseWalletQuota(relayInfo.UserId, relayInfo.OrganizationId, quota, movement)
 	} else {
-		err = model.IncreaseUserQuota(relayInfo.UserId, -quota, false)
+		err = model.IncreaseWalletQuota(relayInfo.UserId, relayInfo.OrganizationId, -quota, movement)
 	}
 	if err != nil {
 		return err
@@ -395,16 +450,17 @@ func PostConsumeQuota(relayInfo *relaycommon.RelayInfo, quota int, preConsumedQu
 
 	if !relayInfo.IsPlayground {
 		if quota > 0 {
-			err = model.DecreaseTokenQuota(relayInfo.TokenId, relayInfo.TokenKey, quota)
+			err = model.DecreaseTokenQuota(relayInfo.TokenId, relayInfo.TokenKey, quota, movement)
 		} else {
-			err = model.IncreaseTokenQuota(relayInfo.TokenId, relayInfo.TokenKey, -quota)
+			err = model.IncreaseTokenQuota(relayInfo.TokenId, relayInfo.TokenKey, -quota, movement)
 		}
 		if err != nil {
 			return err
 		}
 	}
 
-	if sendEmail {
+	// 组织钱包的余额不属于用户，不发送用户额度提醒
+	if sendEmail && relayInfo.OrganizationId == 0 {
 		if (quota + preConsumedQuota) != 0 {
 			checkAndSendQuotaNotify(relayInfo, quota, preConsumedQuota)
 		}
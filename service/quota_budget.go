@@ -0,0 +1,103 @@
+package service
+
+import (
+	"fmt"
+	"net/http"
+	"one-api/common"
+	"one-api/dto"
+	"one-api/model"
+	relaycommon "one-api/relay/common"
+	"time"
+
+	"github.com/bytedance/gopkg/util/gopool"
+	"github.com/gin-gonic/gin"
+)
+
+// CheckQuotaBudget 检查令牌和用户的周期预算：本周期用量加上预扣额度超过上限时拒绝请求，
+// 用量达到提醒阈值时通知用户（每个周期一次）
+func CheckQuotaBudget(c *gin.Context, relayInfo *relaycommon.RelayInfo, preConsumedQuota int) *dto.OpenAIErrorWithStatusCode {
+	if !relayInfo.IsPlayground {
+		if budget, ok := c.Get("token_budget"); ok {
+			if err := checkQuotaBudget(relayInfo, model.QuotaAccountToken, relayInfo.TokenId, budget.(model.QuotaBudget), preConsumedQuota); err != nil {
+				return err
+			}
+		}
+	}
+	if budget, ok := c.Get("user_budget"); ok {
+		if err := checkQuotaBudget(relayInfo, model.QuotaAccountUser, relayInfo.UserId, budget.(model.QuotaBudget), preConsumedQuota); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// QuotaBudgetLimited 令牌或用户是否设置了周期预算上限，设置了上限的请求必须预扣额度，由扣费事务检查上限
+func QuotaBudgetLimited(c *gin.Context, relayInfo *relaycommon.RelayInfo) bool {
+	if !relayInfo.IsPlayground {
+		if budget, ok := c.Get("token_budget"); ok && budget.(model.QuotaBudget).Limited() {
+			return true
+		}
+	}
+	budget, ok := c.Get("user_budget")
+	return ok && budget.(model.QuotaBudget).Limited()
+}
+
+func checkQuotaBudget(relayInfo *relaycommon.RelayInfo, accountType string, accountId int, budget model.QuotaBudget, preConsumedQuota int) *dto.OpenAIErrorWithStatusCode {
+	// 预算配置来自缓存，未启用时不查询数据库
+	if !budget.Enabled() {
+		return nil
+	}
+	status, err := model.GetQuotaBudgetStatus(accountType, accountId)
+	if err != nil {
+		return OpenAIErrorWrapperLocal(err, "get_budget_failed", http.StatusInternalServerError)
+	}
+	if status.AlertThreshold > 0 && status.Used >= status.AlertThreshold {
+		sendQuotaBudgetAlert(relayInfo, accountType, accountId, status)
+	}
+	if status.Limit > 0 && (status.Used >= status.Limit || status.Used+preConsumedQuota > status.Limit) {
+		resetTime := time.Unix(status.ResetTime, 0).Format("2006-01-02 15:04:05")
+		return OpenAIErrorWrapperLocal(fmt.Errorf("%s %s budget exceeded, used: %s, limit: %s, need quota: %s, resets at %s",
+			accountType, status.Period, common.FormatQuota(status.Used), common.FormatQuota(status.Limit), common.FormatQuota(preConsumedQuota), resetTime),
+			accountType+"_budget_exceeded", http.StatusForbidden)
+	}
+	return nil
+}
+
+var budgetPeriodNames = map[string]string{
+	model.BudgetPeriodDaily:   "今日",
+	model.BudgetPeriodWeekly:  "本周",
+	model.BudgetPeriodMonthly: "本月",
+}
+
+// sendQuotaBudgetAlert 异步发送预算提醒，同一账户每个周期只发送一次
+func sendQuotaBudgetAlert(relayInfo *relaycommon.RelayInfo, accountType string, accountId int, status *model.QuotaBudgetStatus) {
+	userId := relayInfo.UserId
+	userEmail := relayInfo.UserEmail
+	userSetting := relayInfo.UserSetting
+	gopool.Go(func() {
+		marked, err := model.MarkQuotaBudgetAlerted(accountType, accountId, status.PeriodStart)
+		if err != nil {
+			common.SysError(fmt.Sprintf("failed to mark budget alert of %s %d: %s", accountType, accountId, err.Error()))
+			return
+		}
+		if !marked {
+			return
+		}
+		subject := "您的账户"
+		if accountType == model.QuotaAccountToken {
+			subject = fmt.Sprintf("您的令牌 #%d", accountId)
+		}
+		prompt := "预算即将用尽"
+		limit := "不限"
+		if status.Limit > 0 {
+			limit = common.FormatQuota(status.Limit)
+		}
+		content := "{{value}}{{value}}已使用 {{value}}，预算上限为 {{value}}，将于 {{value}} 重置。"
+		values := []interface{}{subject, budgetPeriodNames[status.Period], common.FormatQuota(status.Used), limit,
+			time.Unix(status.ResetTime, 0).Format("2006-01-02 15:04:05")}
+		err = NotifyUser(userId, userEmail, userSetting, dto.NewNotify(dto.NotifyTypeBudgetAlert, prompt, content, values))
+		if err != nil {
+			common.SysError(fmt.Sprintf("failed to send budget alert to user %d: %s", userId, err.Error()))
+		}
+	})
+}
@@ -0,0 +1,85 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"one-api/common"
+	"one-api/model"
+	"one-api/setting/operation_setting"
+	"sync"
+	"time"
+)
+
+// quotaReconcileCheckPeriod 定时对账检查是否到期的周期
+const quotaReconcileCheckPeriod = time.Minute
+
+// QuotaReconciliationReport 一次对账的结果
+type QuotaReconciliationReport struct {
+	StartedAt  int64                     `json:"started_at"`
+	FinishedAt int64                     `json:"finished_at"`
+	Accounts   int                       `json:"accounts"` // 账本中出现过的账户数
+	Drifts     []*model.QuotaLedgerDrift `json:"drifts"`
+	Error      string                    `json:"error,omitempty"`
+}
+
+var (
+	quotaReconciliationLock    sync.Mutex
+	quotaReconciliationRunning bool
+	lastQuotaReconciliation    *QuotaReconciliationReport
+)
+
+// GetQuotaReconciliationReport 获取最近一次对账的结果，从未对账时返回 nil
+func GetQuotaReconciliationReport() *QuotaReconciliationReport {
+	quotaReconciliationLock.Lock()
+	defer quotaReconciliationLock.Unlock()
+	return lastQuotaReconciliation
+}
+
+// ReconcileQuotaLedger 对比账本与用户、令牌余额，同一时间只运行一次对账
+func ReconcileQuotaLedger() (*QuotaReconciliationReport, error) {
+	quotaReconciliationLock.Lock()
+	if quotaReconciliationRunning {
+		quotaReconciliationLock.Unlock()
+		return nil, errors.New("对账正在进行中")
+	}
+	quotaReconciliationRunning = true
+	quotaReconciliationLock.Unlock()
+
+	report := &QuotaReconciliationReport{StartedAt: common.GetTimestamp()}
+	accounts, drifts, err := model.ReconcileQuotaLedger()
+	report.FinishedAt = common.GetTimestamp()
+	report.Accounts = accounts
+	report.Drifts = drifts
+	if err != nil {
+		report.Error = err.Error()
+		common.SysError("failed to reconcile quota ledger: " + err.Error())
+	}
+	for _, drift := range drifts {
+		common.SysError(fmt.Sprintf("quota ledger drift: %s %d, ledger balance %d, actual balance %d, drift %d",
+			drift.AccountType, drift.AccountId, drift.LedgerBalance, drift.ActualBalance, drift.Drift))
+	}
+	common.SysLog(fmt.Sprintf("quota ledger reconciled, %d accounts, %d drifts", accounts, len(drifts)))
+
+	quotaReconciliationLock.Lock()
+	quotaReconciliationRunning = false
+	lastQuotaReconciliation = report
+	quotaReconciliationLock.Unlock()
+	return report, err
+}
+
+// AutoReconcileQuotaLedger 按 quota_ledger_setting 定时对账，仅在主节点运行
+func AutoReconcileQuotaLedger() {
+	var lastRun time.Time
+	for {
+		time.Sleep(quotaReconcileCheckPeriod)
+		setting := operation_setting.GetQuotaLedgerSetting()
+		if !setting.ReconcileEnabled || setting.ReconcileIntervalMinutes <= 0 {
+			continue
+		}
+		if time.Since(lastRun) < time.Duration(setting.ReconcileIntervalMinutes)*time.Minute {
+			continue
+		}
+		lastRun = time.Now()
+		_, _ = ReconcileQuotaLedger()
+	}
+}
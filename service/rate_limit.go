@@ -0,0 +1,379 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"one-api/common"
+	"one-api/constant"
+	"one-api/dto"
+	"one-api/model"
+	relaycommon "one-api/relay/common"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis/v8"
+)
+
+// TPM 按自然分钟计数，请求准入时按 prompt tokens 预占，响应结束后按实际用量修正
+const (
+	tpmWindowSeconds      = 60
+	tpmKeyExpireSeconds   = 2 * tpmWindowSeconds
+	concurrencyKeyExpires = 10 * time.Minute // 节点异常退出时未释放的并发计数最多保留该时间
+)
+
+const (
+	RateLimitScopeToken   = "token"
+	RateLimitScopeUser    = "user"
+	RateLimitScopeChannel = "channel"
+)
+
+var tpmReserveScript = redis.NewScript(`
+local used = tonumber(redis.call('GET', KEYS[1]) or '0')
+local amount = tonumber(ARGV[1])
+local limit = tonumber(ARGV[2])
+if limit > 0 and (used >= limit or used + amount > limit) then
+	return {0, used}
+end
+used = redis.call('INCRBY', KEYS[1], amount)
+redis.call('EXPIRE', KEYS[1], ARGV[3])
+return {1, used}
+`)
+
+var concurrencyAcquireScript = redis.NewScript(`
+local current = redis.call('INCR', KEYS[1])
+redis.call('EXPIRE', KEYS[1], ARGV[2])
+if current > tonumber(ARGV[1]) then
+	redis.call('DECR', KEYS[1])
+	return {0, current - 1}
+end
+return {1, current}
+`)
+
+var concurrencyReleaseScript = redis.NewScript(`
+local current = redis.call('DECR', KEYS[1])
+if current <= 0 then
+	redis.call('DEL', KEYS[1])
+end
+return current
+`)
+
+type tpmWindow struct {
+	minute int64
+	used   int64
+}
+
+// 未启用 Redis 时使用内存计数，仅对单节点有效
+var (
+	rateLimitLock      sync.Mutex
+	tpmWindows         = make(map[string]*tpmWindow)
+	concurrencyCounter = make(map[string]int64)
+)
+
+type tpmReservation struct {
+	key    string
+	minute int64
+	amount int64
+}
+
+func currentMinute() int64 {
+	return time.Now().Unix() / tpmWindowSeconds
+}
+
+func tpmResetSeconds() int64 {
+	return tpmWindowSeconds - time.Now().Unix()%tpmWindowSeconds
+}
+
+func tpmKey(scope string, id int) string {
+	return fmt.Sprintf("tpm:%s:%d", scope, id)
+}
+
+func concurrencyKey(scope string, id int) string {
+	return fmt.Sprintf("concurrency:%s:%d", scope, id)
+}
+
+// reserveTPM 在当前分钟内预占 amount 个 token，limit 为 0 时只计数不限制，返回是否允许及占用后的用量
+func reserveTPM(key string, minute int64, amount int64, limit int64) (bool, int64) {
+	if common.RedisEnabled {
+		result, err := tpmReserveScript.Run(context.Background(), common.RDB,
+			[]string{fmt.Sprintf("%s:%d", key, minute)}, amount, limit, tpmKeyExpireSeconds).Int64Slice()
+		if err != nil {
+			// 限流计数不可用时放行请求
+			common.SysError("failed to reserve tpm: " + err.Error())
+			return true, 0
+		}
+		return result[0] == 1, result[1]
+	}
+	rateLimitLock.Lock()
+	defer rateLimitLock.Unlock()
+	window, ok := tpmWindows[key]
+	if !ok || window.minute != minute {
+		window = &tpmWindow{minute: minute}
+		tpmWindows[key] = window
+	}
+	if limit > 0 && (window.used >= limit || window.used+amount > limit) {
+		return false, window.used
+	}
+	window.used += amount
+	return true, window.used
+}
+
+func adjustTPM(key string, minute int64, delta int64) {
+	if common.RedisEnabled {
+		ctx := context.Background()
+		windowKey := fmt.Sprintf("%s:%d", key, minute)
+		if err := common.RDB.IncrBy(ctx, windowKey, delta).Err(); err != nil {
+			common.SysError("failed to adjust tpm: " + err.Error())
+			return
+		}
+		common.RDB.Expire(ctx, windowKey, tpmKeyExpireSeconds*time.Second)
+		return
+	}
+	rateLimitLock.Lock()
+	defer rateLimitLock.Unlock()
+	if window, ok := tpmWindows[key]; ok && window.minute == minute {
+		window.used += delta
+	}
+}
+
+// GetTPMUsage 获取当前分钟已使用的 token 数
+func GetTPMUsage(scope string, id int) int64 {
+	key := tpmKey(scope, id)
+	minute := currentMinute()
+	if common.RedisEnabled {
+		used, err := common.RDB.Get(context.Background(), fmt.Sprintf("%s:%d", key, minute)).Int64()
+		if err != nil {
+			return 0
+		}
+		return used
+	}
+	rateLimitLock.Lock()
+	defer rateLimitLock.Unlock()
+	if window, ok := tpmWindows[key]; ok && window.minute == minute {
+		return window.used
+	}
+	return 0
+}
+
+func acquireConcurrency(key string, limit int64) (bool, int64) {
+	if common.RedisEnabled {
+		result, err := concurrencyAcquireScript.Run(context.Background(), common.RDB,
+			[]string{key}, limit, int64(concurrencyKeyExpires/time.Second)).Int64Slice()
+		if err != nil {
+			common.SysError("failed to acquire concurrency: " + err.Error())
+			return true, 0
+		}
+		return result[0] == 1, result[1]
+	}
+	rateLimitLock.Lock()
+	defer rateLimitLock.Unlock()
+	if concurrencyCounter[key] >= limit {
+		return false, concurrencyCounter[key]
+	}
+	concurrencyCounter[key]++
+	return true, concurrencyCounter[key]
+}
+
+func releaseConcurrency(key string) {
+	if common.RedisEnabled {
+		if err := concurrencyReleaseScript.Run(context.Background(), common.RDB, []string{key}).Err(); err != nil {
+			common.SysError("failed to release concurrency: " + err.Error())
+		}
+		return
+	}
+	rateLimitLock.Lock()
+	defer rateLimitLock.Unlock()
+	concurrencyCounter[key]--
+	if concurrencyCounter[key] <= 0 {
+		delete(concurrencyCounter, key)
+	}
+}
+
+// GetConcurrencyUsage 获取当前正在处理的请求数
+func GetConcurrencyUsage(scope string, id int) int64 {
+	key := concurrencyKey(scope, id)
+	if common.RedisEnabled {
+		current, err := common.RDB.Get(context.Background(), key).Int64()
+		if err != nil {
+			return 0
+		}
+		return current
+	}
+	rateLimitLock.Lock()
+	defer rateLimitLock.Unlock()
+	return concurrencyCounter[key]
+}
+
+func rateLimitScopeName(scope string) string {
+	switch scope {
+	case RateLimitScopeToken:
+		return "令牌"
+	case RateLimitScopeUser:
+		return "用户"
+	}
+	return "渠道"
+}
+
+func tpmLimitExceeded(c *gin.Context, scope string, limit int64, used int64, amount int64) *dto.OpenAIErrorWithStatusCode {
+	reset := tpmResetSeconds()
+	remaining := limit - used
+	if remaining < 0 {
+		remaining = 0
+	}
+	c.Header("Retry-After", strconv.FormatInt(reset, 10))
+	c.Header("x-ratelimit-limit-tokens", strconv.FormatInt(limit, 10))
+	c.Header("x-ratelimit-remaining-tokens", strconv.FormatInt(remaining, 10))
+	c.Header("x-ratelimit-reset-tokens", fmt.Sprintf("%ds", reset))
+	err := fmt.Errorf("%s已达到每分钟 token 数限制：限制 %d，已使用 %d，本次请求 %d，请 %d 秒后重试", rateLimitScopeName(scope), limit, used, amount, reset)
+	return OpenAIErrorWrapperLocal(err, "rate_limit_exceeded", http.StatusTooManyRequests)
+}
+
+// ReserveTPM 请求准入时按 prompt tokens 预占令牌、用户和渠道的 TPM，令牌或用户超限时返回 429。
+// 渠道超限在选择渠道时处理，这里只计数。预占的用量需要调用 SettleTPM 按实际用量修正。
+func ReserveTPM(c *gin.Context, relayInfo *relaycommon.RelayInfo) *dto.OpenAIErrorWithStatusCode {
+	amount := int64(relayInfo.PromptTokens)
+	if amount < 0 {
+		amount = 0
+	}
+	minute := currentMinute()
+	limits := []struct {
+		scope string
+		id    int
+		limit int64
+	}{
+		{RateLimitScopeToken, relayInfo.TokenId, int64(c.GetInt("token_tpm_limit"))},
+		{RateLimitScopeUser, relayInfo.UserId, int64(c.GetInt("user_tpm_limit"))},
+		{RateLimitScopeChannel, relayInfo.ChannelId, c.GetInt64("channel_tpm_limit")},
+	}
+	var reservations []tpmReservation
+	for _, item := range limits {
+		if item.limit <= 0 || item.id == 0 {
+			continue
+		}
+		key := tpmKey(item.scope, item.id)
+		limit := item.limit
+		if item.scope == RateLimitScopeChannel {
+			limit = 0
+		}
+		allowed, used := reserveTPM(key, minute, amount, limit)
+		if !allowed {
+			for _, reservation := range reservations {
+				adjustTPM(reservation.key, reservation.minute, -reservation.amount)
+			}
+			return tpmLimitExceeded(c, item.scope, item.limit, used, amount)
+		}
+		reservations = append(reservations, tpmReservation{key: key, minute: minute, amount: amount})
+	}
+	if len(reservations) > 0 {
+		if hedge := GetHedgeGroup(c); hedge != nil {
+			hedge.setTPMReservations(reservations)
+		} else {
+			c.Set(constant.ContextKeyTPMReservations, reservations)
+		}
+	}
+	return nil
+}
+
+// SettleTPM 按实际使用的 token 数修正预占的 TPM，请求失败时 totalTokens 为 0，多次调用只有第一次生效
+func SettleTPM(c *gin.Context, totalTokens int) {
+	if hedge := GetHedgeGroup(c); hedge != nil {
+		hedge.settleTPM(c.GetInt("channel_id"), totalTokens)
+		return
+	}
+	value, ok := c.Get(constant.ContextKeyTPMReservations)
+	if !ok {
+		return
+	}
+	c.Set(constant.ContextKeyTPMReservations, nil)
+	reservations, ok := value.([]tpmReservation)
+	if !ok {
+		return
+	}
+	for _, reservation := range reservations {
+		if delta := int64(totalTokens) - reservation.amount; delta != 0 {
+			adjustTPM(reservation.key, reservation.minute, delta)
+		}
+	}
+}
+
+// IsChannelTPMExceeded 判断渠道当前分钟的 token 用量是否已达到限制
+func IsChannelTPMExceeded(channel *model.Channel) bool {
+	limit := channel.GetTPMLimit()
+	if limit <= 0 {
+		return false
+	}
+	return GetTPMUsage(RateLimitScopeChannel, channel.Id) >= limit
+}
+
+// AcquireChannelConcurrency 占用渠道的一个并发名额，返回释放函数；渠道已满时返回 false
+func AcquireChannelConcurrency(channel *model.Channel) (func(), bool) {
+	limit := channel.GetConcurrencyLimit()
+	if limit <= 0 {
+		return func() {}, true
+	}
+	key := concurrencyKey(RateLimitScopeChannel, channel.Id)
+	if allowed, _ := acquireConcurrency(key, limit); !allowed {
+		return nil, false
+	}
+	return func() { releaseConcurrency(key) }, true
+}
+
+// AcquireRequestConcurrency 占用令牌和用户的并发名额，返回释放函数；超限时设置限流响应头并返回错误
+func AcquireRequestConcurrency(c *gin.Context) (func(), error) {
+	limits := []struct {
+		scope string
+		id    int
+		limit int64
+	}{
+		{RateLimitScopeToken, c.GetInt("token_id"), int64(c.GetInt("token_concurrency_limit"))},
+		{RateLimitScopeUser, c.GetInt("id"), int64(c.GetInt("user_concurrency_limit"))},
+	}
+	var acquired []string
+	release := func() {
+		for _, key := range acquired {
+			releaseConcurrency(key)
+		}
+	}
+	for _, item := range limits {
+		if item.limit <= 0 || item.id == 0 {
+			continue
+		}
+		key := concurrencyKey(item.scope, item.id)
+		if allowed, current := acquireConcurrency(key, item.limit); !allowed {
+			release()
+			c.Header("Retry-After", "1")
+			c.Header("x-ratelimit-limit-concurrency", strconv.FormatInt(item.limit, 10))
+			c.Header("x-ratelimit-remaining-concurrency", "0")
+			return nil, fmt.Errorf("%s已达到并发请求数限制：最多同时处理 %d 个请求，当前 %d 个", rateLimitScopeName(item.scope), item.limit, current)
+		}
+		acquired = append(acquired, key)
+	}
+	return release, nil
+}
+
+// GetTPMLimitStatus 获取令牌和用户中剩余额度最少的 TPM 限制，用于设置限流响应头
+func GetTPMLimitStatus(c *gin.Context) (limit int64, remaining int64, reset int64, ok bool) {
+	limits := []struct {
+		scope string
+		id    int
+		limit int64
+	}{
+		{RateLimitScopeToken, c.GetInt("token_id"), int64(c.GetInt("token_tpm_limit"))},
+		{RateLimitScopeUser, c.GetInt("id"), int64(c.GetInt("user_tpm_limit"))},
+	}
+	for _, item := range limits {
+		if item.limit <= 0 || item.id == 0 {
+			continue
+		}
+		itemRemaining := item.limit - GetTPMUsage(item.scope, item.id)
+		if itemRemaining < 0 {
+			itemRemaining = 0
+		}
+		if !ok || itemRemaining < remaining {
+			limit, remaining, ok = item.limit, itemRemaining, true
+		}
+	}
+	return limit, remaining, tpmResetSeconds(), ok
+}
@@ -0,0 +1,117 @@
+package service
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"one-api/common"
+	"one-api/dto"
+	"one-api/model"
+	relayconstant "one-api/relay/constant"
+	"one-api/setting/operation_setting"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const responseCacheRedisPrefix = "response_cache:"
+
+// ResponseCacheEntry 缓存的响应内容，流式响应保存完整的 SSE 数据用于回放
+type ResponseCacheEntry struct {
+	Stream      bool      `json:"stream"`
+	Body        string    `json:"body"`
+	Usage       dto.Usage `json:"usage"`
+	CreatedTime int64     `json:"created_time"`
+}
+
+// GetResponseCacheTTL 判断请求是否可以使用响应缓存，返回缓存时间（秒），0 表示不使用缓存
+func GetResponseCacheTTL(c *gin.Context, relayMode int, request *dto.GeneralOpenAIRequest) int {
+	setting := operation_setting.GetResponseCacheSetting()
+	if !setting.Enabled {
+		return 0
+	}
+	if relayMode != relayconstant.RelayModeChatCompletions && relayMode != relayconstant.RelayModeCompletions {
+		return 0
+	}
+	// 指定渠道的请求通常用于测试渠道，不使用缓存
+	if _, ok := c.Get("specific_channel_id"); ok {
+		return 0
+	}
+	if strings.Contains(c.GetHeader("Cache-Control"), "no-store") {
+		return 0
+	}
+	if setting.OnlyZeroTemperature && (request.Temperature == nil || *request.Temperature != 0) {
+		return 0
+	}
+	ttl := c.GetInt("token_response_cache_ttl")
+	if ttl == 0 && operation_setting.IsResponseCacheEnabledForGroup(c.GetString("group")) {
+		ttl = -1
+	}
+	if ttl < 0 {
+		ttl = setting.DefaultTTLSeconds
+	}
+	if ttl < 0 {
+		return 0
+	}
+	return ttl
+}
+
+// GetResponseCacheKey 根据用户、模型和影响输出的请求参数计算缓存键。
+// 请求先序列化为 map 再序列化，使对象的键按字典序排列，字段顺序和空白不影响结果。
+func GetResponseCacheKey(userId int, modelName string, request *dto.GeneralOpenAIRequest) (string, error) {
+	params := request.ToMap()
+	// 模型映射可能已经修改了请求中的模型名称
+	params["model"] = modelName
+	delete(params, "user")
+	data, err := json.Marshal(params)
+	if err != nil {
+		return "", err
+	}
+	hash := sha256.Sum256([]byte(fmt.Sprintf("%d:%s", userId, data)))
+	return hex.EncodeToString(hash[:]), nil
+}
+
+func GetResponseCache(key string) (*ResponseCacheEntry, error) {
+	var value string
+	var err error
+	if common.RedisEnabled {
+		value, err = common.RedisGet(responseCacheRedisPrefix + key)
+		if err != nil {
+			// redis 中不存在缓存
+			return nil, nil
+		}
+	} else {
+		value, err = model.GetResponseCache(key)
+		if err != nil || value == "" {
+			return nil, err
+		}
+	}
+	var entry ResponseCacheEntry
+	if err = json.Unmarshal([]byte(value), &entry); err != nil {
+		return nil, err
+	}
+	return &entry, nil
+}
+
+func SaveResponseCache(key string, entry *ResponseCacheEntry, ttl int) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	if common.RedisEnabled {
+		return common.RedisSet(responseCacheRedisPrefix+key, string(data), time.Duration(ttl)*time.Second)
+	}
+	if err = model.SaveResponseCache(key, string(data), ttl); err != nil {
+		return err
+	}
+	// 数据库中的过期缓存不会自动删除，写入时随机清理
+	if rand.Intn(100) == 0 {
+		if _, err = model.DeleteExpiredResponseCaches(); err != nil {
+			common.SysError("failed to delete expired response caches: " + err.Error())
+		}
+	}
+	return nil
+}
@@ -0,0 +1,121 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"one-api/common"
+	"one-api/dto"
+	"one-api/model"
+	"one-api/setting/operation_setting"
+	"time"
+)
+
+const (
+	// subscriptionCheckPeriod 定时任务检查是否到期的周期
+	subscriptionCheckPeriod = time.Minute
+	// subscriptionBatchSize 每次处理的到期订阅数
+	subscriptionBatchSize = 100
+)
+
+// ProcessDueSubscriptions 处理已到期的订阅：开启自动续订且余额足够时续订（包括切换到下个周期的套餐），否则结束订阅并恢复分组。
+// 单个订阅处理失败时记录日志并跳过，下次运行时重试。
+func ProcessDueSubscriptions() (renewed int, expired int, err error) {
+	lastId := 0
+	for {
+		subscriptions, err := model.GetDueSubscriptions(common.GetTimestamp(), lastId, subscriptionBatchSize)
+		if err != nil {
+			return renewed, expired, err
+		}
+		for _, subscription := range subscriptions {
+			// 按 ID 顺序读取，处理失败的订阅不会在本轮被重复读取
+			lastId = subscription.Id
+			ok, err := processDueSubscription(subscription)
+			if errors.Is(err, model.ErrSubscriptionNotActive) {
+				// 处理期间订阅已被替换或由其他节点处理
+				continue
+			}
+			if err != nil {
+				common.SysError(fmt.Sprintf("failed to process due subscription %d of user %d: %s", subscription.Id, subscription.UserId, err.Error()))
+				continue
+			}
+			if ok {
+				renewed++
+			} else {
+				expired++
+			}
+		}
+		if len(subscriptions) < subscriptionBatchSize {
+			return renewed, expired, nil
+		}
+	}
+}
+
+// processDueSubscription 返回 true 表示已续订，false 表示已结束
+func processDueSubscription(subscription *model.Subscription) (bool, error) {
+	reason := "未开启自动续订"
+	if subscription.AutoRenew {
+		planId := subscription.PlanId
+		if subscription.NextPlanId != 0 {
+			planId = subscription.NextPlanId
+		}
+		plan, err := model.GetSubscriptionPlanById(planId)
+		if err == nil && plan.Status == model.SubscriptionPlanStatusEnabled {
+			err = model.RenewSubscription(subscription, plan)
+			if err == nil {
+				common.SysLog(fmt.Sprintf("subscription %d of user %d renewed with plan %d", subscription.Id, subscription.UserId, plan.Id))
+				model.RecordLog(subscription.UserId, model.LogTypeSystem, fmt.Sprintf("订阅自动续订成功，套餐：%s，扣除 %s", plan.Name, common.LogQuota(plan.PriceQuota())))
+				notifySubscription(subscription.UserId, "订阅已续订", "您的订阅已自动续订为 {{value}}，有效期至 {{value}}。",
+					plan.Name, time.Unix(subscription.EndTime, 0).Format("2006-01-02 15:04:05"))
+				return true, nil
+			}
+			if !errors.Is(err, model.ErrSubscriptionInsufficientQuota) {
+				return false, err
+			}
+			reason = "余额不足"
+		} else {
+			reason = "套餐已下架"
+		}
+	}
+	if err := model.ExpireSubscription(subscription); err != nil {
+		return false, err
+	}
+	common.SysLog(fmt.Sprintf("subscription %d of user %d expired: %s", subscription.Id, subscription.UserId, reason))
+	model.RecordLog(subscription.UserId, model.LogTypeSystem, fmt.Sprintf("订阅 %s 已到期（%s），未用完的包含额度已收回", subscription.PlanName, reason))
+	notifySubscription(subscription.UserId, "订阅已到期", "您的订阅 {{value}} 已到期（{{value}}），未用完的包含额度已收回。",
+		subscription.PlanName, reason)
+	return false, nil
+}
+
+func notifySubscription(userId int, title string, content string, values ...interface{}) {
+	user, err := model.GetUserCache(userId)
+	if err != nil {
+		return
+	}
+	err = NotifyUser(userId, user.Email, user.GetSetting(), dto.NewNotify(dto.NotifyTypeSubscription, title, content, values))
+	if err != nil {
+		common.SysError(fmt.Sprintf("failed to send subscription notify to user %d: %s", userId, err.Error()))
+	}
+}
+
+// AutoProcessSubscriptions 按 subscription_setting 定时处理到期的订阅，仅在主节点运行
+func AutoProcessSubscriptions() {
+	var lastRun time.Time
+	for {
+		time.Sleep(subscriptionCheckPeriod)
+		setting := operation_setting.GetSubscriptionSetting()
+		if !setting.RenewalEnabled || setting.CheckIntervalMinutes <= 0 {
+			continue
+		}
+		if time.Since(lastRun) < time.Duration(setting.CheckIntervalMinutes)*time.Minute {
+			continue
+		}
+		lastRun = time.Now()
+		renewed, expired, err := ProcessDueSubscriptions()
+		if err != nil {
+			common.SysError("failed to process due subscriptions: " + err.Error())
+		}
+		if renewed+expired > 0 {
+			common.SysLog(fmt.Sprintf("processed due subscriptions, %d renewed, %d expired", renewed, expired))
+		}
+	}
+}
@@ -0,0 +1,34 @@
+package operation_setting
+
+import "one-api/setting/config"
+
+// BatchSetting Files 和 Batch API 配置
+type BatchSetting struct {
+	Enabled              bool `json:"enabled"`
+	MaxFileSizeMB        int  `json:"max_file_size_mb"`       // 上传文件大小上限（MB）
+	MaxRequestsPerBatch  int  `json:"max_requests_per_batch"` // 单个批量任务的最大请求数
+	Concurrency          int  `json:"concurrency"`            // 批量任务的请求并发数，保持较小以免挤占在线请求
+	IntervalMilliseconds int  `json:"interval_milliseconds"`  // 每轮请求之间的等待时间（毫秒）
+	PollIntervalSeconds  int  `json:"poll_interval_seconds"`  // 后台任务检查待处理批量任务的间隔（秒）
+	LowPriorityChannels  bool `json:"low_priority_channels"`  // 批量请求从最低优先级的渠道开始选择，重试时逐级提高，把高优先级渠道留给在线请求
+}
+
+// 默认配置
+var batchSetting = BatchSetting{
+	Enabled:              true,
+	MaxFileSizeMB:        100,
+	MaxRequestsPerBatch:  50000,
+	Concurrency:          2,
+	IntervalMilliseconds: 200,
+	PollIntervalSeconds:  10,
+	LowPriorityChannels:  true,
+}
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("batch_setting", &batchSetting)
+}
+
+func GetBatchSetting() *BatchSetting {
+	return &batchSetting
+}
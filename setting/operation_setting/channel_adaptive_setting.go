@@ -0,0 +1,34 @@
+package operation_setting
+
+import "one-api/setting/config"
+
+// ChannelAdaptiveSetting 自适应渠道权重配置
+type ChannelAdaptiveSetting struct {
+	Enabled             bool    `json:"enabled"`
+	Alpha               float64 `json:"alpha"`                 // EWMA 平滑系数（0-1），越大越看重最近的请求
+	MinSamples          int     `json:"min_samples"`           // 样本数低于该值时使用静态权重
+	FirstTokenWeight    float64 `json:"first_token_weight"`    // 首字时间在延迟评分中的占比（0-1）
+	MinFactor           float64 `json:"min_factor"`            // 延迟系数下限
+	MaxFactor           float64 `json:"max_factor"`            // 延迟系数上限
+	SyncIntervalSeconds int     `json:"sync_interval_seconds"` // 通过 Redis 同步统计数据的间隔（秒）
+}
+
+// 默认配置
+var channelAdaptiveSetting = ChannelAdaptiveSetting{
+	Enabled:             false,
+	Alpha:               0.2,
+	MinSamples:          5,
+	FirstTokenWeight:    0.5,
+	MinFactor:           0.1,
+	MaxFactor:           10,
+	SyncIntervalSeconds: 10,
+}
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("channel_adaptive_setting", &channelAdaptiveSetting)
+}
+
+func GetChannelAdaptiveSetting() *ChannelAdaptiveSetting {
+	return &channelAdaptiveSetting
+}
@@ -0,0 +1,34 @@
+package operation_setting
+
+import "one-api/setting/config"
+
+// ChannelBreakerSetting 渠道熔断器配置
+type ChannelBreakerSetting struct {
+	Enabled            bool    `json:"enabled"`
+	WindowSeconds      int     `json:"window_seconds"`       // 统计窗口（秒）
+	MinRequests        int     `json:"min_requests"`         // 窗口内最少请求数，低于该值不触发熔断
+	ErrorRateThreshold float64 `json:"error_rate_threshold"` // 错误率阈值（0-1）
+	SlowRequestSeconds int     `json:"slow_request_seconds"` // 首字时间超过该耗时的请求按失败计算，0 表示不统计
+	OpenSeconds        int     `json:"open_seconds"`         // 熔断持续时间（秒），之后进入半开状态
+	HalfOpenProbes     int     `json:"half_open_probes"`     // 半开状态允许同时放行的探测请求数
+}
+
+// 默认配置
+var channelBreakerSetting = ChannelBreakerSetting{
+	Enabled:            false,
+	WindowSeconds:      60,
+	MinRequests:        10,
+	ErrorRateThreshold: 0.5,
+	SlowRequestSeconds: 0,
+	OpenSeconds:        30,
+	HalfOpenProbes:     1,
+}
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("channel_breaker_setting", &channelBreakerSetting)
+}
+
+func GetChannelBreakerSetting() *ChannelBreakerSetting {
+	return &channelBreakerSetting
+}
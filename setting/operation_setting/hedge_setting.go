@@ -0,0 +1,34 @@
+package operation_setting
+
+import (
+	"one-api/setting/config"
+	"slices"
+)
+
+// HedgeSetting 对冲请求配置
+type HedgeSetting struct {
+	Enabled           bool     `json:"enabled"`
+	DelayMilliseconds int      `json:"delay_milliseconds"` // 首个请求超过该时间未完成时向第二个渠道发起请求（毫秒）
+	Groups            []string `json:"groups"`             // 对整个分组启用对冲，令牌也可单独启用
+}
+
+// 默认配置
+var hedgeSetting = HedgeSetting{
+	Enabled:           false,
+	DelayMilliseconds: 1000,
+	Groups:            []string{},
+}
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("hedge_setting", &hedgeSetting)
+}
+
+func GetHedgeSetting() *HedgeSetting {
+	return &hedgeSetting
+}
+
+// IsHedgeEnabledForGroup 判断分组是否启用对冲请求
+func IsHedgeEnabledForGroup(group string) bool {
+	return hedgeSetting.Enabled && slices.Contains(hedgeSetting.Groups, group)
+}
@@ -0,0 +1,43 @@
+package operation_setting
+
+import "one-api/setting/config"
+
+const (
+	HistoryArchiveStorageLocal = "local"
+	HistoryArchiveStorageS3    = "s3"
+)
+
+// HistoryArchiveSetting 对话历史分表归档配置
+type HistoryArchiveSetting struct {
+	Enabled          bool   `json:"enabled"`
+	RetentionDays    int    `json:"retention_days"`     // 早于该天数的分表会被归档
+	Storage          string `json:"storage"`            // local 或 s3
+	LocalDir         string `json:"local_dir"`          // 本地归档目录
+	DropAfterArchive bool   `json:"drop_after_archive"` // 校验通过后删除分表
+	S3Endpoint       string `json:"s3_endpoint"`        // S3 兼容存储地址，例如 https://s3.us-east-1.amazonaws.com
+	S3Region         string `json:"s3_region"`
+	S3Bucket         string `json:"s3_bucket"`
+	S3Prefix         string `json:"s3_prefix"`
+	S3AccessKey      string `json:"s3_access_key"`
+	S3SecretKey      string `json:"s3_secret_key"`
+}
+
+// 默认配置
+var historyArchiveSetting = HistoryArchiveSetting{
+	Enabled:          false,
+	RetentionDays:    30,
+	Storage:          HistoryArchiveStorageLocal,
+	LocalDir:         "./archives",
+	DropAfterArchive: true,
+	S3Region:         "us-east-1",
+	S3Prefix:         "conversation-history/",
+}
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("history_archive_setting", &historyArchiveSetting)
+}
+
+func GetHistoryArchiveSetting() *HistoryArchiveSetting {
+	return &historyArchiveSetting
+}
@@ -0,0 +1,62 @@
+package operation_setting
+
+import (
+	"fmt"
+	"one-api/setting/config"
+)
+
+// 对话历史存储模式
+const (
+	HistoryModeOn         = "on"          // 保存所有对话
+	HistoryModeOff        = "off"         // 不保存
+	HistoryModeErrorsOnly = "errors_only" // 只保存失败的请求
+	HistoryModeSampled    = "sampled"     // 按 SampleRate 抽样保存
+)
+
+// HistoryPolicy 对话历史存储策略，可以设置在令牌、用户和分组上，Mode 为空表示继承上一级
+type HistoryPolicy struct {
+	Mode          string `json:"mode"`
+	SampleRate    int    `json:"sample_rate"`    // sampled 模式下保存的百分比，1-100
+	RetentionDays int    `json:"retention_days"` // 保留天数，0 表示按全局清理天数清理
+}
+
+// Validate 检查策略是否合法，Mode 为空时不检查其他字段
+func (p HistoryPolicy) Validate() error {
+	switch p.Mode {
+	case "", HistoryModeOn, HistoryModeOff, HistoryModeErrorsOnly:
+	case HistoryModeSampled:
+		if p.SampleRate < 1 || p.SampleRate > 100 {
+			return fmt.Errorf("抽样比例必须在 1-100 之间")
+		}
+	default:
+		return fmt.Errorf("无效的对话历史存储模式: %s", p.Mode)
+	}
+	if p.RetentionDays < 0 {
+		return fmt.Errorf("保留天数不能为负数")
+	}
+	return nil
+}
+
+// HistoryPolicySetting 分组级别的对话历史存储策略，令牌和用户的策略优先
+type HistoryPolicySetting struct {
+	GroupPolicies map[string]HistoryPolicy `json:"group_policies"`
+}
+
+// 默认配置
+var historyPolicySetting = HistoryPolicySetting{
+	GroupPolicies: map[string]HistoryPolicy{},
+}
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("history_policy_setting", &historyPolicySetting)
+}
+
+func GetHistoryPolicySetting() *HistoryPolicySetting {
+	return &historyPolicySetting
+}
+
+// GetHistoryGroupPolicy 获取分组的存储策略，未设置时返回 Mode 为空的策略
+func GetHistoryGroupPolicy(group string) HistoryPolicy {
+	return historyPolicySetting.GroupPolicies[group]
+}
@@ -0,0 +1,28 @@
+package operation_setting
+
+import "one-api/setting/config"
+
+// HistoryPrivacySetting 对话历史脱敏与加密配置
+type HistoryPrivacySetting struct {
+	RedactionEnabled  bool     `json:"redaction_enabled"`  // 保存前脱敏
+	Detectors         []string `json:"detectors"`          // 启用的检测器：email、phone、id_number、api_key、dictionary
+	DictionaryWords   []string `json:"dictionary_words"`   // dictionary 检测器使用的词典，不区分大小写
+	EncryptionEnabled bool     `json:"encryption_enabled"` // 使用由 CRYPTO_SECRET 派生的密钥 AES-GCM 加密 RawJson
+}
+
+// 默认配置
+var historyPrivacySetting = HistoryPrivacySetting{
+	RedactionEnabled:  false,
+	Detectors:         []string{"email", "phone", "id_number", "api_key"},
+	DictionaryWords:   []string{},
+	EncryptionEnabled: false,
+}
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("history_privacy_setting", &historyPrivacySetting)
+}
+
+func GetHistoryPrivacySetting() *HistoryPrivacySetting {
+	return &historyPrivacySetting
+}
@@ -0,0 +1,24 @@
+package operation_setting
+
+import "one-api/setting/config"
+
+// QuotaLedgerSetting 额度账本对账配置
+type QuotaLedgerSetting struct {
+	ReconcileEnabled         bool `json:"reconcile_enabled"`
+	ReconcileIntervalMinutes int  `json:"reconcile_interval_minutes"` // 定时对账间隔（分钟）
+}
+
+// 默认配置
+var quotaLedgerSetting = QuotaLedgerSetting{
+	ReconcileEnabled:         true,
+	ReconcileIntervalMinutes: 60,
+}
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("quota_ledger_setting", &quotaLedgerSetting)
+}
+
+func GetQuotaLedgerSetting() *QuotaLedgerSetting {
+	return &quotaLedgerSetting
+}
@@ -0,0 +1,50 @@
+package operation_setting
+
+import (
+	"one-api/setting/config"
+	"slices"
+)
+
+// ResponseCacheSetting 文本补全响应缓存配置
+type ResponseCacheSetting struct {
+	Enabled             bool               `json:"enabled"`
+	DefaultTTLSeconds   int                `json:"default_ttl_seconds"`   // 令牌未设置缓存时间时使用的默认缓存时间（秒）
+	OnlyZeroTemperature bool               `json:"only_zero_temperature"` // 仅缓存 temperature 为 0 的请求
+	MaxBodyKB           int                `json:"max_body_kb"`           // 超过该大小的响应不缓存（KB），未启用 Redis 时受数据库 text 字段长度限制
+	Groups              []string           `json:"groups"`                // 对整个分组启用缓存，令牌也可单独启用
+	HitQuotaRatio       float64            `json:"hit_quota_ratio"`       // 缓存命中时按原价的该比例计费
+	GroupHitQuotaRatio  map[string]float64 `json:"group_hit_quota_ratio"` // 分组单独设置的命中计费比例
+}
+
+// 默认配置
+var responseCacheSetting = ResponseCacheSetting{
+	Enabled:             false,
+	DefaultTTLSeconds:   3600,
+	OnlyZeroTemperature: true,
+	MaxBodyKB:           60,
+	Groups:              []string{},
+	HitQuotaRatio:       0.1,
+	GroupHitQuotaRatio:  map[string]float64{},
+}
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("response_cache_setting", &responseCacheSetting)
+}
+
+func GetResponseCacheSetting() *ResponseCacheSetting {
+	return &responseCacheSetting
+}
+
+// IsResponseCacheEnabledForGroup 判断分组是否启用响应缓存
+func IsResponseCacheEnabledForGroup(group string) bool {
+	return responseCacheSetting.Enabled && slices.Contains(responseCacheSetting.Groups, group)
+}
+
+// GetResponseCacheHitQuotaRatio 获取缓存命中时的计费比例，分组未单独设置时使用默认比例
+func GetResponseCacheHitQuotaRatio(group string) float64 {
+	if ratio, ok := responseCacheSetting.GroupHitQuotaRatio[group]; ok {
+		return ratio
+	}
+	return responseCacheSetting.HitQuotaRatio
+}
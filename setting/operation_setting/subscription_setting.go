@@ -0,0 +1,24 @@
+package operation_setting
+
+import "one-api/setting/config"
+
+// SubscriptionSetting 订阅续订与到期处理配置
+type SubscriptionSetting struct {
+	RenewalEnabled       bool `json:"renewal_enabled"`        // 是否自动处理到期的订阅
+	CheckIntervalMinutes int  `json:"check_interval_minutes"` // 检查到期订阅的间隔（分钟）
+}
+
+// 默认配置
+var subscriptionSetting = SubscriptionSetting{
+	RenewalEnabled:       true,
+	CheckIntervalMinutes: 5,
+}
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("subscription_setting", &subscriptionSetting)
+}
+
+func GetSubscriptionSetting() *SubscriptionSetting {
+	return &subscriptionSetting
+}
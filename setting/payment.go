@@ -9,6 +9,15 @@ var EpayKey = ""
 var Price = 7.3
 var MinTopUp = 1
 
+// Stripe Checkout，金额按 Price 换算后以 StripeCurrency 计价
+var StripeSecretKey = ""
+var StripeWebhookSecret = ""
+var StripeCurrency = "usd"
+
+// 通用支付网关，创建订单、查询和退款请求以及网关的回调都使用 PaymentWebhookSecret 签名
+var PaymentWebhookAddress = ""
+var PaymentWebhookSecret = ""
+
 var PayMethods = []map[string]string{
 	{
 		"name":  "支付宝",
@@ -35,6 +44,16 @@ func PayMethods2JsonString() string {
 	return string(jsonBytes)
 }
 
+// GetPayMethodProvider 返回支付方式使用的支付渠道，未配置 provider 时使用易支付
+func GetPayMethodProvider(method string) string {
+	for _, payMethod := range PayMethods {
+		if payMethod["type"] == method && payMethod["provider"] != "" {
+			return payMethod["provider"]
+		}
+	}
+	return "epay"
+}
+
 func ContainsPayMethod(method string) bool {
 	for _, payMethod := range PayMethods {
 		if payMethod["type"] == method {
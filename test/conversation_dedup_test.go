@@ -0,0 +1,240 @@
+package test
+
+import (
+	"encoding/json"
+	"one-api/common"
+	"one-api/model"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func enableConversationDedup(t *testing.T, enabled bool) {
+	t.Helper()
+	dedup := common.ConversationHistoryDedup
+	common.ConversationHistoryDedup = enabled
+	t.Cleanup(func() { common.ConversationHistoryDedup = dedup })
+}
+
+func countConversationMessages(t *testing.T, conversationId string) int64 {
+	t.Helper()
+	var count int64
+	assert.NoError(t, model.MES_DB.Model(&model.ConversationMessage{}).Where("conversation_id = ?", conversationId).Count(&count).Error)
+	return count
+}
+
+// messagesOf 解析 RawJson 中的 messages
+func messagesOf(t *testing.T, rawJson string) []map[string]any {
+	t.Helper()
+	var data struct {
+		Messages []map[string]any `json:"messages"`
+	}
+	assert.NoError(t, json.Unmarshal([]byte(rawJson), &data))
+	return data.Messages
+}
+
+func TestConversationHistoryDedup(t *testing.T) {
+	setupTestDB(t)
+	enableConversationDedup(t, true)
+	conversationId := "conv_dedup"
+
+	tests := []struct {
+		name      string
+		rawJson   string
+		wantTotal int64 // 写入后消息表中该对话的消息数
+	}{
+		{name: "第一轮对话", rawJson: `{"model":"gpt-4o","messages":[{"role":"user","content":"u1"}]}`, wantTotal: 1},
+		{name: "追加一轮只保存新增的消息", rawJson: `{"model":"gpt-4o","messages":[{"role":"user","content":"u1"},{"role":"assistant","content":"a1"},{"role":"user","content":"u2"}]}`, wantTotal: 3},
+		{name: "字段顺序不同的相同消息不重复保存", rawJson: `{"model":"gpt-4o","messages":[{"content":"u1","role":"user"},{"content":"a1","role":"assistant"},{"content":"u2","role":"user"}]}`, wantTotal: 3},
+		{name: "从中间分叉的对话", rawJson: `{"model":"gpt-4o","messages":[{"role":"user","content":"u1"},{"role":"assistant","content":"a1"},{"role":"user","content":"u3"}]}`, wantTotal: 4},
+		{name: "相同内容在不同位置单独保存", rawJson: `{"model":"gpt-4o","messages":[{"role":"user","content":"u1"},{"role":"assistant","content":"a1"},{"role":"user","content":"u1"}]}`, wantTotal: 5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.NoError(t, model.CreateConversationHistory(nil, conversationId, "gpt-4o", tt.rawJson, 1, 0, 0))
+			assert.Equal(t, tt.wantTotal, countConversationMessages(t, conversationId))
+
+			// 存储的记录只包含消息链
+			var stored model.ConversationHistory
+			assert.NoError(t, model.MES_DB.Table(model.GetConversationHistoryTableName()).Order("id desc").First(&stored).Error)
+			assert.False(t, strings.Contains(stored.RawJson, `"messages"`))
+
+			// 读取时还原完整的 messages
+			history, err := model.GetConversationHistoryById(stored.Id)
+			assert.NoError(t, err)
+			assert.Equal(t, messagesOf(t, tt.rawJson), messagesOf(t, history.RawJson))
+		})
+	}
+}
+
+func TestDedupConversationHistoryPartition(t *testing.T) {
+	setupTestDB(t)
+	enableConversationDedup(t, false)
+	day := time.Date(2025, 4, 1, 0, 0, 0, 0, time.Local)
+	createHistoriesInPartitions(t, []time.Time{day.Add(time.Hour), day.Add(2 * time.Hour)}, []int{1, 2})
+	// 没有 messages 的记录保持原样
+	assert.NoError(t, model.MES_DB.Table(model.GetConversationHistoryTableName(day)).Create(&model.ConversationHistory{
+		ConversationId: "conv_prompt",
+		ModelName:      "gpt-4o",
+		RawJson:        `{"prompt":"hello"}`,
+		UserId:         1,
+		CreatedAt:      day.Add(3 * time.Hour),
+	}).Error)
+
+	partitions, err := model.GetMESPartitions(model.ConversationHistoryBaseTable)
+	assert.NoError(t, err)
+	var partition model.MESPartition
+	for _, p := range partitions {
+		if p.Date.Equal(day) {
+			partition = p
+		}
+	}
+
+	tests := []struct {
+		name          string
+		batchSize     int
+		wantConverted int64
+	}{
+		{name: "转换完整存储的记录", batchSize: 1, wantConverted: 2},
+		{name: "已转换的记录不再处理", batchSize: 500, wantConverted: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			converted, err := model.DedupConversationHistoryPartition(partition, tt.batchSize)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.wantConverted, converted)
+		})
+	}
+
+	var histories []*model.ConversationHistory
+	assert.NoError(t, model.MES_DB.Table(partition.Table).Order("id").Find(&histories).Error)
+	for _, stored := range histories {
+		history, err := model.GetConversationHistoryById(stored.Id)
+		assert.NoError(t, err)
+		if stored.ConversationId == "conv_prompt" {
+			assert.JSONEq(t, `{"prompt":"hello"}`, stored.RawJson)
+			continue
+		}
+		assert.False(t, strings.Contains(stored.RawJson, `"messages"`))
+		assert.Equal(t, []map[string]any{{"role": "user", "content": "hello"}}, messagesOf(t, history.RawJson))
+	}
+}
+
+func countUserConversationMessages(t *testing.T, userId int, conversationId string) int64 {
+	t.Helper()
+	var count int64
+	assert.NoError(t, model.MES_DB.Model(&model.ConversationMessage{}).
+		Where("user_id = ? AND conversation_id = ?", userId, conversationId).Count(&count).Error)
+	return count
+}
+
+// backdateConversationMessages 将消息的写入时间提前，使其超过批量清理的保护期
+func backdateConversationMessages(t *testing.T) {
+	t.Helper()
+	assert.NoError(t, model.MES_DB.Model(&model.ConversationMessage{}).Where("1 = 1").
+		UpdateColumn("created_at", time.Now().Add(-2*time.Hour)).Error)
+}
+
+// dedupAllConversationPartitions 将所有分区转换为去重存储
+func dedupAllConversationPartitions(t *testing.T) {
+	t.Helper()
+	partitions, err := model.GetMESPartitions(model.ConversationHistoryBaseTable)
+	assert.NoError(t, err)
+	for _, partition := range partitions {
+		_, err = model.DedupConversationHistoryPartition(partition, 500)
+		assert.NoError(t, err)
+	}
+}
+
+func TestDeleteConversationHistoryMessages(t *testing.T) {
+	setupTestDB(t)
+	enableConversationDedup(t, true)
+	turn1 := `{"messages":[{"role":"user","content":"u1"}]}`
+	turn2 := `{"messages":[{"role":"user","content":"u1"},{"role":"assistant","content":"a1"},{"role":"user","content":"u2"}]}`
+	assert.NoError(t, model.CreateConversationHistory(nil, "conv_a", "gpt-4o", turn1, 1, 0, 0))
+	assert.NoError(t, model.CreateConversationHistory(nil, "conv_a", "gpt-4o", turn2, 1, 0, 0))
+	// 其他用户使用了相同的对话ID
+	assert.NoError(t, model.CreateConversationHistory(nil, "conv_a", "gpt-4o", turn1, 2, 0, 0))
+	assert.NoError(t, model.CreateConversationHistory(nil, "conv_b", "gpt-4o", turn1, 1, 0, 0))
+	var histories []*model.ConversationHistory
+	assert.NoError(t, model.MES_DB.Table(model.GetConversationHistoryTableName()).Order("id").Find(&histories).Error)
+	if !assert.Len(t, histories, 4) {
+		return
+	}
+
+	steps := []struct {
+		name      string
+		run       func() error
+		wantUser1 int64 // 用户 1 的 conv_a 剩余消息数
+		wantUser2 int64 // 用户 2 的 conv_a 剩余消息数
+		wantConvB int64
+	}{
+		{
+			name:      "对话中还有其他记录时保留消息",
+			run:       func() error { return model.DeleteConversationHistory(histories[0].Id) },
+			wantUser1: 3, wantUser2: 1, wantConvB: 1,
+		},
+		{
+			name:      "删除对话的最后一条记录后删除消息",
+			run:       func() error { return model.HardDeleteConversationHistory(histories[1].Id) },
+			wantUser1: 0, wantUser2: 1, wantConvB: 1,
+		},
+		{
+			name:      "按对话ID删除",
+			run:       func() error { return model.DeleteConversationHistoriesByConversationId("conv_b") },
+			wantUser1: 0, wantUser2: 1, wantConvB: 0,
+		},
+	}
+	for _, step := range steps {
+		t.Run(step.name, func(t *testing.T) {
+			assert.NoError(t, step.run())
+			assert.Equal(t, step.wantUser1, countUserConversationMessages(t, 1, "conv_a"))
+			assert.Equal(t, step.wantUser2, countUserConversationMessages(t, 2, "conv_a"))
+			assert.Equal(t, step.wantConvB, countConversationMessages(t, "conv_b"))
+		})
+	}
+}
+
+func TestCleanupOrphanConversationMessages(t *testing.T) {
+	setupTestDB(t)
+	enableConversationDedup(t, false)
+	now := time.Now()
+	// conv_0 超过清理天数，conv_1 未到清理天数
+	createHistoriesInPartitions(t, []time.Time{now.AddDate(0, 0, -40), now.AddDate(0, 0, -2)}, []int{1, 1})
+	dedupAllConversationPartitions(t)
+	backdateConversationMessages(t)
+
+	// 刚写入的消息还没有未删除的对话历史引用
+	enableConversationDedup(t, true)
+	assert.NoError(t, model.CreateConversationHistory(nil, "conv_new", "gpt-4o", `{"messages":[{"role":"user","content":"new"}]}`, 1, 0, 0))
+	assert.NoError(t, model.MES_DB.Table(model.GetConversationHistoryTableName()).
+		Where("conversation_id = ?", "conv_new").Delete(&model.ConversationHistory{}).Error)
+
+	deleted, err := model.CleanupOldConversationHistories(30)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), deleted)
+
+	tests := []struct {
+		name           string
+		conversationId string
+		want           int64
+	}{
+		{name: "清理对话历史后删除消息", conversationId: "conv_0", want: 0},
+		{name: "未清理的对话保留消息", conversationId: "conv_1", want: 1},
+		{name: "保护期内的消息留到下次清理", conversationId: "conv_new", want: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, countConversationMessages(t, tt.conversationId))
+		})
+	}
+
+	backdateConversationMessages(t)
+	deleted, err = model.CleanupOrphanConversationMessages()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), deleted)
+	assert.Zero(t, countConversationMessages(t, "conv_new"))
+	assert.Equal(t, int64(1), countConversationMessages(t, "conv_1"))
+}
@@ -0,0 +1,195 @@
+package test
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"one-api/model"
+	"one-api/service"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// createExportHistories 写入一组成功和失败的对话，conv_a 有两轮，只应导出最新的一轮
+func createExportHistories(t *testing.T, day time.Time) {
+	t.Helper()
+	assert.NoError(t, model.SimulateDateChange(day))
+	histories := []struct {
+		conversationId string
+		rawJson        string
+	}{
+		{conversationId: "conv_a", rawJson: `{"messages":[{"role":"user","content":"hi"}]}`},
+		{conversationId: "conv_a", rawJson: `{"messages":[{"role":"system","content":"be brief"},{"role":"user","content":"hi"},{"role":"assistant","content":"hello","reasoning_content":"think"}]}`},
+		{conversationId: "conv_b", rawJson: `{"messages":[{"role":"user","content":"weather?"},{"role":"assistant","content":"","tool_calls":[{"id":"call_1","type":"function","function":{"name":"get_weather","arguments":"{\"city\":\"Paris\"}"}}]},{"role":"tool","tool_call_id":"call_1","content":"sunny"},{"role":"assistant","content":"It is sunny"}]}`},
+		{conversationId: "conv_c", rawJson: `{"messages":[{"role":"user","content":"no reply"}]}`},
+		{conversationId: "conv_d", rawJson: `not json`},
+	}
+	for i, history := range histories {
+		assert.NoError(t, model.MES_DB.Table(model.GetConversationHistoryTableName(day)).Create(&model.ConversationHistory{
+			ConversationId: history.conversationId,
+			ModelName:      "gpt-4o",
+			RawJson:        history.rawJson,
+			UserId:         1,
+			CreatedAt:      day.Add(time.Duration(10+i) * time.Hour),
+		}).Error)
+	}
+	assert.NoError(t, model.MES_DB.Table(model.GetErrorConversationHistoryTableName(day)).Create(&model.ErrorConversationHistory{
+		ConversationId: "conv_e",
+		ModelName:      "gpt-4o",
+		RawJson:        `{"messages":[{"role":"user","content":"boom"}]}`,
+		ErrorCode:      "500",
+		ErrorMessage:   "upstream error",
+		UserId:         1,
+		CreatedAt:      day.Add(20 * time.Hour),
+	}).Error)
+}
+
+// exportedConversations 解析导出内容，返回每个对话的标识：openai 格式为第一条用户消息，其他格式为对话ID
+func exportedConversations(t *testing.T, format string, output string) []string {
+	t.Helper()
+	var ids []string
+	switch format {
+	case service.ConversationExportFormatOpenAI:
+		scanner := bufio.NewScanner(strings.NewReader(output))
+		for scanner.Scan() {
+			var line struct {
+				Messages []map[string]any `json:"messages"`
+			}
+			assert.NoError(t, json.Unmarshal(scanner.Bytes(), &line))
+			for _, message := range line.Messages {
+				// 只保留微调支持的字段
+				assert.NotContains(t, message, "reasoning_content")
+				if message["role"] == "user" {
+					ids = append(ids, message["content"].(string))
+					break
+				}
+			}
+		}
+	case service.ConversationExportFormatShareGPT:
+		var conversations []struct {
+			Id string `json:"id"`
+		}
+		assert.NoError(t, json.Unmarshal([]byte(output), &conversations))
+		for _, conversation := range conversations {
+			ids = append(ids, conversation.Id)
+		}
+	case service.ConversationExportFormatMarkdown:
+		for _, line := range strings.Split(output, "\n") {
+			if strings.HasPrefix(line, "## ") {
+				ids = append(ids, strings.TrimPrefix(line, "## "))
+			}
+		}
+	}
+	return ids
+}
+
+func TestExportConversationHistories(t *testing.T) {
+	setupTestDB(t)
+	createExportHistories(t, time.Date(2025, 5, 1, 0, 0, 0, 0, time.Local))
+
+	tests := []struct {
+		name           string
+		format         string
+		includeSuccess bool
+		includeError   bool
+		want           []string
+	}{
+		{name: "OpenAI 格式跳过没有助手回复的对话", format: service.ConversationExportFormatOpenAI, includeSuccess: true, includeError: true, want: []string{"weather?", "hi"}},
+		{name: "ShareGPT 格式只导出成功的对话", format: service.ConversationExportFormatShareGPT, includeSuccess: true, want: []string{"conv_c", "conv_b", "conv_a"}},
+		{name: "ShareGPT 格式只导出失败的对话", format: service.ConversationExportFormatShareGPT, includeError: true, want: []string{"conv_e"}},
+		{name: "Markdown 格式导出全部对话", format: service.ConversationExportFormatMarkdown, includeSuccess: true, includeError: true, want: []string{"conv_c", "conv_b", "conv_a", "conv_e"}},
+		{name: "没有可导出的对话", format: service.ConversationExportFormatShareGPT, want: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buffer bytes.Buffer
+			flushes := 0
+			err := service.ExportConversationHistories(&buffer, service.ConversationExportOptions{
+				Format:         tt.format,
+				IncludeSuccess: tt.includeSuccess,
+				IncludeError:   tt.includeError,
+			}, func() { flushes++ })
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, exportedConversations(t, tt.format, buffer.String()))
+			if tt.includeSuccess || tt.includeError {
+				assert.Greater(t, flushes, 0)
+			}
+		})
+	}
+
+	err := service.ExportConversationHistories(&bytes.Buffer{}, service.ConversationExportOptions{Format: "csv", IncludeSuccess: true}, func() {})
+	assert.Error(t, err)
+}
+
+func TestExportConversationHistoriesContent(t *testing.T) {
+	setupTestDB(t)
+	createExportHistories(t, time.Date(2025, 5, 1, 0, 0, 0, 0, time.Local))
+	filter := model.ConversationHistoryFilter{ConversationId: "conv_b"}
+
+	tests := []struct {
+		name   string
+		format string
+		want   string
+	}{
+		{
+			name:   "OpenAI 格式保留工具调用",
+			format: service.ConversationExportFormatOpenAI,
+			want:   `{"messages":[{"role":"user","content":"weather?"},{"role":"assistant","content":"","tool_calls":[{"id":"call_1","type":"function","function":{"name":"get_weather","arguments":"{\"city\":\"Paris\"}"}}]},{"role":"tool","content":"sunny","tool_call_id":"call_1"},{"role":"assistant","content":"It is sunny"}]}` + "\n",
+		},
+		{
+			name:   "ShareGPT 格式映射角色",
+			format: service.ConversationExportFormatShareGPT,
+			want:   "[\n" + `{"id":"conv_b","model":"gpt-4o","conversations":[{"from":"human","value":"weather?"},{"from":"function_call","value":"{\"arguments\":\"{\\\"city\\\":\\\"Paris\\\"}\",\"name\":\"get_weather\"}"},{"from":"observation","value":"sunny"},{"from":"gpt","value":"It is sunny"}]}` + "\n]\n",
+		},
+		{
+			name:   "Markdown 格式",
+			format: service.ConversationExportFormatMarkdown,
+			want: "## conv_b\n\n- 模型: gpt-4o\n- 用户: 1\n- 时间: 2025-05-01 12:00:00\n\n" +
+				"### user\n\nweather?\n\n" +
+				"### assistant\n\n调用工具 `get_weather`：\n\n```json\n{\"city\":\"Paris\"}\n```\n\n" +
+				"### tool\n\nsunny\n\n" +
+				"### assistant\n\nIt is sunny\n\n---\n\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buffer bytes.Buffer
+			err := service.ExportConversationHistories(&buffer, service.ConversationExportOptions{
+				Filter:         filter,
+				Format:         tt.format,
+				IncludeSuccess: true,
+			}, func() {})
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, buffer.String())
+		})
+	}
+}
+
+func TestExportConversationHistoriesSameIdDifferentUsers(t *testing.T) {
+	setupTestDB(t)
+	day := time.Date(2025, 5, 1, 0, 0, 0, 0, time.Local)
+	assert.NoError(t, model.SimulateDateChange(day))
+	for i, userId := range []int{1, 2, 2} {
+		assert.NoError(t, model.MES_DB.Table(model.GetConversationHistoryTableName(day)).Create(&model.ConversationHistory{
+			ConversationId: "conv_shared",
+			ModelName:      "gpt-4o",
+			RawJson:        `{"messages":[{"role":"user","content":"hi"}]}`,
+			UserId:         userId,
+			CreatedAt:      day.Add(time.Duration(10+i) * time.Hour),
+		}).Error)
+	}
+
+	var buffer bytes.Buffer
+	err := service.ExportConversationHistories(&buffer, service.ConversationExportOptions{
+		Format:         service.ConversationExportFormatMarkdown,
+		IncludeSuccess: true,
+	}, func() {})
+	assert.NoError(t, err)
+	// 两个用户使用了相同的对话ID，各自导出最新的一条
+	assert.Equal(t, []string{"conv_shared", "conv_shared"}, exportedConversations(t, service.ConversationExportFormatMarkdown, buffer.String()))
+	assert.Contains(t, buffer.String(), "- 用户: 1\n")
+	assert.Contains(t, buffer.String(), "- 用户: 2\n")
+}
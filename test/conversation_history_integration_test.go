@@ -3,81 +3,200 @@ package test
 import (
 	"encoding/json"
 	"fmt"
-	"net/http/httptest"
 	"one-api/dto"
-	"one-api/relay/channel/openai"
+	"one-api/relay"
+	relaycommon "one-api/relay/common"
 	"testing"
 
-	"github.com/gin-gonic/gin"
 	"github.com/stretchr/testify/assert"
 )
 
+// expectedToolCall 期望组装出的工具调用
+type expectedToolCall struct {
+	id        string
+	name      string
+	arguments string
+}
+
 // TestConversationHistoryIntegration 测试对话历史功能的完整集成
 func TestConversationHistoryIntegration(t *testing.T) {
-	// 初始化测试环境
-	gin.SetMode(gin.TestMode)
-
-	// 测试AI回复内容提取
-	t.Run("TestAIResponseContentExtraction", func(t *testing.T) {
-		// 创建测试上下文
-		w := httptest.NewRecorder()
-		c, _ := gin.CreateTestContext(w)
-
-		// 模拟AI回复内容
-		aiResponse := &openai.AIResponseContent{
-			Content:          "这是AI的回复内容",
-			ReasoningContent: "这是AI的思考过程",
-		}
-		c.Set("ai_response_content", aiResponse)
-
-		// 测试从上下文中提取AI回复内容
-		extracted := extractAIResponseFromContext(c)
-		assert.NotNil(t, extracted)
-		assert.Equal(t, "这是AI的回复内容", extracted.Content)
-		assert.Equal(t, "这是AI的思考过程", extracted.ReasoningContent)
-	})
-
-	// 测试从响应体解析AI回复内容
-	t.Run("TestParseAIResponseFromBody", func(t *testing.T) {
-		// 构建测试响应体
-		response := dto.OpenAITextResponse{
-			Choices: []dto.OpenAITextResponseChoice{
-				{
-					Message: dto.Message{
-						Role:             "assistant",
-						ReasoningContent: "AI的思考过程",
-					},
+	// 测试按入站协议从响应体组装AI回复内容
+	t.Run("TestAssembleAssistantMessage", func(t *testing.T) {
+		testCases := []struct {
+			name         string
+			format       string
+			body         string
+			content      string
+			reasoning    string
+			toolCalls    []expectedToolCall
+			finishReason string
+		}{
+			{
+				name:         "OpenAI非流式",
+				format:       relaycommon.RelayFormatOpenAI,
+				body:         `{"id":"chatcmpl-1","object":"chat.completion","choices":[{"index":0,"message":{"role":"assistant","content":"AI的回复内容","reasoning_content":"AI的思考过程"},"finish_reason":"stop"}]}`,
+				content:      "AI的回复内容",
+				reasoning:    "AI的思考过程",
+				finishReason: "stop",
+			},
+			{
+				name:         "OpenAI非流式reasoning字段",
+				format:       relaycommon.RelayFormatOpenAI,
+				body:         `{"choices":[{"index":0,"message":{"role":"assistant","content":"回复","reasoning":"思考"},"finish_reason":"stop"}]}`,
+				content:      "回复",
+				reasoning:    "思考",
+				finishReason: "stop",
+			},
+			{
+				name:         "OpenAI非流式tool_calls",
+				format:       relaycommon.RelayFormatOpenAI,
+				body:         `{"choices":[{"index":0,"message":{"role":"assistant","content":null,"tool_calls":[{"id":"call_1","type":"function","function":{"name":"get_weather","arguments":"{\"city\":\"北京\"}"}}]},"finish_reason":"tool_calls"}]}`,
+				toolCalls:    []expectedToolCall{{id: "call_1", name: "get_weather", arguments: `{"city":"北京"}`}},
+				finishReason: "tool_calls",
+			},
+			{
+				name:   "OpenAI流式",
+				format: relaycommon.RelayFormatOpenAI,
+				body: "data: {\"choices\":[{\"index\":0,\"delta\":{\"role\":\"assistant\",\"reasoning_content\":\"先想\"}}]}\n\n" +
+					": keep-alive\n\n" +
+					"data: {\"choices\":[{\"index\":0,\"delta\":{\"content\":\"你好\"}}]}\n\n" +
+					"data: {\"choices\":[{\"index\":0,\"delta\":{\"content\":\"，世界\"},\"finish_reason\":\"stop\"}]}\n\n" +
+					"data: [DONE]\n\n",
+				content:      "你好，世界",
+				reasoning:    "先想",
+				finishReason: "stop",
+			},
+			{
+				name:   "OpenAI流式tool_calls分片合并",
+				format: relaycommon.RelayFormatOpenAI,
+				body: "data: {\"choices\":[{\"index\":0,\"delta\":{\"tool_calls\":[{\"index\":0,\"id\":\"call_1\",\"type\":\"function\",\"function\":{\"name\":\"get_weather\",\"arguments\":\"\"}}]}}]}\n\n" +
+					"data: {\"choices\":[{\"index\":0,\"delta\":{\"tool_calls\":[{\"index\":0,\"function\":{\"arguments\":\"{\\\"city\\\":\"}}]}}]}\n\n" +
+					"data: {\"choices\":[{\"index\":0,\"delta\":{\"tool_calls\":[{\"index\":1,\"id\":\"call_2\",\"type\":\"function\",\"function\":{\"name\":\"get_time\",\"arguments\":\"{}\"}}]}}]}\n\n" +
+					"data: {\"choices\":[{\"index\":0,\"delta\":{\"tool_calls\":[{\"index\":0,\"function\":{\"arguments\":\"\\\"上海\\\"}\"}}]},\"finish_reason\":\"tool_calls\"}]}\n\n" +
+					"data: [DONE]\n\n",
+				toolCalls: []expectedToolCall{
+					{id: "call_1", name: "get_weather", arguments: `{"city":"上海"}`},
+					{id: "call_2", name: "get_time", arguments: `{}`},
 				},
+				finishReason: "tool_calls",
+			},
+			{
+				name:         "Claude非流式",
+				format:       relaycommon.RelayFormatClaude,
+				body:         `{"id":"msg_1","type":"message","role":"assistant","content":[{"type":"thinking","thinking":"思考过程"},{"type":"text","text":"回复内容"},{"type":"tool_use","id":"toolu_1","name":"search","input":{"q":"go"}}],"stop_reason":"tool_use"}`,
+				content:      "回复内容",
+				reasoning:    "思考过程",
+				toolCalls:    []expectedToolCall{{id: "toolu_1", name: "search", arguments: `{"q":"go"}`}},
+				finishReason: "tool_use",
+			},
+			{
+				name:   "Claude流式",
+				format: relaycommon.RelayFormatClaude,
+				body: "event: message_start\ndata: {\"type\":\"message_start\",\"message\":{\"id\":\"msg_1\",\"type\":\"message\",\"role\":\"assistant\",\"content\":[]}}\n\n" +
+					"event: content_block_start\ndata: {\"type\":\"content_block_start\",\"index\":0,\"content_block\":{\"type\":\"thinking\",\"thinking\":\"\"}}\n\n" +
+					"event: content_block_delta\ndata: {\"type\":\"content_block_delta\",\"index\":0,\"delta\":{\"type\":\"thinking_delta\",\"thinking\":\"想一想\"}}\n\n" +
+					"event: content_block_start\ndata: {\"type\":\"content_block_start\",\"index\":1,\"content_block\":{\"type\":\"text\",\"text\":\"\"}}\n\n" +
+					"event: content_block_delta\ndata: {\"type\":\"content_block_delta\",\"index\":1,\"delta\":{\"type\":\"text_delta\",\"text\":\"查询中\"}}\n\n" +
+					"event: content_block_start\ndata: {\"type\":\"content_block_start\",\"index\":2,\"content_block\":{\"type\":\"tool_use\",\"id\":\"toolu_1\",\"name\":\"search\",\"input\":{}}}\n\n" +
+					"event: content_block_delta\ndata: {\"type\":\"content_block_delta\",\"index\":2,\"delta\":{\"type\":\"input_json_delta\",\"partial_json\":\"{\\\"q\\\":\"}}\n\n" +
+					"event: content_block_delta\ndata: {\"type\":\"content_block_delta\",\"index\":2,\"delta\":{\"type\":\"input_json_delta\",\"partial_json\":\"\\\"go\\\"}\"}}\n\n" +
+					"event: message_delta\ndata: {\"type\":\"message_delta\",\"delta\":{\"stop_reason\":\"tool_use\"}}\n\n" +
+					"event: message_stop\ndata: {\"type\":\"message_stop\"}\n\n",
+				content:      "查询中",
+				reasoning:    "想一想",
+				toolCalls:    []expectedToolCall{{id: "toolu_1", name: "search", arguments: `{"q":"go"}`}},
+				finishReason: "tool_use",
+			},
+			{
+				name:         "Gemini非流式",
+				format:       relaycommon.RelayFormatGemini,
+				body:         `{"candidates":[{"content":{"role":"model","parts":[{"text":"思考","thought":true},{"text":"回复"}]},"finishReason":"STOP"}]}`,
+				content:      "回复",
+				reasoning:    "思考",
+				finishReason: "STOP",
+			},
+			{
+				name:         "Gemini JSON数组流式",
+				format:       relaycommon.RelayFormatGemini,
+				body:         `[{"candidates":[{"content":{"role":"model","parts":[{"text":"你好"}]}}]},{"candidates":[{"content":{"role":"model","parts":[{"text":"，世界"}]},"finishReason":"STOP"}]}]`,
+				content:      "你好，世界",
+				finishReason: "STOP",
+			},
+			{
+				name:   "Gemini SSE流式functionCall",
+				format: relaycommon.RelayFormatGemini,
+				body: "data: {\"candidates\":[{\"content\":{\"role\":\"model\",\"parts\":[{\"text\":\"调用工具\"}]}}]}\r\n\r\n" +
+					"data: {\"candidates\":[{\"content\":{\"role\":\"model\",\"parts\":[{\"functionCall\":{\"name\":\"get_weather\",\"args\":{\"city\":\"北京\"}}}]},\"finishReason\":\"STOP\"}]}\r\n\r\n",
+				content:      "调用工具",
+				toolCalls:    []expectedToolCall{{name: "get_weather", arguments: `{"city":"北京"}`}},
+				finishReason: "STOP",
+			},
+			{
+				name:         "Responses非流式",
+				format:       relaycommon.RelayFormatOpenAIResponses,
+				body:         `{"id":"resp_1","object":"response","status":"completed","output":[{"type":"reasoning","id":"rs_1","summary":[{"type":"summary_text","text":"思考"}]},{"type":"message","id":"msg_1","role":"assistant","content":[{"type":"output_text","text":"回复"}]},{"type":"function_call","id":"fc_1","call_id":"call_1","name":"search","arguments":"{\"q\":\"go\"}"}]}`,
+				content:      "回复",
+				reasoning:    "思考",
+				toolCalls:    []expectedToolCall{{id: "call_1", name: "search", arguments: `{"q":"go"}`}},
+				finishReason: "completed",
+			},
+			{
+				name:   "Responses流式",
+				format: relaycommon.RelayFormatOpenAIResponses,
+				body: "event: response.created\ndata: {\"type\":\"response.created\",\"response\":{\"id\":\"resp_1\",\"status\":\"in_progress\",\"output\":[]}}\n\n" +
+					"event: response.output_text.delta\ndata: {\"type\":\"response.output_text.delta\",\"delta\":\"回\"}\n\n" +
+					"event: response.output_text.delta\ndata: {\"type\":\"response.output_text.delta\",\"delta\":\"复\"}\n\n" +
+					"event: response.completed\ndata: {\"type\":\"response.completed\",\"response\":{\"id\":\"resp_1\",\"status\":\"completed\",\"output\":[{\"type\":\"message\",\"id\":\"msg_1\",\"role\":\"assistant\",\"content\":[{\"type\":\"output_text\",\"text\":\"回复\"}]},{\"type\":\"function_call\",\"id\":\"fc_1\",\"call_id\":\"call_1\",\"name\":\"search\",\"arguments\":\"{}\"}]}}\n\n",
+				content:      "回复",
+				toolCalls:    []expectedToolCall{{id: "call_1", name: "search", arguments: `{}`}},
+				finishReason: "completed",
 			},
 		}
-		response.Choices[0].Message.SetStringContent("AI的回复内容")
-
-		responseBody, err := json.Marshal(response)
-		assert.NoError(t, err)
 
-		// 测试解析
-		extracted := parseAIResponseFromBody(responseBody)
-		assert.NotNil(t, extracted)
-		assert.Equal(t, "AI的回复内容", extracted.Content)
-		assert.Equal(t, "AI的思考过程", extracted.ReasoningContent)
+		for _, tc := range testCases {
+			t.Run(tc.name, func(t *testing.T) {
+				message, finishReason, ok := relay.AssembleAssistantMessage(tc.format, []byte(tc.body))
+				assert.True(t, ok)
+				assert.Equal(t, "assistant", message.Role)
+				assert.Equal(t, tc.content, message.StringContent())
+				assert.Equal(t, tc.reasoning, message.ReasoningContent)
+				assert.Equal(t, tc.finishReason, finishReason)
+
+				toolCalls := message.ParseToolCalls()
+				assert.Len(t, toolCalls, len(tc.toolCalls))
+				for i, expected := range tc.toolCalls {
+					if i >= len(toolCalls) {
+						break
+					}
+					assert.Equal(t, expected.id, toolCalls[i].ID)
+					assert.Equal(t, expected.name, toolCalls[i].Function.Name)
+					assert.JSONEq(t, expected.arguments, toolCalls[i].Function.Arguments)
+				}
+			})
+		}
 	})
 
 	// 测试空回复不保存
 	t.Run("TestEmptyResponseNotSaved", func(t *testing.T) {
-		w := httptest.NewRecorder()
-		c, _ := gin.CreateTestContext(w)
-
-		// 模拟空的AI回复
-		aiResponse := &openai.AIResponseContent{
-			Content: "",
+		testCases := []struct {
+			name   string
+			format string
+			body   string
+		}{
+			{"空响应体", relaycommon.RelayFormatOpenAI, ""},
+			{"OpenAI空回复", relaycommon.RelayFormatOpenAI, `{"choices":[{"index":0,"message":{"role":"assistant","content":""},"finish_reason":"stop"}]}`},
+			{"OpenAI只有DONE", relaycommon.RelayFormatOpenAI, "data: [DONE]\n\n"},
+			{"Claude只有开始结束事件", relaycommon.RelayFormatClaude, "data: {\"type\":\"message_start\",\"message\":{\"content\":[]}}\n\ndata: {\"type\":\"message_stop\"}\n\n"},
+			{"Gemini无候选", relaycommon.RelayFormatGemini, `{"candidates":[]}`},
+			{"Responses未完成", relaycommon.RelayFormatOpenAIResponses, "data: {\"type\":\"response.output_text.delta\",\"delta\":\"回\"}\n\n"},
+			{"非JSON内容", relaycommon.RelayFormatOpenAI, "upstream error"},
+		}
+		for _, tc := range testCases {
+			t.Run(tc.name, func(t *testing.T) {
+				_, _, ok := relay.AssembleAssistantMessage(tc.format, []byte(tc.body))
+				assert.False(t, ok)
+			})
 		}
-		c.Set("ai_response_content", aiResponse)
-
-		extracted := extractAIResponseFromContext(c)
-		assert.NotNil(t, extracted)
-		assert.Equal(t, "", extracted.Content)
-
-		// 在实际的saveConversationHistoryWithResponse函数中，空内容会导致提前返回
 	})
 
 	// 测试对话历史JSON格式
@@ -88,17 +207,16 @@ func TestConversationHistoryIntegration(t *testing.T) {
 		}
 		userMessage.SetStringContent("你是什么模型")
 
-		aiMessage := dto.Message{
-			Role:             "assistant",
-			ReasoningContent: "我需要思考一下这个问题",
-		}
-		aiMessage.SetStringContent("我是DeepSeek-R1模型")
+		aiMessage, finishReason, ok := relay.AssembleAssistantMessage(relaycommon.RelayFormatOpenAI,
+			[]byte(`{"choices":[{"index":0,"message":{"role":"assistant","content":"我是DeepSeek-R1模型","reasoning_content":"我需要思考一下这个问题"},"finish_reason":"stop"}]}`))
+		assert.True(t, ok)
 
 		messages := []dto.Message{userMessage, aiMessage}
 
 		conversationData := map[string]interface{}{
-			"messages": messages,
-			"model":    "deepseek-r1",
+			"messages":      messages,
+			"model":         "deepseek-r1",
+			"finish_reason": finishReason,
 		}
 
 		jsonData, err := json.Marshal(conversationData)
@@ -110,6 +228,7 @@ func TestConversationHistoryIntegration(t *testing.T) {
 		assert.NoError(t, err)
 
 		assert.Equal(t, "deepseek-r1", parsed["model"])
+		assert.Equal(t, "stop", parsed["finish_reason"])
 
 		messagesArray, ok := parsed["messages"].([]interface{})
 		assert.True(t, ok)
@@ -122,48 +241,9 @@ func TestConversationHistoryIntegration(t *testing.T) {
 		// 验证AI消息
 		aiMsg := messagesArray[1].(map[string]interface{})
 		assert.Equal(t, "assistant", aiMsg["role"])
+		assert.Equal(t, "我是DeepSeek-R1模型", aiMsg["content"])
 		assert.Equal(t, "我需要思考一下这个问题", aiMsg["reasoning_content"])
 
 		fmt.Printf("Generated conversation JSON: %s\n", string(jsonData))
 	})
 }
-
-// 这些函数需要从relay-text.go中导入，这里只是为了测试而重新定义
-func extractAIResponseFromContext(c *gin.Context) *openai.AIResponseContent {
-	if responseData, exists := c.Get("ai_response_content"); exists {
-		if content, ok := responseData.(*openai.AIResponseContent); ok {
-			return content
-		}
-	}
-	return nil
-}
-
-func parseAIResponseFromBody(responseBody []byte) *openai.AIResponseContent {
-	var openaiResponse dto.OpenAITextResponse
-	if err := json.Unmarshal(responseBody, &openaiResponse); err != nil {
-		return nil
-	}
-
-	if len(openaiResponse.Choices) == 0 {
-		return nil
-	}
-
-	choice := openaiResponse.Choices[0]
-	content := choice.Message.StringContent()
-
-	if content == "" {
-		return nil
-	}
-
-	result := &openai.AIResponseContent{
-		Content: content,
-	}
-
-	if choice.Message.ReasoningContent != "" {
-		result.ReasoningContent = choice.Message.ReasoningContent
-	} else if choice.Message.Reasoning != "" {
-		result.ReasoningContent = choice.Message.Reasoning
-	}
-
-	return result
-}
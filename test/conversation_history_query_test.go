@@ -0,0 +1,142 @@
+package test
+
+import (
+	"fmt"
+	"one-api/common"
+	"one-api/model"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// createHistoriesInPartitions 在按天分表中写入指定时间的对话历史，返回按时间从新到旧排列的会话 ID
+func createHistoriesInPartitions(t *testing.T, times []time.Time, userIds []int) []string {
+	t.Helper()
+	daily := common.MESDailyPartition
+	common.MESDailyPartition = true
+	t.Cleanup(func() { common.MESDailyPartition = daily })
+
+	ids := make([]string, len(times))
+	for i, createdAt := range times {
+		assert.NoError(t, model.SimulateDateChange(createdAt))
+		history := &model.ConversationHistory{
+			ConversationId: fmt.Sprintf("conv_%d", i),
+			ModelName:      "gpt-4o",
+			RawJson:        `{"messages":[{"role":"user","content":"hello"}]}`,
+			UserId:         userIds[i],
+			CreatedAt:      createdAt,
+		}
+		assert.NoError(t, model.MES_DB.Table(model.GetConversationHistoryTableName(createdAt)).Create(history).Error)
+		ids[i] = history.ConversationId
+	}
+	for i, j := 0, len(ids)-1; i < j; i, j = i+1, j-1 {
+		ids[i], ids[j] = ids[j], ids[i]
+	}
+	return ids
+}
+
+func TestQueryConversationHistoriesPagination(t *testing.T) {
+	setupTestDB(t)
+	base := time.Date(2025, 3, 1, 10, 0, 0, 0, time.Local)
+	times := []time.Time{
+		base,
+		base.Add(time.Hour),
+		base.AddDate(0, 0, 1),
+		base.AddDate(0, 0, 1).Add(time.Hour),
+		base.AddDate(0, 0, 3),
+	}
+	newestFirst := createHistoriesInPartitions(t, times, []int{1, 2, 1, 1, 2})
+
+	for _, limit := range []int{1, 2, 3, 10} {
+		t.Run(fmt.Sprintf("limit_%d", limit), func(t *testing.T) {
+			var got []string
+			var cursor *model.ConversationHistoryCursor
+			for page := 0; page < 10; page++ {
+				histories, next, err := model.QueryConversationHistories(model.ConversationHistoryFilter{}, cursor, limit)
+				assert.NoError(t, err)
+				assert.LessOrEqual(t, len(histories), limit)
+				for _, history := range histories {
+					got = append(got, history.ConversationId)
+				}
+				if next == nil {
+					break
+				}
+				// 游标经过编码后传给下一页
+				cursor, err = model.DecodeConversationHistoryCursor(next.Encode())
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, newestFirst, got)
+		})
+	}
+}
+
+func TestQueryConversationHistoriesFilter(t *testing.T) {
+	setupTestDB(t)
+	base := time.Date(2025, 3, 1, 10, 0, 0, 0, time.Local)
+	times := []time.Time{
+		base,
+		base.Add(time.Hour),
+		base.AddDate(0, 0, 1),
+		base.AddDate(0, 0, 1).Add(time.Hour),
+		base.AddDate(0, 0, 3),
+	}
+	createHistoriesInPartitions(t, times, []int{1, 2, 1, 1, 2})
+	at := func(tm time.Time) *time.Time { return &tm }
+
+	tests := []struct {
+		name   string
+		filter model.ConversationHistoryFilter
+		want   []string
+	}{
+		{name: "按用户过滤", filter: model.ConversationHistoryFilter{UserId: 2}, want: []string{"conv_4", "conv_1"}},
+		{name: "单日范围", filter: model.ConversationHistoryFilter{StartTime: at(base.AddDate(0, 0, 1).Truncate(time.Hour)), EndTime: at(base.AddDate(0, 0, 2))}, want: []string{"conv_3", "conv_2"}},
+		{name: "只有开始时间", filter: model.ConversationHistoryFilter{StartTime: at(base.AddDate(0, 0, 2))}, want: []string{"conv_4"}},
+		{name: "只有结束时间", filter: model.ConversationHistoryFilter{EndTime: at(base.Add(30 * time.Minute))}, want: []string{"conv_0"}},
+		{name: "跨天的时刻范围", filter: model.ConversationHistoryFilter{StartTime: at(base.Add(30 * time.Minute)), EndTime: at(base.AddDate(0, 0, 1).Add(30 * time.Minute))}, want: []string{"conv_2", "conv_1"}},
+		{name: "用户和时间组合", filter: model.ConversationHistoryFilter{UserId: 1, EndTime: at(base.AddDate(0, 0, 2))}, want: []string{"conv_3", "conv_2", "conv_0"}},
+		{name: "范围内没有分表", filter: model.ConversationHistoryFilter{StartTime: at(base.AddDate(0, 1, 0))}, want: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			histories, next, err := model.QueryConversationHistories(tt.filter, nil, 100)
+			assert.NoError(t, err)
+			assert.Nil(t, next)
+			var got []string
+			for _, history := range histories {
+				got = append(got, history.ConversationId)
+			}
+			assert.Equal(t, tt.want, got)
+
+			count, err := model.CountConversationHistories(tt.filter)
+			assert.NoError(t, err)
+			assert.Equal(t, int64(len(tt.want)), count)
+		})
+	}
+}
+
+func TestDecodeConversationHistoryCursor(t *testing.T) {
+	cursor := &model.ConversationHistoryCursor{CreatedAt: time.Unix(1700000000, 123456789), Id: 42}
+	tests := []struct {
+		name    string
+		cursor  string
+		want    *model.ConversationHistoryCursor
+		wantErr bool
+	}{
+		{name: "编码后解码", cursor: cursor.Encode(), want: cursor},
+		{name: "不是 base64", cursor: "!!!", wantErr: true},
+		{name: "缺少 ID", cursor: "MTIz", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := model.DecodeConversationHistoryCursor(tt.cursor)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.True(t, tt.want.CreatedAt.Equal(got.CreatedAt))
+			assert.Equal(t, tt.want.Id, got.Id)
+		})
+	}
+}
@@ -11,12 +11,8 @@ import (
 
 // TestConversationHistory 测试对话历史功能
 func TestConversationHistory(t *testing.T) {
-	// 初始化数据库连接（这里需要根据实际情况配置）
-	err := model.InitDB()
-	if err != nil {
-		t.Fatal("Failed to initialize database:", err)
-	}
-	defer model.CloseDB()
+	// 使用临时 SQLite 数据库
+	setupTestDB(t)
 
 	// 测试数据
 	testUserId := 1
@@ -52,7 +48,7 @@ func TestConversationHistory(t *testing.T) {
 
 	// 1. 测试创建对话历史
 	t.Run("CreateConversationHistory", func(t *testing.T) {
-		err = model.CreateConversationHistory(nil, testConversationId, testModelName, string(jsonData), testUserId)
+		err = model.CreateConversationHistory(nil, testConversationId, testModelName, string(jsonData), testUserId, 0, 0)
 		if err != nil {
 			t.Errorf("创建对话历史失败: %v", err)
 		} else {
@@ -152,7 +148,7 @@ func TestConversationHistory(t *testing.T) {
 }
 
 // 运行测试的示例函数
-func ExampleRunTest() {
+func Example_runTest() {
 	// 运行测试: go test ./test -v
 	log.Println("运行测试命令: go test ./test -v")
 }
@@ -0,0 +1,186 @@
+package test
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"one-api/common"
+	"one-api/controller"
+	"one-api/model"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+type replayResult struct {
+	HistoryId    int    `json:"history_id"`
+	ChannelId    int    `json:"channel_id"`
+	Success      bool   `json:"success"`
+	StatusCode   int    `json:"status_code"`
+	ErrorMessage string `json:"error_message"`
+	Response     string `json:"response"`
+	Usage        *struct {
+		TotalTokens int `json:"total_tokens"`
+	} `json:"usage"`
+}
+
+// setupReplayUpstream 启动模拟的 OpenAI 上游，消息中包含 fail 时返回 500，并创建指向它的渠道
+func setupReplayUpstream(t *testing.T) (*model.Channel, *int32) {
+	t.Helper()
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "application/json")
+		if strings.Contains(string(body), "fail") {
+			w.WriteHeader(http.StatusInternalServerError)
+			_, _ = w.Write([]byte(`{"error":{"message":"upstream down","type":"server_error","code":"server_error"}}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"id":"chatcmpl-1","object":"chat.completion","created":1,"model":"gpt-4o","choices":[{"index":0,"message":{"role":"assistant","content":"pong"},"finish_reason":"stop"}],"usage":{"prompt_tokens":3,"completion_tokens":1,"total_tokens":4}}`))
+	}))
+	t.Cleanup(server.Close)
+
+	baseURL := server.URL
+	channel := &model.Channel{
+		Type:    common.ChannelTypeOpenAI,
+		Key:     "sk-test",
+		Name:    "replay",
+		BaseURL: &baseURL,
+		Models:  "gpt-4o",
+		Group:   "default",
+		Status:  common.ChannelStatusEnabled,
+	}
+	assert.NoError(t, channel.Insert())
+	return channel, &requests
+}
+
+func createErrorHistory(t *testing.T, userId int, rawJson string, createdAt time.Time) *model.ErrorConversationHistory {
+	t.Helper()
+	assert.NoError(t, model.SimulateDateChange(createdAt))
+	history := &model.ErrorConversationHistory{
+		ConversationId: "conv_replay",
+		ModelName:      "gpt-4o",
+		RawJson:        rawJson,
+		ErrorCode:      "500",
+		ErrorMessage:   "original error",
+		UserId:         userId,
+		CreatedAt:      createdAt,
+	}
+	assert.NoError(t, model.MES_DB.Table(model.GetErrorConversationHistoryTableName(createdAt)).Create(history).Error)
+	return history
+}
+
+func TestReplayErrorConversationHistory(t *testing.T) {
+	setupTestDB(t)
+	gin.SetMode(gin.TestMode)
+	channel, requests := setupReplayUpstream(t)
+	user := &model.User{Username: "replay_user", AffCode: "replay", Group: "default", Quota: 1000}
+	assert.NoError(t, model.DB.Create(user).Error)
+	day := time.Date(2025, 6, 1, 10, 0, 0, 0, time.Local)
+
+	tests := []struct {
+		name         string
+		rawJson      string
+		channelId    int
+		wantSuccess  bool
+		wantStatus   int
+		wantMessage  string
+		wantRequests int32
+	}{
+		{name: "指定渠道重放成功", rawJson: `{"messages":[{"role":"user","content":"ping"}]}`, channelId: channel.Id, wantSuccess: true, wantStatus: http.StatusOK, wantRequests: 1},
+		{name: "按正常规则选择渠道", rawJson: `{"messages":[{"role":"user","content":"ping"}]}`, wantSuccess: true, wantStatus: http.StatusOK, wantRequests: 1},
+		{name: "上游仍然失败", rawJson: `{"messages":[{"role":"user","content":"fail"}]}`, channelId: channel.Id, wantStatus: http.StatusInternalServerError, wantMessage: "upstream down", wantRequests: 1},
+		{name: "没有消息时不发送请求", rawJson: `{"messages":[]}`, channelId: channel.Id, wantMessage: "错误对话历史中没有消息"},
+		{name: "无法解析的记录", rawJson: `not json`, channelId: channel.Id, wantMessage: "无法解析错误对话历史"},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			history := createErrorHistory(t, user.Id, tt.rawJson, day.Add(time.Duration(i)*time.Minute))
+			before := atomic.LoadInt32(requests)
+
+			c, recorder := newJSONContext(http.MethodPost, map[string]int{"channel_id": tt.channelId})
+			c.Params = gin.Params{{Key: "id", Value: strconv.Itoa(history.Id)}}
+			controller.ReplayErrorConversationHistory(c)
+			var resp struct {
+				Success bool         `json:"success"`
+				Data    replayResult `json:"data"`
+			}
+			assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &resp))
+			assert.True(t, resp.Success)
+			assert.Equal(t, history.Id, resp.Data.HistoryId)
+			assert.Equal(t, tt.wantSuccess, resp.Data.Success)
+			assert.Equal(t, tt.wantStatus, resp.Data.StatusCode)
+			assert.Contains(t, resp.Data.ErrorMessage, tt.wantMessage)
+			assert.Equal(t, tt.wantRequests, atomic.LoadInt32(requests)-before)
+			if tt.wantSuccess {
+				assert.Equal(t, channel.Id, resp.Data.ChannelId)
+				assert.Equal(t, "pong", resp.Data.Response)
+				assert.Equal(t, 4, resp.Data.Usage.TotalTokens)
+			}
+		})
+	}
+
+	// 重放是演练，不扣费也不记录消费日志
+	assert.Equal(t, 1000, getUserQuota(t, user.Id))
+	var logs int64
+	assert.NoError(t, model.LOG_DB.Model(&model.Log{}).Count(&logs).Error)
+	assert.Equal(t, int64(0), logs)
+}
+
+func TestBatchReplayErrorConversationHistories(t *testing.T) {
+	setupTestDB(t)
+	gin.SetMode(gin.TestMode)
+	channel, requests := setupReplayUpstream(t)
+	user := &model.User{Username: "batch_replay_user", AffCode: "batchreplay", Group: "default"}
+	assert.NoError(t, model.DB.Create(user).Error)
+	day := time.Date(2025, 6, 1, 10, 0, 0, 0, time.Local)
+	for i, rawJson := range []string{
+		`{"messages":[{"role":"user","content":"ping"}]}`,
+		`{"messages":[{"role":"user","content":"fail"}]}`,
+		`{"messages":[{"role":"user","content":"ping again"}]}`,
+	} {
+		createErrorHistory(t, user.Id, rawJson, day.Add(time.Duration(i)*time.Hour))
+	}
+
+	tests := []struct {
+		name          string
+		body          map[string]any
+		wantTotal     int
+		wantSucceeded int
+	}{
+		{name: "重放全部", body: map[string]any{"channel_id": channel.Id}, wantTotal: 3, wantSucceeded: 2},
+		{name: "限制数量时重放最新的记录", body: map[string]any{"channel_id": channel.Id, "limit": 1}, wantTotal: 1, wantSucceeded: 1},
+		{name: "按时间过滤", body: map[string]any{"channel_id": channel.Id, "end_timestamp": day.Add(90 * time.Minute).Unix()}, wantTotal: 2, wantSucceeded: 1},
+		{name: "按用户过滤", body: map[string]any{"channel_id": channel.Id, "user_id": user.Id + 1}, wantTotal: 0, wantSucceeded: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := atomic.LoadInt32(requests)
+			c, recorder := newJSONContext(http.MethodPost, tt.body)
+			controller.BatchReplayErrorConversationHistories(c)
+			var resp struct {
+				Success bool `json:"success"`
+				Data    struct {
+					Results   []replayResult `json:"results"`
+					Total     int            `json:"total"`
+					Succeeded int            `json:"succeeded"`
+					Failed    int            `json:"failed"`
+				} `json:"data"`
+			}
+			assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &resp))
+			assert.True(t, resp.Success)
+			assert.Equal(t, tt.wantTotal, resp.Data.Total)
+			assert.Len(t, resp.Data.Results, tt.wantTotal)
+			assert.Equal(t, tt.wantSucceeded, resp.Data.Succeeded)
+			assert.Equal(t, tt.wantTotal-tt.wantSucceeded, resp.Data.Failed)
+			assert.Equal(t, int32(tt.wantTotal), atomic.LoadInt32(requests)-before)
+		})
+	}
+}
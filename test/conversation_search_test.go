@@ -0,0 +1,140 @@
+package test
+
+import (
+	"one-api/common"
+	"one-api/model"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func enableConversationSearch(t *testing.T, enabled bool) {
+	t.Helper()
+	search := common.ConversationHistorySearch
+	common.ConversationHistorySearch = enabled
+	t.Cleanup(func() { common.ConversationHistorySearch = search })
+}
+
+// createSearchHistories 写入用于检索的对话历史
+func createSearchHistories(t *testing.T) {
+	t.Helper()
+	histories := []struct {
+		conversationId string
+		rawJson        string
+		userId         int
+		tokenId        int
+	}{
+		{conversationId: "conv_sourdough", userId: 1, tokenId: 10, rawJson: `{"messages":[{"role":"system","content":"You are helpful"},{"role":"user","content":"How do I bake sourdough bread?"},{"role":"assistant","content":"Use a starter"}]}`},
+		{conversationId: "conv_cjk", userId: 1, tokenId: 11, rawJson: `{"messages":[{"role":"user","content":"如何学习机器学习"},{"role":"assistant","content":"从线性代数开始"}]}`},
+		{conversationId: "conv_banana", userId: 2, tokenId: 12, rawJson: `{"messages":[{"role":"user","content":[{"type":"text","text":"bread <b>recipe</b>"}]},{"role":"assistant","content":"Try banana bread"}]}`},
+	}
+	for _, history := range histories {
+		assert.NoError(t, model.CreateConversationHistory(nil, history.conversationId, "gpt-4o", history.rawJson, history.userId, history.tokenId, 0))
+	}
+}
+
+func searchConversationIds(t *testing.T, filter model.ConversationSearchFilter) []string {
+	t.Helper()
+	results, total, err := model.SearchConversationHistoriesByIndex(filter, 10, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(len(results)), total)
+	var ids []string
+	for _, result := range results {
+		ids = append(ids, result.ConversationId)
+	}
+	return ids
+}
+
+func TestSearchConversationHistoriesByIndex(t *testing.T) {
+	setupTestDB(t)
+	enableConversationSearch(t, true)
+	createSearchHistories(t)
+	keyword := func(keyword string) model.ConversationSearchFilter {
+		return model.ConversationSearchFilter{ConversationHistoryFilter: model.ConversationHistoryFilter{Keyword: keyword}}
+	}
+
+	tests := []struct {
+		name   string
+		filter func() model.ConversationSearchFilter
+		want   []string
+	}{
+		{name: "单个关键词", filter: func() model.ConversationSearchFilter { return keyword("Bread") }, want: []string{"conv_sourdough", "conv_banana"}},
+		{name: "多个关键词都必须出现", filter: func() model.ConversationSearchFilter { return keyword("sourdough bread") }, want: []string{"conv_sourdough"}},
+		{name: "中文按相邻两字匹配", filter: func() model.ConversationSearchFilter { return keyword("机器学习") }, want: []string{"conv_cjk"}},
+		{name: "中文不连续的字不匹配", filter: func() model.ConversationSearchFilter { return keyword("机习") }, want: nil},
+		{name: "限定用户角色", filter: func() model.ConversationSearchFilter {
+			filter := keyword("banana")
+			filter.Role = "user"
+			return filter
+		}, want: nil},
+		{name: "限定助手角色", filter: func() model.ConversationSearchFilter {
+			filter := keyword("banana")
+			filter.Role = "assistant"
+			return filter
+		}, want: []string{"conv_banana"}},
+		{name: "按用户过滤", filter: func() model.ConversationSearchFilter {
+			filter := keyword("bread")
+			filter.UserId = 2
+			return filter
+		}, want: []string{"conv_banana"}},
+		{name: "按令牌过滤", filter: func() model.ConversationSearchFilter {
+			filter := keyword("bread")
+			filter.TokenId = 10
+			return filter
+		}, want: []string{"conv_sourdough"}},
+		{name: "没有匹配", filter: func() model.ConversationSearchFilter { return keyword("missing") }, want: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.ElementsMatch(t, tt.want, searchConversationIds(t, tt.filter()))
+		})
+	}
+}
+
+func TestConversationSearchSnippets(t *testing.T) {
+	setupTestDB(t)
+	enableConversationSearch(t, true)
+	createSearchHistories(t)
+
+	results, _, err := model.SearchConversationHistoriesByIndex(model.ConversationSearchFilter{
+		ConversationHistoryFilter: model.ConversationHistoryFilter{Keyword: "recipe"},
+	}, 10, 0)
+	assert.NoError(t, err)
+	if assert.Len(t, results, 1) {
+		// 摘要中的 HTML 被转义，匹配的词被标出
+		assert.Equal(t, []string{"bread &lt;b&gt;<mark>recipe</mark>&lt;/b&gt;"}, results[0].Snippets)
+	}
+
+	// 已删除的对话历史不返回
+	assert.NoError(t, model.MES_DB.Exec("DROP TABLE "+model.GetConversationHistoryTableName()).Error)
+	results, total, err := model.SearchConversationHistoriesByIndex(model.ConversationSearchFilter{
+		ConversationHistoryFilter: model.ConversationHistoryFilter{Keyword: "bread"},
+	}, 10, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), total)
+	assert.Empty(t, results)
+}
+
+func TestIndexConversationHistoryPartition(t *testing.T) {
+	setupTestDB(t)
+	// 开启检索之前写入的对话历史没有索引
+	enableConversationSearch(t, false)
+	createSearchHistories(t)
+	assert.Empty(t, searchConversationIds(t, model.ConversationSearchFilter{
+		ConversationHistoryFilter: model.ConversationHistoryFilter{Keyword: "bread"},
+	}))
+
+	common.ConversationHistorySearch = true
+	partitions, err := model.GetMESPartitions(model.ConversationHistoryBaseTable)
+	assert.NoError(t, err)
+	var indexed int64
+	for _, partition := range partitions {
+		count, err := model.IndexConversationHistoryPartition(partition, 2)
+		assert.NoError(t, err)
+		indexed += count
+	}
+	assert.Equal(t, int64(3), indexed)
+	assert.ElementsMatch(t, []string{"conv_sourdough", "conv_banana"}, searchConversationIds(t, model.ConversationSearchFilter{
+		ConversationHistoryFilter: model.ConversationHistoryFilter{Keyword: "bread"},
+	}))
+}
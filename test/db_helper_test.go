@@ -0,0 +1,32 @@
+package test
+
+import (
+	"one-api/common"
+	"one-api/model"
+	"path/filepath"
+	"testing"
+)
+
+// setupTestDB 使用临时 SQLite 文件初始化主库、日志库和对话历史库，测试结束后关闭
+func setupTestDB(t *testing.T) {
+	t.Helper()
+	t.Setenv("SQL_DSN", "")
+	t.Setenv("LOG_SQL_DSN", "")
+	t.Setenv("MES_SQL_DSN", "")
+	common.SQLitePath = filepath.Join(t.TempDir(), "one-api.db")
+	common.IsMasterNode = true
+	common.RedisEnabled = false
+	common.MemoryCacheEnabled = false
+	if err := model.InitDB(); err != nil {
+		t.Fatal("Failed to initialize database:", err)
+	}
+	if err := model.InitLogDB(); err != nil {
+		t.Fatal("Failed to initialize log database:", err)
+	}
+	if err := model.InitMESDB(); err != nil {
+		t.Fatal("Failed to initialize conversation history database:", err)
+	}
+	t.Cleanup(func() {
+		_ = model.CloseDB()
+	})
+}
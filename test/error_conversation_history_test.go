@@ -9,10 +9,7 @@ import (
 )
 
 func TestErrorConversationHistory(t *testing.T) {
-	err := model.InitDB()
-	if err != nil {
-		t.Fatal(err)
-	}
+	setupTestDB(t)
 
 	testConversationId := "test_error_conv_123"
 	testModelName := "gpt-3.5-turbo"
@@ -34,7 +31,7 @@ func TestErrorConversationHistory(t *testing.T) {
 	assert.NoError(t, err)
 
 	t.Run("CreateErrorConversationHistory", func(t *testing.T) {
-		err = model.CreateErrorConversationHistory(nil, testConversationId, testModelName, string(jsonData), testErrorMessage, testErrorCode, testUserId)
+		err = model.CreateErrorConversationHistory(nil, testConversationId, testModelName, string(jsonData), testErrorMessage, testErrorCode, testUserId, 0)
 		assert.NoError(t, err)
 	})
 
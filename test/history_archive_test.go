@@ -0,0 +1,192 @@
+package test
+
+import (
+	"one-api/common"
+	"one-api/model"
+	"one-api/service"
+	"one-api/setting/operation_setting"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// setupLocalArchive 将归档写入测试临时目录
+func setupLocalArchive(t *testing.T, dropAfterArchive bool) string {
+	t.Helper()
+	setting := operation_setting.GetHistoryArchiveSetting()
+	previous := *setting
+	t.Cleanup(func() { *setting = previous })
+	setting.Storage = operation_setting.HistoryArchiveStorageLocal
+	setting.LocalDir = t.TempDir()
+	setting.DropAfterArchive = dropAfterArchive
+	return setting.LocalDir
+}
+
+func getConversationPartition(t *testing.T, date time.Time) *model.MESPartition {
+	t.Helper()
+	partitions, err := model.GetMESPartitions(model.ConversationHistoryBaseTable)
+	assert.NoError(t, err)
+	for _, partition := range partitions {
+		if partition.Date.Equal(date) {
+			return &partition
+		}
+	}
+	return nil
+}
+
+func TestArchiveAndRestoreMESPartition(t *testing.T) {
+	day := time.Date(2025, 2, 10, 0, 0, 0, 0, time.Local)
+
+	tests := []struct {
+		name        string
+		drop        bool
+		wantDropped bool
+	}{
+		{name: "归档后删除分表", drop: true, wantDropped: true},
+		{name: "归档后保留分表", drop: false, wantDropped: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupTestDB(t)
+			dir := setupLocalArchive(t, tt.drop)
+			createHistoriesInPartitions(t, []time.Time{day.Add(time.Hour), day.Add(2 * time.Hour), day.Add(3 * time.Hour)}, []int{1, 1, 2})
+			partition := getConversationPartition(t, day)
+			if !assert.NotNil(t, partition) {
+				return
+			}
+
+			archive, err := service.ArchiveMESPartition(model.ConversationHistoryBaseTable, *partition)
+			assert.NoError(t, err)
+			assert.Equal(t, int64(3), archive.Rows)
+			assert.Equal(t, model.HistoryArchiveStatusArchived, archive.Status)
+			assert.Equal(t, tt.wantDropped, archive.Dropped)
+			assert.FileExists(t, filepath.Join(dir, "conversation_histories_2025_02_10.jsonl.gz"))
+			assert.FileExists(t, filepath.Join(dir, "conversation_histories_2025_02_10.manifest.json"))
+			assert.Equal(t, !tt.wantDropped, getConversationPartition(t, day) != nil)
+
+			saved, err := model.GetHistoryArchive(model.ConversationHistoryBaseTable, "2025-02-10")
+			assert.NoError(t, err)
+			assert.Equal(t, archive.Id, saved.Id)
+
+			// 恢复后行数与归档一致，重复恢复时跳过已存在的记录
+			for i := 0; i < 2; i++ {
+				rows, err := service.RestoreHistoryArchive(saved)
+				assert.NoError(t, err)
+				assert.Equal(t, int64(3), rows)
+				restored := getConversationPartition(t, day)
+				if !assert.NotNil(t, restored) {
+					return
+				}
+				count, err := model.CountMESPartitionRows(*restored)
+				assert.NoError(t, err)
+				assert.Equal(t, int64(3), count)
+			}
+			saved, err = model.GetHistoryArchiveById(archive.Id)
+			assert.NoError(t, err)
+			assert.Equal(t, model.HistoryArchiveStatusRestored, saved.Status)
+			assert.False(t, saved.Dropped)
+		})
+	}
+}
+
+func TestRestoreHistoryArchiveChecksumMismatch(t *testing.T) {
+	setupTestDB(t)
+	setupLocalArchive(t, true)
+	day := time.Date(2025, 2, 11, 0, 0, 0, 0, time.Local)
+	createHistoriesInPartitions(t, []time.Time{day.Add(time.Hour)}, []int{1})
+	partition := getConversationPartition(t, day)
+	if !assert.NotNil(t, partition) {
+		return
+	}
+	archive, err := service.ArchiveMESPartition(model.ConversationHistoryBaseTable, *partition)
+	assert.NoError(t, err)
+
+	// 归档文件被改动后拒绝恢复
+	assert.NoError(t, os.WriteFile(archive.Path, []byte("corrupted"), 0644))
+	_, err = service.RestoreHistoryArchive(archive)
+	assert.Error(t, err)
+	assert.Nil(t, getConversationPartition(t, day))
+	saved, err := model.GetHistoryArchiveById(archive.Id)
+	assert.NoError(t, err)
+	assert.Equal(t, model.HistoryArchiveStatusArchived, saved.Status)
+}
+
+func TestArchiveDedupMESPartition(t *testing.T) {
+	setupTestDB(t)
+	setupLocalArchive(t, true)
+	enableConversationDedup(t, false)
+	day := time.Date(2025, 2, 11, 0, 0, 0, 0, time.Local)
+	createHistoriesInPartitions(t, []time.Time{day.Add(time.Hour), day.Add(2 * time.Hour)}, []int{1, 2})
+	enableConversationDedup(t, true)
+	dedupAllConversationPartitions(t)
+	backdateConversationMessages(t)
+	partition := getConversationPartition(t, day)
+	if !assert.NotNil(t, partition) {
+		return
+	}
+
+	archive, err := service.ArchiveMESPartition(model.ConversationHistoryBaseTable, *partition)
+	assert.NoError(t, err)
+	assert.True(t, archive.Dropped)
+	// 分表删除后不再被引用的消息一起删除
+	var messages int64
+	assert.NoError(t, model.MES_DB.Model(&model.ConversationMessage{}).Count(&messages).Error)
+	assert.Zero(t, messages)
+
+	// 归档中是完整的消息，恢复后不依赖消息表
+	rows, err := service.RestoreHistoryArchive(archive)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), rows)
+	var restored []*model.ConversationHistory
+	assert.NoError(t, model.MES_DB.Table(partition.Table).Order("id").Find(&restored).Error)
+	if assert.Len(t, restored, 2) {
+		for _, stored := range restored {
+			history, err := model.GetConversationHistoryById(stored.Id)
+			assert.NoError(t, err)
+			assert.Equal(t, []map[string]any{{"role": "user", "content": "hello"}}, messagesOf(t, history.RawJson))
+		}
+	}
+}
+
+func TestRestoreEncryptedHistoryArchiveAfterKeyRotation(t *testing.T) {
+	setupTestDB(t)
+	setupLocalArchive(t, true)
+	setupHistoryPrivacy(t, func(setting *operation_setting.HistoryPrivacySetting) {
+		setting.EncryptionEnabled = true
+	})
+	common.CryptoSecret = "old-secret"
+	common.CryptoSecretPrevious = nil
+	day := time.Date(2025, 2, 12, 0, 0, 0, 0, time.Local)
+	createHistoriesInPartitions(t, []time.Time{day.Add(time.Hour)}, []int{1})
+	partition := getConversationPartition(t, day)
+	if !assert.NotNil(t, partition) {
+		return
+	}
+	_, err := model.ReencryptMESPartition(*partition, 500)
+	assert.NoError(t, err)
+	archive, err := service.ArchiveMESPartition(model.ConversationHistoryBaseTable, *partition)
+	assert.NoError(t, err)
+
+	// 轮换密钥后没有保留旧密钥，拒绝恢复且不写入任何记录
+	common.CryptoSecret = "new-secret"
+	_, err = service.RestoreHistoryArchive(archive)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "CRYPTO_SECRET_PREVIOUS")
+	}
+	assert.Nil(t, getConversationPartition(t, day))
+
+	// 保留旧密钥后可以恢复，恢复的记录使用当前密钥加密
+	common.CryptoSecretPrevious = []string{"old-secret"}
+	rows, err := service.RestoreHistoryArchive(archive)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), rows)
+	var restored model.ConversationHistory
+	assert.NoError(t, model.MES_DB.Table(partition.Table).First(&restored).Error)
+	common.CryptoSecretPrevious = nil
+	history, err := model.GetConversationHistoryById(restored.Id)
+	assert.NoError(t, err)
+	assert.Equal(t, []map[string]any{{"role": "user", "content": "hello"}}, messagesOf(t, history.RawJson))
+}
@@ -0,0 +1,213 @@
+package test
+
+import (
+	"net/http/httptest"
+	"one-api/common"
+	"one-api/constant"
+	"one-api/model"
+	"one-api/service"
+	"one-api/setting/operation_setting"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetConversationHistoryPolicy(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	setting := operation_setting.GetHistoryPolicySetting()
+	groupPolicies := setting.GroupPolicies
+	enabled := common.ConversationHistoryEnabled
+	defer func() {
+		setting.GroupPolicies = groupPolicies
+		common.ConversationHistoryEnabled = enabled
+	}()
+	setting.GroupPolicies = map[string]operation_setting.HistoryPolicy{
+		"vip": {Mode: operation_setting.HistoryModeErrorsOnly, RetentionDays: 7},
+	}
+
+	tests := []struct {
+		name          string
+		tokenPolicy   *operation_setting.HistoryPolicy
+		userSetting   map[string]interface{}
+		group         string
+		globalEnabled bool
+		want          operation_setting.HistoryPolicy
+	}{
+		{
+			name:        "令牌策略优先",
+			tokenPolicy: &operation_setting.HistoryPolicy{Mode: operation_setting.HistoryModeSampled, SampleRate: 10},
+			userSetting: map[string]interface{}{constant.UserSettingHistoryMode: operation_setting.HistoryModeOff},
+			group:       "vip",
+			want:        operation_setting.HistoryPolicy{Mode: operation_setting.HistoryModeSampled, SampleRate: 10},
+		},
+		{
+			name:        "令牌未设置时使用用户设置",
+			tokenPolicy: &operation_setting.HistoryPolicy{},
+			userSetting: map[string]interface{}{
+				constant.UserSettingHistoryMode:          operation_setting.HistoryModeSampled,
+				constant.UserSettingHistorySampleRate:    float64(30),
+				constant.UserSettingHistoryRetentionDays: "14",
+			},
+			group: "vip",
+			want:  operation_setting.HistoryPolicy{Mode: operation_setting.HistoryModeSampled, SampleRate: 30, RetentionDays: 14},
+		},
+		{
+			name:  "用户未设置时使用分组策略",
+			group: "vip",
+			want:  operation_setting.HistoryPolicy{Mode: operation_setting.HistoryModeErrorsOnly, RetentionDays: 7},
+		},
+		{name: "都未设置时按全局开关保存", group: "default", globalEnabled: true, want: operation_setting.HistoryPolicy{Mode: operation_setting.HistoryModeOn}},
+		{name: "都未设置且全局关闭", group: "default", want: operation_setting.HistoryPolicy{Mode: operation_setting.HistoryModeOff}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			common.ConversationHistoryEnabled = tt.globalEnabled
+			c, _ := gin.CreateTestContext(httptest.NewRecorder())
+			if tt.tokenPolicy != nil {
+				c.Set("token_history_policy", *tt.tokenPolicy)
+			}
+			if tt.userSetting != nil {
+				c.Set(constant.ContextKeyUserSetting, tt.userSetting)
+			}
+			c.Set("group", tt.group)
+			assert.Equal(t, tt.want, service.GetConversationHistoryPolicy(c))
+		})
+	}
+}
+
+func TestShouldSaveConversationHistory(t *testing.T) {
+	tests := []struct {
+		name      string
+		policy    operation_setting.HistoryPolicy
+		wantOk    bool
+		wantError bool
+	}{
+		{name: "保存所有对话", policy: operation_setting.HistoryPolicy{Mode: operation_setting.HistoryModeOn}, wantOk: true, wantError: true},
+		{name: "不保存", policy: operation_setting.HistoryPolicy{Mode: operation_setting.HistoryModeOff}},
+		{name: "只保存失败的请求", policy: operation_setting.HistoryPolicy{Mode: operation_setting.HistoryModeErrorsOnly}, wantError: true},
+		{name: "全部抽中", policy: operation_setting.HistoryPolicy{Mode: operation_setting.HistoryModeSampled, SampleRate: 100}, wantOk: true, wantError: true},
+		{name: "抽样比例为 0", policy: operation_setting.HistoryPolicy{Mode: operation_setting.HistoryModeSampled}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.wantOk, service.ShouldSaveConversationHistory(tt.policy, false))
+			assert.Equal(t, tt.wantError, service.ShouldSaveConversationHistory(tt.policy, true))
+		})
+	}
+}
+
+func TestHistoryPolicyValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  operation_setting.HistoryPolicy
+		wantErr bool
+	}{
+		{name: "继承上一级", policy: operation_setting.HistoryPolicy{}},
+		{name: "只保存失败的请求", policy: operation_setting.HistoryPolicy{Mode: operation_setting.HistoryModeErrorsOnly, RetentionDays: 3}},
+		{name: "抽样比例合法", policy: operation_setting.HistoryPolicy{Mode: operation_setting.HistoryModeSampled, SampleRate: 100}},
+		{name: "抽样比例为 0", policy: operation_setting.HistoryPolicy{Mode: operation_setting.HistoryModeSampled}, wantErr: true},
+		{name: "抽样比例超过 100", policy: operation_setting.HistoryPolicy{Mode: operation_setting.HistoryModeSampled, SampleRate: 101}, wantErr: true},
+		{name: "未知模式", policy: operation_setting.HistoryPolicy{Mode: "always"}, wantErr: true},
+		{name: "保留天数为负数", policy: operation_setting.HistoryPolicy{Mode: operation_setting.HistoryModeOn, RetentionDays: -1}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.policy.Validate()
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestCleanupConversationHistoryRetention(t *testing.T) {
+	setupTestDB(t)
+	daily := common.MESDailyPartition
+	common.MESDailyPartition = true
+	defer func() { common.MESDailyPartition = daily }()
+	now := time.Now()
+
+	tests := []struct {
+		name          string
+		age           int // 创建于多少天前
+		retentionDays int // 0 表示没有单独设置保留天数
+		wantKept      bool
+	}{
+		{name: "超过全局清理天数", age: 40, wantKept: false},
+		{name: "超过全局清理天数但保留期未到", age: 40, retentionDays: 60, wantKept: true},
+		{name: "未到全局清理天数但保留期已过", age: 2, retentionDays: 1, wantKept: false},
+		{name: "未到全局清理天数", age: 2, wantKept: true},
+	}
+	ids := make([]int, len(tests))
+	for i, tt := range tests {
+		createdAt := now.AddDate(0, 0, -tt.age)
+		assert.NoError(t, model.SimulateDateChange(createdAt))
+		tableName := model.GetConversationHistoryTableName(createdAt)
+		history := &model.ConversationHistory{
+			ConversationId: "conv_retention",
+			ModelName:      "gpt-4o",
+			RawJson:        `{"messages":[]}`,
+			UserId:         1,
+			CreatedAt:      createdAt,
+		}
+		assert.NoError(t, model.MES_DB.Table(tableName).Create(history).Error)
+		if tt.retentionDays > 0 {
+			assert.NoError(t, model.MES_DB.Create(&model.ConversationHistoryRetention{
+				HistoryTable: tableName,
+				HistoryId:    history.Id,
+				ExpireAt:     createdAt.AddDate(0, 0, tt.retentionDays),
+			}).Error)
+		}
+		ids[i] = history.Id
+	}
+
+	deleted, err := model.CleanupOldConversationHistories(30)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), deleted)
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := model.GetConversationHistoryById(ids[i])
+			assert.Equal(t, tt.wantKept, err == nil)
+		})
+	}
+
+	// 过期的保留记录在清理后被删除
+	var retentions int64
+	assert.NoError(t, model.MES_DB.Model(&model.ConversationHistoryRetention{}).Count(&retentions).Error)
+	assert.Equal(t, int64(1), retentions)
+}
+
+func TestCreateConversationHistoryRetention(t *testing.T) {
+	setupTestDB(t)
+
+	tests := []struct {
+		name          string
+		retentionDays int
+		wantRecord    bool
+	}{
+		{name: "按全局清理天数清理时不记录", retentionDays: 0},
+		{name: "记录单独设置的保留天数", retentionDays: 7, wantRecord: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.NoError(t, model.CreateConversationHistory(nil, tt.name, "gpt-4o", `{"messages":[]}`, 1, 0, tt.retentionDays))
+			var history model.ConversationHistory
+			assert.NoError(t, model.MES_DB.Table(model.GetConversationHistoryTableName()).Where("conversation_id = ?", tt.name).First(&history).Error)
+
+			var retentions []model.ConversationHistoryRetention
+			assert.NoError(t, model.MES_DB.Where("history_id = ?", history.Id).Find(&retentions).Error)
+			if !tt.wantRecord {
+				assert.Empty(t, retentions)
+				return
+			}
+			if assert.Len(t, retentions, 1) {
+				assert.Equal(t, model.GetConversationHistoryTableName(), retentions[0].HistoryTable)
+				assert.WithinDuration(t, history.CreatedAt.AddDate(0, 0, tt.retentionDays), retentions[0].ExpireAt, time.Second)
+			}
+		})
+	}
+}
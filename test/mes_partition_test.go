@@ -0,0 +1,118 @@
+package test
+
+import (
+	"one-api/common"
+	"one-api/model"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsMESNativePartition(t *testing.T) {
+	setupTestDB(t)
+	native := common.MESNativePartition
+	defer func() { common.MESNativePartition = native }()
+
+	tests := []struct {
+		name   string
+		native bool
+		want   bool
+	}{
+		{name: "未开启原生分区", native: false, want: false},
+		{name: "SQLite 不支持原生分区", native: true, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			common.MESNativePartition = tt.native
+			assert.Equal(t, tt.want, model.IsMESNativePartition())
+		})
+	}
+}
+
+func TestGetMESPartitions(t *testing.T) {
+	setupTestDB(t)
+	daily := common.MESDailyPartition
+	common.MESDailyPartition = true
+	defer func() { common.MESDailyPartition = daily }()
+
+	day := func(year int, month time.Month, date int) time.Time {
+		return time.Date(year, month, date, 0, 0, 0, 0, time.Local)
+	}
+	// 乱序创建分表
+	for _, date := range []time.Time{day(2025, 1, 15), day(2024, 12, 31), day(2025, 1, 2)} {
+		assert.NoError(t, model.SimulateDateChange(date))
+	}
+	// 名称相似但不是日期后缀的表不属于分区
+	assert.NoError(t, model.MES_DB.Exec("CREATE TABLE conversation_histories_backup (id integer)").Error)
+	assert.NoError(t, model.MES_DB.Exec("CREATE TABLE conversation_historiesX2025_01_01 (id integer)").Error)
+
+	tests := []struct {
+		baseTable string
+		want      []string
+	}{
+		{
+			baseTable: model.ConversationHistoryBaseTable,
+			want: []string{
+				"conversation_histories",
+				"conversation_histories_2024_12_31",
+				"conversation_histories_2025_01_02",
+				"conversation_histories_2025_01_15",
+			},
+		},
+		{
+			baseTable: model.ErrorConversationHistoryBaseTable,
+			want: []string{
+				"error_conversation_histories",
+				"error_conversation_histories_2024_12_31",
+				"error_conversation_histories_2025_01_02",
+				"error_conversation_histories_2025_01_15",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.baseTable, func(t *testing.T) {
+			partitions, err := model.GetMESPartitions(tt.baseTable)
+			assert.NoError(t, err)
+			var names []string
+			for _, partition := range partitions {
+				names = append(names, partition.Name)
+				assert.Equal(t, partition.Name, partition.Table)
+				assert.False(t, partition.Native)
+			}
+			assert.Equal(t, tt.want, names)
+			// 未分表的基础表日期为零值，排在最前
+			assert.True(t, partitions[0].Date.IsZero())
+			assert.Equal(t, day(2024, 12, 31), partitions[1].Date)
+		})
+	}
+}
+
+func TestCreateConversationHistoryPartitionDate(t *testing.T) {
+	setupTestDB(t)
+	daily := common.MESDailyPartition
+	common.MESDailyPartition = true
+	defer func() { common.MESDailyPartition = daily }()
+
+	assert.NoError(t, model.CreateConversationHistory(nil, "conv_partition", "gpt-4o", `{"messages":[]}`, 1, 0, 0))
+	assert.NoError(t, model.CreateErrorConversationHistory(nil, "conv_partition", "gpt-4o", `{"messages":[]}`, "boom", "500", 1, 0))
+
+	// 记录写入的分表与其 created_at 所在的日期一致
+	for _, baseTable := range []string{model.ConversationHistoryBaseTable, model.ErrorConversationHistoryBaseTable} {
+		t.Run(baseTable, func(t *testing.T) {
+			partitions, err := model.GetMESPartitions(baseTable)
+			assert.NoError(t, err)
+			found := false
+			for _, partition := range partitions {
+				var createdAt []time.Time
+				assert.NoError(t, model.MES_DB.Table(partition.Table).Where("conversation_id = ?", "conv_partition").Pluck("created_at", &createdAt).Error)
+				for _, value := range createdAt {
+					found = true
+					year, month, day := value.Local().Date()
+					assert.Equal(t, time.Date(year, month, day, 0, 0, 0, 0, time.Local), partition.Date)
+				}
+			}
+			assert.True(t, found)
+		})
+	}
+}
@@ -0,0 +1,238 @@
+package test
+
+import (
+	"fmt"
+	"one-api/common"
+	"one-api/model"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func createOrganizationUser(t *testing.T, username string, quota int) *model.User {
+	t.Helper()
+	user := &model.User{Username: username, AffCode: username, Group: "default", Quota: quota}
+	assert.NoError(t, model.DB.Create(user).Error)
+	return user
+}
+
+func TestOrganizationMemberValidateSpending(t *testing.T) {
+	tests := []struct {
+		name    string
+		period  string
+		limit   int
+		wantErr bool
+	}{
+		{name: "不限制", period: "", limit: 0},
+		{name: "累计上限", period: "", limit: 1000},
+		{name: "每月上限", period: model.BudgetPeriodMonthly, limit: 1000},
+		{name: "无效周期", period: "yearly", limit: 1000, wantErr: true},
+		{name: "负数上限", period: model.BudgetPeriodDaily, limit: -1, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			member := &model.OrganizationMember{SpendingPeriod: tt.period, SpendingLimit: tt.limit}
+			err := member.ValidateSpending()
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestTransferUserQuotaToOrganization(t *testing.T) {
+	setupTestDB(t)
+	owner := createOrganizationUser(t, "org_owner", 1500)
+	organization, err := model.CreateOrganization("acme", owner.Id)
+	assert.NoError(t, err)
+
+	tests := []struct {
+		name          string
+		quota         int
+		wantErr       bool
+		wantUserQuota int
+		wantOrgQuota  int
+	}{
+		{name: "转入组织钱包", quota: 1000, wantUserQuota: 500, wantOrgQuota: 1000},
+		{name: "余额不足时不转入", quota: 1000, wantErr: true, wantUserQuota: 500, wantOrgQuota: 1000},
+		{name: "转入额度必须大于 0", quota: 0, wantErr: true, wantUserQuota: 500, wantOrgQuota: 1000},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := model.TransferUserQuotaToOrganization(owner.Id, organization.Id, tt.quota)
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tt.wantUserQuota, getUserQuota(t, owner.Id))
+			quota, err := model.GetOrganizationQuota(organization.Id)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.wantOrgQuota, quota)
+		})
+	}
+
+	// 两笔账本记录互为对方科目
+	ledgers, total, err := model.GetQuotaLedgers(model.QuotaLedgerFilter{Reason: model.QuotaReasonTransfer}, 0, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), total)
+	for _, ledger := range ledgers {
+		switch ledger.AccountType {
+		case model.QuotaAccountUser:
+			assert.Equal(t, -1000, ledger.Delta)
+			assert.Equal(t, fmt.Sprintf("organization:%d", organization.Id), ledger.CounterAccount)
+		case model.QuotaAccountOrganization:
+			assert.Equal(t, 1000, ledger.Delta)
+			assert.Equal(t, fmt.Sprintf("user:%d", owner.Id), ledger.CounterAccount)
+		}
+	}
+}
+
+func TestOrganizationWalletQuota(t *testing.T) {
+	setupTestDB(t)
+	batch := common.BatchUpdateEnabled
+	common.BatchUpdateEnabled = false
+	defer func() { common.BatchUpdateEnabled = batch }()
+	owner := createOrganizationUser(t, "wallet_owner", 2000)
+	developer := createOrganizationUser(t, "wallet_developer", 50)
+	outsider := createOrganizationUser(t, "wallet_outsider", 0)
+	organization, err := model.CreateOrganization("acme", owner.Id)
+	assert.NoError(t, err)
+	assert.NoError(t, model.TransferUserQuotaToOrganization(owner.Id, organization.Id, 1000))
+	member := &model.OrganizationMember{
+		OrganizationId: organization.Id,
+		UserId:         developer.Id,
+		Role:           model.OrganizationRoleDeveloper,
+		SpendingLimit:  300,
+	}
+	assert.NoError(t, member.Insert())
+
+	steps := []struct {
+		name            string
+		run             func() error
+		userId          int
+		organizationId  int
+		wantErr         bool
+		wantWallet      int
+		wantOrgQuota    int
+		wantMemberSpent int
+	}{
+		{name: "个人令牌使用用户余额", userId: developer.Id, wantWallet: 50, wantOrgQuota: 1000},
+		{name: "所有者没有消费上限", userId: owner.Id, organizationId: organization.Id, wantWallet: 1000, wantOrgQuota: 1000},
+		{name: "成员受消费上限限制", userId: developer.Id, organizationId: organization.Id, wantWallet: 300, wantOrgQuota: 1000},
+		{
+			name: "成员消费从组织钱包扣除",
+			run: func() error {
+				return model.DecreaseWalletQuota(developer.Id, organization.Id, 200, model.QuotaMovement{Reason: model.QuotaReasonConsume})
+			},
+			userId: developer.Id, organizationId: organization.Id, wantWallet: 100, wantOrgQuota: 800, wantMemberSpent: 200,
+		},
+		{
+			name: "退还额度减少成员消费",
+			run: func() error {
+				return model.IncreaseWalletQuota(developer.Id, organization.Id, 50, model.QuotaMovement{Reason: model.QuotaReasonRefund})
+			},
+			userId: developer.Id, organizationId: organization.Id, wantWallet: 150, wantOrgQuota: 850, wantMemberSpent: 150,
+		},
+		{name: "非成员不能使用组织钱包", userId: outsider.Id, organizationId: organization.Id, wantErr: true, wantOrgQuota: 850, wantMemberSpent: 150},
+	}
+	for _, step := range steps {
+		t.Run(step.name, func(t *testing.T) {
+			if step.run != nil {
+				assert.NoError(t, step.run())
+			}
+			wallet, err := model.GetWalletQuota(step.userId, step.organizationId)
+			if step.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, step.wantWallet, wallet)
+			}
+			quota, err := model.GetOrganizationQuota(organization.Id)
+			assert.NoError(t, err)
+			assert.Equal(t, step.wantOrgQuota, quota)
+			saved, err := model.GetOrganizationMember(organization.Id, developer.Id)
+			assert.NoError(t, err)
+			assert.Equal(t, step.wantMemberSpent, saved.SpendingUsed)
+		})
+	}
+	// 个人余额不受组织消费影响
+	assert.Equal(t, 50, getUserQuota(t, developer.Id))
+
+	// 组织钱包的变动同样记入账本，对账一致
+	_, drifts, err := model.ReconcileQuotaLedger()
+	assert.NoError(t, err)
+	assert.Empty(t, drifts)
+
+	organizations, err := model.GetUserOrganizations(developer.Id)
+	assert.NoError(t, err)
+	if assert.Len(t, organizations, 1) {
+		assert.Equal(t, model.OrganizationRoleDeveloper, organizations[0].Role)
+	}
+
+	// 删除组织后成员不能再使用组织钱包
+	assert.NoError(t, model.DeleteOrganization(organization.Id))
+	_, err = model.GetWalletQuota(developer.Id, organization.Id)
+	assert.Error(t, err)
+	organizations, err = model.GetUserOrganizations(developer.Id)
+	assert.NoError(t, err)
+	assert.Empty(t, organizations)
+}
+
+func TestOrganizationWalletEnforcedDebit(t *testing.T) {
+	setupTestDB(t)
+	owner := createOrganizationUser(t, "enforce_owner", 1000)
+	developer := createOrganizationUser(t, "enforce_developer", 0)
+	organization, err := model.CreateOrganization("acme", owner.Id)
+	assert.NoError(t, err)
+	assert.NoError(t, model.TransferUserQuotaToOrganization(owner.Id, organization.Id, 1000))
+	member := &model.OrganizationMember{
+		OrganizationId: organization.Id,
+		UserId:         developer.Id,
+		Role:           model.OrganizationRoleDeveloper,
+		SpendingLimit:  300,
+	}
+	assert.NoError(t, member.Insert())
+
+	steps := []struct {
+		name            string
+		userId          int
+		quota           int
+		enforce         bool
+		wantErr         error
+		wantOrgQuota    int
+		wantMemberSpent int
+	}{
+		{name: "超出成员消费上限", userId: developer.Id, quota: 400, enforce: true, wantErr: model.ErrQuotaBudgetExceeded, wantOrgQuota: 1000},
+		{name: "上限内扣费", userId: developer.Id, quota: 250, enforce: true, wantOrgQuota: 750, wantMemberSpent: 250},
+		{name: "累计消费超出上限", userId: developer.Id, quota: 100, enforce: true, wantErr: model.ErrQuotaBudgetExceeded, wantOrgQuota: 750, wantMemberSpent: 250},
+		{name: "钱包余额不足", userId: owner.Id, quota: 800, enforce: true, wantErr: model.ErrQuotaBudgetExceeded, wantOrgQuota: 750, wantMemberSpent: 250},
+		{name: "结算不受限制", userId: owner.Id, quota: 800, wantOrgQuota: -50, wantMemberSpent: 250},
+	}
+	for _, step := range steps {
+		t.Run(step.name, func(t *testing.T) {
+			err := model.DecreaseWalletQuota(step.userId, organization.Id, step.quota, model.QuotaMovement{
+				Reason:        model.QuotaReasonConsume,
+				EnforceBudget: step.enforce,
+			})
+			if step.wantErr != nil {
+				assert.ErrorIs(t, err, step.wantErr)
+			} else {
+				assert.NoError(t, err)
+			}
+			quota, err := model.GetOrganizationQuota(organization.Id)
+			assert.NoError(t, err)
+			assert.Equal(t, step.wantOrgQuota, quota)
+			saved, err := model.GetOrganizationMember(organization.Id, developer.Id)
+			assert.NoError(t, err)
+			assert.Equal(t, step.wantMemberSpent, saved.SpendingUsed)
+		})
+	}
+
+	// 失败的扣费整笔回滚，不写入账本
+	_, drifts, err := model.ReconcileQuotaLedger()
+	assert.NoError(t, err)
+	assert.Empty(t, drifts)
+}
@@ -0,0 +1,191 @@
+package test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"one-api/common"
+	"one-api/controller"
+	"one-api/model"
+	"one-api/service"
+	"one-api/setting"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+// setupMockPayment 启用模拟支付渠道，返回测试用户
+func setupMockPayment(t *testing.T, username string) *model.User {
+	t.Helper()
+	setupTestDB(t)
+	gin.SetMode(gin.TestMode)
+	debugEnabled := common.DebugEnabled
+	payMethods := setting.PayMethods
+	common.DebugEnabled = true
+	setting.PayMethods = []map[string]string{{"name": "mock", "type": "mock", "provider": service.PaymentProviderMock}}
+	t.Cleanup(func() {
+		common.DebugEnabled = debugEnabled
+		setting.PayMethods = payMethods
+	})
+	user := &model.User{Username: username, AffCode: username, Group: "default"}
+	assert.NoError(t, model.DB.Create(user).Error)
+	return user
+}
+
+func newJSONContext(method string, body any) (*gin.Context, *httptest.ResponseRecorder) {
+	payload, _ := json.Marshal(body)
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	c.Request = httptest.NewRequest(method, "/", bytes.NewReader(payload))
+	c.Request.Header.Set("Content-Type", "application/json")
+	return c, recorder
+}
+
+// sendMockNotify 将模拟渠道返回的表单提交到回调地址
+func sendMockNotify(t *testing.T, params map[string]string) *httptest.ResponseRecorder {
+	t.Helper()
+	form := url.Values{}
+	for key, value := range params {
+		form.Set(key, value)
+	}
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	c.Request = httptest.NewRequest(http.MethodPost, "/api/user/payment/mock/notify", bytes.NewBufferString(form.Encode()))
+	c.Request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	c.Params = gin.Params{{Key: "provider", Value: service.PaymentProviderMock}}
+	controller.PaymentNotify(c)
+	return recorder
+}
+
+func getUserQuota(t *testing.T, userId int) int {
+	t.Helper()
+	quota, err := model.GetUserQuota(userId, true)
+	assert.NoError(t, err)
+	return quota
+}
+
+func TestMockPaymentFlow(t *testing.T) {
+	user := setupMockPayment(t, "payment_user")
+
+	// 创建订单
+	c, recorder := newJSONContext(http.MethodPost, controller.PaymentRequest{Amount: 10, PaymentMethod: "mock"})
+	c.Set("id", user.Id)
+	controller.RequestPayment(c)
+	var checkout struct {
+		Message string            `json:"message"`
+		Data    map[string]string `json:"data"`
+		Url     string            `json:"url"`
+	}
+	assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &checkout))
+	assert.Equal(t, "success", checkout.Message)
+	tradeNo := checkout.Data["trade_no"]
+	topUp := model.GetTopUpByTradeNo(tradeNo)
+	if !assert.NotNil(t, topUp) {
+		return
+	}
+	assert.Equal(t, model.TopUpStatusPending, topUp.Status)
+	assert.Equal(t, "MOCK"+tradeNo, topUp.ProviderTradeNo)
+	quota := topUp.GetQuota()
+	assert.Greater(t, quota, 0)
+
+	// 支付回调及重复的回调只入账一次
+	for i := 0; i < 2; i++ {
+		resp := sendMockNotify(t, checkout.Data)
+		assert.Equal(t, http.StatusOK, resp.Code)
+		assert.Equal(t, quota, getUserQuota(t, user.Id))
+	}
+	assert.Equal(t, model.TopUpStatusSuccess, model.GetTopUpByTradeNo(tradeNo).Status)
+
+	// 退款收回额度，重复退款被拒绝
+	wantSuccess := []bool{true, false}
+	for _, want := range wantSuccess {
+		c, recorder = newJSONContext(http.MethodPost, map[string]any{})
+		c.Params = gin.Params{{Key: "trade_no", Value: tradeNo}}
+		controller.RefundTopUp(c)
+		var resp struct {
+			Success bool `json:"success"`
+		}
+		assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &resp))
+		assert.Equal(t, want, resp.Success)
+		assert.Equal(t, 0, getUserQuota(t, user.Id))
+	}
+	topUp = model.GetTopUpByTradeNo(tradeNo)
+	assert.Equal(t, model.TopUpStatusRefunded, topUp.Status)
+	assert.Equal(t, topUp.Money, topUp.RefundMoney)
+
+	// 退款后迟到的支付回调不会再次入账
+	sendMockNotify(t, checkout.Data)
+	assert.Equal(t, 0, getUserQuota(t, user.Id))
+}
+
+func TestPaymentRefundStatus(t *testing.T) {
+	user := setupMockPayment(t, "refund_user")
+
+	tests := []struct {
+		name        string
+		resultMoney float64 // 渠道退款通知中的金额
+		wantRefund  float64
+		wantQuota   int
+	}{
+		{name: "通知未返回金额时全额退款", resultMoney: 0, wantRefund: 10, wantQuota: 0},
+		{name: "按通知中的金额部分退款", resultMoney: 4, wantRefund: 4, wantQuota: 600},
+		{name: "通知金额超出订单金额时按订单金额", resultMoney: 20, wantRefund: 10, wantQuota: 0},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.NoError(t, model.DB.Model(&model.User{}).Where("id = ?", user.Id).Update("quota", 0).Error)
+			topUp := &model.TopUp{
+				UserId:          user.Id,
+				Money:           10,
+				Quota:           1000,
+				TradeNo:         "REFUND" + string(rune('A'+i)),
+				Status:          model.TopUpStatusPending,
+				PaymentProvider: service.PaymentProviderMock,
+			}
+			assert.NoError(t, topUp.Insert())
+			_, completed, err := model.CompleteTopUp(topUp.TradeNo, "")
+			assert.NoError(t, err)
+			assert.True(t, completed)
+
+			provider, err := service.GetPaymentProvider(service.PaymentProviderMock)
+			assert.NoError(t, err)
+			err = service.HandlePaymentResult(provider, &service.PaymentResult{
+				TradeNo: topUp.TradeNo,
+				Status:  model.TopUpStatusRefunded,
+				Money:   tt.resultMoney,
+			})
+			assert.NoError(t, err)
+			topUp = model.GetTopUpByTradeNo(topUp.TradeNo)
+			assert.Equal(t, model.TopUpStatusRefunded, topUp.Status)
+			assert.Equal(t, tt.wantRefund, topUp.RefundMoney)
+			assert.Equal(t, tt.wantQuota, getUserQuota(t, user.Id))
+		})
+	}
+}
+
+func TestRefundPaymentProviderFailure(t *testing.T) {
+	user := setupMockPayment(t, "refund_failure_user")
+	// 模拟渠道中不存在该订单，退款请求失败
+	topUp := &model.TopUp{
+		UserId:          user.Id,
+		Money:           10,
+		Quota:           1000,
+		TradeNo:         "REFUNDFAIL",
+		Status:          model.TopUpStatusPending,
+		PaymentProvider: service.PaymentProviderMock,
+	}
+	assert.NoError(t, topUp.Insert())
+	_, _, err := model.CompleteTopUp(topUp.TradeNo, "")
+	assert.NoError(t, err)
+	topUp = model.GetTopUpByTradeNo(topUp.TradeNo)
+
+	_, err = service.RefundPayment(topUp, 0)
+	assert.Error(t, err)
+	// 渠道退款失败时恢复为已支付，额度不受影响，可以再次退款
+	topUp = model.GetTopUpByTradeNo(topUp.TradeNo)
+	assert.Equal(t, model.TopUpStatusSuccess, topUp.Status)
+	assert.Equal(t, 1000, getUserQuota(t, user.Id))
+}
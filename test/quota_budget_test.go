@@ -0,0 +1,69 @@
+package test
+
+import (
+	"fmt"
+	"one-api/common"
+	"one-api/model"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQuotaBudgetHardCap(t *testing.T) {
+	setupTestDB(t)
+	currentStart := model.GetBudgetPeriodStart(model.BudgetPeriodDaily, time.Now()).Unix()
+	previousStart := currentStart - 24*3600
+
+	tests := []struct {
+		name        string
+		used        int
+		periodStart int64
+		quota       int
+		enforce     bool
+		batch       bool
+		wantErr     error
+		wantUsed    int
+		wantBalance int
+	}{
+		{name: "受约束的扣费未超出上限", used: 50, periodStart: currentStart, quota: 50, enforce: true, wantUsed: 100, wantBalance: 950},
+		{name: "受约束的扣费超出上限时整笔失败", used: 80, periodStart: currentStart, quota: 30, enforce: true, wantErr: model.ErrQuotaBudgetExceeded, wantUsed: 80, wantBalance: 1000},
+		{name: "结算扣费不受上限约束", used: 80, periodStart: currentStart, quota: 30, wantUsed: 110, wantBalance: 970},
+		{name: "新周期从 0 开始计算", used: 90, periodStart: previousStart, quota: 60, enforce: true, wantUsed: 60, wantBalance: 940},
+		{name: "新周期的单笔扣费超出上限", used: 0, periodStart: previousStart, quota: 150, enforce: true, wantErr: model.ErrQuotaBudgetExceeded, wantUsed: 0, wantBalance: 1000},
+		{name: "开启批量更新时受约束的扣费立即写入", used: 95, periodStart: currentStart, quota: 10, enforce: true, batch: true, wantErr: model.ErrQuotaBudgetExceeded, wantUsed: 95, wantBalance: 1000},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			user := &model.User{
+				Username:          fmt.Sprintf("budget_user_%d", i),
+				AffCode:           fmt.Sprintf("budget%d", i),
+				Quota:             1000,
+				BudgetPeriod:      model.BudgetPeriodDaily,
+				BudgetLimit:       100,
+				BudgetUsed:        tt.used,
+				BudgetPeriodStart: tt.periodStart,
+			}
+			assert.NoError(t, model.DB.Create(user).Error)
+
+			common.BatchUpdateEnabled = tt.batch
+			defer func() { common.BatchUpdateEnabled = false }()
+			err := model.DecreaseUserQuota(user.Id, tt.quota, model.QuotaMovement{
+				Reason:        model.QuotaReasonConsume,
+				EnforceBudget: tt.enforce,
+			})
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+			} else {
+				assert.NoError(t, err)
+			}
+
+			status, err := model.GetQuotaBudgetStatus(model.QuotaAccountUser, user.Id)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.wantUsed, status.Used)
+			balance, err := model.GetUserQuota(user.Id, true)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.wantBalance, balance)
+		})
+	}
+}
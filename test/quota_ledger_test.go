@@ -0,0 +1,144 @@
+package test
+
+import (
+	"one-api/common"
+	"one-api/model"
+	"one-api/service"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func createLedgerUser(t *testing.T, username string) (*model.User, *model.Token) {
+	t.Helper()
+	user := &model.User{Username: username, AffCode: username, Group: "default"}
+	assert.NoError(t, model.DB.Create(user).Error)
+	token := &model.Token{UserId: user.Id, Key: username + "_key", Name: username, RemainQuota: 0}
+	assert.NoError(t, model.DB.Create(token).Error)
+	return user, token
+}
+
+func TestQuotaLedgerEntries(t *testing.T) {
+	setupTestDB(t)
+	batch := common.BatchUpdateEnabled
+	common.BatchUpdateEnabled = false
+	defer func() { common.BatchUpdateEnabled = batch }()
+	user, token := createLedgerUser(t, "ledger_user")
+
+	steps := []struct {
+		name        string
+		run         func() error
+		wantEntry   model.QuotaLedger
+		wantBalance int
+	}{
+		{
+			name: "充值",
+			run: func() error {
+				return model.IncreaseUserQuota(user.Id, 1000, true, model.QuotaMovement{Reason: model.QuotaReasonTopUp, Remark: "T1"})
+			},
+			wantEntry:   model.QuotaLedger{AccountType: model.QuotaAccountUser, AccountId: user.Id, UserId: user.Id, CounterAccount: "system:topup", Delta: 1000, BalanceBefore: 0, BalanceAfter: 1000, Reason: model.QuotaReasonTopUp, Remark: "T1"},
+			wantBalance: 1000,
+		},
+		{
+			name: "请求扣费",
+			run: func() error {
+				return model.DecreaseUserQuota(user.Id, 300, model.QuotaMovement{Reason: model.QuotaReasonConsume, RequestId: "req-1", TokenId: token.Id})
+			},
+			wantEntry:   model.QuotaLedger{AccountType: model.QuotaAccountUser, AccountId: user.Id, UserId: user.Id, TokenId: token.Id, CounterAccount: "system:consume", Delta: -300, BalanceBefore: 1000, BalanceAfter: 700, Reason: model.QuotaReasonConsume, RequestId: "req-1"},
+			wantBalance: 700,
+		},
+		{
+			name: "退还预扣额度",
+			run: func() error {
+				return model.DeltaUpdateUserQuota(user.Id, 50, model.QuotaMovement{Reason: model.QuotaReasonRefund, RequestId: "req-1"})
+			},
+			wantEntry:   model.QuotaLedger{AccountType: model.QuotaAccountUser, AccountId: user.Id, UserId: user.Id, CounterAccount: "system:refund", Delta: 50, BalanceBefore: 700, BalanceAfter: 750, Reason: model.QuotaReasonRefund, RequestId: "req-1"},
+			wantBalance: 750,
+		},
+		{
+			name: "指定对方科目",
+			run: func() error {
+				return model.DecreaseUserQuota(user.Id, 100, model.QuotaMovement{Reason: model.QuotaReasonTransfer, CounterAccount: "organization:1"})
+			},
+			wantEntry:   model.QuotaLedger{AccountType: model.QuotaAccountUser, AccountId: user.Id, UserId: user.Id, CounterAccount: "organization:1", Delta: -100, BalanceBefore: 750, BalanceAfter: 650, Reason: model.QuotaReasonTransfer},
+			wantBalance: 650,
+		},
+		{
+			name: "管理员直接修改余额时记录差额",
+			run: func() error {
+				edited := &model.User{Id: user.Id, Username: user.Username, Group: user.Group, Quota: 2000}
+				return edited.Edit(false)
+			},
+			wantEntry:   model.QuotaLedger{AccountType: model.QuotaAccountUser, AccountId: user.Id, UserId: user.Id, CounterAccount: "system:adjust", Delta: 1350, BalanceBefore: 650, BalanceAfter: 2000, Reason: model.QuotaReasonAdjust, Remark: "管理员修改用户额度"},
+			wantBalance: 2000,
+		},
+	}
+	for _, step := range steps {
+		t.Run(step.name, func(t *testing.T) {
+			assert.NoError(t, step.run())
+			ledgers, _, err := model.GetQuotaLedgers(model.QuotaLedgerFilter{AccountType: model.QuotaAccountUser, AccountId: user.Id}, 0, 1)
+			assert.NoError(t, err)
+			if !assert.Len(t, ledgers, 1) {
+				return
+			}
+			got := *ledgers[0]
+			assert.NotZero(t, got.CreatedAt)
+			got.Id, got.CreatedAt = 0, 0
+			assert.Equal(t, step.wantEntry, got)
+			assert.Equal(t, step.wantBalance, getUserQuota(t, user.Id))
+		})
+	}
+
+	// 令牌余额的账本
+	assert.NoError(t, model.IncreaseTokenQuota(token.Id, token.Key, 500, model.QuotaMovement{Reason: model.QuotaReasonAdjust, UserId: user.Id}))
+	assert.NoError(t, model.DecreaseTokenQuota(token.Id, token.Key, 200, model.QuotaMovement{Reason: model.QuotaReasonConsume, RequestId: "req-2", UserId: user.Id}))
+
+	filters := []struct {
+		name      string
+		filter    model.QuotaLedgerFilter
+		wantTotal int64
+	}{
+		{name: "按用户", filter: model.QuotaLedgerFilter{UserId: user.Id}, wantTotal: 7},
+		{name: "按账户", filter: model.QuotaLedgerFilter{AccountType: model.QuotaAccountToken, AccountId: token.Id}, wantTotal: 2},
+		{name: "按令牌", filter: model.QuotaLedgerFilter{TokenId: token.Id}, wantTotal: 3},
+		{name: "按原因", filter: model.QuotaLedgerFilter{Reason: model.QuotaReasonConsume}, wantTotal: 2},
+		{name: "按请求", filter: model.QuotaLedgerFilter{RequestId: "req-1"}, wantTotal: 2},
+	}
+	for _, tt := range filters {
+		t.Run(tt.name, func(t *testing.T) {
+			_, total, err := model.GetQuotaLedgers(tt.filter, 0, 10)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.wantTotal, total)
+		})
+	}
+}
+
+func TestReconcileQuotaLedger(t *testing.T) {
+	setupTestDB(t)
+	batch := common.BatchUpdateEnabled
+	common.BatchUpdateEnabled = false
+	defer func() { common.BatchUpdateEnabled = batch }()
+	user, token := createLedgerUser(t, "reconcile_user")
+	other, _ := createLedgerUser(t, "reconcile_other")
+	assert.NoError(t, model.IncreaseUserQuota(user.Id, 1000, true, model.QuotaMovement{Reason: model.QuotaReasonTopUp}))
+	assert.NoError(t, model.DecreaseUserQuota(user.Id, 100, model.QuotaMovement{Reason: model.QuotaReasonConsume}))
+	assert.NoError(t, model.IncreaseUserQuota(other.Id, 500, true, model.QuotaMovement{Reason: model.QuotaReasonRedemption}))
+	assert.NoError(t, model.IncreaseTokenQuota(token.Id, token.Key, 300, model.QuotaMovement{Reason: model.QuotaReasonAdjust, UserId: user.Id}))
+
+	report, err := service.ReconcileQuotaLedger()
+	assert.NoError(t, err)
+	assert.Equal(t, 3, report.Accounts)
+	assert.Empty(t, report.Drifts)
+
+	// 绕过账本直接修改余额
+	assert.NoError(t, model.DB.Model(&model.User{}).Where("id = ?", user.Id).Update("quota", 950).Error)
+	assert.NoError(t, model.DB.Model(&model.Token{}).Where("id = ?", token.Id).Update("remain_quota", 250).Error)
+
+	report, err = service.ReconcileQuotaLedger()
+	assert.NoError(t, err)
+	assert.Equal(t, report, service.GetQuotaReconciliationReport())
+	assert.ElementsMatch(t, []*model.QuotaLedgerDrift{
+		{AccountType: model.QuotaAccountUser, AccountId: user.Id, OpeningBalance: 0, LedgerBalance: 900, LastBalanceAfter: 900, ActualBalance: 950, Drift: 50},
+		{AccountType: model.QuotaAccountToken, AccountId: token.Id, OpeningBalance: 0, LedgerBalance: 300, LastBalanceAfter: 300, ActualBalance: 250, Drift: -50},
+	}, report.Drifts)
+}
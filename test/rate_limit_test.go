@@ -0,0 +1,161 @@
+package test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"one-api/common"
+	"one-api/middleware"
+	"one-api/model"
+	relaycommon "one-api/relay/common"
+	"one-api/service"
+	"sync"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+// 限流计数保存在进程内，各测试使用不同的 ID 避免互相影响
+
+func TestChannelConcurrencyLimit(t *testing.T) {
+	common.RedisEnabled = false
+	limit := int64(2)
+	channel := &model.Channel{Id: 9101, ConcurrencyLimit: &limit}
+
+	release1, ok := service.AcquireChannelConcurrency(channel)
+	assert.True(t, ok)
+	release2, ok := service.AcquireChannelConcurrency(channel)
+	assert.True(t, ok)
+	assert.Equal(t, int64(2), service.GetConcurrencyUsage(service.RateLimitScopeChannel, channel.Id))
+
+	// 渠道已满时拒绝，释放名额后可以再次占用
+	_, ok = service.AcquireChannelConcurrency(channel)
+	assert.False(t, ok)
+	release1()
+	release3, ok := service.AcquireChannelConcurrency(channel)
+	assert.True(t, ok)
+	release2()
+	release3()
+	assert.Zero(t, service.GetConcurrencyUsage(service.RateLimitScopeChannel, channel.Id))
+
+	// 未设置并发限制时不计数
+	unlimited := &model.Channel{Id: 9102}
+	for i := 0; i < 3; i++ {
+		release, ok := service.AcquireChannelConcurrency(unlimited)
+		assert.True(t, ok)
+		release()
+	}
+	assert.Zero(t, service.GetConcurrencyUsage(service.RateLimitScopeChannel, unlimited.Id))
+}
+
+func TestChannelTPMLimit(t *testing.T) {
+	common.RedisEnabled = false
+	limit := int64(100)
+	channel := &model.Channel{Id: 9201, TPMLimit: &limit}
+	relayInfo := &relaycommon.RelayInfo{ChannelId: channel.Id, PromptTokens: 60}
+
+	tests := []struct {
+		name         string
+		totalTokens  int
+		wantUsage    int64
+		wantExceeded bool
+	}{
+		{name: "按实际用量修正预占", totalTokens: 40, wantUsage: 40},
+		{name: "渠道超限时准入只计数", totalTokens: 80, wantUsage: 120, wantExceeded: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, _ := newJSONContext(http.MethodPost, nil)
+			c.Set("channel_tpm_limit", limit)
+			// 渠道的 TPM 在选择渠道时判断，准入时不会因渠道超限拒绝
+			assert.Nil(t, service.ReserveTPM(c, relayInfo))
+			service.SettleTPM(c, tt.totalTokens)
+			// 重复结算不会再次修正
+			service.SettleTPM(c, 0)
+			assert.Equal(t, tt.wantUsage, service.GetTPMUsage(service.RateLimitScopeChannel, channel.Id))
+			assert.Equal(t, tt.wantExceeded, service.IsChannelTPMExceeded(channel))
+		})
+	}
+
+	assert.False(t, service.IsChannelTPMExceeded(&model.Channel{Id: channel.Id}))
+}
+
+func TestReserveTPMTokenLimit(t *testing.T) {
+	common.RedisEnabled = false
+	relayInfo := &relaycommon.RelayInfo{TokenId: 9301, UserId: 9302, PromptTokens: 60}
+	newContext := func() (*gin.Context, *httptest.ResponseRecorder) {
+		c, recorder := newJSONContext(http.MethodPost, nil)
+		c.Set("token_id", relayInfo.TokenId)
+		c.Set("id", relayInfo.UserId)
+		c.Set("token_tpm_limit", 100)
+		c.Set("user_tpm_limit", 1000)
+		return c, recorder
+	}
+
+	c, _ := newContext()
+	assert.Nil(t, service.ReserveTPM(c, relayInfo))
+
+	// 令牌超限时返回 429，已经预占的用户用量一起回滚
+	c, recorder := newContext()
+	err := service.ReserveTPM(c, relayInfo)
+	if assert.NotNil(t, err) {
+		assert.Equal(t, http.StatusTooManyRequests, err.StatusCode)
+	}
+	assert.Equal(t, "100", recorder.Header().Get("x-ratelimit-limit-tokens"))
+	assert.Equal(t, "40", recorder.Header().Get("x-ratelimit-remaining-tokens"))
+	assert.NotEmpty(t, recorder.Header().Get("Retry-After"))
+	assert.Equal(t, int64(60), service.GetTPMUsage(service.RateLimitScopeToken, relayInfo.TokenId))
+	assert.Equal(t, int64(60), service.GetTPMUsage(service.RateLimitScopeUser, relayInfo.UserId))
+
+	c, _ = newContext()
+	limit, remaining, _, ok := service.GetTPMLimitStatus(c)
+	assert.True(t, ok)
+	assert.Equal(t, int64(100), limit)
+	assert.Equal(t, int64(40), remaining)
+}
+
+func TestConcurrencyLimitMiddleware(t *testing.T) {
+	common.RedisEnabled = false
+	gin.SetMode(gin.TestMode)
+	started := make(chan struct{})
+	finish := make(chan struct{})
+	router := gin.New()
+	router.Use(func(c *gin.Context) {
+		c.Set("token_id", 9401)
+		c.Set("id", 9402)
+		c.Set("token_concurrency_limit", 1)
+		c.Next()
+	}, middleware.ConcurrencyLimit())
+	router.GET("/", func(c *gin.Context) {
+		if c.Query("block") != "" {
+			close(started)
+			<-finish
+		}
+		c.Status(http.StatusOK)
+	})
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/?block=1", nil))
+		assert.Equal(t, http.StatusOK, recorder.Code)
+	}()
+	<-started
+
+	// 令牌只允许一个并发请求，第二个请求被拒绝
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.Equal(t, http.StatusTooManyRequests, recorder.Code)
+	assert.Equal(t, "1", recorder.Header().Get("x-ratelimit-limit-concurrency"))
+	assert.Equal(t, int64(1), service.GetConcurrencyUsage(service.RateLimitScopeToken, 9401))
+
+	// 第一个请求结束后释放名额
+	close(finish)
+	wg.Wait()
+	assert.Zero(t, service.GetConcurrencyUsage(service.RateLimitScopeToken, 9401))
+	recorder = httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.Equal(t, http.StatusOK, recorder.Code)
+}
@@ -0,0 +1,244 @@
+package test
+
+import (
+	"fmt"
+	"one-api/common"
+	"one-api/model"
+	"one-api/service"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func createSubscriptionPlan(t *testing.T, name string, group string) *model.SubscriptionPlan {
+	t.Helper()
+	plan := &model.SubscriptionPlan{
+		Name:          name,
+		DurationDays:  30,
+		IncludedQuota: 1000,
+		Group:         group,
+		OverageRatio:  1,
+		Status:        model.SubscriptionPlanStatusEnabled,
+	}
+	assert.NoError(t, plan.Insert())
+	return plan
+}
+
+func TestActivateSubscriptionGroup(t *testing.T) {
+	setupTestDB(t)
+
+	tests := []struct {
+		name          string
+		currentGroup  string // 当前订阅套餐的分组，为 "-" 表示没有当前订阅
+		changedGroup  string // 订阅期间管理员修改的分组
+		planGroup     string
+		wantGroup     string
+		wantPrevGroup string
+	}{
+		{name: "首次订阅切换分组", currentGroup: "-", planGroup: "vip", wantGroup: "vip", wantPrevGroup: "default"},
+		{name: "首次订阅不指定分组", currentGroup: "-", planGroup: "", wantGroup: "default", wantPrevGroup: "default"},
+		{name: "替换为不指定分组的套餐时恢复原分组", currentGroup: "vip", planGroup: "", wantGroup: "default", wantPrevGroup: "default"},
+		{name: "替换为其他分组的套餐", currentGroup: "vip", planGroup: "svip", wantGroup: "svip", wantPrevGroup: "default"},
+		{name: "管理员修改过的分组不恢复", currentGroup: "vip", changedGroup: "custom", planGroup: "", wantGroup: "custom", wantPrevGroup: "default"},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			user := &model.User{
+				Username: fmt.Sprintf("subscription_user_%d", i),
+				AffCode:  fmt.Sprintf("subscription%d", i),
+				Group:    "default",
+			}
+			assert.NoError(t, model.DB.Create(user).Error)
+			if tt.currentGroup != "-" {
+				current := createSubscriptionPlan(t, fmt.Sprintf("current_%d", i), tt.currentGroup)
+				_, err := model.ActivateSubscription(user.Id, current, "")
+				assert.NoError(t, err)
+			}
+			if tt.changedGroup != "" {
+				assert.NoError(t, model.DB.Model(user).Update("group", tt.changedGroup).Error)
+			}
+
+			plan := createSubscriptionPlan(t, fmt.Sprintf("plan_%d", i), tt.planGroup)
+			subscription, err := model.ActivateSubscription(user.Id, plan, "")
+			assert.NoError(t, err)
+			assert.Equal(t, tt.wantPrevGroup, subscription.PreviousGroup)
+			group, err := model.GetUserGroup(user.Id, true)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.wantGroup, group)
+
+			active, err := model.GetActiveSubscription(user.Id)
+			assert.NoError(t, err)
+			assert.Equal(t, subscription.Id, active.Id)
+		})
+	}
+}
+
+func TestProcessDueSubscriptions(t *testing.T) {
+	setupTestDB(t)
+	plan := createSubscriptionPlan(t, "monthly", "vip")
+
+	tests := []struct {
+		name       string
+		autoRenew  bool
+		wantStatus string
+		wantGroup  string
+	}{
+		{name: "自动续订", autoRenew: true, wantStatus: model.SubscriptionStatusActive, wantGroup: "vip"},
+		{name: "未开启自动续订时结束并恢复分组", autoRenew: false, wantStatus: model.SubscriptionStatusExpired, wantGroup: "default"},
+	}
+	subscriptions := make([]*model.Subscription, len(tests))
+	for i, tt := range tests {
+		user := &model.User{
+			Username: fmt.Sprintf("due_user_%d", i),
+			AffCode:  fmt.Sprintf("due%d", i),
+			Group:    "default",
+		}
+		assert.NoError(t, model.DB.Create(user).Error)
+		subscription, err := model.ActivateSubscription(user.Id, plan, "")
+		assert.NoError(t, err)
+		// 将订阅改为已到期
+		assert.NoError(t, model.DB.Model(subscription).Updates(map[string]interface{}{
+			"end_time":   common.GetTimestamp() - 1,
+			"auto_renew": tt.autoRenew,
+		}).Error)
+		subscriptions[i] = subscription
+	}
+
+	renewed, expired, err := service.ProcessDueSubscriptions()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, renewed)
+	assert.Equal(t, 1, expired)
+
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			subscription := &model.Subscription{}
+			assert.NoError(t, model.DB.First(subscription, subscriptions[i].Id).Error)
+			assert.Equal(t, tt.wantStatus, subscription.Status)
+			group, err := model.GetUserGroup(subscription.UserId, true)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.wantGroup, group)
+		})
+	}
+
+	// 到期的订阅都已处理，再次运行没有需要处理的订阅
+	renewed, expired, err = service.ProcessDueSubscriptions()
+	assert.NoError(t, err)
+	assert.Equal(t, 0, renewed+expired)
+}
+
+func TestSubscriptionClaimConflict(t *testing.T) {
+	setupTestDB(t)
+	plan := createSubscriptionPlan(t, "monthly", "vip")
+	replacement := createSubscriptionPlan(t, "yearly", "svip")
+
+	tests := []struct {
+		name    string
+		prepare func(user *model.User, due *model.Subscription) // 续订任务读取到期订阅后发生的修改
+		process func(due *model.Subscription) error
+	}{
+		{
+			name: "续订时订阅已被替换",
+			prepare: func(user *model.User, due *model.Subscription) {
+				_, err := model.ActivateSubscription(user.Id, replacement, "")
+				assert.NoError(t, err)
+			},
+			process: func(due *model.Subscription) error { return model.RenewSubscription(due, plan) },
+		},
+		{
+			name: "结束时订阅已被替换",
+			prepare: func(user *model.User, due *model.Subscription) {
+				_, err := model.ActivateSubscription(user.Id, replacement, "")
+				assert.NoError(t, err)
+			},
+			process: func(due *model.Subscription) error { return model.ExpireSubscription(due) },
+		},
+		{
+			name: "订阅已由其他节点续订",
+			prepare: func(user *model.User, due *model.Subscription) {
+				renewed := *due
+				assert.NoError(t, model.RenewSubscription(&renewed, plan))
+			},
+			process: func(due *model.Subscription) error { return model.RenewSubscription(due, plan) },
+		},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			user := &model.User{
+				Username: fmt.Sprintf("claim_user_%d", i),
+				AffCode:  fmt.Sprintf("claim%d", i),
+				Group:    "default",
+			}
+			assert.NoError(t, model.DB.Create(user).Error)
+			subscription, err := model.ActivateSubscription(user.Id, plan, "")
+			assert.NoError(t, err)
+			assert.NoError(t, model.DB.Model(subscription).Update("end_time", common.GetTimestamp()-1).Error)
+			due := &model.Subscription{}
+			assert.NoError(t, model.DB.First(due, subscription.Id).Error)
+
+			tt.prepare(user, due)
+			before := getUserQuota(t, user.Id)
+			group, err := model.GetUserGroup(user.Id, true)
+			assert.NoError(t, err)
+
+			assert.ErrorIs(t, tt.process(due), model.ErrSubscriptionNotActive)
+			// 没有任何修改：只有一个生效的订阅，余额和分组不变
+			var active int64
+			assert.NoError(t, model.DB.Model(&model.Subscription{}).
+				Where("user_id = ? AND status = ?", user.Id, model.SubscriptionStatusActive).Count(&active).Error)
+			assert.Equal(t, int64(1), active)
+			assert.Equal(t, before, getUserQuota(t, user.Id))
+			after, err := model.GetUserGroup(user.Id, true)
+			assert.NoError(t, err)
+			assert.Equal(t, group, after)
+		})
+	}
+}
+
+func TestGetSubscriptionOverageRatio(t *testing.T) {
+	setupTestDB(t)
+	plan := createSubscriptionPlan(t, "overage", "")
+	plan.OverageRatio = 2
+	assert.NoError(t, plan.Update())
+	user := &model.User{Username: "overage_user", AffCode: "overage", Group: "default"}
+	assert.NoError(t, model.DB.Create(user).Error)
+	subscription, err := model.ActivateSubscription(user.Id, plan, "")
+	assert.NoError(t, err)
+
+	steps := []struct {
+		name         string
+		run          func()
+		wantRatio    float64
+		wantExceeded bool
+	}{
+		{name: "包含额度未用完", run: func() {}, wantRatio: 2},
+		{
+			name: "缓存期内不重新查询订阅",
+			run: func() {
+				assert.NoError(t, model.DB.Model(subscription).Update("used_quota", 1000).Error)
+			},
+			wantRatio: 2,
+		},
+		{
+			name: "订阅结束后清除缓存",
+			run: func() {
+				assert.NoError(t, model.ExpireSubscription(subscription))
+			},
+		},
+		{
+			name: "开通新订阅后清除缓存",
+			run: func() {
+				_, err := model.ActivateSubscription(user.Id, plan, "")
+				assert.NoError(t, err)
+			},
+			wantRatio: 2,
+		},
+	}
+	for _, step := range steps {
+		t.Run(step.name, func(t *testing.T) {
+			step.run()
+			ratio, exceeded := model.GetSubscriptionOverageRatio(user.Id)
+			assert.Equal(t, step.wantRatio, ratio)
+			assert.Equal(t, step.wantExceeded, exceeded)
+		})
+	}
+}
@@ -198,6 +198,10 @@ const TopUp = () => {
         if (message === 'success') {
           let params = data;
           let url = res.data.url;
+          if (res.data.method === 'GET') {
+            window.open(url, '_blank');
+            return;
+          }
           let form = document.createElement('form');
           form.action = url;
           form.method = 'POST';